@@ -4,6 +4,8 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -13,10 +15,14 @@ import (
 	"os"
 	"path"
 	"strconv"
+	"time"
 
+	"github.com/filecoin-project/storetheindex/api/v0/admin/model"
 	"github.com/filecoin-project/storetheindex/api/v0/httpclient"
+	"github.com/ipfs/go-cid"
 	"github.com/libp2p/go-libp2p-core/peer"
 	"github.com/multiformats/go-multiaddr"
+	"github.com/multiformats/go-multihash"
 )
 
 const (
@@ -68,6 +74,150 @@ func (c *Client) ImportFromManifest(ctx context.Context, fileName string, provID
 	return nil
 }
 
+// ImportFromManifestDir imports each manifest file in a directory into the
+// indexer. Importing continues past files that fail, and the result for each
+// file is returned.
+func (c *Client) ImportFromManifestDir(ctx context.Context, dirName string, provID peer.ID, contextID, metadata []byte) (*model.ImportDirResult, error) {
+	u := c.baseURL + path.Join(importResource, "manifestdir", provID.String())
+	req, err := c.newUploadRequest(ctx, u, dirName, contextID, metadata)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, httpclient.ReadErrorFrom(resp.StatusCode, resp.Body)
+	}
+
+	var result model.ImportDirResult
+	if err = json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// ImportFromSnapshot asks the indexer to ingest the provider's advertisement
+// chain from a directory of CAR files on the indexer host. If head is
+// cid.Undef, the indexer finds the head of the chain in the CAR files. This
+// waits until the chain is ingested.
+func (c *Client) ImportFromSnapshot(ctx context.Context, dirName string, provID peer.ID, head cid.Cid) (*model.ImportSnapshotResult, error) {
+	u := c.baseURL + path.Join(importResource, "snapshot", provID.String())
+	snapReq := model.ImportSnapshotRequest{
+		Dir: dirName,
+	}
+	if head != cid.Undef {
+		snapReq.Head = head.String()
+	}
+	data, err := json.Marshal(&snapReq)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, httpclient.ReadErrorFrom(resp.StatusCode, resp.Body)
+	}
+
+	var result model.ImportSnapshotResult
+	if err = json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// StartImportJob starts a job in the indexer that imports a file on the
+// indexer host. The job runs after this returns; use ImportJob to poll its
+// progress.
+func (c *Client) StartImportJob(ctx context.Context, jobReq model.ImportJobRequest) (*model.ImportJob, error) {
+	data, err := json.Marshal(&jobReq)
+	if err != nil {
+		return nil, err
+	}
+	return c.importJobRequest(ctx, http.MethodPost, path.Join(importResource, "jobs"), data)
+}
+
+// ImportJob gets the status and progress of an import job.
+func (c *Client) ImportJob(ctx context.Context, id string) (*model.ImportJob, error) {
+	return c.importJobRequest(ctx, http.MethodGet, path.Join(importResource, "jobs", id), nil)
+}
+
+// ResumeImportJob resumes a failed or canceled import job from where it
+// stopped.
+func (c *Client) ResumeImportJob(ctx context.Context, id string) (*model.ImportJob, error) {
+	return c.importJobRequest(ctx, http.MethodPost, path.Join(importResource, "jobs", id, "resume"), nil)
+}
+
+// CancelImportJob stops a running import job. The job can be resumed later.
+func (c *Client) CancelImportJob(ctx context.Context, id string) (*model.ImportJob, error) {
+	return c.importJobRequest(ctx, http.MethodPost, path.Join(importResource, "jobs", id, "cancel"), nil)
+}
+
+// ListImportJobs lists all import jobs, in the order they were started.
+func (c *Client) ListImportJobs(ctx context.Context) ([]model.ImportJob, error) {
+	u := c.baseURL + path.Join(importResource, "jobs")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, httpclient.ReadErrorFrom(resp.StatusCode, resp.Body)
+	}
+
+	var jobs []model.ImportJob
+	if err = json.NewDecoder(resp.Body).Decode(&jobs); err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+func (c *Client) importJobRequest(ctx context.Context, method, resource string, data []byte) (*model.ImportJob, error) {
+	var body io.Reader
+	if data != nil {
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+resource, body)
+	if err != nil {
+		return nil, err
+	}
+	if data != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
+		return nil, httpclient.ReadErrorFrom(resp.StatusCode, resp.Body)
+	}
+
+	var job model.ImportJob
+	if err = json.NewDecoder(resp.Body).Decode(&job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
 // ImportFromCidList process entries from a cidlist and imprts it into the
 // indexer.
 func (c *Client) ImportFromCidList(ctx context.Context, fileName string, provID peer.ID, contextID, metadata []byte) error {
@@ -121,6 +271,26 @@ func (c *Client) Sync(ctx context.Context, peerID peer.ID, peerAddr multiaddr.Mu
 	return c.ingestRequest(ctx, peerID, "sync", http.MethodPost, data, q...)
 }
 
+// SyncSince syncs with a data peer, re-ingesting the advertisements that the
+// indexer first processed at or after since. The depth limit is used when the
+// indexer does not know when advertisements were processed.
+func (c *Client) SyncSince(ctx context.Context, peerID peer.ID, peerAddr multiaddr.Multiaddr, since time.Time, depth int64) error {
+	var data []byte
+	var err error
+	if peerAddr != nil {
+		data, err = peerAddr.MarshalJSON()
+		if err != nil {
+			return err
+		}
+	}
+
+	q := []string{"since", since.Format(time.RFC3339)}
+	if depth != 0 {
+		q = append(q, "depth", strconv.FormatInt(depth, 10))
+	}
+	return c.ingestRequest(ctx, peerID, "sync", http.MethodPost, data, q...)
+}
+
 // ImportProviders
 func (c *Client) ImportProviders(ctx context.Context, fromURL *url.URL) error {
 	if fromURL == nil || fromURL.String() == "" {
@@ -183,6 +353,29 @@ func (c *Client) ReloadConfig(ctx context.Context) error {
 	return nil
 }
 
+// Flush writes any pending changes in the indexer's value store to storage,
+// and returns once the changes are durable.
+func (c *Client) Flush(ctx context.Context) error {
+	u := c.baseURL + "/flush"
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return httpclient.ReadErrorFrom(resp.StatusCode, resp.Body)
+	}
+
+	return nil
+}
+
 // Allow configures the indexer to allow the peer to publish messages and
 // provide content.
 func (c *Client) Allow(ctx context.Context, peerID peer.ID) error {
@@ -195,6 +388,471 @@ func (c *Client) Block(ctx context.Context, peerID peer.ID) error {
 	return c.ingestRequest(ctx, peerID, "block", http.MethodPut, nil)
 }
 
+// SubscribeBatch subscribes the indexer to each of the peers, by allowing
+// them to publish messages and provide content. A result is returned for
+// each peer, in the same order as peerIDs.
+func (c *Client) SubscribeBatch(ctx context.Context, peerIDs []peer.ID) ([]model.SubscribeResult, error) {
+	return c.batchRequest(ctx, "/subscribe/batch", peerIDs)
+}
+
+// UnsubscribeBatch unsubscribes the indexer from each of the peers, by
+// blocking them from publishing messages and providing content. A result is
+// returned for each peer, in the same order as peerIDs.
+func (c *Client) UnsubscribeBatch(ctx context.Context, peerIDs []peer.ID) ([]model.SubscribeResult, error) {
+	return c.batchRequest(ctx, "/unsubscribe/batch", peerIDs)
+}
+
+func (c *Client) batchRequest(ctx context.Context, resource string, peerIDs []peer.ID) ([]model.SubscribeResult, error) {
+	ids := make([]string, len(peerIDs))
+	for i, peerID := range peerIDs {
+		ids[i] = peerID.String()
+	}
+	data, err := json.Marshal(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+resource, bytes.NewBuffer(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, httpclient.ReadErrorFrom(resp.StatusCode, resp.Body)
+	}
+
+	var results []model.SubscribeResult
+	if err = json.NewDecoder(resp.Body).Decode(&results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// SyncState gets the latest sync for each publisher, as seen by the indexer's
+// subscriber and as persisted by the indexer.
+func (c *Client) SyncState(ctx context.Context) ([]model.SyncState, error) {
+	u := c.baseURL + path.Join(ingestResource, "syncstate")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, httpclient.ReadErrorFrom(resp.StatusCode, resp.Body)
+	}
+
+	var states []model.SyncState
+	if err = json.NewDecoder(resp.Body).Decode(&states); err != nil {
+		return nil, err
+	}
+	return states, nil
+}
+
+// InFlightSyncs gets the syncs that the indexer has started, as requested by
+// Sync, and that have not yet finished.
+func (c *Client) InFlightSyncs(ctx context.Context) ([]model.InFlightSync, error) {
+	u := c.baseURL + path.Join(ingestResource, "syncs")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, httpclient.ReadErrorFrom(resp.StatusCode, resp.Body)
+	}
+
+	var syncs []model.InFlightSync
+	if err = json.NewDecoder(resp.Body).Decode(&syncs); err != nil {
+		return nil, err
+	}
+	return syncs, nil
+}
+
+// CancelSync cancels the in-flight sync with the given ID, as listed by
+// InFlightSyncs.
+func (c *Client) CancelSync(ctx context.Context, id uint64) error {
+	u := c.baseURL + path.Join(ingestResource, "syncs", strconv.FormatUint(id, 10))
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return httpclient.ReadErrorFrom(resp.StatusCode, resp.Body)
+	}
+	return nil
+}
+
+// RetryFailures queues the advertisements that the indexer failed to ingest
+// to be ingested again. If providerID is not empty, then only the
+// advertisements of that provider are retried.
+func (c *Client) RetryFailures(ctx context.Context, providerID peer.ID) (*model.FailureRetry, error) {
+	u := c.baseURL + path.Join(ingestResource, "failures", "retry")
+	if providerID != "" {
+		u += "?" + url.Values{"provider": {providerID.String()}}.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, httpclient.ReadErrorFrom(resp.StatusCode, resp.Body)
+	}
+
+	var summary model.FailureRetry
+	if err = json.NewDecoder(resp.Body).Decode(&summary); err != nil {
+		return nil, err
+	}
+	return &summary, nil
+}
+
+// ClearSync removes the latest sync the indexer has recorded for the
+// provider's publisher, so that the next sync traverses the publisher's entire
+// advertisement chain. Depending on the length of the chain, the next sync
+// may take a long time.
+func (c *Client) ClearSync(ctx context.Context, providerID peer.ID) error {
+	u := c.baseURL + path.Join("/providers", providerID.String(), "sync")
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return httpclient.ReadErrorFrom(resp.StatusCode, resp.Body)
+	}
+	return nil
+}
+
+// ProviderEntryCount returns the number of multihashes that the indexer holds
+// for the provider.
+func (c *Client) ProviderEntryCount(ctx context.Context, providerID peer.ID) (uint64, error) {
+	u := c.baseURL + path.Join("/providers", providerID.String(), "count")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := c.c.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, httpclient.ReadErrorFrom(resp.StatusCode, resp.Body)
+	}
+
+	var count model.ProviderEntryCount
+	if err = json.NewDecoder(resp.Body).Decode(&count); err != nil {
+		return 0, err
+	}
+	return count.EntryCount, nil
+}
+
+// SyncHistory returns the summaries of the provider's most recent syncs,
+// newest first.
+func (c *Client) SyncHistory(ctx context.Context, providerID peer.ID) ([]model.SyncSummary, error) {
+	u := c.baseURL + path.Join("/providers", providerID.String(), "history")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, httpclient.ReadErrorFrom(resp.StatusCode, resp.Body)
+	}
+
+	var history []model.SyncSummary
+	if err = json.NewDecoder(resp.Body).Decode(&history); err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+// SetMetadataOverride sets metadata that the indexer returns in find results
+// for the provider's context, instead of the metadata that the provider
+// advertised. The override is removed if the provider advertises different
+// metadata for the context.
+func (c *Client) SetMetadataOverride(ctx context.Context, providerID peer.ID, contextID, metadata []byte) error {
+	return c.metadataOverride(ctx, http.MethodPut, providerID, contextID, metadata)
+}
+
+// ClearMetadataOverride removes the metadata override for the provider's
+// context.
+func (c *Client) ClearMetadataOverride(ctx context.Context, providerID peer.ID, contextID []byte) error {
+	return c.metadataOverride(ctx, http.MethodDelete, providerID, contextID, nil)
+}
+
+func (c *Client) metadataOverride(ctx context.Context, method string, providerID peer.ID, contextID, metadata []byte) error {
+	u := c.baseURL + path.Join("/providers", providerID.String(), "contexts", base64.RawURLEncoding.EncodeToString(contextID), "metadata")
+	req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewBuffer(metadata))
+	if err != nil {
+		return err
+	}
+	resp, err := c.c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return httpclient.ReadErrorFrom(resp.StatusCode, resp.Body)
+	}
+	return nil
+}
+
+// SetAlias makes the indexer attribute advertisements that name alias as their
+// provider to the provider with the given ID. Both peers must be allowed by
+// the indexer's policy.
+func (c *Client) SetAlias(ctx context.Context, alias, providerID peer.ID) error {
+	u := c.baseURL + path.Join("/providers", providerID.String(), "aliases", alias.String())
+	return c.emptyRequest(ctx, http.MethodPut, u)
+}
+
+// RemoveAlias removes the alias, so that advertisements naming alias as their
+// provider are attributed to alias again.
+func (c *Client) RemoveAlias(ctx context.Context, alias peer.ID) error {
+	u := c.baseURL + path.Join("/aliases", alias.String())
+	return c.emptyRequest(ctx, http.MethodDelete, u)
+}
+
+// emptyRequest sends a request that has no body and expects no response body.
+func (c *Client) emptyRequest(ctx context.Context, method, u string) error {
+	req, err := http.NewRequestWithContext(ctx, method, u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return httpclient.ReadErrorFrom(resp.StatusCode, resp.Body)
+	}
+	return nil
+}
+
+// ListAliases gets all provider aliases configured on the indexer.
+func (c *Client) ListAliases(ctx context.Context) ([]model.ProviderAlias, error) {
+	u := c.baseURL + "/aliases"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, httpclient.ReadErrorFrom(resp.StatusCode, resp.Body)
+	}
+
+	var aliases []model.ProviderAlias
+	if err = json.NewDecoder(resp.Body).Decode(&aliases); err != nil {
+		return nil, err
+	}
+	return aliases, nil
+}
+
+// SetProviderTags replaces the tags of a registered provider. Giving no tags
+// removes all of the provider's tags.
+func (c *Client) SetProviderTags(ctx context.Context, providerID peer.ID, tags []string) error {
+	if tags == nil {
+		tags = []string{}
+	}
+	data, err := json.Marshal(tags)
+	if err != nil {
+		return err
+	}
+	u := c.baseURL + path.Join("/providers", providerID.String(), "tags")
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return httpclient.ReadErrorFrom(resp.StatusCode, resp.Body)
+	}
+	return nil
+}
+
+// GetProviderTags gets the tags of a registered provider.
+func (c *Client) GetProviderTags(ctx context.Context, providerID peer.ID) ([]string, error) {
+	u := c.baseURL + path.Join("/providers", providerID.String(), "tags")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, httpclient.ReadErrorFrom(resp.StatusCode, resp.Body)
+	}
+
+	var tags []string
+	if err = json.NewDecoder(resp.Body).Decode(&tags); err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
+
+// BlockMultihash adds the multihash to the indexer's blocklist, so that it is
+// not returned in find results or indexed.
+func (c *Client) BlockMultihash(ctx context.Context, mh multihash.Multihash) error {
+	u := c.baseURL + path.Join("/blocklist", mh.B58String())
+	return c.emptyRequest(ctx, http.MethodPut, u)
+}
+
+// UnblockMultihash removes the multihash from the indexer's blocklist.
+func (c *Client) UnblockMultihash(ctx context.Context, mh multihash.Multihash) error {
+	u := c.baseURL + path.Join("/blocklist", mh.B58String())
+	return c.emptyRequest(ctx, http.MethodDelete, u)
+}
+
+// ListBlockedMultihashes gets all multihashes on the indexer's blocklist.
+func (c *Client) ListBlockedMultihashes(ctx context.Context) ([]multihash.Multihash, error) {
+	u := c.baseURL + "/blocklist"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, httpclient.ReadErrorFrom(resp.StatusCode, resp.Body)
+	}
+
+	var mhs []multihash.Multihash
+	if err = json.NewDecoder(resp.Body).Decode(&mhs); err != nil {
+		return nil, err
+	}
+	return mhs, nil
+}
+
+// MultihashesWithPrefix gets the indexed multihashes whose bytes begin with
+// prefix, and the providers they are indexed for, up to limit multihashes. A
+// limit of zero uses the indexer's default limit. Finding the multihashes
+// requires the indexer to read its whole value store, so this can take a long
+// time for a large index.
+func (c *Client) MultihashesWithPrefix(ctx context.Context, prefix []byte, limit int) (*model.PrefixScan, error) {
+	u := c.baseURL + "/multihashes"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	q := url.Values{}
+	q.Add("prefix", hex.EncodeToString(prefix))
+	if limit != 0 {
+		q.Add("limit", strconv.Itoa(limit))
+	}
+	req.URL.RawQuery = q.Encode()
+
+	resp, err := c.c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, httpclient.ReadErrorFrom(resp.StatusCode, resp.Body)
+	}
+
+	var scan model.PrefixScan
+	if err = json.NewDecoder(resp.Body).Decode(&scan); err != nil {
+		return nil, err
+	}
+	return &scan, nil
+}
+
+// AuditLog gets the audit entries, recorded for admin requests that may change
+// the indexer's state, with a time that is not before start and is before end.
+// A zero start or end leaves that end of the time range open.
+func (c *Client) AuditLog(ctx context.Context, start, end time.Time) ([]model.AuditEntry, error) {
+	u := c.baseURL + "/audit"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	q := url.Values{}
+	if !start.IsZero() {
+		q.Set("start", start.Format(time.RFC3339))
+	}
+	if !end.IsZero() {
+		q.Set("end", end.Format(time.RFC3339))
+	}
+	req.URL.RawQuery = q.Encode()
+
+	resp, err := c.c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, httpclient.ReadErrorFrom(resp.StatusCode, resp.Body)
+	}
+
+	var entries []model.AuditEntry
+	if err = json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
 func (c *Client) ListLogSubSystems(ctx context.Context) ([]string, error) {
 	u := c.baseURL + "/config/log/subsystems"
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
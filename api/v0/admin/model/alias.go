@@ -0,0 +1,15 @@
+package model
+
+import (
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// ProviderAlias maps a peer ID that a provider publishes advertisements under
+// to the provider's canonical ID.
+type ProviderAlias struct {
+	// Alias is the peer ID that advertisements name as their provider.
+	Alias peer.ID
+	// ProviderID is the canonical ID of the provider that the advertisements
+	// are attributed to.
+	ProviderID peer.ID
+}
@@ -0,0 +1,91 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/record"
+)
+
+// AllowList is a list of peers that an indexer allows, in addition to those
+// allowed by its configured policy. It is distributed as a signed envelope so
+// that indexers can verify that it came from the expected signer.
+type AllowList struct {
+	// Peers is the list of allowed peer IDs.
+	Peers []peer.ID
+	// Seq orders the lists published by a signer. An indexer ignores a list
+	// with a lower Seq than one it has already applied.
+	Seq uint64
+}
+
+// AllowListEnvelopeDomain is the domain string used for allowlists contained
+// in a Envelope.
+const AllowListEnvelopeDomain = "indexer-allowlist-record"
+
+// AllowListEnvelopePayloadType is the type hint used to identify AllowList
+// records in a Envelope.
+var AllowListEnvelopePayloadType = []byte("indexer-allowlist")
+
+func init() {
+	record.RegisterType(&AllowList{})
+}
+
+// Domain is used when signing and validating AllowList records contained in
+// Envelopes
+func (a *AllowList) Domain() string {
+	return AllowListEnvelopeDomain
+}
+
+// Codec is a binary identifier for the AllowList type
+func (a *AllowList) Codec() []byte {
+	return AllowListEnvelopePayloadType
+}
+
+// UnmarshalRecord parses an AllowList from a byte slice
+func (a *AllowList) UnmarshalRecord(data []byte) error {
+	if a == nil {
+		return fmt.Errorf("cannot unmarshal AllowList to nil receiver")
+	}
+
+	return json.Unmarshal(data, a)
+}
+
+// MarshalRecord serializes an AllowList to a byte slice.
+func (a *AllowList) MarshalRecord() ([]byte, error) {
+	return json.Marshal(a)
+}
+
+// MakeAllowList creates an AllowList signed by the private key and marshals
+// it into bytes.
+func MakeAllowList(peers []peer.ID, privateKey crypto.PrivKey) ([]byte, error) {
+	allowList := &AllowList{
+		Peers: peers,
+		Seq:   peer.TimestampSeq(),
+	}
+
+	env, err := record.Seal(allowList, privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("could not sign allowlist: %s", err)
+	}
+	return env.Marshal()
+}
+
+// ReadAllowList unmarshals an AllowList from bytes, verifies the signature,
+// and returns the AllowList and the ID of the peer that signed it.
+func ReadAllowList(data []byte) (*AllowList, peer.ID, error) {
+	env, untypedRecord, err := record.ConsumeEnvelope(data, AllowListEnvelopeDomain)
+	if err != nil {
+		return nil, "", fmt.Errorf("cannot consume allowlist envelope: %s", err)
+	}
+	allowList, ok := untypedRecord.(*AllowList)
+	if !ok {
+		return nil, "", fmt.Errorf("unmarshaled record is not a *AllowList")
+	}
+	signerID, err := peer.IDFromPublicKey(env.PublicKey)
+	if err != nil {
+		return nil, "", fmt.Errorf("cannot get allowlist signer id: %s", err)
+	}
+	return allowList, signerID, nil
+}
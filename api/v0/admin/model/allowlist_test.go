@@ -0,0 +1,52 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/test"
+)
+
+func TestAllowList(t *testing.T) {
+	privKey, pubKey, err := test.RandTestKeyPair(crypto.Ed25519, 256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signerID, err := peer.IDFromPublicKey(pubKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	peers := make([]peer.ID, 3)
+	for i := range peers {
+		peers[i], err = test.RandPeerID()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, err := MakeAllowList(peers, privKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	allowList, readSigner, err := ReadAllowList(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if readSigner != signerID {
+		t.Error("wrong signer id")
+	}
+	if len(allowList.Peers) != len(peers) {
+		t.Fatal("wrong number of peers")
+	}
+	for i := range peers {
+		if allowList.Peers[i] != peers[i] {
+			t.Error("wrong peer id")
+		}
+	}
+	if allowList.Seq == 0 {
+		t.Error("sequence not set")
+	}
+}
@@ -0,0 +1,26 @@
+package model
+
+import (
+	"time"
+)
+
+// AuditEntry records an admin request that may have changed the indexer's
+// state.
+type AuditEntry struct {
+	// Time is when the request was handled.
+	Time time.Time
+	// Actor identifies who made the request. This is the subject of the
+	// client certificate when client certificates are required, "token" when
+	// only a bearer token is required, and empty when the admin server does
+	// not authenticate requests.
+	Actor string `json:",omitempty"`
+	// Remote is the network address that the request came from.
+	Remote string
+	// Action is the request method and route, such as
+	// "PUT /blocklist/{multihash}".
+	Action string
+	// Params holds the route variables and query parameters of the request.
+	Params map[string]string `json:",omitempty"`
+	// Status is the HTTP status of the response.
+	Status int
+}
@@ -0,0 +1,19 @@
+package model
+
+import (
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// ProviderEntryCount is the number of multihashes that an indexer holds for a
+// provider.
+type ProviderEntryCount struct {
+	// ProviderID is the ID of the provider.
+	ProviderID peer.ID
+	// EntryCount is the number of multihashes indexed for the provider,
+	// totalled over all of the provider's contexts.
+	EntryCount uint64
+	// InvalidEntryCount is the number of advertised entries from the
+	// provider that were not indexed because they are not valid multihashes,
+	// since the indexer started.
+	InvalidEntryCount uint64 `json:",omitempty"`
+}
@@ -0,0 +1,13 @@
+package model
+
+// FailureRetry summarizes a retry of the advertisements that an indexer
+// failed to ingest.
+type FailureRetry struct {
+	// Requeued is the number of failed advertisements that were queued to be
+	// ingested again.
+	Requeued int
+	// Skipped is the number of failed advertisements that could not be
+	// queued, because they could not be loaded or fetched from their
+	// publisher.
+	Skipped int
+}
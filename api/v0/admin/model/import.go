@@ -0,0 +1,108 @@
+package model
+
+import (
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// ImportFileResult is the result of importing a single file.
+type ImportFileResult struct {
+	// File is the path of the imported file.
+	File string
+	// Imported is the number of CIDs imported from the file.
+	Imported int
+	// Error describes why importing the file failed, and is empty if the
+	// file was imported successfully. CIDs may have been imported from a file
+	// that failed.
+	Error string `json:",omitempty"`
+}
+
+// ImportDirResult is the result of importing all the files in a directory.
+type ImportDirResult struct {
+	// Files holds the result for each file in the directory, in the order the
+	// files were imported.
+	Files []ImportFileResult
+	// TotalImported is the number of CIDs imported from all files.
+	TotalImported int
+	// Failed is the number of files that failed to import.
+	Failed int
+}
+
+// ImportSnapshotRequest asks the indexer to ingest a provider's advertisement
+// chain from a directory of CAR files on the indexer host.
+type ImportSnapshotRequest struct {
+	// Dir is the directory, on the indexer host, that holds the CAR files.
+	Dir string
+	// Head is the CID of the latest advertisement in the chain. If empty,
+	// the head is the only advertisement that no other advertisement in the
+	// CAR files links to.
+	Head string `json:",omitempty"`
+}
+
+// ImportSnapshotResult is the result of ingesting a snapshot.
+type ImportSnapshotResult struct {
+	// Files is the number of CAR files read.
+	Files int
+	// Blocks is the number of blocks read from all CAR files.
+	Blocks int
+	// LatestProcessed is the CID of the latest advertisement processed. It is
+	// the head of the chain if the whole chain was ingested.
+	LatestProcessed string
+}
+
+// Kinds of file imported by an import job.
+const (
+	// ImportJobManifest imports the CIDs in a CID aggregator manifest.
+	ImportJobManifest = "manifest"
+	// ImportJobCidList imports a file with one CID per line.
+	ImportJobCidList = "cidlist"
+)
+
+// Import job statuses.
+const (
+	// ImportJobRunning is the status of a job that is importing. A running
+	// job is resumed when the indexer restarts.
+	ImportJobRunning = "running"
+	// ImportJobDone is the status of a job that imported the whole file.
+	ImportJobDone = "done"
+	// ImportJobFailed is the status of a job that stopped because of an
+	// error. A failed job can be resumed.
+	ImportJobFailed = "failed"
+	// ImportJobCanceled is the status of a job that was canceled. A canceled
+	// job can be resumed.
+	ImportJobCanceled = "canceled"
+)
+
+// ImportJobRequest asks the indexer to start a job that imports a file on the
+// indexer host. The job runs in the indexer after the request returns.
+type ImportJobRequest struct {
+	// Kind is the kind of file: ImportJobManifest or ImportJobCidList.
+	Kind string
+	// Provider is the provider that the imported CIDs are indexed for.
+	Provider peer.ID
+	// File is the path, on the indexer host, of the file to import.
+	File string
+	// ContextID is the context ID that the CIDs are indexed with.
+	ContextID []byte
+	// Metadata is the metadata that the CIDs are indexed with.
+	Metadata []byte
+}
+
+// ImportJob describes an import job and its progress.
+type ImportJob struct {
+	ImportJobRequest
+	// ID identifies the job in requests to get, resume, or cancel the job.
+	ID string
+	// Status is the job's status, which is one of the ImportJob statuses.
+	Status string
+	// Imported is the number of CIDs imported so far. A resumed job continues
+	// after this many CIDs.
+	Imported int
+	// Error describes why the job failed, if Status is ImportJobFailed.
+	Error string `json:",omitempty"`
+	// Started is the time that the job was first started.
+	Started time.Time
+	// Updated is the time that the job last made progress or changed status.
+	Updated time.Time
+}
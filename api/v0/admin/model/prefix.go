@@ -0,0 +1,30 @@
+package model
+
+import (
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/multiformats/go-multihash"
+)
+
+// PrefixScan is the result of finding the indexed multihashes that begin with
+// a prefix.
+type PrefixScan struct {
+	// Multihashes are the multihashes found that begin with the prefix.
+	Multihashes []PrefixMultihash
+	// Truncated is true if the scan stopped at the result limit, so there may
+	// be more multihashes that begin with the prefix.
+	Truncated bool
+}
+
+// PrefixMultihash is a multihash found by a prefix scan, and the providers
+// and contexts it is indexed under.
+type PrefixMultihash struct {
+	Multihash multihash.Multihash
+	Values    []PrefixValue
+}
+
+// PrefixValue identifies a provider context that a multihash is indexed
+// under.
+type PrefixValue struct {
+	ProviderID peer.ID
+	ContextID  []byte
+}
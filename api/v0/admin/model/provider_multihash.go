@@ -0,0 +1,10 @@
+package model
+
+import "github.com/multiformats/go-multihash"
+
+// ProviderMultihash is a multihash that is indexed for a provider, and the
+// context ID that the provider advertised it under.
+type ProviderMultihash struct {
+	Multihash multihash.Multihash
+	ContextID []byte
+}
@@ -0,0 +1,14 @@
+package model
+
+// SubscribeResult is the result of subscribing or unsubscribing one peer in a
+// batch request.
+type SubscribeResult struct {
+	// PeerID is the peer ID as given in the request.
+	PeerID string
+	// Changed is true if the request changed whether the indexer is
+	// subscribed to the peer, and false if it was already in that state.
+	Changed bool `json:",omitempty"`
+	// Err describes why the request failed for this peer. It is empty if
+	// the request succeeded.
+	Err string `json:",omitempty"`
+}
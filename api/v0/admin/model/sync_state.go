@@ -0,0 +1,71 @@
+package model
+
+import (
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// SyncState compares the latest sync for a publisher as seen by the go-legs
+// subscriber with the latest sync persisted by the indexer.
+type SyncState struct {
+	// PeerID is the ID of the publisher.
+	PeerID peer.ID
+	// SubscriberSync is the advertisement that the subscriber last reported
+	// as synced. This is only held in memory, and is undefined if there has
+	// been no sync with the publisher since the indexer started.
+	SubscriberSync cid.Cid
+	// PersistedSync is the latest processed advertisement stored in the
+	// indexer's datastore. This is where the next sync with the publisher
+	// stops.
+	PersistedSync cid.Cid
+	// Mismatch is true if both the subscriber sync and persisted sync are
+	// defined and are different. This is expected while advertisements are
+	// being processed, but otherwise means that advertisements may have been
+	// skipped.
+	Mismatch bool
+}
+
+// InFlightSync describes a sync, started by a sync request, that has not
+// finished.
+type InFlightSync struct {
+	// ID identifies the sync, to cancel it.
+	ID uint64
+	// PeerID is the ID of the publisher being synced.
+	PeerID peer.ID
+	// Depth is the advertisement depth limit requested for the sync.
+	Depth int
+	// Resync is true if the sync does not stop at the latest processed
+	// advertisement.
+	Resync bool
+	// Started is when the sync started.
+	Started time.Time
+	// Stage is "syncing" while advertisements are fetched from the publisher,
+	// and "processing" while the fetched advertisements are ingested.
+	Stage string
+	// AdsProcessed is the number of advertisements from the publisher that
+	// have been processed during the sync.
+	AdsProcessed int
+}
+
+// SyncSummary summarizes one run of ingesting a provider's synced
+// advertisements.
+type SyncSummary struct {
+	// HeadAdCid is the latest advertisement in the synced chain.
+	HeadAdCid cid.Cid
+	// Publisher is the ID of the publisher the advertisements were synced
+	// from.
+	Publisher peer.ID
+	// Time is when ingestion of the advertisements finished.
+	Time time.Time
+	// AdsProcessed is the number of advertisements that were processed,
+	// including those skipped because of a permanent error.
+	AdsProcessed int
+	// MultihashesAdded is the number of multihashes indexed from the
+	// advertisements' entries.
+	MultihashesAdded uint64
+	// Err describes the error that stopped ingestion before all of the
+	// advertisements were processed. It is empty if there was no error.
+	Err string `json:",omitempty"`
+}
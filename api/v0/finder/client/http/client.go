@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 
 	"github.com/filecoin-project/storetheindex/api/v0/finder/model"
 	"github.com/filecoin-project/storetheindex/api/v0/httpclient"
@@ -18,6 +19,7 @@ const (
 	finderPath    = "/multihash"
 	providersPath = "/providers"
 	statsPath     = "/stats"
+	infoPath      = "/info"
 )
 
 // Client is an http client for the indexer finder API
@@ -26,6 +28,7 @@ type Client struct {
 	finderURL    string
 	providersURL string
 	statsURL     string
+	infoURL      string
 }
 
 // New creates a new finder HTTP client.
@@ -40,6 +43,7 @@ func New(baseURL string, options ...httpclient.Option) (*Client, error) {
 		finderURL:    baseURL + finderPath,
 		providersURL: baseURL + providersPath,
 		statsURL:     baseURL + statsPath,
+		infoURL:      baseURL + infoPath,
 	}, nil
 }
 
@@ -71,7 +75,16 @@ func (c *Client) FindBatch(ctx context.Context, mhs []multihash.Multihash) (*mod
 }
 
 func (c *Client) ListProviders(ctx context.Context) ([]*model.ProviderInfo, error) {
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.providersURL, nil)
+	return c.ListProvidersWithTags(ctx)
+}
+
+// ListProvidersWithTags lists the providers that have all of the given tags.
+func (c *Client) ListProvidersWithTags(ctx context.Context, tags ...string) ([]*model.ProviderInfo, error) {
+	u := c.providersURL
+	if len(tags) != 0 {
+		u += "?" + url.Values{"tag": tags}.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -132,6 +145,39 @@ func (c *Client) GetProvider(ctx context.Context, providerID peer.ID) (*model.Pr
 	return &providerInfo, nil
 }
 
+// GetProviderContexts gets the context IDs that the provider has advertised,
+// along with their current metadata and entry count.
+func (c *Client) GetProviderContexts(ctx context.Context, providerID peer.ID) ([]model.ProviderContext, error) {
+	u := fmt.Sprint(c.providersURL, "/", providerID.String(), "/contexts")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Accept", "application/json")
+
+	resp, err := c.c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, httpclient.ReadError(resp.StatusCode, body)
+	}
+
+	var contexts []model.ProviderContext
+	err = json.Unmarshal(body, &contexts)
+	if err != nil {
+		return nil, err
+	}
+	return contexts, nil
+}
+
 func (c *Client) GetStats(ctx context.Context) (*model.Stats, error) {
 	u := fmt.Sprint(c.statsURL)
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
@@ -158,8 +204,40 @@ func (c *Client) GetStats(ctx context.Context) (*model.Stats, error) {
 	return model.UnmarshalStats(body)
 }
 
+// GetInfo gets the indexer's identity, version, and the protocols and
+// features that it supports.
+func (c *Client) GetInfo(ctx context.Context) (*model.Info, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.infoURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Accept", "application/json")
+
+	resp, err := c.c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, httpclient.ReadError(resp.StatusCode, body)
+	}
+
+	return model.UnmarshalInfo(body)
+}
+
+// sendRequest sends a find request and decodes the response. The more compact
+// DAG-CBOR encoding is requested, and the response is decoded according to
+// its content type, so JSON responses from indexers that do not support
+// DAG-CBOR are also handled.
 func (c *Client) sendRequest(req *http.Request) (*model.FindResponse, error) {
 	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", model.CBORMediaType+", application/json")
 	resp, err := c.c.Do(req)
 	if err != nil {
 		return nil, err
@@ -178,5 +256,8 @@ func (c *Client) sendRequest(req *http.Request) (*model.FindResponse, error) {
 		return nil, err
 	}
 
+	if resp.Header.Get("Content-Type") == model.CBORMediaType {
+		return model.UnmarshalFindResponseCBOR(b)
+	}
 	return model.UnmarshalFindResponse(b)
 }
@@ -0,0 +1,234 @@
+package model
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/ipld/go-ipld-prime/codec/dagcbor"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/fluent/qp"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/multiformats/go-multihash"
+)
+
+// CBORMediaType is the media type of a find response encoded as DAG-CBOR.
+const CBORMediaType = "application/cbor"
+
+// MarshalFindResponseCBOR serializes a find response as DAG-CBOR. This has the
+// same structure as the JSON encoding, but multihashes, peer IDs, and
+// multiaddrs are encoded as their binary form, and byte values are not base64
+// encoded. This makes it more compact than JSON.
+func MarshalFindResponseCBOR(r *FindResponse) ([]byte, error) {
+	node, err := qp.BuildMap(basicnode.Prototype.Map, 1, func(ma datamodel.MapAssembler) {
+		qp.MapEntry(ma, "MultihashResults", qp.List(int64(len(r.MultihashResults)), func(la datamodel.ListAssembler) {
+			for i := range r.MultihashResults {
+				qp.ListEntry(la, multihashResultAssemble(&r.MultihashResults[i]))
+			}
+		}))
+	})
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err = dagcbor.Encode(node, &buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func multihashResultAssemble(mhr *MultihashResult) qp.Assemble {
+	return qp.Map(-1, func(ma datamodel.MapAssembler) {
+		qp.MapEntry(ma, "Multihash", qp.Bytes(mhr.Multihash))
+		qp.MapEntry(ma, "ProviderResults", qp.List(int64(len(mhr.ProviderResults)), func(la datamodel.ListAssembler) {
+			for i := range mhr.ProviderResults {
+				qp.ListEntry(la, providerResultAssemble(&mhr.ProviderResults[i]))
+			}
+		}))
+		if mhr.Error != "" {
+			qp.MapEntry(ma, "Error", qp.String(mhr.Error))
+		}
+	})
+}
+
+func providerResultAssemble(pr *ProviderResult) qp.Assemble {
+	return qp.Map(-1, func(ma datamodel.MapAssembler) {
+		qp.MapEntry(ma, "ContextID", qp.Bytes(pr.ContextID))
+		qp.MapEntry(ma, "Metadata", qp.Bytes(pr.Metadata))
+		qp.MapEntry(ma, "Provider", qp.Map(2, func(ma datamodel.MapAssembler) {
+			qp.MapEntry(ma, "ID", qp.Bytes([]byte(pr.Provider.ID)))
+			qp.MapEntry(ma, "Addrs", qp.List(int64(len(pr.Provider.Addrs)), func(la datamodel.ListAssembler) {
+				for _, addr := range pr.Provider.Addrs {
+					qp.ListEntry(la, qp.Bytes(addr.Bytes()))
+				}
+			}))
+		}))
+		// Optional fields are omitted when empty, as in the JSON encoding.
+		if pr.Timestamp != "" {
+			qp.MapEntry(ma, "Timestamp", qp.String(pr.Timestamp))
+		}
+		if pr.MetadataOverride {
+			qp.MapEntry(ma, "MetadataOverride", qp.Bool(true))
+		}
+		if pr.Status != "" {
+			qp.MapEntry(ma, "Status", qp.String(pr.Status))
+		}
+		if pr.ContextLastAdvertisementCid != "" {
+			qp.MapEntry(ma, "ContextLastAdvertisementCid", qp.String(pr.ContextLastAdvertisementCid))
+		}
+	})
+}
+
+// UnmarshalFindResponseCBOR de-serializes a find response encoded by
+// MarshalFindResponseCBOR.
+func UnmarshalFindResponseCBOR(b []byte) (*FindResponse, error) {
+	nb := basicnode.Prototype.Any.NewBuilder()
+	if err := dagcbor.Decode(nb, bytes.NewReader(b)); err != nil {
+		return nil, err
+	}
+	node := nb.Build()
+
+	mhrsNode, err := lookupKind(node, "MultihashResults", datamodel.Kind_List)
+	if err != nil {
+		return nil, err
+	}
+	r := &FindResponse{
+		MultihashResults: make([]MultihashResult, 0, mhrsNode.Length()),
+	}
+	for it := mhrsNode.ListIterator(); !it.Done(); {
+		_, mhrNode, err := it.Next()
+		if err != nil {
+			return nil, err
+		}
+		mhr, err := decodeMultihashResult(mhrNode)
+		if err != nil {
+			return nil, err
+		}
+		r.MultihashResults = append(r.MultihashResults, mhr)
+	}
+	return r, nil
+}
+
+func decodeMultihashResult(node datamodel.Node) (MultihashResult, error) {
+	var mhr MultihashResult
+	mhBytes, err := lookupBytes(node, "Multihash")
+	if err != nil {
+		return mhr, err
+	}
+	if _, mhr.Multihash, err = multihash.MHFromBytes(mhBytes); err != nil {
+		return mhr, fmt.Errorf("bad multihash: %w", err)
+	}
+
+	prsNode, err := lookupKind(node, "ProviderResults", datamodel.Kind_List)
+	if err != nil {
+		return mhr, err
+	}
+	mhr.ProviderResults = make([]ProviderResult, 0, prsNode.Length())
+	for it := prsNode.ListIterator(); !it.Done(); {
+		_, prNode, err := it.Next()
+		if err != nil {
+			return mhr, err
+		}
+		pr, err := decodeProviderResult(prNode)
+		if err != nil {
+			return mhr, err
+		}
+		mhr.ProviderResults = append(mhr.ProviderResults, pr)
+	}
+	if n, err := node.LookupByString("Error"); err == nil {
+		if mhr.Error, err = n.AsString(); err != nil {
+			return mhr, fmt.Errorf("bad Error: %w", err)
+		}
+	}
+	return mhr, nil
+}
+
+func decodeProviderResult(node datamodel.Node) (ProviderResult, error) {
+	var pr ProviderResult
+	var err error
+	if pr.ContextID, err = lookupBytes(node, "ContextID"); err != nil {
+		return pr, err
+	}
+	if pr.Metadata, err = lookupBytes(node, "Metadata"); err != nil {
+		return pr, err
+	}
+
+	provNode, err := lookupKind(node, "Provider", datamodel.Kind_Map)
+	if err != nil {
+		return pr, err
+	}
+	idBytes, err := lookupBytes(provNode, "ID")
+	if err != nil {
+		return pr, err
+	}
+	if pr.Provider.ID, err = peer.IDFromBytes(idBytes); err != nil {
+		return pr, fmt.Errorf("bad provider ID: %w", err)
+	}
+	addrsNode, err := lookupKind(provNode, "Addrs", datamodel.Kind_List)
+	if err != nil {
+		return pr, err
+	}
+	if addrsNode.Length() != 0 {
+		pr.Provider.Addrs = make([]multiaddr.Multiaddr, 0, addrsNode.Length())
+	}
+	for it := addrsNode.ListIterator(); !it.Done(); {
+		_, addrNode, err := it.Next()
+		if err != nil {
+			return pr, err
+		}
+		addrBytes, err := addrNode.AsBytes()
+		if err != nil {
+			return pr, fmt.Errorf("bad provider address: %w", err)
+		}
+		addr, err := multiaddr.NewMultiaddrBytes(addrBytes)
+		if err != nil {
+			return pr, fmt.Errorf("bad provider address: %w", err)
+		}
+		pr.Provider.Addrs = append(pr.Provider.Addrs, addr)
+	}
+
+	if n, err := node.LookupByString("Timestamp"); err == nil {
+		if pr.Timestamp, err = n.AsString(); err != nil {
+			return pr, fmt.Errorf("bad Timestamp: %w", err)
+		}
+	}
+	if n, err := node.LookupByString("MetadataOverride"); err == nil {
+		if pr.MetadataOverride, err = n.AsBool(); err != nil {
+			return pr, fmt.Errorf("bad MetadataOverride: %w", err)
+		}
+	}
+	if n, err := node.LookupByString("Status"); err == nil {
+		if pr.Status, err = n.AsString(); err != nil {
+			return pr, fmt.Errorf("bad Status: %w", err)
+		}
+	}
+	if n, err := node.LookupByString("ContextLastAdvertisementCid"); err == nil {
+		if pr.ContextLastAdvertisementCid, err = n.AsString(); err != nil {
+			return pr, fmt.Errorf("bad ContextLastAdvertisementCid: %w", err)
+		}
+	}
+	return pr, nil
+}
+
+// lookupKind returns the value of the required map key, which must be of the
+// given kind.
+func lookupKind(node datamodel.Node, key string, kind datamodel.Kind) (datamodel.Node, error) {
+	n, err := node.LookupByString(key)
+	if err != nil {
+		return nil, fmt.Errorf("missing %s: %w", key, err)
+	}
+	if n.Kind() != kind {
+		return nil, fmt.Errorf("%s must be %s, not %s", key, kind, n.Kind())
+	}
+	return n, nil
+}
+
+// lookupBytes returns the bytes value of the required map key.
+func lookupBytes(node datamodel.Node, key string) ([]byte, error) {
+	n, err := lookupKind(node, key, datamodel.Kind_Bytes)
+	if err != nil {
+		return nil, err
+	}
+	return n.AsBytes()
+}
@@ -0,0 +1,91 @@
+package model
+
+import (
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/storetheindex/test/util"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/multiformats/go-multiaddr"
+)
+
+func TestMarshalCBOR(t *testing.T) {
+	mhs := util.RandomMultihashes(3, rng)
+	p, _ := peer.Decode("12D3KooWKRyzVWW6ChFjQjK4miCty85Niy48tpPV95XdKu1BcvMA")
+	m1, err := multiaddr.NewMultiaddr("/ip4/127.0.0.1/udp/1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	provResults := []ProviderResult{
+		{
+			ContextID: []byte("test-context-id"),
+			Metadata:  []byte("test-metadata"),
+			Provider:  peer.AddrInfo{ID: p, Addrs: []multiaddr.Multiaddr{m1}},
+		},
+		{
+			ContextID:                   []byte("other-context-id"),
+			Metadata:                    []byte("override-metadata"),
+			Provider:                    peer.AddrInfo{ID: p},
+			MetadataOverride:            true,
+			Status:                      StatusDeregistered,
+			ContextLastAdvertisementCid: "bafyreigdmqpykrgxyaxtlafqpqhzrb7qy2rh75nldvfd4tucqdxhg6ad4y",
+		},
+	}
+	provResults[0].SetTimestamp(time.Now())
+
+	resp := &FindResponse{}
+	for i := range mhs {
+		resp.MultihashResults = append(resp.MultihashResults, MultihashResult{
+			Multihash:       mhs[i],
+			ProviderResults: provResults,
+		})
+	}
+	resp.MultihashResults = append(resp.MultihashResults, MultihashResult{
+		Multihash: util.RandomMultihashes(1, rng)[0],
+		Error:     "failed to query",
+	})
+
+	b, err := MarshalFindResponseCBOR(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jsonData, err := MarshalFindResponse(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b) >= len(jsonData) {
+		t.Fatalf("expected CBOR encoding (%d bytes) to be smaller than JSON (%d bytes)", len(b), len(jsonData))
+	}
+
+	r, err := UnmarshalFindResponseCBOR(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equalMultihashResult(resp.MultihashResults, r.MultihashResults) {
+		t.Fatal("failed marshal/unmarshaling response")
+	}
+	for i := range r.MultihashResults {
+		if r.MultihashResults[i].Error != resp.MultihashResults[i].Error {
+			t.Fatal("multihash result error not preserved")
+		}
+		for j, pr := range r.MultihashResults[i].ProviderResults {
+			expect := provResults[j]
+			if pr.Timestamp != expect.Timestamp || pr.MetadataOverride != expect.MetadataOverride || pr.Status != expect.Status || pr.ContextLastAdvertisementCid != expect.ContextLastAdvertisementCid {
+				t.Fatal("optional provider result fields not preserved")
+			}
+			if len(pr.Provider.Addrs) != len(expect.Provider.Addrs) {
+				t.Fatal("wrong number of provider addresses")
+			}
+			for k := range pr.Provider.Addrs {
+				if !pr.Provider.Addrs[k].Equal(expect.Provider.Addrs[k]) {
+					t.Fatal("provider address not preserved")
+				}
+			}
+		}
+	}
+
+	if _, err = UnmarshalFindResponseCBOR(jsonData); err == nil {
+		t.Fatal("expected error decoding JSON as CBOR")
+	}
+}
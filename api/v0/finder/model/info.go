@@ -0,0 +1,57 @@
+package model
+
+import (
+	"encoding/json"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// Names of the protocols that an indexer may serve, as listed in Info.
+const (
+	// ProtocolHttpFinder is the finder HTTP API.
+	ProtocolHttpFinder = "http-finder"
+	// ProtocolLibp2pFinder is the finder API over libp2p.
+	ProtocolLibp2pFinder = "libp2p-finder"
+	// ProtocolHttpIngest is the ingest HTTP API.
+	ProtocolHttpIngest = "http-ingest"
+	// ProtocolLibp2pIngest is the ingest API over libp2p.
+	ProtocolLibp2pIngest = "libp2p-ingest"
+	// ProtocolReframe is the reframe API, served by the finder HTTP server.
+	ProtocolReframe = "reframe"
+)
+
+// Names of the optional features that an indexer may have enabled, as listed
+// in Info.
+const (
+	// FeatureBlockedStatus means that find requests for only blocked
+	// multihashes are answered with 451 (Unavailable For Legal Reasons).
+	FeatureBlockedStatus = "blocked-status-451"
+	// FeatureSignedResponses means that finder responses are signed with the
+	// indexer's key.
+	FeatureSignedResponses = "signed-responses"
+)
+
+// Info is the client response to an info request. It describes the indexer
+// and what it supports.
+type Info struct {
+	// PeerID is the indexer's peer ID.
+	PeerID peer.ID
+	// Version is the indexer's software version.
+	Version string
+	// Protocols lists the protocols that the indexer serves.
+	Protocols []string
+	// Features lists the optional features that are enabled.
+	Features []string `json:",omitempty"`
+}
+
+// MarshalInfo serializes the info response.
+func MarshalInfo(info *Info) ([]byte, error) {
+	return json.Marshal(info)
+}
+
+// UnmarshalInfo de-serializes the info response.
+func UnmarshalInfo(b []byte) (*Info, error) {
+	info := &Info{}
+	err := json.Unmarshal(b, info)
+	return info, err
+}
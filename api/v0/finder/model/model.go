@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/libp2p/go-libp2p-core/peer"
 	"github.com/multiformats/go-multihash"
@@ -24,12 +25,40 @@ type ProviderResult struct {
 	Metadata []byte
 	// Provider is the peer ID and addresses of the provider.
 	Provider peer.AddrInfo
+	// Timestamp is the time, in ISO 8601 format, that the multihash was
+	// indexed for the provider's context. If the indexer does not record the
+	// time that each multihash is indexed, then it is the time that the
+	// indexer last ingested an advertisement for the provider's context. This
+	// is only present when requested.
+	Timestamp string `json:",omitempty"`
+	// MetadataOverride is true if Metadata was set by the indexer operator,
+	// replacing the metadata that the provider advertised.
+	MetadataOverride bool `json:",omitempty"`
+	// Status is empty for a provider that is registered with the indexer. It
+	// is StatusDeregistered for a provider that is no longer registered, and
+	// is only present when such results are requested.
+	Status string `json:",omitempty"`
+	// ContextLastAdvertisementCid is the CID of the most recent advertisement
+	// that updated the provider's context, which is the advertisement that
+	// set the result's metadata. It is not necessarily the advertisement that
+	// indexed the multihash, since an earlier advertisement for the same
+	// context may have added it. This is only present when requested.
+	ContextLastAdvertisementCid string `json:",omitempty"`
 }
 
+// StatusDeregistered is the ProviderResult status of a provider that has been
+// removed from the indexer's registry. Content is unlikely to be retrievable
+// from such a provider, and the result has no provider addresses.
+const StatusDeregistered = "deregistered"
+
 // MultihashResult aggregates all values for a single multihash.
 type MultihashResult struct {
 	Multihash       multihash.Multihash
 	ProviderResults []ProviderResult
+	// Error describes why the multihash could not be looked up. When set,
+	// there are no provider results for the multihash, but the results for
+	// other multihashes in the same response are still valid.
+	Error string `json:",omitempty"`
 }
 
 // FindResponse used to answer client queries/requests
@@ -54,6 +83,15 @@ func (pr ProviderResult) Equal(other ProviderResult) bool {
 	return true
 }
 
+// SetTimestamp sets the result's timestamp to the given time. A zero time
+// leaves the timestamp unset.
+func (pr *ProviderResult) SetTimestamp(t time.Time) {
+	if t.IsZero() {
+		return
+	}
+	pr.Timestamp = iso8601(t)
+}
+
 // MarshalFindRequest serializes the request. Currently uses JSON, but could
 // use anything else.
 //
@@ -0,0 +1,47 @@
+package model
+
+import (
+	"time"
+
+	v0 "github.com/filecoin-project/storetheindex/api/v0"
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multicodec"
+)
+
+// ProviderContext describes a context ID that a provider has advertised, as
+// currently known by the indexer.
+type ProviderContext struct {
+	ContextID []byte
+	Metadata  []byte `json:",omitempty"`
+	// EntryCount is the number of multihashes indexed for the context.
+	EntryCount uint64
+	// LastAdvertisement is the most recent advertisement that updated the
+	// context.
+	LastAdvertisement cid.Cid `json:",omitempty"`
+	// LastAdvertisementTime is the time, in ISO 8601 format, that the most
+	// recent advertisement that updated the context was ingested.
+	LastAdvertisementTime string `json:",omitempty"`
+	// Transport is the name of the transport protocol that the metadata is
+	// for, if the metadata identifies one.
+	Transport string `json:",omitempty"`
+}
+
+// MakeProviderContext returns the ProviderContext for a context ID with the
+// given metadata and number of indexed multihashes. The last advertisement
+// time and transport are only set if lastAdTime is not zero and transport is
+// a known transport code.
+func MakeProviderContext(contextID, metadata []byte, entryCount uint64, lastAd cid.Cid, lastAdTime time.Time, transport multicodec.Code) ProviderContext {
+	pctx := ProviderContext{
+		ContextID:         contextID,
+		Metadata:          metadata,
+		EntryCount:        entryCount,
+		LastAdvertisement: lastAd,
+	}
+	if !lastAdTime.IsZero() {
+		pctx.LastAdvertisementTime = iso8601(lastAdTime)
+	}
+	if transport != 0 {
+		pctx.Transport = v0.TransportName(transport)
+	}
+	return pctx
+}
@@ -14,6 +14,17 @@ type ProviderInfo struct {
 	LastAdvertisement     cid.Cid        `json:",omitempty"`
 	LastAdvertisementTime string         `json:",omitempty"`
 	Publisher             *peer.AddrInfo `json:",omitempty"`
+	// LastSeen is the last time that the indexer received an announcement
+	// from the provider's publisher, whether or not it announced a new
+	// advertisement. It is empty if no announcement has been received since
+	// the indexer started.
+	LastSeen string `json:",omitempty"`
+	// AutoRegistered is true if the indexer registered the provider from the
+	// addresses in an advertisement, instead of the provider registering
+	// itself. The indexer only flags providers this way when configured to.
+	AutoRegistered bool `json:",omitempty"`
+	// Tags are labels that the indexer operator has given the provider.
+	Tags []string `json:",omitempty"`
 }
 
 func MakeProviderInfo(addrInfo peer.AddrInfo, lastAd cid.Cid, lastAdTime time.Time, publisherID peer.ID, publisherAddr multiaddr.Multiaddr) ProviderInfo {
@@ -34,3 +45,12 @@ func MakeProviderInfo(addrInfo peer.AddrInfo, lastAd cid.Cid, lastAdTime time.Ti
 	}
 	return pinfo
 }
+
+// SetLastSeen sets the time that the provider's publisher was last seen. A
+// zero time leaves LastSeen unset.
+func (p *ProviderInfo) SetLastSeen(t time.Time) {
+	if t.IsZero() {
+		return
+	}
+	p.LastSeen = iso8601(t)
+}
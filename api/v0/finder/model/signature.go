@@ -0,0 +1,67 @@
+package model
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// Headers set on finder HTTP responses signed by the indexer.
+const (
+	// SignatureHeader holds the base64 (standard encoding) signature of the
+	// response.
+	SignatureHeader = "X-Indexer-Signature"
+	// SignerHeader holds the peer ID of the indexer that signed the response.
+	SignerHeader = "X-Indexer-ID"
+)
+
+// signatureDomain is prepended to the signed data, so that a signature of a
+// response cannot be used as the signature of any other data signed with the
+// indexer's key.
+const signatureDomain = "storetheindex-finder-response\n"
+
+// ResponseSigningData returns the data that the indexer signs for a response:
+// the signature domain, the request URI (path and query) as received by the
+// indexer, a newline, and the uncompressed response body. Including the
+// request URI binds the response to the request that it answers.
+func ResponseSigningData(requestURI string, body []byte) []byte {
+	data := make([]byte, 0, len(signatureDomain)+len(requestURI)+1+len(body))
+	data = append(data, signatureDomain...)
+	data = append(data, requestURI...)
+	data = append(data, '\n')
+	return append(data, body...)
+}
+
+// VerifyResponse checks that a finder response was signed by the indexer
+// with the given peer ID. The signature is the value of the SignatureHeader
+// response header, requestURI is the path and query of the request, and body
+// is the uncompressed response body.
+//
+// To verify a response without this function, decode the public key from
+// the indexer's peer ID, base64-decode the signature, and verify the
+// signature over ResponseSigningData(requestURI, body) using the public key.
+// The SignerHeader is informational only; the indexer ID must be known to the
+// client in advance.
+func VerifyResponse(indexerID peer.ID, requestURI string, body []byte, signature string) error {
+	if signature == "" {
+		return errors.New("response is not signed")
+	}
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("cannot decode response signature: %w", err)
+	}
+	pubKey, err := indexerID.ExtractPublicKey()
+	if err != nil {
+		return fmt.Errorf("cannot get public key from indexer id: %w", err)
+	}
+	ok, err := pubKey.Verify(ResponseSigningData(requestURI, body), sig)
+	if err != nil {
+		return fmt.Errorf("cannot verify response signature: %w", err)
+	}
+	if !ok {
+		return errors.New("response signature is not valid")
+	}
+	return nil
+}
@@ -35,9 +35,38 @@ func New(baseURL, resource string, options ...Option) (*url.URL, *http.Client, e
 	cl := &http.Client{
 		Timeout: cfg.timeout,
 	}
+	if cfg.tlsConfig != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = cfg.tlsConfig
+		cl.Transport = transport
+	}
+	if cfg.bearerToken != "" {
+		cl.Transport = &bearerTransport{
+			token: cfg.bearerToken,
+			next:  cl.Transport,
+		}
+	}
 	return u, cl, nil
 }
 
+// bearerTransport adds an Authorization header with a bearer token to each
+// request.
+type bearerTransport struct {
+	token string
+	next  http.RoundTripper
+}
+
+func (t *bearerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	next := t.next
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	// A RoundTripper must not modify the request, so modify a copy.
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+t.token)
+	return next.RoundTrip(req)
+}
+
 func ReadErrorFrom(status int, r io.Reader) error {
 	body, err := ioutil.ReadAll(r)
 	if err != nil {
@@ -1,12 +1,15 @@
 package httpclient
 
 import (
+	"crypto/tls"
 	"fmt"
 	"time"
 )
 
 type clientConfig struct {
-	timeout time.Duration
+	timeout     time.Duration
+	tlsConfig   *tls.Config
+	bearerToken string
 }
 
 // Option is the option type for httpclient
@@ -41,3 +44,21 @@ func Timeout(timeout time.Duration) Option {
 		return nil
 	}
 }
+
+// TLSConfig configures the TLS settings used for https connections, such as
+// the root CAs to trust and a client certificate to present.
+func TLSConfig(tlsConfig *tls.Config) Option {
+	return func(cfg *clientConfig) error {
+		cfg.tlsConfig = tlsConfig
+		return nil
+	}
+}
+
+// BearerToken configures the client to send the token in an Authorization
+// header with every request.
+func BearerToken(token string) Option {
+	return func(cfg *clientConfig) error {
+		cfg.bearerToken = token
+		return nil
+	}
+}
@@ -3,8 +3,10 @@ package ingesthttpclient
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"io"
 	"net/http"
+	"path"
 
 	"github.com/filecoin-project/go-legs/dtsync"
 	httpclient "github.com/filecoin-project/storetheindex/api/v0/httpclient"
@@ -19,6 +21,7 @@ const (
 	announcePath     = "/ingest/announce"
 	registerPath     = "/register"
 	indexContentPath = "/ingest/content"
+	adPath           = "/ingest/ad"
 )
 
 // Client is an http client for the indexer ingest API
@@ -27,6 +30,7 @@ type Client struct {
 	indexContentURL string
 	announceURL     string
 	registerURL     string
+	adURL           string
 }
 
 // New creates a new ingest http Client
@@ -41,6 +45,7 @@ func New(baseURL string, options ...httpclient.Option) (*Client, error) {
 		indexContentURL: baseURL + indexContentPath,
 		announceURL:     baseURL + announcePath,
 		registerURL:     baseURL + registerPath,
+		adURL:           baseURL + adPath,
 	}, nil
 }
 
@@ -73,6 +78,37 @@ func (c *Client) IndexContent(ctx context.Context, providerID peer.ID, privateKe
 	return nil
 }
 
+// RemoveContent removes the multihash, indexed for the provider's context,
+// from the indexer. The request is signed with the provider's private key.
+func (c *Client) RemoveContent(ctx context.Context, providerID peer.ID, privateKey p2pcrypto.PrivKey, m multihash.Multihash, contextID []byte) error {
+	data, err := model.MakeRemoveContentRequest(providerID, privateKey, m, contextID)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, c.indexContentURL, bytes.NewBuffer(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	resp, err := c.c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return httpclient.ReadError(resp.StatusCode, body)
+	}
+	return nil
+}
+
 // Announce a new root cid
 func (c *Client) Announce(ctx context.Context, provider *peer.AddrInfo, root cid.Cid) error {
 	p2paddrs, err := peer.AddrInfoToP2pAddrs(provider)
@@ -143,3 +179,29 @@ func (c *Client) Register(ctx context.Context, providerID peer.ID, privateKey p2
 	}
 	return nil
 }
+
+// AdStatus gets the indexer's ingestion status of the advertisement, to check
+// whether an advertisement that was published has been ingested.
+func (c *Client) AdStatus(ctx context.Context, adCid cid.Cid) (*model.AdStatus, error) {
+	u := c.adURL + path.Join("/", adCid.String(), "status")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, httpclient.ReadErrorFrom(resp.StatusCode, resp.Body)
+	}
+
+	var status model.AdStatus
+	if err = json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
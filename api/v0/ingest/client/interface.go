@@ -14,4 +14,5 @@ type Ingest interface {
 	Register(ctx context.Context, providerID peer.ID, privateKey crypto.PrivKey, addrs []string) error
 	IndexContent(ctx context.Context, providerID peer.ID, privateKey crypto.PrivKey, m multihash.Multihash, contextID []byte, metadata []byte, addrs []string) error
 	Announce(ctx context.Context, provider *peer.AddrInfo, root cid.Cid) error
+	RemoveContent(ctx context.Context, providerID peer.ID, privateKey crypto.PrivKey, m multihash.Multihash, contextID []byte) error
 }
@@ -79,6 +79,27 @@ func (c *Client) IndexContent(ctx context.Context, providerID peer.ID, privateKe
 	return nil
 }
 
+// RemoveContent removes the multihash, indexed for the provider's context,
+// from the indexer. The request is signed with the provider's private key.
+func (c *Client) RemoveContent(ctx context.Context, providerID peer.ID, privateKey p2pcrypto.PrivKey, m multihash.Multihash, contextID []byte) error {
+	data, err := model.MakeRemoveContentRequest(providerID, privateKey, m, contextID)
+	if err != nil {
+		return err
+	}
+
+	req := &pb.IngestMessage{
+		Type: pb.IngestMessage_REMOVE_CONTENT,
+		Data: data,
+	}
+
+	_, err = c.sendRecv(ctx, req, pb.IngestMessage_REMOVE_CONTENT_RESPONSE)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
 // Deprecated: Use gossip sub instead for sending announce message,
 func (c *Client) Announce(ctx context.Context, provider *peer.AddrInfo, root cid.Cid) error {
 	return fmt.Errorf("note implemented")
@@ -0,0 +1,31 @@
+package model
+
+import (
+	"github.com/ipfs/go-cid"
+)
+
+// Advertisement ingestion states reported in AdStatus.
+const (
+	// AdStatusUnknown means that the indexer has not synced the
+	// advertisement, or no longer holds any record of it.
+	AdStatusUnknown = "unknown"
+	// AdStatusSyncing means that the indexer has synced the advertisement,
+	// and has not finished processing it.
+	AdStatusSyncing = "syncing"
+	// AdStatusProcessed means that the indexer has finished processing the
+	// advertisement.
+	AdStatusProcessed = "processed"
+	// AdStatusFailed means that the indexer could not ingest the
+	// advertisement.
+	AdStatusFailed = "failed"
+)
+
+// AdStatus is the ingestion status of an advertisement.
+type AdStatus struct {
+	// AdCid is the CID of the advertisement.
+	AdCid cid.Cid
+	// Status is one of the AdStatus states.
+	Status string
+	// Err describes why ingestion failed, if Status is AdStatusFailed.
+	Err string `json:",omitempty"`
+}
@@ -0,0 +1,95 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/record"
+	"github.com/multiformats/go-multihash"
+)
+
+// RemoveContentRequest is a request to remove a single multihash, that was
+// indexed for a provider's context, from the indexer. The request must be
+// signed by the provider.
+type RemoveContentRequest struct {
+	Multihash  multihash.Multihash
+	ProviderID peer.ID
+	ContextID  []byte
+	Seq        uint64
+}
+
+// RemoveContentRequestEnvelopeDomain is the domain string used for remove
+// content requests contained in a Envelope.
+const RemoveContentRequestEnvelopeDomain = "indexer-remove-content-request-record"
+
+// RemoveContentRequestEnvelopePayloadType is the type hint used to identify
+// RemoveContentRequest records in a Envelope.
+var RemoveContentRequestEnvelopePayloadType = []byte("indexer-remove-content-request")
+
+func init() {
+	record.RegisterType(&RemoveContentRequest{})
+}
+
+// Domain is used when signing and validating RemoveContentRequest records
+// contained in Envelopes
+func (r *RemoveContentRequest) Domain() string {
+	return RemoveContentRequestEnvelopeDomain
+}
+
+// Codec is a binary identifier for the RemoveContentRequest type
+func (r *RemoveContentRequest) Codec() []byte {
+	return RemoveContentRequestEnvelopePayloadType
+}
+
+// UnmarshalRecord parses a RemoveContentRequest from a byte slice.
+func (r *RemoveContentRequest) UnmarshalRecord(data []byte) error {
+	if r == nil {
+		return fmt.Errorf("cannot unmarshal RemoveContentRequest to nil receiver")
+	}
+
+	return json.Unmarshal(data, r)
+}
+
+// MarshalRecord serializes a RemoveContentRequest to a byte slice.
+func (r *RemoveContentRequest) MarshalRecord() ([]byte, error) {
+	return json.Marshal(r)
+}
+
+// MakeRemoveContentRequest creates a signed RemoveContentRequest and marshals
+// it into bytes
+func MakeRemoveContentRequest(providerID peer.ID, privateKey crypto.PrivKey, m multihash.Multihash, contextID []byte) ([]byte, error) {
+	req := &RemoveContentRequest{
+		Multihash:  m,
+		ProviderID: providerID,
+		ContextID:  contextID,
+		Seq:        peer.TimestampSeq(),
+	}
+
+	return makeRequestEnvelop(req, privateKey)
+}
+
+// ReadRemoveContentRequest unmarshals a RemoveContentRequest from bytes,
+// verifies the signature, and returns the RemoveContentRequest. An error is
+// returned if the request is not signed by the provider whose content is to be
+// removed.
+func ReadRemoveContentRequest(data []byte) (*RemoveContentRequest, error) {
+	env, untypedRecord, err := record.ConsumeEnvelope(data, RemoveContentRequestEnvelopeDomain)
+	if err != nil {
+		return nil, fmt.Errorf("cannot consume remove content request envelope: %s", err)
+	}
+	rec, ok := untypedRecord.(*RemoveContentRequest)
+	if !ok {
+		return nil, fmt.Errorf("unmarshaled request is not a *RemoveContentRequest")
+	}
+
+	signerID, err := peer.IDFromPublicKey(env.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get signer id from remove content request: %s", err)
+	}
+	if signerID != rec.ProviderID {
+		return nil, fmt.Errorf("remove content request not signed by provider")
+	}
+	return rec, nil
+}
@@ -0,0 +1,57 @@
+package model
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/filecoin-project/storetheindex/test/util"
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/test"
+)
+
+func TestRemoveContentRequest(t *testing.T) {
+	mhs := util.RandomMultihashes(1, rng)
+
+	privKey, pubKey, err := test.RandTestKeyPair(crypto.Ed25519, 256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	providerID, err := peer.IDFromPublicKey(pubKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctxID := []byte("test-context-id")
+
+	data, err := MakeRemoveContentRequest(providerID, privKey, mhs[0], ctxID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := ReadRemoveContentRequest(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.ProviderID != providerID {
+		t.Error("wrong provider id")
+	}
+	if !bytes.Equal(req.Multihash, mhs[0]) {
+		t.Error("wrong multihash")
+	}
+	if !bytes.Equal(req.ContextID, ctxID) {
+		t.Error("wrong context id")
+	}
+
+	// A request signed by a key other than the provider's must be rejected.
+	otherKey, _, err := test.RandTestKeyPair(crypto.Ed25519, 256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err = MakeRemoveContentRequest(providerID, otherKey, mhs[0], ctxID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ReadRemoveContentRequest(data); err == nil {
+		t.Fatal("expected error reading request not signed by provider")
+	}
+}
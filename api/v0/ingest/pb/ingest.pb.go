@@ -34,18 +34,22 @@ const (
 	IngestMessage_REMOVE_PROVIDER_RESPONSE   IngestMessage_MessageType = 6
 	IngestMessage_INDEX_CONTENT              IngestMessage_MessageType = 7
 	IngestMessage_INDEX_CONTENT_RESPONSE     IngestMessage_MessageType = 8
+	IngestMessage_REMOVE_CONTENT             IngestMessage_MessageType = 9
+	IngestMessage_REMOVE_CONTENT_RESPONSE    IngestMessage_MessageType = 10
 )
 
 var IngestMessage_MessageType_name = map[int32]string{
-	0: "ERROR_RESPONSE",
-	1: "DISCOVER_PROVIDER",
-	2: "DISCOVER_PROVIDER_RESPONSE",
-	3: "REGISTER_PROVIDER",
-	4: "REGISTER_PROVIDER_RESPONSE",
-	5: "REMOVE_PROVIDER",
-	6: "REMOVE_PROVIDER_RESPONSE",
-	7: "INDEX_CONTENT",
-	8: "INDEX_CONTENT_RESPONSE",
+	0:  "ERROR_RESPONSE",
+	1:  "DISCOVER_PROVIDER",
+	2:  "DISCOVER_PROVIDER_RESPONSE",
+	3:  "REGISTER_PROVIDER",
+	4:  "REGISTER_PROVIDER_RESPONSE",
+	5:  "REMOVE_PROVIDER",
+	6:  "REMOVE_PROVIDER_RESPONSE",
+	7:  "INDEX_CONTENT",
+	8:  "INDEX_CONTENT_RESPONSE",
+	9:  "REMOVE_CONTENT",
+	10: "REMOVE_CONTENT_RESPONSE",
 }
 
 var IngestMessage_MessageType_value = map[string]int32{
@@ -58,6 +62,8 @@ var IngestMessage_MessageType_value = map[string]int32{
 	"REMOVE_PROVIDER_RESPONSE":   6,
 	"INDEX_CONTENT":              7,
 	"INDEX_CONTENT_RESPONSE":     8,
+	"REMOVE_CONTENT":             9,
+	"REMOVE_CONTENT_RESPONSE":    10,
 }
 
 func (x IngestMessage_MessageType) String() string {
@@ -130,25 +136,25 @@ func init() {
 func init() { proto.RegisterFile("ingest.proto", fileDescriptor_ff993cce43359ffa) }
 
 var fileDescriptor_ff993cce43359ffa = []byte{
-	// 273 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x64, 0xd1, 0xb1, 0x4a, 0xc3, 0x40,
-	0x1c, 0xc7, 0xf1, 0x5c, 0x8c, 0x55, 0xfe, 0xb6, 0x35, 0xfd, 0x8b, 0x12, 0x8a, 0x1c, 0xa5, 0x20,
-	0x74, 0xca, 0xa0, 0x93, 0xab, 0xcd, 0x21, 0x19, 0x9a, 0x2b, 0xff, 0x84, 0xe0, 0x16, 0x52, 0x3c,
-	0x8a, 0x8b, 0x3d, 0x93, 0x2c, 0x7d, 0x0b, 0x1f, 0xcb, 0xb1, 0x38, 0x39, 0x4a, 0xf2, 0x04, 0xbe,
-	0x81, 0x34, 0x08, 0xd7, 0xda, 0xe9, 0x8e, 0xdf, 0x7d, 0x3f, 0xd3, 0x41, 0xf7, 0xe5, 0x75, 0xa9,
-	0xca, 0xca, 0xd7, 0xc5, 0xaa, 0x5a, 0x21, 0x14, 0xea, 0xad, 0x50, 0xa5, 0xf6, 0xf5, 0x62, 0xfc,
-	0x69, 0x43, 0x2f, 0x6c, 0x1f, 0x67, 0xaa, 0x2c, 0xf3, 0xa5, 0xc2, 0x7b, 0x70, 0xaa, 0xb5, 0x56,
-	0x1e, 0x1b, 0xb1, 0x49, 0xff, 0xf6, 0xc6, 0x37, 0xb1, 0xbf, 0x17, 0xfa, 0x7f, 0x67, 0xb2, 0xd6,
-	0x8a, 0x5a, 0x82, 0x08, 0xce, 0x73, 0x5e, 0xe5, 0x9e, 0x3d, 0x62, 0x93, 0x2e, 0xb5, 0xf7, 0xf1,
-	0x0f, 0x83, 0xb3, 0x9d, 0x12, 0x11, 0xfa, 0x82, 0x48, 0x52, 0x46, 0x22, 0x9e, 0xcb, 0x28, 0x16,
-	0xae, 0x85, 0x97, 0x30, 0x08, 0xc2, 0x78, 0x2a, 0x53, 0x41, 0xd9, 0x9c, 0x64, 0x1a, 0x06, 0x82,
-	0x5c, 0x86, 0x1c, 0x86, 0x07, 0xb3, 0x61, 0xf6, 0x96, 0x91, 0x78, 0x0c, 0xe3, 0x64, 0x97, 0x1d,
-	0x6d, 0xd9, 0xc1, 0x6c, 0x98, 0x83, 0x17, 0x70, 0x4e, 0x62, 0x26, 0x53, 0x61, 0xd0, 0x31, 0x5e,
-	0x83, 0xf7, 0x6f, 0x34, 0xa4, 0x83, 0x03, 0xe8, 0x85, 0x51, 0x20, 0x9e, 0xb2, 0xa9, 0x8c, 0x12,
-	0x11, 0x25, 0xee, 0x09, 0x0e, 0xe1, 0x6a, 0x6f, 0x32, 0xf9, 0xe9, 0x83, 0xf7, 0x51, 0x73, 0xb6,
-	0xa9, 0x39, 0xfb, 0xae, 0x39, 0x7b, 0x6f, 0xb8, 0xb5, 0x69, 0xb8, 0xf5, 0xd5, 0x70, 0x6b, 0xd1,
-	0x69, 0x7f, 0xe0, 0xee, 0x37, 0x00, 0x00, 0xff, 0xff, 0xa3, 0xeb, 0xb0, 0x96, 0x91, 0x01, 0x00,
-	0x00,
+	// 286 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x64, 0x91, 0x31, 0x4b, 0xc3, 0x40,
+	0x14, 0x80, 0x73, 0x6d, 0xad, 0xfa, 0x6c, 0x6b, 0xfb, 0x44, 0x0d, 0x55, 0x8e, 0x52, 0x10, 0x3a,
+	0x65, 0xd0, 0xc9, 0xd5, 0xe6, 0x90, 0x0c, 0xcd, 0x95, 0x97, 0x10, 0xdc, 0x42, 0x8a, 0x47, 0x71,
+	0xb1, 0x31, 0xc9, 0xd2, 0xd9, 0x3f, 0xe0, 0x6f, 0xf0, 0xd7, 0x38, 0x76, 0x74, 0x94, 0xe4, 0x8f,
+	0x48, 0x42, 0xe5, 0x1a, 0x3b, 0xdd, 0xf1, 0xdd, 0xf7, 0x1d, 0x3c, 0x1e, 0x74, 0x5e, 0x5e, 0x97,
+	0x2a, 0xcd, 0xac, 0x38, 0x59, 0x65, 0x2b, 0x84, 0x44, 0xbd, 0x25, 0x2a, 0x8d, 0xad, 0x78, 0x31,
+	0x7e, 0x6f, 0x42, 0xd7, 0xa9, 0x1e, 0x67, 0x2a, 0x4d, 0xa3, 0xa5, 0xc2, 0x7b, 0x68, 0x65, 0xeb,
+	0x58, 0x99, 0x6c, 0xc4, 0x26, 0xbd, 0xdb, 0x1b, 0x4b, 0xcb, 0x56, 0x4d, 0xb4, 0xb6, 0xa7, 0xbf,
+	0x8e, 0x15, 0x55, 0x09, 0x22, 0xb4, 0x9e, 0xa3, 0x2c, 0x32, 0x1b, 0x23, 0x36, 0xe9, 0x50, 0x75,
+	0x1f, 0x7f, 0x36, 0xe0, 0x64, 0xc7, 0x44, 0x84, 0x9e, 0x20, 0x92, 0x14, 0x92, 0xf0, 0xe6, 0xd2,
+	0xf5, 0x44, 0xdf, 0xc0, 0x73, 0x18, 0xd8, 0x8e, 0x37, 0x95, 0x81, 0xa0, 0x70, 0x4e, 0x32, 0x70,
+	0x6c, 0x41, 0x7d, 0x86, 0x1c, 0x86, 0x7b, 0x58, 0x67, 0x8d, 0x32, 0x23, 0xf1, 0xe8, 0x78, 0xfe,
+	0x6e, 0xd6, 0x2c, 0xb3, 0x3d, 0xac, 0xb3, 0x16, 0x9e, 0xc1, 0x29, 0x89, 0x99, 0x0c, 0x84, 0x8e,
+	0x0e, 0xf0, 0x1a, 0xcc, 0x7f, 0x50, 0x27, 0x6d, 0x1c, 0x40, 0xd7, 0x71, 0x6d, 0xf1, 0x14, 0x4e,
+	0xa5, 0xeb, 0x0b, 0xd7, 0xef, 0x1f, 0xe2, 0x10, 0x2e, 0x6a, 0x48, 0xeb, 0x47, 0xe5, 0x8c, 0xdb,
+	0xcf, 0xfe, 0xfc, 0x63, 0xbc, 0x82, 0xcb, 0x3a, 0xd3, 0x01, 0x3c, 0x98, 0x5f, 0x39, 0x67, 0x9b,
+	0x9c, 0xb3, 0x9f, 0x9c, 0xb3, 0x8f, 0x82, 0x1b, 0x9b, 0x82, 0x1b, 0xdf, 0x05, 0x37, 0x16, 0xed,
+	0x6a, 0x65, 0x77, 0xbf, 0x03, 0x00, 0xde, 0x8e, 0xff, 0xa9, 0xc2, 0x01, 0x00, 0x00,
 }
 
 func (m *IngestMessage) Marshal() (dAtA []byte, err error) {
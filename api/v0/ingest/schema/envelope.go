@@ -115,6 +115,14 @@ func (ad *Advertisement) Sign(key crypto.PrivKey) error {
 // the caller will need to check if the signer is allowed to sign this
 // advertisement.
 func (ad *Advertisement) VerifySignature() (peer.ID, error) {
+	signerID, _, err := ad.VerifySignatureKey()
+	return signerID, err
+}
+
+// VerifySignatureKey is the same as VerifySignature, and also returns the
+// public key that signed the advertisement. This allows the caller to check
+// the type of key used.
+func (ad *Advertisement) VerifySignatureKey() (peer.ID, crypto.PubKey, error) {
 	// sigSize is the size of the current signature.  Any signature that is not
 	// this size is the old signature format.
 	const sigSize = 34
@@ -123,19 +131,19 @@ func (ad *Advertisement) VerifySignature() (peer.ID, error) {
 	rec := &advSignatureRecord{}
 	envelope, err := record.ConsumeTypedEnvelope(ad.Signature, rec)
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
 
 	// Calculate our own hash of the advertisement.
 	oldFormat := len(rec.advID) != sigSize
 	genID, err := signaturePayload(ad, oldFormat)
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
 
 	// Check that our own hash is equal to the hash from the signature.
 	if !bytes.Equal(genID, rec.advID) {
-		return "", errors.New("invalid signature")
+		return "", nil, errors.New("invalid signature")
 	}
 
 	// Get the peer ID that was used to sign the advertisement.  This may be
@@ -143,12 +151,12 @@ func (ad *Advertisement) VerifySignature() (peer.ID, error) {
 	// allowed to sign this advertisement.
 	signerID, err := peer.IDFromPublicKey(envelope.PublicKey)
 	if err != nil {
-		return "", fmt.Errorf("cannot convert public key to peer ID: %w", err)
+		return "", nil, fmt.Errorf("cannot convert public key to peer ID: %w", err)
 	}
 
 	if oldFormat {
 		log.Warnw("advertisement has deprecated signature format", "signer", signerID)
 	}
 
-	return signerID, nil
+	return signerID, envelope.PublicKey, nil
 }
@@ -0,0 +1,65 @@
+package v0
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-varint"
+)
+
+// TransportHTTP is the multicodec code for retrieval over HTTP, from an IPFS
+// gateway compatible endpoint. This code is not yet in go-multicodec.
+const TransportHTTP = multicodec.Code(0x0920)
+
+// transportNames maps the names accepted by ParseTransport to the multicodec
+// code of each transport.
+var transportNames = map[string]multicodec.Code{
+	"bitswap":   multicodec.TransportBitswap,
+	"graphsync": multicodec.TransportGraphsyncFilecoinv1,
+	"http":      TransportHTTP,
+}
+
+// ParseTransport returns the multicodec code of the named transport protocol.
+// The name is one of "bitswap", "graphsync", or "http", or is the name of the
+// multicodec code, such as "transport-bitswap".
+func ParseTransport(name string) (multicodec.Code, error) {
+	name = strings.ToLower(name)
+	if code, ok := transportNames[name]; ok {
+		return code, nil
+	}
+	if name == "transport-ipfs-gateway-http" {
+		return TransportHTTP, nil
+	}
+	var code multicodec.Code
+	if err := code.Set(name); err != nil || code.Tag() != "transport" {
+		return 0, fmt.Errorf("unknown transport %q", name)
+	}
+	return code, nil
+}
+
+// MetadataTransport returns the transport protocol of the metadata. The
+// metadata starts with the uvarint multicodec code of the transport that the
+// rest of the metadata is for.
+func MetadataTransport(metadata []byte) (multicodec.Code, error) {
+	if len(metadata) == 0 {
+		return 0, errors.New("empty metadata")
+	}
+	code, _, err := varint.FromUvarint(metadata)
+	if err != nil {
+		return 0, fmt.Errorf("cannot read transport from metadata: %w", err)
+	}
+	return multicodec.Code(code), nil
+}
+
+// TransportName returns the name of the transport protocol, as accepted by
+// ParseTransport.
+func TransportName(code multicodec.Code) string {
+	for name, c := range transportNames {
+		if c == code {
+			return name
+		}
+	}
+	return code.String()
+}
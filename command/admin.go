@@ -1,12 +1,19 @@
 package command
 
 import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"net/url"
+	"sort"
+	"time"
 
 	httpclient "github.com/filecoin-project/storetheindex/api/v0/admin/client/http"
+	"github.com/ipfs/go-cid"
 	"github.com/libp2p/go-libp2p-core/peer"
 	"github.com/multiformats/go-multiaddr"
+	"github.com/multiformats/go-multihash"
 	"github.com/urfave/cli/v2"
 )
 
@@ -17,6 +24,81 @@ var sync = &cli.Command{
 	Action: syncCmd,
 }
 
+var syncHistory = &cli.Command{
+	Name:   "sync-history",
+	Usage:  "Show summaries of a provider's recent syncs, newest first",
+	Flags:  adminSyncHistoryFlags,
+	Action: syncHistoryCmd,
+}
+
+var syncState = &cli.Command{
+	Name:   "sync-state",
+	Usage:  "Show the latest sync for each publisher, and flag any inconsistency",
+	Flags:  adminSyncStateFlags,
+	Action: syncStateCmd,
+}
+
+var clearSync = &cli.Command{
+	Name:  "clear-sync",
+	Usage: "Clear the latest sync for a provider so that its entire advertisement chain is synced again",
+	Description: "The next sync with the provider's publisher traverses the whole" +
+		" advertisement chain, which may take a long time for a long chain." +
+		" Advertisements that were already processed are not indexed again.",
+	Flags:  adminClearSyncFlags,
+	Action: clearSyncCmd,
+}
+
+var entryCount = &cli.Command{
+	Name:   "entry-count",
+	Usage:  "Show the number of multihashes indexed for a provider",
+	Flags:  adminEntryCountFlags,
+	Action: entryCountCmd,
+}
+
+var prefixScan = &cli.Command{
+	Name:  "prefix-scan",
+	Usage: "List indexed multihashes that begin with a prefix, and the providers they are indexed for",
+	Description: "The prefix is compared with the bytes of each multihash, which" +
+		" begin with the hash function code and digest length, so the prefix" +
+		" 1220 matches all sha2-256 multihashes. The indexer reads its whole" +
+		" value store to find the multihashes, so this can take a long time" +
+		" for a large index.",
+	Flags:  adminPrefixScanFlags,
+	Action: prefixScanCmd,
+}
+
+var metadataOverride = &cli.Command{
+	Name:  "metadata-override",
+	Usage: "Set or clear metadata that replaces a provider's advertised metadata for a context",
+	Description: "Find results for the context return the override metadata, flagged" +
+		" as an operator override, until the override is cleared or the provider" +
+		" advertises different metadata for the context.",
+	Flags:  adminMetadataOverrideFlags,
+	Action: metadataOverrideCmd,
+}
+
+var alias = &cli.Command{
+	Name:  "alias",
+	Usage: "Set, remove, or list aliases that attribute a provider's advertisements to another provider ID",
+	Description: "Advertisements that name the alias as their provider are ingested" +
+		" as the canonical provider's, so that a provider that rotates its keys" +
+		" keeps a single identity. Give --alias and --provider to set an alias," +
+		" --alias and --remove to remove one, or no flags to list all aliases.",
+	Flags:  adminAliasFlags,
+	Action: aliasCmd,
+}
+
+var tags = &cli.Command{
+	Name:  "tags",
+	Usage: "Set, clear, or show the tags that organize a provider",
+	Description: "Tags are labels, such as a region or operator, that the" +
+		" indexer keeps for a provider and that provider listings can be" +
+		" filtered by. They do not affect ingestion. Give --set to replace the" +
+		" provider's tags, --clear to remove them, or neither to show them.",
+	Flags:  adminTagsFlags,
+	Action: tagsCmd,
+}
+
 var allow = &cli.Command{
 	Name:   "allow",
 	Usage:  "Allow advertisements and content from peer",
@@ -54,20 +136,72 @@ var reload = &cli.Command{
 	Action: reloadConfigCmd,
 }
 
+var blocklist = &cli.Command{
+	Name:  "blocklist",
+	Usage: "Add, remove, or list multihashes that are blocked from being indexed or returned in find results",
+	Description: "Blocked multihashes have no find results, whichever provider" +
+		" indexed them, and are not indexed from advertisements. Content can be" +
+		" given as a multihash or as a CID. Give --add or --remove to change the" +
+		" blocklist, or no flags to list all blocked multihashes.",
+	Flags:  adminBlocklistFlags,
+	Action: blocklistCmd,
+}
+
+var audit = &cli.Command{
+	Name:  "audit",
+	Usage: "Show the audit log of admin requests that changed the indexer's state",
+	Description: "Shows when each admin request was made, by whom, what it did" +
+		" and its result. Give --start and --end to only show requests in that" +
+		" time range.",
+	Flags:  adminAuditFlags,
+	Action: auditCmd,
+}
+
+var flush = &cli.Command{
+	Name:  "flush",
+	Usage: "Write pending changes in the value store to storage",
+	Description: "Returns once all pending changes are durable, so that a" +
+		" consistent backup of the value store directory can be taken.",
+	Flags:  adminFlushFlags,
+	Action: flushCmd,
+}
+
+var retryFailures = &cli.Command{
+	Name:  "retry-failures",
+	Usage: "Ingest advertisements that failed to ingest again",
+	Description: "Each failed advertisement is queued to be ingested again, and" +
+		" is fetched from its publisher again if the indexer no longer holds" +
+		" it. Use the provider flag to only retry one provider's advertisements.",
+	Flags:  adminRetryFailuresFlags,
+	Action: retryFailuresCmd,
+}
+
 var AdminCmd = &cli.Command{
 	Name:  "admin",
 	Usage: "Perform admin activities with an indexer",
 	Subcommands: []*cli.Command{
+		alias,
 		allow,
+		audit,
 		block,
+		blocklist,
+		clearSync,
+		entryCount,
+		flush,
 		importProviders,
+		metadataOverride,
+		prefixScan,
 		reload,
+		retryFailures,
 		sync,
+		syncHistory,
+		syncState,
+		tags,
 	},
 }
 
 func syncCmd(cctx *cli.Context) error {
-	cl, err := httpclient.New(cliIndexer(cctx, "admin"))
+	cl, err := httpclient.New(cliIndexer(cctx, "admin"), adminClientOptions(cctx)...)
 	if err != nil {
 		return err
 	}
@@ -83,7 +217,11 @@ func syncCmd(cctx *cli.Context) error {
 			return err
 		}
 	}
-	err = cl.Sync(cctx.Context, peerID, addr, cctx.Int64("depth"), cctx.Bool("resync"))
+	if since := cctx.Duration("since"); since != 0 {
+		err = cl.SyncSince(cctx.Context, peerID, addr, time.Now().Add(-since), cctx.Int64("depth"))
+	} else {
+		err = cl.Sync(cctx.Context, peerID, addr, cctx.Int64("depth"), cctx.Bool("resync"))
+	}
 	if err != nil {
 		return err
 	}
@@ -91,8 +229,98 @@ func syncCmd(cctx *cli.Context) error {
 	return nil
 }
 
+func syncStateCmd(cctx *cli.Context) error {
+	cl, err := httpclient.New(cliIndexer(cctx, "admin"), adminClientOptions(cctx)...)
+	if err != nil {
+		return err
+	}
+	states, err := cl.SyncState(cctx.Context)
+	if err != nil {
+		return err
+	}
+	for _, state := range states {
+		fmt.Println("Publisher:", state.PeerID)
+		fmt.Println("    Subscriber latest sync:", cidOrNone(state.SubscriberSync))
+		fmt.Println("    Persisted latest sync: ", cidOrNone(state.PersistedSync))
+		if state.Mismatch {
+			fmt.Println("    MISMATCH")
+		}
+	}
+	return nil
+}
+
+func syncHistoryCmd(cctx *cli.Context) error {
+	cl, err := httpclient.New(cliIndexer(cctx, "admin"), adminClientOptions(cctx)...)
+	if err != nil {
+		return err
+	}
+	provID, err := peer.Decode(cctx.String("provider"))
+	if err != nil {
+		return err
+	}
+	history, err := cl.SyncHistory(cctx.Context, provID)
+	if err != nil {
+		return err
+	}
+	if len(history) == 0 {
+		fmt.Println("No sync history for provider", provID)
+		return nil
+	}
+	for _, summary := range history {
+		fmt.Println("Sync finished:", summary.Time.Format(time.RFC3339))
+		fmt.Println("    Head advertisement:", summary.HeadAdCid)
+		fmt.Println("    Publisher:         ", summary.Publisher)
+		fmt.Println("    Ads processed:     ", summary.AdsProcessed)
+		fmt.Println("    Multihashes added: ", summary.MultihashesAdded)
+		if summary.Err != "" {
+			fmt.Println("    Error:             ", summary.Err)
+		}
+	}
+	return nil
+}
+
+func clearSyncCmd(cctx *cli.Context) error {
+	cl, err := httpclient.New(cliIndexer(cctx, "admin"), adminClientOptions(cctx)...)
+	if err != nil {
+		return err
+	}
+	provID, err := peer.Decode(cctx.String("provider"))
+	if err != nil {
+		return err
+	}
+	err = cl.ClearSync(cctx.Context, provID)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Cleared latest sync for provider", provID)
+	return nil
+}
+
+func retryFailuresCmd(cctx *cli.Context) error {
+	cl, err := httpclient.New(cliIndexer(cctx, "admin"), adminClientOptions(cctx)...)
+	if err != nil {
+		return err
+	}
+	var provID peer.ID
+	if cctx.IsSet("provider") {
+		provID, err = peer.Decode(cctx.String("provider"))
+		if err != nil {
+			return err
+		}
+	}
+	summary, err := cl.RetryFailures(cctx.Context, provID)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Requeued", summary.Requeued, "failed advertisements")
+	if summary.Skipped != 0 {
+		fmt.Println("Skipped", summary.Skipped, "failed advertisements that could not be loaded")
+	}
+	return nil
+}
+
 func allowCmd(cctx *cli.Context) error {
-	cl, err := httpclient.New(cliIndexer(cctx, "admin"))
+	cl, err := httpclient.New(cliIndexer(cctx, "admin"), adminClientOptions(cctx)...)
 	if err != nil {
 		return err
 	}
@@ -109,7 +337,7 @@ func allowCmd(cctx *cli.Context) error {
 }
 
 func blockCmd(cctx *cli.Context) error {
-	cl, err := httpclient.New(cliIndexer(cctx, "admin"))
+	cl, err := httpclient.New(cliIndexer(cctx, "admin"), adminClientOptions(cctx)...)
 	if err != nil {
 		return err
 	}
@@ -131,7 +359,7 @@ func importProvidersCmd(cctx *cli.Context) error {
 		Host:   cctx.String("from"),
 		Path:   "/providers",
 	}
-	cl, err := httpclient.New(cliIndexer(cctx, "admin"))
+	cl, err := httpclient.New(cliIndexer(cctx, "admin"), adminClientOptions(cctx)...)
 	if err != nil {
 		return err
 	}
@@ -144,7 +372,7 @@ func importProvidersCmd(cctx *cli.Context) error {
 }
 
 func reloadConfigCmd(cctx *cli.Context) error {
-	cl, err := httpclient.New(cliIndexer(cctx, "admin"))
+	cl, err := httpclient.New(cliIndexer(cctx, "admin"), adminClientOptions(cctx)...)
 	if err != nil {
 		return err
 	}
@@ -155,3 +383,290 @@ func reloadConfigCmd(cctx *cli.Context) error {
 	fmt.Println("Reloaded indexer configuration")
 	return nil
 }
+
+func flushCmd(cctx *cli.Context) error {
+	cl, err := httpclient.New(cliIndexer(cctx, "admin"), adminClientOptions(cctx)...)
+	if err != nil {
+		return err
+	}
+	err = cl.Flush(cctx.Context)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Flushed indexer value store")
+	return nil
+}
+
+func entryCountCmd(cctx *cli.Context) error {
+	cl, err := httpclient.New(cliIndexer(cctx, "admin"), adminClientOptions(cctx)...)
+	if err != nil {
+		return err
+	}
+	provID, err := peer.Decode(cctx.String("provider"))
+	if err != nil {
+		return err
+	}
+	count, err := cl.ProviderEntryCount(cctx.Context, provID)
+	if err != nil {
+		return err
+	}
+	fmt.Println(count)
+	return nil
+}
+
+func prefixScanCmd(cctx *cli.Context) error {
+	prefix, err := hex.DecodeString(cctx.String("prefix"))
+	if err != nil {
+		return fmt.Errorf("cannot decode prefix as hex: %w", err)
+	}
+	cl, err := httpclient.New(cliIndexer(cctx, "admin"), adminClientOptions(cctx)...)
+	if err != nil {
+		return err
+	}
+	scan, err := cl.MultihashesWithPrefix(cctx.Context, prefix, cctx.Int("limit"))
+	if err != nil {
+		return err
+	}
+	if len(scan.Multihashes) == 0 {
+		fmt.Println("No multihashes with prefix")
+		return nil
+	}
+	for _, found := range scan.Multihashes {
+		fmt.Println(found.Multihash.B58String())
+		for _, value := range found.Values {
+			fmt.Printf("    Provider: %s  ContextID: %s\n", value.ProviderID, base64.StdEncoding.EncodeToString(value.ContextID))
+		}
+	}
+	if scan.Truncated {
+		fmt.Printf("Stopped after %d multihashes; more may begin with the prefix\n", len(scan.Multihashes))
+	}
+	return nil
+}
+
+func aliasCmd(cctx *cli.Context) error {
+	cl, err := httpclient.New(cliIndexer(cctx, "admin"), adminClientOptions(cctx)...)
+	if err != nil {
+		return err
+	}
+
+	if !cctx.IsSet("alias") {
+		aliases, err := cl.ListAliases(cctx.Context)
+		if err != nil {
+			return err
+		}
+		if len(aliases) == 0 {
+			fmt.Println("No provider aliases")
+			return nil
+		}
+		for _, a := range aliases {
+			fmt.Println(a.Alias, "->", a.ProviderID)
+		}
+		return nil
+	}
+
+	aliasID, err := peer.Decode(cctx.String("alias"))
+	if err != nil {
+		return err
+	}
+	if cctx.Bool("remove") {
+		if err = cl.RemoveAlias(cctx.Context, aliasID); err != nil {
+			return err
+		}
+		fmt.Println("Removed alias", aliasID)
+		return nil
+	}
+
+	if !cctx.IsSet("provider") {
+		return errors.New("either --provider or --remove must be given with --alias")
+	}
+	provID, err := peer.Decode(cctx.String("provider"))
+	if err != nil {
+		return err
+	}
+	if err = cl.SetAlias(cctx.Context, aliasID, provID); err != nil {
+		return err
+	}
+	fmt.Println("Advertisements from", aliasID, "are attributed to provider", provID)
+	return nil
+}
+
+func tagsCmd(cctx *cli.Context) error {
+	cl, err := httpclient.New(cliIndexer(cctx, "admin"), adminClientOptions(cctx)...)
+	if err != nil {
+		return err
+	}
+	provID, err := peer.Decode(cctx.String("provider"))
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case cctx.IsSet("set") && cctx.Bool("clear"):
+		return errors.New("only one of --set or --clear may be given")
+	case cctx.IsSet("set"):
+		if err = cl.SetProviderTags(cctx.Context, provID, cctx.StringSlice("set")); err != nil {
+			return err
+		}
+		fmt.Println("Set tags for provider", provID)
+		return nil
+	case cctx.Bool("clear"):
+		if err = cl.SetProviderTags(cctx.Context, provID, nil); err != nil {
+			return err
+		}
+		fmt.Println("Cleared tags for provider", provID)
+		return nil
+	}
+
+	provTags, err := cl.GetProviderTags(cctx.Context, provID)
+	if err != nil {
+		return err
+	}
+	if len(provTags) == 0 {
+		fmt.Println("Provider has no tags")
+		return nil
+	}
+	for _, tag := range provTags {
+		fmt.Println(tag)
+	}
+	return nil
+}
+
+func blocklistCmd(cctx *cli.Context) error {
+	cl, err := httpclient.New(cliIndexer(cctx, "admin"), adminClientOptions(cctx)...)
+	if err != nil {
+		return err
+	}
+
+	add := cctx.StringSlice("add")
+	remove := cctx.StringSlice("remove")
+	if len(add) == 0 && len(remove) == 0 {
+		mhs, err := cl.ListBlockedMultihashes(cctx.Context)
+		if err != nil {
+			return err
+		}
+		if len(mhs) == 0 {
+			fmt.Println("No blocked multihashes")
+			return nil
+		}
+		for _, mh := range mhs {
+			fmt.Println(mh.B58String())
+		}
+		return nil
+	}
+
+	for _, s := range add {
+		mh, err := parseMultihashOrCid(s)
+		if err != nil {
+			return err
+		}
+		if err = cl.BlockMultihash(cctx.Context, mh); err != nil {
+			return err
+		}
+		fmt.Println("Blocked", mh.B58String())
+	}
+	for _, s := range remove {
+		mh, err := parseMultihashOrCid(s)
+		if err != nil {
+			return err
+		}
+		if err = cl.UnblockMultihash(cctx.Context, mh); err != nil {
+			return err
+		}
+		fmt.Println("Unblocked", mh.B58String())
+	}
+	return nil
+}
+
+// parseMultihashOrCid parses a base58 multihash, or the multihash of a CID.
+func parseMultihashOrCid(s string) (multihash.Multihash, error) {
+	if mh, err := multihash.FromB58String(s); err == nil {
+		return mh, nil
+	}
+	c, err := cid.Decode(s)
+	if err != nil {
+		return nil, fmt.Errorf("%q is not a multihash or CID", s)
+	}
+	return c.Hash(), nil
+}
+
+func auditCmd(cctx *cli.Context) error {
+	var start, end time.Time
+	var err error
+	if s := cctx.String("start"); s != "" {
+		if start, err = time.Parse(time.RFC3339, s); err != nil {
+			return fmt.Errorf("bad start time: %w", err)
+		}
+	}
+	if s := cctx.String("end"); s != "" {
+		if end, err = time.Parse(time.RFC3339, s); err != nil {
+			return fmt.Errorf("bad end time: %w", err)
+		}
+	}
+
+	cl, err := httpclient.New(cliIndexer(cctx, "admin"), adminClientOptions(cctx)...)
+	if err != nil {
+		return err
+	}
+	entries, err := cl.AuditLog(cctx.Context, start, end)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		fmt.Println("No audit entries")
+		return nil
+	}
+	for _, entry := range entries {
+		fmt.Println(entry.Time.Format(time.RFC3339Nano), entry.Action, entry.Status)
+		if entry.Actor != "" {
+			fmt.Println("    Actor: ", entry.Actor)
+		}
+		fmt.Println("    Remote:", entry.Remote)
+		keys := make([]string, 0, len(entry.Params))
+		for k := range entry.Params {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Printf("    %s=%s\n", k, entry.Params[k])
+		}
+	}
+	return nil
+}
+
+func metadataOverrideCmd(cctx *cli.Context) error {
+	cl, err := httpclient.New(cliIndexer(cctx, "admin"), adminClientOptions(cctx)...)
+	if err != nil {
+		return err
+	}
+	provID, err := peer.Decode(cctx.String("provider"))
+	if err != nil {
+		return err
+	}
+	contextID, err := base64.StdEncoding.DecodeString(cctx.String("context"))
+	if err != nil {
+		return fmt.Errorf("cannot decode context id: %w", err)
+	}
+
+	if cctx.Bool("clear") {
+		err = cl.ClearMetadataOverride(cctx.Context, provID, contextID)
+		if err != nil {
+			return err
+		}
+		fmt.Println("Cleared metadata override for provider", provID)
+		return nil
+	}
+
+	if !cctx.IsSet("metadata") {
+		return errors.New("either --metadata or --clear must be given")
+	}
+	metadata, err := base64.StdEncoding.DecodeString(cctx.String("metadata"))
+	if err != nil {
+		return fmt.Errorf("cannot decode metadata: %w", err)
+	}
+	err = cl.SetMetadataOverride(cctx.Context, provID, contextID, metadata)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Set metadata override for provider", provID)
+	return nil
+}
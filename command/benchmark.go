@@ -0,0 +1,239 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/filecoin-project/go-indexer-core"
+	"github.com/filecoin-project/storetheindex/config"
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
+	"github.com/multiformats/go-varint"
+	"github.com/urfave/cli/v2"
+)
+
+var BenchmarkStoreCmd = &cli.Command{
+	Name:  "benchmark-store",
+	Usage: "Benchmark the value store with synthetic puts and gets",
+	Description: "Creates a value store of the type set in the config file, or" +
+		" given by --type, in a temporary directory. Synthetic multihashes are" +
+		" stored in batches, as when ingesting entry chunks, and then looked up" +
+		" in random order. The throughput and latency percentiles of the puts" +
+		" and gets are reported. The temporary store is created next to the" +
+		" configured value store, so that it is on the same storage, unless" +
+		" --dir is given, and is removed when the benchmark finishes. If the" +
+		" indexer is not initialized, the default value store settings are used.",
+	Flags:  benchmarkStoreFlags,
+	Action: benchmarkStoreCmd,
+}
+
+// storeBenchmark is the result of benchmarking a value store.
+type storeBenchmark struct {
+	Puts      []time.Duration
+	PutCount  int
+	PutTime   time.Duration
+	FlushTime time.Duration
+	Gets      []time.Duration
+	GetTime   time.Duration
+	Misses    int
+}
+
+func benchmarkStoreCmd(cctx *cli.Context) error {
+	num := cctx.Int("num")
+	batchSize := cctx.Int("batch")
+	if num < 1 || batchSize < 1 {
+		return errors.New("num and batch must be greater than zero")
+	}
+
+	cfgIndexer := config.NewIndexer()
+	cfg, err := loadConfig("")
+	if err == nil {
+		cfgIndexer = cfg.Indexer
+	} else if !errors.Is(err, config.ErrNotInitialized) {
+		return err
+	}
+	if storeType := cctx.String("type"); storeType != "" {
+		cfgIndexer.ValueStoreType = storeType
+	}
+
+	parentDir := cctx.String("dir")
+	if parentDir == "" {
+		if cfg != nil {
+			storeDir, err := config.Path("", cfgIndexer.ValueStoreDir)
+			if err != nil {
+				return err
+			}
+			parentDir = filepath.Dir(storeDir)
+		} else {
+			parentDir = os.TempDir()
+		}
+	}
+	dir, err := os.MkdirTemp(parentDir, "benchmark-store-")
+	if err != nil {
+		return fmt.Errorf("cannot create benchmark directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+	cfgIndexer.ValueStoreDir = dir
+
+	store, err := createValueStore(cctx.Context, cfgIndexer)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	fmt.Printf("Generating %d multihashes\n", num)
+	mhs, err := randomMultihashes(num)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Benchmarking %s value store in %s\n", cfgIndexer.ValueStoreType, dir)
+	result, err := runStoreBenchmark(cctx.Context, store, mhs, batchSize, cctx.Int("gets"), cctx.Int("providers"))
+	if err != nil {
+		return err
+	}
+	result.print(os.Stdout, batchSize)
+
+	size, err := store.Size()
+	if err == nil {
+		fmt.Printf("Value store size: %d bytes\n", size)
+	}
+	return nil
+}
+
+// randomMultihashes returns the multihashes of n synthetic CIDs.
+func randomMultihashes(n int) ([]multihash.Multihash, error) {
+	mhs := make([]multihash.Multihash, 0, n)
+	for len(mhs) < n {
+		cids, err := randomCids(100)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range cids {
+			if len(mhs) == n {
+				break
+			}
+			mhs = append(mhs, c.Hash())
+		}
+	}
+	return mhs, nil
+}
+
+// runStoreBenchmark puts the multihashes into the store in batches, with each
+// batch indexed for one of the given number of synthetic providers, and then
+// gets the given number of multihashes chosen at random from those that were
+// put.
+func runStoreBenchmark(ctx context.Context, store indexer.Interface, mhs []multihash.Multihash, batchSize, gets, providers int) (*storeBenchmark, error) {
+	if providers < 1 {
+		providers = 1
+	}
+	values := make([]indexer.Value, providers)
+	metadata := varint.ToUvarint(uint64(multicodec.TransportBitswap))
+	for i := range values {
+		priv, _, err := crypto.GenerateEd25519Key(nil)
+		if err != nil {
+			return nil, err
+		}
+		providerID, err := peer.IDFromPrivateKey(priv)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = indexer.Value{
+			ProviderID:    providerID,
+			ContextID:     []byte(fmt.Sprint("benchmark-context-", i)),
+			MetadataBytes: metadata,
+		}
+	}
+
+	prng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	result := &storeBenchmark{
+		Puts: make([]time.Duration, 0, (len(mhs)+batchSize-1)/batchSize),
+		Gets: make([]time.Duration, 0, gets),
+	}
+
+	start := time.Now()
+	for i := 0; i < len(mhs); i += batchSize {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		end := i + batchSize
+		if end > len(mhs) {
+			end = len(mhs)
+		}
+		value := values[prng.Intn(len(values))]
+		putStart := time.Now()
+		if err := store.Put(value, mhs[i:end]...); err != nil {
+			return nil, fmt.Errorf("cannot put multihashes: %w", err)
+		}
+		result.Puts = append(result.Puts, time.Since(putStart))
+		result.PutCount += end - i
+	}
+	flushStart := time.Now()
+	if err := store.Flush(); err != nil {
+		return nil, fmt.Errorf("cannot flush value store: %w", err)
+	}
+	result.FlushTime = time.Since(flushStart)
+	result.PutTime = time.Since(start)
+
+	start = time.Now()
+	for i := 0; i < gets; i++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		mh := mhs[prng.Intn(len(mhs))]
+		getStart := time.Now()
+		_, found, err := store.Get(mh)
+		if err != nil {
+			return nil, fmt.Errorf("cannot get multihash: %w", err)
+		}
+		result.Gets = append(result.Gets, time.Since(getStart))
+		if !found {
+			result.Misses++
+		}
+	}
+	result.GetTime = time.Since(start)
+
+	return result, nil
+}
+
+func (b *storeBenchmark) print(w io.Writer, batchSize int) {
+	fmt.Fprintf(w, "Put %d multihashes in %d batches of up to %d in %s, including flush of %s: %.0f multihashes/s\n",
+		b.PutCount, len(b.Puts), batchSize, b.PutTime, b.FlushTime, perSecond(b.PutCount, b.PutTime))
+	fmt.Fprintln(w, "  Batch latency:", percentiles(b.Puts))
+	fmt.Fprintf(w, "Got %d multihashes in %s: %.0f gets/s\n", len(b.Gets), b.GetTime, perSecond(len(b.Gets), b.GetTime))
+	if len(b.Gets) != 0 {
+		fmt.Fprintln(w, "  Get latency:", percentiles(b.Gets))
+	}
+	if b.Misses != 0 {
+		fmt.Fprintf(w, "  %d gets did not find a multihash that was put\n", b.Misses)
+	}
+}
+
+func perSecond(count int, elapsed time.Duration) float64 {
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(count) / elapsed.Seconds()
+}
+
+// percentiles describes the 50th, 90th, and 99th percentile, and maximum, of
+// the latencies. The latencies are sorted in place.
+func percentiles(latencies []time.Duration) string {
+	if len(latencies) == 0 {
+		return "none"
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	at := func(p float64) time.Duration {
+		return latencies[int(p*float64(len(latencies)-1))]
+	}
+	return fmt.Sprintf("p50=%s p90=%s p99=%s max=%s", at(0.5), at(0.9), at(0.99), latencies[len(latencies)-1])
+}
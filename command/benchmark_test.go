@@ -0,0 +1,50 @@
+package command
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/go-indexer-core/store/memory"
+)
+
+func TestRunStoreBenchmark(t *testing.T) {
+	mhs, err := randomMultihashes(250)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mhs) != 250 {
+		t.Fatalf("expected 250 multihashes, got %d", len(mhs))
+	}
+
+	store := memory.New()
+	result, err := runStoreBenchmark(context.Background(), store, mhs, 100, 50, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.PutCount != len(mhs) || len(result.Puts) != 3 {
+		t.Fatalf("expected %d multihashes put in 3 batches, got %d in %d", len(mhs), result.PutCount, len(result.Puts))
+	}
+	if len(result.Gets) != 50 || result.Misses != 0 {
+		t.Fatalf("expected 50 gets with no misses, got %d with %d misses", len(result.Gets), result.Misses)
+	}
+	for _, mh := range mhs {
+		if _, found, err := store.Get(mh); err != nil || !found {
+			t.Fatal("multihash was not put")
+		}
+	}
+}
+
+func TestPercentiles(t *testing.T) {
+	var latencies []time.Duration
+	for i := 100; i > 0; i-- {
+		latencies = append(latencies, time.Duration(i)*time.Millisecond)
+	}
+	expect := "p50=50ms p90=90ms p99=99ms max=100ms"
+	if got := percentiles(latencies); got != expect {
+		t.Fatalf("expected %q, got %q", expect, got)
+	}
+	if got := percentiles(nil); got != "none" {
+		t.Fatalf("expected none, got %q", got)
+	}
+}
@@ -1,18 +1,33 @@
 package command
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"os"
+	"strings"
 
 	httpclient "github.com/filecoin-project/storetheindex/api/v0/admin/client/http"
+	"github.com/filecoin-project/storetheindex/config"
 	"github.com/urfave/cli/v2"
 )
 
 var ConfigCmd = &cli.Command{
 	Name:  "config",
-	Usage: "Dynamically modifies and shows the current logging configuration",
-	Flags: []cli.Flag{indexerHostFlag},
+	Usage: "Checks a config file, or dynamically modifies and shows the current logging configuration",
+	Flags: []cli.Flag{indexerHostFlag, adminTokenFlag},
 	Subcommands: []*cli.Command{
+		{
+			Name:  "check",
+			Usage: "Validates a config file without starting the daemon",
+			Description: `Loads and validates the config file at the given path, or the config file
+in the storetheindex repository if no path is given. The config is printed
+with unset values populated with their defaults, followed by any warnings
+about values that are valid but likely mistakes. Exits with an error if the
+config is not valid.`,
+			ArgsUsage: "[path]",
+			Action:    checkConfig,
+		},
 		{
 			Name:  "set",
 			Usage: "Sets a configuration parameter",
@@ -46,8 +61,72 @@ following command will set the level for all subsystems to debug:
 	},
 }
 
+func checkConfig(cctx *cli.Context) error {
+	if cctx.Args().Len() > 1 {
+		return errors.New("too many arguments")
+	}
+	cfgPath := cctx.Args().First()
+	if cfgPath == "" {
+		var err error
+		cfgPath, err = config.Filename("")
+		if err != nil {
+			return err
+		}
+	}
+
+	cfg, err := config.Load(cfgPath)
+	if err != nil {
+		return fmt.Errorf("cannot load config file: %w", err)
+	}
+	if err = cfg.Validate(); err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+
+	warnings := cfg.Warnings()
+	// Fields that are not known are ignored when loading the config. These
+	// are fields that have been removed, or that are misspelled.
+	unknown, err := unknownConfigField(cfgPath)
+	if err != nil {
+		return err
+	}
+	if unknown != "" {
+		warnings = append(warnings, fmt.Sprintf("%s: unknown field is ignored", unknown))
+	}
+
+	_, _ = fmt.Fprintln(cctx.App.Writer, cfg.String())
+	for _, warning := range warnings {
+		_, _ = fmt.Fprintln(cctx.App.ErrWriter, "WARNING:", warning)
+	}
+	_, _ = fmt.Fprintln(cctx.App.ErrWriter, "Config file", cfgPath, "is valid")
+	return nil
+}
+
+// unknownConfigField returns the name of the first field in the config file
+// that is not a config field, or an empty string if all fields are known.
+func unknownConfigField(cfgPath string) (string, error) {
+	f, err := os.Open(cfgPath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	var cfg config.Config
+	err = dec.Decode(&cfg)
+	if err == nil {
+		return "", nil
+	}
+	// The json package does not have an error type for unknown fields.
+	const prefix = "json: unknown field "
+	if msg := err.Error(); strings.HasPrefix(msg, prefix) {
+		return strings.Trim(strings.TrimPrefix(msg, prefix), `"`), nil
+	}
+	return "", err
+}
+
 func setLogLevels(cctx *cli.Context) error {
-	cl, err := httpclient.New(cctx.String("indexer"))
+	cl, err := httpclient.New(cctx.String("indexer"), adminClientOptions(cctx)...)
 	if err != nil {
 		return err
 	}
@@ -71,7 +150,7 @@ func setLogLevels(cctx *cli.Context) error {
 }
 
 func listLogSubSystems(cctx *cli.Context) error {
-	cl, err := httpclient.New(cctx.String("indexer"))
+	cl, err := httpclient.New(cctx.String("indexer"), adminClientOptions(cctx)...)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,77 @@
+package command
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/filecoin-project/storetheindex/config"
+	"github.com/urfave/cli/v2"
+)
+
+func TestConfigCheck(t *testing.T) {
+	cfgPath := filepath.Join(t.TempDir(), "config")
+	cfg, err := config.Init(io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = cfg.Save(cfgPath); err != nil {
+		t.Fatal(err)
+	}
+
+	var out, errOut bytes.Buffer
+	app := &cli.App{
+		Name:      "indexer",
+		Writer:    &out,
+		ErrWriter: &errOut,
+		Commands: []*cli.Command{
+			ConfigCmd,
+		},
+	}
+	ctx := context.Background()
+
+	if err = app.RunContext(ctx, []string{"storetheindex", "config", "check", cfgPath}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), `"Datastore"`) {
+		t.Fatal("expected config to be printed, got:", out.String())
+	}
+	if strings.Contains(errOut.String(), "WARNING") {
+		t.Fatal("expected no warnings, got:", errOut.String())
+	}
+
+	// An unknown field and a policy that allows no one are warned about.
+	data, err := os.ReadFile(cfgPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = bytes.Replace(data, []byte(`"Allow": true`), []byte(`"Allow": false`), 1)
+	data = bytes.Replace(data, []byte(`"Version"`), []byte(`"OldSetting": 1, "Version"`), 1)
+	if err = os.WriteFile(cfgPath, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	errOut.Reset()
+	if err = app.RunContext(ctx, []string{"storetheindex", "config", "check", cfgPath}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(errOut.String(), "WARNING: Discovery.Policy:") {
+		t.Fatal("expected policy warning, got:", errOut.String())
+	}
+	if !strings.Contains(errOut.String(), "WARNING: OldSetting:") {
+		t.Fatal("expected unknown field warning, got:", errOut.String())
+	}
+
+	// An invalid config is an error.
+	cfg.Ingest.IngestWorkerCount = -1
+	if err = cfg.Save(cfgPath); err != nil {
+		t.Fatal(err)
+	}
+	err = app.RunContext(ctx, []string{"storetheindex", "config", "check", cfgPath})
+	if err == nil || !strings.Contains(err.Error(), "IngestWorkerCount") {
+		t.Fatal("expected invalid config error, got:", err)
+	}
+}
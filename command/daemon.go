@@ -16,15 +16,22 @@ import (
 	"github.com/filecoin-project/go-indexer-core/store/memory"
 	"github.com/filecoin-project/go-indexer-core/store/pogreb"
 	"github.com/filecoin-project/go-indexer-core/store/storethehash"
+	v0 "github.com/filecoin-project/storetheindex/api/v0"
+	findermodel "github.com/filecoin-project/storetheindex/api/v0/finder/model"
 	"github.com/filecoin-project/storetheindex/config"
+	"github.com/filecoin-project/storetheindex/internal/cachewarm"
 	"github.com/filecoin-project/storetheindex/internal/ingest"
 	"github.com/filecoin-project/storetheindex/internal/lotus"
+	"github.com/filecoin-project/storetheindex/internal/metadedup"
 	"github.com/filecoin-project/storetheindex/internal/registry"
+	"github.com/filecoin-project/storetheindex/internal/safemode"
 	httpadminserver "github.com/filecoin-project/storetheindex/server/admin/http"
 	httpfinderserver "github.com/filecoin-project/storetheindex/server/finder/http"
 	p2pfinderserver "github.com/filecoin-project/storetheindex/server/finder/libp2p"
 	httpingestserver "github.com/filecoin-project/storetheindex/server/ingest/http"
 	p2pingestserver "github.com/filecoin-project/storetheindex/server/ingest/libp2p"
+	"github.com/ipfs/go-datastore"
+	badger "github.com/ipfs/go-ds-badger"
 	leveldb "github.com/ipfs/go-ds-leveldb"
 	"github.com/ipfs/go-ipfs/core/bootstrap"
 	"github.com/ipfs/go-ipfs/peering"
@@ -32,6 +39,7 @@ import (
 	"github.com/libp2p/go-libp2p"
 	"github.com/libp2p/go-libp2p-core/host"
 	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p/p2p/net/connmgr"
 	"github.com/multiformats/go-multiaddr"
 	manet "github.com/multiformats/go-multiaddr/net"
 	"github.com/urfave/cli/v2"
@@ -44,6 +52,12 @@ const (
 	vstoreStorethehash = "sth"
 )
 
+// Recognized datastore type names.
+const (
+	dstoreBadger  = "badgerds"
+	dstoreLevelDB = "levelds"
+)
+
 var log = logging.Logger("indexer")
 
 var (
@@ -78,10 +92,6 @@ func daemonCommand(cctx *cli.Context) error {
 		log.Warn("Configuration file out-of-date. Upgrade by running: ./storetheindex init --upgrade")
 	}
 
-	if cfg.Datastore.Type != "levelds" {
-		return fmt.Errorf("only levelds datastore type supported, %q not supported", cfg.Datastore.Type)
-	}
-
 	// Create a valuestore of the configured type.
 	valueStore, err := createValueStore(cctx.Context, cfg.Indexer)
 	if err != nil {
@@ -102,9 +112,6 @@ func daemonCommand(cctx *cli.Context) error {
 		log.Info("Result cache disabled")
 	}
 
-	// Create indexer core
-	indexerCore := engine.New(resultCache, valueStore)
-
 	// Create datastore
 	dataStorePath, err := config.Path("", cfg.Datastore.Dir)
 	if err != nil {
@@ -114,10 +121,39 @@ func daemonCommand(cctx *cli.Context) error {
 	if err != nil {
 		return err
 	}
-	dstore, err := leveldb.NewDatastore(dataStorePath, nil)
+	dstore, err := createDatastore(cfg.Datastore.Type, dataStorePath)
 	if err != nil {
 		return err
 	}
+	log.Infow("Datastore initialized", "type", cfg.Datastore.Type, "path", dataStorePath)
+
+	// Store each distinct metadata once, in the datastore, if configured.
+	var dedupStore *metadedup.ValueStore
+	if cfg.Indexer.DedupMetadata {
+		dedupStore = metadedup.New(valueStore, dstore)
+		valueStore = dedupStore
+		log.Info("Metadata deduplication enabled")
+	}
+
+	// Create indexer core
+	indexerCore := engine.New(resultCache, valueStore)
+
+	// Record the multihashes found by finder requests, so that those found
+	// before a restart are loaded back into the result cache, if configured.
+	var finderIndexer indexer.Interface = indexerCore
+	var cacheWarmer *cachewarm.Indexer
+	if cfg.Indexer.CacheWarmCount > 0 && resultCache != nil {
+		warmCount := cfg.Indexer.CacheWarmCount
+		if warmCount > cacheSize {
+			warmCount = cacheSize
+		}
+		cacheWarmer, err = cachewarm.New(indexerCore, dstore, warmCount)
+		if err != nil {
+			return fmt.Errorf("cannot create result cache warmer: %w", err)
+		}
+		finderIndexer = cacheWarmer
+		log.Infow("Result cache warming enabled", "count", warmCount)
+	}
 
 	var lotusDiscoverer *lotus.Discoverer
 	if cfg.Discovery.LotusGateway != "none" {
@@ -129,8 +165,16 @@ func daemonCommand(cctx *cli.Context) error {
 		}
 	}
 
+	// In safe mode, loading state from the datastore skips corrupt entries
+	// instead of failing startup.
+	var safeMode *safemode.Skipper
+	if cctx.Bool("safe-mode") {
+		safeMode = safemode.New()
+		log.Warn("Safe mode enabled, corrupt datastore entries are skipped")
+	}
+
 	// Create registry
-	reg, err := registry.NewRegistry(cctx.Context, cfg.Discovery, dstore, lotusDiscoverer)
+	reg, err := registry.NewRegistry(cctx.Context, cfg.Discovery, dstore, lotusDiscoverer, registry.WithSafeMode(safeMode))
 	if err != nil {
 		return fmt.Errorf("cannot create provider registry: %s", err)
 	}
@@ -146,7 +190,37 @@ func daemonCommand(cctx *cli.Context) error {
 		if err != nil {
 			return err
 		}
-		finderSvr, err = httpfinderserver.New(finderAddr.String(), indexerCore, reg)
+		peerID, _, err := cfg.Identity.Decode()
+		if err != nil {
+			return err
+		}
+		// Describe the other servers that are enabled, in info responses.
+		p2pEnabled := cfg.Addresses.P2PAddr != "none" && !cctx.Bool("nop2p")
+		ingestEnabled := cfg.Addresses.Ingest != "none" && !cctx.Bool("noingest")
+		var protocols []string
+		if p2pEnabled {
+			protocols = append(protocols, findermodel.ProtocolLibp2pFinder)
+		}
+		if ingestEnabled {
+			protocols = append(protocols, findermodel.ProtocolHttpIngest)
+			if p2pEnabled {
+				protocols = append(protocols, findermodel.ProtocolLibp2pIngest)
+			}
+		}
+		finderOpts := []httpfinderserver.ServerOption{
+			httpfinderserver.FindTimeout(time.Duration(cfg.Indexer.FindTimeout)),
+			httpfinderserver.BlockedStatus(cfg.Indexer.BlockedStatus451),
+			httpfinderserver.IndexerInfo(peerID, protocols),
+		}
+		if cfg.Indexer.SignFinderResponses {
+			_, privKey, err := cfg.Identity.Decode()
+			if err != nil {
+				return err
+			}
+			finderOpts = append(finderOpts, httpfinderserver.SignResponses(privKey))
+			log.Info("Finder responses are signed")
+		}
+		finderSvr, err = httpfinderserver.New(finderAddr.String(), finderIndexer, reg, finderOpts...)
 		if err != nil {
 			return err
 		}
@@ -183,18 +257,38 @@ func daemonCommand(cctx *cli.Context) error {
 			log.Info("libp2p resource manager disabled")
 			p2pOpts = append(p2pOpts, libp2p.ResourceManager(network.NullResourceManager))
 		}
+		if cfg.Addresses.ConnMgrHighWater > 0 {
+			cmgr, err := connmgr.NewConnManager(cfg.Addresses.ConnMgrLowWater, cfg.Addresses.ConnMgrHighWater,
+				connmgr.WithGracePeriod(time.Duration(cfg.Addresses.ConnMgrGracePeriod)))
+			if err != nil {
+				return fmt.Errorf("cannot create connection manager: %w", err)
+			}
+			p2pOpts = append(p2pOpts, libp2p.ConnectionManager(cmgr))
+		} else {
+			log.Info("libp2p connection manager disabled")
+		}
+		if cfg.Addresses.GateBlockedPeers {
+			p2pOpts = append(p2pOpts, libp2p.ConnectionGater(reg.ConnGater()))
+			log.Info("libp2p connections with blocked peers are rejected")
+		}
 
 		p2pHost, err = libp2p.New(p2pOpts...)
 		if err != nil {
 			return err
 		}
+		if cfg.Addresses.GateBlockedPeers {
+			reg.ConnGater().SetHost(p2pHost)
+		}
 
+		// The libp2p finder server shares the indexer and registry with the
+		// finder HTTP server, and is only run when finder HTTP server is.
 		if finderSvr != nil {
-			p2pfinderserver.New(ctx, p2pHost, indexerCore, reg)
+			p2pfinderserver.New(ctx, p2pHost, finderIndexer, reg)
+			log.Infow("libp2p finder server initialized", "protocol", v0.FinderProtocolID)
 		}
 
 		// Initialize ingester.
-		ingester, err = ingest.NewIngester(cfg.Ingest, p2pHost, indexerCore, reg, dstore)
+		ingester, err = ingest.NewIngester(cfg.Ingest, p2pHost, indexerCore, reg, dstore, ingest.WithSafeMode(safeMode))
 		if err != nil {
 			return err
 		}
@@ -238,12 +332,14 @@ func daemonCommand(cctx *cli.Context) error {
 		if err != nil {
 			return err
 		}
-		ingestSvr, err = httpingestserver.New(ingestAddr.String(), indexerCore, ingester, reg)
+		ingestSvr, err = httpingestserver.New(ingestAddr.String(), indexerCore, ingester, reg,
+			httpingestserver.MaxInFlight(cfg.Ingest.MaxInFlightRequests))
 		if err != nil {
 			return err
 		}
 		if cfg.Addresses.P2PAddr != "none" && !cctx.Bool("nop2p") {
 			p2pingestserver.New(ctx, p2pHost, indexerCore, ingester, reg)
+			log.Infow("libp2p ingest server initialized", "protocol", v0.IngestProtocolID)
 		}
 	}
 
@@ -260,10 +356,22 @@ func daemonCommand(cctx *cli.Context) error {
 		if err != nil {
 			return err
 		}
-		adminSvr, err = httpadminserver.New(adminAddr.String(), indexerCore, ingester, reg, reloadErrsChan)
+		adminOpts, err := adminServerOptions(cfg.AdminServer)
 		if err != nil {
 			return err
 		}
+		adminOpts = append(adminOpts, httpadminserver.WithAuditDatastore(dstore),
+			httpadminserver.WithImportJobDatastore(dstore),
+			httpadminserver.WithSafeMode(safeMode))
+		adminSvr, err = httpadminserver.New(adminAddr.String(), indexerCore, ingester, reg, reloadErrsChan, adminOpts...)
+		if err != nil {
+			return err
+		}
+	}
+
+	if safeMode != nil {
+		safeMode.LogSummary()
+		fmt.Println("Safe mode:\t skipped", safeMode.Total(), "corrupt datastore entries")
 	}
 
 	log.Info("Starting http servers")
@@ -406,26 +514,33 @@ func daemonCommand(cctx *cli.Context) error {
 		cancelP2pServers()
 	}
 
+	// Stop accepting requests on all servers, and wait for in-flight requests
+	// to finish, before closing the ingester that the requests may use.
+	var servers []namedServer
 	if ingestSvr != nil {
-		if err = ingestSvr.Shutdown(ctx); err != nil {
-			log.Errorw("Error shutting down ingest server", "err", err)
-			finalErr = ErrDaemonStop
-		}
+		servers = append(servers, namedServer{"ingest", ingestSvr})
 	}
 	if finderSvr != nil {
-		if err = finderSvr.Shutdown(ctx); err != nil {
-			log.Errorw("Error shutting down finder server", "err", err)
-			finalErr = ErrDaemonStop
-		}
+		servers = append(servers, namedServer{"finder", finderSvr})
 	}
 	if adminSvr != nil {
-		if err = adminSvr.Shutdown(ctx); err != nil {
-			log.Errorw("Error shutting down admin server", "err", err)
+		servers = append(servers, namedServer{"admin", adminSvr})
+	}
+	if !shutdownServers(ctx, servers) {
+		finalErr = ErrDaemonStop
+	}
+
+	// Save the record of found multihashes after the finder servers have
+	// stopped, and before the value store and datastore are closed.
+	if cacheWarmer != nil {
+		if err = cacheWarmer.Close(); err != nil {
+			log.Errorw("Error closing result cache warmer", "err", err)
 			finalErr = ErrDaemonStop
 		}
 	}
 
-	// If ingester set, close ingester
+	// Close the ingester after the servers, so that no new syncs are
+	// started. Closing the ingester waits for its workers and pending syncs.
 	if ingester != nil {
 		if err = ingester.Close(); err != nil {
 			log.Errorw("Error closing ingester", "err", err)
@@ -437,6 +552,14 @@ func daemonCommand(cctx *cli.Context) error {
 		log.Errorw("Error closing value store", "err", err)
 		finalErr = ErrDaemonStop
 	}
+	// Close the datastore last, since the ingester and registry write to it.
+	if err = dstore.Close(); err != nil {
+		log.Errorw("Error closing datastore", "err", err)
+		finalErr = ErrDaemonStop
+	}
+	if dedupStore != nil {
+		log.Infow("Metadata deduplication saved space in value store", "bytes", dedupStore.SavedBytes())
+	}
 
 	cancel()
 
@@ -444,6 +567,69 @@ func daemonCommand(cctx *cli.Context) error {
 	return finalErr
 }
 
+// adminServerOptions returns the admin server options for TLS and
+// authentication, with file locations resolved relative to the repo directory.
+// namedServer is a server that is shut down by shutdownServers.
+type namedServer struct {
+	name   string
+	server interface {
+		Shutdown(context.Context) error
+	}
+}
+
+// shutdownServers shuts down the servers concurrently, so that they all stop
+// accepting new requests at once, and waits until each server's in-flight
+// requests have finished or ctx is done. Returns false if any server did not
+// shut down cleanly.
+func shutdownServers(ctx context.Context, servers []namedServer) bool {
+	type result struct {
+		name string
+		err  error
+	}
+	results := make(chan result, len(servers))
+	for _, s := range servers {
+		go func(s namedServer) {
+			results <- result{s.name, s.server.Shutdown(ctx)}
+		}(s)
+	}
+
+	ok := true
+	for range servers {
+		r := <-results
+		if r.err != nil {
+			log.Errorw("Error shutting down "+r.name+" server", "err", r.err)
+			ok = false
+		}
+	}
+	return ok
+}
+
+func adminServerOptions(cfgAdmin config.AdminServer) ([]httpadminserver.ServerOption, error) {
+	var opts []httpadminserver.ServerOption
+	if cfgAdmin.TLSCertFile != "" {
+		certFile, err := config.Path("", cfgAdmin.TLSCertFile)
+		if err != nil {
+			return nil, err
+		}
+		keyFile, err := config.Path("", cfgAdmin.TLSKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, httpadminserver.WithTLS(certFile, keyFile))
+	}
+	if cfgAdmin.ClientCAFile != "" {
+		caFile, err := config.Path("", cfgAdmin.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, httpadminserver.WithClientCA(caFile))
+	}
+	if cfgAdmin.BearerToken != "" {
+		opts = append(opts, httpadminserver.WithBearerToken(cfgAdmin.BearerToken))
+	}
+	return opts, nil
+}
+
 func fileChanged(filePath string, modTime time.Time) (time.Time, bool, error) {
 	fi, err := os.Stat(filePath)
 	if err != nil {
@@ -478,6 +664,21 @@ func createValueStore(ctx context.Context, cfgIndexer config.Indexer) (indexer.I
 	return nil, fmt.Errorf("unrecognized store type: %s", cfgIndexer.ValueStoreType)
 }
 
+// createDatastore opens, or creates, the datastore of the given type at
+// path. Returns an error if path already holds a datastore of another type.
+func createDatastore(dsType, path string) (datastore.Batching, error) {
+	if existing := datastoreTypeInDir(path); existing != "" && existing != dsType {
+		return nil, fmt.Errorf("datastore directory %s holds a %s datastore, not %s: set Datastore.Dir to another directory to change the type", path, existing, dsType)
+	}
+	switch dsType {
+	case dstoreLevelDB:
+		return leveldb.NewDatastore(path, nil)
+	case dstoreBadger:
+		return badger.NewDatastore(path, &badger.DefaultOptions)
+	}
+	return nil, fmt.Errorf("unrecognized datastore type: %s", dsType)
+}
+
 func setLoggingConfig(cfgLogging config.Logging) error {
 	// Set overall log level.
 	err := logging.SetLogLevel("*", cfgLogging.Level)
@@ -492,7 +693,9 @@ func setLoggingConfig(cfgLogging config.Logging) error {
 			return err
 		}
 	}
-	return nil
+
+	// Levels given on the command line override the config.
+	return applyLogLevels(logLevelOverrides)
 }
 
 func loadConfig(filePath string) (*config.Config, error) {
@@ -504,8 +707,8 @@ func loadConfig(filePath string) (*config.Config, error) {
 		log.Warn("Configuration file out-of-date. Upgrade by running: ./storetheindex init --upgrade")
 	}
 
-	if cfg.Datastore.Type != "levelds" {
-		return nil, fmt.Errorf("only levelds datastore type supported, %q not supported", cfg.Datastore.Type)
+	if err = cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
 	}
 
 	return cfg, nil
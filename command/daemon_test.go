@@ -0,0 +1,82 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type testServer struct {
+	started *int32
+	wait    time.Duration
+	err     error
+}
+
+func (s *testServer) Shutdown(ctx context.Context) error {
+	atomic.AddInt32(s.started, 1)
+	select {
+	case <-time.After(s.wait):
+		return s.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func TestShutdownServers(t *testing.T) {
+	var started int32
+	servers := []namedServer{
+		{"a", &testServer{started: &started, wait: 50 * time.Millisecond}},
+		{"b", &testServer{started: &started, wait: 50 * time.Millisecond}},
+	}
+	start := time.Now()
+	if !shutdownServers(context.Background(), servers) {
+		t.Fatal("expected servers to shut down cleanly")
+	}
+	if started != 2 {
+		t.Fatalf("expected 2 servers shut down, got %d", started)
+	}
+	// Servers are shut down concurrently.
+	if elapsed := time.Since(start); elapsed >= 100*time.Millisecond {
+		t.Fatalf("servers not shut down concurrently, took %s", elapsed)
+	}
+
+	servers[1].server = &testServer{started: &started, err: errors.New("failed")}
+	if shutdownServers(context.Background(), servers) {
+		t.Fatal("expected failed shutdown")
+	}
+
+	// Shutdown gives up when the context is done.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	servers[0].server = &testServer{started: &started, wait: time.Minute}
+	if shutdownServers(ctx, servers) {
+		t.Fatal("expected shutdown to time out")
+	}
+}
+
+func TestCreateDatastoreTypeMismatch(t *testing.T) {
+	for _, types := range [][2]string{{dstoreLevelDB, dstoreBadger}, {dstoreBadger, dstoreLevelDB}} {
+		dir := t.TempDir()
+		ds, err := createDatastore(types[0], dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = ds.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err = createDatastore(types[1], dir); err == nil {
+			t.Fatalf("expected error opening %s datastore as %s", types[0], types[1])
+		}
+
+		ds, err = createDatastore(types[0], dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = ds.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
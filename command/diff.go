@@ -0,0 +1,129 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	httpclient "github.com/filecoin-project/storetheindex/api/v0/admin/client/http"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/urfave/cli/v2"
+)
+
+var DiffProviderCmd = &cli.Command{
+	Name:  "diff-provider",
+	Usage: "Compare two indexers' view of a provider",
+	Description: "Fetches the latest sync and the number of indexed multihashes for" +
+		" the provider from the admin server of each indexer, and reports where" +
+		" they differ. Exits with an error if the indexers do not agree.",
+	Flags:  diffProviderFlags,
+	Action: diffProviderCmd,
+}
+
+// providerView is one indexer's view of a provider.
+type providerView struct {
+	// LatestSync is the latest advertisement persisted as synced from the
+	// provider's publisher, or undefined if there is none.
+	LatestSync cid.Cid
+	// EntryCount is the number of multihashes indexed for the provider.
+	EntryCount uint64
+}
+
+func diffProviderCmd(cctx *cli.Context) error {
+	provID, err := peer.Decode(cctx.String("provider"))
+	if err != nil {
+		return err
+	}
+	pubID := provID
+	if cctx.String("publisher") != "" {
+		pubID, err = peer.Decode(cctx.String("publisher"))
+		if err != nil {
+			return err
+		}
+	}
+
+	hostA, hostB := cctx.String("a"), cctx.String("b")
+	viewA, err := getProviderView(cctx, hostA, provID, pubID)
+	if err != nil {
+		return fmt.Errorf("indexer %s: %w", hostA, err)
+	}
+	viewB, err := getProviderView(cctx, hostB, provID, pubID)
+	if err != nil {
+		return fmt.Errorf("indexer %s: %w", hostB, err)
+	}
+
+	fmt.Println("Provider", provID)
+	for _, v := range []struct {
+		host string
+		view providerView
+	}{{hostA, viewA}, {hostB, viewB}} {
+		fmt.Println("    Indexer", v.host)
+		fmt.Println("        Latest sync:", cidOrNone(v.view.LatestSync))
+		fmt.Println("        Entry count:", v.view.EntryCount)
+	}
+
+	diffs := diffProviderViews(viewA, viewB)
+	if len(diffs) == 0 {
+		fmt.Println("Indexers agree")
+		return nil
+	}
+	for _, d := range diffs {
+		fmt.Println("DIVERGENCE:", d)
+	}
+	return errors.New("indexers diverge")
+}
+
+// getProviderView fetches the view of the provider from the admin server of
+// the indexer at host.
+func getProviderView(cctx *cli.Context, host string, provID, pubID peer.ID) (providerView, error) {
+	var view providerView
+	cl, err := httpclient.New(host, adminClientOptions(cctx)...)
+	if err != nil {
+		return view, err
+	}
+	view.EntryCount, err = cl.ProviderEntryCount(cctx.Context, provID)
+	if err != nil {
+		return view, err
+	}
+	view.LatestSync, err = latestSync(cctx.Context, cl, pubID)
+	if err != nil {
+		return view, err
+	}
+	return view, nil
+}
+
+// latestSync returns the latest sync persisted by the indexer for the
+// publisher, or cid.Undef if the indexer has not synced with the publisher.
+func latestSync(ctx context.Context, cl *httpclient.Client, pubID peer.ID) (cid.Cid, error) {
+	states, err := cl.SyncState(ctx)
+	if err != nil {
+		return cid.Undef, err
+	}
+	for _, state := range states {
+		if state.PeerID == pubID {
+			return state.PersistedSync, nil
+		}
+	}
+	return cid.Undef, nil
+}
+
+// diffProviderViews returns a description of each way that the two views of a
+// provider differ.
+func diffProviderViews(a, b providerView) []string {
+	var diffs []string
+	if a.LatestSync != b.LatestSync {
+		diffs = append(diffs, fmt.Sprintf("latest sync differs: %s != %s", cidOrNone(a.LatestSync), cidOrNone(b.LatestSync)))
+	}
+	if a.EntryCount != b.EntryCount {
+		diffs = append(diffs, fmt.Sprintf("entry count differs: %d != %d", a.EntryCount, b.EntryCount))
+	}
+	return diffs
+}
+
+func cidOrNone(c cid.Cid) string {
+	if c == cid.Undef {
+		return "none"
+	}
+	return c.String()
+}
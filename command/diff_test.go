@@ -0,0 +1,37 @@
+package command
+
+import (
+	"testing"
+
+	"github.com/ipfs/go-cid"
+)
+
+func TestDiffProviderViews(t *testing.T) {
+	c1, err := cid.Decode("bafkqaaa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2, err := cid.Decode("QmWATWQ7fVPP2EFGu71UkfnqhYXDYH566qy47CnJDgvs8u")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := providerView{LatestSync: c1, EntryCount: 10}
+	if diffs := diffProviderViews(a, a); len(diffs) != 0 {
+		t.Fatalf("expected no divergence, got %v", diffs)
+	}
+
+	b := providerView{LatestSync: c2, EntryCount: 10}
+	if diffs := diffProviderViews(a, b); len(diffs) != 1 {
+		t.Fatalf("expected 1 divergence, got %v", diffs)
+	}
+
+	b.EntryCount = 9
+	if diffs := diffProviderViews(a, b); len(diffs) != 2 {
+		t.Fatalf("expected 2 divergences, got %v", diffs)
+	}
+
+	if diffs := diffProviderViews(a, providerView{EntryCount: 10}); len(diffs) != 1 {
+		t.Fatalf("expected divergence when one indexer has no sync, got %v", diffs)
+	}
+}
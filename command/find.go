@@ -1,12 +1,17 @@
 package command
 
 import (
+	"bufio"
 	"encoding/base64"
+	"errors"
 	"fmt"
+	"os"
+	"strings"
 
 	"github.com/filecoin-project/storetheindex/api/v0/finder/client"
 	httpclient "github.com/filecoin-project/storetheindex/api/v0/finder/client/http"
 	p2pclient "github.com/filecoin-project/storetheindex/api/v0/finder/client/libp2p"
+	"github.com/filecoin-project/storetheindex/api/v0/finder/model"
 	"github.com/ipfs/go-cid"
 	"github.com/libp2p/go-libp2p-core/peer"
 	"github.com/multiformats/go-multihash"
@@ -14,18 +19,33 @@ import (
 )
 
 var FindCmd = &cli.Command{
-	Name:   "find",
-	Usage:  "Find value by CID or multihash in indexer",
-	Flags:  findFlags,
-	Action: findCmd,
+	Name:      "find",
+	Usage:     "Find value by CID or multihash in indexer",
+	ArgsUsage: "[<cid>...]",
+	Flags:     findFlags,
+	Action:    findCmd,
 }
 
 func findCmd(cctx *cli.Context) error {
 	protocol := cctx.String("protocol")
 
 	mhArgs := cctx.StringSlice("mh")
-	cidArgs := cctx.StringSlice("cid")
+	cidArgs := append(cctx.StringSlice("cid"), cctx.Args().Slice()...)
+	if fileName := cctx.String("from-file"); fileName != "" {
+		fileCids, err := readCidFile(fileName)
+		if err != nil {
+			return err
+		}
+		cidArgs = append(cidArgs, fileCids...)
+	}
+	if len(mhArgs) == 0 && len(cidArgs) == 0 {
+		return errors.New("no multihash or cid specified")
+	}
+
 	mhs := make([]multihash.Multihash, 0, len(mhArgs)+len(cidArgs))
+	// Show results for the CIDs that were asked for, instead of for their
+	// multihashes.
+	mhCids := make(map[string][]string, len(cidArgs))
 	for i := range mhArgs {
 		m, err := multihash.FromB58String(mhArgs[i])
 		if err != nil {
@@ -36,9 +56,10 @@ func findCmd(cctx *cli.Context) error {
 	for i := range cidArgs {
 		c, err := cid.Decode(cidArgs[i])
 		if err != nil {
-			return err
+			return fmt.Errorf("cannot decode cid %q: %w", cidArgs[i], err)
 		}
 		mhs = append(mhs, c.Hash())
+		mhCids[string(c.Hash())] = append(mhCids[string(c.Hash())], c.String())
 	}
 
 	var cl client.Finder
@@ -75,6 +96,15 @@ func findCmd(cctx *cli.Context) error {
 		return err
 	}
 
+	if cctx.Bool("json") {
+		data, err := model.MarshalFindResponse(resp)
+		if err != nil {
+			return fmt.Errorf("cannot encode find response: %w", err)
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
 	if len(resp.MultihashResults) == 0 {
 		fmt.Println("index not found")
 		return nil
@@ -82,7 +112,17 @@ func findCmd(cctx *cli.Context) error {
 
 	fmt.Println("Content providers:")
 	for i := range resp.MultihashResults {
-		fmt.Println("   Multihash:", resp.MultihashResults[i].Multihash.B58String(), "==>")
+		mh := resp.MultihashResults[i].Multihash
+		if cids, ok := mhCids[string(mh)]; ok {
+			for _, c := range cids {
+				fmt.Println("   CID:", c, "==>")
+			}
+		} else {
+			fmt.Println("   Multihash:", mh.B58String(), "==>")
+		}
+		if resp.MultihashResults[i].Error != "" {
+			fmt.Println("       Error:", resp.MultihashResults[i].Error)
+		}
 		for _, pr := range resp.MultihashResults[i].ProviderResults {
 			fmt.Println("       Provider:", pr.Provider)
 			fmt.Println("       ContextID:", base64.StdEncoding.EncodeToString(pr.ContextID))
@@ -91,3 +131,27 @@ func findCmd(cctx *cli.Context) error {
 	}
 	return nil
 }
+
+// readCidFile reads CIDs from a file that has one CID per line. Empty lines,
+// and lines starting with "#", are ignored.
+func readCidFile(fileName string) ([]string, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var cids []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		cids = append(cids, line)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, fmt.Errorf("cannot read cid file: %w", err)
+	}
+	return cids, nil
+}
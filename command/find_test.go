@@ -0,0 +1,33 @@
+package command
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadCidFile(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "cids.txt")
+	data := "# CIDs to find\nbafkqaaa\n\n  QmWATWQ7fVPP2EFGu71UkfnqhYXDYH566qy47CnJDgvs8u  \n"
+	if err := os.WriteFile(fileName, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cids, err := readCidFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []string{"bafkqaaa", "QmWATWQ7fVPP2EFGu71UkfnqhYXDYH566qy47CnJDgvs8u"}
+	if len(cids) != len(expect) {
+		t.Fatalf("expected %d cids, got %d", len(expect), len(cids))
+	}
+	for i := range expect {
+		if cids[i] != expect[i] {
+			t.Errorf("expected cid %q, got %q", expect[i], cids[i])
+		}
+	}
+
+	if _, err = readCidFile(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Fatal("expected error reading missing file")
+	}
+}
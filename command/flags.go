@@ -2,7 +2,9 @@ package command
 
 import (
 	"fmt"
+	"time"
 
+	"github.com/filecoin-project/storetheindex/api/v0/httpclient"
 	"github.com/filecoin-project/storetheindex/config"
 	"github.com/multiformats/go-multiaddr"
 	"github.com/urfave/cli/v2"
@@ -36,6 +38,13 @@ var providerFlag = &cli.StringFlag{
 	Required: true,
 }
 
+var adminTokenFlag = &cli.StringFlag{
+	Name:     "admin-token",
+	Usage:    "Bearer token to authenticate with the indexer admin server, if it requires one",
+	EnvVars:  []string{"INDEXER_ADMIN_TOKEN"},
+	Required: false,
+}
+
 var daemonFlags = []cli.Flag{
 	cacheSizeFlag,
 	&cli.BoolFlag{
@@ -62,6 +71,12 @@ var daemonFlags = []cli.Flag{
 		Value:    false,
 		Required: false,
 	},
+	&cli.BoolFlag{
+		Name:     "safe-mode",
+		Usage:    "Skip datastore entries that cannot be read or decoded when loading state at startup, instead of failing, and report how many were skipped. Blocklist entries are never skipped",
+		Value:    false,
+		Required: false,
+	},
 	&cli.BoolFlag{
 		Name:     "watch-config",
 		Usage:    "Watch for changes to config file and automatically reload",
@@ -82,6 +97,11 @@ var findFlags = []cli.Flag{
 		Usage:    "Specify CID to use as indexer key, multiple OK",
 		Required: false,
 	},
+	&cli.StringFlag{
+		Name:     "from-file",
+		Usage:    "Read CIDs to use as indexer keys from file, one per line",
+		Required: false,
+	},
 	indexerHostFlag,
 	&cli.StringFlag{
 		Name:     "indexerid",
@@ -96,6 +116,11 @@ var findFlags = []cli.Flag{
 		Value:    "http",
 		Required: false,
 	},
+	&cli.BoolFlag{
+		Name:     "json",
+		Usage:    "Write the find response to stdout as JSON",
+		Required: false,
+	},
 }
 
 var importFlags = []cli.Flag{
@@ -114,6 +139,64 @@ var importFlags = []cli.Flag{
 	},
 	fileFlag,
 	indexerHostFlag,
+	adminTokenFlag,
+}
+
+var importDirFlags = []cli.Flag{
+	providerFlag,
+	&cli.StringFlag{
+		Name:     "ctxid",
+		Usage:    "Context ID of data imported",
+		Aliases:  []string{"c"},
+		Required: true,
+	},
+	&cli.StringFlag{
+		Name:     "metadata",
+		Usage:    "Bytes of opaque metadata corresponding to protocol 0",
+		Aliases:  []string{"m"},
+		Required: false,
+	},
+	&cli.StringFlag{
+		Name:     "dir",
+		Usage:    "Directory containing manifest files to import",
+		Aliases:  []string{"d"},
+		Required: true,
+	},
+	indexerHostFlag,
+	adminTokenFlag,
+}
+
+var importSnapshotFlags = []cli.Flag{
+	providerFlag,
+	&cli.StringFlag{
+		Name:     "dir",
+		Usage:    "Directory, on the indexer host, containing the CAR files to ingest",
+		Aliases:  []string{"d"},
+		Required: true,
+	},
+	&cli.StringFlag{
+		Name:  "head",
+		Usage: "CID of the latest advertisement in the chain. Found in the CAR files if not given",
+	},
+	indexerHostFlag,
+	adminTokenFlag,
+}
+
+var importJobStartFlags = append([]cli.Flag{
+	&cli.StringFlag{
+		Name:  "kind",
+		Usage: "Kind of file to import: manifest or cidlist",
+		Value: "manifest",
+	},
+	&cli.BoolFlag{
+		Name:  "wait",
+		Usage: "Wait for the job to finish, showing its progress",
+	},
+}, importFlags...)
+
+var importJobFlags = []cli.Flag{
+	indexerHostFlag,
+	adminTokenFlag,
 }
 
 var adminPolicyFlags = []cli.Flag{
@@ -124,14 +207,171 @@ var adminPolicyFlags = []cli.Flag{
 		Required: true,
 	},
 	indexerHostFlag,
+	adminTokenFlag,
 }
 
 var adminReloadConfigFlags = []cli.Flag{
 	indexerHostFlag,
+	adminTokenFlag,
+}
+
+var adminAuditFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:  "start",
+		Usage: "Only show requests made at or after this RFC 3339 time",
+	},
+	&cli.StringFlag{
+		Name:  "end",
+		Usage: "Only show requests made before this RFC 3339 time",
+	},
+	indexerHostFlag,
+	adminTokenFlag,
+}
+
+var adminFlushFlags = []cli.Flag{
+	indexerHostFlag,
+	adminTokenFlag,
+}
+
+var adminClearSyncFlags = []cli.Flag{
+	providerFlag,
+	indexerHostFlag,
+	adminTokenFlag,
+}
+
+var adminEntryCountFlags = []cli.Flag{
+	providerFlag,
+	indexerHostFlag,
+	adminTokenFlag,
+}
+
+var adminPrefixScanFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:     "prefix",
+		Usage:    "Hex-encoded bytes that the multihashes begin with",
+		Required: true,
+	},
+	&cli.IntFlag{
+		Name:  "limit",
+		Usage: "Maximum number of multihashes to list. Zero uses the indexer's default of 100",
+	},
+	indexerHostFlag,
+	adminTokenFlag,
+}
+
+var adminAliasFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:  "alias",
+		Usage: "Peer ID that the provider publishes advertisements under",
+	},
+	&cli.StringFlag{
+		Name:    "provider",
+		Usage:   "Canonical peer ID of the provider that the alias is attributed to",
+		Aliases: []string{"p"},
+	},
+	&cli.BoolFlag{
+		Name:  "remove",
+		Usage: "Remove the alias",
+	},
+	indexerHostFlag,
+	adminTokenFlag,
+}
+
+var adminTagsFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:     "provider",
+		Usage:    "Peer ID of the provider",
+		Aliases:  []string{"p"},
+		Required: true,
+	},
+	&cli.StringSliceFlag{
+		Name:  "set",
+		Usage: "Tag to give the provider, replacing its existing tags. Repeat to give multiple tags",
+	},
+	&cli.BoolFlag{
+		Name:  "clear",
+		Usage: "Remove all of the provider's tags",
+	},
+	indexerHostFlag,
+	adminTokenFlag,
+}
+
+var adminBlocklistFlags = []cli.Flag{
+	&cli.StringSliceFlag{
+		Name:  "add",
+		Usage: "Multihash or CID to add to the blocklist. May be repeated",
+	},
+	&cli.StringSliceFlag{
+		Name:  "remove",
+		Usage: "Multihash or CID to remove from the blocklist. May be repeated",
+	},
+	indexerHostFlag,
+	adminTokenFlag,
+}
+
+var adminMetadataOverrideFlags = []cli.Flag{
+	providerFlag,
+	&cli.StringFlag{
+		Name:     "context",
+		Usage:    "Base64 encoded context ID, as shown in find results",
+		Required: true,
+	},
+	&cli.StringFlag{
+		Name:  "metadata",
+		Usage: "Base64 encoded metadata to return in find results",
+	},
+	&cli.BoolFlag{
+		Name:  "clear",
+		Usage: "Clear the metadata override",
+	},
+	indexerHostFlag,
+	adminTokenFlag,
+}
+
+var diffProviderFlags = []cli.Flag{
+	providerFlag,
+	&cli.StringFlag{
+		Name:     "publisher",
+		Usage:    "Peer ID of the provider's publisher, if different from the provider",
+		Required: false,
+	},
+	&cli.StringFlag{
+		Name:     "a",
+		Usage:    "Host or host:port of the first indexer's admin server",
+		Required: true,
+	},
+	&cli.StringFlag{
+		Name:     "b",
+		Usage:    "Host or host:port of the second indexer's admin server",
+		Required: true,
+	},
+	adminTokenFlag,
+}
+
+var adminRetryFailuresFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:    "provider",
+		Usage:   "Only retry the failed advertisements of this provider's peer ID",
+		Aliases: []string{"p"},
+	},
+	indexerHostFlag,
+	adminTokenFlag,
+}
+
+var adminSyncHistoryFlags = []cli.Flag{
+	providerFlag,
+	indexerHostFlag,
+	adminTokenFlag,
+}
+
+var adminSyncStateFlags = []cli.Flag{
+	indexerHostFlag,
+	adminTokenFlag,
 }
 
 var adminSyncFlags = []cli.Flag{
 	indexerHostFlag,
+	adminTokenFlag,
 	&cli.StringFlag{
 		Name:     "pubid",
 		Usage:    "Publisher peer ID",
@@ -151,6 +391,10 @@ var adminSyncFlags = []cli.Flag{
 		Usage: "Ignore the latest synced advertisement and sync advertisements as far back as the depth limit allows.",
 		Value: false,
 	},
+	&cli.DurationFlag{
+		Name:  "since",
+		Usage: "Resync advertisements that the indexer first processed within this long ago, such as 24h. The depth limit applies where processing times are not known.",
+	},
 }
 
 var initFlags = []cli.Flag{
@@ -217,6 +461,10 @@ var providersGetFlags = []cli.Flag{
 }
 
 var providersListFlags = []cli.Flag{
+	&cli.StringSliceFlag{
+		Name:  "tag",
+		Usage: "Only list providers that have this tag. Repeat to only list providers that have all the tags",
+	},
 	indexerHostFlag,
 }
 
@@ -228,6 +476,7 @@ var importProvidersFlags = []cli.Flag{
 		Required: true,
 	},
 	indexerHostFlag,
+	adminTokenFlag,
 }
 
 var registerFlags = []cli.Flag{
@@ -245,6 +494,94 @@ var registerFlags = []cli.Flag{
 	},
 }
 
+var replayCarFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:     "provider",
+		Usage:    "Peer ID of provider whose indexed content is reported",
+		Aliases:  []string{"p"},
+		Required: true,
+	},
+	&cli.StringFlag{
+		Name:     "head",
+		Usage:    "CID of head advertisement to replay from. Default is first root in CAR header",
+		Required: false,
+	},
+	&cli.DurationFlag{
+		Name:     "timeout",
+		Usage:    "Maximum time to allow for replay to complete",
+		Value:    10 * time.Minute,
+		Required: false,
+	},
+}
+
+var exportRegistryFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:     "file",
+		Usage:    "File to write the exported registry to",
+		Aliases:  []string{"f"},
+		Required: true,
+	},
+}
+
+var importRegistryFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:     "file",
+		Usage:    "File written by export-registry to import",
+		Aliases:  []string{"f"},
+		Required: true,
+	},
+	&cli.BoolFlag{
+		Name:     "overwrite",
+		Usage:    "Overwrite providers and latest syncs that are already in the datastore",
+		Value:    false,
+		Required: false,
+	},
+	&cli.BoolFlag{
+		Name:     "policy",
+		Usage:    "Also replace the policy and poll overrides in the config file with the imported ones",
+		Value:    false,
+		Required: false,
+	},
+}
+
+var benchmarkStoreFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:     "type",
+		Usage:    "Type of value store to benchmark (sth, pogreb, memory). Default is the type in the config file",
+		Required: false,
+	},
+	&cli.StringFlag{
+		Name:     "dir",
+		Usage:    "Directory in which to create the temporary value store",
+		Required: false,
+	},
+	&cli.IntFlag{
+		Name:     "num",
+		Usage:    "Number of synthetic multihashes to put",
+		Aliases:  []string{"n"},
+		Value:    100000,
+		Required: false,
+	},
+	&cli.IntFlag{
+		Name:     "batch",
+		Usage:    "Number of multihashes in each put",
+		Value:    config.NewIngest().StoreBatchSize,
+		Required: false,
+	},
+	&cli.IntFlag{
+		Name:     "gets",
+		Usage:    "Number of random gets of the multihashes that were put",
+		Value:    100000,
+		Required: false,
+	},
+	&cli.IntFlag{
+		Name:     "providers",
+		Usage:    "Number of synthetic providers that the batches are put for",
+		Value:    10,
+		Required: false,
+	},
+}
+
 var syntheticFlags = []cli.Flag{
 	fileFlag,
 	&cli.StringFlag{
@@ -265,9 +602,24 @@ var syntheticFlags = []cli.Flag{
 		Aliases:  []string{"s"},
 		Required: false,
 	},
+	&cli.StringFlag{
+		Name:  "entries",
+		Usage: "Kind of advertisement entries to generate for the car type (chunk, hamt)",
+		Value: "chunk",
+	},
 }
 
 // cliIndexer reads the indexer host from CLI flag or from config.
+// adminClientOptions returns the options for connecting to the admin server,
+// from the command line.
+func adminClientOptions(cctx *cli.Context) []httpclient.Option {
+	var opts []httpclient.Option
+	if token := cctx.String("admin-token"); token != "" {
+		opts = append(opts, httpclient.BearerToken(token))
+	}
+	return opts
+}
+
 func cliIndexer(cctx *cli.Context, addrType string) string {
 	idxr := cctx.String("indexer")
 	if idxr != "" {
@@ -57,3 +57,17 @@ func fileExists(filename string) bool {
 	}
 	return false
 }
+
+// datastoreTypeInDir returns the type of the datastore kept in dir, or an
+// empty string if dir does not hold a datastore of a known type. A Badger
+// datastore has a MANIFEST file, and a LevelDB datastore has a CURRENT file
+// that names its numbered manifest.
+func datastoreTypeInDir(dir string) string {
+	if fileExists(filepath.Join(dir, "MANIFEST")) {
+		return dstoreBadger
+	}
+	if fileExists(filepath.Join(dir, "CURRENT")) {
+		return dstoreLevelDB
+	}
+	return ""
+}
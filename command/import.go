@@ -1,10 +1,14 @@
 package command
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"time"
 
 	httpclient "github.com/filecoin-project/storetheindex/api/v0/admin/client/http"
+	"github.com/filecoin-project/storetheindex/api/v0/admin/model"
+	"github.com/ipfs/go-cid"
 	"github.com/libp2p/go-libp2p-core/peer"
 	"github.com/urfave/cli/v2"
 )
@@ -30,6 +34,69 @@ var importManifest = &cli.Command{
 	Action: importManifestCmd,
 }
 
+var importManifestDir = &cli.Command{
+	Name:   "manifest-dir",
+	Usage:  "Import every manifest file in a directory",
+	Flags:  importDirFlags,
+	Action: importManifestDirCmd,
+}
+
+var importSnapshot = &cli.Command{
+	Name:  "snapshot",
+	Usage: "Ingest a provider's advertisement chain from a directory of CAR files",
+	Description: "Tells the indexer to read every CAR file in a directory on the" +
+		" indexer host, and to ingest the advertisement chain in them using the" +
+		" same sync and ingest logic as for an online provider. This rebuilds" +
+		" the index of a provider that is offline.",
+	Flags:  importSnapshotFlags,
+	Action: importSnapshotCmd,
+}
+
+var importJob = &cli.Command{
+	Name:  "job",
+	Usage: "Start and manage resumable import jobs",
+	Description: "An import job imports a manifest or cidlist file on the indexer" +
+		" host in the background, so the import does not depend on the" +
+		" connection to the indexer. The job's progress is stored by the" +
+		" indexer, so a job resumes where it stopped if the indexer restarts," +
+		" or when it is resumed after failing or being canceled.",
+	Subcommands: []*cli.Command{
+		{
+			Name:   "start",
+			Usage:  "Start a job that imports a file",
+			Flags:  importJobStartFlags,
+			Action: importJobStartCmd,
+		},
+		{
+			Name:   "list",
+			Usage:  "List import jobs",
+			Flags:  importJobFlags,
+			Action: importJobListCmd,
+		},
+		{
+			Name:      "status",
+			Usage:     "Show the status and progress of an import job",
+			ArgsUsage: "<job-id>",
+			Flags:     importJobFlags,
+			Action:    importJobStatusCmd,
+		},
+		{
+			Name:      "resume",
+			Usage:     "Resume a failed or canceled import job from where it stopped",
+			ArgsUsage: "<job-id>",
+			Flags:     importJobFlags,
+			Action:    importJobResumeCmd,
+		},
+		{
+			Name:      "cancel",
+			Usage:     "Cancel a running import job",
+			ArgsUsage: "<job-id>",
+			Flags:     importJobFlags,
+			Action:    importJobCancelCmd,
+		},
+	},
+}
+
 var ImportCmd = &cli.Command{
 	Name:  "import",
 	Usage: "Imports data directly into indexer, bypassing ingestion process",
@@ -37,13 +104,16 @@ var ImportCmd = &cli.Command{
 		importCidList,
 		importCar,
 		importManifest,
+		importManifestDir,
+		importSnapshot,
+		importJob,
 	},
 }
 
 func importListCmd(cctx *cli.Context) error {
 	// NOTE: Importing manually from CLI only supported for http protocol
 	// for now. This feature is mainly for testing purposes
-	cl, err := httpclient.New(cliIndexer(cctx, "admin"))
+	cl, err := httpclient.New(cliIndexer(cctx, "admin"), adminClientOptions(cctx)...)
 	if err != nil {
 		return err
 	}
@@ -69,7 +139,7 @@ func importCarCmd(c *cli.Context) error {
 }
 
 func importManifestCmd(cctx *cli.Context) error {
-	cl, err := httpclient.New(cliIndexer(cctx, "admin"))
+	cl, err := httpclient.New(cliIndexer(cctx, "admin"), adminClientOptions(cctx)...)
 	if err != nil {
 		return err
 	}
@@ -91,3 +161,162 @@ func importManifestCmd(cctx *cli.Context) error {
 	fmt.Println("Indexer imported manifest file")
 	return nil
 }
+
+func importManifestDirCmd(cctx *cli.Context) error {
+	cl, err := httpclient.New(cliIndexer(cctx, "admin"), adminClientOptions(cctx)...)
+	if err != nil {
+		return err
+	}
+	prov := cctx.String("provider")
+	p, err := peer.Decode(prov)
+	if err != nil {
+		return err
+	}
+	dirName := cctx.String("dir")
+
+	fmt.Println("Telling indexer to import manifest files in directory:", dirName)
+	result, err := cl.ImportFromManifestDir(cctx.Context, dirName, p, []byte(cctx.String("ctxid")), []byte(cctx.String("metadata")))
+	if err != nil {
+		return err
+	}
+	for _, fr := range result.Files {
+		if fr.Error != "" {
+			fmt.Printf("  %s: FAILED after %d CIDs: %s\n", fr.File, fr.Imported, fr.Error)
+			continue
+		}
+		fmt.Printf("  %s: imported %d CIDs\n", fr.File, fr.Imported)
+	}
+	fmt.Printf("Indexer imported %d CIDs from %d manifest files, %d files failed\n",
+		result.TotalImported, len(result.Files)-result.Failed, result.Failed)
+	return nil
+}
+
+func importSnapshotCmd(cctx *cli.Context) error {
+	cl, err := httpclient.New(cliIndexer(cctx, "admin"), adminClientOptions(cctx)...)
+	if err != nil {
+		return err
+	}
+	p, err := peer.Decode(cctx.String("provider"))
+	if err != nil {
+		return err
+	}
+	head := cid.Undef
+	if headStr := cctx.String("head"); headStr != "" {
+		head, err = cid.Decode(headStr)
+		if err != nil {
+			return fmt.Errorf("bad head cid: %w", err)
+		}
+	}
+	dirName := cctx.String("dir")
+
+	fmt.Println("Telling indexer to ingest snapshot in directory:", dirName)
+	result, err := cl.ImportFromSnapshot(cctx.Context, dirName, p, head)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Indexer read %d blocks from %d car files, latest processed advertisement: %s\n",
+		result.Blocks, result.Files, result.LatestProcessed)
+	return nil
+}
+
+func importJobStartCmd(cctx *cli.Context) error {
+	cl, err := httpclient.New(cliIndexer(cctx, "admin"), adminClientOptions(cctx)...)
+	if err != nil {
+		return err
+	}
+	p, err := peer.Decode(cctx.String("provider"))
+	if err != nil {
+		return err
+	}
+	job, err := cl.StartImportJob(cctx.Context, model.ImportJobRequest{
+		Kind:      cctx.String("kind"),
+		Provider:  p,
+		File:      cctx.String("file"),
+		ContextID: []byte(cctx.String("ctxid")),
+		Metadata:  []byte(cctx.String("metadata")),
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Println("Started import job", job.ID)
+	if !cctx.Bool("wait") {
+		return nil
+	}
+
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	for job.Status == model.ImportJobRunning {
+		select {
+		case <-ticker.C:
+		case <-cctx.Context.Done():
+			return cctx.Context.Err()
+		}
+		job, err = cl.ImportJob(cctx.Context, job.ID)
+		if err != nil {
+			return err
+		}
+		fmt.Println("Imported", job.Imported, "CIDs")
+	}
+	printImportJob(job)
+	if job.Status == model.ImportJobFailed {
+		return errors.New("import job failed")
+	}
+	return nil
+}
+
+func importJobListCmd(cctx *cli.Context) error {
+	cl, err := httpclient.New(cliIndexer(cctx, "admin"), adminClientOptions(cctx)...)
+	if err != nil {
+		return err
+	}
+	jobs, err := cl.ListImportJobs(cctx.Context)
+	if err != nil {
+		return err
+	}
+	if len(jobs) == 0 {
+		fmt.Println("No import jobs")
+		return nil
+	}
+	for i := range jobs {
+		printImportJob(&jobs[i])
+	}
+	return nil
+}
+
+func importJobStatusCmd(cctx *cli.Context) error {
+	return importJobAction(cctx, (*httpclient.Client).ImportJob)
+}
+
+func importJobResumeCmd(cctx *cli.Context) error {
+	return importJobAction(cctx, (*httpclient.Client).ResumeImportJob)
+}
+
+func importJobCancelCmd(cctx *cli.Context) error {
+	return importJobAction(cctx, (*httpclient.Client).CancelImportJob)
+}
+
+// importJobAction calls the client function with the job ID argument, and
+// prints the job that it returns.
+func importJobAction(cctx *cli.Context, action func(*httpclient.Client, context.Context, string) (*model.ImportJob, error)) error {
+	if cctx.NArg() != 1 {
+		return errors.New("exactly one job ID argument is required")
+	}
+	cl, err := httpclient.New(cliIndexer(cctx, "admin"), adminClientOptions(cctx)...)
+	if err != nil {
+		return err
+	}
+	job, err := action(cl, cctx.Context, cctx.Args().First())
+	if err != nil {
+		return err
+	}
+	printImportJob(job)
+	return nil
+}
+
+func printImportJob(job *model.ImportJob) {
+	fmt.Printf("%s: %s import of %s for provider %s\n", job.ID, job.Kind, job.File, job.Provider)
+	fmt.Printf("  Status: %s, imported %d CIDs, updated %s\n", job.Status, job.Imported, job.Updated.Format(time.RFC3339))
+	if job.Error != "" {
+		fmt.Println("  Error:", job.Error)
+	}
+}
@@ -37,6 +37,9 @@ func initCommand(cctx *cli.Context) error {
 		if err != nil {
 			return err
 		}
+		if err = cfg.Validate(); err != nil {
+			return fmt.Errorf("invalid config: %w", err)
+		}
 		prevVer := cfg.Version
 		err = cfg.UpgradeConfig(configFile)
 		if err != nil {
@@ -117,5 +120,9 @@ func initCommand(cctx *cli.Context) error {
 		cfg.Ingest.PubSubTopic = topic
 	}
 
+	if err = cfg.Validate(); err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+
 	return cfg.Save(configFile)
 }
@@ -0,0 +1,80 @@
+package command
+
+import (
+	"fmt"
+	"strings"
+
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/urfave/cli/v2"
+)
+
+// LogLevelFlag sets the log levels of individual logging subsystems for any
+// command.
+var LogLevelFlag = &cli.StringFlag{
+	Name:     "log-level",
+	Usage:    "Comma-separated subsystem=level pairs that set log levels, e.g. indexer/ingest=debug,findserver=warn. Use * as the subsystem to set all levels",
+	Required: false,
+}
+
+// logLevelOverrides holds the log levels set by LogLevelFlag. These are
+// applied after the log levels from the config file, so that they take
+// precedence.
+var logLevelOverrides map[string]string
+
+// SetLogLevels applies the log levels given by LogLevelFlag. This is run
+// before any command.
+func SetLogLevels(cctx *cli.Context) error {
+	levels, err := parseLogLevels(cctx.String(LogLevelFlag.Name))
+	if err != nil {
+		return err
+	}
+	logLevelOverrides = levels
+	return applyLogLevels(levels)
+}
+
+// parseLogLevels parses comma-separated subsystem=level pairs.
+func parseLogLevels(s string) (map[string]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	levels := map[string]string{}
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("bad log level %q, expected subsystem=level", pair)
+		}
+		subsystem := strings.TrimSpace(parts[0])
+		level := strings.TrimSpace(parts[1])
+		if subsystem == "" || level == "" {
+			return nil, fmt.Errorf("bad log level %q, expected subsystem=level", pair)
+		}
+		if _, err := logging.LevelFromString(level); err != nil {
+			return nil, fmt.Errorf("bad log level for %s: %w", subsystem, err)
+		}
+		levels[subsystem] = level
+	}
+	return levels, nil
+}
+
+// applyLogLevels sets the given log levels. A level for "*" is set first, so
+// that the levels for individual subsystems are not replaced by it.
+func applyLogLevels(levels map[string]string) error {
+	if level, ok := levels["*"]; ok {
+		if err := logging.SetLogLevel("*", level); err != nil {
+			return err
+		}
+	}
+	for subsystem, level := range levels {
+		if subsystem == "*" {
+			continue
+		}
+		if err := logging.SetLogLevel(subsystem, level); err != nil {
+			return fmt.Errorf("cannot set log level for %s: %w", subsystem, err)
+		}
+	}
+	return nil
+}
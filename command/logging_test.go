@@ -0,0 +1,39 @@
+package command
+
+import (
+	"testing"
+)
+
+func TestParseLogLevels(t *testing.T) {
+	levels, err := parseLogLevels("indexer/ingest=debug, findserver=warn,,*=error")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := map[string]string{
+		"indexer/ingest": "debug",
+		"findserver":     "warn",
+		"*":              "error",
+	}
+	if len(levels) != len(expect) {
+		t.Fatalf("expected %d levels, got %d", len(expect), len(levels))
+	}
+	for subsystem, level := range expect {
+		if levels[subsystem] != level {
+			t.Errorf("expected level %q for %s, got %q", level, subsystem, levels[subsystem])
+		}
+	}
+
+	levels, err = parseLogLevels("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(levels) != 0 {
+		t.Fatal("expected no levels")
+	}
+
+	for _, bad := range []string{"debug", "=debug", "indexer/ingest=", "indexer/ingest=loud"} {
+		if _, err = parseLogLevels(bad); err == nil {
+			t.Errorf("expected error parsing %q", bad)
+		}
+	}
+}
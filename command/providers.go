@@ -2,6 +2,7 @@ package command
 
 import (
 	"fmt"
+	"strings"
 
 	httpclient "github.com/filecoin-project/storetheindex/api/v0/finder/client/http"
 	"github.com/filecoin-project/storetheindex/api/v0/finder/model"
@@ -59,7 +60,7 @@ func listProvidersCmd(cctx *cli.Context) error {
 	if err != nil {
 		return err
 	}
-	provs, err := cl.ListProviders(cctx.Context)
+	provs, err := cl.ListProvidersWithTags(cctx.Context, cctx.StringSlice("tag")...)
 	if err != nil {
 		return err
 	}
@@ -80,4 +81,7 @@ func showProviderInfo(pinfo *model.ProviderInfo) {
 	fmt.Println("    Addresses:", pinfo.AddrInfo.Addrs)
 	fmt.Println("    LastAdvertisement:", pinfo.LastAdvertisement)
 	fmt.Println("    LastAdvertisementTime:", pinfo.LastAdvertisementTime)
+	if len(pinfo.Tags) != 0 {
+		fmt.Println("    Tags:", strings.Join(pinfo.Tags, ", "))
+	}
 }
@@ -0,0 +1,141 @@
+package command
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/filecoin-project/storetheindex/config"
+	"github.com/filecoin-project/storetheindex/internal/ingest"
+	"github.com/filecoin-project/storetheindex/internal/registry"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/urfave/cli/v2"
+)
+
+var ExportRegistryCmd = &cli.Command{
+	Name:  "export-registry",
+	Usage: "Export the provider registry and latest syncs to a JSON file",
+	Description: "Writes the registered providers, the latest sync for each" +
+		" publisher, and the discovery policy and poll overrides to a JSON" +
+		" file that can be imported by import-registry to stand up a" +
+		" replacement indexer. The indexer daemon must not be running.",
+	Flags:  exportRegistryFlags,
+	Action: exportRegistryCmd,
+}
+
+var ImportRegistryCmd = &cli.Command{
+	Name:  "import-registry",
+	Usage: "Import the provider registry and latest syncs from a JSON file",
+	Description: "Reads a file written by export-registry and stores the" +
+		" providers and latest syncs in the indexer datastore. Providers and" +
+		" publishers that are already known are skipped unless --overwrite is" +
+		" given. The indexer daemon must not be running.",
+	Flags:  importRegistryFlags,
+	Action: importRegistryCmd,
+}
+
+// registryExport is the content of the file written by export-registry.
+type registryExport struct {
+	Providers     []*registry.ProviderInfo
+	LatestSyncs   map[peer.ID]cid.Cid
+	Policy        config.Policy
+	PollOverrides []config.Polling `json:",omitempty"`
+}
+
+func exportRegistryCmd(cctx *cli.Context) error {
+	cfg, err := loadConfig("")
+	if err != nil {
+		return err
+	}
+	dstore, err := openDatastore(cfg)
+	if err != nil {
+		return err
+	}
+	defer dstore.Close()
+
+	providers, err := registry.ReadProviders(cctx.Context, dstore)
+	if err != nil {
+		return fmt.Errorf("cannot read providers: %w", err)
+	}
+	latestSyncs, err := ingest.ReadLatestSyncs(cctx.Context, dstore)
+	if err != nil {
+		return fmt.Errorf("cannot read latest syncs: %w", err)
+	}
+
+	export := registryExport{
+		Providers:     providers,
+		LatestSyncs:   latestSyncs,
+		Policy:        cfg.Discovery.Policy,
+		PollOverrides: cfg.Discovery.PollOverrides,
+	}
+	data, err := json.MarshalIndent(&export, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.WriteFile(cctx.String("file"), data, 0644); err != nil {
+		return err
+	}
+
+	fmt.Printf("Exported %d providers and %d latest syncs to %s\n", len(providers), len(latestSyncs), cctx.String("file"))
+	return nil
+}
+
+func importRegistryCmd(cctx *cli.Context) error {
+	data, err := os.ReadFile(cctx.String("file"))
+	if err != nil {
+		return err
+	}
+	var export registryExport
+	if err = json.Unmarshal(data, &export); err != nil {
+		return fmt.Errorf("cannot decode registry file: %w", err)
+	}
+
+	cfg, err := loadConfig("")
+	if err != nil {
+		return err
+	}
+	dstore, err := openDatastore(cfg)
+	if err != nil {
+		return err
+	}
+	defer dstore.Close()
+
+	overwrite := cctx.Bool("overwrite")
+	provCount, err := registry.WriteProviders(cctx.Context, dstore, export.Providers, overwrite)
+	if err != nil {
+		return fmt.Errorf("cannot write providers: %w", err)
+	}
+	syncCount, err := ingest.WriteLatestSyncs(cctx.Context, dstore, export.LatestSyncs, overwrite)
+	if err != nil {
+		return fmt.Errorf("cannot write latest syncs: %w", err)
+	}
+	fmt.Printf("Imported %d of %d providers and %d of %d latest syncs\n", provCount, len(export.Providers), syncCount, len(export.LatestSyncs))
+
+	if cctx.Bool("policy") {
+		cfg.Discovery.Policy = export.Policy
+		cfg.Discovery.PollOverrides = export.PollOverrides
+		if err = cfg.Save(""); err != nil {
+			return fmt.Errorf("cannot save config: %w", err)
+		}
+		fmt.Println("Updated config with imported policy and poll overrides")
+	}
+	return nil
+}
+
+// openDatastore opens the indexer datastore for use by an offline command.
+func openDatastore(cfg *config.Config) (datastore.Batching, error) {
+	dataStorePath, err := config.Path("", cfg.Datastore.Dir)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = os.Stat(dataStorePath); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("datastore not found at %s", dataStorePath)
+		}
+		return nil, err
+	}
+	return createDatastore(cfg.Datastore.Type, dataStorePath)
+}
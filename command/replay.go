@@ -0,0 +1,252 @@
+package command
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/filecoin-project/go-indexer-core/engine"
+	"github.com/filecoin-project/go-indexer-core/store/memory"
+	"github.com/filecoin-project/go-legs/dtsync"
+	"github.com/filecoin-project/storetheindex/api/v0/ingest/schema"
+	"github.com/filecoin-project/storetheindex/config"
+	"github.com/filecoin-project/storetheindex/internal/importer"
+	"github.com/filecoin-project/storetheindex/internal/ingest"
+	"github.com/filecoin-project/storetheindex/internal/registry"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	dssync "github.com/ipfs/go-datastore/sync"
+	hamt "github.com/ipld/go-ipld-adl-hamt"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/bindnode"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/multiformats/go-multihash"
+	"github.com/urfave/cli/v2"
+)
+
+var ReplayCarCmd = &cli.Command{
+	Name:      "replay-car",
+	Usage:     "Replay an advertisement chain from a CAR file through the ingest pipeline",
+	ArgsUsage: "<car-file>",
+	Description: "Loads an advertisement chain and its entries from a CAR file, and" +
+		" ingests it into a temporary in-memory indexer using the same sync and" +
+		" ingest logic as the daemon. No network access is needed. This is" +
+		" intended for reproducing ingestion problems offline.",
+	Flags:  replayCarFlags,
+	Action: replayCarCmd,
+}
+
+func replayCarCmd(cctx *cli.Context) error {
+	fileName := cctx.Args().First()
+	if fileName == "" {
+		return errors.New("missing car file argument")
+	}
+	providerID, err := peer.Decode(cctx.String("provider"))
+	if err != nil {
+		return fmt.Errorf("bad provider id: %w", err)
+	}
+
+	// Read CAR blocks into the store served by the local publisher.
+	blockStore := dssync.MutexWrap(datastore.NewMapDatastore())
+	f, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	roots, blockCount, err := importer.ReadCar(cctx.Context, f, blockStore)
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	var headCid cid.Cid
+	if headStr := cctx.String("head"); headStr != "" {
+		headCid, err = cid.Decode(headStr)
+		if err != nil {
+			return fmt.Errorf("bad head cid: %w", err)
+		}
+	} else if len(roots) != 0 {
+		headCid = roots[0]
+	} else {
+		return errors.New("car file has no roots, specify head advertisement with --head")
+	}
+	fmt.Printf("Read %d blocks from %s, head advertisement: %s\n", blockCount, fileName, headCid)
+
+	ingestCfg := config.NewIngest()
+	ingestCfg.IngestWorkerCount = 1
+	ingestCfg.RateLimit = config.RateLimit{}
+
+	// Create a temporary indexer and ingester using in-memory stores.
+	indexerCore := engine.New(nil, memory.New())
+	reg, err := registry.NewRegistry(cctx.Context, config.NewDiscovery(), nil, nil)
+	if err != nil {
+		return err
+	}
+	defer reg.Close()
+
+	ingHost, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	if err != nil {
+		return err
+	}
+	defer ingHost.Close()
+
+	ingester, err := ingest.NewIngester(ingestCfg, ingHost, indexerCore, reg, dssync.MutexWrap(datastore.NewMapDatastore()))
+	if err != nil {
+		return err
+	}
+	defer ingester.Close()
+
+	// Serve the CAR blocks from a local publisher so that the ingester syncs
+	// them exactly as it would from a real publisher.
+	pubHost, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	if err != nil {
+		return err
+	}
+	defer pubHost.Close()
+
+	// The publisher keeps its data-transfer state in a separate datastore from
+	// the CAR blocks.
+	pubDS := dssync.MutexWrap(datastore.NewMapDatastore())
+	pub, err := dtsync.NewPublisher(pubHost, pubDS, mkStoreLinkSystem(blockStore), ingestCfg.PubSubTopic)
+	if err != nil {
+		return err
+	}
+	defer pub.Close()
+
+	if err = pub.SetRoot(cctx.Context, headCid); err != nil {
+		return err
+	}
+
+	ingHost.Peerstore().AddAddrs(pubHost.ID(), pubHost.Addrs(), time.Hour)
+	if err = ingHost.Connect(cctx.Context, pubHost.Peerstore().PeerInfo(pubHost.ID())); err != nil {
+		return fmt.Errorf("cannot connect to local publisher: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(cctx.Context, cctx.Duration("timeout"))
+	defer cancel()
+
+	wait, err := ingester.Sync(ctx, pubHost.ID(), nil, 0, false)
+	if err != nil {
+		return err
+	}
+	<-wait
+
+	latest, err := ingester.GetLatestSync(pubHost.ID())
+	if err != nil {
+		return err
+	}
+	fmt.Println("Latest processed advertisement:", latest)
+	if latest != headCid {
+		fmt.Fprintln(os.Stderr, "Replay did not process all advertisements, check log for ingest errors")
+	}
+
+	// Report how many of the multihashes in the CAR entries were indexed for
+	// the provider.
+	total, indexed, err := countIndexed(cctx.Context, blockStore, indexerCore, providerID)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Indexed %d of %d multihashes in entries for provider %s\n", indexed, total, providerID)
+	return nil
+}
+
+func mkStoreLinkSystem(ds datastore.Batching) ipld.LinkSystem {
+	lsys := cidlink.DefaultLinkSystem()
+	lsys.StorageReadOpener = func(lctx ipld.LinkContext, lnk ipld.Link) (io.Reader, error) {
+		c := lnk.(cidlink.Link).Cid
+		val, err := ds.Get(lctx.Ctx, datastore.NewKey(c.String()))
+		if err != nil {
+			return nil, err
+		}
+		return bytes.NewBuffer(val), nil
+	}
+	lsys.StorageWriteOpener = func(lctx ipld.LinkContext) (io.Writer, ipld.BlockWriteCommitter, error) {
+		buf := bytes.NewBuffer(nil)
+		return buf, func(lnk ipld.Link) error {
+			c := lnk.(cidlink.Link).Cid
+			return ds.Put(lctx.Ctx, datastore.NewKey(c.String()), buf.Bytes())
+		}, nil
+	}
+	return lsys
+}
+
+// countIndexed checks every entry chunk and HAMT of entries in the datastore,
+// and counts how many of their multihashes are indexed for the given provider.
+func countIndexed(ctx context.Context, ds datastore.Batching, indexerCore *engine.Engine, providerID peer.ID) (int, int, error) {
+	lsys := mkStoreLinkSystem(ds)
+	results, err := ds.Query(ctx, query.Query{KeysOnly: true})
+	if err != nil {
+		return 0, 0, err
+	}
+	defer results.Close()
+
+	var total, indexed int
+	for result := range results.Next() {
+		if result.Error != nil {
+			return 0, 0, result.Error
+		}
+		c, err := cid.Decode(datastore.RawKey(result.Key).BaseNamespace())
+		if err != nil {
+			continue
+		}
+		for _, mh := range entriesMultihashes(ctx, lsys, c) {
+			total++
+			values, found, err := indexerCore.Get(mh)
+			if err != nil || !found {
+				continue
+			}
+			for _, v := range values {
+				if v.ProviderID == providerID {
+					indexed++
+					break
+				}
+			}
+		}
+	}
+	return total, indexed, nil
+}
+
+// entriesMultihashes returns the multihashes in the block if it is an entry
+// chunk or the root of a HAMT of entries, and nil for any other block.
+func entriesMultihashes(ctx context.Context, lsys ipld.LinkSystem, c cid.Cid) []multihash.Multihash {
+	lnk := cidlink.Link{Cid: c}
+	n, err := lsys.Load(ipld.LinkContext{Ctx: ctx}, lnk, schema.EntryChunkPrototype)
+	if err == nil {
+		chunk, err := schema.UnwrapEntryChunk(n)
+		if err != nil {
+			return nil
+		}
+		return chunk.Entries
+	}
+
+	n, err = lsys.Load(ipld.LinkContext{Ctx: ctx}, lnk, hamt.HashMapRootPrototype)
+	if err != nil {
+		return nil
+	}
+	root, ok := bindnode.Unwrap(n).(*hamt.HashMapRoot)
+	if !ok || root == nil {
+		return nil
+	}
+	hn := hamt.Node{
+		HashMapRoot: *root,
+	}.WithLinking(lsys, schema.Linkproto)
+	var mhs []multihash.Multihash
+	for it := hn.MapIterator(); !it.Done(); {
+		k, _, err := it.Next()
+		if err != nil {
+			return nil
+		}
+		ks, err := k.AsString()
+		if err != nil {
+			return nil
+		}
+		mhs = append(mhs, multihash.Multihash(ks))
+	}
+	return mhs
+}
@@ -2,6 +2,7 @@ package command
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -10,16 +11,37 @@ import (
 	"time"
 
 	agg "github.com/filecoin-project/go-dagaggregator-unixfs"
+	"github.com/filecoin-project/storetheindex/api/v0/ingest/schema"
+	"github.com/filecoin-project/storetheindex/internal/importer"
 	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	hamt "github.com/ipld/go-ipld-adl-hamt"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/multiformats/go-multicodec"
 	"github.com/multiformats/go-multihash"
+	"github.com/multiformats/go-varint"
 	"github.com/urfave/cli/v2"
 )
 
 const DAG_MAX = 3200000
 
+// syntheticChunkSize is the number of multihashes in each entry chunk of a
+// synthetic advertisement.
+const syntheticChunkSize = 16384
+
 var SyntheticCmd = &cli.Command{
-	Name:   "synthetic",
-	Usage:  "Generate synthetic load to import in indexer",
+	Name:  "synthetic",
+	Usage: "Generate synthetic load to import in indexer",
+	Description: "The car type writes a CAR file holding a single signed" +
+		" advertisement, from a new random provider, with --num synthetic" +
+		" multihashes. The advertisement's entries are a chain of entry chunks," +
+		" or a HAMT if --entries is hamt. The CAR file can be ingested with the" +
+		" \"import snapshot\" command, from a directory holding the file, or" +
+		" with the \"replay-car\" command, using the provider ID that is printed.",
 	Flags:  syntheticFlags,
 	Action: syntheticCmd,
 }
@@ -39,8 +61,139 @@ func syntheticCmd(c *cli.Context) error {
 		return genManifest(fileName, num, size)
 	case "cidlist":
 		return genCidList(fileName, num, size)
+	case "car":
+		if num == 0 {
+			return errors.New("number of multihashes must be provided for car type")
+		}
+		return genAdCar(c.Context, fileName, num, c.String("entries"))
+	}
+	return errors.New("export type not implemented, try types manifest, cidlist, or car")
+}
+
+func genAdCar(ctx context.Context, fileName string, num int, entriesKind string) error {
+	fmt.Printf("Generating advertisement with %d multihashes in %s entries\n", num, entriesKind)
+	providerID, adCid, err := writeAdCar(ctx, fileName, num, entriesKind)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Created car file successfully")
+	fmt.Println("Provider:", providerID)
+	fmt.Println("Advertisement:", adCid)
+	return nil
+}
+
+// writeAdCar writes a CAR file holding an advertisement, signed by a new
+// random provider, whose entries are num synthetic multihashes in a chain of
+// entry chunks or in a HAMT. The advertisement is the root of the CAR file.
+func writeAdCar(ctx context.Context, fileName string, num int, entriesKind string) (peer.ID, cid.Cid, error) {
+	blockStore := dssync.MutexWrap(datastore.NewMapDatastore())
+	lsys := mkStoreLinkSystem(blockStore)
+
+	mhs, err := randomMultihashes(num)
+	if err != nil {
+		return "", cid.Undef, err
+	}
+	var entries ipld.Link
+	switch entriesKind {
+	case "chunk":
+		entries, err = storeEntryChunks(ctx, lsys, mhs)
+	case "hamt":
+		entries, err = storeHamtEntries(ctx, lsys, mhs)
+	default:
+		return "", cid.Undef, fmt.Errorf("unknown entries kind %q, try chunk or hamt", entriesKind)
+	}
+	if err != nil {
+		return "", cid.Undef, fmt.Errorf("cannot store entries: %w", err)
+	}
+
+	priv, _, err := crypto.GenerateEd25519Key(nil)
+	if err != nil {
+		return "", cid.Undef, err
+	}
+	providerID, err := peer.IDFromPrivateKey(priv)
+	if err != nil {
+		return "", cid.Undef, err
+	}
+	ad := schema.Advertisement{
+		Provider:  providerID.String(),
+		Addresses: []string{"/ip4/127.0.0.1/tcp/9999"},
+		Entries:   entries,
+		ContextID: []byte("synthetic"),
+		Metadata:  varint.ToUvarint(uint64(multicodec.TransportBitswap)),
+	}
+	if err = ad.Sign(priv); err != nil {
+		return "", cid.Undef, fmt.Errorf("cannot sign advertisement: %w", err)
+	}
+	node, err := ad.ToNode()
+	if err != nil {
+		return "", cid.Undef, err
+	}
+	adLink, err := lsys.Store(ipld.LinkContext{Ctx: ctx}, schema.Linkproto, node)
+	if err != nil {
+		return "", cid.Undef, fmt.Errorf("cannot store advertisement: %w", err)
+	}
+	adCid := adLink.(cidlink.Link).Cid
+
+	file, err := os.Create(fileName)
+	if err != nil {
+		return "", cid.Undef, err
+	}
+	defer file.Close()
+	if _, err = importer.WriteCar(ctx, file, []cid.Cid{adCid}, blockStore); err != nil {
+		return "", cid.Undef, fmt.Errorf("cannot write car file: %w", err)
+	}
+	return providerID, adCid, file.Close()
+}
+
+// storeEntryChunks stores the multihashes as a chain of entry chunks, and
+// returns the link to the first chunk.
+func storeEntryChunks(ctx context.Context, lsys ipld.LinkSystem, mhs []multihash.Multihash) (ipld.Link, error) {
+	var next ipld.Link
+	for end := len(mhs); end > 0; end -= syntheticChunkSize {
+		start := end - syntheticChunkSize
+		if start < 0 {
+			start = 0
+		}
+		chunk := schema.EntryChunk{
+			Entries: mhs[start:end],
+			Next:    next,
+		}
+		node, err := chunk.ToNode()
+		if err != nil {
+			return nil, err
+		}
+		next, err = lsys.Store(ipld.LinkContext{Ctx: ctx}, schema.Linkproto, node)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return next, nil
+}
+
+// storeHamtEntries stores the multihashes as the keys of a HAMT, and returns
+// the link to the HAMT root.
+func storeHamtEntries(ctx context.Context, lsys ipld.LinkSystem, mhs []multihash.Multihash) (ipld.Link, error) {
+	hb := hamt.NewBuilder(hamt.Prototype{
+		BitWidth:   5,
+		BucketSize: 3,
+	}).WithLinking(lsys, schema.Linkproto)
+	ma, err := hb.BeginMap(int64(len(mhs)))
+	if err != nil {
+		return nil, err
+	}
+	for _, mh := range mhs {
+		if err = ma.AssembleKey().AssignBytes(mh); err != nil {
+			return nil, err
+		}
+		if err = ma.AssembleValue().AssignBool(true); err != nil {
+			return nil, err
+		}
+	}
+	if err = ma.Finish(); err != nil {
+		return nil, err
 	}
-	return errors.New("export type not implemented, try types manifest or cidlist")
+	root := hb.Build().(*hamt.Node).Substrate()
+	return lsys.Store(ipld.LinkContext{Ctx: ctx}, schema.Linkproto, root)
 }
 
 func genCidList(fileName string, num int, size int) error {
@@ -0,0 +1,78 @@
+package command
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/filecoin-project/go-indexer-core/engine"
+	"github.com/filecoin-project/go-indexer-core/store/memory"
+	"github.com/filecoin-project/storetheindex/api/v0/ingest/schema"
+	"github.com/filecoin-project/storetheindex/internal/importer"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+)
+
+func TestWriteAdCar(t *testing.T) {
+	ctx := context.Background()
+	// More multihashes than fit in one chunk are used, so that there is a
+	// chain of chunks. Building a HAMT is slower, so it has fewer.
+	for kind, num := range map[string]int{"chunk": syntheticChunkSize + 100, "hamt": 1000} {
+		kind, num := kind, num
+		t.Run(kind, func(t *testing.T) {
+			fileName := filepath.Join(t.TempDir(), "ad.car")
+			providerID, adCid, err := writeAdCar(ctx, fileName, num, kind)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			f, err := os.Open(fileName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			ds := dssync.MutexWrap(datastore.NewMapDatastore())
+			roots, _, err := importer.ReadCar(ctx, f, ds)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(roots) != 1 || roots[0] != adCid {
+				t.Fatal("expected advertisement as car root, got", roots)
+			}
+
+			lsys := mkStoreLinkSystem(ds)
+			n, err := lsys.Load(ipld.LinkContext{Ctx: ctx}, cidlink.Link{Cid: adCid}, schema.AdvertisementPrototype)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ad, err := schema.UnwrapAdvertisement(n)
+			if err != nil {
+				t.Fatal(err)
+			}
+			signerID, err := ad.VerifySignature()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if signerID != providerID || ad.Provider != providerID.String() {
+				t.Fatal("advertisement not signed by provider")
+			}
+
+			// All of the multihashes are found in the entries, and none are
+			// indexed yet.
+			total, indexed, err := countIndexed(ctx, ds, engine.New(nil, memory.New()), providerID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if total != num || indexed != 0 {
+				t.Fatalf("expected %d multihashes with none indexed, got %d with %d indexed", num, total, indexed)
+			}
+		})
+	}
+
+	if _, _, err := writeAdCar(ctx, filepath.Join(t.TempDir(), "ad.car"), 1, "list"); err == nil {
+		t.Fatal("expected error for unknown entries kind")
+	}
+}
@@ -1,5 +1,9 @@
 package config
 
+import (
+	"time"
+)
+
 // Addresses stores the (string) multiaddr addresses for the node.
 type Addresses struct {
 	// Admin is the admin http listen address. Set to "none" to disable this
@@ -12,10 +16,30 @@ type Addresses struct {
 	// to disable this server for both http and libp2p.
 	Ingest string
 	// P2PMaddr is the libp2p host multiaddr for all servers. Set to "none" to
-	// disable libp2p hosting.
+	// disable libp2p hosting. The finder server is served on this host using
+	// the protocol ID "/indexer/finder/0.0.1", and the ingest server using
+	// "/indexer/ingest/0.0.1".
 	P2PAddr string
 	// NoResourceManager disables the libp2p resource manager when true.
 	NoResourceManager bool
+	// GateBlockedPeers rejects libp2p connections with peers that are
+	// explicitly blocked by the Discovery.Policy, and closes existing
+	// connections with peers when they become blocked. Only peers listed as
+	// exceptions when the policy allows peers by default, or blocked with the
+	// admin API, are explicitly blocked.
+	GateBlockedPeers bool
+	// ConnMgrHighWater is the number of libp2p connections above which the
+	// connection manager closes connections, until there are ConnMgrLowWater
+	// connections. Connections to peers that are being synced are not
+	// closed. The value -1 disables the connection manager.
+	ConnMgrHighWater int
+	// ConnMgrLowWater is the number of libp2p connections that the
+	// connection manager keeps when closing connections.
+	ConnMgrLowWater int
+	// ConnMgrGracePeriod is the time that a new connection is kept before it
+	// can be closed by the connection manager. Values are a number ending in
+	// "s", "m", "h" for seconds, minutes, hours.
+	ConnMgrGracePeriod Duration
 }
 
 // NewAddresses returns Addresses with values set to their defaults.
@@ -25,6 +49,10 @@ func NewAddresses() Addresses {
 		Finder:  "/ip4/0.0.0.0/tcp/3000",
 		Ingest:  "/ip4/0.0.0.0/tcp/3001",
 		P2PAddr: "/ip4/0.0.0.0/tcp/3003",
+
+		ConnMgrHighWater:   900,
+		ConnMgrLowWater:    600,
+		ConnMgrGracePeriod: Duration(20 * time.Second),
 	}
 }
 
@@ -44,4 +72,13 @@ func (c *Addresses) populateUnset() {
 	if c.P2PAddr == "" {
 		c.P2PAddr = def.P2PAddr
 	}
+	if c.ConnMgrHighWater == 0 {
+		c.ConnMgrHighWater = def.ConnMgrHighWater
+	}
+	if c.ConnMgrLowWater == 0 {
+		c.ConnMgrLowWater = def.ConnMgrLowWater
+	}
+	if c.ConnMgrGracePeriod == 0 {
+		c.ConnMgrGracePeriod = def.ConnMgrGracePeriod
+	}
 }
@@ -0,0 +1,29 @@
+package config
+
+// AdminServer configures access to the admin HTTP server. The admin API can
+// remove providers and change policy, so access to it should be restricted if
+// it listens on an address that is reachable by others.
+type AdminServer struct {
+	// TLSCertFile is the PEM-encoded certificate file used to serve the admin
+	// API over HTTPS. TLSKeyFile must also be set. If not an absolute path,
+	// then the location is relative to the indexer repo directory. Leave
+	// empty to serve plain HTTP.
+	TLSCertFile string
+	// TLSKeyFile is the PEM-encoded private key file for TLSCertFile. If not
+	// an absolute path, then the location is relative to the indexer repo
+	// directory.
+	TLSKeyFile string
+	// ClientCAFile is a PEM-encoded file of CA certificates. If set, every
+	// admin request must be made with a client certificate signed by one of
+	// these CAs. This requires TLS. If not an absolute path, then the
+	// location is relative to the indexer repo directory.
+	ClientCAFile string
+	// BearerToken, if set, requires every admin request to have the header
+	// "Authorization: Bearer <BearerToken>".
+	BearerToken string
+}
+
+// NewAdminServer returns AdminServer with values set to their defaults.
+func NewAdminServer() AdminServer {
+	return AdminServer{}
+}
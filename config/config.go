@@ -12,16 +12,17 @@ import (
 
 // Config is used to load config files.
 type Config struct {
-	Version   int       // config version
-	Identity  Identity  // peer identity
-	Addresses Addresses // addresses to listen on
-	Bootstrap Bootstrap // Peers to connect to for gossip
-	Datastore Datastore // datastore config
-	Discovery Discovery // provider pubsub peers
-	Indexer   Indexer   // indexer code configuration
-	Ingest    Ingest    // ingestion related configuration.
-	Logging   Logging   // logging configuration.
-	Peering   Peering   // peering service configuration.
+	Version     int         // config version
+	Identity    Identity    // peer identity
+	Addresses   Addresses   // addresses to listen on
+	AdminServer AdminServer // admin server access configuration
+	Bootstrap   Bootstrap   // Peers to connect to for gossip
+	Datastore   Datastore   // datastore config
+	Discovery   Discovery   // provider pubsub peers
+	Indexer     Indexer     // indexer code configuration
+	Ingest      Ingest      // ingestion related configuration.
+	Logging     Logging     // logging configuration.
+	Peering     Peering     // peering service configuration.
 }
 
 const (
@@ -101,14 +102,15 @@ func Load(filePath string) (*Config, error) {
 
 	// Populate with initial values in case they are not present in config.
 	cfg := Config{
-		Addresses: NewAddresses(),
-		Bootstrap: NewBootstrap(),
-		Datastore: NewDatastore(),
-		Discovery: NewDiscovery(),
-		Indexer:   NewIndexer(),
-		Ingest:    NewIngest(),
-		Logging:   NewLogging(),
-		Peering:   NewPeering(),
+		Addresses:   NewAddresses(),
+		AdminServer: NewAdminServer(),
+		Bootstrap:   NewBootstrap(),
+		Datastore:   NewDatastore(),
+		Discovery:   NewDiscovery(),
+		Indexer:     NewIndexer(),
+		Ingest:      NewIngest(),
+		Logging:     NewLogging(),
+		Peering:     NewPeering(),
 	}
 
 	if err = json.NewDecoder(f).Decode(&cfg); err != nil {
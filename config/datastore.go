@@ -6,7 +6,10 @@ type Datastore struct {
 	// absolute path then the location is relative to the indexer repo
 	// directory.
 	Dir string
-	// Type is the type of datastore.
+	// Type is the type of datastore, which is either "levelds" for a LevelDB
+	// datastore or "badgerds" for a Badger datastore. Changing the type does
+	// not migrate an existing datastore, and the indexer does not start if
+	// Dir holds a datastore of another type, so Dir must also be changed.
 	Type string
 }
 
@@ -29,10 +29,27 @@ type Discovery struct {
 	PollStopAfter Duration
 	// PollOverrides configures polling for specific providers.
 	PollOverrides []Polling
+	// ReachabilityInterval is the amount of time between checks that dial
+	// the TCP addresses of each registered provider, to find providers that
+	// are not reachable. Find requests can ask to exclude or deprioritize
+	// unreachable providers. Values are a number ending in "s", "m", "h" for
+	// seconds. minutes, hours. Zero disables reachability checks.
+	ReachabilityInterval Duration
+	// ReachabilityTimeout is the maximum amount of time to wait for a
+	// connection to one of a provider's addresses when checking
+	// reachability.
+	ReachabilityTimeout Duration
 	// RediscoverWait is the amount of time that must pass before a provider
 	// can be discovered following a previous discovery attempt. A value of 0
 	// means there is no wait time.
 	RediscoverWait Duration
+	// TimerJitterPercent is the maximum percentage of the period that is
+	// randomly added to each period of the registry's periodic work, such as
+	// polling, reachability checks, and allowlist refreshes. This keeps the
+	// periodic work of indexers that started together from being
+	// synchronized. The value -1 disables jitter and zero means use the
+	// default value.
+	TimerJitterPercent int
 	// Timeout is the maximum amount of time that the indexer will spend trying
 	// to discover and verify a new provider.
 	Timeout Duration
@@ -55,13 +72,15 @@ type Polling struct {
 // NewDiscovery returns Discovery with values set to their defaults.
 func NewDiscovery() Discovery {
 	return Discovery{
-		LotusGateway:   "https://api.chain.love",
-		Policy:         NewPolicy(),
-		PollInterval:   Duration(24 * time.Hour),
-		PollRetryAfter: Duration(5 * time.Hour),
-		PollStopAfter:  Duration(7 * 24 * time.Hour),
-		RediscoverWait: Duration(5 * time.Minute),
-		Timeout:        Duration(2 * time.Minute),
+		LotusGateway:        "https://api.chain.love",
+		Policy:              NewPolicy(),
+		PollInterval:        Duration(24 * time.Hour),
+		PollRetryAfter:      Duration(5 * time.Hour),
+		PollStopAfter:       Duration(7 * 24 * time.Hour),
+		ReachabilityTimeout: Duration(10 * time.Second),
+		RediscoverWait:      Duration(5 * time.Minute),
+		TimerJitterPercent:  10,
+		Timeout:             Duration(2 * time.Minute),
 	}
 }
 
@@ -69,6 +88,8 @@ func NewDiscovery() Discovery {
 func (c *Discovery) populateUnset() {
 	def := NewDiscovery()
 
+	c.Policy.populateUnset()
+
 	if c.PollInterval == 0 {
 		c.PollInterval = def.PollInterval
 	}
@@ -81,4 +102,10 @@ func (c *Discovery) populateUnset() {
 	if c.Timeout == 0 {
 		c.Timeout = def.Timeout
 	}
+	if c.ReachabilityTimeout == 0 {
+		c.ReachabilityTimeout = def.ReachabilityTimeout
+	}
+	if c.TimerJitterPercent == 0 {
+		c.TimerJitterPercent = def.TimerJitterPercent
+	}
 }
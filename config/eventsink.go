@@ -0,0 +1,36 @@
+package config
+
+// EventSink configures publishing an event to an external message bus each
+// time an advertisement finishes processing.
+type EventSink struct {
+	// URL is the connection string for the message bus. A URL with the
+	// "nats" or "tls" scheme publishes events to a NATS server, for example
+	// "nats://127.0.0.1:4222". An empty URL disables publishing events.
+	URL string
+	// Subject is the subject, or topic, that events are published to.
+	Subject string
+	// BufferSize is the number of events held while waiting to be published.
+	// When the buffer is full, events are dropped, so that a slow or
+	// unavailable message bus never holds up ingestion.
+	BufferSize int
+}
+
+// NewEventSink returns EventSink with values set to their defaults.
+func NewEventSink() EventSink {
+	return EventSink{
+		Subject:    "storetheindex.ingest",
+		BufferSize: 1024,
+	}
+}
+
+// populateUnset replaces zero-values in the config with default values.
+func (c *EventSink) populateUnset() {
+	def := NewEventSink()
+
+	if c.Subject == "" {
+		c.Subject = def.Subject
+	}
+	if c.BufferSize == 0 {
+		c.BufferSize = def.BufferSize
+	}
+}
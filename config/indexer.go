@@ -7,16 +7,47 @@ import (
 // Indexer holds configuration for the indexer core. Setting any of these items
 // to their zero-value configures the default value.
 type Indexer struct {
+	// BlockedStatus451, if true, makes the finder HTTP server respond with
+	// 451 (Unavailable For Legal Reasons), instead of 404 (Not Found), to
+	// find requests for multihashes that are all on the admin blocklist.
+	BlockedStatus451 bool
 	// Maximum number of CIDs that cache can hold. Setting to -1 disables the
 	// cache.
 	CacheSize int
+	// CacheWarmCount is the number of the most recently found multihashes
+	// that are recorded, and saved in the datastore, so that they are loaded
+	// back into the cache when the indexer restarts. This lets the indexer
+	// serve frequently requested content from the cache soon after a restart.
+	// The count is limited to CacheSize. The value zero disables cache
+	// warming.
+	CacheWarmCount int
 	// ConfigCheckInterval is the time between config file update checks.
 	ConfigCheckInterval Duration
+	// DedupMetadata, if true, stores each distinct metadata once in the
+	// datastore, and stores a reference to it in the value store instead of
+	// storing the metadata with each value. This saves space when many
+	// advertisements have identical metadata. Values stored before this is
+	// enabled are still read, but values stored while it is enabled cannot be
+	// read if it is disabled later.
+	DedupMetadata bool
+	// FindTimeout is the maximum time that the finder HTTP server spends
+	// handling a find request. If the value store lookups for the request do
+	// not finish in this time, then the request fails with 504 (Gateway
+	// Timeout).
+	FindTimeout Duration
 	// GCInterval configures the garbage collection interval for valuestores
 	// that support it.
 	GCInterval Duration
+	// SignFinderResponses, if true, makes the finder HTTP server sign its
+	// responses with the indexer's identity key, so that clients can verify
+	// that responses came from this indexer. Find results cannot be streamed
+	// when responses are signed. See model.VerifyResponse in the
+	// api/v0/finder/model package for how to verify a response.
+	SignFinderResponses bool
 	// ShutdownTimeout is the duration that a graceful shutdown has to complete
-	// before the daemon process is terminated.
+	// before the daemon process is terminated. On shutdown, the servers stop
+	// accepting requests and in-flight requests are allowed to finish, and
+	// then the ingester is closed, all within this time.
 	ShutdownTimeout Duration
 	// Directory where value store is kept. If this is not an absolute path
 	// then the location is relative to the indexer repo directory.
@@ -30,6 +61,7 @@ func NewIndexer() Indexer {
 	return Indexer{
 		CacheSize:           300000,
 		ConfigCheckInterval: Duration(30 * time.Second),
+		FindTimeout:         Duration(20 * time.Second),
 		GCInterval:          Duration(30 * time.Minute),
 		ShutdownTimeout:     Duration(10 * time.Second),
 		ValueStoreDir:       "valuestore",
@@ -47,6 +79,9 @@ func (c *Indexer) populateUnset() {
 	if c.ConfigCheckInterval == 0 {
 		c.ConfigCheckInterval = def.ConfigCheckInterval
 	}
+	if c.FindTimeout == 0 {
+		c.FindTimeout = def.FindTimeout
+	}
 	if c.GCInterval == 0 {
 		c.GCInterval = def.GCInterval
 	}
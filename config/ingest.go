@@ -6,6 +6,12 @@ import (
 
 // Ingest tracks the configuration related to the ingestion protocol.
 type Ingest struct {
+	// AdCacheSize is the maximum number of decoded advertisements kept in
+	// memory, so that advertisements loaded more than once during sync and
+	// ingestion are not decoded again. The least recently used advertisement
+	// is evicted when the cache is full. The value -1 disables the cache and
+	// zero means use the default value.
+	AdCacheSize int
 	// AdvertisementDepthLimit is the total maximum recursion depth limit when
 	// syncing advertisements. The value -1 means no limit and zero means use
 	// the default value. Limiting the depth of advertisements can be done if
@@ -17,6 +23,44 @@ type Ingest struct {
 	// size set by SyncSegmentDepthLimit. AdvertisementDepthLimit sets the
 	// limit on the total number of advertisements across all segments.
 	AdvertisementDepthLimit int
+	// AllowRemovedContextReuse, if true, indexes advertisements that use the
+	// context ID of content that their provider removed. The value store
+	// removes a context lazily, so this may restore removed multihashes that
+	// were not looked up since their removal. When false, such advertisements
+	// are skipped and their content is not indexed.
+	AllowRemovedContextReuse bool
+	// AllowedKeyTypes lists the types of keys that advertisements may be
+	// signed with. Advertisements signed with any other type of key are
+	// rejected. Valid types are "Ed25519", "Secp256k1", "ECDSA", and "RSA".
+	// An empty list allows all key types.
+	AllowedKeyTypes []string
+	// AnnounceDebounce is the time to wait after receiving a direct announce
+	// message from a provider before handling it. Any further announcements
+	// from the provider during this time replace the pending one, so that a
+	// burst of announcements results in a single sync to the latest
+	// advertisement. The latest announcement is always handled when the time
+	// is up. Values are a number ending in "s", "m", "h" for seconds,
+	// minutes, hours. Zero disables the debounce.
+	AnnounceDebounce Duration
+	// BadSignatureBlockLimit is the number of consecutive advertisements
+	// signed by a publisher that are rejected because of their signatures,
+	// after which the publisher is blocked. Advertisements whose signatures
+	// cannot be verified do not identify a publisher, so they are not
+	// counted. The block lasts until the indexer is restarted or the
+	// publisher is allowed again using the admin API. Zero disables blocking.
+	BadSignatureBlockLimit int
+	// DatastoreGCDryRun, if true, makes the datastore GC only log the number
+	// and size of orphaned blocks instead of removing them.
+	DatastoreGCDryRun bool
+	// DatastoreGCInterval is the time between removals of orphaned blocks
+	// from the datastore. Orphaned blocks are advertisement entries that are
+	// not reachable from any unprocessed advertisement, such as entries left
+	// by an interrupted sync. Values are a number ending in "s", "m", "h" for
+	// seconds. minutes, hours. Zero disables the datastore GC.
+	DatastoreGCInterval Duration
+	// DepthLimitOverrides configures advertisement and entries depth limits
+	// for specific providers.
+	DepthLimitOverrides []DepthLimit
 	// EntriesDepthLimit is the total maximum recursion depth limit when
 	// syncing advertisement entries. The value -1 means no limit and zero
 	// means use the default value. The purpose is to prevent overload from
@@ -27,6 +71,32 @@ type Ingest struct {
 	// (segments) of size set by SyncSegmentDepthLimit. EntriesDepthLimit sets
 	// the limit on the total number of entries chunks across all segments.
 	EntriesDepthLimit int
+	// EntriesFetchAhead is the maximum number of entries chunks that may be
+	// fetched ahead of the chunk being indexed. Each chunk links to the next,
+	// so the chunks of an advertisement are always fetched one after another,
+	// but fetching can continue while earlier chunks are written to the value
+	// store. Chunks are always indexed in the order they appear in the chain.
+	// The value -1 disables fetching ahead and zero means use the default
+	// value.
+	EntriesFetchAhead int
+	// EntryChunkMarkerTTL is how long the indexer remembers that an entry
+	// chunk was indexed for a provider context, so that the chunk is not
+	// indexed again when a later advertisement for the context links to it.
+	// Markers are removed when they expire, or when the context or provider
+	// is removed. Values are a number ending in "s", "m", "h" for seconds,
+	// minutes, hours. Zero means use the default value.
+	EntryChunkMarkerTTL Duration
+	// EventSink configures publishing advertisement processing events to an
+	// external message bus.
+	EventSink EventSink
+	// FilterUnretrievableAds, if true, rejects advertisements whose metadata
+	// does not name a transport protocol that the advertised content can be
+	// retrieved with. Rejected advertisements are skipped and their content
+	// is not indexed. Removal advertisements are not filtered.
+	FilterUnretrievableAds bool
+	// HttpSyncOverrides configures specific providers to always be synced
+	// over HTTP, from the given address, instead of over libp2p.
+	HttpSyncOverrides []HttpSyncOverride
 	// HttpSyncRetryMax sets the maximum number of times HTTP sync requests
 	// should be retried.
 	HttpSyncRetryMax int
@@ -38,24 +108,124 @@ type Ingest struct {
 	HttpSyncRetryWaitMin Duration
 	// HttpSyncTimeout sets the time limit for HTTP sync requests.
 	HttpSyncTimeout Duration
+	// IndexCodecs, if true, records the CID codec of each multihash that is
+	// advertised as a CID instead of as a bare multihash, so that find
+	// requests can ask for only the providers that advertised a multihash with
+	// a given codec. Multihashes are always indexed without their codec, so
+	// finding by multihash alone is not affected.
+	IndexCodecs bool
+	// IndexTimestamps, if true, records the time that each multihash is
+	// indexed for a provider context, so that find requests that ask for
+	// timestamps get the time that each multihash was indexed. Otherwise,
+	// they get the time that the multihash's provider context was last
+	// updated. This adds a datastore write for each indexed multihash.
+	IndexTimestamps bool
 	// IngestWorkerCount sets how many ingest worker goroutines to spawn. This
 	// controls how many concurrent ingest from different providers we can handle.
+	// Each worker ingests one provider at a time. Set MaxConcurrentProviders
+	// to ingest fewer providers at once, and MaxAdsPerSync to keep providers
+	// with long advertisement chains from holding workers while other
+	// providers wait. The ingest/queueDepth and ingest/workersBusy metrics
+	// show whether more workers are needed.
 	IngestWorkerCount int
+	// MaxAdsPerSync is the maximum number of advertisements that are
+	// ingested from a provider's synced chain for each sync. The remaining
+	// advertisements are held until the next sync with the provider's
+	// publisher, and are ingested, oldest first, with any advertisements
+	// that sync fetches. This bounds the work done for a single sync,
+	// independent of how many advertisements the sync selector fetched. The
+	// value 0 means no limit.
+	MaxAdsPerSync int
+	// MaxConcurrentProviders is the maximum number of distinct providers
+	// whose advertisements are ingested at the same time. When this many
+	// providers are being ingested, other providers wait for one of them to
+	// finish, even if there are idle ingest workers. Setting this below
+	// IngestWorkerCount keeps a burst of announcements from many providers
+	// from occupying every worker. The value 0 means no limit other than
+	// IngestWorkerCount.
+	MaxConcurrentProviders int
+	// MaxEntriesPerChunk is the maximum number of multihashes in a single
+	// entries chunk. Decoding a chunk stops as soon as it is found to have
+	// more entries than this, and the chunk's advertisement is recorded as
+	// failed and skipped. This protects the indexer from the memory needed to
+	// decode enormous chunks. The value -1 means no limit and zero means use
+	// the default value.
+	MaxEntriesPerChunk int
+	// MaxInFlightRequests is the number of ingest HTTP requests that are
+	// handled concurrently. When this many requests are already being handled,
+	// new requests are rejected with 429 (Too Many Requests) and a Retry-After
+	// header, instead of being queued. The value -1 means no limit and zero
+	// means use the default value.
+	MaxInFlightRequests int
+	// ProviderAdsPerMinute is the maximum number of advertisements per minute
+	// that are ingested from any single provider. This prevents a provider
+	// that publishes many advertisements in a short time from occupying the
+	// ingest workers while other providers' advertisements wait. Ads over the
+	// limit are not dropped; they are held and ingested when the provider's
+	// rate allows. The value 0 means no limit.
+	ProviderAdsPerMinute int
 	// PubSubTopic sets the topic name to which to subscribe for ingestion
 	// announcements.
 	PubSubTopic string
 	// RateLimit contains rate-limiting configuration.
 	RateLimit RateLimit
+	// RequirePublisherIsProvider, if true, only ingests advertisements that
+	// are published by the provider named in the advertisement. This is
+	// stricter than Discovery.Policy.Publish, and rejects advertisements
+	// published on behalf of any other provider.
+	RequirePublisherIsProvider bool
 	// ResendDirectAnnounce determines whether or not to re-publish direct
 	// announce messages over gossip pubsub. When a single indexer receives an
 	// announce message via HTTP, enabling this lets the indexers re-publish
 	// the announce so that other indexers can also receive it.
 	ResendDirectAnnounce bool
+	// ResyncOnStartup, if true, syncs with the publisher of every known
+	// provider when the indexer starts, to ingest any advertisements that
+	// were published while the indexer was not running. Each sync stops at
+	// the latest advertisement already processed. Providers and publishers
+	// that are not allowed by policy are not synced.
+	ResyncOnStartup bool
+	// SkipPresentEntries, if true, checks whether the multihashes in each
+	// entries chunk are already indexed for the advertisement's provider,
+	// context ID, and metadata, and if so, skips storing them again. This
+	// speeds up resyncs of advertisements that overlap content that is
+	// already indexed, at the cost of a lookup for each multihash.
+	SkipPresentEntries bool
+	// SkipTrustedSignatureCheck, if true, skips verifying the signatures of
+	// advertisements for providers listed in Discovery.Policy.Trusted, which
+	// speeds up ingestion of those advertisements. The signature check is
+	// only skipped when the advertisement was also published by a trusted
+	// peer. Advertisements for all other providers, and advertisements for
+	// trusted providers that were published by any other peer, are always
+	// verified.
+	SkipTrustedSignatureCheck bool
 	// StoreBatchSize is the number of entries in each write to the value
 	// store. Specifying a value less than 2 disables batching. This should be
 	// smaller than the maximum number of multihashes in an entry block to
 	// write concurrently to the value store.
 	StoreBatchSize int
+	// StrictMultihashValidation, if true, checks that each advertised
+	// multihash uses a known hash function and has a digest length that the
+	// function can produce. Entries that fail are not indexed, and are logged
+	// and counted for the provider. Entries that cannot be decoded as a
+	// multihash or CID are never indexed. This adds CPU cost to indexing each
+	// multihash.
+	StrictMultihashValidation bool
+	// SyncDataLimit is a soft limit on the number of bytes of advertisements
+	// and entries that syncs may hold in the datastore before they are
+	// processed. When the limit is exceeded, fetching entries is paused until
+	// processing frees space. Fetching continues over the limit if no space
+	// is freed for a minute, since that means no advertisements are being
+	// processed. Zero means no limit, in which case the size of held blocks
+	// is not tracked or reported.
+	SyncDataLimit int64
+	// SyncHistoryLength is the number of summaries of recent syncs kept for
+	// each provider. Each summary records the head advertisement, when its
+	// ingestion finished, and the number of advertisements processed and
+	// multihashes indexed. The oldest summaries are removed when there are
+	// more than this. The value -1 disables the history and zero means use
+	// the default value.
+	SyncHistoryLength int
 	// SyncSegmentDepthLimit is the depth limit of a single sync in a series of
 	// calls that collectively sync advertisements or their entries. The value
 	// -1 disables the segmentation where the sync will be done in a single call
@@ -66,23 +236,91 @@ type Ingest struct {
 	// or a chain of advertisement entries. The value is an integer string
 	// ending in "s", "m", "h" for seconds. minutes, hours.
 	SyncTimeout Duration
+	// TimerJitterPercent is the maximum percentage of the period that is
+	// randomly added to each period of the ingester's periodic work, such as
+	// metrics updates and datastore GC. This keeps the periodic work of
+	// indexers that started together from being synchronized. The value -1
+	// disables jitter and zero means use the default value.
+	TimerJitterPercent int
+	// UnknownProviderPolicy determines how an advertisement is handled when
+	// its provider is not registered with the indexer. The value "register"
+	// registers the provider using the addresses in the advertisement.
+	// "reject" skips the advertisement, so that providers must be registered
+	// by a register request or discovery before their advertisements are
+	// ingested. "flag" registers the provider as "register" does, but flags
+	// it as auto-registered in its provider information, and logs a warning,
+	// so that the operator can review providers registered this way. A
+	// provider that later registers itself is no longer flagged.
+	UnknownProviderPolicy string
+	// WriteAheadLog, if true, records each advertisement chain that is about
+	// to be processed before processing begins, and removes the record once
+	// the chain has been processed. When the indexer starts, any chains that
+	// were recorded but not processed, because the indexer stopped, are
+	// processed from the advertisements held in the datastore. This prevents
+	// advertisements that were synced but not processed from being lost if
+	// the indexer crashes.
+	WriteAheadLog bool
+}
+
+// DepthLimit is a set of sync depth limits that is applied to a specific
+// provider. The values override the matching depth limits in the Ingest
+// config.
+type DepthLimit struct {
+	// ProviderID identifies the provider that this override applies to. The
+	// advertisement depth limit applies when syncing advertisements from the
+	// provider's publisher, and the entries depth limit applies when syncing
+	// the entries of the provider's advertisements. If a publisher publishes
+	// for more than one provider with an advertisement depth limit, then the
+	// smallest limit applies.
+	ProviderID string
+	// AdvertisementDepthLimit overrides Ingest.AdvertisementDepthLimit. The
+	// value -1 means no limit and zero means use the Ingest value.
+	AdvertisementDepthLimit int
+	// EntriesDepthLimit overrides Ingest.EntriesDepthLimit. The value -1
+	// means no limit and zero means use the Ingest value.
+	EntriesDepthLimit int
+}
+
+// HttpSyncOverride configures a provider's advertisement chain to be synced
+// over HTTP. This allows ingesting advertisements from providers that cannot
+// be reached over libp2p, such as those behind NAT, but that can serve their
+// advertisement chain from an HTTP endpoint.
+type HttpSyncOverride struct {
+	// ProviderID identifies the provider that this override applies to. The
+	// override applies when syncing with the provider as the publisher of
+	// advertisements, and when auto-syncing the provider's publisher.
+	ProviderID string
+	// Addr is the multiaddr of the HTTP endpoint that serves the provider's
+	// advertisement chain, such as "/dns4/ads.example.com/tcp/443/https".
+	// This address is used instead of any address given in announcements or
+	// sync requests.
+	Addr string
 }
 
 // NewIngest returns Ingest with values set to their defaults.
 func NewIngest() Ingest {
 	return Ingest{
+		AdCacheSize:             1024,
 		AdvertisementDepthLimit: 33554432,
 		EntriesDepthLimit:       65536,
+		EntriesFetchAhead:       16,
+		EntryChunkMarkerTTL:     Duration(24 * time.Hour),
+		EventSink:               NewEventSink(),
 		HttpSyncRetryMax:        4,
 		HttpSyncRetryWaitMax:    Duration(30 * time.Second),
 		HttpSyncRetryWaitMin:    Duration(1 * time.Second),
 		HttpSyncTimeout:         Duration(10 * time.Second),
 		IngestWorkerCount:       10,
+		MaxEntriesPerChunk:      262144,
+		MaxInFlightRequests:     1024,
 		PubSubTopic:             "/indexer/ingest/mainnet",
 		RateLimit:               NewRateLimit(),
 		StoreBatchSize:          4096,
+		SyncHistoryLength:       100,
 		SyncSegmentDepthLimit:   2_000,
 		SyncTimeout:             Duration(2 * time.Hour),
+		TimerJitterPercent:      10,
+		UnknownProviderPolicy:   "register",
 	}
 }
 
@@ -90,12 +328,22 @@ func NewIngest() Ingest {
 func (c *Ingest) populateUnset() {
 	def := NewIngest()
 
+	if c.AdCacheSize == 0 {
+		c.AdCacheSize = def.AdCacheSize
+	}
 	if c.AdvertisementDepthLimit == 0 {
 		c.AdvertisementDepthLimit = def.AdvertisementDepthLimit
 	}
 	if c.EntriesDepthLimit == 0 {
 		c.EntriesDepthLimit = def.EntriesDepthLimit
 	}
+	if c.EntriesFetchAhead == 0 {
+		c.EntriesFetchAhead = def.EntriesFetchAhead
+	}
+	if c.EntryChunkMarkerTTL == 0 {
+		c.EntryChunkMarkerTTL = def.EntryChunkMarkerTTL
+	}
+	c.EventSink.populateUnset()
 	if c.HttpSyncRetryMax == 0 {
 		c.HttpSyncRetryMax = def.HttpSyncRetryMax
 	}
@@ -111,6 +359,12 @@ func (c *Ingest) populateUnset() {
 	if c.IngestWorkerCount == 0 {
 		c.IngestWorkerCount = def.IngestWorkerCount
 	}
+	if c.MaxEntriesPerChunk == 0 {
+		c.MaxEntriesPerChunk = def.MaxEntriesPerChunk
+	}
+	if c.MaxInFlightRequests == 0 {
+		c.MaxInFlightRequests = def.MaxInFlightRequests
+	}
 	if c.PubSubTopic == "" {
 		c.PubSubTopic = def.PubSubTopic
 	}
@@ -118,10 +372,19 @@ func (c *Ingest) populateUnset() {
 	if c.StoreBatchSize == 0 {
 		c.StoreBatchSize = def.StoreBatchSize
 	}
+	if c.SyncHistoryLength == 0 {
+		c.SyncHistoryLength = def.SyncHistoryLength
+	}
 	if c.SyncSegmentDepthLimit == 0 {
 		c.SyncSegmentDepthLimit = def.SyncSegmentDepthLimit
 	}
 	if c.SyncTimeout == 0 {
 		c.SyncTimeout = def.SyncTimeout
 	}
+	if c.TimerJitterPercent == 0 {
+		c.TimerJitterPercent = def.TimerJitterPercent
+	}
+	if c.UnknownProviderPolicy == "" {
+		c.UnknownProviderPolicy = def.UnknownProviderPolicy
+	}
 }
@@ -21,15 +21,16 @@ func Init(out io.Writer) (*Config, error) {
 
 func InitWithIdentity(identity Identity) (*Config, error) {
 	conf := &Config{
-		Version:   Version,
-		Addresses: NewAddresses(),
-		Bootstrap: NewBootstrap(),
-		Datastore: NewDatastore(),
-		Discovery: NewDiscovery(),
-		Identity:  identity,
-		Indexer:   NewIndexer(),
-		Ingest:    NewIngest(),
-		Logging:   NewLogging(),
+		Version:     Version,
+		Addresses:   NewAddresses(),
+		AdminServer: NewAdminServer(),
+		Bootstrap:   NewBootstrap(),
+		Datastore:   NewDatastore(),
+		Discovery:   NewDiscovery(),
+		Identity:    identity,
+		Indexer:     NewIndexer(),
+		Ingest:      NewIngest(),
+		Logging:     NewLogging(),
 	}
 
 	return conf, nil
@@ -1,5 +1,9 @@
 package config
 
+import (
+	"time"
+)
+
 // Policy configures which peers are allowed and which may publish on behalf of
 // others. Currently, the allow policy is applied to both providers and
 // publishers. The Publish policie applies only to publishers.
@@ -27,12 +31,44 @@ type Policy struct {
 	// PublishExcept. If Publish is true, then all allowed peers can publish
 	// advertisements for any provider, unless listed in PublishExcept.
 	PublishExcept []string
+
+	// Trusted is a list of peer IDs of providers that are trusted. When
+	// Ingest.SkipTrustedSignatureCheck is true, advertisements for these
+	// providers that are published by a trusted peer are ingested without
+	// verifying their signatures. Trusted providers must still be allowed by
+	// the Allow policy.
+	Trusted []string
+
+	// AllowListURL is the URL of a signed list of peer IDs that are allowed,
+	// in addition to the peers allowed by Allow and Except. Peers that are
+	// blocked locally, by Except when Allow is true or by the admin block
+	// command, are not allowed by the list. The list is fetched periodically
+	// and applied to the running policy. Leave empty to not use a remote
+	// allowlist.
+	AllowListURL string
+	// AllowListSigner is the peer ID whose key must sign the remote
+	// allowlist. This is required when AllowListURL is set.
+	AllowListSigner string
+	// AllowListRefresh is the amount of time to wait between fetches of the
+	// remote allowlist. Values are a number ending in "s", "m", "h" for
+	// seconds. minutes, hours.
+	AllowListRefresh Duration
 }
 
 // NewPolicy returns Policy with values set to their defaults.
 func NewPolicy() Policy {
 	return Policy{
-		Allow:   true,
-		Publish: true,
+		Allow:            true,
+		Publish:          true,
+		AllowListRefresh: Duration(time.Hour),
+	}
+}
+
+// populateUnset replaces zero-values in the config with default values.
+func (c *Policy) populateUnset() {
+	def := NewPolicy()
+
+	if c.AllowListRefresh == 0 {
+		c.AllowListRefresh = def.AllowListRefresh
 	}
 }
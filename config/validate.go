@@ -0,0 +1,294 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	crypto_pb "github.com/libp2p/go-libp2p-core/crypto/pb"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// Validate checks that the config values are usable, and returns an error
+// naming the first field that is not. This catches misconfiguration when the
+// config is loaded, instead of when the subsystem using the value is started.
+// Validate is called after unset values are populated with defaults.
+func (c *Config) Validate() error {
+	if err := c.Addresses.validate(); err != nil {
+		return fmt.Errorf("Addresses.%w", err)
+	}
+	if err := c.AdminServer.validate(); err != nil {
+		return fmt.Errorf("AdminServer.%w", err)
+	}
+	if err := c.Datastore.validate(); err != nil {
+		return fmt.Errorf("Datastore.%w", err)
+	}
+	if err := c.Discovery.validate(); err != nil {
+		return fmt.Errorf("Discovery.%w", err)
+	}
+	if err := c.Ingest.validate(); err != nil {
+		return fmt.Errorf("Ingest.%w", err)
+	}
+	return nil
+}
+
+// Warnings returns descriptions of config values that are valid, but are
+// likely mistakes or need attention. Unlike Validate, these do not prevent the
+// indexer from starting.
+func (c *Config) Warnings() []string {
+	var warnings []string
+	if c.Version != Version {
+		warnings = append(warnings, fmt.Sprintf("Version: config is version %d, current version is %d; upgrade with \"init --upgrade\"", c.Version, Version))
+	}
+	if !c.Discovery.Policy.Allow && len(c.Discovery.Policy.Except) == 0 && c.Discovery.Policy.AllowListURL == "" {
+		warnings = append(warnings, "Discovery.Policy: allows no peers, so nothing can be indexed")
+	}
+	if c.Indexer.CacheWarmCount > 0 && c.Indexer.CacheSize < 0 {
+		warnings = append(warnings, "Indexer.CacheWarmCount: has no effect because the cache is disabled by Indexer.CacheSize")
+	}
+	if c.Ingest.SkipTrustedSignatureCheck && len(c.Discovery.Policy.Trusted) == 0 {
+		warnings = append(warnings, "Ingest.SkipTrustedSignatureCheck: has no effect because Discovery.Policy.Trusted is empty")
+	}
+	return warnings
+}
+
+func (c *Addresses) validate() error {
+	addrs := []struct {
+		name string
+		addr string
+	}{
+		{"Admin", c.Admin},
+		{"Finder", c.Finder},
+		{"Ingest", c.Ingest},
+		{"P2PAddr", c.P2PAddr},
+	}
+	for _, a := range addrs {
+		if a.addr == "none" {
+			continue
+		}
+		if _, err := multiaddr.NewMultiaddr(a.addr); err != nil {
+			return fmt.Errorf("%s: bad multiaddr %q: %s", a.name, a.addr, err)
+		}
+	}
+
+	if c.ConnMgrHighWater != -1 {
+		if c.ConnMgrHighWater <= 0 {
+			return fmt.Errorf("ConnMgrHighWater: must be -1 or greater than 0, got %d", c.ConnMgrHighWater)
+		}
+		if c.ConnMgrLowWater <= 0 {
+			return fmt.Errorf("ConnMgrLowWater: must be greater than 0, got %d", c.ConnMgrLowWater)
+		}
+		if c.ConnMgrLowWater > c.ConnMgrHighWater {
+			return fmt.Errorf("ConnMgrLowWater: must not be greater than ConnMgrHighWater (%d), got %d", c.ConnMgrHighWater, c.ConnMgrLowWater)
+		}
+	}
+	if c.ConnMgrGracePeriod < 0 {
+		return fmt.Errorf("ConnMgrGracePeriod: must not be negative, got %s", c.ConnMgrGracePeriod)
+	}
+	return nil
+}
+
+func (c *AdminServer) validate() error {
+	if c.TLSCertFile != "" && c.TLSKeyFile == "" {
+		return errors.New("TLSKeyFile: must be set when TLSCertFile is set")
+	}
+	if c.TLSKeyFile != "" && c.TLSCertFile == "" {
+		return errors.New("TLSCertFile: must be set when TLSKeyFile is set")
+	}
+	if c.ClientCAFile != "" && c.TLSCertFile == "" {
+		return errors.New("ClientCAFile: requires TLSCertFile and TLSKeyFile to be set")
+	}
+	return nil
+}
+
+func (c *Datastore) validate() error {
+	switch c.Type {
+	case "levelds", "badgerds":
+	default:
+		return fmt.Errorf("Type: must be \"levelds\" or \"badgerds\", got %q", c.Type)
+	}
+	return nil
+}
+
+func (c *Policy) validate() error {
+	if err := validatePeerIDs(c.Except); err != nil {
+		return fmt.Errorf("Except: %w", err)
+	}
+	if err := validatePeerIDs(c.PublishExcept); err != nil {
+		return fmt.Errorf("PublishExcept: %w", err)
+	}
+	if err := validatePeerIDs(c.Trusted); err != nil {
+		return fmt.Errorf("Trusted: %w", err)
+	}
+	if c.AllowListURL != "" {
+		if c.AllowListSigner == "" {
+			return errors.New("AllowListSigner: must be set when AllowListURL is set")
+		}
+		if _, err := peer.Decode(c.AllowListSigner); err != nil {
+			return fmt.Errorf("AllowListSigner: bad peer ID %q: %s", c.AllowListSigner, err)
+		}
+		if c.AllowListRefresh < 0 {
+			return fmt.Errorf("AllowListRefresh: must not be negative, got %s", c.AllowListRefresh)
+		}
+	}
+	return nil
+}
+
+func (c *Discovery) validate() error {
+	if err := c.Policy.validate(); err != nil {
+		return fmt.Errorf("Policy.%w", err)
+	}
+	if err := validateJitterPercent(c.TimerJitterPercent); err != nil {
+		return fmt.Errorf("TimerJitterPercent: %w", err)
+	}
+	return nil
+}
+
+func (c *Ingest) validate() error {
+	// Limits where -1 means no limit.
+	limits := []struct {
+		name  string
+		value int
+	}{
+		{"AdCacheSize", c.AdCacheSize},
+		{"AdvertisementDepthLimit", c.AdvertisementDepthLimit},
+		{"EntriesDepthLimit", c.EntriesDepthLimit},
+		{"EntriesFetchAhead", c.EntriesFetchAhead},
+		{"MaxInFlightRequests", c.MaxInFlightRequests},
+		{"SyncSegmentDepthLimit", c.SyncSegmentDepthLimit},
+	}
+	for _, l := range limits {
+		if l.value < -1 {
+			return fmt.Errorf("%s: must be -1 or greater, got %d", l.name, l.value)
+		}
+	}
+
+	if c.IngestWorkerCount <= 0 {
+		return fmt.Errorf("IngestWorkerCount: must be greater than 0, got %d", c.IngestWorkerCount)
+	}
+	if c.StoreBatchSize < 0 {
+		return fmt.Errorf("StoreBatchSize: must not be negative, got %d", c.StoreBatchSize)
+	}
+	if c.BadSignatureBlockLimit < 0 {
+		return fmt.Errorf("BadSignatureBlockLimit: must not be negative, got %d", c.BadSignatureBlockLimit)
+	}
+	if c.HttpSyncRetryMax < 0 {
+		return fmt.Errorf("HttpSyncRetryMax: must not be negative, got %d", c.HttpSyncRetryMax)
+	}
+	if c.MaxAdsPerSync < 0 {
+		return fmt.Errorf("MaxAdsPerSync: must not be negative, got %d", c.MaxAdsPerSync)
+	}
+	if c.MaxConcurrentProviders < 0 {
+		return fmt.Errorf("MaxConcurrentProviders: must not be negative, got %d", c.MaxConcurrentProviders)
+	}
+	if c.ProviderAdsPerMinute < 0 {
+		return fmt.Errorf("ProviderAdsPerMinute: must not be negative, got %d", c.ProviderAdsPerMinute)
+	}
+	if err := validateJitterPercent(c.TimerJitterPercent); err != nil {
+		return fmt.Errorf("TimerJitterPercent: %w", err)
+	}
+	if c.SyncDataLimit < 0 {
+		return fmt.Errorf("SyncDataLimit: must not be negative, got %d", c.SyncDataLimit)
+	}
+	switch c.UnknownProviderPolicy {
+	case "register", "reject", "flag":
+	default:
+		return fmt.Errorf("UnknownProviderPolicy: must be \"register\", \"reject\", or \"flag\", got %q", c.UnknownProviderPolicy)
+	}
+
+	durations := []struct {
+		name  string
+		value Duration
+	}{
+		{"AnnounceDebounce", c.AnnounceDebounce},
+		{"DatastoreGCInterval", c.DatastoreGCInterval},
+		{"EntryChunkMarkerTTL", c.EntryChunkMarkerTTL},
+		{"HttpSyncRetryWaitMax", c.HttpSyncRetryWaitMax},
+		{"HttpSyncRetryWaitMin", c.HttpSyncRetryWaitMin},
+		{"HttpSyncTimeout", c.HttpSyncTimeout},
+		{"SyncTimeout", c.SyncTimeout},
+	}
+	for _, d := range durations {
+		if d.value < 0 {
+			return fmt.Errorf("%s: must not be negative, got %s", d.name, d.value)
+		}
+	}
+	if c.HttpSyncRetryWaitMin > c.HttpSyncRetryWaitMax {
+		return fmt.Errorf("HttpSyncRetryWaitMin: must not be greater than HttpSyncRetryWaitMax (%s), got %s", c.HttpSyncRetryWaitMax, c.HttpSyncRetryWaitMin)
+	}
+
+	if c.RateLimit.BlocksPerSecond < 0 {
+		return fmt.Errorf("RateLimit.BlocksPerSecond: must not be negative, got %d", c.RateLimit.BlocksPerSecond)
+	}
+	if c.RateLimit.BurstSize < 0 {
+		return fmt.Errorf("RateLimit.BurstSize: must not be negative, got %d", c.RateLimit.BurstSize)
+	}
+	if err := validatePeerIDs(c.RateLimit.Except); err != nil {
+		return fmt.Errorf("RateLimit.Except: %w", err)
+	}
+
+	if c.EventSink.URL != "" {
+		u, err := url.Parse(c.EventSink.URL)
+		if err != nil {
+			return fmt.Errorf("EventSink.URL: %w", err)
+		}
+		if u.Scheme != "nats" && u.Scheme != "tls" {
+			return fmt.Errorf("EventSink.URL: scheme must be \"nats\" or \"tls\", got %q", u.Scheme)
+		}
+	}
+	if c.EventSink.BufferSize < 0 {
+		return fmt.Errorf("EventSink.BufferSize: must not be negative, got %d", c.EventSink.BufferSize)
+	}
+
+	for _, name := range c.AllowedKeyTypes {
+		if !validKeyType(name) {
+			return fmt.Errorf("AllowedKeyTypes: unknown key type %q", name)
+		}
+	}
+
+	for i, override := range c.DepthLimitOverrides {
+		if _, err := peer.Decode(override.ProviderID); err != nil {
+			return fmt.Errorf("DepthLimitOverrides[%d].ProviderID: bad peer ID %q: %s", i, override.ProviderID, err)
+		}
+	}
+	for i, override := range c.HttpSyncOverrides {
+		if _, err := peer.Decode(override.ProviderID); err != nil {
+			return fmt.Errorf("HttpSyncOverrides[%d].ProviderID: bad peer ID %q: %s", i, override.ProviderID, err)
+		}
+		if _, err := multiaddr.NewMultiaddr(override.Addr); err != nil {
+			return fmt.Errorf("HttpSyncOverrides[%d].Addr: bad multiaddr %q: %s", i, override.Addr, err)
+		}
+	}
+	return nil
+}
+
+// validateJitterPercent checks that a timer jitter percentage is -1, to
+// disable jitter, or from 0 through 100.
+func validateJitterPercent(percent int) error {
+	if percent < -1 || percent > 100 {
+		return fmt.Errorf("must be -1 or from 0 through 100, got %d", percent)
+	}
+	return nil
+}
+
+// validatePeerIDs checks that each string is a valid peer ID.
+func validatePeerIDs(ids []string) error {
+	for _, id := range ids {
+		if _, err := peer.Decode(id); err != nil {
+			return fmt.Errorf("bad peer ID %q: %s", id, err)
+		}
+	}
+	return nil
+}
+
+// validKeyType returns true if name is the name of a key type, ignoring case.
+func validKeyType(name string) bool {
+	for typeName := range crypto_pb.KeyType_value {
+		if strings.EqualFold(name, typeName) {
+			return true
+		}
+	}
+	return false
+}
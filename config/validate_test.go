@@ -0,0 +1,105 @@
+package config
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	cfg, err := Init(io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = cfg.Validate(); err != nil {
+		t.Fatal("default config not valid:", err)
+	}
+
+	tests := []struct {
+		field  string
+		modify func(*Config)
+	}{
+		{"Ingest.BadSignatureBlockLimit", func(c *Config) { c.Ingest.BadSignatureBlockLimit = -1 }},
+		{"Ingest.IngestWorkerCount", func(c *Config) { c.Ingest.IngestWorkerCount = -1 }},
+		{"Ingest.MaxAdsPerSync", func(c *Config) { c.Ingest.MaxAdsPerSync = -1 }},
+		{"Ingest.MaxConcurrentProviders", func(c *Config) { c.Ingest.MaxConcurrentProviders = -1 }},
+		{"Ingest.TimerJitterPercent", func(c *Config) { c.Ingest.TimerJitterPercent = 101 }},
+		{"Ingest.UnknownProviderPolicy", func(c *Config) { c.Ingest.UnknownProviderPolicy = "ignore" }},
+		{"Discovery.TimerJitterPercent", func(c *Config) { c.Discovery.TimerJitterPercent = -2 }},
+		{"Ingest.StoreBatchSize", func(c *Config) { c.Ingest.StoreBatchSize = -5 }},
+		{"Ingest.EntriesDepthLimit", func(c *Config) { c.Ingest.EntriesDepthLimit = -2 }},
+		{"Ingest.HttpSyncRetryWaitMin", func(c *Config) { c.Ingest.HttpSyncRetryWaitMin = c.Ingest.HttpSyncRetryWaitMax + 1 }},
+		{"Ingest.EventSink.URL", func(c *Config) { c.Ingest.EventSink.URL = "kafka://127.0.0.1:9092" }},
+		{"Ingest.AllowedKeyTypes", func(c *Config) { c.Ingest.AllowedKeyTypes = []string{"ed25519", "dsa"} }},
+		{"Ingest.HttpSyncOverrides[0].ProviderID", func(c *Config) {
+			c.Ingest.HttpSyncOverrides = []HttpSyncOverride{{ProviderID: "bad", Addr: "/ip4/127.0.0.1/tcp/80/http"}}
+		}},
+		{"Addresses.Finder", func(c *Config) { c.Addresses.Finder = "127.0.0.1:3000" }},
+		{"Addresses.ConnMgrLowWater", func(c *Config) { c.Addresses.ConnMgrLowWater = c.Addresses.ConnMgrHighWater + 1 }},
+		{"AdminServer.TLSKeyFile", func(c *Config) { c.AdminServer.TLSCertFile = "cert.pem" }},
+		{"AdminServer.ClientCAFile", func(c *Config) { c.AdminServer.ClientCAFile = "ca.pem" }},
+		{"Datastore.Type", func(c *Config) { c.Datastore.Type = "pebbleds" }},
+		{"Discovery.Policy.Trusted", func(c *Config) { c.Discovery.Policy.Trusted = []string{"not-a-peer-id"} }},
+		{"Discovery.Policy.AllowListSigner", func(c *Config) { c.Discovery.Policy.AllowListURL = "https://example.com/allow" }},
+	}
+	for _, tc := range tests {
+		cfg, err := Init(io.Discard)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tc.modify(cfg)
+		err = cfg.Validate()
+		if err == nil {
+			t.Fatalf("expected error for invalid %s", tc.field)
+		}
+		if !strings.HasPrefix(err.Error(), tc.field+":") {
+			t.Fatalf("expected error for %s, got: %s", tc.field, err)
+		}
+	}
+
+	// Addresses set to "none" and disabled limits are valid.
+	cfg.Addresses.Admin = "none"
+	cfg.Addresses.ConnMgrHighWater = -1
+	cfg.Ingest.SyncSegmentDepthLimit = -1
+	cfg.Datastore.Type = "badgerds"
+	if err = cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWarnings(t *testing.T) {
+	cfg, err := Init(io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if warnings := cfg.Warnings(); len(warnings) != 0 {
+		t.Fatal("expected no warnings for default config, got:", warnings)
+	}
+
+	cfg.Version = Version - 1
+	cfg.Discovery.Policy.Allow = false
+	warnings := cfg.Warnings()
+	if len(warnings) != 2 {
+		t.Fatal("expected 2 warnings, got:", warnings)
+	}
+	if !strings.HasPrefix(warnings[0], "Version:") {
+		t.Fatal("expected warning for Version, got:", warnings[0])
+	}
+	if !strings.HasPrefix(warnings[1], "Discovery.Policy:") {
+		t.Fatal("expected warning for Discovery.Policy, got:", warnings[1])
+	}
+
+	// A policy that allows peers by exception does not warn.
+	cfg.Version = Version
+	cfg.Discovery.Policy.Except = []string{"12D3KooWPw6bfQbJHfKa2o5XpusChoq67iZoqgfnhecygjKsQRmG"}
+	if warnings = cfg.Warnings(); len(warnings) != 0 {
+		t.Fatal("expected no warnings, got:", warnings)
+	}
+
+	cfg.Indexer.CacheWarmCount = 1000
+	cfg.Indexer.CacheSize = -1
+	warnings = cfg.Warnings()
+	if len(warnings) != 1 || !strings.HasPrefix(warnings[0], "Indexer.CacheWarmCount:") {
+		t.Fatal("expected warning for Indexer.CacheWarmCount, got:", warnings)
+	}
+}
@@ -0,0 +1,186 @@
+// Package cachewarm wraps an indexer so that the multihashes most recently
+// found by lookups are recorded in an access log, and are loaded back into the
+// indexer's result cache when the indexer restarts.
+//
+// After a restart the result cache is empty, and every lookup goes to the
+// slower value store until the cache fills again. The access log is saved in a
+// datastore periodically and when closed. When the wrapper is created, each
+// multihash in the saved access log is looked up, which puts its values into
+// the result cache, so that hot content is served from the cache soon after
+// startup.
+package cachewarm
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	indexer "github.com/filecoin-project/go-indexer-core"
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/ipfs/go-datastore"
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/multiformats/go-multihash"
+)
+
+var log = logging.Logger("indexer/cachewarm")
+
+// accessLogKey is where the access log is stored in the datastore.
+var accessLogKey = datastore.NewKey("/cachewarm/accesslog")
+
+// saveInterval is the time between saves of the access log, so that little of
+// it is lost if the indexer does not shut down cleanly.
+const saveInterval = 5 * time.Minute
+
+// Indexer is an indexer.Interface that records the multihashes found by Get in
+// a bounded access log. It is safe for concurrent use if the wrapped indexer
+// is.
+type Indexer struct {
+	indexer.Interface
+	dstore datastore.Datastore
+	// accessed holds the most recently found multihashes, as strings.
+	accessed *lru.Cache
+	// changed is non-zero if the access log has changed since it was saved.
+	changed int32
+
+	closeOnce sync.Once
+	closing   chan struct{}
+	done      chan struct{}
+	// warmed is closed when warming the cache has finished.
+	warmed chan struct{}
+}
+
+// New wraps idx so that up to size of the most recently found multihashes are
+// recorded and saved in dstore. The access log saved by a previous Indexer is
+// loaded, and its multihashes are looked up in idx in the background to warm
+// idx's result cache. Close must be called to stop the background work and to
+// save the access log.
+func New(idx indexer.Interface, dstore datastore.Datastore, size int) (*Indexer, error) {
+	accessed, err := lru.New(size)
+	if err != nil {
+		return nil, err
+	}
+	ix := &Indexer{
+		Interface: idx,
+		dstore:    dstore,
+		accessed:  accessed,
+		closing:   make(chan struct{}),
+		done:      make(chan struct{}),
+		warmed:    make(chan struct{}),
+	}
+	if err = ix.load(context.Background()); err != nil {
+		return nil, fmt.Errorf("cannot load access log: %w", err)
+	}
+	go ix.run()
+	return ix, nil
+}
+
+// Get retrieves the values for a multihash, and records the multihash in the
+// access log if it is found.
+func (ix *Indexer) Get(mh multihash.Multihash) ([]indexer.Value, bool, error) {
+	values, found, err := ix.Interface.Get(mh)
+	if found {
+		ix.accessed.Add(string(mh), nil)
+		atomic.StoreInt32(&ix.changed, 1)
+	}
+	return values, found, err
+}
+
+// Close stops warming the cache and saving the access log, and then saves the
+// access log. It does not close the wrapped indexer.
+func (ix *Indexer) Close() error {
+	ix.closeOnce.Do(func() {
+		close(ix.closing)
+	})
+	<-ix.done
+	return ix.save(context.Background())
+}
+
+func (ix *Indexer) run() {
+	defer close(ix.done)
+
+	ix.warm()
+	close(ix.warmed)
+
+	ticker := time.NewTicker(saveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := ix.save(context.Background()); err != nil {
+				log.Errorw("Cannot save access log", "err", err)
+			}
+		case <-ix.closing:
+			return
+		}
+	}
+}
+
+// warm looks up each multihash in the access log, from the least to the most
+// recently found, so that the most recently found are the last to be evicted
+// if the result cache fills up.
+func (ix *Indexer) warm() {
+	keys := ix.accessed.Keys()
+	if len(keys) == 0 {
+		return
+	}
+	log.Infow("Warming result cache", "multihashes", len(keys))
+	start := time.Now()
+	var found int
+	for _, k := range keys {
+		select {
+		case <-ix.closing:
+			log.Infow("Stopped warming result cache", "found", found)
+			return
+		default:
+		}
+		_, ok, err := ix.Interface.Get(multihash.Multihash(k.(string)))
+		if err != nil {
+			log.Errorw("Cannot get multihash while warming result cache", "err", err)
+			continue
+		}
+		if ok {
+			found++
+		}
+	}
+	log.Infow("Finished warming result cache", "found", found, "elapsed", time.Since(start))
+}
+
+// load adds the multihashes in the saved access log to the access log, in the
+// order they were saved.
+func (ix *Indexer) load(ctx context.Context) error {
+	data, err := ix.dstore.Get(ctx, accessLogKey)
+	if err != nil {
+		if err == datastore.ErrNotFound {
+			return nil
+		}
+		return err
+	}
+	for len(data) != 0 {
+		n, mh, err := multihash.MHFromBytes(data)
+		if err != nil {
+			return err
+		}
+		ix.accessed.Add(string(mh), nil)
+		data = data[n:]
+	}
+	return nil
+}
+
+// save stores the multihashes in the access log, from the least to the most
+// recently found, if the access log has changed since it was last saved.
+func (ix *Indexer) save(ctx context.Context) error {
+	if !atomic.CompareAndSwapInt32(&ix.changed, 1, 0) {
+		return nil
+	}
+	var data []byte
+	for _, k := range ix.accessed.Keys() {
+		data = append(data, k.(string)...)
+	}
+	if err := ix.dstore.Put(ctx, accessLogKey, data); err != nil {
+		atomic.StoreInt32(&ix.changed, 1)
+		return err
+	}
+	return ix.dstore.Sync(ctx, accessLogKey)
+}
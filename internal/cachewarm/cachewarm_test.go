@@ -0,0 +1,113 @@
+package cachewarm
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	indexer "github.com/filecoin-project/go-indexer-core"
+	"github.com/filecoin-project/go-indexer-core/cache/radixcache"
+	"github.com/filecoin-project/go-indexer-core/engine"
+	"github.com/filecoin-project/go-indexer-core/store/memory"
+	"github.com/filecoin-project/storetheindex/test/util"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+var provID = peer.ID("12D3KooWKRyzVWW6ChFjQjK4miCty85Niy48tpPV95XdKu1BcvMA")
+
+func TestWarmCache(t *testing.T) {
+	dstore := dssync.MutexWrap(datastore.NewMapDatastore())
+	valueStore := memory.New()
+
+	mhs := util.RandomMultihashes(6, rand.New(rand.NewSource(1413)))
+	value := indexer.Value{ProviderID: provID, ContextID: []byte("ctx"), MetadataBytes: []byte("md")}
+	if err := valueStore.Put(value, mhs[:5]...); err != nil {
+		t.Fatal(err)
+	}
+
+	ix, err := New(engine.New(radixcache.New(100), valueStore), dstore, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Only the 3 most recently found multihashes are kept, and multihashes
+	// that are not found are not recorded.
+	for _, mh := range mhs {
+		if _, _, err = ix.Get(mh); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = ix.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// After a restart, the recorded multihashes are loaded into the empty
+	// result cache.
+	resultCache := radixcache.New(100)
+	ix, err = New(engine.New(resultCache, valueStore), dstore, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-ix.warmed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for cache to be warmed")
+	}
+	for i, mh := range mhs {
+		_, found := resultCache.Get(mh)
+		if found != (i >= 2 && i < 5) {
+			t.Fatalf("multihash %d in cache is %t", i, found)
+		}
+	}
+
+	// The access log is kept if nothing is looked up before closing.
+	if err = ix.Close(); err != nil {
+		t.Fatal(err)
+	}
+	ix, err = New(engine.New(radixcache.New(100), valueStore), dstore, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ix.Close()
+	if ix.accessed.Len() != 3 {
+		t.Fatalf("expected 3 multihashes in access log, got %d", ix.accessed.Len())
+	}
+}
+
+func TestSmallerAccessLog(t *testing.T) {
+	dstore := dssync.MutexWrap(datastore.NewMapDatastore())
+	valueStore := memory.New()
+
+	mhs := util.RandomMultihashes(4, rand.New(rand.NewSource(1413)))
+	value := indexer.Value{ProviderID: provID, ContextID: []byte("ctx"), MetadataBytes: []byte("md")}
+	if err := valueStore.Put(value, mhs...); err != nil {
+		t.Fatal(err)
+	}
+
+	ix, err := New(valueStore, dstore, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mh := range mhs {
+		if _, _, err = ix.Get(mh); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = ix.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Loading a saved access log into a smaller one keeps the most recently
+	// found multihashes.
+	ix, err = New(valueStore, dstore, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ix.Close()
+	for i, mh := range mhs {
+		if ix.accessed.Contains(string(mh)) != (i >= 2) {
+			t.Fatalf("unexpected access log membership of multihash %d", i)
+		}
+	}
+}
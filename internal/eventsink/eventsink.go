@@ -0,0 +1,114 @@
+// Package eventsink publishes ingestion events to an external message bus, so
+// that systems outside of the indexer can react to indexing as it happens.
+package eventsink
+
+import (
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+var log = logging.Logger("indexer/eventsink")
+
+// Event describes an advertisement that the indexer finished processing.
+type Event struct {
+	// Publisher is the peer that the advertisement was synced from.
+	Publisher peer.ID
+	// HeadAdCid is the CID of the latest advertisement in the chain that the
+	// advertisement was processed as part of.
+	HeadAdCid cid.Cid
+	// AdCid is the CID of the processed advertisement.
+	AdCid cid.Cid
+	// Error describes why the advertisement failed to process. It is empty
+	// if the advertisement was processed successfully.
+	Error string `json:",omitempty"`
+	// Time is when the advertisement finished processing.
+	Time time.Time
+}
+
+// Sink is a destination that events are published to.
+type Sink interface {
+	// Publish publishes the event. It must not wait for the event to be
+	// received by anything downstream.
+	Publish(Event) error
+	// Close releases resources held by the sink.
+	Close() error
+}
+
+// New creates the built-in Sink that is selected by the scheme of the
+// connection URL. The "nats" and "tls" schemes publish to a NATS server, on
+// the given subject.
+func New(connURL, subject string) (Sink, error) {
+	u, err := url.Parse(connURL)
+	if err != nil {
+		return nil, fmt.Errorf("bad event sink url: %w", err)
+	}
+	switch u.Scheme {
+	case "nats", "tls":
+		return NewNatsSink(connURL, subject)
+	default:
+		return nil, fmt.Errorf("unsupported event sink url scheme %q", u.Scheme)
+	}
+}
+
+// Async publishes events to a Sink from a separate goroutine, so that sending
+// an event never waits for it to be published. Events are held in a buffer
+// while waiting to be published. If the buffer is full then events are
+// dropped.
+type Async struct {
+	sink   Sink
+	events chan Event
+	done   chan struct{}
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// NewAsync starts publishing events sent to the returned Async to sink.
+func NewAsync(sink Sink, bufferSize int) *Async {
+	a := &Async{
+		sink:   sink,
+		events: make(chan Event, bufferSize),
+		done:   make(chan struct{}),
+	}
+	go a.run()
+	return a
+}
+
+func (a *Async) run() {
+	defer close(a.done)
+	for event := range a.events {
+		if err := a.sink.Publish(event); err != nil {
+			log.Warnw("Failed to publish event", "err", err, "adCid", event.AdCid, "publisher", event.Publisher)
+		}
+	}
+}
+
+// Send queues the event to be published, without waiting. Returns false if
+// the event was dropped because the buffer is full. Send must not be called
+// after Close.
+func (a *Async) Send(event Event) bool {
+	select {
+	case a.events <- event:
+		return true
+	default:
+		log.Warnw("Dropped event because publishing is not keeping up", "adCid", event.AdCid, "publisher", event.Publisher)
+		return false
+	}
+}
+
+// Close publishes the events that are already queued, and then closes the
+// Sink.
+func (a *Async) Close() error {
+	a.closeOnce.Do(func() {
+		close(a.events)
+		<-a.done
+		a.closeErr = a.sink.Close()
+	})
+	return a.closeErr
+}
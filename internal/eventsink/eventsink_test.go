@@ -0,0 +1,152 @@
+package eventsink
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p-core/test"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+type publishedMsg struct {
+	subject string
+	data    []byte
+}
+
+// startNatsServer starts a server that speaks enough of the NATS protocol to
+// accept a client connection and receive published messages.
+func startNatsServer(t *testing.T) (string, <-chan publishedMsg) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+
+	msgs := make(chan publishedMsg, 16)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		port := ln.Addr().(*net.TCPAddr).Port
+		fmt.Fprintf(conn, "INFO {\"server_id\":\"test\",\"version\":\"2.8.4\",\"host\":\"127.0.0.1\",\"port\":%d,\"max_payload\":1048576,\"proto\":1}\r\n", port)
+		r := bufio.NewReader(conn)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			fields := strings.Fields(line)
+			if len(fields) == 0 {
+				continue
+			}
+			switch fields[0] {
+			case "PING":
+				io.WriteString(conn, "PONG\r\n")
+			case "PUB":
+				size, err := strconv.Atoi(fields[len(fields)-1])
+				if err != nil {
+					return
+				}
+				data := make([]byte, size+2)
+				if _, err = io.ReadFull(r, data); err != nil {
+					return
+				}
+				msgs <- publishedMsg{subject: fields[1], data: data[:size]}
+			}
+		}
+	}()
+	return "nats://" + ln.Addr().String(), msgs
+}
+
+func randomCid(t *testing.T) cid.Cid {
+	buf := make([]byte, 32)
+	_, err := rand.Read(buf)
+	require.NoError(t, err)
+	mh, err := multihash.Sum(buf, multihash.SHA2_256, -1)
+	require.NoError(t, err)
+	return cid.NewCidV1(cid.DagJSON, mh)
+}
+
+func TestNatsSink(t *testing.T) {
+	url, msgs := startNatsServer(t)
+
+	sink, err := New(url, "test.events")
+	require.NoError(t, err)
+
+	publisher, err := test.RandPeerID()
+	require.NoError(t, err)
+	event := Event{
+		Publisher: publisher,
+		HeadAdCid: randomCid(t),
+		AdCid:     randomCid(t),
+		Error:     "failed",
+		Time:      time.Now().UTC().Truncate(time.Second),
+	}
+	require.NoError(t, sink.Publish(event))
+	require.NoError(t, sink.Close())
+
+	select {
+	case msg := <-msgs:
+		require.Equal(t, "test.events", msg.subject)
+		var got Event
+		require.NoError(t, json.Unmarshal(msg.data, &got))
+		require.Equal(t, event, got)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for published event")
+	}
+}
+
+func TestNewUnsupportedScheme(t *testing.T) {
+	_, err := New("kafka://127.0.0.1:9092", "test.events")
+	require.ErrorContains(t, err, "unsupported")
+}
+
+// blockingSink is a Sink whose Publish waits until it is released.
+type blockingSink struct {
+	release   chan struct{}
+	published chan Event
+}
+
+func (s *blockingSink) Publish(event Event) error {
+	<-s.release
+	s.published <- event
+	return nil
+}
+
+func (s *blockingSink) Close() error { return nil }
+
+func TestAsyncDropsWhenFull(t *testing.T) {
+	sink := &blockingSink{
+		release:   make(chan struct{}),
+		published: make(chan Event, 10),
+	}
+	async := NewAsync(sink, 2)
+
+	// The first event is taken by the publishing goroutine, which waits, and
+	// the next two fill the buffer.
+	require.True(t, async.Send(Event{Error: "1"}))
+	require.Eventually(t, func() bool { return len(async.events) == 0 }, time.Second, time.Millisecond)
+	require.True(t, async.Send(Event{Error: "2"}))
+	require.True(t, async.Send(Event{Error: "3"}))
+	require.False(t, async.Send(Event{Error: "4"}))
+
+	// Closing publishes the events that were queued.
+	close(sink.release)
+	require.NoError(t, async.Close())
+	close(sink.published)
+	var got []string
+	for event := range sink.published {
+		got = append(got, event.Error)
+	}
+	require.Equal(t, []string{"1", "2", "3"}, got)
+}
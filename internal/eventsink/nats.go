@@ -0,0 +1,67 @@
+package eventsink
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// natsFlushTimeout is how long closing the sink waits for buffered events to
+// be sent to the server.
+const natsFlushTimeout = 5 * time.Second
+
+type natsSink struct {
+	conn    *nats.Conn
+	subject string
+}
+
+// NewNatsSink creates a Sink that publishes each event, encoded as JSON, to
+// the subject on the NATS server at url. If the server cannot be reached, the
+// connection is retried in the background. Events published while not
+// connected are buffered by the NATS client until its reconnect buffer is
+// full, after which publishing returns an error.
+func NewNatsSink(url, subject string) (Sink, error) {
+	if subject == "" {
+		return nil, errors.New("nats event sink requires a subject")
+	}
+	conn, err := nats.Connect(url,
+		nats.Name("storetheindex"),
+		nats.MaxReconnects(-1),
+		nats.RetryOnFailedConnect(true),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			if err != nil {
+				log.Warnw("Disconnected from nats server", "err", err)
+			}
+		}),
+		nats.ReconnectHandler(func(c *nats.Conn) {
+			log.Infow("Reconnected to nats server", "url", c.ConnectedUrl())
+		}))
+	if err != nil {
+		return nil, err
+	}
+	return &natsSink{
+		conn:    conn,
+		subject: subject,
+	}, nil
+}
+
+func (s *natsSink) Publish(event Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return s.conn.Publish(s.subject, data)
+}
+
+func (s *natsSink) Close() error {
+	// Send any buffered events to the server before closing, unless not
+	// connected, in which case they are lost.
+	var err error
+	if s.conn.IsConnected() {
+		err = s.conn.FlushTimeout(natsFlushTimeout)
+	}
+	s.conn.Close()
+	return err
+}
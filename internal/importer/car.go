@@ -0,0 +1,217 @@
+package importer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	"github.com/ipld/go-ipld-prime/codec/dagcbor"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/fluent/qp"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/multiformats/go-varint"
+)
+
+// maxCarSectionSize is the largest CAR section that will be read. This
+// prevents a corrupt length prefix from causing a huge allocation.
+const maxCarSectionSize = 32 << 20
+
+// ReadCar reads a CARv1 stream and writes every block in it to the datastore,
+// keyed by the block's CID string. This is the same keying that the ingester
+// link system uses, so the blocks can be loaded as if they had been synced.
+// The roots listed in the CAR header are returned along with the number of
+// blocks read.
+func ReadCar(ctx context.Context, in io.Reader, ds datastore.Write) ([]cid.Cid, int, error) {
+	r := bufio.NewReader(in)
+
+	header, err := readCarSection(r)
+	if err != nil {
+		return nil, 0, fmt.Errorf("cannot read car header: %w", err)
+	}
+	roots, err := decodeCarHeader(header)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var count int
+	for {
+		if ctx.Err() != nil {
+			return nil, count, ctx.Err()
+		}
+		section, err := readCarSection(r)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, count, fmt.Errorf("cannot read car section %d: %w", count, err)
+		}
+		n, c, err := cid.CidFromBytes(section)
+		if err != nil {
+			return nil, count, fmt.Errorf("cannot read cid of car section %d: %w", count, err)
+		}
+		err = ds.Put(ctx, datastore.NewKey(c.String()), section[n:])
+		if err != nil {
+			return nil, count, fmt.Errorf("cannot store block %s: %w", c, err)
+		}
+		count++
+	}
+	log.Infof("Read %d blocks from car", count)
+
+	return roots, count, nil
+}
+
+// ReadCarDir reads every file with a ".car" extension in the directory, and
+// writes the blocks in each to the datastore as ReadCar does. Returns the
+// number of CAR files and the total number of blocks read.
+func ReadCarDir(ctx context.Context, dir string, ds datastore.Write) (int, int, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, 0, err
+	}
+	var files, blocks int
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.EqualFold(filepath.Ext(entry.Name()), ".car") {
+			continue
+		}
+		fileName := filepath.Join(dir, entry.Name())
+		f, err := os.Open(fileName)
+		if err != nil {
+			return files, blocks, err
+		}
+		_, count, err := ReadCar(ctx, f, ds)
+		f.Close()
+		blocks += count
+		if err != nil {
+			return files, blocks, fmt.Errorf("cannot read %s: %w", fileName, err)
+		}
+		files++
+	}
+	if files == 0 {
+		return 0, 0, fmt.Errorf("no car files in %s", dir)
+	}
+	return files, blocks, nil
+}
+
+// WriteCar writes a CARv1 stream, with the given roots, that holds every block
+// in the datastore whose key is a CID string, as written by ReadCar. Other
+// keys are skipped. Returns the number of blocks written.
+func WriteCar(ctx context.Context, out io.Writer, roots []cid.Cid, ds datastore.Read) (int, error) {
+	header, err := qp.BuildMap(basicnode.Prototype.Any, 2, func(ma datamodel.MapAssembler) {
+		qp.MapEntry(ma, "roots", qp.List(int64(len(roots)), func(la datamodel.ListAssembler) {
+			for _, root := range roots {
+				qp.ListEntry(la, qp.Link(cidlink.Link{Cid: root}))
+			}
+		}))
+		qp.MapEntry(ma, "version", qp.Int(1))
+	})
+	if err != nil {
+		return 0, fmt.Errorf("cannot build car header: %w", err)
+	}
+	var buf bytes.Buffer
+	if err = dagcbor.Encode(header, &buf); err != nil {
+		return 0, fmt.Errorf("cannot encode car header: %w", err)
+	}
+
+	w := bufio.NewWriter(out)
+	if err = writeCarSection(w, buf.Bytes()); err != nil {
+		return 0, err
+	}
+
+	results, err := ds.Query(ctx, query.Query{})
+	if err != nil {
+		return 0, err
+	}
+	defer results.Close()
+
+	var count int
+	for result := range results.Next() {
+		if result.Error != nil {
+			return count, result.Error
+		}
+		c, err := cid.Decode(datastore.RawKey(result.Key).BaseNamespace())
+		if err != nil {
+			continue
+		}
+		if err = writeCarSection(w, append(c.Bytes(), result.Value...)); err != nil {
+			return count, fmt.Errorf("cannot write block %s: %w", c, err)
+		}
+		count++
+	}
+	return count, w.Flush()
+}
+
+func writeCarSection(w *bufio.Writer, section []byte) error {
+	if _, err := w.Write(varint.ToUvarint(uint64(len(section)))); err != nil {
+		return err
+	}
+	_, err := w.Write(section)
+	return err
+}
+
+func readCarSection(r *bufio.Reader) ([]byte, error) {
+	size, err := varint.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if size == 0 {
+		return nil, errors.New("zero length section")
+	}
+	if size > maxCarSectionSize {
+		return nil, fmt.Errorf("section size %d exceeds maximum %d", size, maxCarSectionSize)
+	}
+	buf := make([]byte, size)
+	if _, err = io.ReadFull(r, buf); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return buf, nil
+}
+
+func decodeCarHeader(data []byte) ([]cid.Cid, error) {
+	nb := basicnode.Prototype.Map.NewBuilder()
+	err := dagcbor.Decode(nb, bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode car header: %w", err)
+	}
+	n := nb.Build()
+
+	versionNode, err := n.LookupByString("version")
+	if err != nil {
+		return nil, errors.New("car header missing version")
+	}
+	version, err := versionNode.AsInt()
+	if err != nil || version != 1 {
+		return nil, errors.New("only car version 1 is supported")
+	}
+
+	rootsNode, err := n.LookupByString("roots")
+	if err != nil {
+		return nil, errors.New("car header missing roots")
+	}
+	var roots []cid.Cid
+	it := rootsNode.ListIterator()
+	for it != nil && !it.Done() {
+		_, rn, err := it.Next()
+		if err != nil {
+			return nil, fmt.Errorf("cannot read car header roots: %w", err)
+		}
+		lnk, err := rn.AsLink()
+		if err != nil {
+			return nil, fmt.Errorf("car root is not a link: %w", err)
+		}
+		roots = append(roots, lnk.(cidlink.Link).Cid)
+	}
+	return roots, nil
+}
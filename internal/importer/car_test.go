@@ -0,0 +1,125 @@
+package importer
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipld/go-ipld-prime/codec/dagcbor"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/fluent/qp"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/multiformats/go-multihash"
+	"github.com/multiformats/go-varint"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadCar(t *testing.T) {
+	blocks := [][]byte{[]byte("block one"), []byte("block two"), []byte("block three")}
+	cids, car := makeTestCar(t, blocks)
+
+	ds := datastore.NewMapDatastore()
+	roots, count, err := ReadCar(context.Background(), bytes.NewReader(car), ds)
+	require.NoError(t, err)
+	require.Equal(t, len(blocks), count)
+	require.Equal(t, []cid.Cid{cids[0]}, roots)
+
+	for i := range blocks {
+		val, err := ds.Get(context.Background(), datastore.NewKey(cids[i].String()))
+		require.NoError(t, err)
+		require.Equal(t, blocks[i], val)
+	}
+
+	// Truncated CAR is an error.
+	_, _, err = ReadCar(context.Background(), bytes.NewReader(car[:3]), ds)
+	require.Error(t, err)
+}
+
+func TestReadCarDir(t *testing.T) {
+	dir := t.TempDir()
+	_, _, err := ReadCarDir(context.Background(), dir, datastore.NewMapDatastore())
+	require.ErrorContains(t, err, "no car files")
+
+	cids1, car1 := makeTestCar(t, [][]byte{[]byte("block one"), []byte("block two")})
+	cids2, car2 := makeTestCar(t, [][]byte{[]byte("block three")})
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "one.car"), car1, 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "two.CAR"), car2, 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("not a car"), 0o644))
+
+	ds := datastore.NewMapDatastore()
+	files, blocks, err := ReadCarDir(context.Background(), dir, ds)
+	require.NoError(t, err)
+	require.Equal(t, 2, files)
+	require.Equal(t, 3, blocks)
+	for _, c := range append(cids1, cids2...) {
+		has, err := ds.Has(context.Background(), datastore.NewKey(c.String()))
+		require.NoError(t, err)
+		require.True(t, has)
+	}
+}
+
+func TestWriteCar(t *testing.T) {
+	ctx := context.Background()
+	blocks := [][]byte{[]byte("block one"), []byte("block two"), []byte("block three")}
+	cids, car := makeTestCar(t, blocks)
+
+	ds := datastore.NewMapDatastore()
+	_, _, err := ReadCar(ctx, bytes.NewReader(car), ds)
+	require.NoError(t, err)
+	// Keys that are not CIDs are not written.
+	require.NoError(t, ds.Put(ctx, datastore.NewKey("/other/key"), []byte("not a block")))
+
+	var out bytes.Buffer
+	count, err := WriteCar(ctx, &out, []cid.Cid{cids[1]}, ds)
+	require.NoError(t, err)
+	require.Equal(t, len(blocks), count)
+
+	ds2 := datastore.NewMapDatastore()
+	roots, count, err := ReadCar(ctx, &out, ds2)
+	require.NoError(t, err)
+	require.Equal(t, len(blocks), count)
+	require.Equal(t, []cid.Cid{cids[1]}, roots)
+	for i := range blocks {
+		val, err := ds2.Get(ctx, datastore.NewKey(cids[i].String()))
+		require.NoError(t, err)
+		require.Equal(t, blocks[i], val)
+	}
+}
+
+// makeTestCar returns the CIDs of the blocks, and a CAR that holds the blocks
+// with the first block as root.
+func makeTestCar(t *testing.T, blocks [][]byte) ([]cid.Cid, []byte) {
+	cids := make([]cid.Cid, len(blocks))
+	for i := range blocks {
+		mh, err := multihash.Sum(blocks[i], multihash.SHA2_256, -1)
+		require.NoError(t, err)
+		cids[i] = cid.NewCidV1(cid.Raw, mh)
+	}
+
+	header, err := qp.BuildMap(basicnode.Prototype.Any, 2, func(ma datamodel.MapAssembler) {
+		qp.MapEntry(ma, "roots", qp.List(1, func(la datamodel.ListAssembler) {
+			qp.ListEntry(la, qp.Link(cidlink.Link{Cid: cids[0]}))
+		}))
+		qp.MapEntry(ma, "version", qp.Int(1))
+	})
+	require.NoError(t, err)
+
+	var headerBuf bytes.Buffer
+	require.NoError(t, dagcbor.Encode(header, &headerBuf))
+
+	var car bytes.Buffer
+	car.Write(varint.ToUvarint(uint64(headerBuf.Len())))
+	car.Write(headerBuf.Bytes())
+	for i := range blocks {
+		cidBytes := cids[i].Bytes()
+		car.Write(varint.ToUvarint(uint64(len(cidBytes) + len(blocks[i]))))
+		car.Write(cidBytes)
+		car.Write(blocks[i])
+	}
+	return cids, car.Bytes()
+}
@@ -0,0 +1,69 @@
+package ingest
+
+import (
+	"context"
+
+	"github.com/filecoin-project/storetheindex/api/v0/ingest/schema"
+	"github.com/filecoin-project/storetheindex/internal/metrics"
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/ipfs/go-cid"
+	"go.opencensus.io/stats"
+)
+
+// adCache holds decoded advertisements, keyed by advertisement CID, so that an
+// advertisement that is loaded more than once while it is synced and
+// processed is only decoded once. An advertisement's CID is the hash of its
+// content, so a cached advertisement never needs to be invalidated, but it is
+// removed once processed so that it is not returned after its block is
+// removed from the datastore. The cache holds a bounded number of
+// advertisements, evicting the least recently used. A nil adCache caches
+// nothing. It is safe for concurrent use.
+type adCache struct {
+	cache *lru.Cache
+}
+
+// newAdCache returns an adCache that holds up to size advertisements, or nil
+// if size is not positive.
+func newAdCache(size int) *adCache {
+	if size <= 0 {
+		return nil
+	}
+	cache, err := lru.New(size)
+	if err != nil {
+		panic(err)
+	}
+	return &adCache{cache: cache}
+}
+
+// get returns the cached advertisement, and records a cache hit or miss.
+func (a *adCache) get(adCid cid.Cid) (schema.Advertisement, bool) {
+	if a == nil {
+		return schema.Advertisement{}, false
+	}
+	v, ok := a.cache.Get(adCid)
+	if !ok {
+		stats.Record(context.Background(), metrics.AdCacheMiss.M(1))
+		return schema.Advertisement{}, false
+	}
+	stats.Record(context.Background(), metrics.AdCacheHit.M(1))
+	return v.(schema.Advertisement), true
+}
+
+func (a *adCache) add(adCid cid.Cid, ad schema.Advertisement) {
+	if a != nil {
+		a.cache.Add(adCid, ad)
+	}
+}
+
+func (a *adCache) remove(adCid cid.Cid) {
+	if a != nil {
+		a.cache.Remove(adCid)
+	}
+}
+
+func (a *adCache) len() int {
+	if a == nil {
+		return 0
+	}
+	return a.cache.Len()
+}
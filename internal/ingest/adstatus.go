@@ -0,0 +1,113 @@
+package ingest
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/filecoin-project/storetheindex/api/v0/ingest/model"
+	"github.com/filecoin-project/storetheindex/internal/metrics"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+)
+
+func adFailedKey(adCid cid.Cid) datastore.Key {
+	return datastore.NewKey(adFailedPrefix + adCid.String())
+}
+
+// adFailure is the value stored for an advertisement under adFailedPrefix.
+// Failures recorded by earlier versions of the indexer are stored as only the
+// error text.
+type adFailure struct {
+	Err       string
+	Publisher peer.ID
+	// Provider is empty if the advertisement could not be decoded.
+	Provider peer.ID `json:",omitempty"`
+	Time     time.Time
+}
+
+// decodeAdFailure reads the value stored for a failed advertisement.
+func decodeAdFailure(value []byte) adFailure {
+	var failure adFailure
+	if err := json.Unmarshal(value, &failure); err != nil {
+		return adFailure{Err: string(value)}
+	}
+	return failure
+}
+
+// markAdFailed records that the advertisement could not be ingested, and why.
+// The publisher and provider are recorded so that the advertisement can be
+// retried.
+func (ing *Ingester) markAdFailed(publisher, provider peer.ID, adCid cid.Cid, ingestErr error) {
+	value, err := json.Marshal(adFailure{
+		Err:       ingestErr.Error(),
+		Publisher: publisher,
+		Provider:  provider,
+		Time:      time.Now(),
+	})
+	if err != nil {
+		log.Errorw("Failed to encode advertisement failure", "adCid", adCid, "err", err)
+		return
+	}
+	err = ing.ds.Put(context.Background(), adFailedKey(adCid), value)
+	if err != nil {
+		log.Errorw("Failed to record advertisement failure in datastore", "adCid", adCid, "err", err)
+	}
+}
+
+// recordMalformedAd records that the advertisement could not be decoded.
+func (ing *Ingester) recordMalformedAd(publisher peer.ID, adCid cid.Cid, err error) {
+	log.Errorw("Advertisement could not be decoded", "adCid", adCid, "publisher", publisher, "err", err)
+	stats.RecordWithOptions(context.Background(),
+		stats.WithMeasurements(metrics.AdIngestErrorCount.M(1)),
+		stats.WithTags(tag.Insert(metrics.ErrKind, string(adIngestDecodingErr))))
+	ing.markAdFailed(publisher, "", adCid, err)
+}
+
+// clearAdFailed removes any record that the advertisement could not be
+// ingested.
+func (ing *Ingester) clearAdFailed(adCid cid.Cid) {
+	err := ing.ds.Delete(context.Background(), adFailedKey(adCid))
+	if err != nil {
+		log.Errorw("Failed to remove advertisement failure from datastore", "adCid", adCid, "err", err)
+	}
+}
+
+// AdStatus returns the ingestion status of the advertisement. An
+// advertisement that failed with a permanent error is skipped, so is also
+// processed, but is reported as failed.
+func (ing *Ingester) AdStatus(ctx context.Context, adCid cid.Cid) (model.AdStatus, error) {
+	status := model.AdStatus{
+		AdCid: adCid,
+	}
+
+	value, err := ing.ds.Get(ctx, adFailedKey(adCid))
+	if err == nil {
+		status.Status = model.AdStatusFailed
+		status.Err = decodeAdFailure(value).Err
+		return status, nil
+	}
+	if err != datastore.ErrNotFound {
+		return status, err
+	}
+
+	if ing.adAlreadyProcessed(adCid) {
+		status.Status = model.AdStatusProcessed
+		return status, nil
+	}
+
+	// A synced advertisement is held in the datastore until it is processed.
+	held, err := ing.ds.Has(ctx, datastore.NewKey(adCid.String()))
+	if err != nil {
+		return status, err
+	}
+	if held {
+		status.Status = model.AdStatusSyncing
+	} else {
+		status.Status = model.AdStatusUnknown
+	}
+	return status, nil
+}
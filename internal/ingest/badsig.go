@@ -0,0 +1,87 @@
+package ingest
+
+import (
+	"context"
+	"sync"
+
+	"github.com/filecoin-project/storetheindex/internal/metrics"
+	"github.com/filecoin-project/storetheindex/internal/registry"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+)
+
+// unknownSigner is the publisher tag value recorded for an advertisement whose
+// signature could not be verified, so that its signer is not known.
+const unknownSigner = "unknown"
+
+// badSigTracker records advertisements rejected because of their signatures,
+// and counts the consecutive rejected advertisements from each publisher. A
+// publisher is blocked when its count reaches the limit, if the limit is
+// non-zero. A publisher is identified by the key that signed the
+// advertisement, so an advertisement whose signature cannot be verified at all
+// is recorded but does not count towards blocking any publisher, since anyone
+// could have produced it.
+type badSigTracker struct {
+	reg   *registry.Registry
+	limit int
+
+	mutex  sync.Mutex
+	counts map[peer.ID]int
+}
+
+func newBadSigTracker(reg *registry.Registry, limit int) *badSigTracker {
+	return &badSigTracker{
+		reg:    reg,
+		limit:  limit,
+		counts: map[peer.ID]int{},
+	}
+}
+
+// failed records that an advertisement signed by signer was rejected because
+// of its signature. The signer is empty if it is not known.
+func (t *badSigTracker) failed(signer peer.ID) {
+	tagValue := unknownSigner
+	if signer != "" {
+		tagValue = signer.String()
+	}
+	_ = stats.RecordWithOptions(context.Background(),
+		stats.WithTags(tag.Insert(metrics.Publisher, tagValue)),
+		stats.WithMeasurements(metrics.AdSignatureFailure.M(1)))
+
+	if t == nil || t.limit == 0 || signer == "" {
+		return
+	}
+
+	t.mutex.Lock()
+	t.counts[signer]++
+	count := t.counts[signer]
+	if count >= t.limit {
+		delete(t.counts, signer)
+	}
+	t.mutex.Unlock()
+
+	if count >= t.limit {
+		t.reg.BlockPeer(signer)
+		log.Warnw("Blocked publisher after consecutive advertisements with invalid signatures", "publisher", signer, "count", count)
+	}
+}
+
+// verified records that an advertisement signed by signer was accepted,
+// which resets the signer's count of consecutive rejected advertisements.
+func (t *badSigTracker) verified(signer peer.ID) {
+	if t == nil || t.limit == 0 || signer == "" {
+		return
+	}
+	t.mutex.Lock()
+	delete(t.counts, signer)
+	t.mutex.Unlock()
+}
+
+// count returns the number of consecutive advertisements from signer that
+// were rejected because of their signatures.
+func (t *badSigTracker) count(signer peer.ID) int {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.counts[signer]
+}
@@ -0,0 +1,169 @@
+package ingest
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+)
+
+// errTooManyEntries is the error for an entry chunk that has more entries than
+// the configured maximum.
+var errTooManyEntries = errors.New("too many entries in entry chunk")
+
+// limitEntries returns a prototype that builds nodes the same as prototype,
+// except that decoding fails as soon as the Entries list in the node's
+// top-level map has more than max items. This stops an oversized entry chunk
+// from being decoded in full. Advertisements and HAMT nodes have no Entries
+// list, so they are not affected. A max less than 1 returns prototype
+// unchanged.
+func limitEntries(prototype ipld.NodePrototype, max int) ipld.NodePrototype {
+	if max < 1 {
+		return prototype
+	}
+	return entriesLimitPrototype{
+		NodePrototype: prototype,
+		max:           max,
+	}
+}
+
+// entriesKey is the key of the list of multihashes in an entry chunk.
+const entriesKey = "Entries"
+
+type entriesLimitPrototype struct {
+	ipld.NodePrototype
+	max int
+}
+
+func (p entriesLimitPrototype) NewBuilder() ipld.NodeBuilder {
+	return entriesLimitBuilder{
+		NodeBuilder: p.NodePrototype.NewBuilder(),
+		max:         p.max,
+	}
+}
+
+type entriesLimitBuilder struct {
+	ipld.NodeBuilder
+	max int
+}
+
+func (b entriesLimitBuilder) BeginMap(sizeHint int64) (ipld.MapAssembler, error) {
+	ma, err := b.NodeBuilder.BeginMap(sizeHint)
+	if err != nil {
+		return nil, err
+	}
+	return &entriesLimitMapAssembler{
+		MapAssembler: ma,
+		max:          b.max,
+	}, nil
+}
+
+// entriesLimitMapAssembler limits the value of the Entries key. Codecs
+// assemble a key before its value, so the last key assembled is the key of
+// the value being assembled.
+type entriesLimitMapAssembler struct {
+	ipld.MapAssembler
+	max int
+	key string
+}
+
+func (ma *entriesLimitMapAssembler) AssembleKey() ipld.NodeAssembler {
+	ma.key = ""
+	return keyRecorder{
+		NodeAssembler: ma.MapAssembler.AssembleKey(),
+		key:           &ma.key,
+	}
+}
+
+func (ma *entriesLimitMapAssembler) AssembleEntry(k string) (ipld.NodeAssembler, error) {
+	na, err := ma.MapAssembler.AssembleEntry(k)
+	if err != nil {
+		return nil, err
+	}
+	if k != entriesKey {
+		return na, nil
+	}
+	return entriesLimitAssembler{
+		NodeAssembler: na,
+		max:           ma.max,
+	}, nil
+}
+
+func (ma *entriesLimitMapAssembler) AssembleValue() ipld.NodeAssembler {
+	na := ma.MapAssembler.AssembleValue()
+	if ma.key != entriesKey {
+		return na
+	}
+	return entriesLimitAssembler{
+		NodeAssembler: na,
+		max:           ma.max,
+	}
+}
+
+// keyRecorder records the string assigned to a map key.
+type keyRecorder struct {
+	ipld.NodeAssembler
+	key *string
+}
+
+func (ka keyRecorder) AssignString(s string) error {
+	*ka.key = s
+	return ka.NodeAssembler.AssignString(s)
+}
+
+type entriesLimitAssembler struct {
+	ipld.NodeAssembler
+	max int
+}
+
+func (na entriesLimitAssembler) BeginList(sizeHint int64) (ipld.ListAssembler, error) {
+	// Codecs that know the length of the list up front give it as the size
+	// hint, so the list can be rejected before any of it is decoded.
+	if sizeHint > int64(na.max) {
+		return nil, tooManyEntries(na.max)
+	}
+	la, err := na.NodeAssembler.BeginList(sizeHint)
+	if err != nil {
+		return nil, err
+	}
+	return &entriesLimitListAssembler{
+		ListAssembler: la,
+		max:           na.max,
+	}, nil
+}
+
+type entriesLimitListAssembler struct {
+	ipld.ListAssembler
+	max   int
+	count int
+}
+
+func (la *entriesLimitListAssembler) AssembleValue() ipld.NodeAssembler {
+	la.count++
+	if la.count > la.max {
+		return errAssembler{tooManyEntries(la.max)}
+	}
+	return la.ListAssembler.AssembleValue()
+}
+
+func tooManyEntries(max int) error {
+	return fmt.Errorf("%w: more than %d", errTooManyEntries, max)
+}
+
+// errAssembler is a NodeAssembler that fails to assemble anything, with err.
+type errAssembler struct {
+	err error
+}
+
+func (a errAssembler) BeginMap(int64) (ipld.MapAssembler, error)   { return nil, a.err }
+func (a errAssembler) BeginList(int64) (ipld.ListAssembler, error) { return nil, a.err }
+func (a errAssembler) AssignNull() error                           { return a.err }
+func (a errAssembler) AssignBool(bool) error                       { return a.err }
+func (a errAssembler) AssignInt(int64) error                       { return a.err }
+func (a errAssembler) AssignFloat(float64) error                   { return a.err }
+func (a errAssembler) AssignString(string) error                   { return a.err }
+func (a errAssembler) AssignBytes([]byte) error                    { return a.err }
+func (a errAssembler) AssignLink(ipld.Link) error                  { return a.err }
+func (a errAssembler) AssignNode(ipld.Node) error                  { return a.err }
+func (a errAssembler) Prototype() ipld.NodePrototype               { return basicnode.Prototype.Any }
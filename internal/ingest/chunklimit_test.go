@@ -0,0 +1,71 @@
+package ingest
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/filecoin-project/storetheindex/api/v0/ingest/schema"
+	"github.com/filecoin-project/storetheindex/test/util"
+	"github.com/ipld/go-ipld-prime/codec/dagcbor"
+	"github.com/ipld/go-ipld-prime/codec/dagjson"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/multiformats/go-multicodec"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimitEntries(t *testing.T) {
+	chunk := &schema.EntryChunk{
+		Entries: util.RandomMultihashes(10, rng),
+	}
+	node, err := chunk.ToNode()
+	require.NoError(t, err)
+
+	var jsonBuf, cborBuf bytes.Buffer
+	require.NoError(t, dagjson.Encode(node, &jsonBuf))
+	require.NoError(t, dagcbor.Encode(node, &cborBuf))
+
+	for _, enc := range []struct {
+		codec multicodec.Code
+		data  []byte
+	}{
+		{multicodec.DagJson, jsonBuf.Bytes()},
+		{multicodec.DagCbor, cborBuf.Bytes()},
+	} {
+		t.Run(enc.codec.String(), func(t *testing.T) {
+			decoded, err := decodeIPLDNode(uint64(enc.codec), bytes.NewReader(enc.data), limitEntries(schema.EntryChunkPrototype, 10))
+			require.NoError(t, err)
+			got, err := schema.UnwrapEntryChunk(decoded)
+			require.NoError(t, err)
+			require.Equal(t, chunk.Entries, got.Entries)
+
+			_, err = decodeIPLDNode(uint64(enc.codec), bytes.NewReader(enc.data), limitEntries(schema.EntryChunkPrototype, 9))
+			require.True(t, errors.Is(err, errTooManyEntries), "expected too many entries error, got %v", err)
+
+			_, err = decodeIPLDNode(uint64(enc.codec), bytes.NewReader(enc.data), limitEntries(basicnode.Prototype.Any, 9))
+			require.True(t, errors.Is(err, errTooManyEntries), "expected too many entries error, got %v", err)
+
+			// No limit.
+			_, err = decodeIPLDNode(uint64(enc.codec), bytes.NewReader(enc.data), limitEntries(schema.EntryChunkPrototype, -1))
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestLimitEntriesOtherLists(t *testing.T) {
+	ad := schema.Advertisement{
+		Provider:  "provider",
+		Addresses: make([]string, 10),
+		Entries:   schema.NoEntries,
+		ContextID: []byte("test-context"),
+		Metadata:  []byte("test-metadata"),
+	}
+	node, err := ad.ToNode()
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	require.NoError(t, dagjson.Encode(node, &buf))
+
+	// Only the Entries list is limited.
+	_, err = decodeIPLDNode(uint64(multicodec.DagJson), bytes.NewReader(buf.Bytes()), limitEntries(basicnode.Prototype.Any, 9))
+	require.NoError(t, err)
+}
@@ -1,7 +1,10 @@
 package ingest
 
 import (
+	"errors"
 	"fmt"
+	"strings"
+	"syscall"
 )
 
 type adIngestState string
@@ -18,10 +21,45 @@ const (
 	adIngestRegisterProviderErr adIngestState = "registerErr"
 	adIngestSyncEntriesErr      adIngestState = "syncEntriesErr"
 	adIngestContentNotFound     adIngestState = "contentNotFound"
+	adIngestFilteredErr         adIngestState = "filteredErr"
+	adIngestNotAllowedErr       adIngestState = "notAllowedErr"
+	adIngestUnknownProviderErr  adIngestState = "unknownProviderErr"
+	adIngestOutOfSpaceErr       adIngestState = "outOfSpaceErr"
+	adIngestSignatureErr        adIngestState = "signatureErr"
+	// Happens if an advertisement uses the context ID of removed content and
+	// reuse of removed context IDs is not allowed.
+	adIngestRemovedContextErr adIngestState = "removedContextErr"
 	// Happens if there is an error during ingest of an entry chunk (rather than fetching it).
 	adIngestEntryChunkErr adIngestState = "ingestEntryChunkErr"
+	// Happens if an entry chunk has more entries than the configured maximum.
+	adIngestOversizedChunkErr adIngestState = "oversizedChunkErr"
 )
 
+// errAdsDeferred is the error for a sync whose remaining advertisements are
+// left for the next sync because MaxAdsPerSync was reached.
+var errAdsDeferred = errors.New("maximum advertisements per sync reached, remaining deferred to next sync")
+
+// errWorkCanceled is the error for advertisements that are not ingested
+// because CancelQueuedWork was called for their provider or publisher.
+var errWorkCanceled = errors.New("ingestion canceled")
+
 func (e adIngestError) Error() string {
 	return fmt.Sprintf("%s: %s", e.state, e.err)
 }
+
+// isDecodingErr returns true if the error is from an advertisement that was
+// fetched but could not be decoded.
+func isDecodingErr(err error) bool {
+	var adIngestErr adIngestError
+	return errors.As(err, &adIngestErr) && adIngestErr.state == adIngestDecodingErr
+}
+
+// isOutOfSpace returns true if the error is caused by the disk being full.
+// Some errors from the value store do not wrap the underlying error, so the
+// error message is also checked.
+func isOutOfSpace(err error) bool {
+	if err == nil {
+		return false
+	}
+	return errors.Is(err, syscall.ENOSPC) || strings.Contains(err.Error(), syscall.ENOSPC.Error())
+}
@@ -0,0 +1,62 @@
+package ingest
+
+import (
+	"fmt"
+
+	v0 "github.com/filecoin-project/storetheindex/api/v0"
+	"github.com/filecoin-project/storetheindex/api/v0/ingest/schema"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// AdFilter decides whether an advertisement is ingested. It is called with
+// each decoded advertisement before the advertisement's provider is registered
+// or any of its content is indexed.
+type AdFilter interface {
+	// FilterAd returns nil if the advertisement may be ingested. Otherwise it
+	// returns an error that gives the reason the advertisement is rejected.
+	FilterAd(providerID peer.ID, ad schema.Advertisement) error
+}
+
+// AdFilterFunc adapts a function to the AdFilter interface.
+type AdFilterFunc func(providerID peer.ID, ad schema.Advertisement) error
+
+func (f AdFilterFunc) FilterAd(providerID peer.ID, ad schema.Advertisement) error {
+	return f(providerID, ad)
+}
+
+// NoopAdFilter allows all advertisements to be ingested.
+var NoopAdFilter AdFilter = AdFilterFunc(func(peer.ID, schema.Advertisement) error {
+	return nil
+})
+
+// RetrievableAdFilter rejects advertisements whose metadata does not say how
+// the advertised content can be retrieved. The metadata must start with the
+// multicodec code of a transport protocol. Removal advertisements are always
+// allowed, so that content previously indexed is still removed.
+var RetrievableAdFilter AdFilter = AdFilterFunc(func(_ peer.ID, ad schema.Advertisement) error {
+	if ad.IsRm {
+		return nil
+	}
+	transport, err := v0.MetadataTransport(ad.Metadata)
+	if err != nil {
+		return err
+	}
+	if transport != v0.TransportHTTP && transport.Tag() != "transport" {
+		return fmt.Errorf("metadata has unknown transport %s", transport)
+	}
+	return nil
+})
+
+// SetAdFilter sets the filter that decides whether each advertisement is
+// ingested. Setting nil allows all advertisements. Advertisements rejected by
+// the filter are skipped and not retried.
+func (ing *Ingester) SetAdFilter(filter AdFilter) {
+	if filter == nil {
+		filter = NoopAdFilter
+	}
+	ing.adFilter.Store(&filter)
+}
+
+func (ing *Ingester) filterAd(providerID peer.ID, ad schema.Advertisement) error {
+	return (*ing.adFilter.Load().(*AdFilter)).FilterAd(providerID, ad)
+}
@@ -0,0 +1,252 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/filecoin-project/storetheindex/internal/jitter"
+	"github.com/filecoin-project/storetheindex/internal/safemode"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+)
+
+// errIngestBusy is returned by GCOrphanedBlocks when ingest workers are
+// processing advertisements, or explicit syncs are in flight.
+var errIngestBusy = errors.New("ingest workers are busy")
+
+// holdOffGC keeps GCOrphanedBlocks from running until the returned function is
+// called. If the GC is running, then this waits for it to finish.
+func (ing *Ingester) holdOffGC() func() {
+	ing.gcMutex.Lock()
+	for ing.gcRunning {
+		ing.gcCond.Wait()
+	}
+	ing.gcHolds++
+	ing.gcMutex.Unlock()
+
+	return func() {
+		ing.gcMutex.Lock()
+		ing.gcHolds--
+		ing.gcMutex.Unlock()
+	}
+}
+
+// startGC marks the GC as running, so that holdOffGC waits for it. Returns
+// false if anything is holding off the GC. Call endGC when the GC is done.
+func (ing *Ingester) startGC() bool {
+	ing.gcMutex.Lock()
+	defer ing.gcMutex.Unlock()
+
+	if ing.gcHolds != 0 {
+		return false
+	}
+	ing.gcRunning = true
+	return true
+}
+
+func (ing *Ingester) endGC() {
+	ing.gcMutex.Lock()
+	ing.gcRunning = false
+	ing.gcMutex.Unlock()
+	ing.gcCond.Broadcast()
+}
+
+// runDatastoreGC periodically removes orphaned blocks from the datastore, or
+// only reports them if dryRun is true. This goroutine exits when the ingester
+// is closed.
+func (ing *Ingester) runDatastoreGC(interval time.Duration, dryRun bool) {
+	defer ing.waitForPendingSyncs.Done()
+
+	t := time.NewTimer(jitter.Add(interval, ing.cfg.TimerJitterPercent))
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			t.Reset(jitter.Add(interval, ing.cfg.TimerJitterPercent))
+			count, size, err := ing.GCOrphanedBlocks(ing.closingCtx, dryRun)
+			if err != nil {
+				if errors.Is(err, errIngestBusy) {
+					log.Debug("Skipped datastore GC because ingestion is in progress")
+				} else {
+					log.Errorw("Datastore GC failed", "err", err)
+				}
+			}
+			if count == 0 {
+				continue
+			}
+			if dryRun {
+				log.Infow("Datastore GC found orphaned blocks", "blocks", count, "reclaimableBytes", size)
+			} else {
+				log.Infow("Datastore GC removed orphaned blocks", "blocks", count, "reclaimedBytes", size)
+			}
+		case <-ing.closingCtx.Done():
+			return
+		}
+	}
+}
+
+// GCOrphanedBlocks removes blocks from the datastore that are not needed to
+// ingest any advertisement. These are advertisements that are already
+// processed, and entries blocks that are not reachable from any unprocessed
+// advertisement, such as the entries fetched by an interrupted sync. If dryRun
+// is true, then the orphaned blocks are only counted. Returns the number and
+// total size of the orphaned blocks.
+//
+// Entries are only reachable through blocks that are still in the datastore,
+// so the GC is not done while any ingest worker is processing advertisements,
+// or while an explicit sync, which may mark processed advertisements as
+// unprocessed, is in flight. Workers and syncs that start while the GC is
+// running wait for it to finish.
+func (ing *Ingester) GCOrphanedBlocks(ctx context.Context, dryRun bool) (int, int64, error) {
+	if !ing.startGC() {
+		return 0, 0, errIngestBusy
+	}
+	defer ing.endGC()
+
+	// Get the blocks to check before walking the entries of unprocessed ads.
+	// Any block written after this is not considered.
+	sizes, err := blockSizes(ctx, ing.ds, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var orphans []cid.Cid
+	var roots []cid.Cid
+	links := make(map[cid.Cid][]cid.Cid)
+	for c := range sizes {
+		n, err := ing.loadNode(c, basicnode.Prototype.Any)
+		if err != nil {
+			// The block was removed, or is not IPLD data that this GC
+			// knows about, so leave it alone.
+			if !errors.Is(err, datastore.ErrNotFound) {
+				log.Warnw("Datastore GC cannot load block, skipping", "cid", c, "err", err)
+			}
+			delete(sizes, c)
+			continue
+		}
+		if !isAdvertisement(n) {
+			links[c] = collectLinks(n, nil)
+			continue
+		}
+		if ing.adAlreadyProcessed(c) {
+			orphans = append(orphans, c)
+			continue
+		}
+		ad, err := n.LookupByString("Entries")
+		if err == nil {
+			roots = collectLinks(ad, roots)
+		}
+	}
+
+	// Remove all entries blocks that are reachable from unprocessed ads,
+	// leaving only the orphaned blocks.
+	for len(roots) != 0 {
+		c := roots[len(roots)-1]
+		roots = roots[:len(roots)-1]
+		next, ok := links[c]
+		if !ok {
+			continue
+		}
+		delete(links, c)
+		roots = append(roots, next...)
+	}
+	for c := range links {
+		orphans = append(orphans, c)
+	}
+
+	var count int
+	var total int64
+	for _, c := range orphans {
+		if !dryRun {
+			err = ing.ds.Delete(ctx, datastore.NewKey(c.String()))
+			if err != nil {
+				return count, total, fmt.Errorf("cannot remove block %s: %w", c, err)
+			}
+		}
+		count++
+		total += sizes[c]
+	}
+	return count, total, nil
+}
+
+// blockSizes returns the size of each block stored in the datastore. A block
+// is stored at a key that is the string form of its CID. Keys that cannot be
+// read are skipped if safeMode is not nil.
+func blockSizes(ctx context.Context, ds datastore.Datastore, safeMode *safemode.Skipper) (map[cid.Cid]int64, error) {
+	results, err := ds.Query(ctx, query.Query{
+		KeysOnly:     true,
+		ReturnsSizes: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer results.Close()
+
+	sizes := make(map[cid.Cid]int64)
+	for result := range results.Next() {
+		if result.Error != nil {
+			if safeMode.Skip("sync data", result.Key, result.Error) {
+				continue
+			}
+			return nil, fmt.Errorf("cannot read datastore key: %w", result.Error)
+		}
+		key := datastore.RawKey(result.Key)
+		if len(key.Namespaces()) != 1 {
+			continue
+		}
+		c, err := cid.Decode(key.BaseNamespace())
+		if err != nil {
+			continue
+		}
+		size := result.Size
+		if size < 0 {
+			size, err = ds.GetSize(ctx, key)
+			if err != nil {
+				continue
+			}
+		}
+		sizes[c] = int64(size)
+	}
+	return sizes, nil
+}
+
+// collectLinks appends the CIDs of all links in the node to links, without
+// loading the linked nodes.
+func collectLinks(n ipld.Node, links []cid.Cid) []cid.Cid {
+	switch n.Kind() {
+	case ipld.Kind_Link:
+		lnk, err := n.AsLink()
+		if err != nil {
+			return links
+		}
+		if cl, ok := lnk.(cidlink.Link); ok {
+			links = append(links, cl.Cid)
+		}
+	case ipld.Kind_Map:
+		it := n.MapIterator()
+		for !it.Done() {
+			_, v, err := it.Next()
+			if err != nil {
+				break
+			}
+			links = collectLinks(v, links)
+		}
+	case ipld.Kind_List:
+		it := n.ListIterator()
+		for !it.Done() {
+			_, v, err := it.Next()
+			if err != nil {
+				break
+			}
+			links = collectLinks(v, links)
+		}
+	}
+	return links
+}
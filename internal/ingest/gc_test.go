@@ -0,0 +1,105 @@
+package ingest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/storetheindex/api/v0/ingest/schema"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGCOrphanedBlocks(t *testing.T) {
+	te := setupTestEnv(t, true)
+	ing := te.ingester
+	ctx := context.Background()
+
+	// Entries left in the ingester's datastore that no ad refers to.
+	orphanEntries, _ := newRandomLinkedList(t, ing.lsys, 3)
+
+	// An unprocessed ad, and its entries, must be kept.
+	keptEntries, _ := newRandomLinkedList(t, ing.lsys, 2)
+	ad := &schema.Advertisement{
+		Provider:  te.pubHost.ID().String(),
+		Addresses: []string{"/ip4/127.0.0.1/tcp/9999"},
+		Entries:   keptEntries,
+		ContextID: []byte("test-context"),
+		Metadata:  []byte("test-metadata"),
+	}
+	require.NoError(t, ad.Sign(te.publisherPriv))
+	node, err := ad.ToNode()
+	require.NoError(t, err)
+	adLink, err := ing.lsys.Store(ipld.LinkContext{}, schema.Linkproto, node)
+	require.NoError(t, err)
+
+	hasBlock := func(lnk ipld.Link) bool {
+		has, err := ing.ds.Has(ctx, datastore.NewKey(lnk.(cidlink.Link).Cid.String()))
+		require.NoError(t, err)
+		return has
+	}
+
+	count, size, err := ing.GCOrphanedBlocks(ctx, true)
+	require.NoError(t, err)
+	require.Equal(t, 3, count)
+	require.NotZero(t, size)
+	require.True(t, hasBlock(orphanEntries), "dry run removed block")
+
+	count2, size2, err := ing.GCOrphanedBlocks(ctx, false)
+	require.NoError(t, err)
+	require.Equal(t, count, count2)
+	require.Equal(t, size, size2)
+	require.False(t, hasBlock(orphanEntries))
+	require.True(t, hasBlock(keptEntries))
+	require.True(t, hasBlock(adLink))
+
+	// Once the ad is processed, it and its entries are orphaned.
+	require.NoError(t, ing.ds.Put(ctx, datastore.NewKey(adProcessedPrefix+adLink.(cidlink.Link).Cid.String()), []byte{1}))
+	count, _, err = ing.GCOrphanedBlocks(ctx, false)
+	require.NoError(t, err)
+	require.Equal(t, 3, count)
+	require.False(t, hasBlock(keptEntries))
+	require.False(t, hasBlock(adLink))
+}
+
+func TestGCHeldOff(t *testing.T) {
+	te := setupTestEnv(t, true)
+	ing := te.ingester
+	ctx := context.Background()
+
+	orphanEntries, _ := newRandomLinkedList(t, ing.lsys, 2)
+	orphanKey := datastore.NewKey(orphanEntries.(cidlink.Link).Cid.String())
+
+	release := ing.holdOffGC()
+	_, _, err := ing.GCOrphanedBlocks(ctx, false)
+	require.ErrorIs(t, err, errIngestBusy)
+	has, err := ing.ds.Has(ctx, orphanKey)
+	require.NoError(t, err)
+	require.True(t, has)
+
+	release()
+	count, _, err := ing.GCOrphanedBlocks(ctx, false)
+	require.NoError(t, err)
+	require.Equal(t, 2, count)
+
+	// Holding off the GC waits for a running GC to finish.
+	require.True(t, ing.startGC())
+	held := make(chan struct{})
+	go func() {
+		ing.holdOffGC()()
+		close(held)
+	}()
+	select {
+	case <-held:
+		t.Fatal("GC held off while running")
+	case <-time.After(100 * time.Millisecond):
+	}
+	ing.endGC()
+	select {
+	case <-held:
+	case <-time.After(time.Second):
+		t.Fatal("GC not held off after it finished")
+	}
+}
@@ -1,10 +1,15 @@
 package ingest
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"net/http"
+	"path"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -12,22 +17,31 @@ import (
 	indexer "github.com/filecoin-project/go-indexer-core"
 	coremetrics "github.com/filecoin-project/go-indexer-core/metrics"
 	"github.com/filecoin-project/go-legs"
+	adminmodel "github.com/filecoin-project/storetheindex/api/v0/admin/model"
 	"github.com/filecoin-project/storetheindex/api/v0/ingest/schema"
 	"github.com/filecoin-project/storetheindex/config"
+	"github.com/filecoin-project/storetheindex/internal/eventsink"
+	"github.com/filecoin-project/storetheindex/internal/jitter"
+	"github.com/filecoin-project/storetheindex/internal/logthrottle"
 	"github.com/filecoin-project/storetheindex/internal/metrics"
 	"github.com/filecoin-project/storetheindex/internal/registry"
+	"github.com/filecoin-project/storetheindex/internal/safemode"
 	"github.com/filecoin-project/storetheindex/peerutil"
 	"github.com/hashicorp/go-retryablehttp"
 	"github.com/ipfs/go-cid"
 	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
 	logging "github.com/ipfs/go-log/v2"
 	"github.com/ipld/go-ipld-prime"
 	"github.com/ipld/go-ipld-prime/datamodel"
 	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
 	"github.com/ipld/go-ipld-prime/traversal/selector"
+	pb "github.com/libp2p/go-libp2p-core/crypto/pb"
 	"github.com/libp2p/go-libp2p-core/host"
 	"github.com/libp2p/go-libp2p-core/peer"
 	"github.com/multiformats/go-multiaddr"
+	"github.com/multiformats/go-multihash"
+	"github.com/multiformats/go-varint"
 	"go.opencensus.io/stats"
 	"go.opencensus.io/tag"
 	"golang.org/x/time/rate"
@@ -35,14 +49,60 @@ import (
 
 var log = logging.Logger("indexer/ingest")
 
+// repeatedLogWindow is the time within which repeats of an identical error
+// are counted instead of logged.
+const repeatedLogWindow = time.Minute
+
+// logRepeats logs errors that can repeat at a high rate, such as when a
+// provider is stuck, so that repeats of an identical error are collapsed into
+// one log message with a count.
+var logRepeats = logthrottle.New(&log.SugaredLogger, repeatedLogWindow)
+
 // prefix used to track latest sync in datastore.
 const (
 	// syncPrefix identifies the latest sync for each provider.
 	syncPrefix = "/sync/"
 	// adProcessedPrefix identifies all processed advertisements.
 	adProcessedPrefix = "/adProcessed/"
+	// adFailedPrefix identifies advertisements that could not be ingested.
+	adFailedPrefix = "/adFailed/"
+	// chunkProcessedPrefix identifies entry chunks that have been indexed for
+	// a provider context.
+	chunkProcessedPrefix = "/chunkProcessed/"
+	// adCheckpointPrefix identifies the next entry chunk to index for an
+	// advertisement whose indexing was interrupted.
+	adCheckpointPrefix = "/adCheckpoint/"
+	// walPrefix identifies the advertisement chains that are to be processed,
+	// when the write-ahead log is enabled.
+	walPrefix = "/wal/"
+	// contextTombstonePrefix identifies the number of times content has been
+	// removed from a provider's context.
+	contextTombstonePrefix = "/tombstone/ctx/"
+	// providerTombstonePrefix identifies the number of times a provider has
+	// been removed.
+	providerTombstonePrefix = "/tombstone/prov/"
+	// syncHistoryPrefix identifies the summaries of recent syncs for each
+	// provider.
+	syncHistoryPrefix = "/syncHistory/"
 )
 
+// adProcessedEventBuffer is the number of advertisement processed events that
+// are buffered for each onAdProcessed reader. If a reader falls further
+// behind than this, then its oldest events are dropped.
+const adProcessedEventBuffer = 16
+
+// startupSyncConcurrency is the maximum number of publishers that are synced
+// at the same time when resyncing on startup.
+const startupSyncConcurrency = 8
+
+// connProtectTag is the prefix of the connection manager tags that protect
+// connections to peers being synced.
+const connProtectTag = "indexer-ingest-sync"
+
+// outOfSpaceRetry is how long ingestion is paused when the value store runs
+// out of space, before trying again.
+var outOfSpaceRetry = time.Minute
+
 type adProcessedEvent struct {
 	publisher peer.ID
 	// Head of the chain being processed.
@@ -64,6 +124,8 @@ type pendingAnnounce struct {
 type adInfo struct {
 	cid cid.Cid
 	ad  schema.Advertisement
+	// retry is true if the ad is being ingested again after it failed.
+	retry bool
 }
 
 type workerAssignment struct {
@@ -82,18 +144,45 @@ type Ingester struct {
 	lsys    ipld.LinkSystem
 	indexer indexer.Interface
 
+	// keyTypes is the set of key types that advertisements may be signed
+	// with. Any key type is allowed if it is empty.
+	keyTypes map[pb.KeyType]struct{}
+	// sigs caches the results of verifying advertisement signatures.
+	sigs *verifiedSigCache
+
+	// syncData is ds, which keeps track of the size of the blocks fetched by
+	// syncs that are not yet processed, if SyncDataLimit is set.
+	syncData *syncDataStore
+
 	batchSize uint32
 	closeOnce sync.Once
 	sigUpdate chan struct{}
 
 	sub         *legs.Subscriber
+	syncHandler *syncHandler
 	syncTimeout time.Duration
 
+	// entriesSel syncs the entry chunks that follow the first chunk of an
+	// advertisement's entries. It is nil if only the first chunk is synced.
 	entriesSel datamodel.Node
 	reg        *registry.Registry
 
+	// depthOverrides holds the depth limits configured for specific
+	// providers.
+	depthOverrides map[peer.ID]depthLimits
+	// httpSyncAddrs holds the HTTP addresses of providers that are always
+	// synced over HTTP.
+	httpSyncAddrs map[peer.ID]multiaddr.Multiaddr
+
 	cfg config.Ingest
 
+	// adCache holds recently loaded advertisements.
+	adCache *adCache
+
+	// adFilter holds the AdFilter that decides whether each advertisement is
+	// ingested.
+	adFilter atomic.Value
+
 	// inEvents is used to send a adProcessedEvent to the distributeEvents
 	// goroutine, when an advertisement in marked complete or err'd.
 	inEvents chan adProcessedEvent
@@ -101,6 +190,12 @@ type Ingester struct {
 	// outEventsChans is a slice of channels, where each channel delivers a
 	// copy of an adProcessedEvent to an onAdProcessed reader.
 	outEventsChans map[peer.ID][]chan adProcessedEvent
+	// syncResults holds the results that Sync calls are waiting for, for
+	// each publisher. It is protected by outEventsMutex.
+	syncResults map[peer.ID][]*syncResult
+	// eventSink, if not nil, publishes a copy of each adProcessedEvent to an
+	// external message bus. It is protected by outEventsMutex.
+	eventSink      *eventsink.Async
 	outEventsMutex sync.Mutex
 
 	waitForPendingSyncs sync.WaitGroup
@@ -108,11 +203,33 @@ type Ingester struct {
 
 	cancelOnSyncFinished context.CancelFunc
 
+	// closingCtx is cancelled when the ingester is closed, to interrupt the
+	// indexing of advertisements that are being ingested.
+	closingCtx    context.Context
+	cancelClosing context.CancelFunc
+
 	// A map of providers currently being processed. A worker holds the lock of
 	// a provider while ingesting ads for that provider.
 	providersBeingProcessed   map[peer.ID]chan struct{}
 	providersBeingProcessedMu sync.Mutex
 	providerAdChainStaging    map[peer.ID]*atomic.Value
+	// providerAdLimiters limits the rate at which each provider's ads are
+	// ingested, when ProviderAdsPerMinute is configured. Guarded by
+	// providersBeingProcessedMu.
+	providerAdLimiters map[peer.ID]*rate.Limiter
+	providerAdRate     rate.Limit
+	// workCancels counts the calls to CancelQueuedWork for each peer, so that
+	// a worker can tell when its work is canceled. Guarded by
+	// providersBeingProcessedMu.
+	workCancels map[peer.ID]uint64
+	// carriedOverAds holds, for each provider, the ads that a worker left
+	// unprocessed after ingesting MaxAdsPerSync ads. They are ingested with
+	// the ads of the next sync. Guarded by providersBeingProcessedMu.
+	carriedOverAds map[peer.ID]workerAssignment
+
+	// outOfSpaceUntil holds the time.Time until which ingestion is paused
+	// because the value store ran out of space.
+	outOfSpaceUntil atomic.Value
 
 	closeWorkers chan struct{}
 	// toStaging receives sync finished events used to call to runIngestStep.
@@ -122,50 +239,171 @@ type Ingester struct {
 	toWorkers      chan providerID
 	waitForWorkers sync.WaitGroup
 	workerPoolSize int
+	// providerSlots limits the number of providers that are ingested
+	// concurrently, when MaxConcurrentProviders is configured. A worker puts
+	// a value into providerSlots before it takes a provider to ingest, and
+	// removes it when done with the provider.
+	providerSlots chan struct{}
+
+	// inFlightSyncs holds the syncs started by Sync that have not finished,
+	// keyed by sync ID.
+	inFlightSyncs map[uint64]*inFlightSync
+	inFlightSeq   uint64
+	inFlightMutex sync.Mutex
+
+	// protectCount numbers the tags used to protect connections to peers that
+	// are being synced, so that each sync has its own tag. Accessed
+	// atomically.
+	protectCount uint64
+
+	// Counters for queue and worker metrics, accessed atomically.
+	// pendingWork is the number of providers scheduled on toWorkers that no
+	// worker has picked up yet. liveWorkers and busyWorkers are the number of
+	// running workers and the number of those processing ads.
+	pendingWork int32
+	liveWorkers int32
+	busyWorkers int32
+
+	// gcRunning is true while GCOrphanedBlocks is removing blocks, and
+	// gcHolds is the number of workers and syncs that hold off the GC. Both
+	// are guarded by gcMutex, and gcCond is signaled when the GC finishes.
+	gcMutex   sync.Mutex
+	gcCond    *sync.Cond
+	gcRunning bool
+	gcHolds   int
 
 	// RateLimiting
 	rateApply peerutil.Policy
 	rateBurst int
 
+	// debouncedAnnounces maps the provider ID to the latest announcement
+	// received from the provider, while waiting for AnnounceDebounce to
+	// elapse.
+	debouncedAnnounces map[peer.ID]pendingAnnounce
+	debounceMutex      sync.Mutex
+
 	// providersPendingAnnounce maps the provider ID to the latest announcement received from the
 	// provider that is waiting to be processed.
 	providersPendingAnnounce sync.Map
 
+	// snapshotPublishers maps each temporary publisher started by
+	// IngestSnapshot to the provider that it serves a snapshot for.
+	snapshotPublishers sync.Map
+
 	rateLimit rate.Limit
 	rateMutex sync.Mutex
+
+	// safeMode, if not nil, skips corrupt entries when loading state from the
+	// datastore at startup.
+	safeMode *safemode.Skipper
+
+	// invalidMhCounts is the number of entries from each provider that were
+	// not indexed because they are not valid multihashes.
+	invalidMhCounts map[peer.ID]uint64
+	invalidMhMutex  sync.Mutex
+}
+
+type ingesterOptions struct {
+	safeMode *safemode.Skipper
+}
+
+// Option configures optional Ingester behavior.
+type Option func(*ingesterOptions)
+
+// WithSafeMode makes the ingester skip datastore entries that cannot be read
+// while loading its state at startup, instead of failing, and record them in
+// skipper.
+func WithSafeMode(skipper *safemode.Skipper) Option {
+	return func(o *ingesterOptions) {
+		o.safeMode = skipper
+	}
 }
 
 // NewIngester creates a new Ingester that uses a go-legs Subscriber to handle
 // communication with providers.
-func NewIngester(cfg config.Ingest, h host.Host, idxr indexer.Interface, reg *registry.Registry, ds datastore.Batching) (*Ingester, error) {
+func NewIngester(cfg config.Ingest, h host.Host, idxr indexer.Interface, reg *registry.Registry, ds datastore.Batching, options ...Option) (*Ingester, error) {
+	keyTypes, err := makeKeyTypeSet(cfg.AllowedKeyTypes)
+	if err != nil {
+		return nil, err
+	}
+
+	var opts ingesterOptions
+	for _, opt := range options {
+		opt(&opts)
+	}
+
+	sigs := newVerifiedSigCache(verifiedSigCacheSize)
+
+	syncData, err := newSyncDataStore(context.Background(), ds, cfg.SyncDataLimit, opts.safeMode)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get size of sync data in datastore: %w", err)
+	}
 
 	ing := &Ingester{
 		host:        h,
-		ds:          ds,
-		lsys:        mkLinkSystem(ds, reg),
+		ds:          syncData,
+		syncData:    syncData,
+		lsys:        mkLinkSystem(syncData, reg, keyTypes, cfg.SkipTrustedSignatureCheck, cfg.MaxEntriesPerChunk, sigs, newBadSigTracker(reg, cfg.BadSignatureBlockLimit)),
+		keyTypes:    keyTypes,
+		sigs:        sigs,
 		indexer:     idxr,
 		batchSize:   uint32(cfg.StoreBatchSize),
 		sigUpdate:   make(chan struct{}, 1),
 		syncTimeout: time.Duration(cfg.SyncTimeout),
-		entriesSel:  Selectors.EntriesWithLimit(recursionLimit(cfg.EntriesDepthLimit)),
+		entriesSel:  entriesSelectorAfterFirst(cfg.EntriesDepthLimit),
 		reg:         reg,
 		cfg:         cfg,
+		adCache:     newAdCache(cfg.AdCacheSize),
 		inEvents:    make(chan adProcessedEvent, 1),
+		safeMode:    opts.safeMode,
 
 		closePendingSyncs: make(chan struct{}),
 
+		debouncedAnnounces:      make(map[peer.ID]pendingAnnounce),
+		inFlightSyncs:           make(map[uint64]*inFlightSync),
 		providersBeingProcessed: make(map[peer.ID]chan struct{}),
 		providerAdChainStaging:  make(map[peer.ID]*atomic.Value),
+		workCancels:             make(map[peer.ID]uint64),
+		carriedOverAds:          make(map[peer.ID]workerAssignment),
 		toWorkers:               make(chan providerID),
 		closeWorkers:            make(chan struct{}),
 	}
 
-	var err error
+	if cfg.MaxConcurrentProviders > 0 {
+		ing.providerSlots = make(chan struct{}, cfg.MaxConcurrentProviders)
+	}
+
+	ing.closingCtx, ing.cancelClosing = context.WithCancel(context.Background())
+	ing.gcCond = sync.NewCond(&ing.gcMutex)
+
+	if cfg.FilterUnretrievableAds {
+		ing.SetAdFilter(RetrievableAdFilter)
+	} else {
+		ing.SetAdFilter(NoopAdFilter)
+	}
+
 	ing.rateApply, ing.rateBurst, ing.rateLimit, err = configRateLimit(cfg.RateLimit)
 	if err != nil {
 		log.Error(err.Error())
 	}
 
+	if cfg.ProviderAdsPerMinute > 0 {
+		ing.providerAdLimiters = make(map[peer.ID]*rate.Limiter)
+		ing.providerAdRate = rate.Limit(float64(cfg.ProviderAdsPerMinute) / 60)
+	}
+
+	ing.depthOverrides, err = makeDepthOverrideMap(cfg.DepthLimitOverrides)
+	if err != nil {
+		return nil, err
+	}
+
+	ing.httpSyncAddrs, err = makeHttpSyncAddrMap(cfg.HttpSyncOverrides)
+	if err != nil {
+		return nil, err
+	}
+
+	ing.syncHandler = &syncHandler{ing: ing}
+
 	// Instantiate retryable HTTP client used by legs httpsync.
 	rclient := &retryablehttp.Client{
 		HTTPClient: &http.Client{
@@ -180,10 +418,10 @@ func NewIngester(cfg config.Ingest, h host.Host, idxr indexer.Interface, reg *re
 
 	// Create and start pubsub subscriber. This also registers the storage hook
 	// to index data as it is received.
-	sub, err := legs.NewSubscriber(h, ds, ing.lsys, cfg.PubSubTopic, Selectors.AdSequence,
-		legs.AllowPeer(reg.Allowed),
+	sub, err := legs.NewSubscriber(h, ing.ds, ing.lsys, cfg.PubSubTopic, Selectors.AdSequence,
+		legs.AllowPeer(ing.allowAnnounce),
 		legs.SyncRecursionLimit(recursionLimit(cfg.AdvertisementDepthLimit)),
-		legs.UseLatestSyncHandler(&syncHandler{ing}),
+		legs.UseLatestSyncHandler(ing.syncHandler),
 		legs.RateLimiter(ing.getRateLimiter),
 		legs.SegmentDepthLimit(int64(cfg.SyncSegmentDepthLimit)),
 		legs.HttpClient(rclient.StandardClient()),
@@ -202,8 +440,24 @@ func NewIngester(cfg config.Ingest, h host.Host, idxr indexer.Interface, reg *re
 	if cfg.IngestWorkerCount == 0 {
 		return nil, errors.New("ingester worker count must be > 0")
 	}
+	var replay []legs.SyncFinished
+	if cfg.WriteAheadLog {
+		replay, err = ing.readWAL(context.Background())
+		if err != nil {
+			return nil, fmt.Errorf("cannot read ingest write-ahead log: %w", err)
+		}
+	}
+
+	if cfg.EventSink.URL != "" {
+		sink, err := eventsink.New(cfg.EventSink.URL, cfg.EventSink.Subject)
+		if err != nil {
+			return nil, fmt.Errorf("cannot create event sink: %w", err)
+		}
+		ing.eventSink = eventsink.NewAsync(sink, cfg.EventSink.BufferSize)
+	}
+
 	ing.RunWorkers(cfg.IngestWorkerCount)
-	go ing.runIngesterLoop()
+	go ing.runIngesterLoop(replay)
 
 	// Start distributor to send SyncFinished messages to interested parties.
 	go ing.distributeEvents()
@@ -212,12 +466,27 @@ func NewIngester(cfg config.Ingest, h host.Host, idxr indexer.Interface, reg *re
 
 	go ing.autoSync()
 
+	if cfg.DatastoreGCInterval != 0 {
+		ing.waitForPendingSyncs.Add(1)
+		go ing.runDatastoreGC(time.Duration(cfg.DatastoreGCInterval), cfg.DatastoreGCDryRun)
+	}
+
+	if cfg.EntryChunkMarkerTTL != 0 {
+		ing.waitForPendingSyncs.Add(1)
+		go ing.runChunkMarkerExpiry(time.Duration(cfg.EntryChunkMarkerTTL))
+	}
+
+	if cfg.ResyncOnStartup {
+		ing.waitForPendingSyncs.Add(1)
+		go ing.syncAllProviders()
+	}
+
 	log.Debugf("Ingester started and all hooks and linksystem registered")
 
 	return ing, nil
 }
 
-func (ing *Ingester) generalLegsBlockHook(_ peer.ID, c cid.Cid, actions legs.SegmentSyncActions) {
+func (ing *Ingester) generalLegsBlockHook(publisher peer.ID, c cid.Cid, actions legs.SegmentSyncActions) {
 	// The only kind of block we should get by loading CIDs here should be Advertisement.
 	// Because:
 	//  - the default subscription selector only selects advertisements.
@@ -229,6 +498,13 @@ func (ing *Ingester) generalLegsBlockHook(_ peer.ID, c cid.Cid, actions legs.Seg
 	// Therefore, we only attempt to load advertisements here and signal failure if the
 	// load fails.
 	if ad, err := ing.loadAd(c); err != nil {
+		if isDecodingErr(err) {
+			// The link to the previous advertisement cannot be read from an
+			// advertisement that does not decode, so the rest of the chain
+			// cannot be synced. Record the failure so that it is reported
+			// for the advertisement CID.
+			ing.recordMalformedAd(publisher, c, err)
+		}
 		actions.FailSync(err)
 	} else if ad.PreviousID != nil {
 		actions.SetNextSyncCid(ad.PreviousID.(cidlink.Link).Cid)
@@ -262,10 +538,19 @@ func (ing *Ingester) Close() error {
 		}
 	}
 	ing.outEventsChans = nil
+	eventSink := ing.eventSink
+	ing.eventSink = nil
 	ing.outEventsMutex.Unlock()
+	if eventSink != nil {
+		if sinkErr := eventSink.Close(); sinkErr != nil {
+			log.Errorw("Error closing event sink", "err", sinkErr)
+		}
+	}
 
 	ing.closeOnce.Do(func() {
 		ing.cancelOnSyncFinished()
+		// Interrupt any advertisements being indexed, so that workers stop.
+		ing.cancelClosing()
 		close(ing.closeWorkers)
 		ing.waitForWorkers.Wait()
 		close(ing.closePendingSyncs)
@@ -296,7 +581,9 @@ func (ing *Ingester) Close() error {
 //
 // The depth argument specifies the recursion depth limit to use during sync.
 // Its value may less than -1 for no limit, 0 to use the indexer's configured
-// value, or greater than 1 for an explicit limit.
+// value, or greater than 1 for an explicit limit. The configured value is the
+// AdvertisementDepthLimit in DepthLimitOverrides for the peer if there is
+// one, and otherwise the global AdvertisementDepthLimit.
 //
 // The resync argument specifies whether to stop the traversal at the latest
 // known advertisement that is already synced. If set to true, the traversal
@@ -309,26 +596,51 @@ func (ing *Ingester) Close() error {
 // is constructed and used for traversal. See legs.Subscriber.Sync.
 //
 // The Context argument controls the lifetime of the sync. Canceling it cancels
-// the sync and causes the multihash channel to close without any data.
+// the sync and causes the multihash channel to close without any data. The
+// sync is listed by InFlightSyncs until it finishes, and can also be cancelled
+// by CancelSync.
 func (ing *Ingester) Sync(ctx context.Context, peerID peer.ID, peerAddr multiaddr.Multiaddr, depth int, resync bool) (<-chan cid.Cid, error) {
+	return ing.sync(ctx, peerID, peerAddr, depth, resync, time.Time{})
+}
+
+// sync does the work of Sync and SyncSince. If since is not zero, then
+// resync must be true, and advertisements first processed before since are
+// not re-ingested and end the sync.
+func (ing *Ingester) sync(ctx context.Context, peerID peer.ID, peerAddr multiaddr.Multiaddr, depth int, resync bool, since time.Time) (<-chan cid.Cid, error) {
 	if err := peerID.Validate(); err != nil {
 		return nil, err
 	}
 
+	if httpAddr, ok := ing.httpSyncAddrs[peerID]; ok {
+		peerAddr = httpAddr
+	}
+
 	out := make(chan cid.Cid, 1)
 
+	ctx, cancelSync := context.WithCancel(ctx)
+	syncID := ing.startInFlightSync(peerID, depth, resync, cancelSync)
+
 	ing.waitForPendingSyncs.Add(1)
 	go func() {
 		defer ing.waitForPendingSyncs.Done()
 		defer close(out)
-
-		log := log.With("provider", peerID, "peerAddr", peerAddr, "depth", depth, "resync", resync)
+		defer cancelSync()
+		defer ing.endInFlightSync(syncID)
+		// A resync marks processed ads as unprocessed, so keep the GC from
+		// removing their blocks until the sync is done.
+		defer ing.holdOffGC()()
+
+		log := log.With("provider", peerID, "peerAddr", peerAddr, "depth", depth, "resync", resync, "syncID", syncID)
+		if !since.IsZero() {
+			log = log.With("since", since)
+		}
 		log.Info("Explicitly syncing the latest advertisement from peer")
 
 		var sel ipld.Node
-		// If depth is non-zero or traversal should not stop at the latest
-		// synced, then construct a selector to behave accordingly.
-		if depth != 0 || resync {
+		// If depth is non-zero, traversal should not stop at the latest
+		// synced, or there is a depth limit configured for the peer, then
+		// construct a selector to behave accordingly.
+		if depth != 0 || resync || ing.adDepthOverride(peerID) != 0 {
 			var err error
 			sel, err = ing.makeLimitedDepthSelector(peerID, depth, resync)
 			if err != nil {
@@ -337,8 +649,12 @@ func (ing *Ingester) Sync(ctx context.Context, peerID peer.ID, peerAddr multiadd
 			}
 		}
 
+		// Progress events may be dropped if this goroutine falls behind, but
+		// the sync result is not.
 		syncDone, cancel := ing.onAdProcessed(peerID)
 		defer cancel()
+		result, cancelResult := ing.awaitSyncResult(peerID)
+		defer cancelResult()
 
 		latest, err := ing.GetLatestSync(peerID)
 		if err != nil {
@@ -362,7 +678,20 @@ func (ing *Ingester) Sync(ctx context.Context, peerID peer.ID, peerAddr multiadd
 			// If this is a resync, then it is necessary to mark the ad as
 			// unprocessed so that everything can be reingested from the start
 			// of this sync. Create a scoped block-hook to do this.
+			var cutoff bool
 			opts = append(opts, legs.ScopedBlockHook(func(i peer.ID, c cid.Cid, actions legs.SegmentSyncActions) {
+				if !since.IsZero() && !cutoff {
+					if t, ok := ing.adProcessedTime(c); ok && t.Before(since) {
+						log.Infow("Reached advertisement processed before cutoff time", "adCid", c, "processed", t)
+						cutoff = true
+					}
+				}
+				if cutoff {
+					// This ad and all older ads stay processed, so the rest
+					// of the chain does not need to be synced.
+					actions.SetNextSyncCid(cid.Undef)
+					return
+				}
 				err := ing.markAdUnprocessed(c)
 				if err != nil {
 					log.Errorw("Failed to mark ad as unprocessed", "err", err, "adCid", c)
@@ -372,7 +701,9 @@ func (ing *Ingester) Sync(ctx context.Context, peerID peer.ID, peerAddr multiadd
 				ing.generalLegsBlockHook(i, c, actions)
 			}))
 		}
+		unprotect := ing.protectPeer(peerID)
 		c, err := ing.sub.Sync(ctx, peerID, cid.Undef, sel, peerAddr, opts...)
+		unprotect()
 		if err != nil {
 			log.Errorw("Failed to sync with provider", "err", err)
 			return
@@ -387,21 +718,34 @@ func (ing *Ingester) Sync(ctx context.Context, peerID peer.ID, peerAddr multiadd
 			out <- c
 			return
 		}
+		// Likewise, if the latest advertisement was processed before the
+		// cutoff time, then it was not marked unprocessed.
+		if !since.IsZero() && ing.adAlreadyProcessed(c) {
+			log.Infow("Latest advertisement processed before cutoff time", "adCid", c)
+			out <- c
+			return
+		}
 
 		log.Debugw("Syncing advertisements up to latest", "adCid", c)
+		ing.updateInFlightSync(syncID, func(s *adminmodel.InFlightSync) {
+			s.Stage = syncStageProcessing
+		})
+		result.setHead(c)
 		for {
 			select {
-			case adProcessedEvent := <-syncDone:
-				log.Debugw("Synced advertisement", "adCid", adProcessedEvent.adCid)
-				if adProcessedEvent.adCid == c || adProcessedEvent.err != nil && adProcessedEvent.headAdCid == c {
-					// If an error occurred then the adProcessedEvent.adCid
-					// will be the cid that caused the error, and there will
-					// not be any future adProcessedEvents. Therefore check the
-					// headAdCid to see if this was the sync that was started.
-					out <- c
-					ing.signalMetricsUpdate()
-					return
+			case adProcessedEvent, ok := <-syncDone:
+				if !ok {
+					syncDone = nil
+					continue
 				}
+				log.Debugw("Synced advertisement", "adCid", adProcessedEvent.adCid)
+				ing.updateInFlightSync(syncID, func(s *adminmodel.InFlightSync) {
+					s.AdsProcessed++
+				})
+			case <-result.done:
+				out <- c
+				ing.signalMetricsUpdate()
+				return
 			case <-ctx.Done():
 				log.Warnw("Sync cancelled", "err", ctx.Err())
 				return
@@ -414,9 +758,76 @@ func (ing *Ingester) Sync(ctx context.Context, peerID peer.ID, peerAddr multiadd
 	return out, nil
 }
 
+// allowAnnounce is called by the subscriber for each announcement received,
+// over gossipsub or directly, to check whether the publisher is allowed. An
+// allowed publisher is recorded as seen, even if it announced an advertisement
+// that is already known.
+func (ing *Ingester) allowAnnounce(publisherID peer.ID) bool {
+	if !ing.reg.Allowed(publisherID) {
+		return false
+	}
+	ing.reg.PublisherSeen(publisherID)
+	return true
+}
+
 // Announce send an announce message to directly to go-legs, instead of through
 // pubsub.
 func (ing *Ingester) Announce(ctx context.Context, nextCid cid.Cid, addrInfo peer.AddrInfo) error {
+	provider := addrInfo.ID
+	if httpAddr, ok := ing.httpSyncAddrs[provider]; ok {
+		addrInfo.Addrs = []multiaddr.Multiaddr{httpAddr}
+	}
+
+	if ing.cfg.AnnounceDebounce > 0 {
+		ing.debounceAnnounce(pendingAnnounce{
+			addrInfo: addrInfo,
+			nextCid:  nextCid,
+		})
+		return nil
+	}
+	return ing.announce(ctx, nextCid, addrInfo)
+}
+
+// debounceAnnounce holds the announcement until AnnounceDebounce has elapsed
+// since the first announcement from the provider, and then handles the latest
+// announcement received from the provider.
+func (ing *Ingester) debounceAnnounce(pa pendingAnnounce) {
+	provider := pa.addrInfo.ID
+	ing.debounceMutex.Lock()
+	_, waiting := ing.debouncedAnnounces[provider]
+	ing.debouncedAnnounces[provider] = pa
+	ing.debounceMutex.Unlock()
+	if waiting {
+		log.Debugw("Replaced debounced announce request", "provider", provider, "cid", pa.nextCid)
+		return
+	}
+
+	ing.waitForPendingSyncs.Add(1)
+	go func() {
+		defer ing.waitForPendingSyncs.Done()
+
+		t := time.NewTimer(time.Duration(ing.cfg.AnnounceDebounce))
+		select {
+		case <-t.C:
+		case <-ing.closePendingSyncs:
+			t.Stop()
+			return
+		}
+
+		ing.debounceMutex.Lock()
+		pa := ing.debouncedAnnounces[provider]
+		delete(ing.debouncedAnnounces, provider)
+		ing.debounceMutex.Unlock()
+
+		if err := ing.announce(ing.closingCtx, pa.nextCid, pa.addrInfo); err != nil {
+			logRepeats.Errorw("Failed to handle debounced announce", "err", err, "provider", provider, "cid", pa.nextCid)
+		}
+	}()
+}
+
+// announce handles an announce message, or defers handling it until the
+// provider's advertisements being ingested are finished.
+func (ing *Ingester) announce(ctx context.Context, nextCid cid.Cid, addrInfo peer.AddrInfo) error {
 	provider := addrInfo.ID
 	log := log.With("provider", provider, "cid", nextCid, "addrs", addrInfo.Addrs)
 
@@ -447,6 +858,10 @@ func (ing *Ingester) Announce(ctx context.Context, nextCid cid.Cid, addrInfo pee
 }
 
 func (ing *Ingester) makeLimitedDepthSelector(peerID peer.ID, depth int, resync bool) (ipld.Node, error) {
+	// Use the configured depth limit for the peer if no depth is given.
+	if depth == 0 {
+		depth = ing.adDepthLimit(peerID)
+	}
 	// Consider the value of < 1 as no-limit.
 	rLimit := recursionLimit(depth)
 	log := log.With("depth", depth)
@@ -479,7 +894,9 @@ func (ing *Ingester) makeLimitedDepthSelector(peerID peer.ID, depth int, resync
 // constraint is maintained that if an ad is processed, all older ads are also
 // processed.
 func (ing *Ingester) markAdUnprocessed(adCid cid.Cid) error {
-	return ing.ds.Put(context.Background(), datastore.NewKey(adProcessedPrefix+adCid.String()), []byte{0})
+	// Keep the time that the ad was first processed.
+	t, _ := ing.adProcessedTime(adCid)
+	return ing.ds.Put(context.Background(), datastore.NewKey(adProcessedPrefix+adCid.String()), adProcessedValue(false, t))
 }
 
 func (ing *Ingester) adAlreadyProcessed(adCid cid.Cid) bool {
@@ -493,143 +910,620 @@ func (ing *Ingester) adAlreadyProcessed(adCid cid.Cid) bool {
 	return v[0] == byte(1)
 }
 
-func (ing *Ingester) markAdProcessed(publisher peer.ID, adCid cid.Cid) error {
+// markAdProcessed marks the advertisement as processed and removes it from
+// the datastore. If updateLatest is true, then the advertisement is also
+// persisted as the latest sync for the publisher. This is false for retried
+// advertisements, which are older than the latest sync.
+func (ing *Ingester) markAdProcessed(publisher peer.ID, adCid cid.Cid, updateLatest bool) error {
 	log.Debugw("Persisted latest sync", "peer", publisher, "cid", adCid)
-	err := ing.ds.Put(context.Background(), datastore.NewKey(adProcessedPrefix+adCid.String()), []byte{1})
+	// Record when the ad was first processed, keeping the existing time if
+	// the ad is being processed again.
+	firstProcessed, ok := ing.adProcessedTime(adCid)
+	if !ok {
+		firstProcessed = time.Now()
+	}
+	err := ing.ds.Put(context.Background(), datastore.NewKey(adProcessedPrefix+adCid.String()), adProcessedValue(true, firstProcessed))
 	if err != nil {
 		return err
 	}
 	// This ad is processed, so remove it from the datastore.
+	ing.adCache.remove(adCid)
 	err = ing.ds.Delete(context.Background(), datastore.NewKey(adCid.String()))
 	if err != nil {
 		// Log the error, but do not return. Continue on to save the procesed ad.
 		log.Errorw("Cound not remove advertisement from datastore", "err", err)
 	}
+	// The ad no longer needs a checkpoint to resume indexing its entries.
+	err = ing.ds.Delete(context.Background(), datastore.NewKey(adCheckpointPrefix+adCid.String()))
+	if err != nil {
+		log.Errorw("Cound not remove advertisement checkpoint from datastore", "err", err)
+	}
+	if ing.cfg.WriteAheadLog {
+		// If this ad is the head of a logged chain, then the chain is done.
+		err = ing.ds.Delete(context.Background(), walKey(publisher, adCid))
+		if err != nil {
+			log.Errorw("Cound not remove write-ahead log entry from datastore", "err", err)
+		}
+	}
+	if !updateLatest {
+		return nil
+	}
 	return ing.ds.Put(context.Background(), datastore.NewKey(syncPrefix+publisher.String()), adCid.Bytes())
 }
 
-// distributeEvents reads a adProcessedEvent, sent by a peer handler, and
-// copies the event to all channels in outEventsChans. This delivers the event
-// to all onAdProcessed channel readers.
-func (ing *Ingester) distributeEvents() {
-	for event := range ing.inEvents {
-		// Send update to all change notification channels.
-		ing.outEventsMutex.Lock()
-		outEventsChans, ok := ing.outEventsChans[event.publisher]
-		if ok {
-			for _, ch := range outEventsChans {
-				ch <- event
-			}
+// chunkMarker returns the value that identifies the indexing of an entry
+// chunk's multihashes with the given value. An entry chunk that is
+// re-encountered with the same marker has already been indexed with identical
+// provider, context ID, and metadata, and no content has been removed from
+// that context since.
+//
+// The marker includes the tombstone counts of the provider and of the
+// context, so that any removal makes previously indexed chunks no longer
+// considered processed. This lets a later advertisement re-add content that
+// was removed after an earlier advertisement added it.
+func (ing *Ingester) chunkMarker(ctx context.Context, value indexer.Value) ([]byte, error) {
+	provTombs, err := ing.tombstoneCount(ctx, providerTombstoneKey(value.ProviderID))
+	if err != nil {
+		return nil, err
+	}
+	ctxTombs, err := ing.tombstoneCount(ctx, contextTombstoneKey(value.ProviderID, value.ContextID))
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.New()
+	h.Write([]byte(value.ProviderID))
+	h.Write(varint.ToUvarint(uint64(len(value.ContextID))))
+	h.Write(value.ContextID)
+	h.Write(varint.ToUvarint(uint64(len(value.MetadataBytes))))
+	h.Write(value.MetadataBytes)
+	h.Write(varint.ToUvarint(provTombs))
+	h.Write(varint.ToUvarint(ctxTombs))
+	return h.Sum(nil), nil
+}
+
+// chunkMarkerPrefix returns the prefix of the keys of the markers of entry
+// chunks indexed for the provider, or for the provider's context if contextID
+// is not nil. The prefix ends with "/" so that it does not match the keys of
+// another provider or context whose ID starts with the same characters.
+func chunkMarkerPrefix(providerID peer.ID, contextID []byte) string {
+	prefix := chunkProcessedPrefix + providerID.String() + "/"
+	if contextID != nil {
+		prefix += base64.RawURLEncoding.EncodeToString(contextID) + "/"
+	}
+	return prefix
+}
+
+func chunkMarkerKey(value indexer.Value, chunkCid cid.Cid) datastore.Key {
+	return datastore.NewKey(chunkMarkerPrefix(value.ProviderID, value.ContextID) + chunkCid.String())
+}
+
+// chunkAlreadyProcessed returns true if the entry chunk was indexed with the
+// value, with the same marker, and the marker has not expired.
+func (ing *Ingester) chunkAlreadyProcessed(ctx context.Context, value indexer.Value, chunkCid cid.Cid, marker []byte) bool {
+	v, err := ing.ds.Get(ctx, chunkMarkerKey(value, chunkCid))
+	if err != nil {
+		if err != datastore.ErrNotFound {
+			log.Errorw("Failed to read entry chunk processed state from datastore", "err", err)
 		}
-		ing.outEventsMutex.Unlock()
+		return false
 	}
+	marked, v, ok := decodeChunkMarker(v)
+	if !ok || ing.chunkMarkerExpired(marked) {
+		return false
+	}
+	return bytes.Equal(v, marker)
 }
 
-// onAdProcessed creates a channel that receives notification when an
-// advertisement and all of its content entries have finished syncing.
-//
-// Doing a manual sync will not always cause a notification if the requested
-// advertisement has previously been processed.
-//
-// Calling the returned cancel function removes the notification channel from
-// the list of channels to be notified on changes, and closes the channel to
-// allow any reading goroutines to stop waiting on the channel.
-func (ing *Ingester) onAdProcessed(peerID peer.ID) (<-chan adProcessedEvent, context.CancelFunc) {
-	// Channel is buffered to prevent distribute() from blocking if a reader is
-	// not reading the channel immediately.
-	ch := make(chan adProcessedEvent, 1)
-	ing.outEventsMutex.Lock()
-	defer ing.outEventsMutex.Unlock()
+// markChunkProcessed records that the entry chunk was indexed with the value.
+// The time that it was marked is stored before the marker, to expire it.
+func (ing *Ingester) markChunkProcessed(ctx context.Context, value indexer.Value, chunkCid cid.Cid, marker []byte) error {
+	v := append(varint.ToUvarint(uint64(time.Now().Unix())), marker...)
+	return ing.ds.Put(ctx, chunkMarkerKey(value, chunkCid), v)
+}
 
-	var outEventsChans []chan adProcessedEvent
-	if ing.outEventsChans == nil {
-		ing.outEventsChans = make(map[peer.ID][]chan adProcessedEvent)
-	} else {
-		outEventsChans = ing.outEventsChans[peerID]
+// decodeChunkMarker returns the time that an entry chunk marker was stored,
+// and the marker.
+func decodeChunkMarker(v []byte) (time.Time, []byte, bool) {
+	secs, n, err := varint.FromUvarint(v)
+	if err != nil {
+		return time.Time{}, nil, false
 	}
-	ing.outEventsChans[peerID] = append(outEventsChans, ch)
+	return time.Unix(int64(secs), 0), v[n:], true
+}
 
-	cncl := func() {
-		ing.outEventsMutex.Lock()
-		defer ing.outEventsMutex.Unlock()
-		outEventsChans, ok := ing.outEventsChans[peerID]
-		if !ok {
-			return
-		}
+func (ing *Ingester) chunkMarkerExpired(marked time.Time) bool {
+	ttl := time.Duration(ing.cfg.EntryChunkMarkerTTL)
+	return ttl != 0 && time.Since(marked) >= ttl
+}
 
-		for i, ca := range outEventsChans {
-			if ca == ch {
-				if len(outEventsChans) == 1 {
-					if len(ing.outEventsChans) == 1 {
-						ing.outEventsChans = nil
-					} else {
-						delete(ing.outEventsChans, peerID)
-					}
-				} else {
-					outEventsChans[i] = outEventsChans[len(outEventsChans)-1]
-					outEventsChans[len(outEventsChans)-1] = nil
-					outEventsChans = outEventsChans[:len(outEventsChans)-1]
-					close(ch)
-					ing.outEventsChans[peerID] = outEventsChans
-				}
-				break
+// removeChunkMarkers removes the entry chunk markers whose keys start with
+// prefix. If expiredOnly is true, then only expired markers are removed.
+// Returns the number of markers removed.
+func (ing *Ingester) removeChunkMarkers(ctx context.Context, prefix string, expiredOnly bool) (int, error) {
+	results, err := ing.ds.Query(ctx, query.Query{
+		Prefix:   prefix,
+		KeysOnly: !expiredOnly,
+	})
+	if err != nil {
+		return 0, err
+	}
+	var keys []datastore.Key
+	for result := range results.Next() {
+		if result.Error != nil {
+			results.Close()
+			return 0, fmt.Errorf("cannot read entry chunk marker: %w", result.Error)
+		}
+		if expiredOnly {
+			marked, _, ok := decodeChunkMarker(result.Value)
+			if ok && !ing.chunkMarkerExpired(marked) {
+				continue
 			}
 		}
+		keys = append(keys, datastore.NewKey(result.Key))
 	}
-	return ch, cncl
-}
+	results.Close()
 
-// signalMetricsUpdate signals that metrics should be updated.
-func (ing *Ingester) signalMetricsUpdate() {
-	select {
-	case ing.sigUpdate <- struct{}{}:
-	default:
-		// Already signaled
+	for i, key := range keys {
+		if err = ing.ds.Delete(ctx, key); err != nil {
+			return i, err
+		}
 	}
+	return len(keys), nil
 }
 
-// metricsUpdate periodically updates metrics. This goroutine exits when the
-// sigUpdate channel is closed, when Close is called.
-func (ing *Ingester) metricsUpdater() {
-	hasUpdate := true
-	t := time.NewTimer(time.Minute)
+// runChunkMarkerExpiry periodically removes expired entry chunk markers, so
+// that the markers of chunks that are not seen again do not accumulate. This
+// goroutine exits when the ingester is closed.
+func (ing *Ingester) runChunkMarkerExpiry(ttl time.Duration) {
+	defer ing.waitForPendingSyncs.Done()
+
+	interval := ttl
+	if interval > time.Hour {
+		interval = time.Hour
+	}
+	t := time.NewTimer(jitter.Add(interval, ing.cfg.TimerJitterPercent))
+	defer t.Stop()
 
 	for {
 		select {
-		case _, ok := <-ing.sigUpdate:
-			if !ok {
-				return
-			}
-			hasUpdate = true
 		case <-t.C:
-			if hasUpdate {
-				// Update value store size metric after sync.
-				size, err := ing.indexer.Size()
-				if err != nil {
-					log.Errorw("Error getting indexer value store size", "err", err)
-					return
-				}
-				stats.Record(context.Background(), coremetrics.StoreSize.M(size))
-				hasUpdate = false
+			t.Reset(jitter.Add(interval, ing.cfg.TimerJitterPercent))
+			count, err := ing.removeChunkMarkers(ing.closingCtx, chunkProcessedPrefix, true)
+			if err != nil {
+				log.Errorw("Failed to remove expired entry chunk markers", "err", err)
+			}
+			if count != 0 {
+				log.Infow("Removed expired entry chunk markers", "count", count)
 			}
-			t.Reset(time.Minute)
+		case <-ing.closingCtx.Done():
+			return
 		}
 	}
 }
 
-// removePublisher removes data for the identified publisher. This is done as
-// part of removing a provider.
-func (ing *Ingester) removePublisher(ctx context.Context, publisherID peer.ID) error {
-	if publisherID.Validate() != nil {
-		// Invalid publisher ID, registered provider never got a published ad.
-		return nil
+// saveAdCheckpoint records that indexing of the advertisement's entry chunks
+// has reached nextChunkCid, after indexing entryCount multihashes. If
+// indexing is interrupted, it resumes from this chunk.
+func (ing *Ingester) saveAdCheckpoint(ctx context.Context, adCid, nextChunkCid cid.Cid, entryCount uint64) error {
+	value := append(varint.ToUvarint(entryCount), nextChunkCid.Bytes()...)
+	return ing.ds.Put(ctx, datastore.NewKey(adCheckpointPrefix+adCid.String()), value)
+}
+
+// adCheckpoint returns the next entry chunk to index for the advertisement,
+// and the number of multihashes already indexed, if indexing of the
+// advertisement was interrupted.
+func (ing *Ingester) adCheckpoint(ctx context.Context, adCid cid.Cid) (cid.Cid, uint64, bool) {
+	value, err := ing.ds.Get(ctx, datastore.NewKey(adCheckpointPrefix+adCid.String()))
+	if err != nil {
+		if err != datastore.ErrNotFound {
+			log.Errorw("Failed to read advertisement checkpoint from datastore", "err", err)
+		}
+		return cid.Undef, 0, false
 	}
-	ing.sub.RemoveHandler(publisherID)
-	err := ing.ds.Delete(ctx, datastore.NewKey(syncPrefix+publisherID.String()))
+	entryCount, n, err := varint.FromUvarint(value)
 	if err != nil {
-		return fmt.Errorf("could not remove latest sync for publisher %s: %w", publisherID, err)
+		log.Errorw("Cannot decode advertisement checkpoint entry count", "err", err)
+		return cid.Undef, 0, false
 	}
-	return nil
+	_, nextChunkCid, err := cid.CidFromBytes(value[n:])
+	if err != nil {
+		log.Errorw("Cannot decode advertisement checkpoint chunk", "err", err)
+		return cid.Undef, 0, false
+	}
+	return nextChunkCid, entryCount, true
+}
+
+func contextTombstoneKey(providerID peer.ID, contextID []byte) datastore.Key {
+	return datastore.NewKey(contextTombstonePrefix + providerID.String() + "/" + base64.RawURLEncoding.EncodeToString(contextID))
+}
+
+func providerTombstoneKey(providerID peer.ID) datastore.Key {
+	return datastore.NewKey(providerTombstonePrefix + providerID.String())
+}
+
+// tombstoneCount returns the number of tombstones recorded at the key.
+func (ing *Ingester) tombstoneCount(ctx context.Context, key datastore.Key) (uint64, error) {
+	v, err := ing.ds.Get(ctx, key)
+	if err != nil {
+		if err == datastore.ErrNotFound {
+			return 0, nil
+		}
+		return 0, err
+	}
+	count, _, err := varint.FromUvarint(v)
+	return count, err
+}
+
+// addTombstone records a removal by incrementing the tombstone count at the
+// key.
+func (ing *Ingester) addTombstone(ctx context.Context, key datastore.Key) error {
+	count, err := ing.tombstoneCount(ctx, key)
+	if err != nil {
+		return err
+	}
+	return ing.ds.Put(ctx, key, varint.ToUvarint(count+1))
+}
+
+// removeProviderContext removes the provider context from the indexer and
+// the registry, and records a tombstone for the context so that any entry
+// chunks previously indexed for the context are indexed again if they are
+// re-advertised.
+func (ing *Ingester) removeProviderContext(ctx context.Context, providerID peer.ID, contextID []byte) error {
+	err := ing.indexer.RemoveProviderContext(providerID, contextID)
+	if err != nil {
+		return err
+	}
+	if err = ing.reg.RemoveProviderContext(ctx, providerID, contextID); err != nil {
+		log.Errorw("Failed to remove provider context from registry", "err", err)
+	}
+	if err = ing.addTombstone(ctx, contextTombstoneKey(providerID, contextID)); err != nil {
+		return err
+	}
+	_, err = ing.removeChunkMarkers(ctx, chunkMarkerPrefix(providerID, contextID), false)
+	return err
+}
+
+// RemoveContent removes the multihash from the value's provider context, and
+// records a tombstone for the context so that an advertisement that re-adds
+// the multihash is not skipped as already processed.
+func (ing *Ingester) RemoveContent(ctx context.Context, value indexer.Value, m multihash.Multihash) error {
+	err := ing.indexer.Remove(value, m)
+	if err != nil {
+		return err
+	}
+	if err = ing.reg.RemoveContextEntries(ctx, value.ProviderID, value.ContextID, 1); err != nil {
+		return err
+	}
+	if err = ing.reg.RemoveIndexTimes(ctx, value.ProviderID, value.ContextID, []multihash.Multihash{m}); err != nil {
+		return err
+	}
+	if err = ing.addTombstone(ctx, contextTombstoneKey(value.ProviderID, value.ContextID)); err != nil {
+		return err
+	}
+	_, err = ing.removeChunkMarkers(ctx, chunkMarkerPrefix(value.ProviderID, value.ContextID), false)
+	return err
+}
+
+// SetEventSink sets the sink that an event is published to each time an
+// advertisement finishes processing, replacing and closing any previous sink.
+// Events are buffered, up to bufferSize, while waiting to be published, so that
+// publishing does not hold up ingestion. Setting nil stops publishing events.
+func (ing *Ingester) SetEventSink(sink eventsink.Sink, bufferSize int) {
+	var async *eventsink.Async
+	if sink != nil {
+		async = eventsink.NewAsync(sink, bufferSize)
+	}
+	ing.outEventsMutex.Lock()
+	prev := ing.eventSink
+	ing.eventSink = async
+	ing.outEventsMutex.Unlock()
+	if prev != nil {
+		if err := prev.Close(); err != nil {
+			log.Errorw("Error closing event sink", "err", err)
+		}
+	}
+}
+
+// distributeEvents reads a adProcessedEvent, sent by a peer handler, and
+// copies the event to all channels in outEventsChans. This delivers the event
+// to all onAdProcessed channel readers, and to the event sink if there is one.
+func (ing *Ingester) distributeEvents() {
+	for event := range ing.inEvents {
+		// Send update to all change notification channels.
+		ing.outEventsMutex.Lock()
+		outEventsChans, ok := ing.outEventsChans[event.publisher]
+		if ok {
+			for _, ch := range outEventsChans {
+				sendEvent(ch, event)
+			}
+		}
+		for _, result := range ing.syncResults[event.publisher] {
+			result.update(event)
+		}
+		if ing.eventSink != nil {
+			sinkEvent := eventsink.Event{
+				Publisher: event.publisher,
+				HeadAdCid: event.headAdCid,
+				AdCid:     event.adCid,
+				Time:      time.Now(),
+			}
+			if event.err != nil {
+				sinkEvent.Error = event.err.Error()
+			}
+			ing.eventSink.Send(sinkEvent)
+		}
+		ing.outEventsMutex.Unlock()
+	}
+}
+
+// sendEvent sends the event to ch without blocking. If the channel buffer is
+// full because its reader is not keeping up, then the oldest event is dropped
+// to make room. This keeps one slow reader from stalling notifications to all
+// the others. The newest events are kept because a reader is most likely
+// waiting for the last advertisement in a chain, which is processed last.
+//
+// Dropping events is only acceptable for best-effort readers. A Sync call
+// gets its result from a syncResult, which never loses it.
+func sendEvent(ch chan adProcessedEvent, event adProcessedEvent) {
+	select {
+	case ch <- event:
+		return
+	default:
+	}
+	select {
+	case dropped := <-ch:
+		log.Warnw("Dropped advertisement processed event for slow reader", "publisher", dropped.publisher, "adCid", dropped.adCid)
+	default:
+	}
+	select {
+	case ch <- event:
+	default:
+		// The reader took the remaining space, so this event is dropped.
+		log.Warnw("Dropped advertisement processed event for slow reader", "publisher", event.publisher, "adCid", event.adCid)
+	}
+}
+
+// syncResult records when the sync of a head advertisement is done, for a Sync
+// call that waits for it. The done channel is closed when the head
+// advertisement is processed, or when processing the chain ending at the head
+// fails.
+type syncResult struct {
+	mutex sync.Mutex
+	head  cid.Cid
+	// finished holds the advertisements that finished, and the heads of the
+	// chains that failed, until the head is known.
+	finished map[cid.Cid]struct{}
+	isDone   bool
+	done     chan struct{}
+}
+
+// update records the event, and closes the done channel if the event ends the
+// sync.
+func (r *syncResult) update(event adProcessedEvent) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.isDone {
+		return
+	}
+	if r.head == cid.Undef {
+		r.finished[event.adCid] = struct{}{}
+		if event.err != nil {
+			r.finished[event.headAdCid] = struct{}{}
+		}
+		return
+	}
+	// If an error occurred then the event's adCid is the ad that caused the
+	// error, and there are no more events for the chain. Therefore check the
+	// headAdCid to see if this is the chain that ends at the head.
+	if event.adCid == r.head || event.err != nil && event.headAdCid == r.head {
+		r.isDone = true
+		close(r.done)
+	}
+}
+
+// setHead sets the head advertisement of the sync, once it is known, and
+// closes the done channel if it already finished.
+func (r *syncResult) setHead(c cid.Cid) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.isDone {
+		return
+	}
+	r.head = c
+	if _, ok := r.finished[c]; ok {
+		r.isDone = true
+		close(r.done)
+	}
+	r.finished = nil
+}
+
+// awaitSyncResult creates a syncResult that records the processing of the
+// publisher's advertisements until the returned cancel function is called.
+func (ing *Ingester) awaitSyncResult(peerID peer.ID) (*syncResult, context.CancelFunc) {
+	result := &syncResult{
+		finished: map[cid.Cid]struct{}{},
+		done:     make(chan struct{}),
+	}
+	ing.outEventsMutex.Lock()
+	defer ing.outEventsMutex.Unlock()
+	if ing.syncResults == nil {
+		ing.syncResults = make(map[peer.ID][]*syncResult)
+	}
+	ing.syncResults[peerID] = append(ing.syncResults[peerID], result)
+
+	cancel := func() {
+		ing.outEventsMutex.Lock()
+		defer ing.outEventsMutex.Unlock()
+		results := ing.syncResults[peerID]
+		for i, r := range results {
+			if r == result {
+				results[i] = results[len(results)-1]
+				results[len(results)-1] = nil
+				results = results[:len(results)-1]
+				break
+			}
+		}
+		if len(results) == 0 {
+			delete(ing.syncResults, peerID)
+		} else {
+			ing.syncResults[peerID] = results
+		}
+	}
+	return result, cancel
+}
+
+// onAdProcessed creates a channel that receives notification when an
+// advertisement and all of its content entries have finished syncing.
+//
+// Doing a manual sync will not always cause a notification if the requested
+// advertisement has previously been processed.
+//
+// Calling the returned cancel function removes the notification channel from
+// the list of channels to be notified on changes, and closes the channel to
+// allow any reading goroutines to stop waiting on the channel.
+func (ing *Ingester) onAdProcessed(peerID peer.ID) (<-chan adProcessedEvent, context.CancelFunc) {
+	// Channel is buffered so that events are not dropped if a reader is not
+	// reading the channel immediately. The distributeEvents goroutine never
+	// blocks on this channel.
+	ch := make(chan adProcessedEvent, adProcessedEventBuffer)
+	ing.outEventsMutex.Lock()
+	defer ing.outEventsMutex.Unlock()
+
+	var outEventsChans []chan adProcessedEvent
+	if ing.outEventsChans == nil {
+		ing.outEventsChans = make(map[peer.ID][]chan adProcessedEvent)
+	} else {
+		outEventsChans = ing.outEventsChans[peerID]
+	}
+	ing.outEventsChans[peerID] = append(outEventsChans, ch)
+
+	cncl := func() {
+		ing.outEventsMutex.Lock()
+		defer ing.outEventsMutex.Unlock()
+		outEventsChans, ok := ing.outEventsChans[peerID]
+		if !ok {
+			return
+		}
+
+		for i, ca := range outEventsChans {
+			if ca == ch {
+				if len(outEventsChans) == 1 {
+					if len(ing.outEventsChans) == 1 {
+						ing.outEventsChans = nil
+					} else {
+						delete(ing.outEventsChans, peerID)
+					}
+				} else {
+					outEventsChans[i] = outEventsChans[len(outEventsChans)-1]
+					outEventsChans[len(outEventsChans)-1] = nil
+					outEventsChans = outEventsChans[:len(outEventsChans)-1]
+					close(ch)
+					ing.outEventsChans[peerID] = outEventsChans
+				}
+				break
+			}
+		}
+	}
+	return ch, cncl
+}
+
+// signalMetricsUpdate signals that metrics should be updated.
+func (ing *Ingester) signalMetricsUpdate() {
+	select {
+	case ing.sigUpdate <- struct{}{}:
+	default:
+		// Already signaled
+	}
+}
+
+// metricsUpdate periodically updates metrics. This goroutine exits when the
+// sigUpdate channel is closed, when Close is called.
+func (ing *Ingester) metricsUpdater() {
+	hasUpdate := true
+	t := time.NewTimer(jitter.Add(time.Minute, ing.cfg.TimerJitterPercent))
+
+	for {
+		select {
+		case _, ok := <-ing.sigUpdate:
+			if !ok {
+				return
+			}
+			hasUpdate = true
+		case <-t.C:
+			if hasUpdate {
+				// Update value store size metric after sync.
+				size, err := ing.indexer.Size()
+				if err != nil {
+					log.Errorw("Error getting indexer value store size", "err", err)
+					return
+				}
+				stats.Record(context.Background(), coremetrics.StoreSize.M(size))
+				hasUpdate = false
+			}
+			ing.recordWorkerMetrics()
+			if ing.cfg.SyncDataLimit != 0 {
+				stats.Record(context.Background(), metrics.IngestSyncDataSize.M(ing.syncData.usage()))
+			}
+			t.Reset(jitter.Add(time.Minute, ing.cfg.TimerJitterPercent))
+		}
+	}
+}
+
+// recordWorkerMetrics records the number of providers waiting for an ingest
+// worker and the fraction of workers that are busy.
+func (ing *Ingester) recordWorkerMetrics() {
+	var busy float64
+	if live := atomic.LoadInt32(&ing.liveWorkers); live != 0 {
+		busy = float64(atomic.LoadInt32(&ing.busyWorkers)) / float64(live)
+	}
+	stats.Record(context.Background(),
+		metrics.IngestQueueDepth.M(int64(atomic.LoadInt32(&ing.pendingWork))),
+		metrics.IngestWorkersBusy.M(busy))
+}
+
+// protectPeer protects connections to the peer from being closed by the
+// host's connection manager, until the returned function is called. This
+// keeps connections to peers being synced from being trimmed mid-sync.
+func (ing *Ingester) protectPeer(peerID peer.ID) func() {
+	tag := fmt.Sprintf("%s-%d", connProtectTag, atomic.AddUint64(&ing.protectCount, 1))
+	cm := ing.host.ConnManager()
+	cm.Protect(peerID, tag)
+	return func() {
+		cm.Unprotect(peerID, tag)
+	}
+}
+
+// removePublisher removes data for the identified publisher. This is done as
+// part of removing a provider.
+func (ing *Ingester) removePublisher(ctx context.Context, publisherID peer.ID) error {
+	if publisherID.Validate() != nil {
+		// Invalid publisher ID, registered provider never got a published ad.
+		return nil
+	}
+	ing.sub.RemoveHandler(publisherID)
+	err := ing.ds.Delete(ctx, datastore.NewKey(syncPrefix+publisherID.String()))
+	if err != nil {
+		return fmt.Errorf("could not remove latest sync for publisher %s: %w", publisherID, err)
+	}
+	return nil
+}
+
+// ClearLatestSync removes the latest sync recorded for the publisher, both
+// in the datastore and as reported by the subscriber. The next sync with the
+// publisher then traverses the publisher's entire advertisement chain, up to
+// the advertisement depth limit, instead of stopping at the last processed
+// advertisement. This may be expensive for a long chain.
+//
+// Advertisements that are already marked as processed are still skipped when
+// the chain is processed. Use Sync with resync to index these again.
+func (ing *Ingester) ClearLatestSync(ctx context.Context, publisherID peer.ID) error {
+	ing.sub.RemoveHandler(publisherID)
+	ing.syncHandler.reported.Delete(publisherID)
+	err := ing.ds.Delete(ctx, datastore.NewKey(syncPrefix+publisherID.String()))
+	if err != nil {
+		return fmt.Errorf("could not remove latest sync for publisher %s: %w", publisherID, err)
+	}
+	return nil
 }
 
 func (ing *Ingester) autoSync() {
@@ -641,6 +1535,15 @@ func (ing *Ingester) autoSync() {
 			if err := ing.removePublisher(ctx, provInfo.Publisher); err != nil {
 				log.Errorw("Error removing provider", "err", err, "provider", provInfo.AddrInfo.ID)
 			}
+			// The provider's content is removed from the core lazily, so
+			// record a tombstone to make sure that content is indexed again
+			// if the provider comes back and re-advertises it.
+			if err := ing.addTombstone(ctx, providerTombstoneKey(provInfo.AddrInfo.ID)); err != nil {
+				log.Errorw("Error recording provider tombstone", "err", err, "provider", provInfo.AddrInfo.ID)
+			}
+			if _, err := ing.removeChunkMarkers(ctx, chunkMarkerPrefix(provInfo.AddrInfo.ID, nil), false); err != nil {
+				log.Errorw("Error removing provider entry chunk markers", "err", err, "provider", provInfo.AddrInfo.ID)
+			}
 			// Do not remove provider info from core, because that requires
 			// scanning the entire core valuestore. Instead, let the finder
 			// delete provider contexts as deleted providers appear in find
@@ -654,18 +1557,98 @@ func (ing *Ingester) autoSync() {
 		go func(pubID peer.ID, pubAddr multiaddr.Multiaddr, provID peer.ID) {
 			defer ing.waitForPendingSyncs.Done()
 
+			if httpAddr, ok := ing.httpSyncAddrs[provID]; ok {
+				pubAddr = httpAddr
+			} else if httpAddr, ok = ing.httpSyncAddrs[pubID]; ok {
+				pubAddr = httpAddr
+			}
+
 			log := log.With("provider", provID, "publisher", pubID, "addr", pubAddr)
 			log.Info("Auto-syncing the latest advertisement with publisher")
 
-			_, err := ing.sub.Sync(ctx, pubID, cid.Undef, nil, pubAddr)
+			// Use a selector with the provider's advertisement depth limit
+			// if one is configured. Otherwise use the default selector.
+			var sel ipld.Node
+			var opts []legs.SyncOption
+			if limit := ing.depthOverrides[provID].adDepth; limit != 0 {
+				var err error
+				sel, err = ing.makeLimitedDepthSelector(pubID, limit, false)
+				if err != nil {
+					log.Errorw("Failed to construct selector for auto-sync", "err", err)
+					return
+				}
+				opts = append(opts, legs.AlwaysUpdateLatest())
+			}
+
+			defer ing.protectPeer(pubID)()
+			_, err := ing.sub.Sync(ctx, pubID, cid.Undef, sel, pubAddr, opts...)
 			if err != nil {
-				log.Errorw("Failed to auto-sync with publisher", "err", err)
+				logRepeats.Errorw("Failed to auto-sync with publisher", "provider", provID, "publisher", pubID, "addr", pubAddr, "err", err)
 				return
 			}
 		}(provInfo.Publisher, provInfo.PublisherAddr, provInfo.AddrInfo.ID)
 	}
 }
 
+// syncAllProviders syncs with the publisher of every known provider that is
+// allowed by policy, to catch up with advertisements published while the
+// indexer was not running. Each sync stops at the latest advertisement that was
+// already processed.
+func (ing *Ingester) syncAllProviders() {
+	defer ing.waitForPendingSyncs.Done()
+
+	type pubInfo struct {
+		id   peer.ID
+		addr multiaddr.Multiaddr
+	}
+	pubs := make(map[peer.ID]pubInfo)
+	for _, provInfo := range ing.reg.AllProviderInfo() {
+		provID := provInfo.AddrInfo.ID
+		if !ing.reg.Allowed(provID) {
+			continue
+		}
+		// The publisher is the provider if there is no separate publisher.
+		pub := pubInfo{id: provInfo.Publisher, addr: provInfo.PublisherAddr}
+		if pub.id.Validate() != nil {
+			pub = pubInfo{id: provID}
+		} else if pub.id != provID && !ing.reg.Allowed(pub.id) {
+			continue
+		}
+		pubs[pub.id] = pub
+	}
+	log.Infow("Resyncing publishers on startup", "count", len(pubs))
+
+	pubChan := make(chan pubInfo)
+	var wg sync.WaitGroup
+	for i := 0; i < startupSyncConcurrency && i < len(pubs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for pub := range pubChan {
+				syncDone, err := ing.Sync(ing.closingCtx, pub.id, pub.addr, 0, false)
+				if err != nil {
+					log.Errorw("Failed to resync publisher on startup", "err", err, "publisher", pub.id)
+					continue
+				}
+				// Wait for the sync to finish to limit the number of
+				// concurrent syncs.
+				<-syncDone
+			}
+		}()
+	}
+
+send:
+	for _, pub := range pubs {
+		select {
+		case pubChan <- pub:
+		case <-ing.closingCtx.Done():
+			break send
+		}
+	}
+	close(pubChan)
+	wg.Wait()
+}
+
 // Get the latest CID synced for the peer.
 func (ing *Ingester) GetLatestSync(publisherID peer.ID) (cid.Cid, error) {
 	b, err := ing.ds.Get(context.Background(), datastore.NewKey(syncPrefix+publisherID.String()))
@@ -679,6 +1662,126 @@ func (ing *Ingester) GetLatestSync(publisherID peer.ID) (cid.Cid, error) {
 	return c, err
 }
 
+// ReadLatestSyncs reads the latest sync persisted in the datastore for each
+// publisher. This is used to export the state of an indexer that is not
+// running.
+func ReadLatestSyncs(ctx context.Context, ds datastore.Datastore) (map[peer.ID]cid.Cid, error) {
+	results, err := ds.Query(ctx, query.Query{
+		Prefix: syncPrefix,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer results.Close()
+
+	latestSyncs := make(map[peer.ID]cid.Cid)
+	for result := range results.Next() {
+		if result.Error != nil {
+			return nil, fmt.Errorf("cannot read latest sync: %w", result.Error)
+		}
+		peerID, err := peer.Decode(path.Base(result.Key))
+		if err != nil {
+			return nil, fmt.Errorf("cannot decode publisher ID: %w", err)
+		}
+		_, c, err := cid.CidFromBytes(result.Value)
+		if err != nil {
+			return nil, fmt.Errorf("cannot decode latest sync for publisher %s: %w", peerID, err)
+		}
+		latestSyncs[peerID] = c
+	}
+	return latestSyncs, nil
+}
+
+// WriteLatestSyncs persists the latest sync for each publisher in the
+// datastore, so that the next sync with each publisher stops at that
+// advertisement. A publisher that already has a latest sync is not written
+// unless overwrite is true. Returns the number of latest syncs written.
+func WriteLatestSyncs(ctx context.Context, ds datastore.Datastore, latestSyncs map[peer.ID]cid.Cid, overwrite bool) (int, error) {
+	var count int
+	for peerID, c := range latestSyncs {
+		key := datastore.NewKey(syncPrefix + peerID.String())
+		if !overwrite {
+			has, err := ds.Has(ctx, key)
+			if err != nil {
+				return count, err
+			}
+			if has {
+				continue
+			}
+		}
+		// The advertisement is not in the datastore of this indexer, so
+		// mark it as processed so that the advertisement is not processed
+		// again when the publisher is next synced.
+		if err := ds.Put(ctx, datastore.NewKey(adProcessedPrefix+c.String()), []byte{1}); err != nil {
+			return count, err
+		}
+		if err := ds.Put(ctx, key, c.Bytes()); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, ds.Sync(ctx, datastore.NewKey(syncPrefix))
+}
+
+// SyncStates returns, for each publisher, the latest sync reported by the
+// go-legs subscriber and the latest sync persisted in the datastore. A
+// publisher is included if it has either.
+func (ing *Ingester) SyncStates(ctx context.Context) ([]adminmodel.SyncState, error) {
+	states := map[peer.ID]*adminmodel.SyncState{}
+
+	results, err := ing.ds.Query(ctx, query.Query{
+		Prefix: syncPrefix,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer results.Close()
+
+	for r := range results.Next() {
+		if r.Error != nil {
+			return nil, fmt.Errorf("cannot read latest sync: %w", r.Error)
+		}
+		peerID, err := peer.Decode(path.Base(r.Key))
+		if err != nil {
+			log.Errorw("Cannot decode publisher ID of latest sync", "key", r.Key, "err", err)
+			continue
+		}
+		_, c, err := cid.CidFromBytes(r.Value)
+		if err != nil {
+			log.Errorw("Cannot decode latest sync", "publisher", peerID, "err", err)
+			continue
+		}
+		states[peerID] = &adminmodel.SyncState{
+			PeerID:        peerID,
+			PersistedSync: c,
+		}
+	}
+
+	ing.syncHandler.reported.Range(func(key, value interface{}) bool {
+		peerID := key.(peer.ID)
+		state, ok := states[peerID]
+		if !ok {
+			state = &adminmodel.SyncState{
+				PeerID: peerID,
+			}
+			states[peerID] = state
+		}
+		state.SubscriberSync = value.(cid.Cid)
+		return true
+	})
+
+	stateList := make([]adminmodel.SyncState, 0, len(states))
+	for _, state := range states {
+		state.Mismatch = state.SubscriberSync != cid.Undef && state.PersistedSync != cid.Undef &&
+			state.SubscriberSync != state.PersistedSync
+		stateList = append(stateList, *state)
+	}
+	sort.Slice(stateList, func(i, j int) bool {
+		return stateList[i].PeerID < stateList[j].PeerID
+	})
+	return stateList, nil
+}
+
 func (ing *Ingester) BatchSize() int {
 	return int(atomic.LoadUint32(&ing.batchSize))
 }
@@ -717,7 +1820,13 @@ func (ing *Ingester) RunWorkers(n int) {
 	}
 }
 
-func (ing *Ingester) runIngesterLoop() {
+// runIngesterLoop stages synced advertisements for processing. Any replay
+// events, of advertisements that were synced but not processed before the
+// indexer stopped, are staged first.
+func (ing *Ingester) runIngesterLoop(replay []legs.SyncFinished) {
+	for _, syncFinishedEvent := range replay {
+		ing.runIngestStep(syncFinishedEvent)
+	}
 	for syncFinishedEvent := range ing.toStaging {
 		ing.runIngestStep(syncFinishedEvent)
 	}
@@ -731,6 +1840,13 @@ func (ing *Ingester) runIngestStep(syncFinishedEvent legs.SyncFinished) {
 		// Group the CIDs by the provider. Most of the time a publisher will
 		// only publish Ads for one provider, but it's possible that an ad
 		// chain can include multiple providers.
+		//
+		// Each group keeps the chain order of its ads, so a provider's ads
+		// are always ingested in chain order. Groups for different providers
+		// are ingested independently and can be reordered relative to each
+		// other. This is safe because an ad only indexes or removes content
+		// of its own provider, so ads of different providers never depend on
+		// each other.
 
 		if ing.adAlreadyProcessed(c) {
 			// This ad has been processed so all earlier ads already have been
@@ -740,8 +1856,15 @@ func (ing *Ingester) runIngestStep(syncFinishedEvent legs.SyncFinished) {
 
 		ad, err := ing.loadAd(c)
 		if err != nil {
+			if isDecodingErr(err) {
+				// The ad will never decode, so skip it and continue with the
+				// rest of the chain. It is not marked processed, because its
+				// provider is not known.
+				ing.recordMalformedAd(syncFinishedEvent.PeerID, c, err)
+				continue
+			}
 			stats.Record(context.Background(), metrics.AdLoadError.M(1))
-			log.Errorw("Failed to load advertisement CID, skipping", "cid", c, "err", err)
+			logRepeats.Errorw("Failed to load advertisement CID, skipping", "publisher", syncFinishedEvent.PeerID, "cid", c, "err", err)
 			continue
 		}
 		providerID, err := peer.Decode(ad.Provider)
@@ -749,6 +1872,9 @@ func (ing *Ingester) runIngestStep(syncFinishedEvent legs.SyncFinished) {
 			log.Errorf("Failed to get provider from ad CID: %s skipping", err)
 			continue
 		}
+		// Group ads from an alias with the ads of its canonical provider, so
+		// that they are ingested in order by the same worker.
+		providerID = ing.reg.CanonicalProvider(providerID)
 
 		adsGroupedByProvider[providerID] = append(adsGroupedByProvider[providerID], adInfo{
 			cid: c,
@@ -756,8 +1882,26 @@ func (ing *Ingester) runIngestStep(syncFinishedEvent legs.SyncFinished) {
 		})
 	}
 
+	// Add the ads carried over from an earlier sync that reached
+	// MaxAdsPerSync, after the newly synced ads of the same provider.
+	ing.providersBeingProcessedMu.Lock()
+	for p, carried := range ing.carriedOverAds {
+		if _, ok := adsGroupedByProvider[p]; ok || carried.publisher == syncFinishedEvent.PeerID {
+			adsGroupedByProvider[p] = appendNewAdInfos(adsGroupedByProvider[p], carried.adInfos)
+			delete(ing.carriedOverAds, p)
+		}
+	}
+	ing.providersBeingProcessedMu.Unlock()
+
 	// 2. For each provider put the ad stack to the worker msg channel.
 	for p, adInfos := range adsGroupedByProvider {
+		if ing.cfg.WriteAheadLog {
+			// Record the chain before any of it is processed, so that it is
+			// processed after a restart if the indexer stops first.
+			if err := ing.addWAL(context.Background(), syncFinishedEvent.PeerID, adInfos[0].cid); err != nil {
+				log.Errorw("Failed to write ingest write-ahead log", "err", err, "provider", p, "adCid", adInfos[0].cid)
+			}
+		}
 		ing.providersBeingProcessedMu.Lock()
 		if _, ok := ing.providersBeingProcessed[p]; !ok {
 			ing.providersBeingProcessed[p] = make(chan struct{}, 1)
@@ -767,17 +1911,26 @@ func (ing *Ingester) runIngestStep(syncFinishedEvent legs.SyncFinished) {
 			wa = &atomic.Value{}
 			ing.providerAdChainStaging[p] = wa
 		}
-		ing.providersBeingProcessedMu.Unlock()
 
-		oldAssignment := wa.Swap(workerAssignment{
+		// If there is already a staged assignment that no worker has picked
+		// up yet, such as ads held back by the provider's rate limit, then
+		// keep those ads after the newer ones.
+		var scheduled bool
+		if oldAssignment := wa.Load(); oldAssignment != nil && !oldAssignment.(workerAssignment).none {
+			adInfos = appendNewAdInfos(adInfos, oldAssignment.(workerAssignment).adInfos)
+			scheduled = true
+		}
+		wa.Store(workerAssignment{
 			adInfos:   adInfos,
 			publisher: syncFinishedEvent.PeerID,
 			provider:  p,
 		})
+		ing.providersBeingProcessedMu.Unlock()
 
-		if oldAssignment == nil || oldAssignment.(workerAssignment).none {
+		if !scheduled {
 			// No previous run scheduled a worker to handle this provider, so
 			// schedule one.
+			atomic.AddInt32(&ing.pendingWork, 1)
 			ing.toWorkers <- providerID(p)
 		}
 	}
@@ -785,34 +1938,64 @@ func (ing *Ingester) runIngestStep(syncFinishedEvent legs.SyncFinished) {
 
 func (ing *Ingester) ingestWorker() {
 	log.Debug("started ingest worker")
-	defer ing.waitForWorkers.Done()
+	atomic.AddInt32(&ing.liveWorkers, 1)
+	defer func() {
+		atomic.AddInt32(&ing.liveWorkers, -1)
+		ing.waitForWorkers.Done()
+	}()
 
 	for {
+		// Wait for a provider slot before taking a provider, so that a
+		// provider is only taken off the queue when it can be ingested, and
+		// other workers can take it otherwise.
+		if ing.providerSlots != nil {
+			select {
+			case <-ing.closeWorkers:
+				log.Debug("stopped ingest worker")
+				return
+			case ing.providerSlots <- struct{}{}:
+			}
+		}
 		select {
 		case <-ing.closeWorkers:
+			ing.releaseProviderSlot()
 			log.Debug("stopped ingest worker")
 			return
 		case provider := <-ing.toWorkers:
+			atomic.AddInt32(&ing.pendingWork, -1)
+			atomic.AddInt32(&ing.busyWorkers, 1)
 			pid := peer.ID(provider)
 			ing.providersBeingProcessedMu.Lock()
 			pc := ing.providersBeingProcessed[pid]
 			ing.providersBeingProcessedMu.Unlock()
 			pc <- struct{}{}
+			releaseGC := ing.holdOffGC()
 			ing.ingestWorkerLogic(pid)
+			releaseGC()
 			ing.handlePendingAnnounce(pid)
 			<-pc
+			atomic.AddInt32(&ing.busyWorkers, -1)
+			ing.releaseProviderSlot()
 		}
 	}
 }
 
+// releaseProviderSlot frees the provider slot held by a worker, if provider
+// slots are limited.
+func (ing *Ingester) releaseProviderSlot() {
+	if ing.providerSlots != nil {
+		<-ing.providerSlots
+	}
+}
+
 func (ing *Ingester) ingestWorkerLogic(provider peer.ID) {
 	// Pull out the assignment for this provider. Note that runIngestStep
 	// populates this atomic.Value.
 	ing.providersBeingProcessedMu.Lock()
 	wa := ing.providerAdChainStaging[provider]
+	assignmentInterface := wa.Swap(workerAssignment{none: true})
 	ing.providersBeingProcessedMu.Unlock()
 
-	assignmentInterface := wa.Swap(workerAssignment{none: true})
 	if assignmentInterface == nil || assignmentInterface.(workerAssignment).none {
 		// Note this is here for completeness. This would not happen
 		// normally. Execution could get here if someone manually calls this
@@ -820,6 +2003,7 @@ func (ing *Ingester) ingestWorkerLogic(provider peer.ID) {
 		return
 	}
 	assignment := assignmentInterface.(workerAssignment)
+	cancels := ing.workCancelCount(assignment)
 
 	rmCtxID := make(map[string]struct{})
 	var skips []int
@@ -854,7 +2038,22 @@ func (ing *Ingester) ingestWorkerLogic(provider peer.ID) {
 	}
 
 	log.Infow("Running worker on ad stack", "headAdCid", assignment.adInfos[0].cid, "publisher", assignment.publisher, "numAdsToProcess", splitAtIndex)
-	var count int
+	var count, ingested int
+
+	// Record a summary of this run in the provider's sync history, unless
+	// nothing was done because all of the ads were deferred.
+	summary := adminmodel.SyncSummary{
+		HeadAdCid: assignment.adInfos[0].cid,
+		Publisher: assignment.publisher,
+	}
+	defer func() {
+		if summary.AdsProcessed == 0 && summary.Err == "" {
+			return
+		}
+		summary.Time = time.Now()
+		ing.recordSyncSummary(assignment.provider, summary)
+	}()
+
 	for i := splitAtIndex - 1; i >= 0; i-- {
 		// Note that iteration proceeds backwards here. Earliest to newest.
 		ai := assignment.adInfos[i]
@@ -871,9 +2070,10 @@ func (ing *Ingester) ingestWorkerLogic(provider peer.ID) {
 				"publisher", assignment.publisher,
 				"progress", fmt.Sprintf("%d of %d", count, splitAtIndex))
 
-			if markErr := ing.markAdProcessed(assignment.publisher, ai.cid); markErr != nil {
+			if markErr := ing.markAdProcessed(assignment.publisher, ai.cid, !ai.retry); markErr != nil {
 				log.Errorw("Failed to mark ad as processed", "err", markErr)
 			}
+			summary.AdsProcessed++
 			// Distribute the atProcessedEvent notices to waiting Sync calls.
 			ing.inEvents <- adProcessedEvent{
 				publisher: assignment.publisher,
@@ -883,24 +2083,110 @@ func (ing *Ingester) ingestWorkerLogic(provider peer.ID) {
 			continue
 		}
 
+		if ing.workCancelCount(assignment) != cancels {
+			// The work for the provider or publisher was canceled while
+			// this worker was running. Stop before the next ad, so that no
+			// ad is left partly ingested, and leave the remaining ads
+			// unprocessed.
+			log.Infow("Ingestion canceled, dropping remaining ads",
+				"adCid", ai.cid,
+				"provider", assignment.provider,
+				"publisher", assignment.publisher,
+				"adsDropped", i+1)
+			summary.Err = errWorkCanceled.Error()
+			ing.inEvents <- adProcessedEvent{
+				publisher: assignment.publisher,
+				headAdCid: assignment.adInfos[0].cid,
+				adCid:     ai.cid,
+				err:       errWorkCanceled,
+			}
+			return
+		}
+
+		if ing.cfg.MaxAdsPerSync > 0 && ingested >= ing.cfg.MaxAdsPerSync {
+			// Leave this ad, and all later ones, for the next sync, so that
+			// no more than the maximum are ingested for this sync.
+			log.Infow("Maximum ads per sync reached, deferring remaining ads to next sync",
+				"adCid", ai.cid,
+				"provider", assignment.provider,
+				"maxAdsPerSync", ing.cfg.MaxAdsPerSync,
+				"adsDeferred", i+1)
+			ing.carryOverAds(assignment, assignment.adInfos[:i+1])
+			// Tell anyone waiting for this sync that it is done.
+			ing.inEvents <- adProcessedEvent{
+				publisher: assignment.publisher,
+				headAdCid: assignment.adInfos[0].cid,
+				adCid:     ai.cid,
+				err:       errAdsDeferred,
+			}
+			return
+		}
+
+		if delay := ing.outOfSpaceDelay(); delay != 0 {
+			// Ingestion is paused until there may be space in the value
+			// store again. Hold this ad, and all later ones, until then.
+			ing.requeueAds(assignment, assignment.adInfos[:i+1], delay)
+			return
+		}
+
+		if delay := ing.throttleProvider(assignment.provider); delay != 0 {
+			// Hold this ad, and all later ones, until the provider's rate
+			// limit allows more ads to be ingested, and let the worker handle
+			// other providers meanwhile.
+			log.Infow("Provider ad rate limit reached, deferring ingestion",
+				"adCid", ai.cid,
+				"provider", assignment.provider,
+				"adsDeferred", i+1,
+				"delay", delay)
+			ing.requeueAds(assignment, assignment.adInfos[:i+1], delay)
+			return
+		}
+
 		log.Infow("Processing advertisement",
 			"adCid", ai.cid,
 			"publisher", assignment.publisher,
 			"progress", fmt.Sprintf("%d of %d", count, splitAtIndex))
 
-		err := ing.ingestAd(assignment.publisher, ai.cid, ai.ad)
+		mhCount, err := ing.ingestAd(assignment.publisher, ai.cid, ai.ad)
+		ingested++
+		summary.MultihashesAdded += mhCount
 		if err == nil {
 			// No error at all, this ad was processed successfully.
 			stats.Record(context.Background(), metrics.AdIngestSuccessCount.M(1))
+			// Clear any failure from an earlier attempt.
+			ing.clearAdFailed(ai.cid)
+		} else {
+			ing.markAdFailed(assignment.publisher, assignment.provider, ai.cid, err)
+		}
+
+		if isOutOfSpace(err) {
+			// Do not mark the ad as processed. Pause ingestion, and retry
+			// this ad and all later ones once there may be space. Entry
+			// chunks that were already indexed are skipped when retried.
+			ing.pauseOutOfSpace()
+			log.Errorw("Value store out of space, pausing ingestion",
+				"adCid", ai.cid,
+				"provider", assignment.provider,
+				"retryIn", outOfSpaceRetry,
+				"err", err)
+			ing.requeueAds(assignment, assignment.adInfos[:i+1], outOfSpaceRetry)
+			summary.Err = err.Error()
+			ing.inEvents <- adProcessedEvent{
+				publisher: assignment.publisher,
+				headAdCid: assignment.adInfos[0].cid,
+				adCid:     ai.cid,
+				err:       err,
+			}
+			return
 		}
 
 		var adIngestErr adIngestError
 		if errors.As(err, &adIngestErr) {
 			switch adIngestErr.state {
-			case adIngestDecodingErr, adIngestMalformedErr, adIngestEntryChunkErr, adIngestContentNotFound:
+			case adIngestDecodingErr, adIngestMalformedErr, adIngestEntryChunkErr, adIngestOversizedChunkErr, adIngestContentNotFound, adIngestFilteredErr, adIngestNotAllowedErr, adIngestUnknownProviderErr, adIngestRemovedContextErr:
 				// These error cases are permanent. If retried later the same
 				// error will happen. So log and drop this error.
-				log.Errorw("Skipping ad because of a permanent error", "adCid", ai.cid, "err", err, "errKind", adIngestErr.state)
+				logRepeats.Errorw("Skipping ad because of a permanent error", "adCid", ai.cid, "err", err, "errKind", adIngestErr.state)
 				stats.Record(context.Background(), metrics.AdIngestSkippedCount.M(1))
 				err = nil
 			}
@@ -914,7 +2200,8 @@ func (ing *Ingester) ingestWorkerLogic(provider peer.ID) {
 		}
 
 		if err != nil {
-			log.Errorw("Error while ingesting ad. Bailing early, not ingesting later ads.", "adCid", ai.cid, "publisher", assignment.provider, "err", err, "adsLeftToProcess", i+1)
+			logRepeats.Errorw("Error while ingesting ad. Bailing early, not ingesting later ads.", "adCid", ai.cid, "publisher", assignment.provider, "err", err, "adsLeftToProcess", i+1)
+			summary.Err = err.Error()
 
 			// Tell anyone waiting that the sync finished for this head because
 			// of error.  TODO(mm) would be better to propagate the error.
@@ -927,9 +2214,10 @@ func (ing *Ingester) ingestWorkerLogic(provider peer.ID) {
 			return
 		}
 
-		if markErr := ing.markAdProcessed(assignment.publisher, ai.cid); markErr != nil {
+		if markErr := ing.markAdProcessed(assignment.publisher, ai.cid, !ai.retry); markErr != nil {
 			log.Errorw("Failed to mark ad as processed", "err", markErr)
 		}
+		summary.AdsProcessed++
 		// Distribute the atProcessedEvent notices to waiting Sync calls.
 		ing.inEvents <- adProcessedEvent{
 			publisher: assignment.publisher,
@@ -939,6 +2227,184 @@ func (ing *Ingester) ingestWorkerLogic(provider peer.ID) {
 	}
 }
 
+// CancelQueuedWork drops the advertisements that are waiting to be ingested
+// for the peer, as their provider or publisher, and that no worker has started
+// ingesting. A worker that is ingesting the peer's advertisements finishes the
+// advertisement in progress and then stops. Dropped advertisements are not
+// marked as processed, so they are ingested if synced again. This is used when
+// the indexer unsubscribes from the peer. Returns the number of
+// advertisements dropped.
+func (ing *Ingester) CancelQueuedWork(peerID peer.ID) int {
+	var dropped []workerAssignment
+	var count int
+	ing.providersBeingProcessedMu.Lock()
+	ing.workCancels[peerID]++
+	for provider, wa := range ing.providerAdChainStaging {
+		v := wa.Load()
+		if v == nil || v.(workerAssignment).none {
+			continue
+		}
+		assignment := v.(workerAssignment)
+		if provider != peerID && assignment.publisher != peerID {
+			continue
+		}
+		// A worker scheduled to handle this provider finds no assignment.
+		wa.Store(workerAssignment{none: true})
+		dropped = append(dropped, assignment)
+		count += len(assignment.adInfos)
+	}
+	for provider, carried := range ing.carriedOverAds {
+		if provider == peerID || carried.publisher == peerID {
+			delete(ing.carriedOverAds, provider)
+			count += len(carried.adInfos)
+		}
+	}
+	ing.providersBeingProcessedMu.Unlock()
+	ing.providersPendingAnnounce.Delete(peerID)
+
+	// Tell anyone waiting for the dropped ads that they will not be
+	// processed.
+	for _, assignment := range dropped {
+		ing.inEvents <- adProcessedEvent{
+			publisher: assignment.publisher,
+			headAdCid: assignment.adInfos[0].cid,
+			adCid:     assignment.adInfos[0].cid,
+			err:       errWorkCanceled,
+		}
+	}
+	if count != 0 {
+		log.Infow("Canceled queued ingestion", "peer", peerID, "adsDropped", count)
+	}
+	return count
+}
+
+// workCancelCount returns the number of times the work of the assignment's
+// provider and publisher has been canceled.
+func (ing *Ingester) workCancelCount(assignment workerAssignment) uint64 {
+	ing.providersBeingProcessedMu.Lock()
+	defer ing.providersBeingProcessedMu.Unlock()
+	count := ing.workCancels[assignment.provider]
+	if assignment.publisher != assignment.provider {
+		count += ing.workCancels[assignment.publisher]
+	}
+	return count
+}
+
+// throttleProvider returns how long to wait before another ad from the
+// provider may be ingested. Zero is returned if the ad may be ingested now, in
+// which case it is counted against the provider's rate limit.
+func (ing *Ingester) throttleProvider(provider peer.ID) time.Duration {
+	if ing.providerAdLimiters == nil {
+		return 0
+	}
+
+	ing.providersBeingProcessedMu.Lock()
+	limiter, ok := ing.providerAdLimiters[provider]
+	if !ok {
+		limiter = rate.NewLimiter(ing.providerAdRate, 1)
+		ing.providerAdLimiters[provider] = limiter
+	}
+	ing.providersBeingProcessedMu.Unlock()
+
+	r := limiter.Reserve()
+	delay := r.Delay()
+	if delay == 0 {
+		return 0
+	}
+	r.Cancel()
+	stats.Record(context.Background(), metrics.AdIngestThrottled.M(1))
+	return delay
+}
+
+// pauseOutOfSpace pauses ingestion by all workers for outOfSpaceRetry.
+func (ing *Ingester) pauseOutOfSpace() {
+	stats.Record(context.Background(), metrics.AdIngestOutOfSpace.M(1))
+	ing.outOfSpaceUntil.Store(time.Now().Add(outOfSpaceRetry))
+}
+
+// outOfSpaceDelay returns how long ingestion remains paused because the value
+// store ran out of space. Zero is returned if ingestion is not paused.
+func (ing *Ingester) outOfSpaceDelay() time.Duration {
+	until, ok := ing.outOfSpaceUntil.Load().(time.Time)
+	if !ok {
+		return 0
+	}
+	delay := time.Until(until)
+	if delay < 0 {
+		return 0
+	}
+	return delay
+}
+
+// requeueAds stages adInfos, which are ads not yet ingested from the
+// assignment, and schedules a worker to handle them after the delay.
+func (ing *Ingester) requeueAds(assignment workerAssignment, adInfos []adInfo, delay time.Duration) {
+	ing.providersBeingProcessedMu.Lock()
+	wa := ing.providerAdChainStaging[assignment.provider]
+	if newer := wa.Load(); newer != nil && !newer.(workerAssignment).none {
+		// Newer ads were staged while the worker was running, and a worker
+		// is already scheduled to handle them. Keep these ads after them.
+		newerAssignment := newer.(workerAssignment)
+		newerAssignment.adInfos = appendNewAdInfos(newerAssignment.adInfos, adInfos)
+		wa.Store(newerAssignment)
+		ing.providersBeingProcessedMu.Unlock()
+		return
+	}
+	wa.Store(workerAssignment{
+		adInfos:   adInfos,
+		publisher: assignment.publisher,
+		provider:  assignment.provider,
+	})
+	ing.providersBeingProcessedMu.Unlock()
+
+	time.AfterFunc(delay, func() {
+		atomic.AddInt32(&ing.pendingWork, 1)
+		select {
+		case ing.toWorkers <- providerID(assignment.provider):
+		case <-ing.closePendingSyncs:
+			atomic.AddInt32(&ing.pendingWork, -1)
+		}
+	})
+}
+
+// carryOverAds holds the ads until the next sync of the assignment's
+// publisher, or of any publisher that has ads for the assignment's provider.
+// If newer ads for the provider were staged while the worker was running,
+// then these ads are kept after them, to be ingested by the worker already
+// scheduled for them.
+func (ing *Ingester) carryOverAds(assignment workerAssignment, adInfos []adInfo) {
+	ing.providersBeingProcessedMu.Lock()
+	defer ing.providersBeingProcessedMu.Unlock()
+	if newer := ing.providerAdChainStaging[assignment.provider].Load(); newer != nil && !newer.(workerAssignment).none {
+		newerAssignment := newer.(workerAssignment)
+		newerAssignment.adInfos = appendNewAdInfos(newerAssignment.adInfos, adInfos)
+		ing.providerAdChainStaging[assignment.provider].Store(newerAssignment)
+		return
+	}
+	ing.carriedOverAds[assignment.provider] = workerAssignment{
+		adInfos:   adInfos,
+		publisher: assignment.publisher,
+		provider:  assignment.provider,
+	}
+}
+
+// appendNewAdInfos returns a new slice with the ads in older appended to the
+// ads in newer, omitting any ads in older that are already in newer.
+func appendNewAdInfos(newer, older []adInfo) []adInfo {
+	seen := make(map[cid.Cid]struct{}, len(newer))
+	merged := make([]adInfo, len(newer), len(newer)+len(older))
+	for i, ai := range newer {
+		seen[ai.cid] = struct{}{}
+		merged[i] = ai
+	}
+	for _, ai := range older {
+		if _, ok := seen[ai.cid]; !ok {
+			merged = append(merged, ai)
+		}
+	}
+	return merged
+}
+
 func (ing *Ingester) handlePendingAnnounce(pid peer.ID) {
 	log := log.With("provider", pid)
 	// Process pending announce request if any.
@@ -957,12 +2423,138 @@ func (ing *Ingester) handlePendingAnnounce(pid peer.ID) {
 	log = log.With("cid", pa.nextCid, "addrinfo", pa.addrInfo)
 	err := ing.sub.Announce(context.Background(), pa.nextCid, pa.addrInfo.ID, pa.addrInfo.Addrs)
 	if err != nil {
-		log.Errorw("Failed to handle pending announce", "err", err)
+		logRepeats.Errorw("Failed to handle pending announce", "cid", pa.nextCid, "addrinfo", pa.addrInfo, "err", err)
 		return
 	}
 	log.Info("Successfully handled pending announce")
 }
 
+// depthLimits are the sync depth limits configured for a specific provider.
+// A zero adDepth means that the configured AdvertisementDepthLimit is used,
+// and a zero entriesDepth means that the default entries selector is used.
+type depthLimits struct {
+	adDepth      int
+	entriesDepth int
+	entriesSel   datamodel.Node
+}
+
+func makeDepthOverrideMap(cfgOverrides []config.DepthLimit) (map[peer.ID]depthLimits, error) {
+	if len(cfgOverrides) == 0 {
+		return nil, nil
+	}
+
+	overrides := make(map[peer.ID]depthLimits, len(cfgOverrides))
+	for _, override := range cfgOverrides {
+		peerID, err := peer.Decode(override.ProviderID)
+		if err != nil {
+			return nil, fmt.Errorf("cannot decode provider ID %q in DepthLimitOverrides: %s", override.ProviderID, err)
+		}
+		limits := depthLimits{
+			adDepth: override.AdvertisementDepthLimit,
+		}
+		if override.EntriesDepthLimit != 0 {
+			limits.entriesDepth = override.EntriesDepthLimit
+			limits.entriesSel = entriesSelectorAfterFirst(override.EntriesDepthLimit)
+		}
+		overrides[peerID] = limits
+	}
+	return overrides, nil
+}
+
+func makeHttpSyncAddrMap(cfgOverrides []config.HttpSyncOverride) (map[peer.ID]multiaddr.Multiaddr, error) {
+	if len(cfgOverrides) == 0 {
+		return nil, nil
+	}
+
+	addrs := make(map[peer.ID]multiaddr.Multiaddr, len(cfgOverrides))
+	for _, override := range cfgOverrides {
+		peerID, err := peer.Decode(override.ProviderID)
+		if err != nil {
+			return nil, fmt.Errorf("cannot decode provider ID %q in HttpSyncOverrides: %s", override.ProviderID, err)
+		}
+		maddr, err := multiaddr.NewMultiaddr(override.Addr)
+		if err != nil {
+			return nil, fmt.Errorf("bad address %q in HttpSyncOverrides: %s", override.Addr, err)
+		}
+		if !isHttpAddr(maddr) {
+			return nil, fmt.Errorf("address %q in HttpSyncOverrides is not an http or https address", override.Addr)
+		}
+		addrs[peerID] = maddr
+	}
+	return addrs, nil
+}
+
+// isHttpAddr returns true if the multiaddr is for an HTTP or HTTPS endpoint.
+func isHttpAddr(maddr multiaddr.Multiaddr) bool {
+	for _, proto := range maddr.Protocols() {
+		if proto.Code == multiaddr.P_HTTP || proto.Code == multiaddr.P_HTTPS {
+			return true
+		}
+	}
+	return false
+}
+
+// adDepthLimit returns the advertisement depth limit for syncing with the
+// publisher, which is the limit configured for the publisher's providers or
+// else the AdvertisementDepthLimit.
+func (ing *Ingester) adDepthLimit(publisherID peer.ID) int {
+	if limit := ing.adDepthOverride(publisherID); limit != 0 {
+		return limit
+	}
+	return ing.cfg.AdvertisementDepthLimit
+}
+
+// adDepthOverride returns the advertisement depth limit configured for the
+// providers that the publisher publishes advertisements for, or zero if there
+// is none. Depth limits are configured by provider ID, so the publisher's own
+// limit is used only if the publisher is also a provider. If more than one of
+// the publisher's providers has a limit, then the smallest limit is used.
+func (ing *Ingester) adDepthOverride(publisherID peer.ID) int {
+	if len(ing.depthOverrides) == 0 {
+		return 0
+	}
+	limit := ing.depthOverrides[publisherID].adDepth
+	for _, info := range ing.reg.AllProviderInfo() {
+		if info.Publisher != publisherID || info.AddrInfo.ID == publisherID {
+			continue
+		}
+		provLimit := ing.depthOverrides[info.AddrInfo.ID].adDepth
+		if provLimit == 0 {
+			continue
+		}
+		// A negative limit means no limit, so any other limit is smaller.
+		if limit == 0 || limit < 0 || (provLimit > 0 && provLimit < limit) {
+			limit = provLimit
+		}
+	}
+	return limit
+}
+
+// entriesSelector returns the selector used to sync the entry chunks that
+// follow the first chunk of the provider's advertisements. Returns nil if the
+// entries depth limit allows no more than the first chunk.
+func (ing *Ingester) entriesSelector(providerID peer.ID) datamodel.Node {
+	if limits := ing.depthOverrides[providerID]; limits.entriesDepth != 0 {
+		return limits.entriesSel
+	}
+	return ing.entriesSel
+}
+
+// entriesSelectorAfterFirst returns the selector that syncs the rest of a
+// chain of entry chunks after its first chunk. The first chunk is synced
+// separately, to check whether the entries are a HAMT, so the recursion limit
+// is reduced by one to sync no more than depth chunks in total. Returns nil if
+// depth is 1, since then only the first chunk is synced.
+func entriesSelectorAfterFirst(depth int) datamodel.Node {
+	if depth == 1 {
+		return nil
+	}
+	if depth > 1 {
+		depth--
+	}
+	return Selectors.EntriesWithLimit(recursionLimit(depth))
+}
+
 // recursionLimit returns the recursion limit for the given depth.
 func recursionLimit(depth int) selector.RecursionLimit {
 	if depth < 1 {
@@ -7,7 +7,10 @@ import (
 	"fmt"
 	"io"
 	"math/rand"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 
@@ -18,8 +21,12 @@ import (
 	"github.com/filecoin-project/go-indexer-core/store/storethehash"
 	"github.com/filecoin-project/go-legs"
 	"github.com/filecoin-project/go-legs/dtsync"
+	"github.com/filecoin-project/go-legs/httpsync"
+	adminmodel "github.com/filecoin-project/storetheindex/api/v0/admin/model"
+	ingestmodel "github.com/filecoin-project/storetheindex/api/v0/ingest/model"
 	schema "github.com/filecoin-project/storetheindex/api/v0/ingest/schema"
 	"github.com/filecoin-project/storetheindex/config"
+	"github.com/filecoin-project/storetheindex/internal/eventsink"
 	"github.com/filecoin-project/storetheindex/internal/registry"
 	"github.com/filecoin-project/storetheindex/test/typehelpers"
 	"github.com/filecoin-project/storetheindex/test/util"
@@ -29,15 +36,20 @@ import (
 	"github.com/ipld/go-ipld-prime"
 	_ "github.com/ipld/go-ipld-prime/codec/dagjson"
 	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/fluent/qp"
 	"github.com/ipld/go-ipld-prime/linking"
 	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
-	"github.com/ipld/go-ipld-prime/traversal/selector"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
 	"github.com/libp2p/go-libp2p"
 	crypto "github.com/libp2p/go-libp2p-core/crypto"
 	"github.com/libp2p/go-libp2p-core/host"
 	"github.com/libp2p/go-libp2p-core/peer"
 	"github.com/libp2p/go-libp2p-core/test"
+	"github.com/libp2p/go-libp2p/p2p/net/connmgr"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/multiformats/go-multicodec"
 	"github.com/multiformats/go-multihash"
+	"github.com/multiformats/go-varint"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/time/rate"
 )
@@ -54,6 +66,7 @@ var (
 	defaultTestIngestConfig = config.Ingest{
 		AdvertisementDepthLimit: 100,
 		EntriesDepthLimit:       100,
+		EntriesFetchAhead:       4,
 		IngestWorkerCount:       1,
 		PubSubTopic:             "test/ingest",
 		RateLimit: config.RateLimit{
@@ -578,74 +591,2418 @@ func TestSyncWithDepth(t *testing.T) {
 	te.Close(t)
 }
 
+func TestCancelInFlightSync(t *testing.T) {
+	// The publisher holds the blocked read until the test is done, so the sync
+	// can only finish by being cancelled.
+	release := make(chan struct{})
+	blockableLsysOpt, blockedReads, hitBlockedRead := blockableLinkSys(func() (io.Reader, error) {
+		<-release
+		return failBlockedRead()
+	})
+	te := setupTestEnv(t, true, blockableLsysOpt)
+	defer te.Close(t)
+	defer close(release)
+
+	adHead := typehelpers.RandomAdBuilder{
+		EntryBuilders: []typehelpers.EntryBuilder{
+			typehelpers.RandomEntryChunkBuilder{ChunkCount: 1, EntriesPerChunk: 1, Seed: 1},
+		}}.Build(t, te.publisherLinkSys, te.publisherPriv)
+	headCid := adHead.(cidlink.Link).Cid
+	headAd := typehelpers.AdFromLink(t, adHead, te.publisherLinkSys)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := te.publisher.SetRoot(ctx, headCid)
+	require.NoError(t, err)
+	// Block reading the entries, so the sync is stuck processing the ad.
+	blockedReads.add(headAd.Entries.(cidlink.Link).Cid)
+
+	require.Empty(t, te.ingester.InFlightSyncs())
+	wait, err := te.ingester.Sync(ctx, te.pubHost.ID(), nil, 0, false)
+	require.NoError(t, err)
+
+	syncs := te.ingester.InFlightSyncs()
+	require.Len(t, syncs, 1)
+	require.Equal(t, te.pubHost.ID(), syncs[0].PeerID)
+
+	<-hitBlockedRead
+	syncs = te.ingester.InFlightSyncs()
+	require.Len(t, syncs, 1)
+	require.Equal(t, syncStageProcessing, syncs[0].Stage)
+	require.False(t, te.ingester.CancelSync(syncs[0].ID+1))
+	require.True(t, te.ingester.CancelSync(syncs[0].ID))
+
+	select {
+	case _, ok := <-wait:
+		require.False(t, ok, "cancelled sync should not return a cid")
+	case <-ctx.Done():
+		t.Fatal("sync was not cancelled")
+	}
+	require.Empty(t, te.ingester.InFlightSyncs())
+	require.False(t, te.ingester.CancelSync(syncs[0].ID))
+}
+
+func TestSyncWithDepthLimitOverride(t *testing.T) {
+	te := setupTestEnv(t, true)
+
+	var err error
+	te.ingester.depthOverrides, err = makeDepthOverrideMap([]config.DepthLimit{
+		{
+			ProviderID:              te.pubHost.ID().String(),
+			AdvertisementDepthLimit: 1,
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, 1, te.ingester.adDepthLimit(te.pubHost.ID()))
+	require.Equal(t, defaultTestIngestConfig.AdvertisementDepthLimit, te.ingester.adDepthLimit(te.ingesterHost.ID()))
+	require.Equal(t, te.ingester.entriesSel, te.ingester.entriesSelector(te.pubHost.ID()))
+
+	// An entries depth limit of 1 syncs only the first chunk, so there is no
+	// selector for the following chunks.
+	overrides, err := makeDepthOverrideMap([]config.DepthLimit{
+		{
+			ProviderID:        te.pubHost.ID().String(),
+			EntriesDepthLimit: 1,
+		},
+	})
+	require.NoError(t, err)
+	require.Nil(t, overrides[te.pubHost.ID()].entriesSel)
+
+	chainHead := typehelpers.RandomAdBuilder{
+		EntryBuilders: []typehelpers.EntryBuilder{
+			typehelpers.RandomEntryChunkBuilder{ChunkCount: 1, EntriesPerChunk: 1, Seed: 1},
+			typehelpers.RandomEntryChunkBuilder{ChunkCount: 1, EntriesPerChunk: 1, Seed: 2},
+		},
+	}.Build(t, te.publisherLinkSys, te.publisherPriv)
+
+	adNode, err := te.publisherLinkSys.Load(linking.LinkContext{}, chainHead, schema.AdvertisementPrototype)
+	require.NoError(t, err)
+	ad, err := schema.UnwrapAdvertisement(adNode)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	err = te.publisher.SetRoot(ctx, chainHead.(cidlink.Link).Cid)
+	require.NoError(t, err)
+
+	// Sync without an explicit depth, so that the depth limit configured for
+	// the provider is used.
+	wait, err := te.ingester.Sync(ctx, te.pubHost.ID(), nil, 0, false)
+	require.NoError(t, err)
+	_, ok := <-wait
+	require.True(t, ok)
+
+	allMhs := typehelpers.AllMultihashesFromAdChain(t, ad, te.publisherLinkSys)
+	requireIndexedEventually(t, te.ingester.indexer, te.pubHost.ID(), []multihash.Multihash{allMhs[1]})
+	requireNotIndexed(t, te.ingester.indexer, te.pubHost.ID(), []multihash.Multihash{allMhs[0]})
+
+	_, err = makeDepthOverrideMap([]config.DepthLimit{{ProviderID: "bad-id"}})
+	require.Error(t, err)
+}
+
+func TestSyncWithPublisherProviderDepthLimitOverride(t *testing.T) {
+	te := setupTestEnv(t, true)
+	pubID := te.pubHost.ID()
+	provID, err := test.RandPeerID()
+	require.NoError(t, err)
+
+	// The depth limit is configured for a provider that is published by
+	// another peer.
+	te.ingester.depthOverrides, err = makeDepthOverrideMap([]config.DepthLimit{
+		{
+			ProviderID:              provID.String(),
+			AdvertisementDepthLimit: 1,
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, defaultTestIngestConfig.AdvertisementDepthLimit, te.ingester.adDepthLimit(pubID))
+
+	addr, err := multiaddr.NewMultiaddr("/ip4/127.0.0.1/tcp/9999")
+	require.NoError(t, err)
+	err = te.reg.Register(context.Background(), &registry.ProviderInfo{
+		AddrInfo:  peer.AddrInfo{ID: provID, Addrs: []multiaddr.Multiaddr{addr}},
+		Publisher: pubID,
+	})
+	require.NoError(t, err)
+	require.Equal(t, 1, te.ingester.adDepthLimit(pubID))
+
+	entries, oldMhs := newRandomLinkedList(t, te.publisherLinkSys, 1)
+	ad1 := storeTestAd(t, te, nil, entries, []byte("context-old"), false)
+	entries, mhs := newRandomLinkedList(t, te.publisherLinkSys, 1)
+	ad2 := storeTestAd(t, te, ad1, entries, []byte("context-new"), false)
+
+	ctx := context.Background()
+	require.NoError(t, te.publisher.SetRoot(ctx, ad2.(cidlink.Link).Cid))
+	wait, err := te.ingester.Sync(ctx, pubID, nil, 0, false)
+	require.NoError(t, err)
+	_, ok := <-wait
+	require.True(t, ok)
+
+	requireIndexedEventually(t, te.core, pubID, mhs)
+	requireNotIndexed(t, te.core, pubID, oldMhs)
+}
+
 type coreWrap struct {
 	indexer.Interface
 	mhs []multihash.Multihash
 }
 
-func (c *coreWrap) Put(value indexer.Value, mhs ...multihash.Multihash) error {
-	c.mhs = append(c.mhs, mhs...)
-	return c.Interface.Put(value, mhs...)
+func (c *coreWrap) Put(value indexer.Value, mhs ...multihash.Multihash) error {
+	c.mhs = append(c.mhs, mhs...)
+	return c.Interface.Put(value, mhs...)
+}
+
+// cancelPutCore cancels a context after the first put.
+type cancelPutCore struct {
+	indexer.Interface
+	cancel context.CancelFunc
+	count  int
+}
+
+func (c *cancelPutCore) Put(value indexer.Value, mhs ...multihash.Multihash) error {
+	c.count += len(mhs)
+	c.cancel()
+	return c.Interface.Put(value, mhs...)
+}
+
+func TestIndexingCancelled(t *testing.T) {
+	te := setupTestEnv(t, true)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cpc := &cancelPutCore{
+		Interface: te.ingester.indexer,
+		cancel:    cancel,
+	}
+	te.ingester.indexer = cpc
+
+	ad := schema.Advertisement{
+		Provider:  te.pubHost.ID().String(),
+		ContextID: []byte("test-context"),
+		Metadata:  []byte("test-metadata"),
+	}
+	mhs := util.RandomMultihashes(10*int(te.ingester.batchSize), rng)
+
+	err := te.ingester.indexAdMultihashes(ctx, ad, mhs, log.With())
+	require.ErrorIs(t, err, context.Canceled)
+	require.NotZero(t, cpc.count)
+	require.Less(t, cpc.count, len(mhs), "indexing did not stop when context was cancelled")
+}
+
+func TestIndexCodecs(t *testing.T) {
+	cfg := defaultTestIngestConfig
+	cfg.IndexCodecs = true
+	te := setupTestEnv(t, true, func(teo *testEnvOpts) {
+		teo.ingestConfig = &cfg
+	})
+	ctx := context.Background()
+	provID := te.pubHost.ID()
+
+	ad := schema.Advertisement{
+		Provider:  provID.String(),
+		ContextID: []byte("test-context"),
+		Metadata:  []byte("test-metadata"),
+	}
+	mhs := util.RandomMultihashes(3, rng)
+	rawCid := cid.NewCidV1(cid.Raw, mhs[0])
+	pbCid := cid.NewCidV1(cid.DagProtobuf, mhs[1])
+	// Entries can be CIDs or multihashes.
+	entries := []multihash.Multihash{rawCid.Bytes(), pbCid.Bytes(), mhs[2]}
+
+	err := te.ingester.indexAdMultihashes(ctx, ad, entries, log.With())
+	require.NoError(t, err)
+	requireIndexedEventually(t, te.ingester.indexer, provID, mhs)
+
+	codec, ok, err := te.reg.Codec(ctx, provID, mhs[0])
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, uint64(cid.Raw), codec)
+	codec, ok, err = te.reg.Codec(ctx, provID, mhs[1])
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, uint64(cid.DagProtobuf), codec)
+	_, ok, err = te.reg.Codec(ctx, provID, mhs[2])
+	require.NoError(t, err)
+	require.False(t, ok, "codec recorded for multihash advertised without codec")
+
+	// Removing the entries removes their codecs.
+	ad.IsRm = true
+	err = te.ingester.indexAdMultihashes(ctx, ad, entries, log.With())
+	require.NoError(t, err)
+	requireNotIndexed(t, te.ingester.indexer, provID, mhs)
+	_, ok, err = te.reg.Codec(ctx, provID, mhs[0])
+	require.NoError(t, err)
+	require.False(t, ok, "codec not removed")
+}
+
+func TestIndexTimestamps(t *testing.T) {
+	cfg := defaultTestIngestConfig
+	cfg.IndexTimestamps = true
+	te := setupTestEnv(t, true, func(teo *testEnvOpts) {
+		teo.ingestConfig = &cfg
+	})
+	ctx := context.Background()
+	provID := te.pubHost.ID()
+
+	ad := schema.Advertisement{
+		Provider:  provID.String(),
+		ContextID: []byte("test-context"),
+		Metadata:  []byte("test-metadata"),
+	}
+	mhs := util.RandomMultihashes(2, rng)
+
+	start := time.Now()
+	err := te.ingester.indexAdMultihashes(ctx, ad, mhs[:1], log.With())
+	require.NoError(t, err)
+	firstTime, ok, err := te.reg.IndexTime(ctx, provID, ad.ContextID, mhs[0])
+	require.NoError(t, err)
+	require.True(t, ok, "index time not recorded")
+	require.False(t, firstTime.Before(start))
+
+	// A multihash indexed later in the same context gets its own time.
+	time.Sleep(time.Millisecond)
+	err = te.ingester.indexAdMultihashes(ctx, ad, mhs[1:], log.With())
+	require.NoError(t, err)
+	secondTime, ok, err := te.reg.IndexTime(ctx, provID, ad.ContextID, mhs[1])
+	require.NoError(t, err)
+	require.True(t, ok, "index time not recorded")
+	require.True(t, secondTime.After(firstTime))
+	indexTime, _, err := te.reg.IndexTime(ctx, provID, ad.ContextID, mhs[0])
+	require.NoError(t, err)
+	require.True(t, indexTime.Equal(firstTime), "index time of earlier multihash changed")
+
+	// Removing the entries removes their index times.
+	ad.IsRm = true
+	err = te.ingester.indexAdMultihashes(ctx, ad, mhs[:1], log.With())
+	require.NoError(t, err)
+	_, ok, err = te.reg.IndexTime(ctx, provID, ad.ContextID, mhs[0])
+	require.NoError(t, err)
+	require.False(t, ok, "index time not removed")
+}
+
+func TestBlockedMultihashNotIndexed(t *testing.T) {
+	te := setupTestEnv(t, true)
+	ctx := context.Background()
+	provID := te.pubHost.ID()
+
+	ad := schema.Advertisement{
+		Provider:  provID.String(),
+		ContextID: []byte("test-context"),
+		Metadata:  []byte("test-metadata"),
+	}
+	mhs := util.RandomMultihashes(3, rng)
+	_, err := te.reg.BlockMultihash(ctx, mhs[0])
+	require.NoError(t, err)
+
+	err = te.ingester.indexAdMultihashes(ctx, ad, mhs, log.With())
+	require.NoError(t, err)
+	requireIndexedEventually(t, te.ingester.indexer, provID, mhs[1:])
+	requireNotIndexed(t, te.ingester.indexer, provID, mhs[:1])
+}
+
+// fullDiskCore fails all puts with an out of space error while full is set.
+type fullDiskCore struct {
+	indexer.Interface
+	full     int32
+	attempts int32
+}
+
+func (c *fullDiskCore) Put(value indexer.Value, mhs ...multihash.Multihash) error {
+	if atomic.LoadInt32(&c.full) != 0 {
+		atomic.AddInt32(&c.attempts, 1)
+		// Format the error without wrapping, as some value stores do.
+		return fmt.Errorf("cannot write index: %s", syscall.ENOSPC)
+	}
+	return c.Interface.Put(value, mhs...)
+}
+
+func TestOutOfSpaceRetried(t *testing.T) {
+	defer func(retry time.Duration) { outOfSpaceRetry = retry }(outOfSpaceRetry)
+	outOfSpaceRetry = 200 * time.Millisecond
+
+	te := setupTestEnv(t, true)
+	pubID := te.pubHost.ID()
+	fdc := &fullDiskCore{
+		Interface: te.ingester.indexer,
+		full:      1,
+	}
+	te.ingester.indexer = fdc
+
+	entries, mhs := newRandomLinkedList(t, te.publisherLinkSys, 3)
+	ad := storeTestAd(t, te, nil, entries, []byte("test-context"), false)
+	adCid := ad.(cidlink.Link).Cid
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err := te.publisher.SetRoot(ctx, adCid)
+	require.NoError(t, err)
+	_, err = te.ingester.Sync(ctx, pubID, nil, 0, false)
+	require.NoError(t, err)
+
+	requireTrueEventually(t, func() bool {
+		return atomic.LoadInt32(&fdc.attempts) != 0
+	}, testRetryInterval, testRetryTimeout, "Expected value store write to be attempted")
+	require.NotZero(t, te.ingester.outOfSpaceDelay(), "Expected ingestion to be paused")
+	require.False(t, te.ingester.adAlreadyProcessed(adCid), "Ad must not be marked processed while out of space")
+
+	// Free up space and check that the ad is ingested without another sync.
+	atomic.StoreInt32(&fdc.full, 0)
+	requireIndexedEventually(t, te.core, pubID, mhs)
+	requireTrueEventually(t, func() bool {
+		return te.ingester.adAlreadyProcessed(adCid)
+	}, testRetryInterval, testRetryTimeout, "Expected ad to be processed after retry")
+}
+
+func TestHttpSyncOverride(t *testing.T) {
+	priv, _, err := test.RandTestKeyPair(crypto.Ed25519, 256)
+	require.NoError(t, err)
+	provID, err := peer.IDFromPrivateKey(priv)
+	require.NoError(t, err)
+
+	// Serve the provider's advertisement chain over HTTP only.
+	pubStore := dssync.MutexWrap(datastore.NewMapDatastore())
+	pubLinkSys := mkProvLinkSystem(pubStore)
+	pub, err := httpsync.NewPublisher("127.0.0.1:0", pubLinkSys, provID, priv)
+	require.NoError(t, err)
+	t.Cleanup(func() { pub.Close() })
+
+	entries, mhs := newRandomLinkedList(t, pubLinkSys, 2)
+	ad := &schema.Advertisement{
+		Provider:  provID.String(),
+		Addresses: []string{"/ip4/127.0.0.1/tcp/9999"},
+		Entries:   entries,
+		ContextID: []byte("test-context"),
+		Metadata:  []byte("test-metadata"),
+	}
+	require.NoError(t, ad.Sign(priv))
+	node, err := ad.ToNode()
+	require.NoError(t, err)
+	adLink, err := pubLinkSys.Store(ipld.LinkContext{}, schema.Linkproto, node)
+	require.NoError(t, err)
+	adCid := adLink.(cidlink.Link).Cid
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	require.NoError(t, pub.SetRoot(ctx, adCid))
+
+	cfg := defaultTestIngestConfig
+	cfg.HttpSyncOverrides = []config.HttpSyncOverride{
+		{
+			ProviderID: provID.String(),
+			Addr:       pub.Address().String(),
+		},
+	}
+	// The ingester is not connected to the provider over libp2p, so the sync
+	// only succeeds if it is done over HTTP.
+	h := mkTestHost()
+	ing, core, reg := mkIngestWithConfig(t, h, cfg)
+	t.Cleanup(func() {
+		ing.Close()
+		core.Close()
+		reg.Close()
+	})
+
+	end, err := ing.Sync(ctx, provID, nil, 0, false)
+	require.NoError(t, err)
+	select {
+	case endCid := <-end:
+		require.Equal(t, adCid, endCid)
+	case <-ctx.Done():
+		t.Fatal("sync timeout")
+	}
+	requireIndexedEventually(t, core, provID, mhs)
+
+	// Only HTTP addresses are accepted.
+	cfg.HttpSyncOverrides[0].Addr = "/ip4/127.0.0.1/tcp/9999"
+	_, err = makeHttpSyncAddrMap(cfg.HttpSyncOverrides)
+	require.Error(t, err)
+}
+
+func TestResyncOnStartup(t *testing.T) {
+	te := setupTestEnv(t, true)
+	pubID := te.pubHost.ID()
+
+	entries, mhs := newRandomLinkedList(t, te.publisherLinkSys, 1)
+	ad := storeTestAd(t, te, nil, entries, []byte("test-context"), false)
+	syncTestAd(t, te, ad)
+	requireIndexedEventually(t, te.core, pubID, mhs)
+
+	// Start another ingester that uses the same registry, as if the indexer
+	// restarted, and check that it syncs the known provider on its own.
+	cfg := defaultTestIngestConfig
+	cfg.ResyncOnStartup = true
+	h := mkTestHost()
+	connectHosts(t, h, te.pubHost)
+	core := mkIndexer(t, true)
+	ing, err := NewIngester(cfg, h, core, te.reg, dssync.MutexWrap(datastore.NewMapDatastore()))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		ing.Close()
+		core.Close()
+	})
+
+	requireIndexedEventually(t, core, pubID, mhs)
+}
+
+func TestWorkerMetricCounters(t *testing.T) {
+	te := setupTestEnv(t, true)
+
+	requireTrueEventually(t, func() bool {
+		return atomic.LoadInt32(&te.ingester.liveWorkers) == int32(defaultTestIngestConfig.IngestWorkerCount)
+	}, testRetryInterval, testRetryTimeout, "Expected all workers to be running")
+
+	entries, mhs := newRandomLinkedList(t, te.publisherLinkSys, 1)
+	ad := storeTestAd(t, te, nil, entries, []byte("test-context"), false)
+	syncTestAd(t, te, ad)
+	requireIndexedEventually(t, te.core, te.pubHost.ID(), mhs)
+
+	// Once the ad is ingested, no work is pending and no worker is busy.
+	requireTrueEventually(t, func() bool {
+		return atomic.LoadInt32(&te.ingester.pendingWork) == 0 && atomic.LoadInt32(&te.ingester.busyWorkers) == 0
+	}, testRetryInterval, testRetryTimeout, "Expected no pending work and no busy workers")
+	te.ingester.recordWorkerMetrics()
+
+	te.ingester.RunWorkers(1)
+	requireTrueEventually(t, func() bool {
+		return atomic.LoadInt32(&te.ingester.liveWorkers) == 1
+	}, testRetryInterval, testRetryTimeout, "Expected one worker to be running")
+}
+
+func TestProtectPeer(t *testing.T) {
+	cm, err := connmgr.NewConnManager(1, 2)
+	require.NoError(t, err)
+	h := mkTestHost(libp2p.ConnectionManager(cm))
+	defer h.Close()
+	ing := &Ingester{host: h}
+	peerID, err := peer.Decode("12D3KooWLjeDyvuv7rbfG2wWNvWn7ybmmU88PirmSckuqCgXBAph")
+	require.NoError(t, err)
+
+	// The connection stays protected until all syncs with the peer are done.
+	unprotect1 := ing.protectPeer(peerID)
+	unprotect2 := ing.protectPeer(peerID)
+	require.True(t, cm.IsProtected(peerID, ""))
+	unprotect1()
+	require.True(t, cm.IsProtected(peerID, ""))
+	unprotect2()
+	require.False(t, cm.IsProtected(peerID, ""))
+}
+
+func TestAllowedKeyTypes(t *testing.T) {
+	reg := mkRegistry(t)
+
+	signedAdNode := func(keyType int) ipld.Node {
+		priv, _, err := crypto.GenerateKeyPair(keyType, 256)
+		require.NoError(t, err)
+		provID, err := peer.IDFromPrivateKey(priv)
+		require.NoError(t, err)
+		ad := schema.Advertisement{
+			Provider:  provID.String(),
+			Addresses: []string{"/ip4/127.0.0.1/tcp/9999"},
+			Entries:   schema.NoEntries,
+			ContextID: []byte("test-context"),
+			Metadata:  []byte("test-metadata"),
+		}
+		require.NoError(t, ad.Sign(priv))
+		node, err := ad.ToNode()
+		require.NoError(t, err)
+		return node
+	}
+	edNode := signedAdNode(crypto.Ed25519)
+	secpNode := signedAdNode(crypto.Secp256k1)
+
+	// All key types are allowed when none are configured.
+	_, _, err := verifyAdvertisement(edNode, cid.Undef, reg, nil, false, nil)
+	require.NoError(t, err)
+	_, _, err = verifyAdvertisement(secpNode, cid.Undef, reg, nil, false, nil)
+	require.NoError(t, err)
+
+	keyTypes, err := makeKeyTypeSet([]string{"ed25519"})
+	require.NoError(t, err)
+	_, _, err = verifyAdvertisement(edNode, cid.Undef, reg, keyTypes, false, nil)
+	require.NoError(t, err)
+	_, _, err = verifyAdvertisement(secpNode, cid.Undef, reg, keyTypes, false, nil)
+	require.ErrorIs(t, err, errDisallowedKeyType)
+
+	_, err = makeKeyTypeSet([]string{"Ed25519", "DSA"})
+	require.Error(t, err)
+}
+
+func TestVerifiedSigCache(t *testing.T) {
+	reg := mkRegistry(t)
+	lsys := mkProvLinkSystem(datastore.NewMapDatastore())
+
+	priv, _, err := crypto.GenerateKeyPair(crypto.Ed25519, 256)
+	require.NoError(t, err)
+	provID, err := peer.IDFromPrivateKey(priv)
+	require.NoError(t, err)
+	ad := schema.Advertisement{
+		Provider:  provID.String(),
+		Addresses: []string{"/ip4/127.0.0.1/tcp/9999"},
+		Entries:   schema.NoEntries,
+		ContextID: []byte("test-context"),
+		Metadata:  []byte("test-metadata"),
+	}
+	require.NoError(t, ad.Sign(priv))
+	node, err := ad.ToNode()
+	require.NoError(t, err)
+	lnk, err := lsys.Store(ipld.LinkContext{}, schema.Linkproto, node)
+	require.NoError(t, err)
+	adCid := lnk.(cidlink.Link).Cid
+
+	sigs := newVerifiedSigCache(2)
+	for i := 0; i < 2; i++ {
+		gotID, _, err := verifyAdvertisement(node, adCid, reg, nil, false, sigs)
+		require.NoError(t, err)
+		require.Equal(t, provID, gotID)
+		require.Equal(t, 1, sigs.cache.Len())
+	}
+
+	// Checks that do not depend on the signature are still done for an ad
+	// whose signature is cached.
+	keyTypes, err := makeKeyTypeSet([]string{"secp256k1"})
+	require.NoError(t, err)
+	_, _, err = verifyAdvertisement(node, adCid, reg, keyTypes, false, sigs)
+	require.ErrorIs(t, err, errDisallowedKeyType)
+
+	// An ad changed after signing has a different CID, so its signature is
+	// verified.
+	ad.Metadata = []byte("altered-metadata")
+	node, err = ad.ToNode()
+	require.NoError(t, err)
+	lnk, err = lsys.Store(ipld.LinkContext{}, schema.Linkproto, node)
+	require.NoError(t, err)
+	_, _, err = verifyAdvertisement(node, lnk.(cidlink.Link).Cid, reg, nil, false, sigs)
+	require.ErrorIs(t, err, errInvalidAdvertSignature)
+	require.Equal(t, 1, sigs.cache.Len())
+}
+
+func TestBadSignatureBlock(t *testing.T) {
+	discoveryCfg := config.Discovery{
+		Policy: config.Policy{
+			Allow: true,
+		},
+		PollInterval:   config.Duration(time.Minute),
+		RediscoverWait: config.Duration(time.Minute),
+	}
+	reg, err := registry.NewRegistry(context.Background(), discoveryCfg, nil, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { reg.Close() })
+
+	syncData, err := newSyncDataStore(context.Background(), datastore.NewMapDatastore(), 0, nil)
+	require.NoError(t, err)
+	badSigs := newBadSigTracker(reg, 3)
+	lsys := mkLinkSystem(syncData, reg, nil, false, 0, nil, badSigs)
+
+	priv, _, err := crypto.GenerateKeyPair(crypto.Ed25519, 256)
+	require.NoError(t, err)
+	signerID, err := peer.IDFromPrivateKey(priv)
+	require.NoError(t, err)
+	otherProvider, err := test.RandPeerID()
+	require.NoError(t, err)
+
+	// storeAd stores an ad for the provider, signed by the signer, which is
+	// only allowed for the signer's own ads.
+	var seq int
+	storeAd := func(provID peer.ID) error {
+		seq++
+		ad := schema.Advertisement{
+			Provider:  provID.String(),
+			Addresses: []string{"/ip4/127.0.0.1/tcp/9999"},
+			Entries:   schema.NoEntries,
+			ContextID: []byte(fmt.Sprint("test-context-", seq)),
+			Metadata:  []byte("test-metadata"),
+		}
+		require.NoError(t, ad.Sign(priv))
+		node, err := ad.ToNode()
+		require.NoError(t, err)
+		_, err = lsys.Store(ipld.LinkContext{Ctx: context.Background()}, schema.Linkproto, node)
+		return err
+	}
+
+	require.ErrorIs(t, storeAd(otherProvider), errInvalidAdvertSignature)
+	require.ErrorIs(t, storeAd(otherProvider), errInvalidAdvertSignature)
+	require.Equal(t, 2, badSigs.count(signerID))
+
+	// A valid ad from the signer resets the count.
+	require.NoError(t, storeAd(signerID))
+	require.Zero(t, badSigs.count(signerID))
+	require.True(t, reg.Allowed(signerID))
+
+	for i := 0; i < 3; i++ {
+		require.ErrorIs(t, storeAd(otherProvider), errInvalidAdvertSignature)
+	}
+	require.False(t, reg.Allowed(signerID))
+
+	// An ad without a valid signature does not identify a signer to block.
+	unsigned := schema.Advertisement{
+		Provider:  signerID.String(),
+		Addresses: []string{"/ip4/127.0.0.1/tcp/9999"},
+		Entries:   schema.NoEntries,
+		ContextID: []byte("unsigned-context"),
+		Metadata:  []byte("test-metadata"),
+	}
+	node, err := unsigned.ToNode()
+	require.NoError(t, err)
+	_, err = lsys.Store(ipld.LinkContext{Ctx: context.Background()}, schema.Linkproto, node)
+	require.ErrorIs(t, err, errInvalidAdvertSignature)
+	require.Zero(t, badSigs.count(""))
+}
+
+func TestAdFilter(t *testing.T) {
+	te := setupTestEnv(t, true)
+	pubID := te.pubHost.ID()
+
+	rejected := []byte("context-rejected")
+	te.ingester.SetAdFilter(AdFilterFunc(func(_ peer.ID, ad schema.Advertisement) error {
+		if bytes.Equal(ad.ContextID, rejected) {
+			return errors.New("provider unavailable")
+		}
+		return nil
+	}))
+
+	entries, rejectedMhs := newRandomLinkedList(t, te.publisherLinkSys, 1)
+	ad1 := storeTestAd(t, te, nil, entries, rejected, false)
+	entries, mhs := newRandomLinkedList(t, te.publisherLinkSys, 1)
+	ad2 := storeTestAd(t, te, ad1, entries, []byte("context-accepted"), false)
+	syncTestAd(t, te, ad2)
+
+	// The rejected ad is skipped, and does not stop later ads from being
+	// ingested.
+	requireIndexedEventually(t, te.core, pubID, mhs)
+	requireNotIndexed(t, te.core, pubID, rejectedMhs)
+}
+
+func TestAdProviderNotAllowed(t *testing.T) {
+	te := setupTestEnv(t, true)
+	pubID := te.pubHost.ID()
+	blockedID, err := test.RandPeerID()
+	require.NoError(t, err)
+	te.reg.BlockPeer(blockedID)
+
+	entries, blockedMhs := newRandomLinkedList(t, te.publisherLinkSys, 1)
+	ad1 := storeProviderTestAd(t, te, nil, blockedID, entries, []byte("context-blocked"), []byte("test-metadata"), false)
+	entries, mhs := newRandomLinkedList(t, te.publisherLinkSys, 1)
+	ad2 := storeTestAd(t, te, ad1, entries, []byte("context-allowed"), false)
+	syncTestAd(t, te, ad2)
+
+	// The ad for the blocked provider is skipped, and does not stop later ads
+	// from being ingested.
+	requireIndexedEventually(t, te.core, pubID, mhs)
+	requireNotIndexed(t, te.core, blockedID, blockedMhs)
+	require.Nil(t, te.reg.ProviderInfo(blockedID))
+}
+
+func TestUnknownProviderPolicy(t *testing.T) {
+	t.Run("reject", func(t *testing.T) {
+		cfg := defaultTestIngestConfig
+		cfg.UnknownProviderPolicy = "reject"
+		te := setupTestEnv(t, true, func(teo *testEnvOpts) {
+			teo.ingestConfig = &cfg
+		})
+		pubID := te.pubHost.ID()
+		unknownID, err := test.RandPeerID()
+		require.NoError(t, err)
+		addr, err := multiaddr.NewMultiaddr("/ip4/127.0.0.1/tcp/9999")
+		require.NoError(t, err)
+		err = te.reg.Register(context.Background(), &registry.ProviderInfo{
+			AddrInfo: peer.AddrInfo{ID: pubID, Addrs: []multiaddr.Multiaddr{addr}},
+		})
+		require.NoError(t, err)
+
+		entries, unknownMhs := newRandomLinkedList(t, te.publisherLinkSys, 1)
+		ad1 := storeProviderTestAd(t, te, nil, unknownID, entries, []byte("context-unknown"), []byte("test-metadata"), false)
+		entries, mhs := newRandomLinkedList(t, te.publisherLinkSys, 1)
+		ad2 := storeTestAd(t, te, ad1, entries, []byte("context-known"), false)
+		syncTestAd(t, te, ad2)
+
+		// The ad for the unknown provider is skipped, and the provider is
+		// not registered.
+		requireIndexedEventually(t, te.core, pubID, mhs)
+		requireNotIndexed(t, te.core, unknownID, unknownMhs)
+		require.Nil(t, te.reg.ProviderInfo(unknownID))
+	})
+
+	t.Run("flag", func(t *testing.T) {
+		cfg := defaultTestIngestConfig
+		cfg.UnknownProviderPolicy = "flag"
+		te := setupTestEnv(t, true, func(teo *testEnvOpts) {
+			teo.ingestConfig = &cfg
+		})
+		unknownID, err := test.RandPeerID()
+		require.NoError(t, err)
+
+		entries, mhs := newRandomLinkedList(t, te.publisherLinkSys, 1)
+		ad := storeProviderTestAd(t, te, nil, unknownID, entries, []byte("context-unknown"), []byte("test-metadata"), false)
+		syncTestAd(t, te, ad)
+
+		// The ad is ingested, and the provider is registered and flagged.
+		requireIndexedEventually(t, te.core, unknownID, mhs)
+		info := te.reg.ProviderInfo(unknownID)
+		require.NotNil(t, info)
+		require.True(t, info.AutoRegistered)
+		require.Len(t, info.AddrInfo.Addrs, 1)
+	})
+}
+
+func TestRequirePublisherIsProvider(t *testing.T) {
+	cfg := defaultTestIngestConfig
+	cfg.RequirePublisherIsProvider = true
+	te := setupTestEnv(t, true, func(teo *testEnvOpts) {
+		teo.ingestConfig = &cfg
+	})
+	pubID := te.pubHost.ID()
+	otherID, err := test.RandPeerID()
+	require.NoError(t, err)
+
+	entries, otherMhs := newRandomLinkedList(t, te.publisherLinkSys, 1)
+	ad1 := storeProviderTestAd(t, te, nil, otherID, entries, []byte("context-other"), []byte("test-metadata"), false)
+	entries, mhs := newRandomLinkedList(t, te.publisherLinkSys, 1)
+	ad2 := storeTestAd(t, te, ad1, entries, []byte("context-own"), false)
+	syncTestAd(t, te, ad2)
+
+	requireIndexedEventually(t, te.core, pubID, mhs)
+	requireNotIndexed(t, te.core, otherID, otherMhs)
+	require.Nil(t, te.reg.ProviderInfo(otherID))
+}
+
+func TestMultiProviderChainOrdering(t *testing.T) {
+	te := setupTestEnv(t, true)
+	provA := te.pubHost.ID()
+	provB, err := test.RandPeerID()
+	require.NoError(t, err)
+
+	storeAd := func(prev ipld.Link, provider peer.ID, entries ipld.Link, contextID []byte, isRm bool) ipld.Link {
+		return storeProviderTestAd(t, te, prev, provider, entries, contextID, []byte("test-metadata"), isRm)
+	}
+
+	// Both providers use the same context ID, and provider A removes it.
+	ctxID := []byte("shared-context")
+	entries, mhsA := newRandomLinkedList(t, te.publisherLinkSys, 1)
+	ad1 := storeAd(nil, provA, entries, ctxID, false)
+	entries, mhsB := newRandomLinkedList(t, te.publisherLinkSys, 1)
+	ad2 := storeAd(ad1, provB, entries, ctxID, false)
+	ad3 := storeAd(ad2, provA, schema.NoEntries, ctxID, true)
+	entries, mhsB2 := newRandomLinkedList(t, te.publisherLinkSys, 1)
+	ad4 := storeAd(ad3, provB, entries, []byte("other-context"), false)
+	syncTestAd(t, te, ad4)
+
+	// Provider A's removal applies after its earlier ad, and does not affect
+	// provider B's content with the same context ID.
+	requireIndexedEventually(t, te.core, provB, mhsB)
+	requireIndexedEventually(t, te.core, provB, mhsB2)
+	requireTrueEventually(t, func() bool {
+		return te.ingester.adAlreadyProcessed(ad3.(cidlink.Link).Cid)
+	}, testRetryInterval, testRetryTimeout, "Expected removal ad to be processed")
+	requireNotIndexed(t, te.core, provA, mhsA)
+}
+
+// providerCountCore records the largest number of providers that have puts
+// in progress at the same time.
+// chanSink is an event sink that sends published events to a channel.
+type chanSink chan eventsink.Event
+
+func (s chanSink) Publish(event eventsink.Event) error {
+	s <- event
+	return nil
+}
+
+func (s chanSink) Close() error { return nil }
+
+func TestEventSink(t *testing.T) {
+	srcStore := dssync.MutexWrap(datastore.NewMapDatastore())
+	h := mkTestHost()
+	pubHost := mkTestHost()
+	i, core, _ := mkIngest(t, h)
+	defer core.Close()
+	defer i.Close()
+	pub, lsys := mkMockPublisher(t, pubHost, srcStore)
+	defer pub.Close()
+	connectHosts(t, h, pubHost)
+
+	sink := make(chanSink, 10)
+	i.SetEventSink(sink, 10)
+
+	c1, _, _ := publishRandomIndexAndAdv(t, pub, lsys, false)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	end, err := i.Sync(ctx, pubHost.ID(), nil, 0, false)
+	require.NoError(t, err)
+	require.Equal(t, c1, <-end)
+
+	select {
+	case event := <-sink:
+		require.Equal(t, pubHost.ID(), event.Publisher)
+		require.Equal(t, c1, event.AdCid)
+		require.Equal(t, c1, event.HeadAdCid)
+		require.Empty(t, event.Error)
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for event")
+	}
+}
+
+type providerCountCore struct {
+	indexer.Interface
+	delay time.Duration
+
+	mutex     sync.Mutex
+	providers map[peer.ID]int
+	max       int
+}
+
+func (c *providerCountCore) Put(value indexer.Value, mhs ...multihash.Multihash) error {
+	c.mutex.Lock()
+	c.providers[value.ProviderID]++
+	if len(c.providers) > c.max {
+		c.max = len(c.providers)
+	}
+	c.mutex.Unlock()
+
+	time.Sleep(c.delay)
+	err := c.Interface.Put(value, mhs...)
+
+	c.mutex.Lock()
+	c.providers[value.ProviderID]--
+	if c.providers[value.ProviderID] == 0 {
+		delete(c.providers, value.ProviderID)
+	}
+	c.mutex.Unlock()
+	return err
+}
+
+func TestMaxConcurrentProviders(t *testing.T) {
+	const provCount = 3
+
+	cfg := defaultTestIngestConfig
+	cfg.IngestWorkerCount = provCount
+	cfg.MaxConcurrentProviders = 1
+	te := setupTestEnv(t, true, func(teo *testEnvOpts) {
+		teo.ingestConfig = &cfg
+	})
+	core := &providerCountCore{
+		Interface: te.ingester.indexer,
+		delay:     50 * time.Millisecond,
+		providers: map[peer.ID]int{},
+	}
+	te.ingester.indexer = core
+
+	provIDs := make([]peer.ID, provCount)
+	provMhs := make([][]multihash.Multihash, provCount)
+	var prev ipld.Link
+	for i := range provIDs {
+		var err error
+		provIDs[i], err = test.RandPeerID()
+		require.NoError(t, err)
+		var entries ipld.Link
+		entries, provMhs[i] = newRandomLinkedList(t, te.publisherLinkSys, 2)
+		prev = storeProviderTestAd(t, te, prev, provIDs[i], entries, []byte("context"), []byte("test-metadata"), false)
+	}
+	syncTestAd(t, te, prev)
+
+	for i, provID := range provIDs {
+		requireIndexedEventually(t, te.core, provID, provMhs[i])
+	}
+	core.mutex.Lock()
+	defer core.mutex.Unlock()
+	require.Equal(t, 1, core.max, "more than one provider ingested at a time")
+}
+
+func TestRetrievableAdFilter(t *testing.T) {
+	ad := schema.Advertisement{
+		Metadata: varint.ToUvarint(uint64(multicodec.TransportBitswap)),
+	}
+	require.NoError(t, RetrievableAdFilter.FilterAd("", ad))
+
+	ad.Metadata = []byte("test-metadata")
+	require.Error(t, RetrievableAdFilter.FilterAd("", ad))
+
+	ad.Metadata = nil
+	require.Error(t, RetrievableAdFilter.FilterAd("", ad))
+
+	// Removals are always allowed.
+	ad.IsRm = true
+	require.NoError(t, RetrievableAdFilter.FilterAd("", ad))
+}
+
+func TestSkipTrustedSignatureCheck(t *testing.T) {
+	badSigNode := func(t *testing.T) (peer.ID, ipld.Node) {
+		priv, _, err := crypto.GenerateKeyPair(crypto.Ed25519, 256)
+		require.NoError(t, err)
+		provID, err := peer.IDFromPrivateKey(priv)
+		require.NoError(t, err)
+		ad := schema.Advertisement{
+			Provider:  provID.String(),
+			Addresses: []string{"/ip4/127.0.0.1/tcp/9999"},
+			Entries:   schema.NoEntries,
+			ContextID: []byte("test-context"),
+			Metadata:  []byte("test-metadata"),
+		}
+		require.NoError(t, ad.Sign(priv))
+		// Change the ad after signing so that the signature is not valid.
+		ad.Metadata = []byte("altered-metadata")
+		node, err := ad.ToNode()
+		require.NoError(t, err)
+		return provID, node
+	}
+	trustedID, trustedNode := badSigNode(t)
+	_, untrustedNode := badSigNode(t)
+
+	discoveryCfg := config.Discovery{
+		Policy: config.Policy{
+			Allow:   true,
+			Publish: true,
+			Trusted: []string{trustedID.String()},
+		},
+		PollInterval:   config.Duration(time.Minute),
+		RediscoverWait: config.Duration(time.Minute),
+	}
+	reg, err := registry.NewRegistry(context.Background(), discoveryCfg, nil, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { reg.Close() })
+
+	// Signatures are verified for everyone unless skipping is enabled.
+	_, _, err = verifyAdvertisement(trustedNode, cid.Undef, reg, nil, false, nil)
+	require.ErrorIs(t, err, errInvalidAdvertSignature)
+
+	provID, _, err := verifyAdvertisement(trustedNode, cid.Undef, reg, nil, true, nil)
+	require.NoError(t, err)
+	require.Equal(t, trustedID, provID)
+
+	// Untrusted providers are always verified.
+	_, _, err = verifyAdvertisement(untrustedNode, cid.Undef, reg, nil, true, nil)
+	require.ErrorIs(t, err, errInvalidAdvertSignature)
+}
+
+func TestSkipTrustedSignatureCheckPublisher(t *testing.T) {
+	// storeBadSigAd stores an advertisement for the provider with a signature
+	// that is not valid.
+	storeBadSigAd := func(t *testing.T, te *testEnv, prev ipld.Link, provider peer.ID, entries ipld.Link) ipld.Link {
+		ad := &schema.Advertisement{
+			PreviousID: prev,
+			Provider:   provider.String(),
+			Addresses:  []string{"/ip4/127.0.0.1/tcp/9999"},
+			Entries:    entries,
+			ContextID:  []byte("context-forged"),
+			Metadata:   []byte("test-metadata"),
+		}
+		require.NoError(t, ad.Sign(te.publisherPriv))
+		ad.Metadata = []byte("altered-metadata")
+		node, err := ad.ToNode()
+		require.NoError(t, err)
+		lnk, err := te.publisherLinkSys.Store(ipld.LinkContext{}, schema.Linkproto, node)
+		require.NoError(t, err)
+		return lnk
+	}
+	cfg := defaultTestIngestConfig
+	cfg.SkipTrustedSignatureCheck = true
+	trustedID, err := test.RandPeerID()
+	require.NoError(t, err)
+
+	t.Run("untrusted publisher", func(t *testing.T) {
+		te := setupTestEnv(t, true, func(teo *testEnvOpts) {
+			teo.ingestConfig = &cfg
+		})
+		pubID := te.pubHost.ID()
+		err := te.reg.SetPolicy(config.Policy{
+			Allow:   true,
+			Publish: true,
+			Trusted: []string{trustedID.String()},
+		})
+		require.NoError(t, err)
+
+		entries, forgedMhs := newRandomLinkedList(t, te.publisherLinkSys, 1)
+		ad1 := storeBadSigAd(t, te, nil, trustedID, entries)
+		entries, mhs := newRandomLinkedList(t, te.publisherLinkSys, 1)
+		ad2 := storeTestAd(t, te, ad1, entries, []byte("context-own"), false)
+		syncTestAd(t, te, ad2)
+
+		// The ad that claims the trusted provider is rejected, and the
+		// trusted provider is not registered from it.
+		requireIndexedEventually(t, te.core, pubID, mhs)
+		requireNotIndexed(t, te.core, trustedID, forgedMhs)
+		require.Nil(t, te.reg.ProviderInfo(trustedID))
+	})
+
+	t.Run("trusted publisher", func(t *testing.T) {
+		te := setupTestEnv(t, true, func(teo *testEnvOpts) {
+			teo.ingestConfig = &cfg
+		})
+		err := te.reg.SetPolicy(config.Policy{
+			Allow:   true,
+			Publish: true,
+			Trusted: []string{trustedID.String(), te.pubHost.ID().String()},
+		})
+		require.NoError(t, err)
+
+		entries, mhs := newRandomLinkedList(t, te.publisherLinkSys, 1)
+		ad := storeBadSigAd(t, te, nil, trustedID, entries)
+		syncTestAd(t, te, ad)
+
+		// The signature is not checked because the publisher is trusted.
+		requireIndexedEventually(t, te.core, trustedID, mhs)
+		require.NotNil(t, te.reg.ProviderInfo(trustedID))
+	})
+}
+
+func TestDistributeEventsSlowReader(t *testing.T) {
+	ing := &Ingester{
+		inEvents: make(chan adProcessedEvent),
+	}
+	go ing.distributeEvents()
+	defer close(ing.inEvents)
+
+	pubID, err := peer.Decode("12D3KooWKRyzVWW6ChFjQjK4miCty85Niy48tpPV95XdKu1BcvMA")
+	require.NoError(t, err)
+
+	// This reader never reads its events.
+	stuckCh, stuckCancel := ing.onAdProcessed(pubID)
+	defer stuckCancel()
+
+	readCh, readCancel := ing.onAdProcessed(pubID)
+	defer readCancel()
+
+	const eventCount = 3 * adProcessedEventBuffer
+	var cids []cid.Cid
+	for _, mh := range util.RandomMultihashes(eventCount, rng) {
+		cids = append(cids, cid.NewCidV1(cid.Raw, mh))
+	}
+	for _, c := range cids {
+		select {
+		case ing.inEvents <- adProcessedEvent{publisher: pubID, adCid: c}:
+		case <-time.After(time.Second):
+			t.Fatal("distribution of events blocked by slow reader")
+		}
+		select {
+		case event := <-readCh:
+			require.Equal(t, c, event.adCid)
+		case <-time.After(time.Second):
+			t.Fatal("did not receive event")
+		}
+	}
+
+	// The slow reader has the most recent events.
+	require.Len(t, stuckCh, adProcessedEventBuffer)
+	var last adProcessedEvent
+	for len(stuckCh) != 0 {
+		last = <-stuckCh
+	}
+	require.Equal(t, cids[eventCount-1], last.adCid)
+}
+
+func TestSyncResultNotDropped(t *testing.T) {
+	ing := &Ingester{
+		inEvents: make(chan adProcessedEvent),
+	}
+	go ing.distributeEvents()
+	defer close(ing.inEvents)
+
+	pubID, err := peer.Decode("12D3KooWKRyzVWW6ChFjQjK4miCty85Niy48tpPV95XdKu1BcvMA")
+	require.NoError(t, err)
+
+	const eventCount = 3 * adProcessedEventBuffer
+	var cids []cid.Cid
+	for _, mh := range util.RandomMultihashes(eventCount, rng) {
+		cids = append(cids, cid.NewCidV1(cid.Raw, mh))
+	}
+	sendEvents := func(events ...adProcessedEvent) {
+		for _, event := range events {
+			select {
+			case ing.inEvents <- event:
+			case <-time.After(time.Second):
+				t.Fatal("distribution of events blocked")
+			}
+		}
+	}
+	requireDone := func(result *syncResult, expect bool) {
+		// Distribute one more event so that all earlier events are handled.
+		sendEvents(adProcessedEvent{publisher: pubID})
+		select {
+		case <-result.done:
+			require.True(t, expect, "sync done early")
+		default:
+			require.False(t, expect, "sync not done")
+		}
+	}
+
+	// The progress events of a Sync call that does not keep up are dropped,
+	// but its result is not, even if the head finishes in the middle of the
+	// events.
+	_, progressCancel := ing.onAdProcessed(pubID)
+	defer progressCancel()
+	result, cancel := ing.awaitSyncResult(pubID)
+	defer cancel()
+	head := cids[eventCount/2]
+	result.setHead(head)
+	for _, c := range cids {
+		sendEvents(adProcessedEvent{publisher: pubID, headAdCid: head, adCid: c})
+	}
+	requireDone(result, true)
+
+	// The head can finish before the Sync call knows which ad is the head.
+	result, cancel = ing.awaitSyncResult(pubID)
+	defer cancel()
+	sendEvents(adProcessedEvent{publisher: pubID, headAdCid: cids[1], adCid: cids[1]})
+	result.setHead(cids[1])
+	requireDone(result, true)
+
+	// A failure of the chain that ends at the head ends the sync, and a
+	// failure of another chain does not.
+	result, cancel = ing.awaitSyncResult(pubID)
+	defer cancel()
+	result.setHead(cids[2])
+	sendEvents(adProcessedEvent{publisher: pubID, headAdCid: cids[3], adCid: cids[0], err: errWorkCanceled})
+	requireDone(result, false)
+	sendEvents(adProcessedEvent{publisher: pubID, headAdCid: cids[2], adCid: cids[0], err: errWorkCanceled})
+	requireDone(result, true)
+}
+
+func TestRmWithNoEntries(t *testing.T) {
+	te := setupTestEnv(t, true)
+	cw := &coreWrap{
+		Interface: te.ingester.indexer,
+	}
+	te.ingester.indexer = cw
+
+	chainHead := typehelpers.RandomAdBuilder{
+		EntryBuilders: []typehelpers.EntryBuilder{
+			typehelpers.RandomEntryChunkBuilder{ChunkCount: 1, EntriesPerChunk: 1, Seed: 1},
+			typehelpers.RandomEntryChunkBuilder{ChunkCount: 1, EntriesPerChunk: 1, Seed: 2},
+			typehelpers.RandomHamtEntryBuilder{MultihashCount: 1, Seed: 3},
+		},
+		AddRmWithNoEntries: true,
+	}.Build(t, te.publisherLinkSys, te.publisherPriv)
+
+	adNode, err := te.publisherLinkSys.Load(linking.LinkContext{}, chainHead, schema.AdvertisementPrototype)
+	require.NoError(t, err)
+	ad, err := schema.UnwrapAdvertisement(adNode)
+	require.NoError(t, err)
+
+	require.NotNil(t, ad.PreviousID)
+	prevAdNode, err := te.publisherLinkSys.Load(linking.LinkContext{}, ad.PreviousID, schema.AdvertisementPrototype)
+	require.NoError(t, err)
+	prevAd, err := schema.UnwrapAdvertisement(prevAdNode)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	err = te.publisher.UpdateRoot(context.Background(), chainHead.(cidlink.Link).Cid)
+	require.NoError(t, err)
+
+	wait, err := te.ingester.Sync(ctx, te.pubHost.ID(), nil, 0, false)
+	require.NoError(t, err)
+	<-wait
+	var lcid cid.Cid
+	requireTrueEventually(t, func() bool {
+		lcid, err = te.ingester.GetLatestSync(te.pubHost.ID())
+		require.NoError(t, err)
+		return chainHead.(cidlink.Link).Cid == lcid
+	}, testRetryInterval, testRetryTimeout, "Expected %s but got %s", chainHead, lcid)
+
+	allMhs := typehelpers.AllMultihashesFromAdChain(t, prevAd, te.publisherLinkSys)
+
+	first := allMhs[0]
+
+	// Remove the mhs from the first ad (since the last add removed this from the indexer)
+	allMhs = allMhs[1:]
+	requireIndexedEventually(t, te.ingester.indexer, te.pubHost.ID(), allMhs)
+
+	// Check that first multihash was never ingested in the first place,
+	// indicating it was skipped.
+	var found bool
+	for _, mh := range cw.mhs {
+		if bytes.Equal(mh, first) {
+			found = true
+		}
+	}
+	require.False(t, found)
+}
+
+func TestSkipAlreadyProcessedEntryChunk(t *testing.T) {
+	te := setupTestEnv(t, true)
+	defer te.Close(t)
+	cw := &coreWrap{
+		Interface: te.ingester.indexer,
+	}
+	te.ingester.indexer = cw
+
+	adLink := typehelpers.RandomAdBuilder{
+		EntryBuilders: []typehelpers.EntryBuilder{
+			typehelpers.RandomEntryChunkBuilder{ChunkCount: 1, EntriesPerChunk: 5, Seed: 1},
+		},
+	}.Build(t, te.publisherLinkSys, te.publisherPriv)
+
+	adNode, err := te.publisherLinkSys.Load(linking.LinkContext{}, adLink, schema.AdvertisementPrototype)
+	require.NoError(t, err)
+	ad, err := schema.UnwrapAdvertisement(adNode)
+	require.NoError(t, err)
+	chunkNode, err := te.publisherLinkSys.Load(linking.LinkContext{}, ad.Entries, schema.EntryChunkPrototype)
+	require.NoError(t, err)
+	chunk, err := schema.UnwrapEntryChunk(chunkNode)
+	require.NoError(t, err)
+	chunkCid := ad.Entries.(cidlink.Link).Cid
+
+	ctx := context.Background()
+	err = te.ingester.ingestEntryChunk(ctx, *ad, chunkCid, *chunk, log.With())
+	require.NoError(t, err)
+	require.Len(t, cw.mhs, len(chunk.Entries))
+
+	// Ingesting the same chunk with the same value again should not index the
+	// multihashes again.
+	err = te.ingester.ingestEntryChunk(ctx, *ad, chunkCid, *chunk, log.With())
+	require.NoError(t, err)
+	require.Len(t, cw.mhs, len(chunk.Entries))
+
+	// The marker is kept after the ad is processed, so that a later ad that
+	// links to the chunk does not index it again.
+	providerID, err := peer.Decode(ad.Provider)
+	require.NoError(t, err)
+	value := indexer.Value{ProviderID: providerID, ContextID: ad.ContextID, MetadataBytes: ad.Metadata}
+	require.NoError(t, te.ingester.markAdProcessed(te.pubHost.ID(), adLink.(cidlink.Link).Cid, false))
+	has, err := te.ingester.ds.Has(ctx, chunkMarkerKey(value, chunkCid))
+	require.NoError(t, err)
+	require.True(t, has)
+
+	// After the context is removed, the chunk must be indexed again.
+	err = te.ingester.removeProviderContext(ctx, providerID, ad.ContextID)
+	require.NoError(t, err)
+	has, err = te.ingester.ds.Has(ctx, chunkMarkerKey(value, chunkCid))
+	require.NoError(t, err)
+	require.False(t, has)
+	err = te.ingester.ingestEntryChunk(ctx, *ad, chunkCid, *chunk, log.With())
+	require.NoError(t, err)
+	require.Len(t, cw.mhs, 2*len(chunk.Entries))
+	requireIndexedEventually(t, cw, providerID, chunk.Entries)
+
+	// An expired marker does not skip the chunk, and is removed.
+	te.ingester.cfg.EntryChunkMarkerTTL = config.Duration(time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	err = te.ingester.ingestEntryChunk(ctx, *ad, chunkCid, *chunk, log.With())
+	require.NoError(t, err)
+	require.Len(t, cw.mhs, 3*len(chunk.Entries))
+	time.Sleep(10 * time.Millisecond)
+	count, err := te.ingester.removeChunkMarkers(ctx, chunkProcessedPrefix, true)
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+}
+
+func TestChunkMarkerPrefix(t *testing.T) {
+	providerID, err := test.RandPeerID()
+	require.NoError(t, err)
+	c := cid.NewCidV1(cid.Raw, util.RandomMultihashes(1, rng)[0])
+
+	// The prefix of one context must not match the keys of another context
+	// whose ID starts with the same bytes.
+	key := chunkMarkerKey(indexer.Value{ProviderID: providerID, ContextID: []byte("context-10")}, c)
+	require.False(t, strings.HasPrefix(key.String(), chunkMarkerPrefix(providerID, []byte("context-1"))))
+	require.True(t, strings.HasPrefix(key.String(), chunkMarkerPrefix(providerID, []byte("context-10"))))
+	require.True(t, strings.HasPrefix(key.String(), chunkMarkerPrefix(providerID, nil)))
+}
+
+func TestSkipPresentEntries(t *testing.T) {
+	cfg := defaultTestIngestConfig
+	cfg.SkipPresentEntries = true
+	te := setupTestEnv(t, true, func(teo *testEnvOpts) {
+		teo.ingestConfig = &cfg
+	})
+	defer te.Close(t)
+	cw := &coreWrap{
+		Interface: te.ingester.indexer,
+	}
+	te.ingester.indexer = cw
+
+	entries, mhs := newRandomLinkedList(t, te.publisherLinkSys, 1)
+	ad := schema.Advertisement{
+		Provider:  te.pubHost.ID().String(),
+		Addresses: []string{"/ip4/127.0.0.1/tcp/9999"},
+		Entries:   entries,
+		ContextID: []byte("test-context"),
+		Metadata:  []byte("test-metadata"),
+	}
+	chunk := schema.EntryChunk{Entries: mhs}
+	ctx := context.Background()
+	err := te.ingester.ingestEntryChunk(ctx, ad, entries.(cidlink.Link).Cid, chunk, log.With())
+	require.NoError(t, err)
+	require.Len(t, cw.mhs, len(mhs))
+
+	// A different chunk with multihashes that are all indexed is skipped.
+	otherCid := cid.NewCidV1(cid.Raw, util.RandomMultihashes(1, rng)[0])
+	err = te.ingester.ingestEntryChunk(ctx, ad, otherCid, chunk, log.With())
+	require.NoError(t, err)
+	require.Len(t, cw.mhs, len(mhs))
+
+	// A chunk with any multihash that is not indexed is stored.
+	chunk.Entries = append(chunk.Entries, util.RandomMultihashes(1, rng)...)
+	otherCid = cid.NewCidV1(cid.Raw, util.RandomMultihashes(1, rng)[0])
+	err = te.ingester.ingestEntryChunk(ctx, ad, otherCid, chunk, log.With())
+	require.NoError(t, err)
+	require.Len(t, cw.mhs, 2*len(mhs)+1)
+
+	// Changed metadata is not present, so the chunk is stored.
+	ad.Metadata = []byte("new-metadata")
+	otherCid = cid.NewCidV1(cid.Raw, util.RandomMultihashes(1, rng)[0])
+	err = te.ingester.ingestEntryChunk(ctx, ad, otherCid, chunk, log.With())
+	require.NoError(t, err)
+	require.Len(t, cw.mhs, 3*len(mhs)+2)
+}
+
+// slowPutCore adds a delay to each Put to simulate a busy value store.
+type slowPutCore struct {
+	indexer.Interface
+	delay time.Duration
+}
+
+func (c *slowPutCore) Put(value indexer.Value, mhs ...multihash.Multihash) error {
+	time.Sleep(c.delay)
+	return c.Interface.Put(value, mhs...)
+}
+
+func TestEntriesFetchAhead(t *testing.T) {
+	const chunkCount = 100
+	const delay = time.Millisecond
+
+	// Simulate the latency of fetching each block from the publisher.
+	slowLinkSys := func(teo *testEnvOpts) {
+		teo.publisherLinkSysFn = func(ds datastore.Batching) ipld.LinkSystem {
+			lsys := mkProvLinkSystem(ds)
+			readOpener := lsys.StorageReadOpener
+			lsys.StorageReadOpener = func(lctx ipld.LinkContext, lnk ipld.Link) (io.Reader, error) {
+				time.Sleep(delay)
+				return readOpener(lctx, lnk)
+			}
+			return lsys
+		}
+	}
+
+	var elapsed [2]time.Duration
+	for i, fetchAhead := range []int{-1, 16} {
+		cfg := defaultTestIngestConfig
+		cfg.EntriesFetchAhead = fetchAhead
+		cfg.EntriesDepthLimit = chunkCount
+		cfg.RateLimit = config.RateLimit{}
+		te := setupTestEnv(t, true, slowLinkSys, func(teo *testEnvOpts) {
+			teo.ingestConfig = &cfg
+		})
+		te.ingester.indexer = &slowPutCore{
+			Interface: te.ingester.indexer,
+			delay:     delay,
+		}
+
+		adCid, mhs, providerID := publishRandomIndexAndAdvWithEntriesChunkCount(t, te.publisher, te.publisherLinkSys, false, chunkCount)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		start := time.Now()
+		end, err := te.ingester.Sync(ctx, te.pubHost.ID(), nil, 0, false)
+		require.NoError(t, err)
+		select {
+		case endCid := <-end:
+			require.Equal(t, adCid, endCid)
+		case <-ctx.Done():
+			t.Fatal("sync timeout")
+		}
+		elapsed[i] = time.Since(start)
+		cancel()
+
+		require.NoError(t, checkAllIndexed(te.core, providerID, mhs))
+		contexts := te.reg.ProviderContexts(providerID)
+		require.Len(t, contexts, 1)
+		require.Equal(t, uint64(len(mhs)), contexts[0].EntryCount)
+	}
+	t.Logf("Ingested %d entry chunks in %s without fetch-ahead and in %s with fetch-ahead", chunkCount, elapsed[0], elapsed[1])
+}
+
+func TestResumeInterruptedAd(t *testing.T) {
+	te := setupTestEnv(t, true)
+	defer te.Close(t)
+	pubID := te.pubHost.ID()
+	ctx := context.Background()
+
+	// The chunks are linked in the reverse order of their multihashes:
+	// head -> middle -> tail.
+	entries, mhs := newRandomLinkedList(t, te.publisherLinkSys, 3)
+	tailMhs := mhs[:testEntriesChunkSize]
+	middleMhs := mhs[testEntriesChunkSize : 2*testEntriesChunkSize]
+	headMhs := mhs[2*testEntriesChunkSize:]
+	nextChunk := func(lnk ipld.Link) ipld.Link {
+		n, err := te.publisherLinkSys.Load(linking.LinkContext{}, lnk, schema.EntryChunkPrototype)
+		require.NoError(t, err)
+		chunk, err := schema.UnwrapEntryChunk(n)
+		require.NoError(t, err)
+		return chunk.Next
+	}
+	tail := nextChunk(nextChunk(entries))
+
+	// Record that indexing was interrupted after the middle chunk.
+	ad := storeTestAd(t, te, nil, entries, []byte("test-context"), false)
+	adCid := ad.(cidlink.Link).Cid
+	err := te.ingester.saveAdCheckpoint(ctx, adCid, tail.(cidlink.Link).Cid, uint64(len(headMhs)+len(middleMhs)))
+	require.NoError(t, err)
+	_, _, ok := te.ingester.adCheckpoint(ctx, adCid)
+	require.True(t, ok)
+
+	syncTestAd(t, te, ad)
+
+	// The middle chunk is not indexed again, since the checkpoint shows it
+	// was already indexed.
+	requireIndexedEventually(t, te.core, pubID, headMhs)
+	requireIndexedEventually(t, te.core, pubID, tailMhs)
+	requireNotIndexed(t, te.core, pubID, middleMhs)
+
+	contexts := te.reg.ProviderContexts(pubID)
+	require.Len(t, contexts, 1)
+	require.Equal(t, uint64(len(mhs)), contexts[0].EntryCount)
+
+	// The checkpoint is removed once the ad is processed.
+	_, _, ok = te.ingester.adCheckpoint(ctx, adCid)
+	require.False(t, ok)
+}
+
+func TestWriteAheadLogReplay(t *testing.T) {
+	cfg := defaultTestIngestConfig
+	cfg.WriteAheadLog = true
+	te := setupTestEnv(t, true, func(teo *testEnvOpts) {
+		teo.ingestConfig = &cfg
+		teo.skipIngesterCleanup = true
+	})
+	pubID := te.pubHost.ID()
+	ctx := context.Background()
+
+	entries1, mhs1 := newRandomLinkedList(t, te.publisherLinkSys, 1)
+	entries2, mhs2 := newRandomLinkedList(t, te.publisherLinkSys, 1)
+	ad1 := storeTestAd(t, te, nil, entries1, []byte("context-1"), false)
+	ad2 := storeTestAd(t, te, ad1, entries2, []byte("context-2"), false)
+	headCid := ad2.(cidlink.Link).Cid
+
+	// Simulate the indexer stopping after syncing both ads, but before
+	// processing them. The ads are held in the datastore, and the chain is
+	// logged.
+	for _, ad := range []ipld.Link{ad2, ad1} {
+		key := datastore.NewKey(ad.(cidlink.Link).Cid.String())
+		block, err := te.pubStore.Get(ctx, key)
+		require.NoError(t, err)
+		require.NoError(t, te.ingester.ds.Put(ctx, key, block))
+	}
+	require.NoError(t, te.ingester.addWAL(ctx, pubID, headCid))
+	te.ingester.Close()
+	te.ingester.host.Close()
+	requireNotIndexed(t, te.core, pubID, mhs1)
+
+	// The logged chain is processed when the indexer starts again.
+	ingesterHost := mkTestHost(libp2p.Identity(te.ingesterPriv))
+	connectHosts(t, te.pubHost, ingesterHost)
+	ingester, err := NewIngester(cfg, ingesterHost, te.ingester.indexer, mkRegistry(t), te.ingester.ds)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		ingester.Close()
+	})
+
+	requireIndexedEventually(t, ingester.indexer, pubID, mhs1)
+	requireIndexedEventually(t, ingester.indexer, pubID, mhs2)
+	require.True(t, ingester.adAlreadyProcessed(headCid))
+
+	// The log entry is removed once the chain is processed.
+	requireTrueEventually(t, func() bool {
+		_, err := ingester.ds.Get(ctx, walKey(pubID, headCid))
+		return err == datastore.ErrNotFound
+	}, testRetryInterval, testRetryTimeout, "write-ahead log entry not removed")
+	replay, err := ingester.readWAL(ctx)
+	require.NoError(t, err)
+	require.Empty(t, replay)
+}
+
+func TestReAddAfterRemove(t *testing.T) {
+	ctxA := []byte("context-a")
+	ctxB := []byte("context-b")
+
+	requireContexts := func(t *testing.T, ix indexer.Interface, mhs []multihash.Multihash, want []byte, notWant []byte) {
+		for _, mh := range mhs {
+			values, _, err := ix.Get(mh)
+			require.NoError(t, err)
+			var found bool
+			for _, v := range values {
+				require.NotEqual(t, notWant, v.ContextID, "multihash still indexed with removed context")
+				if bytes.Equal(v.ContextID, want) {
+					found = true
+				}
+			}
+			require.True(t, found, "multihash not indexed with context %s", want)
+		}
+	}
+
+	t.Run("add-remove-add same context", func(t *testing.T) {
+		te := setupTestEnv(t, true)
+		entries, mhs := newRandomLinkedList(t, te.publisherLinkSys, 2)
+
+		// Reusing the removed context ID is not allowed, so the last ad is
+		// skipped.
+		ad1 := storeTestAd(t, te, nil, entries, ctxA, false)
+		ad2 := storeTestAd(t, te, ad1, schema.NoEntries, ctxA, true)
+		ad3 := storeTestAd(t, te, ad2, entries, ctxA, false)
+		syncTestAd(t, te, ad3)
+
+		requireNotIndexed(t, te.core, te.pubHost.ID(), mhs)
+		require.Nil(t, te.ingester.reg.ProviderContext(te.pubHost.ID(), ctxA))
+	})
+
+	t.Run("add-remove-add same context allowed", func(t *testing.T) {
+		cfg := defaultTestIngestConfig
+		cfg.AllowRemovedContextReuse = true
+		te := setupTestEnv(t, true, func(teo *testEnvOpts) {
+			teo.ingestConfig = &cfg
+		})
+		entries, mhs := newRandomLinkedList(t, te.publisherLinkSys, 2)
+
+		ad1 := storeTestAd(t, te, nil, entries, ctxA, false)
+		ad2 := storeTestAd(t, te, ad1, schema.NoEntries, ctxA, true)
+		ad3 := storeTestAd(t, te, ad2, entries, ctxA, false)
+		syncTestAd(t, te, ad3)
+
+		requireContexts(t, te.core, mhs, ctxA, nil)
+	})
+
+	t.Run("add-remove-add other context", func(t *testing.T) {
+		te := setupTestEnv(t, true)
+		entries, mhs := newRandomLinkedList(t, te.publisherLinkSys, 2)
+
+		ad1 := storeTestAd(t, te, nil, entries, ctxA, false)
+		ad2 := storeTestAd(t, te, ad1, schema.NoEntries, ctxA, true)
+		ad3 := storeTestAd(t, te, ad2, entries, ctxB, false)
+		syncTestAd(t, te, ad3)
+
+		requireContexts(t, te.core, mhs, ctxB, ctxA)
+	})
+
+	t.Run("add-remove content-add", func(t *testing.T) {
+		te := setupTestEnv(t, true)
+		entries, mhs := newRandomLinkedList(t, te.publisherLinkSys, 2)
+
+		ad1 := storeTestAd(t, te, nil, entries, ctxA, false)
+		syncTestAd(t, te, ad1)
+		requireContexts(t, te.core, mhs, ctxA, nil)
+
+		value := indexer.Value{
+			ProviderID: te.pubHost.ID(),
+			ContextID:  ctxA,
+		}
+		err := te.ingester.RemoveContent(context.Background(), value, mhs[0])
+		require.NoError(t, err)
+		requireNotIndexed(t, te.core, te.pubHost.ID(), mhs[:1])
+
+		// Re-advertising the same entries must index the removed multihash
+		// again, instead of skipping the entries as already processed.
+		ad2 := storeTestAd(t, te, ad1, entries, ctxA, false)
+		syncTestAd(t, te, ad2)
+		requireContexts(t, te.core, mhs, ctxA, nil)
+	})
+}
+
+func TestSyncStates(t *testing.T) {
+	te := setupTestEnv(t, true)
+	ctx := context.Background()
+
+	states, err := te.ingester.SyncStates(ctx)
+	require.NoError(t, err)
+	require.Empty(t, states)
+
+	entries, _ := newRandomLinkedList(t, te.publisherLinkSys, 1)
+	ad1 := storeTestAd(t, te, nil, entries, []byte("context-a"), false)
+	syncTestAd(t, te, ad1)
+	adCid := ad1.(cidlink.Link).Cid
+
+	requireTrueEventually(t, func() bool {
+		states, err = te.ingester.SyncStates(ctx)
+		require.NoError(t, err)
+		return len(states) == 1 && states[0].PersistedSync == adCid
+	}, testRetryInterval, testRetryTimeout, "Expected persisted sync to be recorded")
+	require.Equal(t, te.pubHost.ID(), states[0].PeerID)
+	require.Equal(t, adCid, states[0].SubscriberSync)
+	require.False(t, states[0].Mismatch)
+
+	// Simulate the subscriber having a different latest sync.
+	entries, _ = newRandomLinkedList(t, te.publisherLinkSys, 1)
+	ad2 := storeTestAd(t, te, ad1, entries, []byte("context-a"), false)
+	te.ingester.syncHandler.SetLatestSync(te.pubHost.ID(), ad2.(cidlink.Link).Cid)
+
+	states, err = te.ingester.SyncStates(ctx)
+	require.NoError(t, err)
+	require.Len(t, states, 1)
+	require.Equal(t, ad2.(cidlink.Link).Cid, states[0].SubscriberSync)
+	require.Equal(t, adCid, states[0].PersistedSync)
+	require.True(t, states[0].Mismatch)
+}
+
+func TestClearLatestSync(t *testing.T) {
+	te := setupTestEnv(t, true)
+	ctx := context.Background()
+	pubID := te.pubHost.ID()
+
+	entries, _ := newRandomLinkedList(t, te.publisherLinkSys, 1)
+	ad1 := storeTestAd(t, te, nil, entries, []byte("context-a"), false)
+	syncTestAd(t, te, ad1)
+	adCid := ad1.(cidlink.Link).Cid
+
+	requireTrueEventually(t, func() bool {
+		latest, err := te.ingester.GetLatestSync(pubID)
+		require.NoError(t, err)
+		return latest == adCid
+	}, testRetryInterval, testRetryTimeout, "Expected latest sync to be recorded")
+
+	err := te.ingester.ClearLatestSync(ctx, pubID)
+	require.NoError(t, err)
+
+	latest, err := te.ingester.GetLatestSync(pubID)
+	require.NoError(t, err)
+	require.Equal(t, cid.Undef, latest)
+	states, err := te.ingester.SyncStates(ctx)
+	require.NoError(t, err)
+	require.Empty(t, states)
+
+	// Syncing again records the latest sync.
+	entries, mhs := newRandomLinkedList(t, te.publisherLinkSys, 1)
+	ad2 := storeTestAd(t, te, ad1, entries, []byte("context-b"), false)
+	syncTestAd(t, te, ad2)
+	requireIndexedEventually(t, te.core, pubID, mhs)
+	latest, err = te.ingester.GetLatestSync(pubID)
+	require.NoError(t, err)
+	require.Equal(t, ad2.(cidlink.Link).Cid, latest)
+}
+
+func TestReadWriteLatestSyncs(t *testing.T) {
+	ctx := context.Background()
+	pubIDs := make([]peer.ID, 2)
+	for i := range pubIDs {
+		var err error
+		pubIDs[i], err = test.RandPeerID()
+		require.NoError(t, err)
+	}
+	mhs := util.RandomMultihashes(2, rng)
+	latestSyncs := map[peer.ID]cid.Cid{
+		pubIDs[0]: cid.NewCidV1(cid.Raw, mhs[0]),
+		pubIDs[1]: cid.NewCidV1(cid.Raw, mhs[1]),
+	}
+
+	ds := dssync.MutexWrap(datastore.NewMapDatastore())
+	count, err := WriteLatestSyncs(ctx, ds, latestSyncs, false)
+	require.NoError(t, err)
+	require.Equal(t, 2, count)
+
+	read, err := ReadLatestSyncs(ctx, ds)
+	require.NoError(t, err)
+	require.Equal(t, latestSyncs, read)
+
+	// Existing latest syncs are only replaced when overwriting.
+	newCid := cid.NewCidV1(cid.Raw, util.RandomMultihashes(1, rng)[0])
+	count, err = WriteLatestSyncs(ctx, ds, map[peer.ID]cid.Cid{pubIDs[0]: newCid}, false)
+	require.NoError(t, err)
+	require.Zero(t, count)
+	count, err = WriteLatestSyncs(ctx, ds, map[peer.ID]cid.Cid{pubIDs[0]: newCid}, true)
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+
+	read, err = ReadLatestSyncs(ctx, ds)
+	require.NoError(t, err)
+	require.Equal(t, newCid, read[pubIDs[0]])
+}
+
+func TestProviderAdRateLimit(t *testing.T) {
+	const adCount = 4
+
+	cfg := defaultTestIngestConfig
+	// Allow one ad every 100ms.
+	cfg.ProviderAdsPerMinute = 600
+	te := setupTestEnv(t, true, func(teo *testEnvOpts) {
+		teo.ingestConfig = &cfg
+	})
+
+	var prev ipld.Link
+	var allMhs []multihash.Multihash
+	for i := 0; i < adCount; i++ {
+		entries, mhs := newRandomLinkedList(t, te.publisherLinkSys, 1)
+		prev = storeTestAd(t, te, prev, entries, []byte(fmt.Sprint("context-", i)), false)
+		allMhs = append(allMhs, mhs...)
+	}
+
+	start := time.Now()
+	syncTestAd(t, te, prev)
+	elapsed := time.Since(start)
+
+	// All ads are ingested, with each ad after the first waiting for the
+	// rate limit.
+	require.NoError(t, checkAllIndexed(te.core, te.pubHost.ID(), allMhs))
+	require.Len(t, te.reg.ProviderContexts(te.pubHost.ID()), adCount)
+	require.GreaterOrEqual(t, elapsed, (adCount-1)*90*time.Millisecond)
+}
+
+func TestMaxAdsPerSync(t *testing.T) {
+	cfg := defaultTestIngestConfig
+	cfg.MaxAdsPerSync = 2
+	te := setupTestEnv(t, true, func(teo *testEnvOpts) {
+		teo.ingestConfig = &cfg
+	})
+	// Stop the workers, so that the test can run each worker pass itself.
+	te.ingester.RunWorkers(0)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	provID := te.pubHost.ID()
+
+	var prev ipld.Link
+	var adMhs [][]multihash.Multihash
+	addAds := func(count int) {
+		for i := 0; i < count; i++ {
+			entries, mhs := newRandomLinkedList(t, te.publisherLinkSys, 1)
+			prev = storeTestAd(t, te, prev, entries, []byte(fmt.Sprint("context-", len(adMhs))), false)
+			adMhs = append(adMhs, mhs)
+		}
+	}
+	// syncAndIngest syncs the latest ad and runs the one worker pass that the
+	// sync schedules, and checks that the sync is done afterwards.
+	syncAndIngest := func() {
+		headCid := prev.(cidlink.Link).Cid
+		err := te.publisher.SetRoot(ctx, headCid)
+		require.NoError(t, err)
+		end, err := te.ingester.Sync(ctx, provID, nil, 0, false)
+		require.NoError(t, err)
+		select {
+		case p := <-te.ingester.toWorkers:
+			atomic.AddInt32(&te.ingester.pendingWork, -1)
+			te.ingester.ingestWorkerLogic(peer.ID(p))
+		case <-ctx.Done():
+			t.Fatal("timed out waiting for work")
+		}
+		select {
+		case endCid := <-end:
+			require.Equal(t, headCid, endCid)
+		case <-ctx.Done():
+			t.Fatal("sync timeout")
+		}
+	}
+	requireIngested := func(count int) {
+		for i := 0; i < count; i++ {
+			require.NoError(t, checkAllIndexed(te.core, provID, adMhs[i]))
+		}
+		for i := count; i < len(adMhs); i++ {
+			requireNotIndexed(t, te.core, provID, adMhs[i])
+		}
+	}
+	requireNoWork := func() {
+		select {
+		case <-te.ingester.toWorkers:
+			t.Fatal("remaining ads scheduled before next sync")
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	// The first sync ingests only MaxAdsPerSync ads, oldest first, and the
+	// remaining ad waits for the next sync.
+	addAds(3)
+	syncAndIngest()
+	requireIngested(2)
+	requireNoWork()
+	requireIngested(2)
+
+	// The next sync ingests the remaining ad before the newly synced ones,
+	// and again stops at MaxAdsPerSync.
+	addAds(2)
+	syncAndIngest()
+	requireIngested(4)
+	requireNoWork()
+
+	addAds(1)
+	syncAndIngest()
+	requireIngested(6)
+	requireNoWork()
+}
+
+func TestCancelQueuedWork(t *testing.T) {
+	const adCount = 3
+
+	te := setupTestEnv(t, true)
+	// Stop the workers, so that the work stays queued until the test runs it.
+	te.ingester.RunWorkers(0)
+
+	var prev ipld.Link
+	var allMhs []multihash.Multihash
+	for i := 0; i < adCount; i++ {
+		entries, mhs := newRandomLinkedList(t, te.publisherLinkSys, 1)
+		prev = storeTestAd(t, te, prev, entries, []byte(fmt.Sprint("context-", i)), false)
+		allMhs = append(allMhs, mhs...)
+	}
+	headCid := prev.(cidlink.Link).Cid
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err := te.publisher.SetRoot(ctx, headCid)
+	require.NoError(t, err)
+	end, err := te.ingester.Sync(ctx, te.pubHost.ID(), nil, 0, false)
+	require.NoError(t, err)
+
+	var p providerID
+	select {
+	case p = <-te.ingester.toWorkers:
+		atomic.AddInt32(&te.ingester.pendingWork, -1)
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for work")
+	}
+
+	// Canceling the publisher's work drops all of its queued ads, and ends
+	// the sync that is waiting for them.
+	require.Equal(t, adCount, te.ingester.CancelQueuedWork(te.pubHost.ID()))
+	select {
+	case endCid := <-end:
+		require.Equal(t, headCid, endCid)
+	case <-ctx.Done():
+		t.Fatal("sync timeout")
+	}
+
+	// The worker scheduled for the provider has nothing to do.
+	te.ingester.ingestWorkerLogic(peer.ID(p))
+	requireNotIndexed(t, te.core, te.pubHost.ID(), allMhs)
+	require.False(t, te.ingester.adAlreadyProcessed(headCid))
+	require.Zero(t, te.ingester.CancelQueuedWork(te.pubHost.ID()))
 }
 
-func TestRmWithNoEntries(t *testing.T) {
+func TestAdStatus(t *testing.T) {
 	te := setupTestEnv(t, true)
-	cw := &coreWrap{
-		Interface: te.ingester.indexer,
+	ctx := context.Background()
+
+	requireStatus := func(adCid cid.Cid, expect string) ingestmodel.AdStatus {
+		status, err := te.ingester.AdStatus(ctx, adCid)
+		require.NoError(t, err)
+		require.Equal(t, adCid, status.AdCid)
+		require.Equal(t, expect, status.Status)
+		return status
 	}
-	te.ingester.indexer = cw
 
-	chainHead := typehelpers.RandomAdBuilder{
-		EntryBuilders: []typehelpers.EntryBuilder{
-			typehelpers.RandomEntryChunkBuilder{ChunkCount: 1, EntriesPerChunk: 1, Seed: 1},
-			typehelpers.RandomEntryChunkBuilder{ChunkCount: 1, EntriesPerChunk: 1, Seed: 2},
-			typehelpers.RandomHamtEntryBuilder{MultihashCount: 1, Seed: 3},
-		},
-		AddRmWithNoEntries: true,
-	}.Build(t, te.publisherLinkSys, te.publisherPriv)
+	entries, mhs := newRandomLinkedList(t, te.publisherLinkSys, 1)
+	ad1 := storeTestAd(t, te, nil, entries, []byte("context-1"), false)
+	ad1Cid := ad1.(cidlink.Link).Cid
+	requireStatus(ad1Cid, ingestmodel.AdStatusUnknown)
+
+	syncTestAd(t, te, ad1)
+	requireIndexedEventually(t, te.core, te.pubHost.ID(), mhs)
+	requireStatus(ad1Cid, ingestmodel.AdStatusProcessed)
+
+	// An advertisement for a blocked provider is skipped, and reported as
+	// failed.
+	priv, _, err := crypto.GenerateEd25519Key(nil)
+	require.NoError(t, err)
+	blockedID, err := peer.IDFromPrivateKey(priv)
+	require.NoError(t, err)
+	te.reg.BlockPeer(blockedID)
+	entries, _ = newRandomLinkedList(t, te.publisherLinkSys, 1)
+	ad2 := storeProviderTestAd(t, te, ad1, blockedID, entries, []byte("context-2"), []byte("test-metadata"), false)
+	syncTestAd(t, te, ad2)
+	status := requireStatus(ad2.(cidlink.Link).Cid, ingestmodel.AdStatusFailed)
+	require.NotEmpty(t, status.Err)
+
+	// A synced advertisement that is not yet processed is syncing.
+	entries, _ = newRandomLinkedList(t, te.publisherLinkSys, 1)
+	ad3 := storeTestAd(t, te, ad2, entries, []byte("context-3"), false)
+	ad3Cid := ad3.(cidlink.Link).Cid
+	key := datastore.NewKey(ad3Cid.String())
+	block, err := te.pubStore.Get(ctx, key)
+	require.NoError(t, err)
+	require.NoError(t, te.ingester.ds.Put(ctx, key, block))
+	requireStatus(ad3Cid, ingestmodel.AdStatusSyncing)
+}
 
-	adNode, err := te.publisherLinkSys.Load(linking.LinkContext{}, chainHead, schema.AdvertisementPrototype)
+func TestAdCacheConcurrentLoad(t *testing.T) {
+	cfg := defaultTestIngestConfig
+	cfg.AdCacheSize = 2
+	te := setupTestEnv(t, true, func(teo *testEnvOpts) {
+		teo.ingestConfig = &cfg
+	})
+	ctx := context.Background()
+
+	// Hold more ads in the ingester's datastore than the cache can hold.
+	var adCids []cid.Cid
+	var prev ipld.Link
+	for i := 0; i < 5; i++ {
+		entries, _ := newRandomLinkedList(t, te.publisherLinkSys, 1)
+		prev = storeTestAd(t, te, prev, entries, []byte(fmt.Sprint("context-", i)), false)
+		adCid := prev.(cidlink.Link).Cid
+		key := datastore.NewKey(adCid.String())
+		block, err := te.pubStore.Get(ctx, key)
+		require.NoError(t, err)
+		require.NoError(t, te.ingester.ds.Put(ctx, key, block))
+		adCids = append(adCids, adCid)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for n := 0; n < 20; n++ {
+				i := (g + n) % len(adCids)
+				ad, err := te.ingester.loadAd(adCids[i])
+				if err != nil {
+					errs <- err
+					return
+				}
+				if string(ad.ContextID) != fmt.Sprint("context-", i) {
+					errs <- fmt.Errorf("loaded wrong ad for %s: context %q", adCids[i], ad.ContextID)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+	require.LessOrEqual(t, te.ingester.adCache.len(), cfg.AdCacheSize)
+
+	// A processed ad is removed from the cache along with its block.
+	_, err := te.ingester.loadAd(adCids[0])
 	require.NoError(t, err)
-	ad, err := schema.UnwrapAdvertisement(adNode)
+	require.NoError(t, te.ingester.markAdProcessed(te.pubHost.ID(), adCids[0], true))
+	_, err = te.ingester.loadAd(adCids[0])
+	require.Error(t, err)
+}
+
+func TestStrictMultihashValidation(t *testing.T) {
+	cfg := defaultTestIngestConfig
+	cfg.StrictMultihashValidation = true
+	te := setupTestEnv(t, true, func(teo *testEnvOpts) {
+		teo.ingestConfig = &cfg
+	})
+
+	valid := util.RandomMultihashes(10, rng)
+	// A sha2-256 digest that is longer than sha2-256 produces.
+	tooLong, err := multihash.Encode(make([]byte, 40), multihash.SHA2_256)
+	require.NoError(t, err)
+	// A digest from an unknown hash function.
+	unknown, err := multihash.Encode(make([]byte, 32), 0x300000)
 	require.NoError(t, err)
 
-	require.NotNil(t, ad.PreviousID)
-	prevAdNode, err := te.publisherLinkSys.Load(linking.LinkContext{}, ad.PreviousID, schema.AdvertisementPrototype)
+	chunk := &schema.EntryChunk{
+		Entries: append([]multihash.Multihash{tooLong, unknown}, valid...),
+	}
+	node, err := chunk.ToNode()
 	require.NoError(t, err)
-	prevAd, err := schema.UnwrapAdvertisement(prevAdNode)
+	entries, err := te.publisherLinkSys.Store(ipld.LinkContext{}, schema.Linkproto, node)
 	require.NoError(t, err)
 
+	ad := storeTestAd(t, te, nil, entries, []byte("strict"), false)
+	syncTestAd(t, te, ad)
+
+	requireIndexedEventually(t, te.ingester.indexer, te.pubHost.ID(), valid)
+	for _, mh := range []multihash.Multihash{tooLong, unknown} {
+		_, found, err := te.ingester.indexer.Get(mh)
+		require.NoError(t, err)
+		require.False(t, found, "invalid multihash should not be indexed")
+	}
+	require.Equal(t, uint64(2), te.ingester.InvalidMultihashCount(te.pubHost.ID()))
+}
+
+func TestMaxEntriesPerChunk(t *testing.T) {
+	cfg := defaultTestIngestConfig
+	cfg.MaxEntriesPerChunk = testEntriesChunkSize
+	te := setupTestEnv(t, true, func(teo *testEnvOpts) {
+		teo.ingestConfig = &cfg
+	})
 	ctx := context.Background()
-	err = te.publisher.UpdateRoot(context.Background(), chainHead.(cidlink.Link).Cid)
+
+	storeChunk := func(size int, next ipld.Link) ipld.Link {
+		chunk := &schema.EntryChunk{
+			Entries: util.RandomMultihashes(size, rng),
+			Next:    next,
+		}
+		node, err := chunk.ToNode()
+		require.NoError(t, err)
+		lnk, err := te.publisherLinkSys.Store(ipld.LinkContext{}, schema.Linkproto, node)
+		require.NoError(t, err)
+		return lnk
+	}
+
+	// The first chunk is oversized.
+	firstOversized := storeChunk(testEntriesChunkSize+1, nil)
+	ad1 := storeTestAd(t, te, nil, firstOversized, []byte("first-oversized"), false)
+	// A later chunk is oversized.
+	laterOversized := storeChunk(testEntriesChunkSize+1, nil)
+	entries := storeChunk(testEntriesChunkSize, laterOversized)
+	ad2 := storeTestAd(t, te, ad1, entries, []byte("later-oversized"), false)
+	// Ads following the oversized ones are still ingested.
+	entries, mhs := newRandomLinkedList(t, te.publisherLinkSys, 2)
+	ad3 := storeTestAd(t, te, ad2, entries, []byte("within-limit"), false)
+	syncTestAd(t, te, ad3)
+
+	requireIndexedEventually(t, te.ingester.indexer, te.pubHost.ID(), mhs)
+	for _, ad := range []ipld.Link{ad1, ad2} {
+		status, err := te.ingester.AdStatus(ctx, ad.(cidlink.Link).Cid)
+		require.NoError(t, err)
+		require.Equal(t, ingestmodel.AdStatusFailed, status.Status)
+		require.Contains(t, status.Err, string(adIngestOversizedChunkErr))
+		// The oversized chunk is rejected while it is synced.
+		require.Contains(t, status.Err, "failed to sync")
+	}
+	// The oversized chunks are never stored.
+	for _, chunk := range []ipld.Link{firstOversized, laterOversized} {
+		has, err := te.ingester.ds.Has(ctx, datastore.NewKey(chunk.(cidlink.Link).Cid.String()))
+		require.NoError(t, err)
+		require.False(t, has)
+	}
+}
+
+func TestContextIDReuse(t *testing.T) {
+	te := setupTestEnv(t, true)
+	providerID := te.pubHost.ID()
+	contextID := []byte("reused-context")
+
+	var prev ipld.Link
+	storeAd := func(entries ipld.Link, contextID []byte, metadata string, isRm bool) ipld.Link {
+		prev = storeProviderTestAd(t, te, prev, providerID, entries, contextID, []byte(metadata), isRm)
+		return prev
+	}
+	requireMetadata := func(mhs []multihash.Multihash, metadata string) {
+		for _, mh := range mhs {
+			values, _, err := te.ingester.indexer.Get(mh)
+			require.NoError(t, err)
+			var found bool
+			for _, v := range values {
+				if v.ProviderID == providerID && bytes.Equal(v.ContextID, contextID) {
+					require.Equal(t, metadata, string(v.MetadataBytes))
+					found = true
+				}
+			}
+			require.True(t, found, "multihash not indexed for context")
+		}
+	}
+	requireEntryCount := func(count int) {
+		info := te.ingester.reg.ProviderContext(providerID, contextID)
+		require.NotNil(t, info)
+		require.Equal(t, uint64(count), info.EntryCount)
+	}
+
+	entries1, mhs1 := newRandomLinkedList(t, te.publisherLinkSys, 1)
+	otherEntries, otherMhs := newRandomLinkedList(t, te.publisherLinkSys, 1)
+	storeAd(entries1, contextID, "metadata-1", false)
+	syncTestAd(t, te, storeAd(otherEntries, []byte("other-context"), "metadata-1", false))
+	requireIndexedEventually(t, te.ingester.indexer, providerID, mhs1)
+	requireIndexedEventually(t, te.ingester.indexer, providerID, otherMhs)
+	requireEntryCount(len(mhs1))
+
+	// A later ad with the same context ID adds its entries to the context,
+	// and its metadata replaces the metadata of all of the context's
+	// multihashes.
+	entries2, mhs2 := newRandomLinkedList(t, te.publisherLinkSys, 1)
+	syncTestAd(t, te, storeAd(entries2, contextID, "metadata-2", false))
+	requireIndexedEventually(t, te.ingester.indexer, providerID, mhs2)
+	requireMetadata(mhs1, "metadata-2")
+	requireMetadata(mhs2, "metadata-2")
+	requireEntryCount(len(mhs1) + len(mhs2))
+
+	// An ad with no entries only replaces the metadata.
+	syncTestAd(t, te, storeAd(schema.NoEntries, contextID, "metadata-3", false))
+	requireMetadata(mhs1, "metadata-3")
+	requireMetadata(mhs2, "metadata-3")
+	requireEntryCount(len(mhs1) + len(mhs2))
+
+	// Entries already in the context can be advertised again.
+	syncTestAd(t, te, storeAd(entries1, contextID, "metadata-3", false))
+	requireMetadata(mhs1, "metadata-3")
+	requireMetadata(mhs2, "metadata-3")
+
+	// A removal ad removes all of the context's multihashes, and does not
+	// affect the provider's other contexts. The removed multihashes are not
+	// looked up yet, so their removal from the value store is not complete.
+	syncTestAd(t, te, storeAd(schema.NoEntries, contextID, "", true))
+	require.Nil(t, te.ingester.reg.ProviderContext(providerID, contextID))
+	requireIndexedEventually(t, te.ingester.indexer, providerID, otherMhs)
+
+	// An ad that reuses the removed context ID is skipped, so the removed
+	// multihashes are not restored by indexing the context again.
+	entries3, mhs3 := newRandomLinkedList(t, te.publisherLinkSys, 1)
+	syncTestAd(t, te, storeAd(entries3, contextID, "metadata-4", false))
+	require.Nil(t, te.ingester.reg.ProviderContext(providerID, contextID))
+	requireNotIndexed(t, te.ingester.indexer, providerID, mhs3)
+	requireNotIndexed(t, te.ingester.indexer, providerID, mhs1)
+	requireNotIndexed(t, te.ingester.indexer, providerID, mhs2)
+
+	// A metadata update for the removed context is also skipped.
+	syncTestAd(t, te, storeAd(schema.NoEntries, contextID, "metadata-5", false))
+	require.Nil(t, te.ingester.reg.ProviderContext(providerID, contextID))
+	requireNotIndexed(t, te.ingester.indexer, providerID, mhs1)
+
+	// The context ID can be used again if reuse is allowed.
+	te.ingester.cfg.AllowRemovedContextReuse = true
+	entries4, mhs4 := newRandomLinkedList(t, te.publisherLinkSys, 1)
+	syncTestAd(t, te, storeAd(entries4, contextID, "metadata-6", false))
+	requireIndexedEventually(t, te.ingester.indexer, providerID, mhs4)
+	requireMetadata(mhs4, "metadata-6")
+	requireEntryCount(len(mhs4))
+}
+
+func TestSyncHistory(t *testing.T) {
+	cfg := defaultTestIngestConfig
+	cfg.SyncHistoryLength = 2
+	te := setupTestEnv(t, true, func(teo *testEnvOpts) {
+		teo.ingestConfig = &cfg
+	})
+	ctx := context.Background()
+	providerID := te.pubHost.ID()
+
+	var ad ipld.Link
+	for i := 1; i <= 3; i++ {
+		entries, mhs := newRandomLinkedList(t, te.publisherLinkSys, i)
+		ad = storeTestAd(t, te, ad, entries, []byte(fmt.Sprint("context-", i)), false)
+		syncTestAd(t, te, ad)
+		requireIndexedEventually(t, te.ingester.indexer, providerID, mhs)
+
+		adCid := ad.(cidlink.Link).Cid
+		var history []adminmodel.SyncSummary
+		requireTrueEventually(t, func() bool {
+			var err error
+			history, err = te.ingester.SyncHistory(ctx, providerID)
+			require.NoError(t, err)
+			return len(history) != 0 && history[0].HeadAdCid == adCid
+		}, testRetryInterval, testRetryTimeout, "sync summary not recorded")
+		require.Equal(t, te.pubHost.ID(), history[0].Publisher)
+		require.Equal(t, 1, history[0].AdsProcessed)
+		require.Equal(t, uint64(len(mhs)), history[0].MultihashesAdded)
+		require.Empty(t, history[0].Err)
+		require.False(t, history[0].Time.IsZero())
+	}
+
+	// Only the configured number of the most recent summaries are kept.
+	history, err := te.ingester.SyncHistory(ctx, providerID)
 	require.NoError(t, err)
+	require.Len(t, history, 2)
+	require.Equal(t, ad.(cidlink.Link).Cid, history[0].HeadAdCid)
+	require.Equal(t, uint64(2*testEntriesChunkSize), history[1].MultihashesAdded)
 
-	wait, err := te.ingester.Sync(ctx, te.pubHost.ID(), nil, 0, false)
+	history, err = te.ingester.SyncHistory(ctx, te.ingesterHost.ID())
 	require.NoError(t, err)
-	<-wait
-	var lcid cid.Cid
+	require.Empty(t, history)
+}
+
+func TestRetryFailedAds(t *testing.T) {
+	te := setupTestEnv(t, true)
+	ctx := context.Background()
+
+	// An advertisement for a blocked provider is skipped, and removed from
+	// the datastore.
+	priv, _, err := crypto.GenerateEd25519Key(nil)
+	require.NoError(t, err)
+	blockedID, err := peer.IDFromPrivateKey(priv)
+	require.NoError(t, err)
+	te.reg.BlockPeer(blockedID)
+	entries, mhs := newRandomLinkedList(t, te.publisherLinkSys, 1)
+	ad1 := storeProviderTestAd(t, te, nil, blockedID, entries, []byte("context-1"), []byte("test-metadata"), false)
+	ad1Cid := ad1.(cidlink.Link).Cid
+	syncTestAd(t, te, ad1)
+	status, err := te.ingester.AdStatus(ctx, ad1Cid)
+	require.NoError(t, err)
+	require.Equal(t, ingestmodel.AdStatusFailed, status.Status)
+
+	entries, _ = newRandomLinkedList(t, te.publisherLinkSys, 1)
+	ad2 := storeTestAd(t, te, ad1, entries, []byte("context-2"), false)
+	ad2Cid := ad2.(cidlink.Link).Cid
+	syncTestAd(t, te, ad2)
+
+	te.reg.AllowPeer(blockedID)
+
+	// Failures of other providers are not retried.
+	summary, err := te.ingester.RetryFailedAds(ctx, te.pubHost.ID())
+	require.NoError(t, err)
+	require.Zero(t, summary.Requeued)
+	require.Zero(t, summary.Skipped)
+
+	summary, err = te.ingester.RetryFailedAds(ctx, blockedID)
+	require.NoError(t, err)
+	require.Equal(t, 1, summary.Requeued)
+	require.Zero(t, summary.Skipped)
+
+	requireIndexedEventually(t, te.core, blockedID, mhs)
 	requireTrueEventually(t, func() bool {
-		lcid, err = te.ingester.GetLatestSync(te.pubHost.ID())
+		status, err := te.ingester.AdStatus(ctx, ad1Cid)
+		return err == nil && status.Status == ingestmodel.AdStatusProcessed
+	}, testRetryInterval, testRetryTimeout, "Expected retried advertisement to be processed")
+
+	// Retrying an older advertisement does not change the latest sync.
+	latest, err := te.ingester.GetLatestSync(te.pubHost.ID())
+	require.NoError(t, err)
+	require.Equal(t, ad2Cid, latest)
+
+	// Nothing is left to retry.
+	summary, err = te.ingester.RetryFailedAds(ctx, "")
+	require.NoError(t, err)
+	require.Zero(t, summary.Requeued)
+}
+
+func TestMalformedAd(t *testing.T) {
+	te := setupTestEnv(t, true)
+	ctx := context.Background()
+
+	// A node without a signature is stored as if it were entries, so it is
+	// only found to not be an advertisement when it is loaded as one.
+	malformed, err := qp.BuildMap(basicnode.Prototype.Map, 2, func(ma datamodel.MapAssembler) {
+		qp.MapEntry(ma, "Provider", qp.Int(42))
+		qp.MapEntry(ma, "Entries", qp.String("not a link"))
+	})
+	require.NoError(t, err)
+	lnk, err := te.publisherLinkSys.Store(ipld.LinkContext{}, schema.Linkproto, malformed)
+	require.NoError(t, err)
+	badCid := lnk.(cidlink.Link).Cid
+
+	// Syncing a chain headed by the malformed node fails, and the failure is
+	// recorded for the node's CID.
+	require.NoError(t, te.publisher.SetRoot(ctx, badCid))
+	wait, err := te.ingester.Sync(ctx, te.pubHost.ID(), nil, 0, false)
+	require.NoError(t, err)
+	_, ok := <-wait
+	require.False(t, ok, "sync of malformed advertisement should fail")
+
+	status, err := te.ingester.AdStatus(ctx, badCid)
+	require.NoError(t, err)
+	require.Equal(t, ingestmodel.AdStatusFailed, status.Status)
+	require.Contains(t, status.Err, string(adIngestDecodingErr))
+
+	// Failing to decode is distinguished from failing to fetch.
+	_, err = te.ingester.loadAd(badCid)
+	require.True(t, isDecodingErr(err))
+	_, err = te.ingester.loadAd(cid.NewCidV1(cid.DagJSON, util.RandomMultihashes(1, rng)[0]))
+	require.Error(t, err)
+	require.False(t, isDecodingErr(err))
+
+	// Processing a chain that holds the malformed node skips it, and
+	// continues with the rest of the chain.
+	var adCids []cid.Cid
+	var allMhs [][]multihash.Multihash
+	var prev ipld.Link
+	for i := 0; i < 2; i++ {
+		entries, mhs := newRandomLinkedList(t, te.publisherLinkSys, 1)
+		prev = storeTestAd(t, te, prev, entries, []byte(fmt.Sprint("context-", i)), false)
+		adCid := prev.(cidlink.Link).Cid
+		key := datastore.NewKey(adCid.String())
+		block, err := te.pubStore.Get(ctx, key)
 		require.NoError(t, err)
-		return chainHead.(cidlink.Link).Cid == lcid
-	}, testRetryInterval, testRetryTimeout, "Expected %s but got %s", chainHead, lcid)
+		require.NoError(t, te.ingester.ds.Put(ctx, key, block))
+		adCids = append(adCids, adCid)
+		allMhs = append(allMhs, mhs)
+	}
+	te.ingester.runIngestStep(legs.SyncFinished{
+		Cid:        adCids[1],
+		PeerID:     te.pubHost.ID(),
+		SyncedCids: []cid.Cid{adCids[1], badCid, adCids[0]},
+	})
+	for _, mhs := range allMhs {
+		requireIndexedEventually(t, te.ingester.indexer, te.pubHost.ID(), mhs)
+	}
+	requireTrueEventually(t, func() bool {
+		return te.ingester.adAlreadyProcessed(adCids[0]) && te.ingester.adAlreadyProcessed(adCids[1])
+	}, testRetryInterval, testRetryTimeout, "Expected advertisements around malformed advertisement to be processed")
+	require.False(t, te.ingester.adAlreadyProcessed(badCid))
+}
 
-	allMhs := typehelpers.AllMultihashesFromAdChain(t, prevAd, te.publisherLinkSys)
+// storeTestAd stores an advertisement from the test publisher in the
+// publisher's link system.
+func storeTestAd(t *testing.T, te *testEnv, prev, entries ipld.Link, contextID []byte, isRm bool) ipld.Link {
+	return storeProviderTestAd(t, te, prev, te.pubHost.ID(), entries, contextID, []byte("test-metadata"), isRm)
+}
 
-	first := allMhs[0]
+// storeProviderTestAd stores an advertisement for the given provider and
+// metadata, signed by the test publisher.
+func storeProviderTestAd(t *testing.T, te *testEnv, prev ipld.Link, provider peer.ID, entries ipld.Link, contextID, metadata []byte, isRm bool) ipld.Link {
+	ad := &schema.Advertisement{
+		PreviousID: prev,
+		Provider:   provider.String(),
+		Addresses:  []string{"/ip4/127.0.0.1/tcp/9999"},
+		Entries:    entries,
+		ContextID:  contextID,
+		Metadata:   metadata,
+		IsRm:       isRm,
+	}
+	err := ad.Sign(te.publisherPriv)
+	require.NoError(t, err)
+	node, err := ad.ToNode()
+	require.NoError(t, err)
+	lnk, err := te.publisherLinkSys.Store(ipld.LinkContext{}, schema.Linkproto, node)
+	require.NoError(t, err)
+	return lnk
+}
 
-	// Remove the mhs from the first ad (since the last add removed this from the indexer)
-	allMhs = allMhs[1:]
-	requireIndexedEventually(t, te.ingester.indexer, te.pubHost.ID(), allMhs)
+// syncTestAd sets the advertisement as the head of the test publisher's chain
+// and waits for the ingester to process it.
+func syncTestAd(t *testing.T, te *testEnv, ad ipld.Link) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
 
-	// Check that first multihash was never ingested in the first place,
-	// indicating it was skipped.
-	var found bool
-	for _, mh := range cw.mhs {
-		if bytes.Equal(mh, first) {
-			found = true
+	adCid := ad.(cidlink.Link).Cid
+	err := te.publisher.SetRoot(ctx, adCid)
+	require.NoError(t, err)
+	end, err := te.ingester.Sync(ctx, te.pubHost.ID(), nil, 0, false)
+	require.NoError(t, err)
+	select {
+	case endCid := <-end:
+		require.Equal(t, adCid, endCid)
+	case <-ctx.Done():
+		t.Fatal("sync timeout")
+	}
+}
+
+func TestSyncSince(t *testing.T) {
+	te := setupTestEnv(t, true)
+	ctx := context.Background()
+
+	var adCids []cid.Cid
+	var prev ipld.Link
+	for i := 0; i < 3; i++ {
+		entries, _ := newRandomLinkedList(t, te.publisherLinkSys, 1)
+		prev = storeTestAd(t, te, prev, entries, []byte(fmt.Sprint("context-", i)), false)
+		adCids = append(adCids, prev.(cidlink.Link).Cid)
+	}
+	syncTestAd(t, te, prev)
+	for _, c := range adCids {
+		_, ok := te.ingester.adProcessedTime(c)
+		require.True(t, ok, "processed time not recorded")
+	}
+
+	// Make the first ad look like it was processed long ago.
+	oldTime := time.Now().Add(-48 * time.Hour).Truncate(time.Second)
+	err := te.ingester.ds.Put(ctx, datastore.NewKey(adProcessedPrefix+adCids[0].String()), adProcessedValue(true, oldTime))
+	require.NoError(t, err)
+
+	processed, cancel := te.ingester.onAdProcessed(te.pubHost.ID())
+	defer cancel()
+
+	ctx, cancelSync := context.WithTimeout(ctx, 10*time.Second)
+	defer cancelSync()
+	end, err := te.ingester.SyncSince(ctx, te.pubHost.ID(), nil, time.Now().Add(-time.Hour), 0)
+	require.NoError(t, err)
+	select {
+	case endCid := <-end:
+		require.Equal(t, adCids[2], endCid)
+	case <-ctx.Done():
+		t.Fatal("sync timeout")
+	}
+
+	// Only the ads processed after the cutoff are ingested again.
+	var reingested []cid.Cid
+	for len(reingested) < 2 {
+		select {
+		case event := <-processed:
+			require.NoError(t, event.err)
+			reingested = append(reingested, event.adCid)
+		case <-ctx.Done():
+			t.Fatal("timeout waiting for processed ads")
 		}
 	}
-	require.False(t, found)
+	require.ElementsMatch(t, adCids[1:], reingested)
+	require.True(t, te.ingester.adAlreadyProcessed(adCids[0]))
+	firstProcessed, ok := te.ingester.adProcessedTime(adCids[0])
+	require.True(t, ok)
+	require.True(t, oldTime.Equal(firstProcessed))
+
+	// When the latest ad is older than the cutoff, nothing is ingested.
+	end, err = te.ingester.SyncSince(ctx, te.pubHost.ID(), nil, time.Now().Add(time.Hour), 0)
+	require.NoError(t, err)
+	select {
+	case endCid := <-end:
+		require.Equal(t, adCids[2], endCid)
+	case <-ctx.Done():
+		t.Fatal("sync timeout")
+	}
+	require.Zero(t, len(processed))
 }
 
 func TestSync(t *testing.T) {
@@ -688,6 +3045,12 @@ func TestSync(t *testing.T) {
 	// Checking providerID, since that was what was put in the advertisement, not pubhost.ID()
 	requireIndexedEventually(t, i.indexer, providerID, mhs)
 
+	// Check that the advertised context is recorded in the registry.
+	requireTrueEventually(t, func() bool {
+		contexts := i.reg.ProviderContexts(providerID)
+		return len(contexts) == 1 && contexts[0].EntryCount == uint64(len(mhs)) && contexts[0].LastAdvertisement == c1
+	}, testRetryInterval, testRetryTimeout, "Expected provider context to be recorded")
+
 	// Test that we finish this sync even if we're already at the latest
 	end, err = i.Sync(ctx, pubHost.ID(), nil, 0, false)
 	require.NoError(t, err)
@@ -734,6 +3097,45 @@ func TestReSyncWithDepth(t *testing.T) {
 	requireIndexedEventually(t, te.ingester.indexer, te.pubHost.ID(), allMHs)
 }
 
+func TestResyncEntryCountStable(t *testing.T) {
+	te := setupTestEnv(t, true)
+	pubID := te.pubHost.ID()
+	contextID := []byte("test-context")
+
+	entries1, mhs1 := newRandomLinkedList(t, te.publisherLinkSys, 2)
+	ad1 := storeTestAd(t, te, nil, entries1, contextID, false)
+	entries2, mhs2 := newRandomLinkedList(t, te.publisherLinkSys, 1)
+	ad2 := storeTestAd(t, te, ad1, entries2, contextID, false)
+	syncTestAd(t, te, ad2)
+	requireIndexedEventually(t, te.core, pubID, mhs1)
+	requireIndexedEventually(t, te.core, pubID, mhs2)
+
+	expected := uint64(len(mhs1) + len(mhs2))
+	info := te.reg.ProviderContext(pubID, contextID)
+	require.NotNil(t, info)
+	require.Equal(t, expected, info.EntryCount)
+
+	// Resyncing processes the same ads again, which does not change the
+	// number of entries counted for the context.
+	for i := 0; i < 2; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		end, err := te.ingester.Sync(ctx, pubID, nil, 0, true)
+		require.NoError(t, err)
+		select {
+		case endCid := <-end:
+			require.Equal(t, ad2.(cidlink.Link).Cid, endCid)
+		case <-ctx.Done():
+			t.Fatal("sync timeout")
+		}
+		cancel()
+
+		info = te.reg.ProviderContext(pubID, contextID)
+		require.NotNil(t, info)
+		require.Equal(t, expected, info.EntryCount)
+		require.Equal(t, expected, te.reg.ProviderEntryCount(pubID))
+	}
+}
+
 func TestSkipEarlierAdsIfAlreadyProcessedLaterAd(t *testing.T) {
 	te := setupTestEnv(t, false)
 	adHead := typehelpers.RandomAdBuilder{
@@ -770,6 +3172,14 @@ func TestSkipEarlierAdsIfAlreadyProcessedLaterAd(t *testing.T) {
 }
 
 func TestRecursionDepthLimitsEntriesSync(t *testing.T) {
+	for _, entriesDepth := range []int{1, 2, 10} {
+		t.Run(fmt.Sprint("depth-", entriesDepth), func(t *testing.T) {
+			testRecursionDepthLimitsEntriesSync(t, entriesDepth)
+		})
+	}
+}
+
+func testRecursionDepthLimitsEntriesSync(t *testing.T, entriesDepth int) {
 	srcStore := dssync.MutexWrap(datastore.NewMapDatastore())
 	h := mkTestHost()
 	pubHost := mkTestHost()
@@ -780,13 +3190,11 @@ func TestRecursionDepthLimitsEntriesSync(t *testing.T) {
 	defer pub.Close()
 	connectHosts(t, h, pubHost)
 
-	const entriesDepth = 10
-
 	totalChunkCount := entriesDepth * 2
 
 	// Replace ingester entries selector with on that has a much smaller limit,
 	// for testing.
-	ing.entriesSel = Selectors.EntriesWithLimit(selector.RecursionLimitDepth(entriesDepth))
+	ing.entriesSel = entriesSelectorAfterFirst(entriesDepth)
 
 	adCid, _, providerID := publishRandomIndexAndAdvWithEntriesChunkCount(t, pub, lsys, false, totalChunkCount)
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -833,11 +3241,9 @@ func TestRecursionDepthLimitsEntriesSync(t *testing.T) {
 	nextChunkCid := entriesCid
 	for i := 0; i < totalChunkCount; i++ {
 		mhs, nextChunkCid = decodeEntriesChunk(t, srcStore, nextChunkCid)
-		// If chunk depth is within limit
-		// Note that 1 is added to the entries depth because the entries sync process peaks the
-		// first node in order to detect the type of entries, i.e. HAMT vs EntryChunk chain.
-		// Therefore, the max entries traversal depth is 1 plus the configured max.
-		if i < entriesDepth+1 {
+		// If chunk depth is within limit. The first chunk, that is synced
+		// separately to detect the type of entries, counts towards the limit.
+		if i < entriesDepth {
 			// Assert chunk multihashes are indexed
 			requireIndexedEventually(t, ing.indexer, providerID, mhs)
 		} else {
@@ -1118,6 +3524,111 @@ func TestAnnounceIsNotDeferredOnNoInProgressIngest(t *testing.T) {
 	requireIndexedEventually(t, te.ingester.indexer, te.pubHost.ID(), mhs)
 }
 
+func TestAnnounceRecordsLastSeen(t *testing.T) {
+	te := setupTestEnv(t, true)
+	defer te.Close(t)
+	pubID := te.pubHost.ID()
+	pubAddrInfo := te.pubHost.Peerstore().PeerInfo(pubID)
+	require.True(t, te.reg.LastSeen(pubID).IsZero())
+
+	entries, mhs := newRandomLinkedList(t, te.publisherLinkSys, 1)
+	ad := storeTestAd(t, te, nil, entries, []byte("context-1"), false)
+	adCid := ad.(cidlink.Link).Cid
+
+	require.NoError(t, te.ingester.Announce(context.Background(), adCid, pubAddrInfo))
+	requireIndexedEventually(t, te.core, pubID, mhs)
+	firstSeen := te.reg.LastSeen(pubID)
+	require.False(t, firstSeen.IsZero())
+
+	// Announcing an advertisement that is already processed updates the time
+	// the publisher was last seen.
+	time.Sleep(10 * time.Millisecond)
+	require.NoError(t, te.ingester.Announce(context.Background(), adCid, pubAddrInfo))
+	require.True(t, te.reg.LastSeen(pubID).After(firstSeen))
+}
+
+func TestIngestSnapshot(t *testing.T) {
+	te := setupTestEnv(t, true)
+	defer te.Close(t)
+	provID := te.pubHost.ID()
+
+	// The publisher store holds the blocks of the chain keyed by CID, as if
+	// read from CAR files, and is used as the snapshot without the provider
+	// publishing it.
+	entries1, mhs1 := newRandomLinkedList(t, te.publisherLinkSys, 3)
+	ad1 := storeTestAd(t, te, nil, entries1, []byte("context-1"), false)
+	entries2, mhs2 := newRandomLinkedList(t, te.publisherLinkSys, 2)
+	ad2 := storeTestAd(t, te, ad1, entries2, []byte("context-2"), false)
+	headCid := ad2.(cidlink.Link).Cid
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	latest, err := te.ingester.IngestSnapshot(ctx, provID, te.pubStore, cid.Undef)
+	require.NoError(t, err)
+	require.Equal(t, headCid, latest)
+	requireIndexedEventually(t, te.core, provID, append(mhs1, mhs2...))
+
+	// The temporary publisher is not kept as the provider's publisher, and
+	// nothing is kept for it.
+	info := te.reg.ProviderInfo(provID)
+	require.NotNil(t, info)
+	require.True(t, info.Publisher == "" || info.Publisher == provID)
+	var snapshotPubs int
+	te.ingester.snapshotPublishers.Range(func(_, _ interface{}) bool {
+		snapshotPubs++
+		return true
+	})
+	require.Zero(t, snapshotPubs)
+
+	// Ingesting the snapshot again finds the head already processed.
+	latest, err = te.ingester.IngestSnapshot(ctx, provID, te.pubStore, headCid)
+	require.NoError(t, err)
+	require.Equal(t, headCid, latest)
+
+	// The head must be for the given provider.
+	_, err = te.ingester.IngestSnapshot(ctx, te.ingesterHost.ID(), te.pubStore, headCid)
+	require.ErrorContains(t, err, "not "+te.ingesterHost.ID().String())
+
+	// A snapshot with more than one chain needs the head to be given.
+	entries3, _ := newRandomLinkedList(t, te.publisherLinkSys, 1)
+	storeTestAd(t, te, nil, entries3, []byte("context-3"), false)
+	_, err = te.ingester.IngestSnapshot(ctx, provID, te.pubStore, cid.Undef)
+	require.ErrorContains(t, err, "head must be specified")
+}
+
+func TestAnnounceDebounce(t *testing.T) {
+	cfg := defaultTestIngestConfig
+	cfg.AnnounceDebounce = config.Duration(200 * time.Millisecond)
+	te := setupTestEnv(t, true, func(teo *testEnvOpts) {
+		teo.ingestConfig = &cfg
+	})
+	defer te.Close(t)
+	pubID := te.pubHost.ID()
+	pubAddrInfo := te.pubHost.Peerstore().PeerInfo(pubID)
+
+	entries, mhs1 := newRandomLinkedList(t, te.publisherLinkSys, 1)
+	ad1 := storeTestAd(t, te, nil, entries, []byte("context-1"), false)
+	entries, mhs2 := newRandomLinkedList(t, te.publisherLinkSys, 1)
+	ad2 := storeTestAd(t, te, ad1, entries, []byte("context-2"), false)
+	ad2Cid := ad2.(cidlink.Link).Cid
+
+	// A burst of announcements is held, and only the latest is kept.
+	require.NoError(t, te.ingester.Announce(context.Background(), ad1.(cidlink.Link).Cid, pubAddrInfo))
+	require.NoError(t, te.ingester.Announce(context.Background(), ad2Cid, pubAddrInfo))
+	te.ingester.debounceMutex.Lock()
+	pa, found := te.ingester.debouncedAnnounces[pubID]
+	te.ingester.debounceMutex.Unlock()
+	require.True(t, found)
+	require.Equal(t, ad2Cid, pa.nextCid)
+	requireNotIndexed(t, te.core, pubID, mhs1)
+
+	// After the debounce time, the latest announcement is handled.
+	requireIndexedEventually(t, te.core, pubID, append(mhs1, mhs2...))
+	te.ingester.debounceMutex.Lock()
+	require.Len(t, te.ingester.debouncedAnnounces, 0)
+	te.ingester.debounceMutex.Unlock()
+}
+
 func TestAnnounceArrivedJustBeforeEntriesProcessingStartsDoesNotDeadlock(t *testing.T) {
 	blockableLsysOpt, blockedReads, hitBlockedRead := blockableLinkSys(nil)
 	te := setupTestEnv(t, true, blockableLsysOpt)
@@ -1202,7 +3713,7 @@ func mkRegistry(t *testing.T) *registry.Registry {
 		PollInterval:   config.Duration(time.Minute),
 		RediscoverWait: config.Duration(time.Minute),
 	}
-	reg, err := registry.NewRegistry(context.Background(), discoveryCfg, nil, nil)
+	reg, err := registry.NewRegistry(context.Background(), discoveryCfg, datastore.NewMapDatastore(), nil)
 	if err != nil {
 		t.Fatal(err)
 	}
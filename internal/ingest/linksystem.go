@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"io"
 	"strings"
+	"sync"
 	"time"
 
 	indexer "github.com/filecoin-project/go-indexer-core"
@@ -16,6 +17,7 @@ import (
 	"github.com/filecoin-project/storetheindex/api/v0/ingest/schema"
 	"github.com/filecoin-project/storetheindex/internal/metrics"
 	"github.com/filecoin-project/storetheindex/internal/registry"
+	lru "github.com/hashicorp/golang-lru"
 	"github.com/ipfs/go-cid"
 	"github.com/ipfs/go-datastore"
 	hamt "github.com/ipld/go-ipld-adl-hamt"
@@ -24,6 +26,7 @@ import (
 	"github.com/ipld/go-ipld-prime/multicodec"
 	"github.com/ipld/go-ipld-prime/node/basicnode"
 	"github.com/ipld/go-ipld-prime/node/bindnode"
+	pb "github.com/libp2p/go-libp2p-core/crypto/pb"
 	"github.com/libp2p/go-libp2p-core/peer"
 	"github.com/multiformats/go-multihash"
 	"go.opencensus.io/stats"
@@ -34,15 +37,34 @@ import (
 	_ "github.com/ipld/go-ipld-prime/codec/dagjson"
 )
 
+// verifiedSigCacheSize is the maximum number of advertisements whose
+// signature verification result is cached.
+const verifiedSigCacheSize = 8192
+
 var (
 	errBadAdvert              = errors.New("bad advertisement")
 	errInvalidAdvertSignature = errors.New("invalid advertisement signature")
+	errDisallowedKeyType      = errors.New("advertisement signed with disallowed key type")
 )
 
+// adSignatureError is returned by verifyAdvertisement when an advertisement
+// is rejected because of its signature. The signer is the peer that signed
+// the advertisement, or empty if the signature could not be verified.
+type adSignatureError struct {
+	err    error
+	signer peer.ID
+}
+
+func (e adSignatureError) Error() string { return e.err.Error() }
+func (e adSignatureError) Unwrap() error { return e.err }
+
 // mkLinkSystem makes the indexer linkSystem which checks advertisement
 // signatures at storage. If the signature is not valid the traversal/exchange
-// is terminated.
-func mkLinkSystem(ds datastore.Batching, reg *registry.Registry) ipld.LinkSystem {
+// is terminated, and the failure is recorded by badSigs. Storing entries waits
+// while the datastore holds more sync data than its limit. An entry chunk with
+// more than maxEntries entries terminates the traversal without being decoded
+// in full.
+func mkLinkSystem(ds *syncDataStore, reg *registry.Registry, keyTypes map[pb.KeyType]struct{}, skipTrusted bool, maxEntries int, sigs *verifiedSigCache, badSigs *badSigTracker) ipld.LinkSystem {
 	lsys := cidlink.DefaultLinkSystem()
 	lsys.StorageReadOpener = func(lctx ipld.LinkContext, lnk ipld.Link) (io.Reader, error) {
 		c := lnk.(cidlink.Link).Cid
@@ -63,8 +85,12 @@ func mkLinkSystem(ds datastore.Batching, reg *registry.Registry) ipld.LinkSystem
 			log := log.With("cid", c)
 
 			// Decode the node to check its type.
-			n, err := decodeIPLDNode(codec, buf, basicnode.Prototype.Any)
+			n, err := decodeIPLDNode(codec, buf, limitEntries(basicnode.Prototype.Any, maxEntries))
 			if err != nil {
+				if errors.Is(err, errTooManyEntries) {
+					log.Errorw("Rejected oversized entry chunk", "err", err)
+					return err
+				}
 				log.Errorw("Error decoding IPLD node in linksystem", "err", err)
 				return errors.New("bad ipld data")
 			}
@@ -72,14 +98,23 @@ func mkLinkSystem(ds datastore.Batching, reg *registry.Registry) ipld.LinkSystem
 			if isAdvertisement(n) {
 				// Verify that the signature is correct and the advertisement
 				// is valid.
-				provID, err := verifyAdvertisement(n, reg)
+				provID, signerID, err := verifyAdvertisement(n, c, reg, keyTypes, skipTrusted, sigs)
 				if err != nil {
+					var sigErr adSignatureError
+					if errors.As(err, &sigErr) {
+						badSigs.failed(sigErr.signer)
+					}
 					return err
 				}
+				badSigs.verified(signerID)
 
 				log.Infow("Received advertisement", "provider", provID)
 			} else {
 				log.Debug("Received IPLD node")
+				// Pause fetching entries until processing frees space.
+				if err = ds.waitForSpace(lctx.Ctx); err != nil {
+					return err
+				}
 			}
 			// Any other type of node (like entries) are stored right away.
 			return ds.Put(lctx.Ctx, datastore.NewKey(c.String()), origBuf)
@@ -88,35 +123,126 @@ func mkLinkSystem(ds datastore.Batching, reg *registry.Registry) ipld.LinkSystem
 	return lsys
 }
 
-func verifyAdvertisement(n ipld.Node, reg *registry.Registry) (peer.ID, error) {
+// verifiedSig is the result of verifying an advertisement's signature.
+type verifiedSig struct {
+	signerID peer.ID
+	keyType  pb.KeyType
+}
+
+// verifiedSigCache holds the signers of advertisements whose signatures were
+// verified, keyed by advertisement CID. An advertisement's CID is the hash of
+// its content, including its signature, so an advertisement with the same CID
+// does not need its signature verified again. Only the result of verifying
+// the signature is cached. The checks that depend on configuration and policy
+// are always done.
+type verifiedSigCache struct {
+	cache *lru.Cache
+}
+
+func newVerifiedSigCache(size int) *verifiedSigCache {
+	cache, err := lru.New(size)
+	if err != nil {
+		panic(err)
+	}
+	return &verifiedSigCache{cache: cache}
+}
+
+// verify returns the signer of the advertisement, and the type of the signer's
+// key, verifying the signature unless the result is already cached. A nil
+// verifiedSigCache always verifies the signature.
+func (s *verifiedSigCache) verify(adCid cid.Cid, ad *schema.Advertisement) (peer.ID, pb.KeyType, error) {
+	if s != nil && adCid != cid.Undef {
+		if v, ok := s.cache.Get(adCid); ok {
+			sig := v.(verifiedSig)
+			return sig.signerID, sig.keyType, nil
+		}
+	}
+	signerID, signerKey, err := ad.VerifySignatureKey()
+	if err != nil {
+		return "", 0, err
+	}
+	if s != nil && adCid != cid.Undef {
+		s.cache.Add(adCid, verifiedSig{
+			signerID: signerID,
+			keyType:  signerKey.Type(),
+		})
+	}
+	return signerID, signerKey.Type(), nil
+}
+
+// verifyAdvertisement checks that the advertisement is signed by its provider
+// or by a publisher allowed to publish for the provider, and returns the
+// provider and signer IDs. If keyTypes is not empty, the advertisement must
+// also be signed with a key of one of those types. If skipTrusted is true,
+// then the signature of an advertisement for a trusted provider is not
+// verified, and the signer ID is empty. The provider ID in the advertisement
+// is not authenticated, so the publisher of such an advertisement must be
+// checked by verifyTrustedAd before it is ingested. If sigs is not nil, then
+// the signature of an advertisement that was already verified is not
+// verified again.
+func verifyAdvertisement(n ipld.Node, adCid cid.Cid, reg *registry.Registry, keyTypes map[pb.KeyType]struct{}, skipTrusted bool, sigs *verifiedSigCache) (peer.ID, peer.ID, error) {
 	ad, err := schema.UnwrapAdvertisement(n)
 	if err != nil {
 		log.Errorw("Cannot decode advertisement", "err", err)
-		return "", errBadAdvert
+		return "", "", errBadAdvert
+	}
+	if skipTrusted {
+		provID, err := peer.Decode(ad.Provider)
+		if err == nil && reg.Trusted(provID) {
+			log.Debugw("Deferred signature verification for trusted provider", "provider", provID)
+			return provID, "", nil
+		}
 	}
+	return verifyAdSignature(ad, adCid, reg, keyTypes, sigs)
+}
+
+// verifyAdSignature verifies the signature of a decoded advertisement, and
+// returns the provider and signer IDs.
+func verifyAdSignature(ad *schema.Advertisement, adCid cid.Cid, reg *registry.Registry, keyTypes map[pb.KeyType]struct{}, sigs *verifiedSigCache) (peer.ID, peer.ID, error) {
 	// Verify advertisement signature.
-	signerID, err := ad.VerifySignature()
+	signerID, keyType, err := sigs.verify(adCid, ad)
 	if err != nil {
 		// stop exchange, verification of signature failed.
-		log.Errorw("Advertisement signature verification failed", "err", err)
-		return "", errInvalidAdvertSignature
+		logRepeats.Errorw("Advertisement signature verification failed", "err", err)
+		return "", "", adSignatureError{err: errInvalidAdvertSignature}
+	}
+	if len(keyTypes) != 0 {
+		if _, ok := keyTypes[keyType]; !ok {
+			logRepeats.Errorw("Advertisement signed with disallowed key type", "keyType", keyType, "signer", signerID)
+			return "", "", adSignatureError{err: errDisallowedKeyType, signer: signerID}
+		}
 	}
 
 	// Get provider ID from advertisement.
 	provID, err := peer.Decode(ad.Provider)
 	if err != nil {
-		log.Errorw("Cannot get provider from advertisement", "err", err, "signer", signerID)
-		return "", errBadAdvert
+		logRepeats.Errorw("Cannot get provider from advertisement", "err", err, "signer", signerID)
+		return "", "", errBadAdvert
 	}
 
 	// Verify that the advertisement is signed by the provider or by an allowed
 	// publisher.
 	if signerID != provID && !reg.PublishAllowed(signerID, provID) {
-		log.Errorw("Advertisement not signed by provider or allowed publisher", "provider", ad.Provider, "signer", signerID)
-		return "", errInvalidAdvertSignature
+		logRepeats.Errorw("Advertisement not signed by provider or allowed publisher", "provider", ad.Provider, "signer", signerID)
+		return "", "", adSignatureError{err: errInvalidAdvertSignature, signer: signerID}
 	}
 
-	return provID, nil
+	return provID, signerID, nil
+}
+
+// verifyTrustedAd verifies the signature of an advertisement for a trusted
+// provider if the advertisement was not published by a trusted peer. The
+// link system does not verify the signatures of advertisements for trusted
+// providers when SkipTrustedSignatureCheck is enabled, but the provider named
+// in an advertisement is not authenticated. The publisher is the peer that
+// served the sync, so only its identity decides whether the signature check
+// can be skipped.
+func (ing *Ingester) verifyTrustedAd(publisherID, providerID peer.ID, adCid cid.Cid, ad *schema.Advertisement) error {
+	if !ing.cfg.SkipTrustedSignatureCheck || !ing.reg.Trusted(providerID) || ing.reg.Trusted(publisherID) {
+		return nil
+	}
+	_, _, err := verifyAdSignature(ad, adCid, ing.reg, ing.keyTypes, ing.sigs)
+	return err
 }
 
 // ingestAd fetches all the entries for a single advertisement and processes
@@ -132,7 +258,9 @@ func verifyAdvertisement(n ipld.Node, reg *registry.Registry) (peer.ID, error) {
 // source of the indexed content, the provider is where content can be
 // retrieved from. It is the provider ID that needs to be stored by the
 // indexer.
-func (ing *Ingester) ingestAd(publisherID peer.ID, adCid cid.Cid, ad schema.Advertisement) error {
+//
+// Returns the number of multihashes indexed from the advertisement's entries.
+func (ing *Ingester) ingestAd(publisherID peer.ID, adCid cid.Cid, ad schema.Advertisement) (uint64, error) {
 	stats.Record(context.Background(), metrics.IngestChange.M(1))
 	ingestStart := time.Now()
 	defer func() {
@@ -144,25 +272,71 @@ func (ing *Ingester) ingestAd(publisherID peer.ID, adCid cid.Cid, ad schema.Adve
 	// Get provider ID from advertisement.
 	providerID, err := peer.Decode(ad.Provider)
 	if err != nil {
-		return adIngestError{adIngestDecodingErr, fmt.Errorf("failed to read provider id: %w", err)}
+		return 0, adIngestError{adIngestDecodingErr, fmt.Errorf("failed to read provider id: %w", err)}
+	}
+
+	if err = ing.verifyTrustedAd(publisherID, providerID, adCid, &ad); err != nil {
+		return 0, adIngestError{adIngestSignatureErr, fmt.Errorf("advertisement for trusted provider not published by trusted peer: %w", err)}
+	}
+
+	// An allowed publisher may publish advertisements on behalf of another
+	// provider, so check that the provider is also allowed.
+	if err = ing.checkAdProvider(publisherID, providerID); err != nil {
+		return 0, adIngestError{adIngestNotAllowedErr, err}
+	}
+
+	// If the advertised provider is an alias, then attribute the
+	// advertisement to the canonical provider. The checks above are done for
+	// the advertised provider, since that is the key that the advertisement
+	// was signed and published for.
+	if canonicalID := ing.reg.CanonicalProvider(providerID); canonicalID != providerID {
+		log.Debugw("Attributing advertisement to canonical provider", "alias", providerID, "provider", canonicalID)
+		providerID = canonicalID
+		ad.Provider = canonicalID.String()
+	}
+
+	if err = ing.filterAd(providerID, ad); err != nil {
+		return 0, adIngestError{adIngestFilteredErr, fmt.Errorf("advertisement rejected by filter: %w", err)}
 	}
 
 	// Register provider or update existing registration. The provider must be
 	// allowed by policy to be registered.
+	//
+	// A temporary snapshot publisher is not registered as the provider's
+	// publisher, so the provider's registered publisher is kept.
 	var pubInfo peer.AddrInfo
-	peerStore := ing.sub.HttpPeerStore()
-	if peerStore != nil {
-		pubInfo = peerStore.PeerInfo(publisherID)
-	}
-	if len(pubInfo.Addrs) == 0 {
-		peerStore = ing.host.Peerstore()
+	if _, ok := ing.snapshotPublisher(publisherID); !ok {
+		peerStore := ing.sub.HttpPeerStore()
 		if peerStore != nil {
 			pubInfo = peerStore.PeerInfo(publisherID)
 		}
+		if len(pubInfo.Addrs) == 0 {
+			peerStore = ing.host.Peerstore()
+			if peerStore != nil {
+				pubInfo = peerStore.PeerInfo(publisherID)
+			}
+		}
+	}
+	// Apply the unknown provider policy to a provider that is not
+	// registered. The default is to register the provider.
+	var flagProvider bool
+	if !ing.reg.IsRegistered(providerID) {
+		switch ing.cfg.UnknownProviderPolicy {
+		case "reject":
+			return 0, adIngestError{adIngestUnknownProviderErr, fmt.Errorf("provider %s is not registered", providerID)}
+		case "flag":
+			log.Warnw("Registering unknown provider from advertisement", "provider", providerID, "addrs", ad.Addresses)
+			flagProvider = true
+		}
 	}
 	err = ing.reg.RegisterOrUpdate(context.Background(), providerID, ad.Addresses, adCid, pubInfo)
 	if err != nil {
-		return adIngestError{adIngestRegisterProviderErr, fmt.Errorf("could not register/update provider info: %w", err)}
+		return 0, adIngestError{adIngestRegisterProviderErr, fmt.Errorf("could not register/update provider info: %w", err)}
+	}
+	if flagProvider {
+		if _, err = ing.reg.FlagAutoRegistered(context.Background(), providerID); err != nil {
+			return 0, adIngestError{adIngestRegisterProviderErr, fmt.Errorf("could not flag provider as auto-registered: %w", err)}
+		}
 	}
 
 	log = log.With("contextID", base64.StdEncoding.EncodeToString(ad.ContextID), "provider", ad.Provider)
@@ -170,11 +344,28 @@ func (ing *Ingester) ingestAd(publisherID peer.ID, adCid cid.Cid, ad schema.Adve
 	if ad.IsRm {
 		log.Infow("Advertisement is for removal by context id")
 
-		err = ing.indexer.RemoveProviderContext(providerID, ad.ContextID)
+		err = ing.removeProviderContext(context.Background(), providerID, ad.ContextID)
 		if err != nil {
-			return adIngestError{adIngestIndexerErr, fmt.Errorf("failed to remove provider context: %w", err)}
+			return 0, adIngestError{adIngestIndexerErr, fmt.Errorf("failed to remove provider context: %w", err)}
+		}
+		return 0, nil
+	}
+
+	// The value store removes a context lazily, so multihashes of a removed
+	// context that have not been looked up since the removal are restored if
+	// the context ID is used again. This applies to a metadata update as well
+	// as to new entries.
+	if ing.reg.ProviderContext(providerID, ad.ContextID) == nil {
+		tombs, err := ing.tombstoneCount(context.Background(), contextTombstoneKey(providerID, ad.ContextID))
+		if err != nil {
+			return 0, adIngestError{adIngestIndexerErr, fmt.Errorf("failed to read context tombstone count: %w", err)}
+		}
+		if tombs != 0 {
+			if !ing.cfg.AllowRemovedContextReuse {
+				return 0, adIngestError{adIngestRemovedContextErr, errors.New("advertisement reuses context id of removed content")}
+			}
+			log.Warnw("Advertisement reuses context id of removed content, which may restore removed multihashes")
 		}
-		return nil
 	}
 
 	// If advertisement has no entries, then this is for updating metadata only.
@@ -189,18 +380,21 @@ func (ing *Ingester) ingestAd(publisherID peer.ID, adCid cid.Cid, ad schema.Adve
 		log.Error("Advertisement is metadata update only")
 		err = ing.indexer.Put(value)
 		if err != nil {
-			return adIngestError{adIngestIndexerErr, fmt.Errorf("failed to update metadata: %w", err)}
+			return 0, adIngestError{adIngestIndexerErr, fmt.Errorf("failed to update metadata: %w", err)}
 		}
-		return nil
+		ing.updateProviderContext(providerID, ad, 0, adCid, log)
+		return 0, nil
 	}
 
 	entriesCid := ad.Entries.(cidlink.Link).Cid
 	if entriesCid == cid.Undef {
-		return adIngestError{adIngestMalformedErr, fmt.Errorf("advertisement entries link is undefined")}
+		return 0, adIngestError{adIngestMalformedErr, fmt.Errorf("advertisement entries link is undefined")}
 	}
 	log = log.With("entriesCid", entriesCid)
 
-	ctx := context.Background()
+	// Use the ingester's context so that closing the ingester stops the
+	// ingestion of a large advertisement.
+	ctx := ing.closingCtx
 	if ing.syncTimeout != 0 {
 		var cancel context.CancelFunc
 		ctx, cancel = context.WithTimeout(ctx, ing.syncTimeout)
@@ -209,21 +403,37 @@ func (ing *Ingester) ingestAd(publisherID peer.ID, adCid cid.Cid, ad schema.Adve
 
 	startTime := time.Now()
 
+	// Keep the connection to the publisher while syncing entries.
+	defer ing.protectPeer(publisherID)()
+
 	// The ad.Entries link can point to either a chain of EntryChunks or a HAMT.
-	// Sync the very first entry so that we can check which type it is.
-	// Note, this means the maximum depth of entries traversal will be 1 plus the configured max depth.
-	// TODO: See if it is worth detecting and reducing depth the depth in entries selectors by one.
-	syncedFirstEntryCid, err := ing.sub.Sync(ctx, publisherID, entriesCid, Selectors.One, nil)
+	// Sync the very first entry so that we can check which type it is. The
+	// entries selector used to sync the rest of a chain of EntryChunks has
+	// its recursion limit reduced by one to account for this. The scoped
+	// block hook keeps the general block hook from loading the entry as an
+	// advertisement.
+	syncedFirstEntryCid, err := ing.sub.Sync(ctx, publisherID, entriesCid, Selectors.One, nil,
+		legs.ScopedBlockHook(func(peer.ID, cid.Cid, legs.SegmentSyncActions) {}))
 	if err != nil {
-		return adIngestError{adIngestSyncEntriesErr, fmt.Errorf("failed to sync first entry while checking entries type: %w", err)}
+		if isTooManyEntries(err) {
+			return 0, adIngestError{adIngestOversizedChunkErr, fmt.Errorf("failed to sync first entry while checking entries type: %w", err)}
+		}
+		return 0, adIngestError{adIngestSyncEntriesErr, fmt.Errorf("failed to sync first entry while checking entries type: %w", err)}
 	}
 
-	node, err := ing.loadNode(syncedFirstEntryCid, basicnode.Prototype.Any)
+	// Limit the entries so that an oversized first chunk is not decoded in
+	// full while checking its type.
+	node, err := ing.loadNode(syncedFirstEntryCid, limitEntries(basicnode.Prototype.Any, ing.cfg.MaxEntriesPerChunk))
 	if err != nil {
-		return adIngestError{adIngestIndexerErr, fmt.Errorf("failed to load first entry after sync: %w", err)}
+		if errors.Is(err, errTooManyEntries) {
+			return 0, adIngestError{adIngestOversizedChunkErr, fmt.Errorf("failed to load first entry after sync: %w", err)}
+		}
+		return 0, adIngestError{adIngestIndexerErr, fmt.Errorf("failed to load first entry after sync: %w", err)}
 	}
 
 	var errsIngestingEntryChunks []error
+	// entryCount is the number of multihashes indexed from the entries.
+	var entryCount uint64
 	if isHAMT(node) {
 		log = log.With("entriesKind", "hamt")
 		// Keep track of all CIDs in the HAMT to remove them later when the processing is done.
@@ -245,7 +455,7 @@ func (ing *Ingester) ingestAd(publisherID peer.ID, adCid cid.Cid, ad schema.Adve
 		// Load the CID as HAMT root node.
 		hn, err := ing.loadHamt(syncedFirstEntryCid)
 		if err != nil {
-			return adIngestError{adIngestIndexerErr, fmt.Errorf("failed to load entries as HAMT root node: %w", err)}
+			return 0, adIngestError{adIngestIndexerErr, fmt.Errorf("failed to load entries as HAMT root node: %w", err)}
 		}
 
 		// Sync all the links in the hamt, since so far we have only synced the root.
@@ -259,7 +469,7 @@ func (ing *Ingester) ingestAd(publisherID peer.ID, adCid cid.Cid, ad schema.Adve
 					// TODO: see if segmented sync for HAMT makes sense and if so modify block hook action above appropriately.
 					legs.ScopedSegmentDepthLimit(-1))
 				if err != nil {
-					return adIngestError{adIngestSyncEntriesErr, fmt.Errorf("failed to sync remaining HAMT: %w", err)}
+					return 0, adIngestError{adIngestSyncEntriesErr, fmt.Errorf("failed to sync remaining HAMT: %w", err)}
 				}
 			}
 		}
@@ -274,11 +484,11 @@ func (ing *Ingester) ingestAd(publisherID peer.ID, adCid cid.Cid, ad schema.Adve
 		for !mi.Done() {
 			k, _, err := mi.Next()
 			if err != nil {
-				return adIngestError{adIngestIndexerErr, fmt.Errorf("faild to iterate through HAMT: %w", err)}
+				return 0, adIngestError{adIngestIndexerErr, fmt.Errorf("faild to iterate through HAMT: %w", err)}
 			}
 			ks, err := k.AsString()
 			if err != nil {
-				return adIngestError{adIngestMalformedErr, fmt.Errorf("HAMT key must be of type string: %w", err)}
+				return 0, adIngestError{adIngestMalformedErr, fmt.Errorf("HAMT key must be of type string: %w", err)}
 			}
 			mhs = append(mhs, multihash.Multihash(ks))
 			// Note that indexContentBlock also does batching with the same batchSize.
@@ -290,22 +500,28 @@ func (ing *Ingester) ingestAd(publisherID peer.ID, adCid cid.Cid, ad schema.Adve
 			// indexContentBlock.
 			// TODO: See how we can refactor code to make batching logic more flexible in indexContentBlock.
 			if len(mhs) >= int(ing.batchSize) {
-				err := ing.indexAdMultihashes(ad, mhs, log)
+				err := ing.indexAdMultihashes(ctx, ad, mhs, log)
 				if err != nil {
-					return adIngestError{adIngestIndexerErr, fmt.Errorf("failed to index content from HAMT: %w", err)}
+					return 0, adIngestError{adIngestIndexerErr, fmt.Errorf("failed to index content from HAMT: %w", err)}
 				}
+				entryCount += uint64(len(mhs))
 				mhs = nil
 			}
 		}
 		// Process any remaining multihashes from the batch cut-off.
 		if len(mhs) > 0 {
-			err := ing.indexAdMultihashes(ad, mhs, log)
+			err := ing.indexAdMultihashes(ctx, ad, mhs, log)
 			if err != nil {
-				return adIngestError{adIngestIndexerErr, fmt.Errorf("failed to index content from HAMT: %w", err)}
+				return 0, adIngestError{adIngestIndexerErr, fmt.Errorf("failed to index content from HAMT: %w", err)}
 			}
+			entryCount += uint64(len(mhs))
 		}
 	} else {
 		log = log.With("entriesKind", "EntryChunk")
+		// If indexing this ad was interrupted, then resume after the last
+		// indexed chunk, instead of fetching all of the chunks again.
+		resumeCid, resumeCount, resume := ing.adCheckpoint(ctx, adCid)
+
 		// We have already peaked the first EntryChunk as part of probing the entries type.
 		// So process that first
 		chunk, err := ing.loadEntryChunk(syncedFirstEntryCid)
@@ -315,37 +531,66 @@ func (ing *Ingester) ingestAd(publisherID peer.ID, adCid cid.Cid, ad schema.Adve
 			err = ing.ingestEntryChunk(ctx, ad, syncedFirstEntryCid, *chunk, log)
 			if err != nil {
 				errsIngestingEntryChunks = append(errsIngestingEntryChunks, err)
+			} else if !resume {
+				entryCount += uint64(len(chunk.Entries))
+				if chunk.Next != nil {
+					if err = ing.saveAdCheckpoint(ctx, adCid, chunk.Next.(cidlink.Link).Cid, entryCount); err != nil {
+						log.Errorw("Failed to save advertisement checkpoint", "err", err)
+					}
+				}
 			}
 		}
 
-		if chunk != nil && chunk.Next != nil {
-			nextChunkCid := chunk.Next.(cidlink.Link).Cid
+		nextChunkCid := cid.Undef
+		if resume {
+			log.Infow("Resuming interrupted indexing of entries", "nextChunkCid", resumeCid, "entriesIndexed", resumeCount)
+			nextChunkCid = resumeCid
+			entryCount = resumeCount
+		} else if chunk != nil && chunk.Next != nil {
+			nextChunkCid = chunk.Next.(cidlink.Link).Cid
+		}
+
+		entriesSel := ing.entriesSelector(providerID)
+		if nextChunkCid != cid.Undef && entriesSel == nil {
+			log.Infow("Entries depth limit reached after first entry chunk")
+		} else if nextChunkCid != cid.Undef {
+			// Index the remaining chunks on a separate goroutine, so that
+			// fetching the following chunks is not held up by writing to the
+			// value store.
+			pipe := ing.startChunkPipeline(ctx, adCid, ad, entryCount, log)
 			// Traverse remaining entry chunks based on the entries selector that limits recursion depth.
-			_, err = ing.sub.Sync(ctx, publisherID, nextChunkCid, ing.entriesSel, nil, legs.ScopedBlockHook(func(p peer.ID, c cid.Cid, actions legs.SegmentSyncActions) {
-				// Load CID as entry chunk since the selector should only select entry chunk nodes.
-				chunk, err := ing.loadEntryChunk(c)
-				if err != nil {
+			_, err = ing.sub.Sync(ctx, publisherID, nextChunkCid, entriesSel, nil, legs.ScopedBlockHook(func(p peer.ID, c cid.Cid, actions legs.SegmentSyncActions) {
+				// Stop fetching if indexing a previous chunk failed.
+				if err := pipe.err(); err != nil {
 					actions.FailSync(err)
-					errsIngestingEntryChunks = append(errsIngestingEntryChunks, err)
 					return
 				}
-				err = ing.ingestEntryChunk(ctx, ad, c, *chunk, log)
+				// Load CID as entry chunk since the selector should only select entry chunk nodes.
+				chunk, err := ing.loadEntryChunk(c)
 				if err != nil {
 					actions.FailSync(err)
-					errsIngestingEntryChunks = append(errsIngestingEntryChunks, err)
+					pipe.fail(err)
 					return
 				}
+				pipe.put(c, chunk)
 				if chunk.Next != nil {
 					actions.SetNextSyncCid(chunk.Next.(cidlink.Link).Cid)
 				} else {
 					actions.SetNextSyncCid(cid.Undef)
 				}
 			}))
-			if err != nil {
+			pipeCount, pipeErrs := pipe.wait()
+			entryCount += pipeCount
+			errsIngestingEntryChunks = append(errsIngestingEntryChunks, pipeErrs...)
+			// An oversized chunk fails the sync, but is reported as an error
+			// ingesting the chunk so that the ad is skipped.
+			if err != nil && isTooManyEntries(err) {
+				errsIngestingEntryChunks = append(errsIngestingEntryChunks, fmt.Errorf("failed to sync entries: %w", err))
+			} else if err != nil && !hasTooManyEntries(pipeErrs) {
 				if strings.Contains(err.Error(), "datatransfer failed: content not found") {
-					return adIngestError{adIngestContentNotFound, fmt.Errorf("failed to sync entries: %w", err)}
+					return 0, adIngestError{adIngestContentNotFound, fmt.Errorf("failed to sync entries: %w", err)}
 				}
-				return adIngestError{adIngestSyncEntriesErr, fmt.Errorf("failed to sync entries: %w", err)}
+				return 0, adIngestError{adIngestSyncEntriesErr, fmt.Errorf("failed to sync entries: %w", err)}
 			}
 		}
 	}
@@ -356,10 +601,157 @@ func (ing *Ingester) ingestAd(publisherID peer.ID, adCid cid.Cid, ad schema.Adve
 
 	ing.signalMetricsUpdate()
 
+	// If the value store is out of space, then the ad is retried later. Do
+	// not count its entries now, since they are all counted when it is
+	// retried. Entry chunks that were already indexed are skipped then.
+	for _, err := range errsIngestingEntryChunks {
+		if isOutOfSpace(err) {
+			return 0, adIngestError{adIngestOutOfSpaceErr, fmt.Errorf("failed to ingest entry chunks: %w", err)}
+		}
+	}
+
+	ing.updateProviderContext(providerID, ad, entryCount, adCid, log)
+
 	if len(errsIngestingEntryChunks) > 0 {
-		return adIngestError{adIngestEntryChunkErr, fmt.Errorf("failed to ingest entry chunks: %v", errsIngestingEntryChunks)}
+		if hasTooManyEntries(errsIngestingEntryChunks) {
+			return entryCount, adIngestError{adIngestOversizedChunkErr, fmt.Errorf("failed to ingest entry chunks: %v", errsIngestingEntryChunks)}
+		}
+		return entryCount, adIngestError{adIngestEntryChunkErr, fmt.Errorf("failed to ingest entry chunks: %v", errsIngestingEntryChunks)}
+	}
+	return entryCount, nil
+}
+
+// hasTooManyEntries returns true if any of the errors is from an entry chunk
+// with more than the maximum number of entries.
+func hasTooManyEntries(errs []error) bool {
+	for _, err := range errs {
+		if isTooManyEntries(err) {
+			return true
+		}
+	}
+	return false
+}
+
+// isTooManyEntries returns true if the error is from an entry chunk with more
+// than the maximum number of entries. An entry chunk that is rejected by the
+// link system fails the sync, and the sync error does not always wrap the
+// link system's error, so the error message is also checked.
+func isTooManyEntries(err error) bool {
+	return errors.Is(err, errTooManyEntries) || strings.Contains(err.Error(), errTooManyEntries.Error())
+}
+
+// updateProviderContext records the advertisement's context metadata, and the
+// number of multihashes indexed for the context, in the registry.
+func (ing *Ingester) updateProviderContext(providerID peer.ID, ad schema.Advertisement, entryCount uint64, adCid cid.Cid, log *zap.SugaredLogger) {
+	err := ing.reg.UpdateProviderContext(context.Background(), providerID, ad.ContextID, ad.Metadata, entryCount, adCid)
+	if err != nil {
+		log.Errorw("Failed to update provider context in registry", "err", err)
 	}
-	return nil
+}
+
+// chunkPipeline indexes the entry chunks of an advertisement, in the order
+// they are given, while the following chunks are being fetched.
+type chunkPipeline struct {
+	chunks chan chunkPipelineItem
+	done   chan struct{}
+	index  func(chunkPipelineItem)
+
+	// count is the number of multihashes indexed. It is only read after
+	// indexing has finished.
+	count uint64
+
+	errs  []error
+	mutex sync.Mutex
+}
+
+type chunkPipelineItem struct {
+	cid   cid.Cid
+	chunk *schema.EntryChunk
+}
+
+// startChunkPipeline starts a goroutine that indexes the chunks put into the
+// returned chunkPipeline. Up to the configured EntriesFetchAhead number of
+// chunks are buffered, after which put blocks until a chunk is indexed. If
+// fetching ahead is disabled, then put indexes each chunk before returning.
+//
+// After each chunk is indexed, the ad's checkpoint is set to the following
+// chunk. The baseCount is the number of multihashes indexed from the ad's
+// chunks before the pipeline started, and is included in the checkpoint.
+func (ing *Ingester) startChunkPipeline(ctx context.Context, adCid cid.Cid, ad schema.Advertisement, baseCount uint64, log *zap.SugaredLogger) *chunkPipeline {
+	pipe := &chunkPipeline{}
+	pipe.index = func(item chunkPipelineItem) {
+		// Do not index any chunks following one that failed.
+		if pipe.err() != nil {
+			return
+		}
+		err := ing.ingestEntryChunk(ctx, ad, item.cid, *item.chunk, log)
+		if err != nil {
+			pipe.fail(err)
+			return
+		}
+		pipe.count += uint64(len(item.chunk.Entries))
+		if item.chunk.Next != nil {
+			err = ing.saveAdCheckpoint(ctx, adCid, item.chunk.Next.(cidlink.Link).Cid, baseCount+pipe.count)
+			if err != nil {
+				log.Errorw("Failed to save advertisement checkpoint", "err", err)
+			}
+		}
+	}
+
+	if ing.cfg.EntriesFetchAhead < 1 {
+		return pipe
+	}
+
+	pipe.chunks = make(chan chunkPipelineItem, ing.cfg.EntriesFetchAhead)
+	pipe.done = make(chan struct{})
+	go func() {
+		defer close(pipe.done)
+		for item := range pipe.chunks {
+			pipe.index(item)
+		}
+	}()
+	return pipe
+}
+
+// put queues a chunk to be indexed.
+func (p *chunkPipeline) put(c cid.Cid, chunk *schema.EntryChunk) {
+	item := chunkPipelineItem{
+		cid:   c,
+		chunk: chunk,
+	}
+	if p.chunks == nil {
+		p.index(item)
+		return
+	}
+	p.chunks <- item
+}
+
+// fail records an error that stops indexing of any further chunks.
+func (p *chunkPipeline) fail(err error) {
+	p.mutex.Lock()
+	p.errs = append(p.errs, err)
+	p.mutex.Unlock()
+}
+
+// err returns the first error recorded, or nil if there was no error.
+func (p *chunkPipeline) err() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if len(p.errs) == 0 {
+		return nil
+	}
+	return p.errs[0]
+}
+
+// wait waits for all queued chunks to be indexed, and returns the number of
+// multihashes indexed and any errors that occurred. No chunks may be put after
+// calling wait.
+func (p *chunkPipeline) wait() (uint64, []error) {
+	if p.chunks != nil {
+		close(p.chunks)
+		<-p.done
+	}
+	return p.count, p.errs
 }
 
 // ingestEntryChunk ingests a block of entries as that block is received
@@ -381,20 +773,93 @@ func (ing *Ingester) ingestEntryChunk(ctx context.Context, ad schema.Advertiseme
 		}
 	}()
 
-	err := ing.indexAdMultihashes(ad, chunk.Entries, log)
+	value, isRm, err := getAdData(ad)
+	if err != nil {
+		return err
+	}
+
+	// If this chunk was already indexed with the same provider, context ID,
+	// and metadata, since the last removal of the context, then there is no
+	// need to index its multihashes again. Removals are always processed.
+	var marker []byte
+	if !isRm {
+		marker, err = ing.chunkMarker(ctx, value)
+		if err != nil {
+			log.Errorw("Cannot get entry chunk marker", "err", err)
+		} else if ing.chunkAlreadyProcessed(ctx, value, entryChunkCid, marker) {
+			log.Debugw("Skipping already indexed entry chunk", "chunkCid", entryChunkCid)
+			return nil
+		}
+
+		if ing.cfg.SkipPresentEntries && ing.entriesPresent(value, chunk.Entries) {
+			log.Debugw("Skipping entry chunk with multihashes already indexed", "chunkCid", entryChunkCid)
+			stats.Record(context.Background(), metrics.EntryChunksSkipped.M(1))
+			if marker != nil {
+				if err = ing.markChunkProcessed(ctx, value, entryChunkCid, marker); err != nil {
+					log.Errorw("Failed to mark entry chunk as processed", "err", err)
+				}
+			}
+			return nil
+		}
+	}
+
+	err = ing.indexAdMultihashes(ctx, ad, chunk.Entries, log)
 	if err != nil {
 		return fmt.Errorf("failed processing entries for advertisement: %w", err)
 	}
 
+	if marker != nil {
+		if err = ing.markChunkProcessed(ctx, value, entryChunkCid, marker); err != nil {
+			log.Errorw("Failed to mark entry chunk as processed", "err", err)
+		}
+	}
+
 	ing.signalMetricsUpdate()
 	return nil
 }
 
+// entriesPresent returns true if every multihash in mhs is already indexed
+// with a value equal to the given value.
+func (ing *Ingester) entriesPresent(value indexer.Value, mhs []multihash.Multihash) bool {
+	for _, mh := range mhs {
+		values, found, err := ing.indexer.Get(mh)
+		if err != nil || !found {
+			return false
+		}
+		var present bool
+		for i := range values {
+			if values[i].Equal(value) {
+				present = true
+				break
+			}
+		}
+		if !present {
+			return false
+		}
+	}
+	return true
+}
+
 // indexAdMultihashes indexes the content multihashes in a block of data. First
 // the advertisement is loaded to get the context ID and metadata. Then the
 // metadata and multihashes in the content block are indexed by the
 // indexer-core.
-func (ing *Ingester) indexAdMultihashes(ad schema.Advertisement, mhs []multihash.Multihash, log *zap.SugaredLogger) error {
+//
+// The context is checked before each batch is stored, so that cancelling it
+// stops indexing a large number of multihashes without waiting for all of
+// them to be stored.
+//
+// An entry may be a CIDv1 instead of a multihash, in which case the CID's
+// multihash is indexed. If Ingest.IndexCodecs is enabled, the CID codec of
+// each such entry is also recorded in the registry, so that find results can
+// be filtered by codec. If Ingest.IndexTimestamps is enabled, the time that
+// each multihash is indexed is also recorded in the registry.
+//
+// Entries that cannot be decoded as a multihash or CID are not indexed. If
+// Ingest.StrictMultihashValidation is enabled, entries that are not valid
+// multihashes are also not indexed. Entries that are not indexed are counted
+// for the provider.
+func (ing *Ingester) indexAdMultihashes(ctx context.Context, ad schema.Advertisement, mhs []multihash.Multihash, log *zap.SugaredLogger) error {
 
 	// Load the advertisement data for this chunk. If there are more chunks to
 	// follow, then cache the ad data.
@@ -419,22 +884,63 @@ func (ing *Ingester) indexAdMultihashes(ad schema.Advertisement, mhs []multihash
 	batch := make([]multihash.Multihash, 0, ing.batchSize)
 	var prevBatch []multihash.Multihash
 
+	// Multihashes advertised as CIDs, and their codecs.
+	var codecMhs []multihash.Multihash
+	var codecs []uint64
+	// Multihashes whose index times are recorded.
+	var timeMhs []multihash.Multihash
+
 	// Iterate over all entries and ingest (or remove) them.
-	var count, badMultihashCount int
+	var count, badMultihashCount, blockedCount int
 	for _, entry := range mhs {
-		if _, err = multihash.Decode(entry); err != nil {
-			// Only log first error to prevent log flooding.
-			if badMultihashCount == 0 {
-				log.Warnw("Ignoring bad multihash", "err", err)
+		var codec uint64
+		var dmh *multihash.DecodedMultihash
+		if dmh, err = multihash.Decode(entry); err != nil {
+			c, cidErr := cid.Cast(entry)
+			if cidErr != nil || c.Version() != 1 {
+				// Only log first error to prevent log flooding.
+				if badMultihashCount == 0 {
+					log.Warnw("Ignoring bad multihash", "err", err)
+				}
+				badMultihashCount++
+				continue
 			}
-			badMultihashCount++
+			entry = c.Hash()
+			codec = c.Prefix().Codec
+			if ing.cfg.StrictMultihashValidation {
+				dmh, _ = multihash.Decode(entry)
+			}
+		}
+		if ing.cfg.StrictMultihashValidation {
+			if err = validateMultihash(dmh); err != nil {
+				if badMultihashCount == 0 {
+					log.Warnw("Ignoring invalid multihash", "err", err, "multihash", entry.B58String())
+				}
+				badMultihashCount++
+				continue
+			}
+		}
+		// Do not index blocked multihashes. These are still removed, in case
+		// they were indexed before they were blocked.
+		if !isRm && ing.reg.MultihashBlocked(entry) {
+			blockedCount++
 			continue
 		}
+		if codec != 0 && ing.cfg.IndexCodecs {
+			codecMhs = append(codecMhs, entry)
+			codecs = append(codecs, codec)
+		}
+		if ing.cfg.IndexTimestamps {
+			timeMhs = append(timeMhs, entry)
+		}
 
 		batch = append(batch, entry)
 
 		// Process full batch of multihashes.
 		if len(batch) == cap(batch) {
+			if err = ctx.Err(); err != nil {
+				return ing.stopIndexing(batchChan, errChan, count, err, log)
+			}
 			select {
 			case batchChan <- batch:
 			case err = <-errChan:
@@ -451,10 +957,17 @@ func (ing *Ingester) indexAdMultihashes(ad schema.Advertisement, mhs []multihash
 	}
 	if badMultihashCount != 0 {
 		log.Warnw("Ignored bad multihashes", "ignored", badMultihashCount)
+		ing.countInvalidMultihashes(value.ProviderID, badMultihashCount)
+	}
+	if blockedCount != 0 {
+		log.Infow("Skipped blocked multihashes", "skipped", blockedCount)
 	}
 
 	// Process any remaining multihashes.
 	if len(batch) != 0 {
+		if err = ctx.Err(); err != nil {
+			return ing.stopIndexing(batchChan, errChan, count, err, log)
+		}
 		select {
 		case batchChan <- batch:
 		case err = <-errChan:
@@ -469,6 +982,28 @@ func (ing *Ingester) indexAdMultihashes(ad schema.Advertisement, mhs []multihash
 		return err
 	}
 
+	if len(codecMhs) != 0 {
+		if isRm {
+			err = ing.reg.RemoveCodecs(ctx, value.ProviderID, codecMhs)
+		} else {
+			err = ing.reg.PutCodecs(ctx, value.ProviderID, codecMhs, codecs)
+		}
+		if err != nil {
+			return fmt.Errorf("cannot store multihash codecs: %w", err)
+		}
+	}
+
+	if len(timeMhs) != 0 {
+		if isRm {
+			err = ing.reg.RemoveIndexTimes(ctx, value.ProviderID, value.ContextID, timeMhs)
+		} else {
+			err = ing.reg.PutIndexTimes(ctx, value.ProviderID, value.ContextID, timeMhs, time.Now())
+		}
+		if err != nil {
+			return fmt.Errorf("cannot store multihash index times: %w", err)
+		}
+	}
+
 	if isRm {
 		log.Infow("Removed multihashes in entry chunk", "count", count)
 	} else {
@@ -477,6 +1012,18 @@ func (ing *Ingester) indexAdMultihashes(ad schema.Advertisement, mhs []multihash
 	return nil
 }
 
+// stopIndexing stops the goroutine that stores batches of multihashes, after
+// it finishes storing the batch it has, and returns an error saying how many
+// multihashes were stored before indexing was cancelled.
+func (ing *Ingester) stopIndexing(batchChan chan []multihash.Multihash, errChan <-chan error, count int, ctxErr error, log *zap.SugaredLogger) error {
+	close(batchChan)
+	if err := <-errChan; err != nil {
+		return err
+	}
+	log.Warnw("Indexing multihashes cancelled", "stored", count)
+	return fmt.Errorf("indexing cancelled after storing %d multihashes: %w", count, ctxErr)
+}
+
 func (ing *Ingester) storeBatch(value indexer.Value, batch []multihash.Multihash, isRm bool) error {
 	if isRm {
 		if err := ing.indexer.Remove(value, batch...); err != nil {
@@ -490,21 +1037,34 @@ func (ing *Ingester) storeBatch(value indexer.Value, batch []multihash.Multihash
 	return nil
 }
 
+// loadAd returns the advertisement held in the datastore, decoding it unless
+// it is already in the advertisement cache. If the advertisement is held but
+// cannot be decoded, the error is an adIngestError with the
+// adIngestDecodingErr state, so that it can be told apart from an
+// advertisement that could not be fetched.
 func (ing *Ingester) loadAd(c cid.Cid) (schema.Advertisement, error) {
-	adn, err := ing.loadNode(c, schema.AdvertisementPrototype)
+	if ad, ok := ing.adCache.get(c); ok {
+		return ad, nil
+	}
+	val, err := ing.ds.Get(context.Background(), datastore.NewKey(c.String()))
+	if err != nil {
+		return schema.Advertisement{}, fmt.Errorf("cannot fetch advertisement from datastore: %w", err)
+	}
+	adn, err := decodeIPLDNode(c.Prefix().Codec, bytes.NewBuffer(val), schema.AdvertisementPrototype)
 	if err != nil {
-		return schema.Advertisement{}, fmt.Errorf("cannot decode ipld node: %w", err)
+		return schema.Advertisement{}, adIngestError{adIngestDecodingErr, fmt.Errorf("cannot decode ipld node: %w", err)}
 	}
 	ad, err := schema.UnwrapAdvertisement(adn)
 	if err != nil {
-		return schema.Advertisement{}, fmt.Errorf("cannot decode advertisement: %w", err)
+		return schema.Advertisement{}, adIngestError{adIngestDecodingErr, fmt.Errorf("cannot decode advertisement: %w", err)}
 	}
 
+	ing.adCache.add(c, *ad)
 	return *ad, nil
 }
 
 func (ing *Ingester) loadEntryChunk(c cid.Cid) (*schema.EntryChunk, error) {
-	node, err := ing.loadNode(c, schema.EntryChunkPrototype)
+	node, err := ing.loadNode(c, limitEntries(schema.EntryChunkPrototype, ing.cfg.MaxEntriesPerChunk))
 	if err != nil {
 		return nil, fmt.Errorf("failed to decode ipldNode: %w", err)
 	}
@@ -582,3 +1142,49 @@ func isHAMT(n ipld.Node) bool {
 	h, _ := n.LookupByString("hamt")
 	return h != nil
 }
+
+// makeKeyTypeSet returns the set of key types named in keyTypeNames. Names
+// are matched without regard to case. Returns nil if there are no names.
+func makeKeyTypeSet(keyTypeNames []string) (map[pb.KeyType]struct{}, error) {
+	if len(keyTypeNames) == 0 {
+		return nil, nil
+	}
+	keyTypes := make(map[pb.KeyType]struct{}, len(keyTypeNames))
+	for _, name := range keyTypeNames {
+		var found bool
+		for typeName, keyType := range pb.KeyType_value {
+			if strings.EqualFold(name, typeName) {
+				keyTypes[pb.KeyType(keyType)] = struct{}{}
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown key type %q in AllowedKeyTypes", name)
+		}
+	}
+	return keyTypes, nil
+}
+
+// checkAdProvider checks that the provider in an advertisement is allowed by
+// policy, and that the publisher is allowed to publish advertisements for the
+// provider.
+func (ing *Ingester) checkAdProvider(publisherID, providerID peer.ID) error {
+	if !ing.reg.Allowed(providerID) {
+		return fmt.Errorf("provider %s is not allowed", providerID)
+	}
+	if publisherID == providerID {
+		return nil
+	}
+	if snapshotProvider, ok := ing.snapshotPublisher(publisherID); ok && snapshotProvider == providerID {
+		// A snapshot of the provider's chain, ingested by an administrator.
+		return nil
+	}
+	if ing.cfg.RequirePublisherIsProvider {
+		return fmt.Errorf("publisher %s is not the advertisement provider %s", publisherID, providerID)
+	}
+	if !ing.reg.PublishAllowed(publisherID, providerID) {
+		return fmt.Errorf("publisher %s cannot publish for provider %s", publisherID, providerID)
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package ingest
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/filecoin-project/storetheindex/internal/metrics"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/multiformats/go-multihash"
+	"go.opencensus.io/stats"
+)
+
+// maxVariableDigestLen is the longest digest accepted by strict multihash
+// validation for the identity function and for hash functions with variable
+// length output.
+const maxVariableDigestLen = 128
+
+// validateMultihash checks that a decoded multihash uses a known hash
+// function, and has a digest length that the function can produce. A digest
+// may be shorter than the function's output, since truncated digests are
+// allowed.
+func validateMultihash(dmh *multihash.DecodedMultihash) error {
+	if _, ok := multihash.Codes[dmh.Code]; !ok {
+		return fmt.Errorf("unknown hash function code 0x%x", dmh.Code)
+	}
+	if dmh.Length == 0 {
+		return fmt.Errorf("empty %s digest", dmh.Name)
+	}
+	switch dmh.Code {
+	case multihash.IDENTITY, multihash.BLAKE3, multihash.SHAKE_128, multihash.SHAKE_256:
+		if dmh.Length > maxVariableDigestLen {
+			return fmt.Errorf("%s digest length %d is more than %d", dmh.Name, dmh.Length, maxVariableDigestLen)
+		}
+		return nil
+	}
+	maxLen, ok := multihash.DefaultLengths[dmh.Code]
+	if ok && dmh.Length > maxLen {
+		return fmt.Errorf("%s digest length %d is more than %d", dmh.Name, dmh.Length, maxLen)
+	}
+	return nil
+}
+
+// countInvalidMultihashes adds to the number of entries from the provider
+// that were not indexed because they are not valid multihashes.
+func (ing *Ingester) countInvalidMultihashes(providerID peer.ID, count int) {
+	stats.Record(context.Background(), metrics.InvalidMultihashes.M(int64(count)))
+	ing.invalidMhMutex.Lock()
+	if ing.invalidMhCounts == nil {
+		ing.invalidMhCounts = make(map[peer.ID]uint64)
+	}
+	ing.invalidMhCounts[providerID] += uint64(count)
+	ing.invalidMhMutex.Unlock()
+}
+
+// InvalidMultihashCount returns the number of entries from the provider that
+// were not indexed since the indexer started, because they are not valid
+// multihashes.
+func (ing *Ingester) InvalidMultihashCount(providerID peer.ID) uint64 {
+	ing.invalidMhMutex.Lock()
+	defer ing.invalidMhMutex.Unlock()
+	return ing.invalidMhCounts[providerID]
+}
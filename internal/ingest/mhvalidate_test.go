@@ -0,0 +1,40 @@
+package ingest
+
+import (
+	"testing"
+
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateMultihash(t *testing.T) {
+	tests := []struct {
+		name   string
+		code   uint64
+		length int
+		valid  bool
+	}{
+		{"sha2-256", multihash.SHA2_256, 32, true},
+		{"truncated sha2-256", multihash.SHA2_256, 20, true},
+		{"long sha2-256", multihash.SHA2_256, 33, false},
+		{"empty sha2-256", multihash.SHA2_256, 0, false},
+		{"identity", multihash.IDENTITY, 64, true},
+		{"long identity", multihash.IDENTITY, maxVariableDigestLen + 1, false},
+		{"blake3", multihash.BLAKE3, 64, true},
+		{"unknown", 0x300000, 32, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mh, err := multihash.Encode(make([]byte, tt.length), tt.code)
+			require.NoError(t, err)
+			dmh, err := multihash.Decode(mh)
+			require.NoError(t, err)
+			err = validateMultihash(dmh)
+			if tt.valid {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err)
+			}
+		})
+	}
+}
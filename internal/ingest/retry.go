@@ -0,0 +1,174 @@
+package ingest
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/filecoin-project/go-legs"
+	adminmodel "github.com/filecoin-project/storetheindex/api/v0/admin/model"
+	"github.com/filecoin-project/storetheindex/api/v0/ingest/schema"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+type failedAd struct {
+	cid     cid.Cid
+	failure adFailure
+}
+
+// RetryFailedAds queues the advertisements that failed to ingest to be
+// ingested again by the worker pool. If provider is not empty, then only the
+// advertisements of that provider, or of its aliases, are retried.
+//
+// Each failed advertisement is marked unprocessed and staged for its
+// provider, after any advertisements that are already staged. An
+// advertisement that was skipped because of a permanent error is no longer
+// held by the indexer, so is fetched from its publisher again. Retrying an
+// advertisement does not change the latest sync for its publisher.
+// Advertisements that failed before their publisher was recorded cannot be
+// retried, and are skipped.
+func (ing *Ingester) RetryFailedAds(ctx context.Context, provider peer.ID) (adminmodel.FailureRetry, error) {
+	var summary adminmodel.FailureRetry
+
+	if provider != "" {
+		provider = ing.reg.CanonicalProvider(provider)
+	}
+	failed, err := ing.failedAds(ctx, provider)
+	if err != nil {
+		return summary, err
+	}
+
+	publishers := make(map[peer.ID]peer.ID)
+	adsByProvider := make(map[peer.ID][]adInfo)
+	for _, f := range failed {
+		log := log.With("adCid", f.cid, "publisher", f.failure.Publisher)
+		if f.failure.Publisher == "" {
+			log.Warn("Cannot retry failed advertisement with unknown publisher")
+			summary.Skipped++
+			continue
+		}
+		ad, err := ing.loadFailedAd(ctx, f.cid, f.failure.Publisher)
+		if err != nil {
+			log.Warnw("Cannot load failed advertisement to retry", "err", err)
+			summary.Skipped++
+			continue
+		}
+		providerID, err := peer.Decode(ad.Provider)
+		if err != nil {
+			log.Warnw("Cannot get provider of failed advertisement to retry", "err", err)
+			summary.Skipped++
+			continue
+		}
+		providerID = ing.reg.CanonicalProvider(providerID)
+		if provider != "" && providerID != provider {
+			continue
+		}
+		if err = ing.markAdUnprocessed(f.cid); err != nil {
+			return summary, fmt.Errorf("cannot mark advertisement unprocessed: %w", err)
+		}
+		// Stage the ads from all the failures of a provider together, keeping
+		// the publisher of the latest failure.
+		if _, ok := publishers[providerID]; !ok {
+			publishers[providerID] = f.failure.Publisher
+		}
+		adsByProvider[providerID] = append(adsByProvider[providerID], adInfo{
+			cid:   f.cid,
+			ad:    ad,
+			retry: true,
+		})
+		summary.Requeued++
+	}
+
+	for p, adInfos := range adsByProvider {
+		ing.stageRetry(publishers[p], p, adInfos)
+	}
+	log.Infow("Retrying failed advertisements", "provider", provider, "requeued", summary.Requeued, "skipped", summary.Skipped)
+	return summary, nil
+}
+
+// failedAds reads the recorded advertisement failures, newest first. If
+// provider is not empty, then failures recorded for other providers are
+// omitted.
+func (ing *Ingester) failedAds(ctx context.Context, provider peer.ID) ([]failedAd, error) {
+	results, err := ing.ds.Query(ctx, query.Query{Prefix: adFailedPrefix})
+	if err != nil {
+		return nil, fmt.Errorf("cannot query failed advertisements: %w", err)
+	}
+	defer results.Close()
+
+	var failed []failedAd
+	for r := range results.Next() {
+		if r.Error != nil {
+			return nil, fmt.Errorf("cannot read failed advertisement: %w", r.Error)
+		}
+		adCid, err := cid.Decode(strings.TrimPrefix(r.Key, adFailedPrefix))
+		if err != nil {
+			log.Errorw("Cannot decode failed advertisement cid", "key", r.Key, "err", err)
+			continue
+		}
+		failure := decodeAdFailure(r.Value)
+		// A failure without a provider is checked once the ad is loaded.
+		if provider != "" && failure.Provider != "" && failure.Provider != provider {
+			continue
+		}
+		failed = append(failed, failedAd{
+			cid:     adCid,
+			failure: failure,
+		})
+	}
+
+	// Ads are staged newest first, and a failure is recorded when an ad is
+	// ingested, which is in chain order.
+	sort.SliceStable(failed, func(i, j int) bool {
+		return failed[i].failure.Time.After(failed[j].failure.Time)
+	})
+	return failed, nil
+}
+
+// loadFailedAd loads a failed advertisement, first fetching it from the
+// publisher if it is no longer held in the datastore.
+func (ing *Ingester) loadFailedAd(ctx context.Context, adCid cid.Cid, publisher peer.ID) (schema.Advertisement, error) {
+	held, err := ing.ds.Has(ctx, datastore.NewKey(adCid.String()))
+	if err != nil {
+		return schema.Advertisement{}, err
+	}
+	if !held {
+		// Syncing a specific ad with a selector does not update the latest
+		// sync, so the synced ad is not staged by runIngestStep. The scoped
+		// block hook stops the sync at this ad.
+		sel := legs.ExploreRecursiveWithStopNode(recursionLimit(1), Selectors.AdSequence, nil)
+		unprotect := ing.protectPeer(publisher)
+		_, err = ing.sub.Sync(ctx, publisher, adCid, sel, ing.httpSyncAddrs[publisher],
+			legs.ScopedBlockHook(func(_ peer.ID, _ cid.Cid, actions legs.SegmentSyncActions) {
+				actions.SetNextSyncCid(cid.Undef)
+			}))
+		unprotect()
+		if err != nil {
+			return schema.Advertisement{}, fmt.Errorf("cannot fetch advertisement from publisher: %w", err)
+		}
+	}
+	return ing.loadAd(adCid)
+}
+
+// stageRetry stages retried ads for the provider, after any ads that are
+// already staged, and schedules a worker to ingest them.
+func (ing *Ingester) stageRetry(publisher, provider peer.ID, adInfos []adInfo) {
+	ing.providersBeingProcessedMu.Lock()
+	if _, ok := ing.providersBeingProcessed[provider]; !ok {
+		ing.providersBeingProcessed[provider] = make(chan struct{}, 1)
+	}
+	if _, ok := ing.providerAdChainStaging[provider]; !ok {
+		ing.providerAdChainStaging[provider] = &atomic.Value{}
+	}
+	ing.providersBeingProcessedMu.Unlock()
+
+	ing.requeueAds(workerAssignment{
+		publisher: publisher,
+		provider:  provider,
+	}, adInfos, 0)
+}
@@ -0,0 +1,200 @@
+package ingest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/filecoin-project/go-legs/dtsync"
+	"github.com/filecoin-project/storetheindex/api/v0/ingest/schema"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// IngestSnapshot ingests an advertisement chain, and the entries of its
+// advertisements, from a local copy of a provider's published blocks, such as
+// blocks read from a directory of CAR files. This rebuilds the provider's
+// index when the provider is not online.
+//
+// The blocks must be keyed by CID string, as written by importer.ReadCar. They
+// are served by a temporary publisher on the local host, and are synced and
+// ingested in the same way as blocks synced from the provider, so
+// advertisement signatures are verified and already processed advertisements
+// are skipped. If head is cid.Undef, then the head of the chain is the only
+// advertisement that no other advertisement in the snapshot links to.
+//
+// The head advertisement must be for the given provider. The temporary
+// publisher is allowed to publish for the provider, but does not replace the
+// provider's registered publisher. Returns the latest advertisement that was
+// processed, which is head if the whole chain was ingested.
+func (ing *Ingester) IngestSnapshot(ctx context.Context, providerID peer.ID, blockStore datastore.Batching, head cid.Cid) (cid.Cid, error) {
+	var err error
+	if head == cid.Undef {
+		head, err = snapshotHead(ctx, blockStore)
+		if err != nil {
+			return cid.Undef, err
+		}
+	}
+	lsys := mkSnapshotLinkSystem(blockStore)
+	n, err := lsys.Load(ipld.LinkContext{Ctx: ctx}, cidlink.Link{Cid: head}, schema.AdvertisementPrototype)
+	if err != nil {
+		return cid.Undef, fmt.Errorf("cannot load head advertisement %s: %w", head, err)
+	}
+	ad, err := schema.UnwrapAdvertisement(n)
+	if err != nil {
+		return cid.Undef, fmt.Errorf("head %s is not an advertisement: %w", head, err)
+	}
+	if ad.Provider != providerID.String() {
+		return cid.Undef, fmt.Errorf("head advertisement %s is for provider %s, not %s", head, ad.Provider, providerID)
+	}
+	log := log.With("provider", providerID, "head", head)
+
+	// The head may already be processed, when synced from the provider or from
+	// an earlier snapshot. Then there is nothing to ingest, and no
+	// advertisement would be processed to end the sync.
+	if ing.adAlreadyProcessed(head) {
+		log.Infow("Snapshot head advertisement already processed")
+		return head, nil
+	}
+
+	pubHost, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	if err != nil {
+		return cid.Undef, fmt.Errorf("cannot create snapshot publisher host: %w", err)
+	}
+	defer pubHost.Close()
+
+	// The publisher keeps its data-transfer state in a separate datastore from
+	// the snapshot blocks.
+	pubDS := dssync.MutexWrap(datastore.NewMapDatastore())
+	pub, err := dtsync.NewPublisher(pubHost, pubDS, lsys, ing.cfg.PubSubTopic)
+	if err != nil {
+		return cid.Undef, fmt.Errorf("cannot create snapshot publisher: %w", err)
+	}
+	defer pub.Close()
+	if err = pub.SetRoot(ctx, head); err != nil {
+		return cid.Undef, err
+	}
+
+	pubID := pubHost.ID()
+	ing.snapshotPublishers.Store(pubID, providerID)
+	defer func() {
+		ing.snapshotPublishers.Delete(pubID)
+		// Forget the temporary publisher, so that nothing is kept for it.
+		if err := ing.removePublisher(context.Background(), pubID); err != nil {
+			log.Errorw("Cannot remove snapshot publisher", "err", err)
+		}
+	}()
+
+	ing.host.Peerstore().AddAddrs(pubID, pubHost.Addrs(), time.Hour)
+	log.Infow("Ingesting advertisement chain from snapshot", "publisher", pubID)
+
+	// Sync the whole chain, regardless of the configured depth limit, since
+	// the snapshot holds the provider's full dataset.
+	syncDone, err := ing.Sync(ctx, pubID, nil, -1, false)
+	if err != nil {
+		return cid.Undef, err
+	}
+	select {
+	case _, ok := <-syncDone:
+		if !ok {
+			return cid.Undef, errors.New("snapshot sync did not complete")
+		}
+	case <-ctx.Done():
+		return cid.Undef, ctx.Err()
+	}
+
+	latest, err := ing.GetLatestSync(pubID)
+	if err != nil {
+		return cid.Undef, err
+	}
+	log.Infow("Finished ingesting advertisement chain from snapshot", "latestProcessed", latest)
+	return latest, nil
+}
+
+// snapshotPublisher returns the provider that the publisher serves a snapshot
+// for, if the publisher is a temporary snapshot publisher.
+func (ing *Ingester) snapshotPublisher(publisherID peer.ID) (peer.ID, bool) {
+	v, ok := ing.snapshotPublishers.Load(publisherID)
+	if !ok {
+		return "", false
+	}
+	return v.(peer.ID), true
+}
+
+// snapshotHead finds the advertisement in the datastore that no other
+// advertisement links to as its previous advertisement.
+func snapshotHead(ctx context.Context, blockStore datastore.Batching) (cid.Cid, error) {
+	results, err := blockStore.Query(ctx, query.Query{KeysOnly: true})
+	if err != nil {
+		return cid.Undef, err
+	}
+	defer results.Close()
+
+	lsys := mkSnapshotLinkSystem(blockStore)
+	ads := make(map[cid.Cid]struct{})
+	prevs := make(map[cid.Cid]struct{})
+	for result := range results.Next() {
+		if result.Error != nil {
+			return cid.Undef, result.Error
+		}
+		c, err := cid.Decode(datastore.RawKey(result.Key).BaseNamespace())
+		if err != nil {
+			continue
+		}
+		n, err := lsys.Load(ipld.LinkContext{Ctx: ctx}, cidlink.Link{Cid: c}, schema.AdvertisementPrototype)
+		if err != nil {
+			continue
+		}
+		ad, err := schema.UnwrapAdvertisement(n)
+		if err != nil {
+			continue
+		}
+		ads[c] = struct{}{}
+		if ad.PreviousID != nil {
+			prevs[ad.PreviousID.(cidlink.Link).Cid] = struct{}{}
+		}
+	}
+
+	var heads []cid.Cid
+	for c := range ads {
+		if _, ok := prevs[c]; !ok {
+			heads = append(heads, c)
+		}
+	}
+	switch len(heads) {
+	case 0:
+		return cid.Undef, errors.New("no advertisement chain found in snapshot")
+	case 1:
+		return heads[0], nil
+	}
+	return cid.Undef, fmt.Errorf("snapshot has %d advertisement chain heads, head must be specified", len(heads))
+}
+
+// mkSnapshotLinkSystem returns a link system that reads and writes blocks
+// keyed by CID string.
+func mkSnapshotLinkSystem(ds datastore.Batching) ipld.LinkSystem {
+	lsys := cidlink.DefaultLinkSystem()
+	lsys.StorageReadOpener = func(lctx ipld.LinkContext, lnk ipld.Link) (io.Reader, error) {
+		val, err := ds.Get(lctx.Ctx, datastore.NewKey(lnk.(cidlink.Link).Cid.String()))
+		if err != nil {
+			return nil, err
+		}
+		return bytes.NewBuffer(val), nil
+	}
+	lsys.StorageWriteOpener = func(lctx ipld.LinkContext) (io.Writer, ipld.BlockWriteCommitter, error) {
+		buf := bytes.NewBuffer(nil)
+		return buf, func(lnk ipld.Link) error {
+			return ds.Put(lctx.Ctx, datastore.NewKey(lnk.(cidlink.Link).Cid.String()), buf.Bytes())
+		}, nil
+	}
+	return lsys
+}
@@ -0,0 +1,181 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/storetheindex/internal/safemode"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+)
+
+// syncDataStallTimeout is how long a block write waits for space when no
+// space has been freed. If processing frees no space for this long, then
+// pausing does not help, so block writes continue over the limit.
+const syncDataStallTimeout = time.Minute
+
+// syncDataStore is a datastore that keeps track of the total size of the
+// blocks in it. These are the advertisements and entries fetched by syncs,
+// which are held until they are processed. Blocks must be written and
+// removed with Put and Delete, not with a batch, to be counted. Sizes are
+// only tracked when there is a limit.
+type syncDataStore struct {
+	datastore.Batching
+	// limit is the size of held blocks above which block writes wait for
+	// space. Zero means no limit, and no tracking.
+	limit        int64
+	stallTimeout time.Duration
+
+	mutex sync.Mutex
+	used  int64
+	// freed is closed, and replaced, when space is freed.
+	freed     chan struct{}
+	lastFreed time.Time
+}
+
+// newSyncDataStore wraps ds to track the size of blocks held in ds, starting
+// with the size of the blocks that are already there. If limit is zero, then
+// ds is not scanned and sizes are not tracked.
+func newSyncDataStore(ctx context.Context, ds datastore.Batching, limit int64, safeMode *safemode.Skipper) (*syncDataStore, error) {
+	var used int64
+	if limit > 0 {
+		sizes, err := blockSizes(ctx, ds, safeMode)
+		if err != nil {
+			return nil, err
+		}
+		for _, size := range sizes {
+			used += size
+		}
+	}
+	return &syncDataStore{
+		Batching:     ds,
+		limit:        limit,
+		stallTimeout: syncDataStallTimeout,
+		used:         used,
+		freed:        make(chan struct{}),
+		lastFreed:    time.Now(),
+	}, nil
+}
+
+// tracked returns true if the size of the value at key is counted.
+func (s *syncDataStore) tracked(key datastore.Key) bool {
+	return s.limit > 0 && isBlockKey(key)
+}
+
+// Put stores the value, and counts its size if the key is the key of a block.
+func (s *syncDataStore) Put(ctx context.Context, key datastore.Key, value []byte) error {
+	if !s.tracked(key) {
+		return s.Batching.Put(ctx, key, value)
+	}
+	prevSize, err := s.Batching.GetSize(ctx, key)
+	if err != nil {
+		if !errors.Is(err, datastore.ErrNotFound) {
+			return err
+		}
+		prevSize = 0
+	}
+	if err = s.Batching.Put(ctx, key, value); err != nil {
+		return err
+	}
+	s.update(int64(len(value) - prevSize))
+	return nil
+}
+
+// Delete removes the value, and stops counting its size if the key is the key
+// of a block.
+func (s *syncDataStore) Delete(ctx context.Context, key datastore.Key) error {
+	if !s.tracked(key) {
+		return s.Batching.Delete(ctx, key)
+	}
+	size, err := s.Batching.GetSize(ctx, key)
+	if err != nil {
+		if errors.Is(err, datastore.ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+	if err = s.Batching.Delete(ctx, key); err != nil {
+		return err
+	}
+	s.update(-int64(size))
+	return nil
+}
+
+// update adds delta to the size of held blocks, and wakes any block writes
+// waiting for space if space was freed.
+func (s *syncDataStore) update(delta int64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.used += delta
+	if delta < 0 {
+		close(s.freed)
+		s.freed = make(chan struct{})
+		s.lastFreed = time.Now()
+	}
+}
+
+// usage returns the total size of the blocks held in the datastore, or zero if
+// sizes are not tracked.
+func (s *syncDataStore) usage() int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.used
+}
+
+// waitForSpace waits until the size of held blocks is under the limit. This
+// is a soft limit: a write that is waiting is allowed to continue if no space
+// has been freed for the stall timeout, since that means no blocks are being
+// processed.
+func (s *syncDataStore) waitForSpace(ctx context.Context) error {
+	if s.limit <= 0 {
+		return nil
+	}
+	var timer *time.Timer
+	for {
+		s.mutex.Lock()
+		used := s.used
+		freed := s.freed
+		stalled := time.Since(s.lastFreed)
+		s.mutex.Unlock()
+
+		if used < s.limit {
+			break
+		}
+		if stalled >= s.stallTimeout {
+			log.Warnw("Sync data limit exceeded but no space is being freed, continuing sync", "used", used, "limit", s.limit)
+			break
+		}
+		if timer == nil {
+			log.Infow("Sync data limit exceeded, pausing sync until space is freed", "used", used, "limit", s.limit)
+			timer = time.NewTimer(s.stallTimeout - stalled)
+		} else {
+			timer.Reset(s.stallTimeout - stalled)
+		}
+		select {
+		case <-freed:
+			if !timer.Stop() {
+				<-timer.C
+			}
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+	if timer != nil {
+		timer.Stop()
+	}
+	return nil
+}
+
+// isBlockKey returns true if the key is the key of a block, which is the
+// string form of the block's CID.
+func isBlockKey(key datastore.Key) bool {
+	if len(key.Namespaces()) != 1 {
+		return false
+	}
+	_, err := cid.Decode(key.BaseNamespace())
+	return err == nil
+}
@@ -0,0 +1,95 @@
+package ingest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/storetheindex/test/util"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSyncDataStore(t *testing.T) {
+	ctx := context.Background()
+	mds := dssync.MutexWrap(datastore.NewMapDatastore())
+	var keys []datastore.Key
+	for _, mh := range util.RandomMultihashes(3, rng) {
+		keys = append(keys, datastore.NewKey(cid.NewCidV1(cid.Raw, mh).String()))
+	}
+
+	// Blocks already in the datastore are counted.
+	oldKey := keys[0]
+	require.NoError(t, mds.Put(ctx, oldKey, make([]byte, 10)))
+	s, err := newSyncDataStore(ctx, mds, 100, nil)
+	require.NoError(t, err)
+	require.Equal(t, int64(10), s.usage())
+
+	// Only blocks are counted.
+	require.NoError(t, s.Put(ctx, datastore.NewKey(syncPrefix+"somepeer"), make([]byte, 50)))
+	require.Equal(t, int64(10), s.usage())
+	blockKey := keys[1]
+	require.NoError(t, s.Put(ctx, blockKey, make([]byte, 60)))
+	require.Equal(t, int64(70), s.usage())
+	// Writing the same block again does not count it twice.
+	require.NoError(t, s.Put(ctx, blockKey, make([]byte, 60)))
+	require.Equal(t, int64(70), s.usage())
+	require.NoError(t, s.waitForSpace(ctx))
+
+	bigKey := keys[2]
+	require.NoError(t, s.Put(ctx, bigKey, make([]byte, 40)))
+	require.Equal(t, int64(110), s.usage())
+
+	// Over the limit, waiting continues until space is freed.
+	done := make(chan error)
+	go func() {
+		done <- s.waitForSpace(ctx)
+	}()
+	select {
+	case <-done:
+		t.Fatal("did not wait for space")
+	case <-time.After(50 * time.Millisecond):
+	}
+	require.NoError(t, s.Delete(ctx, bigKey))
+	require.Equal(t, int64(70), s.usage())
+	select {
+	case err = <-done:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("still waiting after space was freed")
+	}
+
+	// Removing a block that is not there does not change the size.
+	require.NoError(t, s.Delete(ctx, bigKey))
+	require.Equal(t, int64(70), s.usage())
+
+	// Waiting is cancelled with the context.
+	require.NoError(t, s.Put(ctx, bigKey, make([]byte, 40)))
+	cctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, s.waitForSpace(cctx), context.DeadlineExceeded)
+
+	// Waiting stops if no space is freed for the stall timeout.
+	s.stallTimeout = 100 * time.Millisecond
+	require.NoError(t, s.waitForSpace(ctx))
+}
+
+func TestSyncDataStoreNoLimit(t *testing.T) {
+	ctx := context.Background()
+	mds := dssync.MutexWrap(datastore.NewMapDatastore())
+	mh := util.RandomMultihashes(1, rng)[0]
+	key := datastore.NewKey(cid.NewCidV1(cid.Raw, mh).String())
+	require.NoError(t, mds.Put(ctx, key, make([]byte, 10)))
+
+	// Without a limit, sizes are not tracked.
+	s, err := newSyncDataStore(ctx, mds, 0, nil)
+	require.NoError(t, err)
+	require.Zero(t, s.usage())
+	require.NoError(t, s.Put(ctx, key, make([]byte, 60)))
+	require.Zero(t, s.usage())
+	require.NoError(t, s.Delete(ctx, key))
+	require.Zero(t, s.usage())
+	require.NoError(t, s.waitForSpace(ctx))
+}
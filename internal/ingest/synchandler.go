@@ -1,20 +1,26 @@
 package ingest
 
 import (
+	"sync"
+
 	"github.com/ipfs/go-cid"
 	"github.com/libp2p/go-libp2p-core/peer"
 )
 
 type syncHandler struct {
 	ing *Ingester
+	// reported holds the latest sync that go-legs reported for each peer,
+	// for diagnostics only.
+	reported sync.Map
 }
 
-func (h *syncHandler) SetLatestSync(peer.ID, cid.Cid) {
-	// NOOP
+func (h *syncHandler) SetLatestSync(p peer.ID, c cid.Cid) {
 	// We don't care what go-legs tells us about it's latest sync, we are using
 	// the latestSync mechanism to traverse to the last processed Ad.
 	// This means that the only thing that sets our notion of latest sync is
-	// marking an ad as processed.
+	// marking an ad as processed. The reported value is only kept so that it
+	// can be compared with our latest sync.
+	h.reported.Store(p, c)
 }
 
 func (h *syncHandler) GetLatestSync(p peer.ID) (cid.Cid, bool) {
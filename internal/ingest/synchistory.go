@@ -0,0 +1,60 @@
+package ingest
+
+import (
+	"context"
+	"encoding/json"
+
+	adminmodel "github.com/filecoin-project/storetheindex/api/v0/admin/model"
+	"github.com/ipfs/go-datastore"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+func syncHistoryKey(provider peer.ID) datastore.Key {
+	return datastore.NewKey(syncHistoryPrefix + provider.String())
+}
+
+// recordSyncSummary adds the summary to the provider's sync history, and
+// removes the oldest summaries so that no more than the configured number are
+// kept. Only one worker ingests a provider's advertisements at a time, so the
+// provider's history is not written concurrently.
+func (ing *Ingester) recordSyncSummary(provider peer.ID, summary adminmodel.SyncSummary) {
+	if ing.cfg.SyncHistoryLength < 1 {
+		return
+	}
+	ctx := context.Background()
+	history, err := ing.SyncHistory(ctx, provider)
+	if err != nil {
+		log.Errorw("Cannot read sync history", "provider", provider, "err", err)
+		return
+	}
+	history = append([]adminmodel.SyncSummary{summary}, history...)
+	if len(history) > ing.cfg.SyncHistoryLength {
+		history = history[:ing.cfg.SyncHistoryLength]
+	}
+	value, err := json.Marshal(history)
+	if err != nil {
+		log.Errorw("Cannot encode sync history", "provider", provider, "err", err)
+		return
+	}
+	if err = ing.ds.Put(ctx, syncHistoryKey(provider), value); err != nil {
+		log.Errorw("Cannot write sync history to datastore", "provider", provider, "err", err)
+	}
+}
+
+// SyncHistory returns the summaries of the most recent runs of ingesting the
+// provider's advertisements, newest first. The number of summaries kept for
+// each provider is set by the SyncHistoryLength config.
+func (ing *Ingester) SyncHistory(ctx context.Context, provider peer.ID) ([]adminmodel.SyncSummary, error) {
+	value, err := ing.ds.Get(ctx, syncHistoryKey(provider))
+	if err != nil {
+		if err == datastore.ErrNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var history []adminmodel.SyncSummary
+	if err = json.Unmarshal(value, &history); err != nil {
+		return nil, err
+	}
+	return history, nil
+}
@@ -0,0 +1,93 @@
+package ingest
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	adminmodel "github.com/filecoin-project/storetheindex/api/v0/admin/model"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+const (
+	syncStageSyncing    = "syncing"
+	syncStageProcessing = "processing"
+)
+
+// inFlightSync is a sync started by Sync that has not finished.
+type inFlightSync struct {
+	info   adminmodel.InFlightSync
+	cancel context.CancelFunc
+}
+
+// startInFlightSync records a new in-flight sync and returns its ID. The sync
+// is cancelled by calling cancel.
+func (ing *Ingester) startInFlightSync(peerID peer.ID, depth int, resync bool, cancel context.CancelFunc) uint64 {
+	ing.inFlightMutex.Lock()
+	defer ing.inFlightMutex.Unlock()
+
+	ing.inFlightSeq++
+	id := ing.inFlightSeq
+	ing.inFlightSyncs[id] = &inFlightSync{
+		info: adminmodel.InFlightSync{
+			ID:      id,
+			PeerID:  peerID,
+			Depth:   depth,
+			Resync:  resync,
+			Started: time.Now(),
+			Stage:   syncStageSyncing,
+		},
+		cancel: cancel,
+	}
+	return id
+}
+
+// updateInFlightSync applies update to the in-flight sync, if it is still
+// in-flight.
+func (ing *Ingester) updateInFlightSync(id uint64, update func(*adminmodel.InFlightSync)) {
+	ing.inFlightMutex.Lock()
+	defer ing.inFlightMutex.Unlock()
+
+	if s, ok := ing.inFlightSyncs[id]; ok {
+		update(&s.info)
+	}
+}
+
+// endInFlightSync removes the sync from the in-flight syncs.
+func (ing *Ingester) endInFlightSync(id uint64) {
+	ing.inFlightMutex.Lock()
+	defer ing.inFlightMutex.Unlock()
+
+	delete(ing.inFlightSyncs, id)
+}
+
+// InFlightSyncs returns the syncs started by Sync that have not finished, in
+// the order they were started.
+func (ing *Ingester) InFlightSyncs() []adminmodel.InFlightSync {
+	ing.inFlightMutex.Lock()
+	syncs := make([]adminmodel.InFlightSync, 0, len(ing.inFlightSyncs))
+	for _, s := range ing.inFlightSyncs {
+		syncs = append(syncs, s.info)
+	}
+	ing.inFlightMutex.Unlock()
+
+	sort.Slice(syncs, func(i, j int) bool {
+		return syncs[i].ID < syncs[j].ID
+	})
+	return syncs
+}
+
+// CancelSync cancels the in-flight sync with the given ID. Returns false if
+// there is no such sync. Advertisements that were already fetched by the sync
+// may still be processed.
+func (ing *Ingester) CancelSync(id uint64) bool {
+	ing.inFlightMutex.Lock()
+	s, ok := ing.inFlightSyncs[id]
+	ing.inFlightMutex.Unlock()
+	if !ok {
+		return false
+	}
+	log.Infow("Cancelling sync", "id", id, "peer", s.info.PeerID)
+	s.cancel()
+	return true
+}
@@ -0,0 +1,70 @@
+package ingest
+
+import (
+	"context"
+	"encoding/binary"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// The value stored for an advertisement under adProcessedPrefix is one byte
+// that is 1 if the advertisement is processed and 0 if it is not. This may be
+// followed by the time that the advertisement was first processed, as 8 bytes
+// of big-endian Unix nanoseconds. Advertisements processed by earlier
+// versions of the indexer have no time.
+
+// adProcessedValue returns the value to store for an advertisement under
+// adProcessedPrefix. The time is omitted if firstProcessed is zero.
+func adProcessedValue(processed bool, firstProcessed time.Time) []byte {
+	v := make([]byte, 1, 9)
+	if processed {
+		v[0] = 1
+	}
+	if !firstProcessed.IsZero() {
+		v = v[:9]
+		binary.BigEndian.PutUint64(v[1:], uint64(firstProcessed.UnixNano()))
+	}
+	return v
+}
+
+// adProcessedTime returns the time that the advertisement was first processed
+// by this indexer. Returns false if the advertisement was never processed, or
+// was processed without recording the time.
+func (ing *Ingester) adProcessedTime(adCid cid.Cid) (time.Time, bool) {
+	v, err := ing.ds.Get(context.Background(), datastore.NewKey(adProcessedPrefix+adCid.String()))
+	if err != nil {
+		if err != datastore.ErrNotFound {
+			log.Errorw("Failed to read advertisement processed state from datastore", "err", err)
+		}
+		return time.Time{}, false
+	}
+	if len(v) < 9 {
+		return time.Time{}, false
+	}
+	return time.Unix(0, int64(binary.BigEndian.Uint64(v[1:9]))), true
+}
+
+// SyncSince syncs advertisements from a peer like a resync, but only
+// re-ingests the advertisements that this indexer first processed at or after
+// since. Advertisements carry no publication time, so the time that the
+// indexer first processed an advertisement is used instead. The sync walks
+// back the advertisement chain from the latest advertisement until it reaches
+// an advertisement that was first processed before since, and that
+// advertisement and all older ones are left as they are. Advertisements that
+// were never processed are always ingested.
+//
+// Advertisements that were processed without recording the time, such as
+// those processed by an earlier version of the indexer, or imported from
+// another indexer, are treated as newer than since. If there are no
+// advertisements with a time before since, then the sync is limited only by
+// depth, which has the same meaning as for Sync.
+//
+// As with Sync, the returned channel receives the CID of the latest
+// advertisement once it is processed, and is then closed.
+func (ing *Ingester) SyncSince(ctx context.Context, peerID peer.ID, peerAddr multiaddr.Multiaddr, since time.Time, depth int) (<-chan cid.Cid, error) {
+	return ing.sync(ctx, peerID, peerAddr, depth, true, since)
+}
@@ -0,0 +1,110 @@
+package ingest
+
+import (
+	"context"
+
+	"github.com/filecoin-project/go-legs"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// walKey is the key of the write-ahead log entry for the advertisement chain
+// from publisher that is headed by adCid.
+func walKey(publisher peer.ID, adCid cid.Cid) datastore.Key {
+	return datastore.NewKey(walPrefix + publisher.String() + "/" + adCid.String())
+}
+
+// addWAL records that the advertisement chain headed by adCid, from
+// publisher, is about to be processed. The entry is removed when the head
+// advertisement is marked as processed. The entry is synced to storage before
+// returning, so that it survives a crash.
+func (ing *Ingester) addWAL(ctx context.Context, publisher peer.ID, adCid cid.Cid) error {
+	key := walKey(publisher, adCid)
+	if err := ing.ds.Put(ctx, key, []byte{}); err != nil {
+		return err
+	}
+	return ing.ds.Sync(ctx, key)
+}
+
+// readWAL reads the write-ahead log and returns an event for each logged
+// advertisement chain that was not completely processed. Each event lists the
+// chain's unprocessed advertisements that are still held in the datastore,
+// newest first, as they would be listed by a finished sync. Entries for
+// chains that have been processed, or whose advertisements are no longer
+// held, are removed.
+func (ing *Ingester) readWAL(ctx context.Context) ([]legs.SyncFinished, error) {
+	results, err := ing.ds.Query(ctx, query.Query{
+		Prefix:   walPrefix,
+		KeysOnly: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer results.Close()
+
+	var replay []legs.SyncFinished
+	var done []datastore.Key
+	for r := range results.Next() {
+		if r.Error != nil {
+			if ing.safeMode.Skip("write-ahead log", r.Key, r.Error) {
+				continue
+			}
+			return nil, r.Error
+		}
+		key := datastore.NewKey(r.Key)
+		parts := key.Namespaces()
+		if len(parts) != 3 {
+			log.Errorw("Bad write-ahead log key, removing", "key", r.Key)
+			done = append(done, key)
+			continue
+		}
+		publisher, err := peer.Decode(parts[1])
+		if err != nil {
+			log.Errorw("Bad publisher in write-ahead log, removing", "key", r.Key, "err", err)
+			done = append(done, key)
+			continue
+		}
+		headCid, err := cid.Decode(parts[2])
+		if err != nil {
+			log.Errorw("Bad advertisement CID in write-ahead log, removing", "key", r.Key, "err", err)
+			done = append(done, key)
+			continue
+		}
+
+		var syncedCids []cid.Cid
+		for c := headCid; c != cid.Undef && !ing.adAlreadyProcessed(c); {
+			ad, err := ing.loadAd(c)
+			if err != nil {
+				// The advertisement is not held, so the rest of the chain
+				// must be synced again.
+				log.Warnw("Advertisement in write-ahead log is not in datastore", "adCid", c, "publisher", publisher, "err", err)
+				break
+			}
+			syncedCids = append(syncedCids, c)
+			c = cid.Undef
+			if ad.PreviousID != nil {
+				c = ad.PreviousID.(cidlink.Link).Cid
+			}
+		}
+		if len(syncedCids) == 0 {
+			done = append(done, key)
+			continue
+		}
+		log.Infow("Replaying unprocessed advertisements from write-ahead log", "publisher", publisher, "headAdCid", headCid, "count", len(syncedCids))
+		replay = append(replay, legs.SyncFinished{
+			Cid:        headCid,
+			PeerID:     publisher,
+			SyncedCids: syncedCids,
+		})
+	}
+
+	for _, key := range done {
+		if err = ing.ds.Delete(ctx, key); err != nil {
+			return nil, err
+		}
+	}
+	return replay, nil
+}
@@ -0,0 +1,29 @@
+// Package jitter randomizes the periods of timers, so that the periodic work
+// of indexers that started at the same time does not stay aligned.
+package jitter
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+var (
+	// rng is seeded separately for each process, so that indexers started
+	// together do not choose the same jitter.
+	rng      = rand.New(rand.NewSource(time.Now().UnixNano()))
+	rngMutex sync.Mutex
+)
+
+// Add returns d plus a random duration of up to percent percent of d. A
+// percent that is not greater than zero returns d unchanged.
+func Add(d time.Duration, percent int) time.Duration {
+	maxJitter := int64(d) * int64(percent) / 100
+	if maxJitter <= 0 {
+		return d
+	}
+	rngMutex.Lock()
+	j := rng.Int63n(maxJitter + 1)
+	rngMutex.Unlock()
+	return d + time.Duration(j)
+}
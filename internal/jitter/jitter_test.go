@@ -0,0 +1,31 @@
+package jitter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAdd(t *testing.T) {
+	const d = time.Minute
+
+	if Add(d, 0) != d {
+		t.Fatal("expected no jitter for 0 percent")
+	}
+	if Add(d, -1) != d {
+		t.Fatal("expected no jitter for -1 percent")
+	}
+
+	var varied bool
+	for i := 0; i < 100; i++ {
+		j := Add(d, 10)
+		if j < d || j > d+6*time.Second {
+			t.Fatalf("jittered duration %s out of range", j)
+		}
+		if j != d {
+			varied = true
+		}
+	}
+	if !varied {
+		t.Fatal("expected jitter to change duration")
+	}
+}
@@ -0,0 +1,100 @@
+// Package logthrottle collapses repeated identical log messages, so that an
+// error that happens at a high rate does not flood the log and hide other
+// messages.
+package logthrottle
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Throttle writes a log message the first time it is logged, and then
+// counts identical messages that are logged within the following window of
+// time instead of writing them. At the end of the window, one message with the
+// count of repeats is written, if there were any repeats. Messages are
+// identical if they have the same level, message text, and key-value pairs.
+type Throttle struct {
+	log    *zap.SugaredLogger
+	window time.Duration
+
+	repeats map[string]*repeat
+	mutex   sync.Mutex
+}
+
+type repeat struct {
+	count         int
+	warn          bool
+	msg           string
+	keysAndValues []interface{}
+}
+
+// New creates a Throttle that writes to log. A window of zero or less
+// disables throttling, so that every message is written.
+func New(log *zap.SugaredLogger, window time.Duration) *Throttle {
+	return &Throttle{
+		// Report the caller of the Throttle, not the Throttle itself.
+		log:     log.Desugar().WithOptions(zap.AddCallerSkip(3)).Sugar(),
+		window:  window,
+		repeats: make(map[string]*repeat),
+	}
+}
+
+// Errorw logs a message at error level with the key-value pairs, unless an
+// identical message was logged within the window.
+func (t *Throttle) Errorw(msg string, keysAndValues ...interface{}) {
+	t.logw(false, msg, keysAndValues)
+}
+
+// Warnw logs a message at warn level with the key-value pairs, unless an
+// identical message was logged within the window.
+func (t *Throttle) Warnw(msg string, keysAndValues ...interface{}) {
+	t.logw(true, msg, keysAndValues)
+}
+
+func (t *Throttle) logw(warn bool, msg string, keysAndValues []interface{}) {
+	if t.window > 0 {
+		key := fmt.Sprint(warn, msg, keysAndValues)
+		t.mutex.Lock()
+		if r, ok := t.repeats[key]; ok {
+			r.count++
+			t.mutex.Unlock()
+			return
+		}
+		t.repeats[key] = &repeat{
+			warn:          warn,
+			msg:           msg,
+			keysAndValues: keysAndValues,
+		}
+		t.mutex.Unlock()
+		time.AfterFunc(t.window, func() { t.endWindow(key) })
+	}
+	t.write(warn, msg, keysAndValues)
+}
+
+// endWindow writes the count of repeats of a message, if any, and lets the
+// next identical message be written.
+func (t *Throttle) endWindow(key string) {
+	t.mutex.Lock()
+	r := t.repeats[key]
+	delete(t.repeats, key)
+	t.mutex.Unlock()
+
+	if r.count == 0 {
+		return
+	}
+	keysAndValues := make([]interface{}, len(r.keysAndValues), len(r.keysAndValues)+4)
+	copy(keysAndValues, r.keysAndValues)
+	keysAndValues = append(keysAndValues, "repeated", r.count, "within", t.window)
+	t.write(r.warn, r.msg, keysAndValues)
+}
+
+func (t *Throttle) write(warn bool, msg string, keysAndValues []interface{}) {
+	if warn {
+		t.log.Warnw(msg, keysAndValues...)
+	} else {
+		t.log.Errorw(msg, keysAndValues...)
+	}
+}
@@ -0,0 +1,61 @@
+package logthrottle
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestThrottle(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	const window = 100 * time.Millisecond
+	throttle := New(zap.New(core).Sugar(), window)
+
+	err := errors.New("sync failed")
+	for i := 0; i < 5; i++ {
+		throttle.Errorw("Failed to sync", "err", err, "publisher", "a")
+	}
+	throttle.Errorw("Failed to sync", "err", err, "publisher", "b")
+	throttle.Warnw("Failed to sync", "err", err, "publisher", "a")
+
+	entries := logs.TakeAll()
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 log entries before end of window, got %d", len(entries))
+	}
+
+	time.Sleep(2 * window)
+
+	// Only the message that was repeated has its repeats reported.
+	entries = logs.TakeAll()
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 log entry at end of window, got %d", len(entries))
+	}
+	fields := entries[0].ContextMap()
+	if entries[0].Level != zapcore.ErrorLevel || fields["publisher"] != "a" {
+		t.Fatal("wrong message reported as repeated")
+	}
+	if fields["repeated"] != int64(4) {
+		t.Fatalf("expected 4 repeats, got %v", fields["repeated"])
+	}
+
+	// After the window, the message is written again.
+	throttle.Errorw("Failed to sync", "err", err, "publisher", "a")
+	if logs.Len() != 1 {
+		t.Fatal("expected message to be written after end of window")
+	}
+}
+
+func TestNoThrottle(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	throttle := New(zap.New(core).Sugar(), 0)
+	for i := 0; i < 3; i++ {
+		throttle.Errorw("Failed to sync")
+	}
+	if logs.Len() != 3 {
+		t.Fatalf("expected every message to be written, got %d", logs.Len())
+	}
+}
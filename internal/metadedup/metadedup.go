@@ -0,0 +1,213 @@
+// Package metadedup wraps a value store so that the metadata of values is
+// stored once for each distinct metadata, instead of once for each value.
+//
+// Many advertisements, across many providers and contexts, carry identical
+// metadata. The wrapped value store is given values whose metadata is
+// replaced by a reference to the hash of the metadata, and the metadata itself
+// is stored in a datastore keyed by that hash. Values read from the wrapped
+// value store have their metadata restored from the datastore.
+package metadedup
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path"
+	"sync/atomic"
+
+	indexer "github.com/filecoin-project/go-indexer-core"
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/ipfs/go-datastore"
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/multiformats/go-multihash"
+)
+
+var log = logging.Logger("indexer/metadedup")
+
+// metadataKeyPath is where deduplicated metadata is stored in the datastore.
+const metadataKeyPath = "/metadata"
+
+// refPrefix starts metadata that is a reference to stored metadata. Real
+// metadata starts with the varint multicodec code of a transport protocol, so
+// cannot start with this prefix.
+var refPrefix = []byte("\x00md:")
+
+// refLen is the length of a reference: the prefix and a sha2-256 multihash.
+var refLen = len(refPrefix) + 34
+
+// defaultCacheSize is the number of distinct metadata that are held in memory
+// so that they do not need to be read from the datastore.
+const defaultCacheSize = 4096
+
+// ValueStore is an indexer.Interface that stores the metadata of values
+// separately, once for each distinct metadata. It is safe for concurrent use
+// if the wrapped value store is.
+type ValueStore struct {
+	indexer.Interface
+	dstore datastore.Datastore
+	// cache holds metadata by reference, for metadata read from or written
+	// to the datastore.
+	cache *lru.Cache
+
+	// savedBytes is the number of metadata bytes not stored in the value
+	// store because the metadata was already stored.
+	savedBytes int64
+}
+
+// New wraps the value store so that the metadata of values put into it is
+// stored in dstore, once for each distinct metadata. The metadata is never
+// removed from dstore, since it may be referenced by any number of values.
+func New(valueStore indexer.Interface, dstore datastore.Datastore) *ValueStore {
+	cache, err := lru.New(defaultCacheSize)
+	if err != nil {
+		panic(err)
+	}
+	return &ValueStore{
+		Interface: valueStore,
+		dstore:    dstore,
+		cache:     cache,
+	}
+}
+
+// Get retrieves the values for a multihash, with their metadata restored.
+func (s *ValueStore) Get(mh multihash.Multihash) ([]indexer.Value, bool, error) {
+	values, found, err := s.Interface.Get(mh)
+	if err != nil || !found {
+		return values, found, err
+	}
+	if err = s.resolveValues(values); err != nil {
+		return nil, false, err
+	}
+	return values, true, nil
+}
+
+// Put stores the value's metadata, if not already stored, and puts the value
+// with a reference to the metadata into the wrapped value store.
+func (s *ValueStore) Put(value indexer.Value, mhs ...multihash.Multihash) error {
+	ref, err := s.storeMetadata(value.MetadataBytes)
+	if err != nil {
+		return err
+	}
+	value.MetadataBytes = ref
+	return s.Interface.Put(value, mhs...)
+}
+
+// Remove removes the mapping of each multihash to the value.
+func (s *ValueStore) Remove(value indexer.Value, mhs ...multihash.Multihash) error {
+	if len(value.MetadataBytes) > refLen {
+		value.MetadataBytes = makeRef(value.MetadataBytes)
+	}
+	return s.Interface.Remove(value, mhs...)
+}
+
+// Iter creates an iterator over the wrapped value store that restores the
+// metadata of the values it returns.
+func (s *ValueStore) Iter() (indexer.Iterator, error) {
+	iter, err := s.Interface.Iter()
+	if err != nil {
+		return nil, err
+	}
+	return &iterator{
+		Iterator: iter,
+		store:    s,
+	}, nil
+}
+
+// SavedBytes returns the number of metadata bytes that were not written to
+// the wrapped value store, since the store was created, because identical
+// metadata was already stored.
+func (s *ValueStore) SavedBytes() int64 {
+	return atomic.LoadInt64(&s.savedBytes)
+}
+
+// storeMetadata stores the metadata in the datastore if it is not already
+// there, and returns the reference to it. Metadata that is no larger than a
+// reference is not stored, and is returned as is.
+func (s *ValueStore) storeMetadata(metadata []byte) ([]byte, error) {
+	if len(metadata) <= refLen {
+		return metadata, nil
+	}
+	ref := makeRef(metadata)
+	if _, ok := s.cache.Get(string(ref)); ok {
+		atomic.AddInt64(&s.savedBytes, int64(len(metadata)-len(ref)))
+		return ref, nil
+	}
+
+	ctx := context.Background()
+	key := metadataDsKey(ref)
+	has, err := s.dstore.Has(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("cannot check for stored metadata: %w", err)
+	}
+	if has {
+		atomic.AddInt64(&s.savedBytes, int64(len(metadata)-len(ref)))
+	} else if err = s.dstore.Put(ctx, key, metadata); err != nil {
+		return nil, fmt.Errorf("cannot store metadata: %w", err)
+	}
+	s.cache.Add(string(ref), metadata)
+	return ref, nil
+}
+
+// resolveValues replaces metadata references in the values with the metadata
+// they refer to.
+func (s *ValueStore) resolveValues(values []indexer.Value) error {
+	for i := range values {
+		ref := values[i].MetadataBytes
+		if !isRef(ref) {
+			// Metadata too small to deduplicate, or value stored before
+			// metadata was deduplicated.
+			continue
+		}
+		if md, ok := s.cache.Get(string(ref)); ok {
+			values[i].MetadataBytes = md.([]byte)
+			continue
+		}
+		md, err := s.dstore.Get(context.Background(), metadataDsKey(ref))
+		if err != nil {
+			log.Errorw("Cannot read metadata for value", "err", err, "provider", values[i].ProviderID)
+			return fmt.Errorf("cannot read metadata: %w", err)
+		}
+		s.cache.Add(string(ref), md)
+		values[i].MetadataBytes = md
+	}
+	return nil
+}
+
+// iterator restores the metadata of the values returned by the wrapped
+// iterator.
+type iterator struct {
+	indexer.Iterator
+	store *ValueStore
+}
+
+func (it *iterator) Next() (multihash.Multihash, []indexer.Value, error) {
+	mh, values, err := it.Iterator.Next()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = it.store.resolveValues(values); err != nil {
+		return nil, nil, err
+	}
+	return mh, values, nil
+}
+
+// makeRef returns the reference to the metadata, which is the reference
+// prefix followed by the sha2-256 multihash of the metadata.
+func makeRef(metadata []byte) []byte {
+	mh, err := multihash.Sum(metadata, multihash.SHA2_256, -1)
+	if err != nil {
+		panic(err)
+	}
+	ref := make([]byte, 0, len(refPrefix)+len(mh))
+	ref = append(ref, refPrefix...)
+	return append(ref, mh...)
+}
+
+func isRef(metadata []byte) bool {
+	return bytes.HasPrefix(metadata, refPrefix)
+}
+
+func metadataDsKey(ref []byte) datastore.Key {
+	mh := multihash.Multihash(ref[len(refPrefix):])
+	return datastore.NewKey(path.Join(metadataKeyPath, mh.B58String()))
+}
@@ -0,0 +1,153 @@
+package metadedup
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"testing"
+
+	indexer "github.com/filecoin-project/go-indexer-core"
+	"github.com/filecoin-project/go-indexer-core/store/memory"
+	"github.com/filecoin-project/storetheindex/test/util"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-varint"
+)
+
+var provID = peer.ID("12D3KooWKRyzVWW6ChFjQjK4miCty85Niy48tpPV95XdKu1BcvMA")
+
+// makeMetadata returns graphsync-like metadata, which is large enough to be
+// deduplicated.
+func makeMetadata(seed int64) []byte {
+	md := varint.ToUvarint(uint64(multicodec.TransportGraphsyncFilecoinv1))
+	payload := make([]byte, 64)
+	rand.New(rand.NewSource(seed)).Read(payload)
+	return append(md, payload...)
+}
+
+func TestDedupMetadata(t *testing.T) {
+	dstore := dssync.MutexWrap(datastore.NewMapDatastore())
+	inner := memory.New()
+	s := New(inner, dstore)
+
+	mhs := util.RandomMultihashes(3, rand.New(rand.NewSource(1413)))
+	bigMd := makeMetadata(1)
+	smallMd := varint.ToUvarint(uint64(multicodec.TransportBitswap))
+
+	v1 := indexer.Value{ProviderID: provID, ContextID: []byte("ctx-1"), MetadataBytes: bigMd}
+	v2 := indexer.Value{ProviderID: provID, ContextID: []byte("ctx-2"), MetadataBytes: bigMd}
+	v3 := indexer.Value{ProviderID: provID, ContextID: []byte("ctx-3"), MetadataBytes: smallMd}
+	if err := s.Put(v1, mhs[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put(v2, mhs[1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put(v3, mhs[2]); err != nil {
+		t.Fatal(err)
+	}
+
+	// The wrapped value store holds a reference instead of large metadata,
+	// and small metadata as is.
+	values, _, err := inner.Get(mhs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isRef(values[0].MetadataBytes) {
+		t.Fatal("expected metadata reference in value store")
+	}
+	values, _, err = inner.Get(mhs[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(values[0].MetadataBytes, smallMd) {
+		t.Fatal("expected small metadata to be stored in value store")
+	}
+
+	// The second value with the same metadata does not store it again.
+	if saved := s.SavedBytes(); saved != int64(len(bigMd)-refLen) {
+		t.Fatalf("expected %d bytes saved, got %d", len(bigMd)-refLen, saved)
+	}
+
+	// Values read back have their metadata restored, also after the cache is
+	// cleared.
+	s.cache.Purge()
+	for i, want := range []indexer.Value{v1, v2, v3} {
+		values, found, err := s.Get(mhs[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !found || len(values) != 1 || !values[0].Equal(want) {
+			t.Fatalf("wrong value for multihash %d", i)
+		}
+	}
+
+	iter, err := s.Iter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var count int
+	for {
+		_, values, err := iter.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, v := range values {
+			if isRef(v.MetadataBytes) {
+				t.Fatal("iterator returned unresolved metadata reference")
+			}
+		}
+		count++
+	}
+	if count != len(mhs) {
+		t.Fatalf("expected to iterate %d multihashes, got %d", len(mhs), count)
+	}
+
+	if err = s.Remove(v1, mhs[0]); err != nil {
+		t.Fatal(err)
+	}
+	if _, found, _ := s.Get(mhs[0]); found {
+		t.Fatal("value not removed")
+	}
+}
+
+// BenchmarkDedupMetadata measures the value store space saved when many
+// contexts share a few distinct metadata.
+func BenchmarkDedupMetadata(b *testing.B) {
+	const contextCount = 1000
+	const metadataCount = 4
+	mds := make([][]byte, metadataCount)
+	for i := range mds {
+		mds[i] = makeMetadata(int64(i))
+	}
+	mhs := util.RandomMultihashes(contextCount, rand.New(rand.NewSource(1413)))
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	var saved, total int64
+	for n := 0; n < b.N; n++ {
+		s := New(memory.New(), dssync.MutexWrap(datastore.NewMapDatastore()))
+		total = 0
+		for i := 0; i < contextCount; i++ {
+			md := mds[i%metadataCount]
+			value := indexer.Value{
+				ProviderID:    provID,
+				ContextID:     []byte(fmt.Sprint("ctx-", i)),
+				MetadataBytes: md,
+			}
+			if err := s.Put(value, mhs[i]); err != nil {
+				b.Fatal(err)
+			}
+			total += int64(len(md))
+		}
+		saved = s.SavedBytes()
+	}
+	b.ReportMetric(float64(saved), "saved-bytes")
+	b.ReportMetric(100*float64(saved)/float64(total), "saved-%")
+}
@@ -2,6 +2,7 @@ package metrics
 
 import (
 	"net/http"
+	"sync"
 
 	logging "github.com/ipfs/go-log/v2"
 	"go.opencensus.io/stats"
@@ -14,10 +15,11 @@ import (
 
 // Global Tags
 var (
-	ErrKind, _ = tag.NewKey("errKind")
-	Method, _  = tag.NewKey("method")
-	Found, _   = tag.NewKey("found")
-	Version, _ = tag.NewKey("version")
+	ErrKind, _   = tag.NewKey("errKind")
+	Method, _    = tag.NewKey("method")
+	Found, _     = tag.NewKey("found")
+	Version, _   = tag.NewKey("version")
+	Publisher, _ = tag.NewKey("publisher")
 )
 
 // Measures
@@ -28,7 +30,17 @@ var (
 	AdIngestErrorCount   = stats.Int64("ingest/adingestError", "Number of errors encountered while processing an ad", stats.UnitDimensionless)
 	AdIngestSuccessCount = stats.Int64("ingest/adingestSuccess", "Number of successful ad ingest", stats.UnitDimensionless)
 	AdIngestSkippedCount = stats.Int64("ingest/adingestSkipped", "Number of ads skipped during ingest", stats.UnitDimensionless)
+	AdSignatureFailure   = stats.Int64("ingest/adSignatureFailure", "Number of ads rejected because their signature could not be verified", stats.UnitDimensionless)
 	AdLoadError          = stats.Int64("ingest/adLoadError", "Number of times an ad failed to load", stats.UnitDimensionless)
+	AdCacheHit           = stats.Int64("ingest/adCacheHit", "Number of ad loads served from the ad cache", stats.UnitDimensionless)
+	AdCacheMiss          = stats.Int64("ingest/adCacheMiss", "Number of ad loads that were not in the ad cache", stats.UnitDimensionless)
+	AdIngestThrottled    = stats.Int64("ingest/adingestThrottled", "Number of times ad ingestion was deferred by the per-provider rate limit", stats.UnitDimensionless)
+	AdIngestOutOfSpace   = stats.Int64("ingest/adingestOutOfSpace", "Number of times ad ingestion was paused because the value store is out of space", stats.UnitDimensionless)
+	IngestQueueDepth     = stats.Int64("ingest/queueDepth", "Number of providers with ads waiting for an ingest worker", stats.UnitDimensionless)
+	IngestWorkersBusy    = stats.Float64("ingest/workersBusy", "Fraction of ingest workers that are processing ads", stats.UnitDimensionless)
+	InvalidMultihashes   = stats.Int64("ingest/invalidMultihashes", "Number of advertised entries not indexed because they are not valid multihashes", stats.UnitDimensionless)
+	EntryChunksSkipped   = stats.Int64("ingest/entryChunksSkipped", "Number of entry chunks not stored because their multihashes were already indexed", stats.UnitDimensionless)
+	IngestSyncDataSize   = stats.Int64("ingest/syncDataSize", "Size of synced ads and entries held in the datastore until processed", stats.UnitBytes)
 	ProviderCount        = stats.Int64("provider/count", "Number of known (registered) providers", stats.UnitDimensionless)
 	EntriesSyncLatency   = stats.Float64("ingest/entriessynclatency", "How long it took to sync an Ad's entries", stats.UnitMilliseconds)
 )
@@ -70,16 +82,75 @@ var (
 		Measure:     AdIngestSkippedCount,
 		Aggregation: view.Count(),
 	}
+	adSignatureFailure = &view.View{
+		Measure:     AdSignatureFailure,
+		Aggregation: view.Count(),
+		TagKeys:     []tag.Key{Publisher},
+	}
 	adLoadError = &view.View{
 		Measure:     AdLoadError,
 		Aggregation: view.Count(),
 	}
+	adCacheHit = &view.View{
+		Measure:     AdCacheHit,
+		Aggregation: view.Count(),
+	}
+	adCacheMiss = &view.View{
+		Measure:     AdCacheMiss,
+		Aggregation: view.Count(),
+	}
+	adIngestThrottled = &view.View{
+		Measure:     AdIngestThrottled,
+		Aggregation: view.Count(),
+	}
+	adIngestOutOfSpace = &view.View{
+		Measure:     AdIngestOutOfSpace,
+		Aggregation: view.Count(),
+	}
+	ingestQueueDepthView = &view.View{
+		Measure:     IngestQueueDepth,
+		Aggregation: view.LastValue(),
+	}
+	ingestWorkersBusyView = &view.View{
+		Measure:     IngestWorkersBusy,
+		Aggregation: view.LastValue(),
+	}
+	invalidMultihashesView = &view.View{
+		Measure:     InvalidMultihashes,
+		Aggregation: view.Sum(),
+	}
+	entryChunksSkippedView = &view.View{
+		Measure:     EntryChunksSkipped,
+		Aggregation: view.Count(),
+	}
+	ingestSyncDataSizeView = &view.View{
+		Measure:     IngestSyncDataSize,
+		Aggregation: view.LastValue(),
+	}
 )
 
 var log = logging.Logger("indexer/metrics")
 
-// Start creates an HTTP router for serving metric info
+var (
+	exporter  http.Handler
+	startOnce sync.Once
+)
+
+// Start creates an HTTP router for serving metric info. The returned handler
+// serves the Prometheus text exposition format, suitable for scraping at the
+// /metrics path.
+//
+// Views are registered and the exporter is created only on the first call.
+// Subsequent calls return the same handler, so that multiple servers in the
+// same process do not register views or prometheus collectors more than once.
 func Start(views []*view.View) http.Handler {
+	startOnce.Do(func() {
+		exporter = start(views)
+	})
+	return exporter
+}
+
+func start(views []*view.View) http.Handler {
 	// Register default views
 	err := view.Register(
 		findLatencyView,
@@ -90,7 +161,17 @@ func Start(views []*view.View) http.Handler {
 		adIngestError,
 		adIngestSkipped,
 		adIngestSuccess,
+		adSignatureFailure,
 		adLoadError,
+		adCacheHit,
+		adCacheMiss,
+		adIngestThrottled,
+		adIngestOutOfSpace,
+		ingestQueueDepthView,
+		ingestWorkersBusyView,
+		ingestSyncDataSizeView,
+		invalidMultihashesView,
+		entryChunksSkippedView,
 	)
 	if err != nil {
 		log.Errorf("cannot register metrics default views: %s", err)
@@ -104,13 +185,14 @@ func Start(views []*view.View) http.Handler {
 	if !ok {
 		log.Warnf("failed to export default prometheus registry; some metrics will be unavailable; unexpected type: %T", promclient.DefaultRegisterer)
 	}
-	exporter, err := prometheus.NewExporter(prometheus.Options{
+	pe, err := prometheus.NewExporter(prometheus.Options{
 		Registry:  registry,
 		Namespace: "storetheindex",
 	})
 	if err != nil {
 		log.Errorf("could not create the prometheus stats exporter: %v", err)
+		return http.NotFoundHandler()
 	}
 
-	return exporter
+	return pe
 }
@@ -0,0 +1,38 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	coremetrics "github.com/filecoin-project/go-indexer-core/metrics"
+	"github.com/stretchr/testify/require"
+	"go.opencensus.io/stats"
+)
+
+func TestStartIdempotent(t *testing.T) {
+	h1 := Start(coremetrics.DefaultViews)
+	h2 := Start(coremetrics.DefaultViews)
+	require.Equal(t, h1, h2)
+
+	stats.Record(context.Background(), AdIngestSuccessCount.M(1))
+	stats.Record(context.Background(), coremetrics.StoreSize.M(1024))
+
+	require.Eventually(t, func() bool {
+		rec := httptest.NewRecorder()
+		h2.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		if rec.Code != http.StatusOK {
+			return false
+		}
+		body, err := io.ReadAll(rec.Body)
+		if err != nil {
+			return false
+		}
+		return strings.Contains(string(body), "storetheindex_ingest_adingestSuccess") &&
+			strings.Contains(string(body), "storetheindex_core_storage_size")
+	}, 5*time.Second, 100*time.Millisecond)
+}
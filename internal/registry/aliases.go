@@ -0,0 +1,160 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// aliasKeyPath is where provider aliases are stored in the indexer repo.
+const aliasKeyPath = "/registry/alias"
+
+// ProviderAlias maps a peer ID that a provider publishes advertisements under
+// to the provider's canonical ID.
+type ProviderAlias struct {
+	// Alias is the peer ID that advertisements name as their provider.
+	Alias peer.ID
+	// Provider is the canonical ID that the advertisements are attributed to.
+	Provider peer.ID
+}
+
+func aliasDsKey(alias peer.ID) datastore.Key {
+	return datastore.NewKey(path.Join(aliasKeyPath, alias.String()))
+}
+
+// SetAlias makes advertisements that name alias as their provider be ingested
+// as advertisements from the canonical provider, so that their content is
+// attributed to the canonical provider in find results. This lets a provider
+// rotate its keys while keeping a single identity.
+//
+// Both peers must be allowed by policy. An alias cannot itself have aliases,
+// and a canonical provider cannot be an alias of another provider. Setting an
+// alias that already exists replaces it.
+func (r *Registry) SetAlias(ctx context.Context, alias, provider peer.ID) error {
+	if alias == provider {
+		return fmt.Errorf("%w: alias is the same as the provider", ErrBadAlias)
+	}
+	if !r.policy.Allowed(alias) {
+		return fmt.Errorf("%w: alias %s", ErrNotAllowed, alias)
+	}
+	if !r.policy.Allowed(provider) {
+		return fmt.Errorf("%w: provider %s", ErrNotAllowed, provider)
+	}
+
+	r.aliasMutex.Lock()
+	defer r.aliasMutex.Unlock()
+
+	if _, ok := r.aliases[provider]; ok {
+		return fmt.Errorf("%w: provider %s is an alias", ErrBadAlias, provider)
+	}
+	for a, p := range r.aliases {
+		if p == alias && a != alias {
+			return fmt.Errorf("%w: %s is the provider for alias %s", ErrBadAlias, alias, a)
+		}
+	}
+
+	if r.dstore != nil {
+		if err := r.dstore.Put(ctx, aliasDsKey(alias), []byte(provider)); err != nil {
+			return err
+		}
+	}
+	r.aliases[alias] = provider
+	return nil
+}
+
+// RemoveAlias removes the alias, so that advertisements naming alias as their
+// provider are attributed to alias again. Returns false if there was no such
+// alias.
+func (r *Registry) RemoveAlias(ctx context.Context, alias peer.ID) (bool, error) {
+	r.aliasMutex.Lock()
+	defer r.aliasMutex.Unlock()
+
+	if _, ok := r.aliases[alias]; !ok {
+		return false, nil
+	}
+	if r.dstore != nil {
+		if err := r.dstore.Delete(ctx, aliasDsKey(alias)); err != nil {
+			return false, err
+		}
+	}
+	delete(r.aliases, alias)
+	return true, nil
+}
+
+// CanonicalProvider returns the provider that the peer is an alias of, or
+// the peer itself if it is not an alias. A canonical provider that is no
+// longer allowed by policy is not returned, so that content cannot be
+// attributed to a provider that would not be allowed to advertise it.
+func (r *Registry) CanonicalProvider(peerID peer.ID) peer.ID {
+	r.aliasMutex.RLock()
+	provider, ok := r.aliases[peerID]
+	r.aliasMutex.RUnlock()
+	if !ok || !r.policy.Allowed(provider) {
+		return peerID
+	}
+	return provider
+}
+
+// Aliases returns all provider aliases, ordered by alias.
+func (r *Registry) Aliases() []ProviderAlias {
+	r.aliasMutex.RLock()
+	aliases := make([]ProviderAlias, 0, len(r.aliases))
+	for alias, provider := range r.aliases {
+		aliases = append(aliases, ProviderAlias{
+			Alias:    alias,
+			Provider: provider,
+		})
+	}
+	r.aliasMutex.RUnlock()
+
+	sort.Slice(aliases, func(i, j int) bool {
+		return aliases[i].Alias < aliases[j].Alias
+	})
+	return aliases
+}
+
+func (r *Registry) loadPersistedAliases(ctx context.Context) (int, error) {
+	if r.dstore == nil {
+		return 0, nil
+	}
+
+	results, err := r.dstore.Query(ctx, query.Query{
+		Prefix: aliasKeyPath,
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer results.Close()
+
+	var count int
+	for result := range results.Next() {
+		if result.Error != nil {
+			if r.safeMode.Skip("provider alias", result.Key, result.Error) {
+				continue
+			}
+			return 0, fmt.Errorf("cannot read provider alias data: %v", result.Error)
+		}
+		alias, err := peer.Decode(path.Base(result.Entry.Key))
+		if err != nil {
+			if r.safeMode.Skip("provider alias", result.Key, err) {
+				continue
+			}
+			return 0, fmt.Errorf("bad provider alias key %q: %w", result.Entry.Key, err)
+		}
+		provider, err := peer.IDFromBytes(result.Entry.Value)
+		if err != nil {
+			if r.safeMode.Skip("provider alias", result.Key, err) {
+				continue
+			}
+			return 0, fmt.Errorf("bad provider for alias %s: %w", alias, err)
+		}
+		r.aliases[alias] = provider
+		count++
+	}
+	return count, nil
+}
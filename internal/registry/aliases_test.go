@@ -0,0 +1,118 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	leveldb "github.com/ipfs/go-ds-leveldb"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/test"
+)
+
+func TestAliases(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	provID, err := peer.Decode(limitedID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aliasID, err := peer.Decode(limitedID2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherID, err := peer.Decode(exceptID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blockedID, err := test.RandPeerID()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dataStorePath := t.TempDir()
+	dstore, err := leveldb.NewDatastore(dataStorePath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewRegistry(ctx, discoveryCfg, dstore, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r.CanonicalProvider(aliasID) != aliasID {
+		t.Fatal("peer without alias should be its own canonical provider")
+	}
+	if err = r.SetAlias(ctx, aliasID, provID); err != nil {
+		t.Fatal(err)
+	}
+	if r.CanonicalProvider(aliasID) != provID {
+		t.Fatal("alias not mapped to provider")
+	}
+	if r.CanonicalProvider(provID) != provID {
+		t.Fatal("provider should be its own canonical provider")
+	}
+
+	// Peers that are not allowed by policy cannot be aliases or providers.
+	if err = r.SetAlias(ctx, blockedID, provID); !errors.Is(err, ErrNotAllowed) {
+		t.Fatalf("expected ErrNotAllowed for blocked alias, got %v", err)
+	}
+	if err = r.SetAlias(ctx, otherID, blockedID); !errors.Is(err, ErrNotAllowed) {
+		t.Fatalf("expected ErrNotAllowed for blocked provider, got %v", err)
+	}
+	// Aliases cannot be chained.
+	if err = r.SetAlias(ctx, otherID, aliasID); !errors.Is(err, ErrBadAlias) {
+		t.Fatalf("expected ErrBadAlias for alias of alias, got %v", err)
+	}
+	if err = r.SetAlias(ctx, provID, otherID); !errors.Is(err, ErrBadAlias) {
+		t.Fatalf("expected ErrBadAlias for provider as alias, got %v", err)
+	}
+	if err = r.SetAlias(ctx, provID, provID); !errors.Is(err, ErrBadAlias) {
+		t.Fatalf("expected ErrBadAlias for alias of self, got %v", err)
+	}
+	if err = r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Check that aliases are loaded from the datastore.
+	dstore, err = leveldb.NewDatastore(dataStorePath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err = NewRegistry(ctx, discoveryCfg, dstore, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	aliases := r.Aliases()
+	if len(aliases) != 1 {
+		t.Fatalf("expected 1 alias, got %d", len(aliases))
+	}
+	if aliases[0].Alias != aliasID || aliases[0].Provider != provID {
+		t.Fatalf("wrong alias loaded: %v", aliases[0])
+	}
+
+	// An alias is not applied once its provider is blocked.
+	r.BlockPeer(provID)
+	if r.CanonicalProvider(aliasID) != aliasID {
+		t.Fatal("alias should not map to blocked provider")
+	}
+	r.AllowPeer(provID)
+
+	removed, err := r.RemoveAlias(ctx, aliasID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !removed {
+		t.Fatal("expected alias to be removed")
+	}
+	if r.CanonicalProvider(aliasID) != aliasID {
+		t.Fatal("removed alias still mapped")
+	}
+	if removed, err = r.RemoveAlias(ctx, aliasID); err != nil || removed {
+		t.Fatalf("expected nothing to remove, got %v, %v", removed, err)
+	}
+}
@@ -0,0 +1,110 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/filecoin-project/storetheindex/api/v0/admin/model"
+	"github.com/filecoin-project/storetheindex/internal/jitter"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// maxAllowListSize is the maximum number of bytes read when fetching a remote
+// allowlist.
+const maxAllowListSize = 16 << 20
+
+// remoteAllowList periodically fetches a signed allowlist and applies it to
+// the registry policy.
+type remoteAllowList struct {
+	url      string
+	signer   peer.ID
+	interval time.Duration
+	client   *http.Client
+	// seq is the sequence number of the last applied allowlist.
+	seq uint64
+}
+
+func newRemoteAllowList(url, signer string, interval time.Duration) (*remoteAllowList, error) {
+	if signer == "" {
+		return nil, errors.New("AllowListSigner must be set when AllowListURL is set")
+	}
+	signerID, err := peer.Decode(signer)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode AllowListSigner %q: %s", signer, err)
+	}
+	if interval <= 0 {
+		return nil, errors.New("AllowListRefresh must be greater than 0")
+	}
+	return &remoteAllowList{
+		url:      url,
+		signer:   signerID,
+		interval: interval,
+		client:   &http.Client{Timeout: time.Minute},
+	}, nil
+}
+
+// runAllowListRefresh fetches the remote allowlist immediately, and then each
+// time the refresh interval elapses, until the registry is closed.
+func (r *Registry) runAllowListRefresh(ral *remoteAllowList) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-r.closing
+		cancel()
+	}()
+
+	timer := time.NewTimer(0)
+	for {
+		select {
+		case <-timer.C:
+			if err := r.refreshAllowList(ctx, ral); err != nil {
+				log.Errorw("Failed to refresh remote allowlist", "err", err, "url", ral.url)
+			}
+			timer.Reset(jitter.Add(ral.interval, r.timerJitter))
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// refreshAllowList fetches and validates the remote allowlist, and applies it
+// to the policy if it is newer than the allowlist currently applied.
+func (r *Registry) refreshAllowList(ctx context.Context, ral *remoteAllowList) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ral.url, nil)
+	if err != nil {
+		return err
+	}
+	rsp, err := ral.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+
+	if rsp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected response status %d", rsp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(rsp.Body, maxAllowListSize))
+	if err != nil {
+		return fmt.Errorf("cannot read allowlist: %w", err)
+	}
+
+	allowList, signerID, err := model.ReadAllowList(data)
+	if err != nil {
+		return err
+	}
+	if signerID != ral.signer {
+		return fmt.Errorf("allowlist signed by unexpected peer %s", signerID)
+	}
+	if allowList.Seq < ral.seq {
+		return fmt.Errorf("allowlist sequence %d is older than applied sequence %d", allowList.Seq, ral.seq)
+	}
+	ral.seq = allowList.Seq
+
+	r.policy.SetRemoteAllow(allowList.Peers)
+	log.Infow("Applied remote allowlist", "peers", len(allowList.Peers), "seq", allowList.Seq)
+	return nil
+}
@@ -0,0 +1,100 @@
+package registry
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/storetheindex/api/v0/admin/model"
+	"github.com/filecoin-project/storetheindex/config"
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/test"
+)
+
+func TestRemoteAllowList(t *testing.T) {
+	privKey, pubKey, err := test.RandTestKeyPair(crypto.Ed25519, 256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signerID, err := peer.IDFromPublicKey(pubKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, _, err := test.RandTestKeyPair(crypto.Ed25519, 256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	allowedID, err := peer.Decode(limitedID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listData, err := model.MakeAllowList([]peer.ID{allowedID}, privKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	badData, err := model.MakeAllowList([]peer.ID{allowedID}, otherKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(listData)
+	}))
+	defer ts.Close()
+
+	cfg := config.Discovery{
+		Policy: config.Policy{
+			Allow:            false,
+			AllowListURL:     ts.URL,
+			AllowListSigner:  signerID.String(),
+			AllowListRefresh: config.Duration(time.Hour),
+		},
+		RediscoverWait: config.Duration(time.Minute),
+	}
+
+	r, err := NewRegistry(context.Background(), cfg, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !r.Allowed(allowedID) {
+		if time.Now().After(deadline) {
+			t.Fatal("peer in remote allowlist should be allowed")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	// Changing the configured policy must not remove the remote allowlist.
+	err = r.SetPolicy(config.Policy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.Allowed(allowedID) {
+		t.Fatal("peer in remote allowlist should be allowed after policy change")
+	}
+
+	// An allowlist signed by the wrong key must be rejected.
+	badServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(badData)
+	}))
+	defer badServer.Close()
+	ral, err := newRemoteAllowList(badServer.URL, signerID.String(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = r.refreshAllowList(context.Background(), ral); err == nil {
+		t.Fatal("expected error from allowlist with wrong signer")
+	}
+
+	// Missing signer is a configuration error.
+	cfg.Policy.AllowListSigner = ""
+	if _, err = NewRegistry(context.Background(), cfg, nil, nil); err == nil {
+		t.Fatal("expected error when signer not configured")
+	}
+}
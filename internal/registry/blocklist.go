@@ -0,0 +1,118 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path"
+	"sort"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	"github.com/multiformats/go-multihash"
+)
+
+// blockedKeyPath is where blocked multihashes are stored in the indexer repo.
+const blockedKeyPath = "/registry/blocked"
+
+func blockedDsKey(mh multihash.Multihash) datastore.Key {
+	return datastore.NewKey(path.Join(blockedKeyPath, mh.B58String()))
+}
+
+// BlockMultihash adds the multihash to the blocklist. A blocked multihash is
+// not returned in find results, whichever provider indexed it, and is not
+// indexed when ingesting advertisements. Returns false if the multihash was
+// already blocked.
+func (r *Registry) BlockMultihash(ctx context.Context, mh multihash.Multihash) (bool, error) {
+	r.blockedMutex.Lock()
+	defer r.blockedMutex.Unlock()
+
+	if _, ok := r.blocked[string(mh)]; ok {
+		return false, nil
+	}
+	if r.dstore != nil {
+		if err := r.dstore.Put(ctx, blockedDsKey(mh), []byte{}); err != nil {
+			return false, err
+		}
+	}
+	r.blocked[string(mh)] = struct{}{}
+	return true, nil
+}
+
+// UnblockMultihash removes the multihash from the blocklist. Content that was
+// not indexed while the multihash was blocked is only indexed when it is
+// advertised again. Returns false if the multihash was not blocked.
+func (r *Registry) UnblockMultihash(ctx context.Context, mh multihash.Multihash) (bool, error) {
+	r.blockedMutex.Lock()
+	defer r.blockedMutex.Unlock()
+
+	if _, ok := r.blocked[string(mh)]; !ok {
+		return false, nil
+	}
+	if r.dstore != nil {
+		if err := r.dstore.Delete(ctx, blockedDsKey(mh)); err != nil {
+			return false, err
+		}
+	}
+	delete(r.blocked, string(mh))
+	return true, nil
+}
+
+// MultihashBlocked returns true if the multihash is on the blocklist.
+func (r *Registry) MultihashBlocked(mh multihash.Multihash) bool {
+	r.blockedMutex.RLock()
+	defer r.blockedMutex.RUnlock()
+
+	if len(r.blocked) == 0 {
+		return false
+	}
+	_, ok := r.blocked[string(mh)]
+	return ok
+}
+
+// BlockedMultihashes returns all multihashes on the blocklist, in byte order.
+func (r *Registry) BlockedMultihashes() []multihash.Multihash {
+	r.blockedMutex.RLock()
+	mhs := make([]multihash.Multihash, 0, len(r.blocked))
+	for mh := range r.blocked {
+		mhs = append(mhs, multihash.Multihash(mh))
+	}
+	r.blockedMutex.RUnlock()
+
+	sort.Slice(mhs, func(i, j int) bool {
+		return bytes.Compare(mhs[i], mhs[j]) < 0
+	})
+	return mhs
+}
+
+// loadPersistedBlocklist loads the blocklist from the datastore. Entries that
+// cannot be read fail loading even in safe mode, since skipping them would
+// serve content that is supposed to be blocked.
+func (r *Registry) loadPersistedBlocklist(ctx context.Context) (int, error) {
+	if r.dstore == nil {
+		return 0, nil
+	}
+
+	results, err := r.dstore.Query(ctx, query.Query{
+		Prefix:   blockedKeyPath,
+		KeysOnly: true,
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer results.Close()
+
+	var count int
+	for result := range results.Next() {
+		if result.Error != nil {
+			return 0, fmt.Errorf("cannot read blocklist data: %v", result.Error)
+		}
+		mh, err := multihash.FromB58String(path.Base(result.Entry.Key))
+		if err != nil {
+			return 0, fmt.Errorf("bad blocked multihash key %q: %w", result.Entry.Key, err)
+		}
+		r.blocked[string(mh)] = struct{}{}
+		count++
+	}
+	return count, nil
+}
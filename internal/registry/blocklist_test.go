@@ -0,0 +1,87 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/storetheindex/test/util"
+	leveldb "github.com/ipfs/go-ds-leveldb"
+)
+
+func TestBlocklist(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	dataStorePath := t.TempDir()
+	dstore, err := leveldb.NewDatastore(dataStorePath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewRegistry(ctx, discoveryCfg, dstore, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mhs := util.RandomMultihashes(3, rand.New(rand.NewSource(1413)))
+	for _, mh := range mhs[:2] {
+		added, err := r.BlockMultihash(ctx, mh)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !added {
+			t.Fatal("expected multihash to be added to blocklist")
+		}
+	}
+	added, err := r.BlockMultihash(ctx, mhs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added {
+		t.Fatal("multihash added to blocklist twice")
+	}
+	if !r.MultihashBlocked(mhs[0]) || !r.MultihashBlocked(mhs[1]) {
+		t.Fatal("multihash not blocked")
+	}
+	if r.MultihashBlocked(mhs[2]) {
+		t.Fatal("multihash should not be blocked")
+	}
+	if err = r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Check that the blocklist is loaded from the datastore.
+	dstore, err = leveldb.NewDatastore(dataStorePath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err = NewRegistry(ctx, discoveryCfg, dstore, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	blocked := r.BlockedMultihashes()
+	if len(blocked) != 2 {
+		t.Fatalf("expected 2 blocked multihashes, got %d", len(blocked))
+	}
+	if bytes.Compare(blocked[0], blocked[1]) >= 0 {
+		t.Fatal("blocked multihashes not sorted")
+	}
+
+	removed, err := r.UnblockMultihash(ctx, mhs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !removed {
+		t.Fatal("expected multihash to be removed from blocklist")
+	}
+	if r.MultihashBlocked(mhs[0]) {
+		t.Fatal("unblocked multihash still blocked")
+	}
+	if removed, err = r.UnblockMultihash(ctx, mhs[0]); err != nil || removed {
+		t.Fatalf("expected nothing to remove, got %v, %v", removed, err)
+	}
+}
@@ -0,0 +1,125 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/multiformats/go-multihash"
+	"github.com/multiformats/go-varint"
+)
+
+// codecKeyPath is where the CID codecs of indexed multihashes are stored in
+// the indexer repo.
+const codecKeyPath = "/registry/codec"
+
+func codecDsKey(providerID peer.ID, mh multihash.Multihash) datastore.Key {
+	return datastore.NewKey(path.Join(codecKeyPath, providerID.String(), mh.B58String()))
+}
+
+// PutCodecs records the CID codec that the provider advertised each
+// multihash with. codecs[i] is the codec of mhs[i]. Nothing is recorded if
+// the registry has no datastore.
+func (r *Registry) PutCodecs(ctx context.Context, providerID peer.ID, mhs []multihash.Multihash, codecs []uint64) error {
+	if len(mhs) != len(codecs) {
+		return errors.New("number of codecs does not match number of multihashes")
+	}
+	if r.dstore == nil || len(mhs) == 0 {
+		return nil
+	}
+	return r.batchWrite(ctx, len(mhs), func(ds datastore.Write, i int) error {
+		return ds.Put(ctx, codecDsKey(providerID, mhs[i]), varint.ToUvarint(codecs[i]))
+	})
+}
+
+// RemoveCodecs removes the recorded CID codecs of the provider's multihashes.
+func (r *Registry) RemoveCodecs(ctx context.Context, providerID peer.ID, mhs []multihash.Multihash) error {
+	if r.dstore == nil || len(mhs) == 0 {
+		return nil
+	}
+	return r.batchWrite(ctx, len(mhs), func(ds datastore.Write, i int) error {
+		return ds.Delete(ctx, codecDsKey(providerID, mhs[i]))
+	})
+}
+
+// batchWrite calls write for each of count records, using a datastore batch if
+// the datastore supports batching.
+func (r *Registry) batchWrite(ctx context.Context, count int, write func(datastore.Write, int) error) error {
+	bds, ok := r.dstore.(datastore.Batching)
+	if !ok {
+		for i := 0; i < count; i++ {
+			if err := write(r.dstore, i); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	batch, err := bds.Batch(ctx)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < count; i++ {
+		if err = write(batch, i); err != nil {
+			return err
+		}
+	}
+	return batch.Commit(ctx)
+}
+
+// Codec returns the CID codec that the provider advertised the multihash
+// with. Returns false if no codec is recorded, such as when the multihash was
+// advertised without a codec.
+func (r *Registry) Codec(ctx context.Context, providerID peer.ID, mh multihash.Multihash) (uint64, bool, error) {
+	if r.dstore == nil {
+		return 0, false, nil
+	}
+	value, err := r.dstore.Get(ctx, codecDsKey(providerID, mh))
+	if err != nil {
+		if err == datastore.ErrNotFound {
+			return 0, false, nil
+		}
+		return 0, false, err
+	}
+	codec, _, err := varint.FromUvarint(value)
+	if err != nil {
+		return 0, false, fmt.Errorf("bad codec stored for %s: %w", mh.B58String(), err)
+	}
+	return codec, true, nil
+}
+
+// removeAllCodecs removes all the recorded CID codecs of the provider's
+// multihashes.
+func (r *Registry) removeAllCodecs(ctx context.Context, providerID peer.ID) error {
+	if r.dstore == nil {
+		return nil
+	}
+	return r.deletePrefix(ctx, path.Join(codecKeyPath, providerID.String()))
+}
+
+// deletePrefix deletes all the records whose keys start with the prefix.
+func (r *Registry) deletePrefix(ctx context.Context, prefix string) error {
+	results, err := r.dstore.Query(ctx, query.Query{
+		Prefix:   prefix,
+		KeysOnly: true,
+	})
+	if err != nil {
+		return err
+	}
+	var keys []datastore.Key
+	for result := range results.Next() {
+		if result.Error != nil {
+			results.Close()
+			return fmt.Errorf("cannot read %s data: %v", prefix, result.Error)
+		}
+		keys = append(keys, datastore.NewKey(result.Key))
+	}
+	results.Close()
+
+	return r.batchWrite(ctx, len(keys), func(ds datastore.Write, i int) error {
+		return ds.Delete(ctx, keys[i])
+	})
+}
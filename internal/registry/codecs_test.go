@@ -0,0 +1,74 @@
+package registry
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/storetheindex/test/util"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+func TestCodecs(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	provID, err := peer.Decode(limitedID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherID, err := peer.Decode(limitedID2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewRegistry(ctx, discoveryCfg, datastore.NewMapDatastore(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	mhs := util.RandomMultihashes(3, rand.New(rand.NewSource(1413)))
+	if err = r.PutCodecs(ctx, provID, mhs[:2], []uint64{cid.Raw}); err == nil {
+		t.Fatal("expected error when codecs do not match multihashes")
+	}
+	if err = r.PutCodecs(ctx, provID, mhs[:2], []uint64{cid.Raw, cid.DagCBOR}); err != nil {
+		t.Fatal(err)
+	}
+	if err = r.PutCodecs(ctx, otherID, mhs[2:], []uint64{cid.DagProtobuf}); err != nil {
+		t.Fatal(err)
+	}
+
+	codec, ok, err := r.Codec(ctx, provID, mhs[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok || codec != cid.DagCBOR {
+		t.Fatalf("expected codec %d, got %d, %v", cid.DagCBOR, codec, ok)
+	}
+	// Codecs are recorded per provider.
+	if _, ok, _ = r.Codec(ctx, provID, mhs[2]); ok {
+		t.Fatal("codec of other provider's multihash returned")
+	}
+
+	if err = r.RemoveCodecs(ctx, provID, mhs[:1]); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ = r.Codec(ctx, provID, mhs[0]); ok {
+		t.Fatal("codec not removed")
+	}
+
+	// Removing a provider removes all its codecs.
+	if err = r.RemoveProvider(ctx, provID); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ = r.Codec(ctx, provID, mhs[1]); ok {
+		t.Fatal("codec not removed with provider")
+	}
+	if _, ok, _ = r.Codec(ctx, otherID, mhs[2]); !ok {
+		t.Fatal("codec of other provider removed")
+	}
+}
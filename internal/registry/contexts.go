@@ -0,0 +1,431 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"sync/atomic"
+	"time"
+
+	v0 "github.com/filecoin-project/storetheindex/api/v0"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-varint"
+)
+
+// contextKeyPath is where provider context info is stored in to indexer repo.
+const contextKeyPath = "/registry/ctx"
+
+// adCountKeyPath is where the number of multihashes that each advertisement
+// added to a provider context is stored.
+const adCountKeyPath = "/registry/adcount"
+
+// ContextInfo is an immutable data structure that holds information about a
+// context ID advertised by a provider.
+type ContextInfo struct {
+	ProviderID peer.ID
+	ContextID  []byte
+	Metadata   []byte
+	// EntryCount is the number of multihashes indexed for the context. This
+	// is the total of the entries of each advertisement that updated the
+	// context, less any that were removed.
+	EntryCount uint64
+	// LastAdvertisement is the most recent advertisement that updated the
+	// context.
+	LastAdvertisement cid.Cid
+	// LastAdvertisementTime is the time that the most recent advertisement
+	// that updated the context was ingested.
+	LastAdvertisementTime time.Time `json:",omitempty"`
+	// Transport is the transport protocol that the metadata is for. This is
+	// zero if the metadata does not identify a transport.
+	Transport multicodec.Code `json:",omitempty"`
+	// MetadataOverride, if not nil, is metadata set by the indexer operator
+	// that replaces Metadata in find results. It is cleared when an
+	// advertisement changes the context's metadata.
+	MetadataOverride []byte `json:",omitempty"`
+}
+
+func contextDsKey(providerID peer.ID, contextID []byte) datastore.Key {
+	return datastore.NewKey(path.Join(contextKeyPath, providerID.String(), base64.RawURLEncoding.EncodeToString(contextID)))
+}
+
+func adCountDsPrefix(providerID peer.ID, contextID []byte) datastore.Key {
+	return datastore.NewKey(path.Join(adCountKeyPath, providerID.String(), base64.RawURLEncoding.EncodeToString(contextID)))
+}
+
+// UpdateProviderContext records that an advertisement set the metadata for a
+// provider's context, and added entryCount multihashes to the context. If the
+// same advertisement already updated the context, such as when it is synced
+// again, then entryCount replaces the number of multihashes it added before.
+func (r *Registry) UpdateProviderContext(ctx context.Context, providerID peer.ID, contextID, metadata []byte, entryCount uint64, adID cid.Cid) error {
+	errCh := make(chan error, 1)
+	r.actions <- func() {
+		errCh <- r.syncUpdateContext(ctx, providerID, contextID, metadata, entryCount, adID)
+	}
+	return <-errCh
+}
+
+// RemoveProviderContext removes the record of a provider's context, and the
+// recorded index times of the context's multihashes.
+func (r *Registry) RemoveProviderContext(ctx context.Context, providerID peer.ID, contextID []byte) error {
+	errCh := make(chan error, 1)
+	r.actions <- func() {
+		errCh <- r.syncRemoveContext(ctx, providerID, contextID)
+	}
+	if err := <-errCh; err != nil {
+		return err
+	}
+	// The index times are not kept in memory, so remove them without holding
+	// up other registry actions.
+	return r.removeContextIndexTimes(ctx, providerID, contextID)
+}
+
+// RemoveContextEntries records that count multihashes were removed from a
+// provider's context. The context's entry count does not go below zero.
+func (r *Registry) RemoveContextEntries(ctx context.Context, providerID peer.ID, contextID []byte, count uint64) error {
+	errCh := make(chan error, 1)
+	r.actions <- func() {
+		errCh <- r.syncRemoveContextEntries(ctx, providerID, contextID, count)
+	}
+	return <-errCh
+}
+
+// SetMetadataOverride sets metadata that replaces the metadata advertised for
+// a provider's context in find results. The override remains until it is
+// cleared, or until an advertisement changes the context's metadata. Returns
+// ErrContextNotFound if the provider has not advertised the context.
+func (r *Registry) SetMetadataOverride(ctx context.Context, providerID peer.ID, contextID, metadata []byte) error {
+	if len(metadata) == 0 {
+		return ErrNoMetadata
+	}
+	errCh := make(chan error, 1)
+	r.actions <- func() {
+		errCh <- r.syncSetMetadataOverride(ctx, providerID, contextID, metadata)
+	}
+	return <-errCh
+}
+
+// ClearMetadataOverride removes the metadata override for a provider's
+// context, so that find results use the advertised metadata again. Returns
+// ErrContextNotFound if the provider has not advertised the context.
+func (r *Registry) ClearMetadataOverride(ctx context.Context, providerID peer.ID, contextID []byte) error {
+	errCh := make(chan error, 1)
+	r.actions <- func() {
+		errCh <- r.syncSetMetadataOverride(ctx, providerID, contextID, nil)
+	}
+	return <-errCh
+}
+
+// HasMetadataOverrides returns true if any provider context has a metadata
+// override. This allows finding results without looking up each context when
+// there are no overrides.
+func (r *Registry) HasMetadataOverrides() bool {
+	return atomic.LoadInt32(&r.metadataOverrides) != 0
+}
+
+// ProviderEntryCount returns the number of multihashes indexed for the
+// provider, which is the total of the entry counts of all of the provider's
+// contexts.
+func (r *Registry) ProviderEntryCount(providerID peer.ID) uint64 {
+	countChan := make(chan uint64, 1)
+	r.actions <- func() {
+		var count uint64
+		for _, info := range r.contexts[providerID] {
+			count += info.EntryCount
+		}
+		countChan <- count
+	}
+	return <-countChan
+}
+
+// ProviderContexts returns information about all the contexts that the
+// provider has advertised and not removed.
+func (r *Registry) ProviderContexts(providerID peer.ID) []*ContextInfo {
+	var infos []*ContextInfo
+	done := make(chan struct{})
+	r.actions <- func() {
+		provContexts := r.contexts[providerID]
+		infos = make([]*ContextInfo, 0, len(provContexts))
+		for _, info := range provContexts {
+			infos = append(infos, info)
+		}
+		close(done)
+	}
+	<-done
+	return infos
+}
+
+// ProviderContext returns information about the provider's context, or nil
+// if the provider has not advertised the context.
+func (r *Registry) ProviderContext(providerID peer.ID, contextID []byte) *ContextInfo {
+	infoChan := make(chan *ContextInfo)
+	r.actions <- func() {
+		info, ok := r.contexts[providerID][string(contextID)]
+		if ok {
+			infoChan <- info
+		}
+		close(infoChan)
+	}
+	return <-infoChan
+}
+
+func (r *Registry) syncUpdateContext(ctx context.Context, providerID peer.ID, contextID, metadata []byte, entryCount uint64, adID cid.Cid) error {
+	prevAdCount, err := r.swapAdEntryCount(ctx, providerID, contextID, adID, entryCount)
+	if err != nil {
+		return err
+	}
+	provContexts, ok := r.contexts[providerID]
+	if !ok {
+		provContexts = map[string]*ContextInfo{}
+		r.contexts[providerID] = provContexts
+	}
+
+	info := &ContextInfo{
+		ProviderID:        providerID,
+		ContextID:         contextID,
+		Metadata:          metadata,
+		EntryCount:        entryCount,
+		LastAdvertisement: adID,
+
+		LastAdvertisementTime: time.Now(),
+	}
+	if transport, err := v0.MetadataTransport(metadata); err == nil {
+		info.Transport = transport
+	}
+	if prev, ok := provContexts[string(contextID)]; ok {
+		info.EntryCount += prev.EntryCount
+		if prevAdCount > info.EntryCount {
+			info.EntryCount = 0
+		} else {
+			info.EntryCount -= prevAdCount
+		}
+		if prev.MetadataOverride != nil {
+			// Keep the override unless the provider advertised different
+			// metadata, which is assumed to correct the metadata.
+			if bytes.Equal(metadata, prev.Metadata) {
+				info.MetadataOverride = prev.MetadataOverride
+			} else {
+				atomic.AddInt32(&r.metadataOverrides, -1)
+				log.Infow("Provider metadata supersedes metadata override", "provider", providerID,
+					"contextID", base64.StdEncoding.EncodeToString(contextID))
+			}
+		}
+	}
+	provContexts[string(contextID)] = info
+	r.indexContextTransport(info)
+
+	if r.dstore == nil {
+		return nil
+	}
+	value, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	return r.dstore.Put(ctx, contextDsKey(providerID, contextID), value)
+}
+
+// swapAdEntryCount records the number of multihashes that an advertisement
+// added to a provider context, and returns the number previously recorded for
+// the advertisement. Counts are only recorded when the registry has a
+// datastore and the advertisement CID is defined.
+func (r *Registry) swapAdEntryCount(ctx context.Context, providerID peer.ID, contextID []byte, adID cid.Cid, entryCount uint64) (uint64, error) {
+	if r.dstore == nil || !adID.Defined() {
+		return 0, nil
+	}
+	key := adCountDsPrefix(providerID, contextID).ChildString(adID.String())
+	var prevCount uint64
+	value, err := r.dstore.Get(ctx, key)
+	if err == nil {
+		prevCount, _, err = varint.FromUvarint(value)
+		if err != nil {
+			return 0, fmt.Errorf("cannot decode advertisement entry count: %w", err)
+		}
+	} else if !errors.Is(err, datastore.ErrNotFound) {
+		return 0, err
+	}
+	if entryCount == 0 && prevCount == 0 {
+		return 0, nil
+	}
+	if err = r.dstore.Put(ctx, key, varint.ToUvarint(entryCount)); err != nil {
+		return 0, err
+	}
+	return prevCount, nil
+}
+
+// deleteAdEntryCounts deletes the advertisement entry counts recorded for a
+// provider context.
+func (r *Registry) deleteAdEntryCounts(ctx context.Context, providerID peer.ID, contextID []byte) error {
+	q := query.Query{
+		Prefix:   adCountDsPrefix(providerID, contextID).String(),
+		KeysOnly: true,
+	}
+	results, err := r.dstore.Query(ctx, q)
+	if err != nil {
+		return err
+	}
+	ents, err := results.Rest()
+	if err != nil {
+		return err
+	}
+	for _, ent := range ents {
+		if err = r.dstore.Delete(ctx, datastore.NewKey(ent.Key)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Registry) syncRemoveContextEntries(ctx context.Context, providerID peer.ID, contextID []byte, count uint64) error {
+	prev, ok := r.contexts[providerID][string(contextID)]
+	if !ok {
+		return nil
+	}
+
+	// ContextInfo is immutable, so replace it with an updated copy.
+	info := *prev
+	if count > info.EntryCount {
+		info.EntryCount = 0
+	} else {
+		info.EntryCount -= count
+	}
+	r.contexts[providerID][string(contextID)] = &info
+
+	if r.dstore == nil {
+		return nil
+	}
+	value, err := json.Marshal(&info)
+	if err != nil {
+		return err
+	}
+	return r.dstore.Put(ctx, contextDsKey(providerID, contextID), value)
+}
+
+func (r *Registry) syncSetMetadataOverride(ctx context.Context, providerID peer.ID, contextID, metadata []byte) error {
+	prev, ok := r.contexts[providerID][string(contextID)]
+	if !ok {
+		return ErrContextNotFound
+	}
+
+	// ContextInfo is immutable, so replace it with an updated copy.
+	info := *prev
+	info.MetadataOverride = metadata
+	r.contexts[providerID][string(contextID)] = &info
+	r.indexContextTransport(&info)
+	if prev.MetadataOverride == nil && metadata != nil {
+		atomic.AddInt32(&r.metadataOverrides, 1)
+	} else if prev.MetadataOverride != nil && metadata == nil {
+		atomic.AddInt32(&r.metadataOverrides, -1)
+	}
+
+	if r.dstore == nil {
+		return nil
+	}
+	value, err := json.Marshal(&info)
+	if err != nil {
+		return err
+	}
+	return r.dstore.Put(ctx, contextDsKey(providerID, contextID), value)
+}
+
+func (r *Registry) syncRemoveContext(ctx context.Context, providerID peer.ID, contextID []byte) error {
+	provContexts, ok := r.contexts[providerID]
+	if !ok {
+		return nil
+	}
+	prev, ok := provContexts[string(contextID)]
+	if !ok {
+		return nil
+	}
+	if prev.MetadataOverride != nil {
+		atomic.AddInt32(&r.metadataOverrides, -1)
+	}
+	delete(provContexts, string(contextID))
+	if len(provContexts) == 0 {
+		delete(r.contexts, providerID)
+	}
+	r.unindexContextTransport(providerID, contextID)
+
+	if r.dstore == nil {
+		return nil
+	}
+	if err := r.deleteAdEntryCounts(ctx, providerID, contextID); err != nil {
+		return err
+	}
+	return r.dstore.Delete(ctx, contextDsKey(providerID, contextID))
+}
+
+// syncRemoveAllContexts removes the records of all of a provider's contexts.
+func (r *Registry) syncRemoveAllContexts(ctx context.Context, providerID peer.ID) error {
+	provContexts := r.contexts[providerID]
+	delete(r.contexts, providerID)
+	r.unindexProviderTransports(providerID)
+	for _, info := range provContexts {
+		if info.MetadataOverride != nil {
+			atomic.AddInt32(&r.metadataOverrides, -1)
+		}
+	}
+
+	if r.dstore == nil {
+		return nil
+	}
+	for _, info := range provContexts {
+		if err := r.deleteAdEntryCounts(ctx, providerID, info.ContextID); err != nil {
+			return err
+		}
+		if err := r.dstore.Delete(ctx, contextDsKey(providerID, info.ContextID)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Registry) loadPersistedContexts(ctx context.Context) (int, error) {
+	if r.dstore == nil {
+		return 0, nil
+	}
+
+	q := query.Query{
+		Prefix: contextKeyPath,
+	}
+	results, err := r.dstore.Query(ctx, q)
+	if err != nil {
+		return 0, err
+	}
+	defer results.Close()
+
+	var count int
+	for result := range results.Next() {
+		if result.Error != nil {
+			if r.safeMode.Skip("provider context", result.Key, result.Error) {
+				continue
+			}
+			return 0, fmt.Errorf("cannot read provider context data: %v", result.Error)
+		}
+		info := new(ContextInfo)
+		if err = json.Unmarshal(result.Entry.Value, info); err != nil {
+			if r.safeMode.Skip("provider context", result.Key, err) {
+				continue
+			}
+			return 0, err
+		}
+		provContexts, ok := r.contexts[info.ProviderID]
+		if !ok {
+			provContexts = map[string]*ContextInfo{}
+			r.contexts[info.ProviderID] = provContexts
+		}
+		provContexts[string(info.ContextID)] = info
+		r.indexContextTransport(info)
+		if info.MetadataOverride != nil {
+			atomic.AddInt32(&r.metadataOverrides, 1)
+		}
+		count++
+	}
+	return count, nil
+}
@@ -0,0 +1,252 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	leveldb "github.com/ipfs/go-ds-leveldb"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+func TestProviderContexts(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	peerID, err := peer.Decode(limitedID)
+	if err != nil {
+		t.Fatal("bad provider ID:", err)
+	}
+	adCid, err := cid.Decode("bafybeigvgzoolc3drupxhlevdp2ugqcrbcsqfmcek2zxiw5wctk3xjpjwy")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dataStorePath := t.TempDir()
+	dstore, err := leveldb.NewDatastore(dataStorePath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewRegistry(ctx, discoveryCfg, dstore, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctxID1 := []byte("ctx-1")
+	ctxID2 := []byte("ctx-2")
+	if err = r.UpdateProviderContext(ctx, peerID, ctxID1, []byte("meta-a"), 10, adCid); err != nil {
+		t.Fatal(err)
+	}
+	adCid2, err := cid.Decode("bafybeihdwdcefgh4dqkjv67uzcmw7ojee6xedzdetojuzjevtenxquvyku")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = r.UpdateProviderContext(ctx, peerID, ctxID1, []byte("meta-b"), 5, adCid2); err != nil {
+		t.Fatal(err)
+	}
+	// Updating the context with the same advertisement again replaces its
+	// entry count instead of adding to it.
+	if err = r.UpdateProviderContext(ctx, peerID, ctxID1, []byte("meta-b"), 5, adCid2); err != nil {
+		t.Fatal(err)
+	}
+	if err = r.UpdateProviderContext(ctx, peerID, ctxID2, nil, 3, adCid); err != nil {
+		t.Fatal(err)
+	}
+	if err = r.RemoveProviderContext(ctx, peerID, ctxID2); err != nil {
+		t.Fatal(err)
+	}
+	if err = r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Check that the contexts are loaded from the datastore.
+	dstore, err = leveldb.NewDatastore(dataStorePath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err = NewRegistry(ctx, discoveryCfg, dstore, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	infos := r.ProviderContexts(peerID)
+	if len(infos) != 1 {
+		t.Fatalf("expected 1 context, got %d", len(infos))
+	}
+	info := infos[0]
+	if !bytes.Equal(info.ContextID, ctxID1) {
+		t.Error("wrong context id")
+	}
+	if !bytes.Equal(info.Metadata, []byte("meta-b")) {
+		t.Error("wrong metadata")
+	}
+	if info.EntryCount != 15 {
+		t.Errorf("expected entry count 15, got %d", info.EntryCount)
+	}
+	if info.LastAdvertisement != adCid2 {
+		t.Error("wrong last advertisement")
+	}
+
+	// Removing the provider removes its contexts.
+	done := make(chan error)
+	r.actions <- func() {
+		done <- r.syncRemoveProvider(ctx, peerID)
+	}
+	if err = <-done; err != nil {
+		t.Fatal(err)
+	}
+	if len(r.ProviderContexts(peerID)) != 0 {
+		t.Fatal("expected no contexts after removing provider")
+	}
+}
+
+func TestProviderEntryCount(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	peerID, err := peer.Decode(limitedID)
+	if err != nil {
+		t.Fatal("bad provider ID:", err)
+	}
+
+	dataStorePath := t.TempDir()
+	dstore, err := leveldb.NewDatastore(dataStorePath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewRegistry(ctx, discoveryCfg, dstore, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if count := r.ProviderEntryCount(peerID); count != 0 {
+		t.Fatalf("expected entry count 0, got %d", count)
+	}
+	if err = r.UpdateProviderContext(ctx, peerID, []byte("ctx-1"), nil, 10, cid.Undef); err != nil {
+		t.Fatal(err)
+	}
+	if err = r.UpdateProviderContext(ctx, peerID, []byte("ctx-2"), nil, 3, cid.Undef); err != nil {
+		t.Fatal(err)
+	}
+	if err = r.RemoveContextEntries(ctx, peerID, []byte("ctx-1"), 2); err != nil {
+		t.Fatal(err)
+	}
+	// Removing more entries than the context has leaves none.
+	if err = r.RemoveContextEntries(ctx, peerID, []byte("ctx-2"), 5); err != nil {
+		t.Fatal(err)
+	}
+	// Removing entries from an unknown context does nothing.
+	if err = r.RemoveContextEntries(ctx, peerID, []byte("ctx-3"), 1); err != nil {
+		t.Fatal(err)
+	}
+	if count := r.ProviderEntryCount(peerID); count != 8 {
+		t.Fatalf("expected entry count 8, got %d", count)
+	}
+	if err = r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Check that the count survives a restart.
+	dstore, err = leveldb.NewDatastore(dataStorePath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err = NewRegistry(ctx, discoveryCfg, dstore, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	if count := r.ProviderEntryCount(peerID); count != 8 {
+		t.Fatalf("expected entry count 8 after restart, got %d", count)
+	}
+}
+
+func TestMetadataOverride(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	peerID, err := peer.Decode(limitedID)
+	if err != nil {
+		t.Fatal("bad provider ID:", err)
+	}
+
+	dataStorePath := t.TempDir()
+	dstore, err := leveldb.NewDatastore(dataStorePath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewRegistry(ctx, discoveryCfg, dstore, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctxID := []byte("ctx-1")
+	override := []byte("meta-fixed")
+	if err = r.SetMetadataOverride(ctx, peerID, ctxID, override); err != ErrContextNotFound {
+		t.Fatalf("expected ErrContextNotFound, got %v", err)
+	}
+	if err = r.UpdateProviderContext(ctx, peerID, ctxID, []byte("meta-bad"), 1, cid.Undef); err != nil {
+		t.Fatal(err)
+	}
+	if err = r.SetMetadataOverride(ctx, peerID, ctxID, nil); err != ErrNoMetadata {
+		t.Fatalf("expected ErrNoMetadata, got %v", err)
+	}
+	if err = r.SetMetadataOverride(ctx, peerID, ctxID, override); err != nil {
+		t.Fatal(err)
+	}
+	if !r.HasMetadataOverrides() {
+		t.Fatal("expected metadata overrides")
+	}
+	// An advertisement with the same metadata keeps the override.
+	if err = r.UpdateProviderContext(ctx, peerID, ctxID, []byte("meta-bad"), 1, cid.Undef); err != nil {
+		t.Fatal(err)
+	}
+	if err = r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Check that the override is loaded from the datastore.
+	dstore, err = leveldb.NewDatastore(dataStorePath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err = NewRegistry(ctx, discoveryCfg, dstore, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	if !r.HasMetadataOverrides() {
+		t.Fatal("expected metadata overrides after restart")
+	}
+	info := r.ProviderContext(peerID, ctxID)
+	if !bytes.Equal(info.MetadataOverride, override) {
+		t.Fatal("wrong metadata override")
+	}
+
+	// Advertising different metadata supersedes the override.
+	if err = r.UpdateProviderContext(ctx, peerID, ctxID, []byte("meta-new"), 1, cid.Undef); err != nil {
+		t.Fatal(err)
+	}
+	if r.ProviderContext(peerID, ctxID).MetadataOverride != nil {
+		t.Fatal("expected override to be superseded")
+	}
+	if r.HasMetadataOverrides() {
+		t.Fatal("expected no metadata overrides")
+	}
+
+	// Clear an override.
+	if err = r.SetMetadataOverride(ctx, peerID, ctxID, override); err != nil {
+		t.Fatal(err)
+	}
+	if err = r.ClearMetadataOverride(ctx, peerID, ctxID); err != nil {
+		t.Fatal(err)
+	}
+	if r.ProviderContext(peerID, ctxID).MetadataOverride != nil || r.HasMetadataOverrides() {
+		t.Fatal("expected override to be cleared")
+	}
+}
@@ -4,10 +4,16 @@ import "errors"
 
 var (
 	ErrInProgress          = errors.New("discovery already in progress")
+	ErrBadAlias            = errors.New("bad provider alias")
+	ErrBadTag              = errors.New("bad provider tag")
 	ErrCannotPublish       = errors.New("publisher not allowed to publish to other provider")
+	ErrContextNotFound     = errors.New("provider context not found")
 	ErrNotAllowed          = errors.New("provider not allowed by policy")
 	ErrNoDiscovery         = errors.New("discovery not available")
+	ErrNoMetadata          = errors.New("missing metadata")
 	ErrNotVerified         = errors.New("provider cannot be verified")
+	ErrProviderNotFound    = errors.New("provider not found")
 	ErrPublisherNotAllowed = errors.New("publisher not allowed by policy")
+	ErrRegisterInProgress  = errors.New("registration already in progress")
 	ErrTooSoon             = errors.New("not enough time since previous discovery")
 )
@@ -0,0 +1,77 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// ReadProviders reads the information about all providers that is persisted in
+// the datastore, sorted by provider ID. This is used to export the registry of
+// an indexer that is not running.
+func ReadProviders(ctx context.Context, dstore datastore.Datastore) ([]*ProviderInfo, error) {
+	results, err := dstore.Query(ctx, query.Query{
+		Prefix: providerKeyPath,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer results.Close()
+
+	var infos []*ProviderInfo
+	for result := range results.Next() {
+		if result.Error != nil {
+			return nil, fmt.Errorf("cannot read provider data: %v", result.Error)
+		}
+		peerID, err := peer.Decode(path.Base(result.Key))
+		if err != nil {
+			return nil, fmt.Errorf("cannot decode provider ID: %s", err)
+		}
+		pinfo := new(ProviderInfo)
+		if err = json.Unmarshal(result.Value, pinfo); err != nil {
+			return nil, fmt.Errorf("cannot decode info for provider %s: %w", peerID, err)
+		}
+		infos = append(infos, pinfo)
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].AddrInfo.ID < infos[j].AddrInfo.ID
+	})
+	return infos, nil
+}
+
+// WriteProviders persists provider information in the datastore, so that the
+// providers are loaded into the registry when the indexer is started. A
+// provider that is already in the datastore is not written unless overwrite
+// is true. Returns the number of providers written.
+func WriteProviders(ctx context.Context, dstore datastore.Datastore, infos []*ProviderInfo, overwrite bool) (int, error) {
+	var count int
+	for _, info := range infos {
+		if err := info.AddrInfo.ID.Validate(); err != nil {
+			return count, fmt.Errorf("bad provider ID: %w", err)
+		}
+		if !overwrite {
+			has, err := dstore.Has(ctx, info.dsKey())
+			if err != nil {
+				return count, err
+			}
+			if has {
+				continue
+			}
+		}
+		value, err := json.Marshal(info)
+		if err != nil {
+			return count, err
+		}
+		if err = dstore.Put(ctx, info.dsKey(), value); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, dstore.Sync(ctx, datastore.NewKey(providerKeyPath))
+}
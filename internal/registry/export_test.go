@@ -0,0 +1,100 @@
+package registry
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/multiformats/go-multiaddr"
+)
+
+func TestExportImportProviders(t *testing.T) {
+	mockDiscoverer := newMockDiscoverer(t, exceptID)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	peerID, err := peer.Decode(limitedID)
+	if err != nil {
+		t.Fatal("bad provider ID:", err)
+	}
+	maddr, err := multiaddr.NewMultiaddr(minerAddr)
+	if err != nil {
+		t.Fatal("bad miner address:", err)
+	}
+	pubID, err := peer.Decode(publisherID)
+	if err != nil {
+		t.Fatal("bad publisher ID:", err)
+	}
+	pubAddr, err := multiaddr.NewMultiaddr(publisherAddr)
+	if err != nil {
+		t.Fatal("bad publisher address:", err)
+	}
+	info := &ProviderInfo{
+		AddrInfo: peer.AddrInfo{
+			ID:    peerID,
+			Addrs: []multiaddr.Multiaddr{maddr},
+		},
+		Publisher:     pubID,
+		PublisherAddr: pubAddr,
+	}
+
+	dstore := dssync.MutexWrap(datastore.NewMapDatastore())
+	r, err := NewRegistry(ctx, discoveryCfg, dstore, mockDiscoverer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = r.Register(ctx, info); err != nil {
+		t.Fatal("failed to register directly:", err)
+	}
+	r.Close()
+
+	infos, err := ReadProviders(ctx, dstore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 1 {
+		t.Fatalf("expected 1 provider, got %d", len(infos))
+	}
+
+	// Import into a new datastore and check that the registry loads it.
+	newStore := dssync.MutexWrap(datastore.NewMapDatastore())
+	count, err := WriteProviders(ctx, newStore, infos, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Fatalf("expected 1 provider written, got %d", count)
+	}
+
+	// Importing again should skip the existing provider.
+	count, err = WriteProviders(ctx, newStore, infos, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Fatalf("expected existing provider to be skipped, got %d written", count)
+	}
+
+	r, err = NewRegistry(ctx, discoveryCfg, newStore, mockDiscoverer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	pinfo := r.ProviderInfo(peerID)
+	if pinfo == nil {
+		t.Fatal("did not find imported provider")
+	}
+	if len(pinfo.AddrInfo.Addrs) != 1 || !pinfo.AddrInfo.Addrs[0].Equal(maddr) {
+		t.Fatal("imported provider has wrong address")
+	}
+	if pinfo.Publisher != pubID {
+		t.Fatal("imported provider has wrong publisher")
+	}
+	if pinfo.PublisherAddr == nil || !pinfo.PublisherAddr.Equal(pubAddr) {
+		t.Fatal("imported provider has wrong publisher address")
+	}
+}
@@ -0,0 +1,96 @@
+package registry
+
+import (
+	"sync"
+
+	"github.com/libp2p/go-libp2p-core/connmgr"
+	"github.com/libp2p/go-libp2p-core/control"
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// ConnGater is a libp2p connection gater that rejects connections with peers
+// that are explicitly blocked by the registry's policy. The policy is checked
+// for each connection, so the gater follows policy changes made while the
+// indexer is running.
+type ConnGater struct {
+	reg *Registry
+
+	host      host.Host
+	hostMutex sync.Mutex
+}
+
+var _ connmgr.ConnectionGater = (*ConnGater)(nil)
+
+// ConnGater returns the registry's connection gater, creating it on first
+// use. Once the gater is given a host with SetHost, blocking a peer in the
+// registry also closes the host's existing connections with the peer.
+func (r *Registry) ConnGater() *ConnGater {
+	r.connGaterMutex.Lock()
+	defer r.connGaterMutex.Unlock()
+	if r.connGater == nil {
+		r.connGater = &ConnGater{
+			reg: r,
+		}
+	}
+	return r.connGater
+}
+
+// SetHost sets the host that is disconnected from peers that become blocked.
+// The host is created using the gater, so is given to the gater afterwards.
+func (g *ConnGater) SetHost(h host.Host) {
+	g.hostMutex.Lock()
+	g.host = h
+	g.hostMutex.Unlock()
+	g.disconnectBlocked()
+}
+
+// disconnectBlocked closes the host's connections with all blocked peers.
+func (g *ConnGater) disconnectBlocked() {
+	g.hostMutex.Lock()
+	h := g.host
+	g.hostMutex.Unlock()
+	if h == nil {
+		return
+	}
+	for _, peerID := range h.Network().Peers() {
+		if !g.reg.policy.Blocked(peerID) {
+			continue
+		}
+		if err := h.Network().ClosePeer(peerID); err != nil {
+			log.Warnw("Cannot close connection with blocked peer", "peer", peerID, "err", err)
+			continue
+		}
+		log.Infow("Closed connection with blocked peer", "peer", peerID)
+	}
+}
+
+// InterceptPeerDial rejects dialing a blocked peer.
+func (g *ConnGater) InterceptPeerDial(p peer.ID) bool {
+	return !g.reg.policy.Blocked(p)
+}
+
+// InterceptAddrDial rejects dialing any address of a blocked peer.
+func (g *ConnGater) InterceptAddrDial(p peer.ID, _ multiaddr.Multiaddr) bool {
+	return !g.reg.policy.Blocked(p)
+}
+
+// InterceptAccept accepts all inbound connections, since the remote peer is
+// not known until the connection is secured.
+func (g *ConnGater) InterceptAccept(network.ConnMultiaddrs) bool {
+	return true
+}
+
+// InterceptSecured rejects connections with a blocked peer, once the peer is
+// known.
+func (g *ConnGater) InterceptSecured(_ network.Direction, p peer.ID, _ network.ConnMultiaddrs) bool {
+	return !g.reg.policy.Blocked(p)
+}
+
+// InterceptUpgraded accepts all upgraded connections, which were already
+// checked by InterceptSecured.
+func (g *ConnGater) InterceptUpgraded(network.Conn) (bool, control.DisconnectReason) {
+	return true, 0
+}
@@ -0,0 +1,68 @@
+package registry
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/storetheindex/config"
+	"github.com/ipfs/go-datastore"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/network"
+)
+
+func TestConnGater(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	r, err := NewRegistry(ctx, config.NewDiscovery(), datastore.NewMapDatastore(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	gatedHost, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"), libp2p.ConnectionGater(r.ConnGater()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer gatedHost.Close()
+	r.ConnGater().SetHost(gatedHost)
+
+	otherHost, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer otherHost.Close()
+
+	connect := func(from, to host.Host) error {
+		from.Peerstore().AddAddrs(to.ID(), to.Addrs(), time.Minute)
+		return from.Connect(ctx, from.Peerstore().PeerInfo(to.ID()))
+	}
+
+	if err = connect(otherHost, gatedHost); err != nil {
+		t.Fatalf("allowed peer could not connect: %s", err)
+	}
+
+	// Blocking the peer closes the existing connection.
+	if !r.BlockPeer(otherHost.ID()) {
+		t.Fatal("expected policy to be updated")
+	}
+	if gatedHost.Network().Connectedness(otherHost.ID()) == network.Connected {
+		t.Fatal("expected connection with blocked peer to be closed")
+	}
+
+	// Connections with the blocked peer are rejected in both directions.
+	if err = connect(otherHost, gatedHost); err == nil {
+		t.Fatal("expected connection from blocked peer to be rejected")
+	}
+	if err = connect(gatedHost, otherHost); err == nil {
+		t.Fatal("expected connection to blocked peer to be rejected")
+	}
+
+	// Allowing the peer lets connections with it be made again.
+	r.AllowPeer(otherHost.ID())
+	if err = connect(gatedHost, otherHost); err != nil {
+		t.Fatalf("allowed peer could not connect: %s", err)
+	}
+}
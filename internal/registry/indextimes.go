@@ -0,0 +1,89 @@
+package registry
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/multiformats/go-multihash"
+	"github.com/multiformats/go-varint"
+)
+
+// indexTimeKeyPath is where the times that multihashes were indexed are
+// stored in the indexer repo.
+const indexTimeKeyPath = "/registry/indextime"
+
+func indexTimeDsPrefix(providerID peer.ID, contextID []byte) string {
+	return path.Join(indexTimeKeyPath, providerID.String(), base64.RawURLEncoding.EncodeToString(contextID))
+}
+
+func indexTimeDsKey(providerID peer.ID, contextID []byte, mh multihash.Multihash) datastore.Key {
+	return datastore.NewKey(path.Join(indexTimeDsPrefix(providerID, contextID), mh.B58String()))
+}
+
+// PutIndexTimes records the time that each multihash was indexed for the
+// provider's context, replacing any earlier time. Nothing is recorded if the
+// registry has no datastore.
+func (r *Registry) PutIndexTimes(ctx context.Context, providerID peer.ID, contextID []byte, mhs []multihash.Multihash, t time.Time) error {
+	if r.dstore == nil || len(mhs) == 0 {
+		return nil
+	}
+	value := varint.ToUvarint(uint64(t.UnixNano()))
+	return r.batchWrite(ctx, len(mhs), func(ds datastore.Write, i int) error {
+		return ds.Put(ctx, indexTimeDsKey(providerID, contextID, mhs[i]), value)
+	})
+}
+
+// RemoveIndexTimes removes the recorded index times of the multihashes in
+// the provider's context.
+func (r *Registry) RemoveIndexTimes(ctx context.Context, providerID peer.ID, contextID []byte, mhs []multihash.Multihash) error {
+	if r.dstore == nil || len(mhs) == 0 {
+		return nil
+	}
+	return r.batchWrite(ctx, len(mhs), func(ds datastore.Write, i int) error {
+		return ds.Delete(ctx, indexTimeDsKey(providerID, contextID, mhs[i]))
+	})
+}
+
+// IndexTime returns the time that the multihash was indexed for the
+// provider's context. Returns false if no time is recorded, such as when the
+// multihash was indexed with Ingest.IndexTimestamps disabled.
+func (r *Registry) IndexTime(ctx context.Context, providerID peer.ID, contextID []byte, mh multihash.Multihash) (time.Time, bool, error) {
+	if r.dstore == nil {
+		return time.Time{}, false, nil
+	}
+	value, err := r.dstore.Get(ctx, indexTimeDsKey(providerID, contextID, mh))
+	if err != nil {
+		if err == datastore.ErrNotFound {
+			return time.Time{}, false, nil
+		}
+		return time.Time{}, false, err
+	}
+	nanos, _, err := varint.FromUvarint(value)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("bad index time stored for %s: %w", mh.B58String(), err)
+	}
+	return time.Unix(0, int64(nanos)), true, nil
+}
+
+// removeContextIndexTimes removes the recorded index times of all the
+// multihashes in the provider's context.
+func (r *Registry) removeContextIndexTimes(ctx context.Context, providerID peer.ID, contextID []byte) error {
+	if r.dstore == nil {
+		return nil
+	}
+	return r.deletePrefix(ctx, indexTimeDsPrefix(providerID, contextID))
+}
+
+// removeAllIndexTimes removes the recorded index times of all the provider's
+// multihashes.
+func (r *Registry) removeAllIndexTimes(ctx context.Context, providerID peer.ID) error {
+	if r.dstore == nil {
+		return nil
+	}
+	return r.deletePrefix(ctx, path.Join(indexTimeKeyPath, providerID.String()))
+}
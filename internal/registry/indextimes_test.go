@@ -0,0 +1,91 @@
+package registry
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/storetheindex/test/util"
+	"github.com/ipfs/go-datastore"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+func TestIndexTimes(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	provID, err := peer.Decode(limitedID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherID, err := peer.Decode(limitedID2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewRegistry(ctx, discoveryCfg, datastore.NewMapDatastore(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	ctxID := []byte("ctx-1")
+	otherCtxID := []byte("ctx-2")
+	mhs := util.RandomMultihashes(3, rand.New(rand.NewSource(1413)))
+	t1 := time.Unix(1600000000, 1)
+	t2 := time.Unix(1600000001, 2)
+	if err = r.PutIndexTimes(ctx, provID, ctxID, mhs[:2], t1); err != nil {
+		t.Fatal(err)
+	}
+	if err = r.PutIndexTimes(ctx, provID, otherCtxID, mhs[2:], t2); err != nil {
+		t.Fatal(err)
+	}
+	if err = r.PutIndexTimes(ctx, otherID, ctxID, mhs[:1], t2); err != nil {
+		t.Fatal(err)
+	}
+
+	indexTime, ok, err := r.IndexTime(ctx, provID, ctxID, mhs[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok || !indexTime.Equal(t1) {
+		t.Fatalf("expected index time %s, got %s, %v", t1, indexTime, ok)
+	}
+	// Index times are recorded per provider context.
+	if _, ok, _ = r.IndexTime(ctx, provID, otherCtxID, mhs[1]); ok {
+		t.Fatal("index time of other context's multihash returned")
+	}
+	if indexTime, _, _ = r.IndexTime(ctx, otherID, ctxID, mhs[0]); !indexTime.Equal(t2) {
+		t.Fatal("wrong index time for other provider")
+	}
+
+	if err = r.RemoveIndexTimes(ctx, provID, ctxID, mhs[:1]); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ = r.IndexTime(ctx, provID, ctxID, mhs[0]); ok {
+		t.Fatal("index time not removed")
+	}
+
+	// Removing a context removes the index times of its multihashes.
+	if err = r.RemoveProviderContext(ctx, provID, ctxID); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ = r.IndexTime(ctx, provID, ctxID, mhs[1]); ok {
+		t.Fatal("index time not removed with context")
+	}
+	if _, ok, _ = r.IndexTime(ctx, provID, otherCtxID, mhs[2]); !ok {
+		t.Fatal("index time of other context removed")
+	}
+
+	// Removing a provider removes all its index times.
+	if err = r.RemoveProvider(ctx, provID); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ = r.IndexTime(ctx, provID, otherCtxID, mhs[2]); ok {
+		t.Fatal("index time not removed with provider")
+	}
+	if _, ok, _ = r.IndexTime(ctx, otherID, ctxID, mhs[0]); !ok {
+		t.Fatal("index time of other provider removed")
+	}
+}
@@ -2,6 +2,7 @@ package policy
 
 import (
 	"fmt"
+	"sort"
 	"sync"
 
 	"github.com/filecoin-project/storetheindex/config"
@@ -13,6 +14,17 @@ type Policy struct {
 	allow   peerutil.Policy
 	publish peerutil.Policy
 	rwmutex sync.RWMutex
+
+	// trusted is the set of providers whose advertisements may be ingested
+	// without signature verification.
+	trusted map[peer.ID]struct{}
+
+	// remoteAllow is the set of peers allowed by a remote allowlist, in
+	// addition to the peers allowed by the allow policy.
+	remoteAllow map[peer.ID]struct{}
+	// blocked is the set of peers blocked by Block. These peers are not
+	// allowed even if they are on the remote allowlist.
+	blocked map[peer.ID]struct{}
 }
 
 func New(cfg config.Policy) (*Policy, error) {
@@ -26,19 +38,55 @@ func New(cfg config.Policy) (*Policy, error) {
 		return nil, fmt.Errorf("bad publish policy: %s", err)
 	}
 
+	var trusted map[peer.ID]struct{}
+	if len(cfg.Trusted) != 0 {
+		trusted = make(map[peer.ID]struct{}, len(cfg.Trusted))
+		for _, idStr := range cfg.Trusted {
+			peerID, err := peer.Decode(idStr)
+			if err != nil {
+				return nil, fmt.Errorf("bad trusted peer ID %q: %s", idStr, err)
+			}
+			trusted[peerID] = struct{}{}
+		}
+	}
+
 	return &Policy{
 		allow:   allow,
 		publish: publish,
+		trusted: trusted,
 	}, nil
 }
 
-// Allowed returns true if the policy allows the peer to index content.
+// Allowed returns true if the policy allows the peer to index content. A peer
+// that is explicitly blocked is not allowed, even if it is on the remote
+// allowlist.
 func (p *Policy) Allowed(peerID peer.ID) bool {
 	p.rwmutex.RLock()
 	defer p.rwmutex.RUnlock()
+	if p.explicitlyBlocked(peerID) {
+		return false
+	}
+	if _, ok := p.remoteAllow[peerID]; ok {
+		return true
+	}
 	return p.allow.Eval(peerID)
 }
 
+// Blocked returns true if the peer is explicitly blocked, either by being an
+// exception to a policy that allows peers by default, or by Block.
+func (p *Policy) Blocked(peerID peer.ID) bool {
+	p.rwmutex.RLock()
+	defer p.rwmutex.RUnlock()
+	return p.explicitlyBlocked(peerID)
+}
+
+func (p *Policy) explicitlyBlocked(peerID peer.ID) bool {
+	if _, ok := p.blocked[peerID]; ok {
+		return true
+	}
+	return p.allow.Default() && !p.allow.Eval(peerID)
+}
+
 // PublishAllowed returns true if policy allows the publisher to publish
 // advertisements for the identified provider.  This assumes that both are
 // already allowed by policy.
@@ -52,20 +100,52 @@ func (p *Policy) PublishAllowed(publisherID, providerID peer.ID) bool {
 	return p.publish.Eval(publisherID)
 }
 
+// Trusted returns true if the peer is a trusted provider.
+func (p *Policy) Trusted(peerID peer.ID) bool {
+	p.rwmutex.RLock()
+	defer p.rwmutex.RUnlock()
+	_, ok := p.trusted[peerID]
+	return ok
+}
+
 // Allow alters the policy to allow the specified peer.  Returns true if the
 // policy needed to be updated.
 func (p *Policy) Allow(peerID peer.ID) bool {
 	p.rwmutex.Lock()
 	defer p.rwmutex.Unlock()
-	return p.allow.SetPeer(peerID, true)
+	_, wasBlocked := p.blocked[peerID]
+	delete(p.blocked, peerID)
+	return p.allow.SetPeer(peerID, true) || wasBlocked
 }
 
-// Block alters the policy to not allow the specified peer.  Returns true if
-// the policy needed to be updated.
+// Block alters the policy to not allow the specified peer, even if it is on
+// the remote allowlist.  Returns true if the policy needed to be updated.
 func (p *Policy) Block(peerID peer.ID) bool {
 	p.rwmutex.Lock()
 	defer p.rwmutex.Unlock()
-	return p.allow.SetPeer(peerID, false)
+	_, wasBlocked := p.blocked[peerID]
+	if !wasBlocked {
+		if p.blocked == nil {
+			p.blocked = make(map[peer.ID]struct{})
+		}
+		p.blocked[peerID] = struct{}{}
+	}
+	return p.allow.SetPeer(peerID, false) || !wasBlocked
+}
+
+// SetRemoteAllow replaces the set of peers allowed by a remote allowlist.
+// These peers are allowed by the allow policy unless they are explicitly
+// blocked. Changes to the allow policy, and copying another policy, do not
+// alter this set.
+func (p *Policy) SetRemoteAllow(peerIDs []peer.ID) {
+	remoteAllow := make(map[peer.ID]struct{}, len(peerIDs))
+	for _, peerID := range peerIDs {
+		remoteAllow[peerID] = struct{}{}
+	}
+
+	p.rwmutex.Lock()
+	defer p.rwmutex.Unlock()
+	p.remoteAllow = remoteAllow
 }
 
 // Copy copies another policy.
@@ -74,25 +154,52 @@ func (p *Policy) Copy(other *Policy) {
 	defer p.rwmutex.Unlock()
 
 	other.rwmutex.RLock()
-	p.allow = other.allow
-	p.publish = other.publish
+	p.allow = other.allow.Copy()
+	p.publish = other.publish.Copy()
+	p.trusted = copyPeerSet(other.trusted)
+	p.blocked = copyPeerSet(other.blocked)
 	other.rwmutex.RUnlock()
 }
 
+// copyPeerSet returns a copy of the set of peers, so that changing one policy's
+// set does not change another's.
+func copyPeerSet(peers map[peer.ID]struct{}) map[peer.ID]struct{} {
+	if peers == nil {
+		return nil
+	}
+	cp := make(map[peer.ID]struct{}, len(peers))
+	for peerID := range peers {
+		cp[peerID] = struct{}{}
+	}
+	return cp
+}
+
 // ToConfig converts a Policy into a config.Policy.
 func (p *Policy) ToConfig() config.Policy {
 	p.rwmutex.RLock()
 	defer p.rwmutex.RUnlock()
 
+	var trusted []string
+	if len(p.trusted) != 0 {
+		trusted = make([]string, 0, len(p.trusted))
+		for peerID := range p.trusted {
+			trusted = append(trusted, peerID.String())
+		}
+		sort.Strings(trusted)
+	}
+
 	return config.Policy{
 		Allow:         p.allow.Default(),
 		Except:        p.allow.ExceptStrings(),
 		Publish:       p.publish.Default(),
 		PublishExcept: p.publish.ExceptStrings(),
+		Trusted:       trusted,
 	}
 }
 
 // Return true if no peers are allowed.
 func (p *Policy) NoneAllowed() bool {
-	return !p.allow.Any(true)
+	p.rwmutex.RLock()
+	defer p.rwmutex.RUnlock()
+	return !p.allow.Any(true) && len(p.remoteAllow) == 0
 }
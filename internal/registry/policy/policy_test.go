@@ -168,6 +168,31 @@ func TestPolicyAccess(t *testing.T) {
 		t.Fatal("expected 1 item in cfg.PublishExcept")
 	}
 
+	if p.Trusted(exceptID) {
+		t.Error("peer ID should not be trusted")
+	}
+	policyCfg.Trusted = []string{exceptIDStr}
+	newPol, err = New(policyCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Copy(newPol)
+	if !p.Trusted(exceptID) {
+		t.Error("peer ID should be trusted")
+	}
+	if p.Trusted(otherID) {
+		t.Error("peer ID should not be trusted")
+	}
+	cfg = p.ToConfig()
+	if len(cfg.Trusted) != 1 || cfg.Trusted[0] != exceptIDStr {
+		t.Error("wrong cfg.Trusted")
+	}
+
+	policyCfg.Trusted = []string{"bad ID"}
+	if _, err = New(policyCfg); err == nil {
+		t.Error("expected error with bad trusted ID")
+	}
+
 	p, err = New(config.Policy{})
 	if err != nil {
 		t.Fatal(err)
@@ -176,3 +201,94 @@ func TestPolicyAccess(t *testing.T) {
 		t.Error("expected inaccessible policy")
 	}
 }
+
+func TestBlocked(t *testing.T) {
+	p, err := New(config.Policy{
+		Allow:  true,
+		Except: []string{exceptIDStr},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.Blocked(exceptID) {
+		t.Error("peer in except list should be blocked")
+	}
+	if p.Blocked(otherID) {
+		t.Error("peer should not be blocked")
+	}
+	// A local block is not overridden by the remote allowlist.
+	p.SetRemoteAllow([]peer.ID{exceptID, otherID})
+	if !p.Blocked(exceptID) || p.Allowed(exceptID) {
+		t.Error("peer in except list should be blocked even if on remote allowlist")
+	}
+	if p.Blocked(otherID) || !p.Allowed(otherID) {
+		t.Error("peer on remote allowlist should be allowed")
+	}
+
+	// Peers that are not allowed by a deny-by-default policy are not
+	// explicitly blocked, unless blocked by Block.
+	p, err = New(config.Policy{
+		Allow:  false,
+		Except: []string{exceptIDStr},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Blocked(exceptID) || p.Blocked(otherID) {
+		t.Error("no peer should be explicitly blocked")
+	}
+	p.SetRemoteAllow([]peer.ID{otherID})
+	if !p.Allowed(otherID) {
+		t.Error("peer on remote allowlist should be allowed")
+	}
+	if !p.Block(otherID) {
+		t.Error("blocking peer should update policy")
+	}
+	if !p.Blocked(otherID) || p.Allowed(otherID) {
+		t.Error("blocked peer should not be allowed by remote allowlist")
+	}
+	// The block remains when the remote allowlist is refreshed.
+	p.SetRemoteAllow([]peer.ID{otherID})
+	if p.Allowed(otherID) {
+		t.Error("blocked peer should not be allowed after remote allowlist refresh")
+	}
+	if !p.Allow(otherID) {
+		t.Error("allowing peer should update policy")
+	}
+	if p.Blocked(otherID) || !p.Allowed(otherID) {
+		t.Error("peer should be allowed after being allowed again")
+	}
+}
+
+func TestCopyDoesNotShareState(t *testing.T) {
+	src, err := New(config.Policy{
+		Allow:  true,
+		Except: []string{exceptIDStr},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := New(config.Policy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Copy(src)
+	if !p.Blocked(exceptID) || p.Blocked(otherID) {
+		t.Fatal("copy should block the same peers as source")
+	}
+
+	// Changes to the copy must not change the source.
+	if !p.Block(otherID) {
+		t.Fatal("blocking peer should update policy")
+	}
+	if !p.Allow(exceptID) {
+		t.Fatal("allowing peer should update policy")
+	}
+	if src.Blocked(otherID) || !src.Allowed(otherID) {
+		t.Error("blocking peer in copy should not block it in source")
+	}
+	if !src.Blocked(exceptID) || src.Allowed(exceptID) {
+		t.Error("allowing peer in copy should not allow it in source")
+	}
+}
@@ -0,0 +1,156 @@
+package registry
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/storetheindex/internal/jitter"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// reachabilityWorkers is the maximum number of providers that are probed
+// concurrently.
+const reachabilityWorkers = 16
+
+// Reachability is the result of the latest attempt to connect to a provider's
+// advertised addresses.
+type Reachability int
+
+const (
+	// ReachabilityUnknown means that the provider has not been probed, or
+	// has no addresses that can be probed.
+	ReachabilityUnknown Reachability = iota
+	// Reachable means that a connection was made to one of the provider's
+	// addresses.
+	Reachable
+	// Unreachable means that no connection could be made to any of the
+	// provider's addresses.
+	Unreachable
+)
+
+// reachabilityStatus holds the reachability of each provider.
+type reachabilityStatus struct {
+	mutex  sync.RWMutex
+	status map[peer.ID]Reachability
+}
+
+// Reachability returns the reachability of the provider found by the latest
+// probe. ReachabilityUnknown is returned if reachability probing is not
+// enabled.
+func (r *Registry) Reachability(providerID peer.ID) Reachability {
+	r.reachability.mutex.RLock()
+	defer r.reachability.mutex.RUnlock()
+	return r.reachability.status[providerID]
+}
+
+// runReachabilityCheck probes the addresses of all registered providers each
+// time the interval elapses, until the registry is closed.
+func (r *Registry) runReachabilityCheck(interval, timeout time.Duration) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-r.closing
+		cancel()
+	}()
+
+	timer := time.NewTimer(jitter.Add(interval, r.timerJitter))
+	for {
+		select {
+		case <-timer.C:
+			r.checkReachability(ctx, timeout)
+			timer.Reset(jitter.Add(interval, r.timerJitter))
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// checkReachability probes the addresses of all registered providers, and
+// records the reachability of each.
+func (r *Registry) checkReachability(ctx context.Context, timeout time.Duration) {
+	infos := r.AllProviderInfo()
+	status := make(map[peer.ID]Reachability, len(infos))
+	var statusMutex sync.Mutex
+
+	infoChan := make(chan *ProviderInfo)
+	var wg sync.WaitGroup
+	for i := 0; i < reachabilityWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for info := range infoChan {
+				reach := probeAddrs(ctx, info.AddrInfo.Addrs, timeout)
+				statusMutex.Lock()
+				status[info.AddrInfo.ID] = reach
+				statusMutex.Unlock()
+			}
+		}()
+	}
+send:
+	for _, info := range infos {
+		select {
+		case infoChan <- info:
+		case <-ctx.Done():
+			break send
+		}
+	}
+	close(infoChan)
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		return
+	}
+
+	var unreachable int
+	for _, reach := range status {
+		if reach == Unreachable {
+			unreachable++
+		}
+	}
+	log.Infow("Checked provider reachability", "providers", len(status), "unreachable", unreachable)
+
+	// Replace the previous status, which also drops providers that are no
+	// longer registered.
+	r.reachability.mutex.Lock()
+	r.reachability.status = status
+	r.reachability.mutex.Unlock()
+}
+
+// probeAddrs dials the TCP addresses in addrs until a connection is made.
+// Addresses that are not TCP addresses are not probed.
+func probeAddrs(ctx context.Context, addrs []multiaddr.Multiaddr, timeout time.Duration) Reachability {
+	dialer := net.Dialer{Timeout: timeout}
+	reach := ReachabilityUnknown
+	for _, maddr := range addrs {
+		addr, ok := tcpDialAddr(maddr)
+		if !ok {
+			continue
+		}
+		conn, err := dialer.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			reach = Unreachable
+			continue
+		}
+		conn.Close()
+		return Reachable
+	}
+	return reach
+}
+
+// tcpDialAddr returns the host:port address to dial for a TCP multiaddr.
+func tcpDialAddr(maddr multiaddr.Multiaddr) (string, bool) {
+	port, err := maddr.ValueForProtocol(multiaddr.P_TCP)
+	if err != nil {
+		return "", false
+	}
+	for _, code := range []int{multiaddr.P_IP4, multiaddr.P_IP6, multiaddr.P_DNS4, multiaddr.P_DNS6, multiaddr.P_DNS} {
+		host, err := maddr.ValueForProtocol(code)
+		if err == nil {
+			return net.JoinHostPort(host, port), true
+		}
+	}
+	return "", false
+}
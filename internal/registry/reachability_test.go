@@ -0,0 +1,85 @@
+package registry
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+func TestReachability(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	r, err := NewRegistry(ctx, discoveryCfg, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	// A provider with a listening address is reachable.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	openAddr, err := manet.FromNetAddr(l.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A provider with an address that nothing listens on is unreachable.
+	l2, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedAddr, err := manet.FromNetAddr(l2.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	l2.Close()
+
+	// A provider with no TCP address cannot be probed.
+	quicAddr, err := multiaddr.NewMultiaddr("/ip4/127.0.0.1/udp/9999/quic")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids := []string{limitedID, limitedID2, exceptID}
+	addrs := []multiaddr.Multiaddr{openAddr, closedAddr, quicAddr}
+	expected := []Reachability{Reachable, Unreachable, ReachabilityUnknown}
+	peerIDs := make([]peer.ID, len(ids))
+	for i := range ids {
+		peerIDs[i], err = peer.Decode(ids[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = r.Register(ctx, &ProviderInfo{
+			AddrInfo: peer.AddrInfo{
+				ID:    peerIDs[i],
+				Addrs: []multiaddr.Multiaddr{addrs[i]},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := range peerIDs {
+		if r.Reachability(peerIDs[i]) != ReachabilityUnknown {
+			t.Fatal("expected unknown reachability before check")
+		}
+	}
+
+	r.checkReachability(ctx, time.Second)
+
+	for i := range peerIDs {
+		if reach := r.Reachability(peerIDs[i]); reach != expected[i] {
+			t.Fatalf("expected reachability %d for %s, got %d", expected[i], addrs[i], reach)
+		}
+	}
+}
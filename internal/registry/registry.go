@@ -14,9 +14,11 @@ import (
 	v0 "github.com/filecoin-project/storetheindex/api/v0"
 	httpclient "github.com/filecoin-project/storetheindex/api/v0/finder/client/http"
 	"github.com/filecoin-project/storetheindex/config"
+	"github.com/filecoin-project/storetheindex/internal/jitter"
 	"github.com/filecoin-project/storetheindex/internal/metrics"
 	"github.com/filecoin-project/storetheindex/internal/registry/discovery"
 	"github.com/filecoin-project/storetheindex/internal/registry/policy"
+	"github.com/filecoin-project/storetheindex/internal/safemode"
 	"github.com/ipfs/go-cid"
 	"github.com/ipfs/go-datastore"
 	"github.com/ipfs/go-datastore/query"
@@ -42,6 +44,39 @@ type Registry struct {
 	dstore    datastore.Datastore
 	providers map[peer.ID]*ProviderInfo
 	sequences *sequences
+	// contexts maps a provider ID to information about each of the
+	// provider's contexts, keyed by context ID.
+	contexts map[peer.ID]map[string]*ContextInfo
+	// reachability holds the result of probing each provider's addresses.
+	reachability reachabilityStatus
+	// metadataOverrides is the number of contexts that have a metadata
+	// override, accessed atomically.
+	metadataOverrides int32
+	// transports indexes the transport protocol of each context in contexts.
+	transports      transportIndex
+	transportsMutex sync.RWMutex
+
+	// aliases maps a peer ID that a provider publishes under to the
+	// provider's canonical ID.
+	aliases    map[peer.ID]peer.ID
+	aliasMutex sync.RWMutex
+
+	// lastSeen holds the last time an announcement was received from each
+	// publisher. This is not persisted, for the same reason as
+	// lastContactTime.
+	lastSeen      map[peer.ID]time.Time
+	lastSeenMutex sync.RWMutex
+
+	// blocked is the set of multihashes that are not indexed or returned in
+	// find results.
+	blocked      map[string]struct{}
+	blockedMutex sync.RWMutex
+
+	// registering holds the IDs of providers that have a registration in
+	// progress, so that concurrent registrations of the same provider are not
+	// interleaved.
+	registering      map[peer.ID]struct{}
+	registeringMutex sync.Mutex
 
 	discoverer    discovery.Discoverer
 	discoverWait  sync.WaitGroup
@@ -50,10 +85,34 @@ type Registry struct {
 
 	discoveryTimeout time.Duration
 	rediscoverWait   time.Duration
+	// timerJitter is the maximum percentage of each period of periodic work
+	// that is randomly added to the period.
+	timerJitter int
+
+	// safeMode, if not nil, skips corrupt entries when loading the registry
+	// from the datastore.
+	safeMode *safemode.Skipper
+
+	// connGater, if not nil, rejects connections with blocked peers.
+	connGater      *ConnGater
+	connGaterMutex sync.Mutex
 
 	syncChan chan *ProviderInfo
 }
 
+// Option configures optional registry behavior.
+type Option func(*Registry)
+
+// WithSafeMode makes loading the registry from the datastore skip entries
+// that cannot be read or decoded, instead of failing, and record them in
+// skipper. The blocklist is not affected, so that blocked content is never
+// served because its blocklist entry was skipped.
+func WithSafeMode(skipper *safemode.Skipper) Option {
+	return func(r *Registry) {
+		r.safeMode = skipper
+	}
+}
+
 // ProviderInfo is an immutable data structure that holds information about a
 // provider.  A ProviderInfo instance is never modified, but rather a new one
 // is created to update its contents.  This means existing references remain
@@ -71,6 +130,13 @@ type ProviderInfo struct {
 	Publisher peer.ID `json:",omitempty"`
 	// PublisherAddr contains the last seen publisher multiaddr.
 	PublisherAddr multiaddr.Multiaddr `json:",omitempty"`
+	// AutoRegistered is true if the provider was registered from the
+	// addresses in an advertisement, with the unknown provider policy set to
+	// flag such providers. It is false once the provider registers itself.
+	AutoRegistered bool `json:",omitempty"`
+	// Tags are labels that the indexer operator sets to organize providers.
+	// They are kept sorted, and are kept when the provider registers again.
+	Tags []string `json:",omitempty"`
 
 	// lastContactTime is the last time the publisher contacted the
 	// indexer. This is not persisted, so that the time since last contact is
@@ -145,33 +211,52 @@ func (p *ProviderInfo) UnmarshalJSON(data []byte) error {
 // NewRegistry creates a new provider registry, giving it provider policy
 // configuration, a datastore to persist provider data, and a Discoverer
 // interface.  The context is only used for cancellation of this function.
-func NewRegistry(ctx context.Context, cfg config.Discovery, dstore datastore.Datastore, discoverer discovery.Discoverer) (*Registry, error) {
+func NewRegistry(ctx context.Context, cfg config.Discovery, dstore datastore.Datastore, discoverer discovery.Discoverer, options ...Option) (*Registry, error) {
 	// Create policy from config
 	regPolicy, err := policy.New(cfg.Policy)
 	if err != nil {
 		return nil, err
 	}
 	// Log warning if no peers are allowed.
-	if regPolicy.NoneAllowed() {
+	if regPolicy.NoneAllowed() && cfg.Policy.AllowListURL == "" {
 		log.Warn("Policy does not allow any peers to index content")
 	}
 
+	var ral *remoteAllowList
+	if cfg.Policy.AllowListURL != "" {
+		ral, err = newRemoteAllowList(cfg.Policy.AllowListURL, cfg.Policy.AllowListSigner, time.Duration(cfg.Policy.AllowListRefresh))
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	r := &Registry{
-		actions:   make(chan func()),
-		closed:    make(chan struct{}),
-		closing:   make(chan struct{}),
-		policy:    regPolicy,
-		providers: map[peer.ID]*ProviderInfo{},
-		sequences: newSequences(0),
+		actions:    make(chan func()),
+		closed:     make(chan struct{}),
+		closing:    make(chan struct{}),
+		policy:     regPolicy,
+		providers:  map[peer.ID]*ProviderInfo{},
+		sequences:  newSequences(0),
+		contexts:   map[peer.ID]map[string]*ContextInfo{},
+		transports: newTransportIndex(),
+		aliases:    map[peer.ID]peer.ID{},
+		blocked:    map[string]struct{}{},
+		lastSeen:   map[peer.ID]time.Time{},
+
+		registering: map[peer.ID]struct{}{},
 
 		rediscoverWait:   time.Duration(cfg.RediscoverWait),
 		discoveryTimeout: time.Duration(cfg.Timeout),
+		timerJitter:      cfg.TimerJitterPercent,
 
 		discoverer: discoverer,
 
 		dstore:   dstore,
 		syncChan: make(chan *ProviderInfo, 1),
 	}
+	for _, opt := range options {
+		opt(r)
+	}
 
 	count, err := r.loadPersistedProviders(ctx)
 	if err != nil {
@@ -179,6 +264,28 @@ func NewRegistry(ctx context.Context, cfg config.Discovery, dstore datastore.Dat
 	}
 	log.Infow("loaded providers into registry", "count", count)
 
+	count, err = r.loadPersistedContexts(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load provider context data from datastore: %w", err)
+	}
+	log.Infow("loaded provider contexts into registry", "count", count)
+
+	count, err = r.loadPersistedAliases(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load provider alias data from datastore: %w", err)
+	}
+	if count != 0 {
+		log.Infow("loaded provider aliases into registry", "count", count)
+	}
+
+	count, err = r.loadPersistedBlocklist(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load blocklist data from datastore: %w", err)
+	}
+	if count != 0 {
+		log.Infow("loaded blocked multihashes into registry", "count", count)
+	}
+
 	pollOverrides, err := makePollOverrideMap(cfg.PollOverrides)
 	if err != nil {
 		return nil, err
@@ -191,6 +298,12 @@ func NewRegistry(ctx context.Context, cfg config.Discovery, dstore datastore.Dat
 
 	go r.run()
 	go r.runPollCheck(poll, pollOverrides)
+	if ral != nil {
+		go r.runAllowListRefresh(ral)
+	}
+	if cfg.ReachabilityInterval != 0 {
+		go r.runReachabilityCheck(time.Duration(cfg.ReachabilityInterval), time.Duration(cfg.ReachabilityTimeout))
+	}
 
 	return r, nil
 }
@@ -260,14 +373,14 @@ func (r *Registry) runPollCheck(poll polling, pollOverrides map[peer.ID]polling)
 	if retryAfter < time.Minute {
 		retryAfter = time.Minute
 	}
-	timer := time.NewTimer(retryAfter)
+	timer := time.NewTimer(jitter.Add(retryAfter, r.timerJitter))
 running:
 	for {
 		select {
 		case <-timer.C:
 			r.cleanup()
 			r.pollProviders(poll, pollOverrides)
-			timer.Reset(retryAfter)
+			timer.Reset(jitter.Add(retryAfter, r.timerJitter))
 		case <-r.closing:
 			break running
 		}
@@ -341,6 +454,12 @@ func (r *Registry) Register(ctx context.Context, info *ProviderInfo) error {
 		}
 	}
 
+	// Only one registration for a provider may be in progress at a time.
+	if !r.startRegister(info.AddrInfo.ID) {
+		return v0.NewError(ErrRegisterInProgress, http.StatusConflict)
+	}
+	defer r.endRegister(info.AddrInfo.ID)
+
 	// If provider is allowed and publisher is allowed to publish for the
 	// provider, then register.
 	errCh := make(chan error, 1)
@@ -357,6 +476,26 @@ func (r *Registry) Register(ctx context.Context, info *ProviderInfo) error {
 	return nil
 }
 
+// startRegister marks a registration for the provider as in progress. Returns
+// false if a registration for the provider is already in progress.
+func (r *Registry) startRegister(providerID peer.ID) bool {
+	r.registeringMutex.Lock()
+	defer r.registeringMutex.Unlock()
+
+	if _, busy := r.registering[providerID]; busy {
+		return false
+	}
+	r.registering[providerID] = struct{}{}
+	return true
+}
+
+// endRegister marks a registration for the provider as completed.
+func (r *Registry) endRegister(providerID peer.ID) {
+	r.registeringMutex.Lock()
+	delete(r.registering, providerID)
+	r.registeringMutex.Unlock()
+}
+
 // Allowed checks if the peer is allowed by policy.
 func (r *Registry) Allowed(peerID peer.ID) bool {
 	return r.policy.Allowed(peerID)
@@ -367,6 +506,11 @@ func (r *Registry) PublishAllowed(publisherID, providerID peer.ID) bool {
 	return r.policy.PublishAllowed(publisherID, providerID)
 }
 
+// Trusted checks if the peer is a trusted provider.
+func (r *Registry) Trusted(peerID peer.ID) bool {
+	return r.policy.Trusted(peerID)
+}
+
 func (r *Registry) SetPolicy(policyCfg config.Policy) error {
 	newPol, err := policy.New(policyCfg)
 	if err != nil {
@@ -378,6 +522,7 @@ func (r *Registry) SetPolicy(policyCfg config.Policy) error {
 	}
 
 	r.policy.Copy(newPol)
+	r.disconnectBlocked()
 	return nil
 }
 
@@ -390,7 +535,22 @@ func (r *Registry) AllowPeer(peerID peer.ID) bool {
 // BlockPeer configures the policy to block messages published by the
 // identified peer, and block the peer from registering as a provider.
 func (r *Registry) BlockPeer(peerID peer.ID) bool {
-	return r.policy.Block(peerID)
+	if !r.policy.Block(peerID) {
+		return false
+	}
+	r.disconnectBlocked()
+	return true
+}
+
+// disconnectBlocked closes connections with blocked peers, if the registry's
+// connection gater is in use.
+func (r *Registry) disconnectBlocked() {
+	r.connGaterMutex.Lock()
+	gater := r.connGater
+	r.connGaterMutex.Unlock()
+	if gater != nil {
+		gater.disconnectBlocked()
+	}
 }
 
 // RegisterOrUpdate attempts to register an unregistered provider, or updates
@@ -412,6 +572,7 @@ func (r *Registry) RegisterOrUpdate(ctx context.Context, providerID peer.ID, add
 			LastAdvertisementTime: info.LastAdvertisementTime,
 			Publisher:             info.Publisher,
 			PublisherAddr:         info.PublisherAddr,
+			AutoRegistered:        info.AutoRegistered,
 		}
 
 		if publisher.ID.Validate() == nil {
@@ -485,6 +646,33 @@ func (r *Registry) RegisterOrUpdate(ctx context.Context, providerID peer.ID, add
 	return nil
 }
 
+// FlagAutoRegistered flags a registered provider as registered from the
+// addresses in an advertisement, rather than by registering itself. Returns
+// false if the provider is not registered.
+func (r *Registry) FlagAutoRegistered(ctx context.Context, providerID peer.ID) (bool, error) {
+	var found bool
+	errCh := make(chan error, 1)
+	r.actions <- func() {
+		info, ok := r.providers[providerID]
+		if !ok {
+			errCh <- nil
+			return
+		}
+		found = true
+		if info.AutoRegistered {
+			errCh <- nil
+			return
+		}
+		// Replace the info with a flagged copy, since the info may be in use
+		// outside of the registry.
+		flagged := *info
+		flagged.AutoRegistered = true
+		errCh <- r.syncRegister(ctx, &flagged)
+	}
+	err := <-errCh
+	return found, err
+}
+
 // IsRegistered checks if the provider is in the registry
 func (r *Registry) IsRegistered(providerID peer.ID) bool {
 	done := make(chan struct{})
@@ -497,6 +685,25 @@ func (r *Registry) IsRegistered(providerID peer.ID) bool {
 	return found
 }
 
+// PublisherSeen records that an announcement was received from the
+// publisher. This is recorded for every announcement, even one for an
+// advertisement that has already been processed, so that it shows whether the
+// publisher is still present when it has nothing new to publish.
+func (r *Registry) PublisherSeen(publisherID peer.ID) {
+	now := time.Now()
+	r.lastSeenMutex.Lock()
+	r.lastSeen[publisherID] = now
+	r.lastSeenMutex.Unlock()
+}
+
+// LastSeen returns the last time that an announcement was received from the
+// publisher, or a zero time if there has been none since the indexer started.
+func (r *Registry) LastSeen(publisherID peer.ID) time.Time {
+	r.lastSeenMutex.RLock()
+	defer r.lastSeenMutex.RUnlock()
+	return r.lastSeen[publisherID]
+}
+
 // ProviderInfo returns information for a registered provider
 func (r *Registry) ProviderInfo(providerID peer.ID) *ProviderInfo {
 	infoChan := make(chan *ProviderInfo)
@@ -681,6 +888,11 @@ func (r *Registry) syncStartDiscover(peerID peer.ID, spID string, errCh chan<- e
 }
 
 func (r *Registry) syncRegister(ctx context.Context, info *ProviderInfo) error {
+	// Tags are set by the indexer operator, not by registration, so keep any
+	// that the provider already has.
+	if prev, ok := r.providers[info.AddrInfo.ID]; ok {
+		info.Tags = prev.Tags
+	}
 	r.providers[info.AddrInfo.ID] = info
 	err := r.syncPersistProvider(ctx, info)
 	if err != nil {
@@ -743,12 +955,18 @@ func (r *Registry) loadPersistedProviders(ctx context.Context) (int, error) {
 	var count int
 	for result := range results.Next() {
 		if result.Error != nil {
+			if r.safeMode.Skip("provider", result.Key, result.Error) {
+				continue
+			}
 			return 0, fmt.Errorf("cannot read provider data: %v", result.Error)
 		}
 		ent := result.Entry
 
 		peerID, err := peer.Decode(path.Base(ent.Key))
 		if err != nil {
+			if r.safeMode.Skip("provider", ent.Key, err) {
+				continue
+			}
 			return 0, fmt.Errorf("cannot decode provider ID: %s", err)
 		}
 
@@ -761,6 +979,9 @@ func (r *Registry) loadPersistedProviders(ctx context.Context) (int, error) {
 		pinfo := new(ProviderInfo)
 		err = json.Unmarshal(ent.Value, pinfo)
 		if err != nil {
+			if r.safeMode.Skip("provider", ent.Key, err) {
+				continue
+			}
 			return 0, err
 		}
 
@@ -864,6 +1085,16 @@ func (r *Registry) syncRemoveProvider(ctx context.Context, providerID peer.ID) e
 	// Remove the provider from the registry.
 	delete(r.providers, providerID)
 
+	if err := r.syncRemoveAllContexts(ctx, providerID); err != nil {
+		return err
+	}
+	if err := r.removeAllCodecs(ctx, providerID); err != nil {
+		return err
+	}
+	if err := r.removeAllIndexTimes(ctx, providerID); err != nil {
+		return err
+	}
+
 	if r.dstore == nil {
 		return nil
 	}
@@ -3,12 +3,17 @@ package registry
 import (
 	"context"
 	"errors"
+	"net/http"
+	"reflect"
 	"testing"
 	"time"
 
+	v0 "github.com/filecoin-project/storetheindex/api/v0"
 	"github.com/filecoin-project/storetheindex/config"
 	"github.com/filecoin-project/storetheindex/internal/registry/discovery"
+	"github.com/filecoin-project/storetheindex/internal/safemode"
 	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
 	leveldb "github.com/ipfs/go-ds-leveldb"
 	"github.com/libp2p/go-libp2p-core/peer"
 	"github.com/multiformats/go-multiaddr"
@@ -675,3 +680,139 @@ func TestPollProviderOverrides(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestConcurrentRegister(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	r, err := NewRegistry(ctx, discoveryCfg, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	peerID, err := peer.Decode(limitedID)
+	if err != nil {
+		t.Fatal("bad provider ID:", err)
+	}
+	maddr, err := multiaddr.NewMultiaddr(minerAddr)
+	if err != nil {
+		t.Fatal("bad miner address:", err)
+	}
+	info := &ProviderInfo{
+		AddrInfo: peer.AddrInfo{
+			ID:    peerID,
+			Addrs: []multiaddr.Multiaddr{maddr},
+		},
+	}
+
+	// Stall the registry so that the first registration stays in progress
+	// while the second is attempted.
+	release := make(chan struct{})
+	r.actions <- func() { <-release }
+
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			errs <- r.Register(ctx, info)
+		}()
+	}
+
+	err = <-errs
+	if !errors.Is(err, ErrRegisterInProgress) {
+		t.Fatalf("expected error %q, got %v", ErrRegisterInProgress, err)
+	}
+	var apierr *v0.Error
+	if !errors.As(err, &apierr) || apierr.Status() != http.StatusConflict {
+		t.Fatal("expected status conflict")
+	}
+
+	close(release)
+	if err = <-errs; err != nil {
+		t.Fatal("failed to register:", err)
+	}
+	if !r.IsRegistered(peerID) {
+		t.Fatal("provider not registered")
+	}
+
+	// Registration is allowed again after the previous one completed.
+	if err = r.Register(ctx, info); err != nil {
+		t.Fatal("failed to register again:", err)
+	}
+}
+
+func TestSafeModeSkipsCorruptEntries(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	dstore := datastore.NewMapDatastore()
+	provID, err := peer.Decode(limitedID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	maddr, err := multiaddr.NewMultiaddr(minerAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewRegistry(ctx, discoveryCfg, dstore, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = r.Register(ctx, &ProviderInfo{
+		AddrInfo: peer.AddrInfo{
+			ID:    provID,
+			Addrs: []multiaddr.Multiaddr{maddr},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Corrupt a provider and an alias.
+	otherID, err := peer.Decode(limitedID2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = dstore.Put(ctx, peerIDToDsKey(otherID), []byte("{not json")); err != nil {
+		t.Fatal(err)
+	}
+	if err = dstore.Put(ctx, aliasDsKey(otherID), []byte("not a peer ID")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = NewRegistry(ctx, discoveryCfg, dstore, nil); err == nil {
+		t.Fatal("expected error loading corrupt registry data without safe mode")
+	}
+
+	skipper := safemode.New()
+	r, err = NewRegistry(ctx, discoveryCfg, dstore, nil, WithSafeMode(skipper))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r.ProviderInfo(provID) == nil {
+		t.Fatal("expected intact provider to be loaded")
+	}
+	if r.ProviderInfo(otherID) != nil {
+		t.Fatal("expected corrupt provider to be skipped")
+	}
+	expect := map[string]int{"provider": 1, "provider alias": 1}
+	if counts := skipper.Counts(); !reflect.DeepEqual(counts, expect) {
+		t.Fatalf("expected skipped %v, got %v", expect, counts)
+	}
+	if skipper.Total() != 2 {
+		t.Fatalf("expected 2 skipped entries, got %d", skipper.Total())
+	}
+	r.Close()
+
+	// A corrupt blocklist entry fails loading even in safe mode.
+	if err = dstore.Put(ctx, datastore.NewKey(blockedKeyPath+"/0OIl"), []byte{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = NewRegistry(ctx, discoveryCfg, dstore, nil, WithSafeMode(safemode.New())); err == nil {
+		t.Fatal("expected error loading corrupt blocklist in safe mode")
+	}
+}
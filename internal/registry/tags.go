@@ -0,0 +1,84 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// maxTagLen is the maximum length of a provider tag.
+const maxTagLen = 64
+
+// normalizeTags returns the tags sorted and without duplicates, or an error
+// if any tag is not valid. A tag is a non-empty string without whitespace or
+// commas. Returns nil if there are no tags.
+func normalizeTags(tags []string) ([]string, error) {
+	if len(tags) == 0 {
+		return nil, nil
+	}
+	seen := make(map[string]struct{}, len(tags))
+	out := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		if tag == "" {
+			return nil, fmt.Errorf("%w: empty tag", ErrBadTag)
+		}
+		if len(tag) > maxTagLen {
+			return nil, fmt.Errorf("%w: %q is longer than %d characters", ErrBadTag, tag, maxTagLen)
+		}
+		if strings.ContainsAny(tag, ", \t\r\n") {
+			return nil, fmt.Errorf("%w: %q contains whitespace or a comma", ErrBadTag, tag)
+		}
+		if _, ok := seen[tag]; ok {
+			continue
+		}
+		seen[tag] = struct{}{}
+		out = append(out, tag)
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// SetProviderTags replaces the tags of a registered provider. Tags are
+// indexer-side metadata that operators use to organize providers, such as by
+// region or by operator, and do not affect ingestion. Setting no tags removes
+// all of the provider's tags.
+func (r *Registry) SetProviderTags(ctx context.Context, providerID peer.ID, tags []string) error {
+	tags, err := normalizeTags(tags)
+	if err != nil {
+		return err
+	}
+
+	errCh := make(chan error, 1)
+	r.actions <- func() {
+		info, ok := r.providers[providerID]
+		if !ok {
+			errCh <- ErrProviderNotFound
+			return
+		}
+		// Replace the info with a tagged copy, since the info may be in use
+		// outside of the registry.
+		tagged := *info
+		tagged.Tags = tags
+		if err := r.syncPersistProvider(ctx, &tagged); err != nil {
+			errCh <- fmt.Errorf("could not persist provider: %w", err)
+			return
+		}
+		r.providers[providerID] = &tagged
+		errCh <- nil
+	}
+	return <-errCh
+}
+
+// HasTags returns true if the provider has all of the tags.
+func (p *ProviderInfo) HasTags(tags ...string) bool {
+	for _, tag := range tags {
+		i := sort.SearchStrings(p.Tags, tag)
+		if i == len(p.Tags) || p.Tags[i] != tag {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,135 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	leveldb "github.com/ipfs/go-ds-leveldb"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/multiformats/go-multiaddr"
+)
+
+func TestProviderTags(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	provID, err := peer.Decode(limitedID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherID, err := peer.Decode(limitedID2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	maddr, err := multiaddr.NewMultiaddr("/ip4/127.0.0.1/tcp/3002")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dataStorePath := t.TempDir()
+	dstore, err := leveldb.NewDatastore(dataStorePath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewRegistry(ctx, discoveryCfg, dstore, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = r.SetProviderTags(ctx, provID, []string{"eu"}); !errors.Is(err, ErrProviderNotFound) {
+		t.Fatalf("expected ErrProviderNotFound for unregistered provider, got %v", err)
+	}
+
+	info := &ProviderInfo{
+		AddrInfo: peer.AddrInfo{
+			ID:    provID,
+			Addrs: []multiaddr.Multiaddr{maddr},
+		},
+	}
+	if err = r.Register(ctx, info); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, bad := range [][]string{{""}, {"has space"}, {"a,b"}, {string(make([]byte, maxTagLen+1))}} {
+		if err = r.SetProviderTags(ctx, provID, bad); !errors.Is(err, ErrBadTag) {
+			t.Fatalf("expected ErrBadTag for %q, got %v", bad, err)
+		}
+	}
+
+	// Tags are sorted and duplicates removed.
+	if err = r.SetProviderTags(ctx, provID, []string{"region-eu", "operator-a", "region-eu"}); err != nil {
+		t.Fatal(err)
+	}
+	expect := []string{"operator-a", "region-eu"}
+	if tags := r.ProviderInfo(provID).Tags; !reflect.DeepEqual(tags, expect) {
+		t.Fatalf("expected tags %v, got %v", expect, tags)
+	}
+
+	// Registering again keeps the tags.
+	info = &ProviderInfo{
+		AddrInfo: peer.AddrInfo{
+			ID:    provID,
+			Addrs: []multiaddr.Multiaddr{maddr},
+		},
+	}
+	if err = r.Register(ctx, info); err != nil {
+		t.Fatal(err)
+	}
+	err = r.RegisterOrUpdate(ctx, provID, []string{"/ip4/127.0.0.1/tcp/3003"}, cid.Undef, peer.AddrInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pinfo := r.ProviderInfo(provID)
+	if !reflect.DeepEqual(pinfo.Tags, expect) {
+		t.Fatalf("expected tags %v after registering again, got %v", expect, pinfo.Tags)
+	}
+	if !pinfo.HasTags() || !pinfo.HasTags("region-eu") || !pinfo.HasTags("region-eu", "operator-a") {
+		t.Fatal("expected provider to have tags")
+	}
+	if pinfo.HasTags("region-us") || pinfo.HasTags("region-eu", "region-us") {
+		t.Fatal("expected provider to not have tags")
+	}
+
+	other := &ProviderInfo{
+		AddrInfo: peer.AddrInfo{
+			ID:    otherID,
+			Addrs: []multiaddr.Multiaddr{maddr},
+		},
+	}
+	if err = r.Register(ctx, other); err != nil {
+		t.Fatal(err)
+	}
+	if r.ProviderInfo(otherID).HasTags("region-eu") {
+		t.Fatal("untagged provider should not have tags")
+	}
+	if err = r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Check that tags are loaded from the datastore.
+	dstore, err = leveldb.NewDatastore(dataStorePath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err = NewRegistry(ctx, discoveryCfg, dstore, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	if tags := r.ProviderInfo(provID).Tags; !reflect.DeepEqual(tags, expect) {
+		t.Fatalf("expected tags %v to be loaded, got %v", expect, tags)
+	}
+
+	// Setting no tags removes them.
+	if err = r.SetProviderTags(ctx, provID, nil); err != nil {
+		t.Fatal(err)
+	}
+	if tags := r.ProviderInfo(provID).Tags; len(tags) != 0 {
+		t.Fatalf("expected no tags, got %v", tags)
+	}
+}
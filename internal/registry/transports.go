@@ -0,0 +1,145 @@
+package registry
+
+import (
+	"sort"
+
+	v0 "github.com/filecoin-project/storetheindex/api/v0"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/multiformats/go-multicodec"
+)
+
+// transportIndex is a secondary index of the transport protocol of each
+// provider context, kept up to date as contexts are updated and removed. This
+// allows find results to be filtered by transport without decoding the
+// metadata of each result, and allows finding the providers that support a
+// transport.
+type transportIndex struct {
+	// contexts maps a provider ID to the transport of each of the provider's
+	// contexts, keyed by context ID. The transport is zero if the context's
+	// metadata does not identify a transport.
+	contexts map[peer.ID]map[string]multicodec.Code
+	// providers maps a transport to the number of contexts with that
+	// transport for each provider.
+	providers map[multicodec.Code]map[peer.ID]int
+}
+
+func newTransportIndex() transportIndex {
+	return transportIndex{
+		contexts:  map[peer.ID]map[string]multicodec.Code{},
+		providers: map[multicodec.Code]map[peer.ID]int{},
+	}
+}
+
+// ContextTransport returns the transport protocol of a provider's context, as
+// given by the context's metadata, or by its metadata override if there is
+// one. The transport is zero if the metadata does not identify a transport.
+// Returns false if the registry has no record of the context.
+func (r *Registry) ContextTransport(providerID peer.ID, contextID []byte) (multicodec.Code, bool) {
+	r.transportsMutex.RLock()
+	defer r.transportsMutex.RUnlock()
+
+	transport, ok := r.transports.contexts[providerID][string(contextID)]
+	return transport, ok
+}
+
+// TransportProviders returns the IDs of the providers that have at least one
+// context with metadata for the transport protocol, in sorted order.
+func (r *Registry) TransportProviders(transport multicodec.Code) []peer.ID {
+	r.transportsMutex.RLock()
+	provCounts := r.transports.providers[transport]
+	provIDs := make([]peer.ID, 0, len(provCounts))
+	for provID := range provCounts {
+		provIDs = append(provIDs, provID)
+	}
+	r.transportsMutex.RUnlock()
+
+	sort.Slice(provIDs, func(i, j int) bool {
+		return provIDs[i] < provIDs[j]
+	})
+	return provIDs
+}
+
+// indexContextTransport records the transport of a context, replacing any
+// previously recorded transport for the context.
+func (r *Registry) indexContextTransport(info *ContextInfo) {
+	transport := info.Transport
+	if info.MetadataOverride != nil {
+		transport, _ = v0.MetadataTransport(info.MetadataOverride)
+	} else if transport == 0 {
+		// Contexts recorded before the transport was stored in ContextInfo.
+		transport, _ = v0.MetadataTransport(info.Metadata)
+	}
+
+	r.transportsMutex.Lock()
+	defer r.transportsMutex.Unlock()
+
+	provContexts, ok := r.transports.contexts[info.ProviderID]
+	if !ok {
+		provContexts = map[string]multicodec.Code{}
+		r.transports.contexts[info.ProviderID] = provContexts
+	} else if prev, ok := provContexts[string(info.ContextID)]; ok {
+		if prev == transport {
+			return
+		}
+		r.transports.decProvider(prev, info.ProviderID)
+	}
+	provContexts[string(info.ContextID)] = transport
+	if transport == 0 {
+		return
+	}
+	provCounts, ok := r.transports.providers[transport]
+	if !ok {
+		provCounts = map[peer.ID]int{}
+		r.transports.providers[transport] = provCounts
+	}
+	provCounts[info.ProviderID]++
+}
+
+// unindexContextTransport removes the record of a context's transport.
+func (r *Registry) unindexContextTransport(providerID peer.ID, contextID []byte) {
+	r.transportsMutex.Lock()
+	defer r.transportsMutex.Unlock()
+
+	provContexts := r.transports.contexts[providerID]
+	transport, ok := provContexts[string(contextID)]
+	if !ok {
+		return
+	}
+	delete(provContexts, string(contextID))
+	if len(provContexts) == 0 {
+		delete(r.transports.contexts, providerID)
+	}
+	r.transports.decProvider(transport, providerID)
+}
+
+// unindexProviderTransports removes the record of the transports of all of a
+// provider's contexts.
+func (r *Registry) unindexProviderTransports(providerID peer.ID) {
+	r.transportsMutex.Lock()
+	defer r.transportsMutex.Unlock()
+
+	delete(r.transports.contexts, providerID)
+	for transport, provCounts := range r.transports.providers {
+		delete(provCounts, providerID)
+		if len(provCounts) == 0 {
+			delete(r.transports.providers, transport)
+		}
+	}
+}
+
+// decProvider decrements the number of the provider's contexts that have the
+// transport. The caller must hold the transports lock.
+func (x *transportIndex) decProvider(transport multicodec.Code, providerID peer.ID) {
+	if transport == 0 {
+		return
+	}
+	provCounts := x.providers[transport]
+	provCounts[providerID]--
+	if provCounts[providerID] > 0 {
+		return
+	}
+	delete(provCounts, providerID)
+	if len(provCounts) == 0 {
+		delete(x.providers, transport)
+	}
+}
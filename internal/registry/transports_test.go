@@ -0,0 +1,140 @@
+package registry
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	leveldb "github.com/ipfs/go-ds-leveldb"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-varint"
+)
+
+func TestTransportIndex(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	provA, err := peer.Decode(limitedID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	provB, err := peer.Decode(exceptID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bitswap := varint.ToUvarint(uint64(multicodec.TransportBitswap))
+	graphsync := varint.ToUvarint(uint64(multicodec.TransportGraphsyncFilecoinv1))
+
+	dataStorePath := t.TempDir()
+	dstore, err := leveldb.NewDatastore(dataStorePath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewRegistry(ctx, discoveryCfg, dstore, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	requireProviders := func(transport multicodec.Code, expect ...peer.ID) {
+		t.Helper()
+		provIDs := r.TransportProviders(transport)
+		if len(provIDs) != len(expect) {
+			t.Fatalf("expected %d providers for %s, got %v", len(expect), transport, provIDs)
+		}
+		for _, expectID := range expect {
+			var found bool
+			for _, provID := range provIDs {
+				if provID == expectID {
+					found = true
+					break
+				}
+			}
+			if !found {
+				t.Fatalf("expected provider %s for %s", expectID, transport)
+			}
+		}
+	}
+
+	if err = r.UpdateProviderContext(ctx, provA, []byte("ctx-1"), bitswap, 1, cid.Undef); err != nil {
+		t.Fatal(err)
+	}
+	if err = r.UpdateProviderContext(ctx, provA, []byte("ctx-2"), graphsync, 1, cid.Undef); err != nil {
+		t.Fatal(err)
+	}
+	if err = r.UpdateProviderContext(ctx, provB, []byte("ctx-1"), graphsync, 1, cid.Undef); err != nil {
+		t.Fatal(err)
+	}
+	if err = r.UpdateProviderContext(ctx, provB, []byte("ctx-2"), []byte{}, 1, cid.Undef); err != nil {
+		t.Fatal(err)
+	}
+	requireProviders(multicodec.TransportBitswap, provA)
+	requireProviders(multicodec.TransportGraphsyncFilecoinv1, provA, provB)
+
+	transport, ok := r.ContextTransport(provA, []byte("ctx-1"))
+	if !ok || transport != multicodec.TransportBitswap {
+		t.Fatalf("expected bitswap transport, got %s", transport)
+	}
+	// A context with metadata that does not identify a transport is known,
+	// with no transport.
+	transport, ok = r.ContextTransport(provB, []byte("ctx-2"))
+	if !ok || transport != 0 {
+		t.Fatalf("expected no transport, got %s", transport)
+	}
+	if _, ok = r.ContextTransport(provB, []byte("ctx-3")); ok {
+		t.Fatal("expected unknown context")
+	}
+
+	// A metadata override changes the transport of the context, until it is
+	// cleared.
+	if err = r.SetMetadataOverride(ctx, provA, []byte("ctx-2"), bitswap); err != nil {
+		t.Fatal(err)
+	}
+	requireProviders(multicodec.TransportGraphsyncFilecoinv1, provB)
+	if err = r.ClearMetadataOverride(ctx, provA, []byte("ctx-2")); err != nil {
+		t.Fatal(err)
+	}
+	requireProviders(multicodec.TransportGraphsyncFilecoinv1, provA, provB)
+
+	// Changing the metadata of a context changes its transport.
+	if err = r.UpdateProviderContext(ctx, provB, []byte("ctx-1"), bitswap, 1, cid.Undef); err != nil {
+		t.Fatal(err)
+	}
+	requireProviders(multicodec.TransportBitswap, provA, provB)
+	requireProviders(multicodec.TransportGraphsyncFilecoinv1, provA)
+
+	if err = r.RemoveProviderContext(ctx, provA, []byte("ctx-2")); err != nil {
+		t.Fatal(err)
+	}
+	requireProviders(multicodec.TransportGraphsyncFilecoinv1)
+	if err = r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Check that the index is rebuilt from the persisted contexts.
+	dstore, err = leveldb.NewDatastore(dataStorePath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err = NewRegistry(ctx, discoveryCfg, dstore, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	requireProviders(multicodec.TransportBitswap, provA, provB)
+	requireProviders(multicodec.TransportGraphsyncFilecoinv1)
+
+	// Removing a provider removes it from the index.
+	done := make(chan error)
+	r.actions <- func() {
+		done <- r.syncRemoveProvider(ctx, provA)
+	}
+	if err = <-done; err != nil {
+		t.Fatal(err)
+	}
+	requireProviders(multicodec.TransportBitswap, provB)
+	if _, ok = r.ContextTransport(provA, []byte("ctx-1")); ok {
+		t.Fatal("expected no context for removed provider")
+	}
+}
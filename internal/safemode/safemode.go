@@ -0,0 +1,91 @@
+// Package safemode lets the routines that load state from the datastore at
+// startup skip entries that cannot be read or decoded, instead of failing
+// startup, and counts what was skipped so that operators can decide whether
+// to rebuild the datastore.
+package safemode
+
+import (
+	"sort"
+	"sync"
+
+	logging "github.com/ipfs/go-log/v2"
+)
+
+var log = logging.Logger("indexer/safemode")
+
+// Skipper records the datastore entries skipped at startup. A nil *Skipper
+// means that safe mode is off, and entries are never skipped.
+type Skipper struct {
+	mutex  sync.Mutex
+	counts map[string]int
+}
+
+// New creates a Skipper that skips corrupt entries.
+func New() *Skipper {
+	return &Skipper{
+		counts: map[string]int{},
+	}
+}
+
+// Skip returns true if the corrupt entry should be skipped, in which case the
+// entry is logged and counted under kind, which names the kind of data that
+// the entry holds. Returns false if safe mode is off, in which case the caller
+// must fail as it would without safe mode.
+func (s *Skipper) Skip(kind, key string, err error) bool {
+	if s == nil {
+		return false
+	}
+	log.Warnw("Safe mode skipped corrupt datastore entry", "kind", kind, "key", key, "err", err)
+	s.mutex.Lock()
+	s.counts[kind]++
+	s.mutex.Unlock()
+	return true
+}
+
+// Counts returns the number of entries skipped of each kind of data.
+func (s *Skipper) Counts() map[string]int {
+	if s == nil {
+		return nil
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	counts := make(map[string]int, len(s.counts))
+	for kind, count := range s.counts {
+		counts[kind] = count
+	}
+	return counts
+}
+
+// Total returns the total number of entries skipped.
+func (s *Skipper) Total() int {
+	var total int
+	for _, count := range s.Counts() {
+		total += count
+	}
+	return total
+}
+
+// LogSummary logs how many entries of each kind were skipped.
+func (s *Skipper) LogSummary() {
+	if s == nil {
+		return
+	}
+	counts := s.Counts()
+	if len(counts) == 0 {
+		log.Info("Safe mode found no corrupt datastore entries")
+		return
+	}
+	kinds := make([]string, 0, len(counts))
+	for kind := range counts {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	var total int
+	kv := make([]interface{}, 0, 2*len(kinds)+2)
+	for _, kind := range kinds {
+		kv = append(kv, kind, counts[kind])
+		total += counts[kind]
+	}
+	kv = append(kv, "total", total)
+	log.Warnw("Safe mode skipped corrupt datastore entries; consider rebuilding the datastore", kv...)
+}
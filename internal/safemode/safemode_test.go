@@ -0,0 +1,32 @@
+package safemode
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSkipper(t *testing.T) {
+	errCorrupt := errors.New("corrupt")
+
+	var off *Skipper
+	if off.Skip("provider", "/key", errCorrupt) {
+		t.Fatal("nil skipper should not skip")
+	}
+	if off.Total() != 0 || off.Counts() != nil {
+		t.Fatal("nil skipper should have no counts")
+	}
+	off.LogSummary()
+
+	s := New()
+	if !s.Skip("provider", "/a", errCorrupt) || !s.Skip("provider", "/b", errCorrupt) || !s.Skip("alias", "/c", errCorrupt) {
+		t.Fatal("skipper should skip")
+	}
+	counts := s.Counts()
+	if len(counts) != 2 || counts["provider"] != 2 || counts["alias"] != 1 {
+		t.Fatalf("wrong counts: %v", counts)
+	}
+	if s.Total() != 3 {
+		t.Fatalf("expected total 3, got %d", s.Total())
+	}
+	s.LogSummary()
+}
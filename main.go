@@ -36,13 +36,22 @@ func main() {
 		Name:    "indexer",
 		Usage:   "Indexer Node: Filecoin's data indexer",
 		Version: version.String(),
+		Flags: []cli.Flag{
+			command.LogLevelFlag,
+		},
+		Before: command.SetLogLevels,
 		Commands: []*cli.Command{
 			command.AdminCmd,
+			command.BenchmarkStoreCmd,
 			command.DaemonCmd,
+			command.DiffProviderCmd,
+			command.ExportRegistryCmd,
 			command.FindCmd,
 			command.ImportCmd,
+			command.ImportRegistryCmd,
 			command.InitCmd,
 			command.RegisterCmd,
+			command.ReplayCarCmd,
 			command.SyntheticCmd,
 			command.ConfigCmd,
 			command.ProvidersCmd,
@@ -55,6 +55,22 @@ func NewPolicyStrings(value bool, except []string) (Policy, error) {
 	}, nil
 }
 
+// Copy returns a copy of the Policy that does not share its except set with
+// the original.
+func (p *Policy) Copy() Policy {
+	var exceptIDs map[peer.ID]struct{}
+	if len(p.except) != 0 {
+		exceptIDs = make(map[peer.ID]struct{}, len(p.except))
+		for exceptID := range p.except {
+			exceptIDs[exceptID] = struct{}{}
+		}
+	}
+	return Policy{
+		value:  p.value,
+		except: exceptIDs,
+	}
+}
+
 // Eval returns the boolean value for the specified peer.
 func (p *Policy) Eval(peerID peer.ID) bool {
 	_, ok := p.except[peerID]
@@ -0,0 +1,237 @@
+package adminserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/storetheindex/api/v0/admin/model"
+	"github.com/gorilla/mux"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	logging "github.com/ipfs/go-log/v2"
+)
+
+// auditKeyPath is where audit entries are stored in the indexer repo. Each
+// entry is keyed by its time, in zero-padded unix nanoseconds, so that keys
+// sort in time order.
+const auditKeyPath = "/admin/audit"
+
+var auditLogger = logging.Logger("indexer/audit")
+
+// auditLog records every admin request that may change the indexer's state.
+// Entries are written to the audit logger, and are appended to the datastore
+// if there is one.
+type auditLog struct {
+	dstore     datastore.Datastore
+	tokenAuth  bool
+	mutex      sync.Mutex
+	lastNanos  int64
+	writeError bool
+}
+
+func newAuditLog(dstore datastore.Datastore, tokenAuth bool) *auditLog {
+	return &auditLog{
+		dstore:    dstore,
+		tokenAuth: tokenAuth,
+	}
+}
+
+// middleware records requests that use any method other than GET or HEAD,
+// after they are handled.
+func (a *auditLog) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r)
+
+		entry := model.AuditEntry{
+			Actor:  a.actor(r),
+			Remote: r.RemoteAddr,
+			Action: r.Method + " " + routeTemplate(r),
+			Params: requestParams(r),
+			Status: sw.status,
+		}
+		a.record(r.Context(), entry)
+	})
+}
+
+// actor identifies who made the request, from its authentication.
+func (a *auditLog) actor(r *http.Request) string {
+	if r.TLS != nil && len(r.TLS.VerifiedChains) != 0 && len(r.TLS.VerifiedChains[0]) != 0 {
+		return r.TLS.VerifiedChains[0][0].Subject.String()
+	}
+	if a.tokenAuth {
+		return "token"
+	}
+	return ""
+}
+
+// record logs the entry and stores it in the datastore. The entry time is set
+// here, and is always later than that of the previous entry so that no two
+// entries have the same key.
+func (a *auditLog) record(ctx context.Context, entry model.AuditEntry) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	nanos := time.Now().UnixNano()
+	if nanos <= a.lastNanos {
+		nanos = a.lastNanos + 1
+	}
+	a.lastNanos = nanos
+	entry.Time = time.Unix(0, nanos).UTC()
+
+	auditLogger.Infow("Admin action", "actor", entry.Actor, "remote", entry.Remote,
+		"action", entry.Action, "params", entry.Params, "status", entry.Status)
+
+	if a.dstore == nil {
+		return
+	}
+	data, err := json.Marshal(entry)
+	if err == nil {
+		err = a.dstore.Put(ctx, auditDsKey(nanos), data)
+	}
+	if err != nil {
+		// Only log the first of consecutive failures, to avoid flooding the
+		// log if the datastore is broken.
+		if !a.writeError {
+			log.Errorw("Cannot store audit entry", "err", err)
+		}
+		a.writeError = true
+		return
+	}
+	a.writeError = false
+}
+
+// entries returns the stored entries with a time that is not before start and
+// is before end, in time order. A zero start or end leaves that end of the
+// range open.
+func (a *auditLog) entries(ctx context.Context, start, end time.Time) ([]model.AuditEntry, error) {
+	entries := []model.AuditEntry{}
+	if a.dstore == nil {
+		return entries, nil
+	}
+
+	results, err := a.dstore.Query(ctx, query.Query{
+		Prefix: auditKeyPath,
+		Orders: []query.Order{query.OrderByKey{}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer results.Close()
+
+	var startKey, endKey string
+	if !start.IsZero() {
+		startKey = auditDsKey(start.UnixNano()).String()
+	}
+	if !end.IsZero() {
+		endKey = auditDsKey(end.UnixNano()).String()
+	}
+	for result := range results.Next() {
+		if result.Error != nil {
+			return nil, fmt.Errorf("cannot read audit entry: %w", result.Error)
+		}
+		if result.Key < startKey {
+			continue
+		}
+		if endKey != "" && result.Key >= endKey {
+			break
+		}
+		var entry model.AuditEntry
+		if err = json.Unmarshal(result.Value, &entry); err != nil {
+			return nil, fmt.Errorf("cannot decode audit entry %s: %w", result.Key, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// listAudit writes the audit entries in the time range given by the optional
+// "start" and "end" query parameters, in RFC 3339 format.
+func (a *auditLog) listAudit(w http.ResponseWriter, r *http.Request) {
+	var start, end time.Time
+	var err error
+	if s := r.URL.Query().Get("start"); s != "" {
+		if start, err = time.Parse(time.RFC3339, s); err != nil {
+			http.Error(w, "start is not an RFC 3339 time", http.StatusBadRequest)
+			return
+		}
+	}
+	if s := r.URL.Query().Get("end"); s != "" {
+		if end, err = time.Parse(time.RFC3339, s); err != nil {
+			http.Error(w, "end is not an RFC 3339 time", http.StatusBadRequest)
+			return
+		}
+	}
+
+	entries, err := a.entries(r.Context(), start, end)
+	if err != nil {
+		msg := "Cannot read audit log"
+		log.Errorw(msg, "err", err)
+		http.Error(w, msg, http.StatusInternalServerError)
+		return
+	}
+	data, err := json.Marshal(entries)
+	if err != nil {
+		log.Errorw("Cannot marshal audit entries", "err", err)
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(data)
+	if err != nil {
+		log.Errorw("Cannot write audit response", "err", err)
+	}
+}
+
+func auditDsKey(nanos int64) datastore.Key {
+	return datastore.NewKey(auditKeyPath + "/" + fmt.Sprintf("%020d", nanos))
+}
+
+// routeTemplate returns the path template of the route that matched the
+// request, so that actions on different resources are recorded the same way.
+func routeTemplate(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil {
+		if tmpl, err := route.GetPathTemplate(); err == nil {
+			return tmpl
+		}
+	}
+	return r.URL.Path
+}
+
+// requestParams returns the route variables and query parameters of the
+// request. Multiple values of a query parameter are joined by commas.
+func requestParams(r *http.Request) map[string]string {
+	vars := mux.Vars(r)
+	values := r.URL.Query()
+	if len(vars) == 0 && len(values) == 0 {
+		return nil
+	}
+	params := make(map[string]string, len(vars)+len(values))
+	for k, vals := range values {
+		params[k] = strings.Join(vals, ",")
+	}
+	for k, v := range vars {
+		params[k] = v
+	}
+	return params
+}
+
+// statusWriter records the status written to the wrapped ResponseWriter.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
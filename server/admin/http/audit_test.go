@@ -0,0 +1,73 @@
+package adminserver
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/storetheindex/api/v0/admin/model"
+	qt "github.com/frankban/quicktest"
+	"github.com/gorilla/mux"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+)
+
+func TestAuditLog(t *testing.T) {
+	dstore := dssync.MutexWrap(datastore.NewMapDatastore())
+	audit := newAuditLog(dstore, true)
+
+	router := mux.NewRouter()
+	router.Use(audit.middleware)
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	router.HandleFunc("/things/{thing}", ok).Methods(http.MethodGet)
+	router.HandleFunc("/things/{thing}", ok).Methods(http.MethodPut)
+	router.HandleFunc("/things/{thing}", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "", http.StatusNotFound)
+	}).Methods(http.MethodDelete)
+	router.HandleFunc("/audit", audit.listAudit).Methods(http.MethodGet)
+
+	serve := func(method, target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	before := time.Now()
+	qt.Assert(t, serve(http.MethodGet, "/things/a").Code, qt.Equals, http.StatusOK)
+	qt.Assert(t, serve(http.MethodPut, "/things/a?force=true").Code, qt.Equals, http.StatusOK)
+	qt.Assert(t, serve(http.MethodDelete, "/things/b").Code, qt.Equals, http.StatusNotFound)
+
+	entries, err := audit.entries(context.Background(), time.Time{}, time.Time{})
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, entries, qt.HasLen, 2)
+
+	qt.Check(t, entries[0].Action, qt.Equals, "PUT /things/{thing}")
+	qt.Check(t, entries[0].Actor, qt.Equals, "token")
+	qt.Check(t, entries[0].Params, qt.DeepEquals, map[string]string{"thing": "a", "force": "true"})
+	qt.Check(t, entries[0].Status, qt.Equals, http.StatusOK)
+	qt.Check(t, entries[0].Time.Before(before), qt.IsFalse)
+
+	qt.Check(t, entries[1].Action, qt.Equals, "DELETE /things/{thing}")
+	qt.Check(t, entries[1].Status, qt.Equals, http.StatusNotFound)
+	qt.Check(t, entries[1].Time.After(entries[0].Time), qt.IsTrue)
+
+	// Check that the time range is applied.
+	entries, err = audit.entries(context.Background(), entries[1].Time, time.Time{})
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, entries, qt.HasLen, 1)
+	qt.Check(t, entries[0].Action, qt.Equals, "DELETE /things/{thing}")
+
+	rr := serve(http.MethodGet, "/audit?end="+before.Add(-time.Minute).Format(time.RFC3339))
+	qt.Assert(t, rr.Code, qt.Equals, http.StatusOK)
+	var listed []model.AuditEntry
+	err = json.Unmarshal(rr.Body.Bytes(), &listed)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, listed, qt.HasLen, 0)
+
+	rr = serve(http.MethodGet, "/audit?start=yesterday")
+	qt.Check(t, rr.Code, qt.Equals, http.StatusBadRequest)
+}
@@ -0,0 +1,50 @@
+package adminserver
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// authHandler rejects requests that are not authenticated, with 401
+// (Unauthorized), before passing them to the wrapped handler.
+type authHandler struct {
+	next              http.Handler
+	requireClientCert bool
+	bearerToken       string
+}
+
+// newAuthHandler wraps next with a handler that requires each request to be
+// made with a verified client certificate, if requireClientCert is true, and
+// to have the bearer token, if bearerToken is not empty. If neither is
+// required, then next is returned.
+func newAuthHandler(next http.Handler, requireClientCert bool, bearerToken string) http.Handler {
+	if !requireClientCert && bearerToken == "" {
+		return next
+	}
+	return &authHandler{
+		next:              next,
+		requireClientCert: requireClientCert,
+		bearerToken:       bearerToken,
+	}
+}
+
+func (h *authHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.requireClientCert && (r.TLS == nil || len(r.TLS.VerifiedChains) == 0) {
+		log.Warnw("Rejected admin request without verified client certificate", "remote", r.RemoteAddr, "path", r.URL.Path)
+		http.Error(w, "client certificate required", http.StatusUnauthorized)
+		return
+	}
+	if h.bearerToken != "" {
+		const prefix = "Bearer "
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, prefix) ||
+			subtle.ConstantTimeCompare([]byte(auth[len(prefix):]), []byte(h.bearerToken)) != 1 {
+			log.Warnw("Rejected admin request without valid bearer token", "remote", r.RemoteAddr, "path", r.URL.Path)
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "valid bearer token required", http.StatusUnauthorized)
+			return
+		}
+	}
+	h.next.ServeHTTP(w, r)
+}
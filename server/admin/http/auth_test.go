@@ -0,0 +1,62 @@
+package adminserver
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestAuthHandler(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	// No authentication required.
+	h := newAuthHandler(next, false, "")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthcheck", nil))
+	qt.Assert(t, rec.Code, qt.Equals, http.StatusOK)
+
+	h = newAuthHandler(next, false, "secret")
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthcheck", nil))
+	qt.Assert(t, rec.Code, qt.Equals, http.StatusUnauthorized)
+	qt.Assert(t, rec.Header().Get("WWW-Authenticate"), qt.Equals, "Bearer")
+
+	req := httptest.NewRequest(http.MethodGet, "/healthcheck", nil)
+	req.Header.Set("Authorization", "Bearer wrong")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	qt.Assert(t, rec.Code, qt.Equals, http.StatusUnauthorized)
+
+	req = httptest.NewRequest(http.MethodGet, "/healthcheck", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	qt.Assert(t, rec.Code, qt.Equals, http.StatusOK)
+
+	h = newAuthHandler(next, true, "")
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthcheck", nil))
+	qt.Assert(t, rec.Code, qt.Equals, http.StatusUnauthorized)
+
+	req = httptest.NewRequest(http.MethodGet, "/healthcheck", nil)
+	req.TLS = &tls.ConnectionState{}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	qt.Assert(t, rec.Code, qt.Equals, http.StatusUnauthorized)
+
+	req = httptest.NewRequest(http.MethodGet, "/healthcheck", nil)
+	req.TLS = &tls.ConnectionState{
+		VerifiedChains: [][]*x509.Certificate{{&x509.Certificate{}}},
+	}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	qt.Assert(t, rec.Code, qt.Equals, http.StatusOK)
+}
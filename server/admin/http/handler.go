@@ -2,6 +2,7 @@ package adminserver
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -9,13 +10,18 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"path/filepath"
 	"strconv"
+	"time"
 
 	"github.com/filecoin-project/go-indexer-core"
+	"github.com/filecoin-project/storetheindex/api/v0/admin/model"
 	"github.com/filecoin-project/storetheindex/internal/importer"
 	"github.com/filecoin-project/storetheindex/internal/ingest"
 	"github.com/filecoin-project/storetheindex/internal/registry"
 	"github.com/gorilla/mux"
+	"github.com/ipfs/go-cid"
+	leveldb "github.com/ipfs/go-ds-leveldb"
 	"github.com/libp2p/go-libp2p-core/peer"
 	"github.com/multiformats/go-multiaddr"
 	"github.com/multiformats/go-multihash"
@@ -27,6 +33,7 @@ type adminHandler struct {
 	ingester      *ingest.Ingester
 	reg           *registry.Registry
 	reloadErrChan chan<- chan error
+	importJobs    *importJobs
 }
 
 func newHandler(ctx context.Context, indexer indexer.Interface, ingester *ingest.Ingester, reg *registry.Registry, reloadErrChan chan<- chan error) *adminHandler {
@@ -63,12 +70,80 @@ func (h *adminHandler) blockPeer(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	log.Infow("Blocking peer from publishing or providing content", "peer", peerID.String())
-	if h.reg.BlockPeer(peerID) {
+	if h.unsubscribe(peerID) {
 		log.Infow("Update config to persist blocking peer", "provider", peerID)
 	}
 	w.WriteHeader(http.StatusOK)
 }
 
+// subscribeBatch subscribes the indexer to each of the peers listed in the
+// request, by allowing the peers to publish and provide content. Announcements
+// and advertisements from a peer are only handled while the indexer is
+// subscribed to it.
+func (h *adminHandler) subscribeBatch(w http.ResponseWriter, r *http.Request) {
+	h.batchPolicy(w, r, "subscribe", h.reg.AllowPeer)
+}
+
+// unsubscribeBatch unsubscribes the indexer from each of the peers listed in
+// the request, by blocking the peers from publishing and providing content.
+func (h *adminHandler) unsubscribeBatch(w http.ResponseWriter, r *http.Request) {
+	h.batchPolicy(w, r, "unsubscribe", h.unsubscribe)
+}
+
+// unsubscribe blocks the peer, and drops any of the peer's advertisements that
+// are waiting to be ingested. Returns true if the peer was not already
+// blocked.
+func (h *adminHandler) unsubscribe(peerID peer.ID) bool {
+	changed := h.reg.BlockPeer(peerID)
+	if h.ingester != nil {
+		h.ingester.CancelQueuedWork(peerID)
+	}
+	return changed
+}
+
+// batchPolicy reads a JSON list of peer IDs from the request body, calls
+// apply for each, and writes a result for each ID in the order given. A peer
+// ID that cannot be decoded fails only its own result.
+func (h *adminHandler) batchPolicy(w http.ResponseWriter, r *http.Request, action string, apply func(peer.ID) bool) {
+	var ids []string
+	if err := json.NewDecoder(r.Body).Decode(&ids); err != nil {
+		log.Errorw("Cannot decode peer ID list", "action", action, "err", err)
+		http.Error(w, "request body must be a JSON list of peer IDs", http.StatusBadRequest)
+		return
+	}
+
+	results := make([]model.SubscribeResult, len(ids))
+	var changed int
+	for i, id := range ids {
+		results[i].PeerID = id
+		peerID, err := peer.Decode(id)
+		if err != nil {
+			results[i].Err = "cannot decode peer id: " + err.Error()
+			continue
+		}
+		if apply(peerID) {
+			results[i].Changed = true
+			changed++
+		}
+	}
+	log.Infow("Updated subscriptions", "action", action, "peers", len(ids), "changed", changed)
+	if changed != 0 {
+		log.Info("Update config to persist subscription changes")
+	}
+
+	data, err := json.Marshal(results)
+	if err != nil {
+		log.Errorw("Cannot marshal subscribe results", "err", err)
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(data)
+	if err != nil {
+		log.Errorw("Cannot write subscribe results response", "err", err)
+	}
+}
+
 func (h *adminHandler) sync(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	peerID, ok := decodePeerID(vars["peer"], w)
@@ -104,6 +179,19 @@ func (h *adminHandler) sync(w http.ResponseWriter, r *http.Request) {
 		log = log.With("resync", resync)
 	}
 
+	var since time.Time
+	sinceStr := query.Get("since")
+	if sinceStr != "" {
+		var err error
+		since, err = time.Parse(time.RFC3339, sinceStr)
+		if err != nil {
+			log.Errorw("Cannot unmarshal since as RFC3339 time", "since", sinceStr, "err", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log = log.With("since", since)
+	}
+
 	data, err := io.ReadAll(r.Body)
 	if err != nil {
 		log.Errorw("Failed reading body", "err", err)
@@ -131,7 +219,11 @@ func (h *adminHandler) sync(w http.ResponseWriter, r *http.Request) {
 	// Start the sync, but do not wait for it to complete.
 	//
 	// TODO: Provide some way for the client to see if the indexer has synced.
-	_, err = h.ingester.Sync(h.ctx, peerID, syncAddr, int(depth), resync)
+	if since.IsZero() {
+		_, err = h.ingester.Sync(h.ctx, peerID, syncAddr, int(depth), resync)
+	} else {
+		_, err = h.ingester.SyncSince(h.ctx, peerID, syncAddr, since, int(depth))
+	}
 	if err != nil {
 		msg := "Cannot sync with peer"
 		log.Errorw(msg, "err", err)
@@ -143,6 +235,450 @@ func (h *adminHandler) sync(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusAccepted)
 }
 
+// clearSync removes the latest sync for a provider's publisher, so that the
+// next sync traverses the publisher's whole advertisement chain.
+func (h *adminHandler) clearSync(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	provID, ok := decodePeerID(vars["providerid"], w)
+	if !ok {
+		return
+	}
+
+	pinfo := h.reg.ProviderInfo(provID)
+	if pinfo == nil {
+		http.Error(w, "provider not found", http.StatusNotFound)
+		return
+	}
+	// The latest sync is recorded for the publisher of the provider's
+	// advertisements, which is the provider if it has no separate publisher.
+	pubID := pinfo.Publisher
+	if pubID.Validate() != nil {
+		pubID = provID
+	}
+
+	err := h.ingester.ClearLatestSync(h.ctx, pubID)
+	if err != nil {
+		msg := "Cannot clear latest sync"
+		log.Errorw(msg, "err", err, "provider", provID, "publisher", pubID)
+		http.Error(w, msg, http.StatusInternalServerError)
+		return
+	}
+	log.Infow("Cleared latest sync, next sync will traverse entire advertisement chain", "provider", provID, "publisher", pubID)
+	w.WriteHeader(http.StatusOK)
+}
+
+// providerEntryCount writes the number of multihashes indexed for a provider.
+func (h *adminHandler) providerEntryCount(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	provID, ok := decodePeerID(vars["providerid"], w)
+	if !ok {
+		return
+	}
+
+	if h.reg.ProviderInfo(provID) == nil {
+		http.Error(w, "provider not found", http.StatusNotFound)
+		return
+	}
+
+	count := model.ProviderEntryCount{
+		ProviderID: provID,
+		EntryCount: h.reg.ProviderEntryCount(provID),
+	}
+	if h.ingester != nil {
+		count.InvalidEntryCount = h.ingester.InvalidMultihashCount(provID)
+	}
+	data, err := json.Marshal(count)
+	if err != nil {
+		log.Errorw("Cannot marshal provider entry count", "err", err)
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(data)
+	if err != nil {
+		log.Errorw("Cannot write provider entry count response", "err", err)
+	}
+}
+
+// setAlias makes advertisements from the alias peer be attributed to the
+// provider.
+func (h *adminHandler) setAlias(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	provID, ok := decodePeerID(vars["providerid"], w)
+	if !ok {
+		return
+	}
+	alias, ok := decodePeerID(vars["alias"], w)
+	if !ok {
+		return
+	}
+
+	err := h.reg.SetAlias(h.ctx, alias, provID)
+	if err != nil {
+		switch {
+		case errors.Is(err, registry.ErrBadAlias):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, registry.ErrNotAllowed):
+			http.Error(w, err.Error(), http.StatusForbidden)
+		default:
+			msg := "Cannot set provider alias"
+			log.Errorw(msg, "err", err)
+			http.Error(w, msg, http.StatusInternalServerError)
+		}
+		return
+	}
+	log.Infow("Set provider alias", "alias", alias, "provider", provID)
+	w.WriteHeader(http.StatusOK)
+}
+
+// removeAlias removes an alias, so that advertisements from the alias peer
+// are attributed to that peer again.
+func (h *adminHandler) removeAlias(w http.ResponseWriter, r *http.Request) {
+	alias, ok := decodePeerID(mux.Vars(r)["alias"], w)
+	if !ok {
+		return
+	}
+	removed, err := h.reg.RemoveAlias(h.ctx, alias)
+	if err != nil {
+		msg := "Cannot remove provider alias"
+		log.Errorw(msg, "err", err)
+		http.Error(w, msg, http.StatusInternalServerError)
+		return
+	}
+	if !removed {
+		http.Error(w, "alias not found", http.StatusNotFound)
+		return
+	}
+	log.Infow("Removed provider alias", "alias", alias)
+	w.WriteHeader(http.StatusOK)
+}
+
+// listAliases writes all provider aliases.
+func (h *adminHandler) listAliases(w http.ResponseWriter, r *http.Request) {
+	regAliases := h.reg.Aliases()
+	aliases := make([]model.ProviderAlias, len(regAliases))
+	for i, a := range regAliases {
+		aliases[i] = model.ProviderAlias{
+			Alias:      a.Alias,
+			ProviderID: a.Provider,
+		}
+	}
+	data, err := json.Marshal(aliases)
+	if err != nil {
+		log.Errorw("Cannot marshal provider aliases", "err", err)
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(data)
+	if err != nil {
+		log.Errorw("Cannot write provider aliases response", "err", err)
+	}
+}
+
+// setProviderTags replaces a provider's tags with the JSON list of tags in
+// the request body. An empty list removes all of the provider's tags.
+func (h *adminHandler) setProviderTags(w http.ResponseWriter, r *http.Request) {
+	provID, ok := decodePeerID(mux.Vars(r)["providerid"], w)
+	if !ok {
+		return
+	}
+	var tags []string
+	if err := json.NewDecoder(r.Body).Decode(&tags); err != nil {
+		log.Errorw("Cannot decode provider tags", "err", err)
+		http.Error(w, "request body must be a JSON list of tags", http.StatusBadRequest)
+		return
+	}
+
+	err := h.reg.SetProviderTags(h.ctx, provID, tags)
+	if err != nil {
+		switch {
+		case errors.Is(err, registry.ErrBadTag):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, registry.ErrProviderNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		default:
+			msg := "Cannot set provider tags"
+			log.Errorw(msg, "err", err)
+			http.Error(w, msg, http.StatusInternalServerError)
+		}
+		return
+	}
+	log.Infow("Set provider tags", "provider", provID, "tags", tags)
+	w.WriteHeader(http.StatusOK)
+}
+
+// getProviderTags writes the tags of a provider.
+func (h *adminHandler) getProviderTags(w http.ResponseWriter, r *http.Request) {
+	provID, ok := decodePeerID(mux.Vars(r)["providerid"], w)
+	if !ok {
+		return
+	}
+	info := h.reg.ProviderInfo(provID)
+	if info == nil {
+		http.Error(w, registry.ErrProviderNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	tags := info.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	data, err := json.Marshal(tags)
+	if err != nil {
+		log.Errorw("Cannot marshal provider tags", "err", err)
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(data)
+	if err != nil {
+		log.Errorw("Cannot write provider tags response", "err", err)
+	}
+}
+
+// blockMultihash adds a multihash to the blocklist, so that it is not
+// returned in find results or indexed.
+func (h *adminHandler) blockMultihash(w http.ResponseWriter, r *http.Request) {
+	mh, ok := decodeMultihash(mux.Vars(r)["multihash"], w)
+	if !ok {
+		return
+	}
+	added, err := h.reg.BlockMultihash(h.ctx, mh)
+	if err != nil {
+		msg := "Cannot block multihash"
+		log.Errorw(msg, "err", err)
+		http.Error(w, msg, http.StatusInternalServerError)
+		return
+	}
+	if added {
+		log.Infow("Blocked multihash", "multihash", mh.B58String())
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// unblockMultihash removes a multihash from the blocklist.
+func (h *adminHandler) unblockMultihash(w http.ResponseWriter, r *http.Request) {
+	mh, ok := decodeMultihash(mux.Vars(r)["multihash"], w)
+	if !ok {
+		return
+	}
+	removed, err := h.reg.UnblockMultihash(h.ctx, mh)
+	if err != nil {
+		msg := "Cannot unblock multihash"
+		log.Errorw(msg, "err", err)
+		http.Error(w, msg, http.StatusInternalServerError)
+		return
+	}
+	if !removed {
+		http.Error(w, "multihash not blocked", http.StatusNotFound)
+		return
+	}
+	log.Infow("Unblocked multihash", "multihash", mh.B58String())
+	w.WriteHeader(http.StatusOK)
+}
+
+// listBlocked writes all blocked multihashes.
+func (h *adminHandler) listBlocked(w http.ResponseWriter, r *http.Request) {
+	data, err := json.Marshal(h.reg.BlockedMultihashes())
+	if err != nil {
+		log.Errorw("Cannot marshal blocklist", "err", err)
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(data)
+	if err != nil {
+		log.Errorw("Cannot write blocklist response", "err", err)
+	}
+}
+
+// setMetadataOverride sets the request body as metadata that replaces the
+// metadata advertised for a provider's context in find results.
+func (h *adminHandler) setMetadataOverride(w http.ResponseWriter, r *http.Request) {
+	provID, contextID, ok := decodeProviderContext(mux.Vars(r), w)
+	if !ok {
+		return
+	}
+	metadata, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(metadata) == 0 {
+		http.Error(w, "missing metadata", http.StatusBadRequest)
+		return
+	}
+
+	err = h.reg.SetMetadataOverride(h.ctx, provID, contextID, metadata)
+	if err != nil {
+		writeMetadataOverrideError(w, err)
+		return
+	}
+	log.Infow("Set metadata override", "provider", provID, "contextID", mux.Vars(r)["contextid"])
+	w.WriteHeader(http.StatusOK)
+}
+
+// clearMetadataOverride removes the metadata override for a provider's
+// context.
+func (h *adminHandler) clearMetadataOverride(w http.ResponseWriter, r *http.Request) {
+	provID, contextID, ok := decodeProviderContext(mux.Vars(r), w)
+	if !ok {
+		return
+	}
+	err := h.reg.ClearMetadataOverride(h.ctx, provID, contextID)
+	if err != nil {
+		writeMetadataOverrideError(w, err)
+		return
+	}
+	log.Infow("Cleared metadata override", "provider", provID, "contextID", mux.Vars(r)["contextid"])
+	w.WriteHeader(http.StatusOK)
+}
+
+func writeMetadataOverrideError(w http.ResponseWriter, err error) {
+	if errors.Is(err, registry.ErrContextNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	msg := "Cannot update metadata override"
+	log.Errorw(msg, "err", err)
+	http.Error(w, msg, http.StatusInternalServerError)
+}
+
+// syncState writes the latest sync for each publisher, as seen by the
+// subscriber and as persisted in the datastore.
+func (h *adminHandler) syncState(w http.ResponseWriter, r *http.Request) {
+	states, err := h.ingester.SyncStates(r.Context())
+	if err != nil {
+		msg := "Cannot get sync state"
+		log.Errorw(msg, "err", err)
+		http.Error(w, msg, http.StatusInternalServerError)
+		return
+	}
+	for i := range states {
+		if states[i].Mismatch {
+			log.Warnw("Subscriber latest sync does not match persisted latest sync",
+				"publisher", states[i].PeerID, "subscriber", states[i].SubscriberSync, "persisted", states[i].PersistedSync)
+		}
+	}
+
+	data, err := json.Marshal(states)
+	if err != nil {
+		log.Errorw("Cannot marshal sync state", "err", err)
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(data)
+	if err != nil {
+		log.Errorw("Cannot write sync state response", "err", err)
+	}
+}
+
+// listSyncs writes the syncs requested through the admin API, or by other
+// callers of the ingester's Sync, that are still in progress.
+func (h *adminHandler) listSyncs(w http.ResponseWriter, r *http.Request) {
+	data, err := json.Marshal(h.ingester.InFlightSyncs())
+	if err != nil {
+		log.Errorw("Cannot marshal in-flight syncs", "err", err)
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(data)
+	if err != nil {
+		log.Errorw("Cannot write in-flight syncs response", "err", err)
+	}
+}
+
+// cancelSync cancels an in-flight sync, identified by the ID listed by
+// listSyncs.
+func (h *adminHandler) cancelSync(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	idStr := vars["id"]
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		log.Errorw("Cannot parse sync id", "id", idStr, "err", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !h.ingester.CancelSync(id) {
+		http.Error(w, "sync not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// retryFailures queues the advertisements that failed to ingest to be
+// ingested again, optionally only those of one provider, and writes a summary
+// of how many were queued.
+func (h *adminHandler) retryFailures(w http.ResponseWriter, r *http.Request) {
+	var provID peer.ID
+	if provStr := r.URL.Query().Get("provider"); provStr != "" {
+		var ok bool
+		provID, ok = decodePeerID(provStr, w)
+		if !ok {
+			return
+		}
+	}
+
+	summary, err := h.ingester.RetryFailedAds(h.ctx, provID)
+	if err != nil {
+		msg := "Cannot retry failed advertisements"
+		log.Errorw(msg, "err", err, "provider", provID)
+		http.Error(w, msg, http.StatusInternalServerError)
+		return
+	}
+
+	data, err := json.Marshal(summary)
+	if err != nil {
+		log.Errorw("Cannot marshal failure retry summary", "err", err)
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(data)
+	if err != nil {
+		log.Errorw("Cannot write failure retry response", "err", err)
+	}
+}
+
+// providerSyncHistory writes the summaries of the provider's recent syncs,
+// newest first.
+func (h *adminHandler) providerSyncHistory(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	provID, ok := decodePeerID(vars["providerid"], w)
+	if !ok {
+		return
+	}
+
+	history, err := h.ingester.SyncHistory(r.Context(), provID)
+	if err != nil {
+		msg := "Cannot read sync history"
+		log.Errorw(msg, "err", err, "provider", provID)
+		http.Error(w, msg, http.StatusInternalServerError)
+		return
+	}
+	if len(history) == 0 {
+		if h.reg.ProviderInfo(provID) == nil {
+			http.Error(w, "provider not found", http.StatusNotFound)
+			return
+		}
+		history = []model.SyncSummary{}
+	}
+
+	data, err := json.Marshal(history)
+	if err != nil {
+		log.Errorw("Cannot marshal sync history", "err", err)
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(data)
+	if err != nil {
+		log.Errorw("Cannot write sync history response", "err", err)
+	}
+}
+
 func (h *adminHandler) importProviders(w http.ResponseWriter, r *http.Request) {
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
@@ -191,6 +727,21 @@ func (h *adminHandler) reloadConfig(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
+// flush writes any pending changes in the value store to storage, and
+// returns once the changes are durable. This lets an operator take a
+// consistent backup of the value store directory.
+func (h *adminHandler) flush(w http.ResponseWriter, r *http.Request) {
+	log.Info("Flushing value store")
+	start := time.Now()
+	if err := h.indexer.Flush(); err != nil {
+		log.Errorw("Cannot flush value store", "err", err)
+		http.Error(w, "cannot flush value store: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Infow("Flushed value store", "elapsed", time.Since(start))
+	w.WriteHeader(http.StatusOK)
+}
+
 // ----- import handlers -----
 
 func (h *adminHandler) importManifest(w http.ResponseWriter, r *http.Request) {
@@ -234,8 +785,8 @@ func (h *adminHandler) importManifest(w http.ResponseWriter, r *http.Request) {
 		ContextID:     contextID,
 		MetadataBytes: metadata,
 	}
-	batchErr := batchIndexerEntries(importBatchSize, out, value, h.indexer)
-	err = <-batchErr
+	batchRes := batchIndexerEntries(importBatchSize, out, value, h.indexer)
+	err = (<-batchRes).err
 	if err != nil {
 		log.Errorf("Error putting entries in indexer: %s", err)
 		http.Error(w, "", http.StatusInternalServerError)
@@ -253,6 +804,189 @@ func (h *adminHandler) importManifest(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
+// importManifestDir imports each manifest file in a directory. A file that
+// fails to import does not stop the remaining files from being imported. The
+// response reports the result for each file.
+func (h *adminHandler) importManifestDir(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	provID, ok := decodePeerID(vars["provider"], w)
+	if !ok {
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Errorw("failed reading import manifest directory request", "err", err)
+		http.Error(w, "", http.StatusBadRequest)
+		return
+	}
+
+	dirName, contextID, metadata, err := getParams(body)
+	if err != nil {
+		log.Error(err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	entries, err := os.ReadDir(dirName)
+	if err != nil {
+		log.Errorw("Cannot read manifest directory", "err", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	value := indexer.Value{
+		ProviderID:    provID,
+		ContextID:     contextID,
+		MetadataBytes: metadata,
+	}
+
+	var result model.ImportDirResult
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		fileName := filepath.Join(dirName, entry.Name())
+		count, err := h.importManifestFile(fileName, value)
+		fileResult := model.ImportFileResult{
+			File:     fileName,
+			Imported: count,
+		}
+		if err != nil {
+			log.Errorw("Cannot import manifest file", "file", fileName, "err", err)
+			fileResult.Error = err.Error()
+			result.Failed++
+		}
+		result.TotalImported += count
+		result.Files = append(result.Files, fileResult)
+	}
+	log.Infow("Imported manifest directory", "dir", dirName, "provider", provID,
+		"files", len(result.Files), "failed", result.Failed, "cids", result.TotalImported)
+
+	data, err := json.Marshal(&result)
+	if err != nil {
+		log.Errorw("Cannot marshal import result", "err", err)
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(data)
+	if err != nil {
+		log.Errorw("Cannot write import result response", "err", err)
+	}
+}
+
+// importManifestFile imports the CIDs in a manifest file, and returns the
+// number of CIDs imported.
+func (h *adminHandler) importManifestFile(fileName string, value indexer.Value) (int, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	out := make(chan multihash.Multihash, importBatchSize)
+	errOut := make(chan error, 1)
+	ctx, cancel := context.WithCancel(h.ctx)
+	defer cancel()
+	go importer.ReadManifest(ctx, file, out, errOut)
+
+	res := <-batchIndexerEntries(importBatchSize, out, value, h.indexer)
+	if res.err != nil {
+		// Stop reading the manifest and wait for the reader to exit.
+		cancel()
+		for range out {
+		}
+		<-errOut
+		return res.count, fmt.Errorf("error putting entries in indexer: %s", res.err)
+	}
+
+	err = <-errOut
+	if err != nil {
+		return res.count, fmt.Errorf("error reading manifest: %s", err)
+	}
+	return res.count, nil
+}
+
+// importSnapshot ingests a provider's advertisement chain, and its entries,
+// from a directory of CAR files on the indexer host. The blocks are read into
+// a temporary datastore, and are ingested as if synced from the provider.
+func (h *adminHandler) importSnapshot(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	provID, ok := decodePeerID(vars["provider"], w)
+	if !ok {
+		return
+	}
+	log := log.With("provider", provID)
+
+	var req model.ImportSnapshotRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Errorw("Cannot unmarshal import snapshot request", "err", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Dir == "" {
+		http.Error(w, "missing dir in request", http.StatusBadRequest)
+		return
+	}
+	head := cid.Undef
+	if req.Head != "" {
+		var err error
+		head, err = cid.Decode(req.Head)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("bad head cid: %s", err), http.StatusBadRequest)
+			return
+		}
+	}
+	log = log.With("dir", req.Dir)
+
+	tmpDir, err := os.MkdirTemp("", "snapshot")
+	if err != nil {
+		log.Errorw("Cannot create snapshot datastore directory", "err", err)
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+	defer os.RemoveAll(tmpDir)
+	blockStore, err := leveldb.NewDatastore(tmpDir, nil)
+	if err != nil {
+		log.Errorw("Cannot create snapshot datastore", "err", err)
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+	defer blockStore.Close()
+
+	var result model.ImportSnapshotResult
+	result.Files, result.Blocks, err = importer.ReadCarDir(h.ctx, req.Dir, blockStore)
+	if err != nil {
+		log.Errorw("Cannot read snapshot", "err", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	log.Infow("Read snapshot", "files", result.Files, "blocks", result.Blocks)
+
+	latest, err := h.ingester.IngestSnapshot(r.Context(), provID, blockStore, head)
+	if err != nil {
+		log.Errorw("Cannot ingest snapshot", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if latest != cid.Undef {
+		result.LatestProcessed = latest.String()
+	}
+
+	data, err := json.Marshal(&result)
+	if err != nil {
+		log.Errorw("Cannot marshal import snapshot result", "err", err)
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(data)
+	if err != nil {
+		log.Errorw("Cannot write import snapshot response", "err", err)
+	}
+}
+
 func getParams(data []byte) (string, []byte, []byte, error) {
 	var params map[string][]byte
 	err := json.Unmarshal(data, &params)
@@ -319,8 +1053,8 @@ func (h *adminHandler) importCidList(w http.ResponseWriter, r *http.Request) {
 		ContextID:     contextID,
 		MetadataBytes: metadata,
 	}
-	batchErr := batchIndexerEntries(importBatchSize, out, value, h.indexer)
-	err = <-batchErr
+	batchRes := batchIndexerEntries(importBatchSize, out, value, h.indexer)
+	err = (<-batchRes).err
 	if err != nil {
 		log.Errorf("Error putting entries in indexer: %s", err)
 		http.Error(w, "", http.StatusInternalServerError)
@@ -338,21 +1072,31 @@ func (h *adminHandler) importCidList(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
-// batchIndexerEntries read
-func batchIndexerEntries(batchSize int, putChan <-chan multihash.Multihash, value indexer.Value, idxr indexer.Interface) <-chan error {
-	errChan := make(chan error, 1)
+// batchResult is the outcome of batchIndexerEntries.
+type batchResult struct {
+	// count is the number of multihashes put into the indexer.
+	count int
+	err   error
+}
+
+// batchIndexerEntries reads multihashes from putChan and puts them into the
+// indexer in batches. The returned channel receives the result when putChan is
+// closed or when a put fails.
+func batchIndexerEntries(batchSize int, putChan <-chan multihash.Multihash, value indexer.Value, idxr indexer.Interface) <-chan batchResult {
+	resChan := make(chan batchResult, 1)
 
 	go func() {
-		defer close(errChan)
+		var count int
 		puts := make([]multihash.Multihash, 0, batchSize)
 		for m := range putChan {
 			puts = append(puts, m)
 			if len(puts) == batchSize {
 				// Process full batch of puts
 				if err := idxr.Put(value, puts...); err != nil {
-					errChan <- err
+					resChan <- batchResult{count, err}
 					return
 				}
+				count += len(puts)
 				puts = puts[:0]
 
 			}
@@ -361,13 +1105,15 @@ func batchIndexerEntries(batchSize int, putChan <-chan multihash.Multihash, valu
 		if len(puts) != 0 {
 			// Process any remaining puts
 			if err := idxr.Put(value, puts...); err != nil {
-				errChan <- err
+				resChan <- batchResult{count, err}
 				return
 			}
+			count += len(puts)
 		}
+		resChan <- batchResult{count: count}
 	}()
 
-	return errChan
+	return resChan
 }
 
 // ----- admin handlers -----
@@ -384,6 +1130,23 @@ func (h *adminHandler) healthCheckHandler(w http.ResponseWriter, r *http.Request
 
 // ----- utility functions -----
 
+// decodeProviderContext decodes the provider ID and the context ID, which is
+// encoded as unpadded URL-safe base64, from the request path.
+func decodeProviderContext(vars map[string]string, w http.ResponseWriter) (peer.ID, []byte, bool) {
+	provID, ok := decodePeerID(vars["providerid"], w)
+	if !ok {
+		return provID, nil, false
+	}
+	contextID, err := base64.RawURLEncoding.DecodeString(vars["contextid"])
+	if err != nil {
+		msg := "Cannot decode context id"
+		log.Errorw(msg, "id", vars["contextid"], "err", err)
+		http.Error(w, msg, http.StatusBadRequest)
+		return provID, nil, false
+	}
+	return provID, contextID, true
+}
+
 func decodePeerID(id string, w http.ResponseWriter) (peer.ID, bool) {
 	peerID, err := peer.Decode(id)
 	if err != nil {
@@ -394,3 +1157,14 @@ func decodePeerID(id string, w http.ResponseWriter) (peer.ID, bool) {
 	}
 	return peerID, true
 }
+
+func decodeMultihash(s string, w http.ResponseWriter) (multihash.Multihash, bool) {
+	mh, err := multihash.FromB58String(s)
+	if err != nil {
+		msg := "Cannot decode multihash"
+		log.Errorw(msg, "multihash", s, "err", err)
+		http.Error(w, msg, http.StatusBadRequest)
+		return nil, false
+	}
+	return mh, true
+}
@@ -0,0 +1,190 @@
+package adminserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	agg "github.com/filecoin-project/go-dagaggregator-unixfs"
+	"github.com/filecoin-project/go-indexer-core"
+	"github.com/filecoin-project/go-indexer-core/engine"
+	"github.com/filecoin-project/go-indexer-core/store/memory"
+	"github.com/filecoin-project/storetheindex/api/v0/admin/model"
+	"github.com/filecoin-project/storetheindex/config"
+	"github.com/filecoin-project/storetheindex/internal/registry"
+	"github.com/filecoin-project/storetheindex/test/util"
+	qt "github.com/frankban/quicktest"
+	"github.com/gorilla/mux"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/multiformats/go-multihash"
+)
+
+func writeTestManifest(t *testing.T, fileName string, mhs []multihash.Multihash) {
+	var buf bytes.Buffer
+	for _, mh := range mhs {
+		e := agg.ManifestDagEntry{
+			RecordType: "DagAggregateEntry",
+			DagCidV1:   cid.NewCidV1(cid.Raw, mh).String(),
+		}
+		line, err := json.Marshal(e)
+		qt.Assert(t, err, qt.IsNil)
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	err := os.WriteFile(fileName, buf.Bytes(), 0644)
+	qt.Assert(t, err, qt.IsNil)
+}
+
+func Test_ImportManifestDir(t *testing.T) {
+	idx := engine.New(nil, memory.New())
+	h := newHandler(context.Background(), idx, nil, nil, nil)
+	router := mux.NewRouter()
+	router.HandleFunc("/import/manifestdir/{provider}", h.importManifestDir).Methods(http.MethodPost)
+
+	rng := rand.New(rand.NewSource(1413))
+	mhsA := util.RandomMultihashes(3, rng)
+	mhsC := util.RandomMultihashes(2, rng)
+	dir := t.TempDir()
+	writeTestManifest(t, filepath.Join(dir, "a.manifest"), mhsA)
+	err := os.WriteFile(filepath.Join(dir, "b.manifest"), []byte("not a manifest\n"), 0644)
+	qt.Assert(t, err, qt.IsNil)
+	writeTestManifest(t, filepath.Join(dir, "c.manifest"), mhsC)
+	err = os.Mkdir(filepath.Join(dir, "subdir"), 0755)
+	qt.Assert(t, err, qt.IsNil)
+
+	provID, err := peer.Decode("12D3KooWKRyzVWW6ChFjQjK4miCty85Niy48tpPV95XdKu1BcvMA")
+	qt.Assert(t, err, qt.IsNil)
+	body, err := json.Marshal(map[string][]byte{
+		"file":       []byte(dir),
+		"context_id": []byte("test-context"),
+		"metadata":   []byte("test-metadata"),
+	})
+	qt.Assert(t, err, qt.IsNil)
+
+	req, err := http.NewRequest(http.MethodPost, "/import/manifestdir/"+provID.String(), bytes.NewReader(body))
+	qt.Assert(t, err, qt.IsNil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	qt.Assert(t, rr.Code, qt.Equals, http.StatusOK)
+
+	var result model.ImportDirResult
+	err = json.Unmarshal(rr.Body.Bytes(), &result)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, result.Files, qt.HasLen, 3)
+	qt.Check(t, result.TotalImported, qt.Equals, len(mhsA)+len(mhsC))
+	qt.Check(t, result.Failed, qt.Equals, 1)
+	qt.Check(t, result.Files[0].Imported, qt.Equals, len(mhsA))
+	qt.Check(t, result.Files[0].Error, qt.Equals, "")
+	qt.Check(t, result.Files[1].File, qt.Equals, filepath.Join(dir, "b.manifest"))
+	qt.Check(t, result.Files[1].Error, qt.Not(qt.Equals), "")
+	qt.Check(t, result.Files[2].Imported, qt.Equals, len(mhsC))
+
+	// The files after the failed file must still be imported.
+	for _, mh := range append(mhsA, mhsC...) {
+		values, found, err := idx.Get(mh)
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, found, qt.IsTrue)
+		qt.Check(t, values[0].ProviderID, qt.Equals, provID)
+	}
+}
+
+func Test_SubscribeBatch(t *testing.T) {
+	ctx := context.Background()
+	reg, err := registry.NewRegistry(ctx, config.NewDiscovery(), datastore.NewMapDatastore(), nil)
+	qt.Assert(t, err, qt.IsNil)
+	t.Cleanup(func() { reg.Close() })
+
+	h := newHandler(ctx, nil, nil, reg, nil)
+	router := mux.NewRouter()
+	router.HandleFunc("/subscribe/batch", h.subscribeBatch).Methods(http.MethodPost)
+	router.HandleFunc("/unsubscribe/batch", h.unsubscribeBatch).Methods(http.MethodPost)
+
+	peerA, err := peer.Decode("12D3KooWKRyzVWW6ChFjQjK4miCty85Niy48tpPV95XdKu1BcvMA")
+	qt.Assert(t, err, qt.IsNil)
+	peerB, err := peer.Decode("12D3KooWD1XypSuBmhebQcvq7Sf1XJZ1hKSfYCED4w6eyxhzwqnV")
+	qt.Assert(t, err, qt.IsNil)
+
+	doBatch := func(resource string, ids []string) []model.SubscribeResult {
+		body, err := json.Marshal(ids)
+		qt.Assert(t, err, qt.IsNil)
+		req, err := http.NewRequest(http.MethodPost, resource, bytes.NewReader(body))
+		qt.Assert(t, err, qt.IsNil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		qt.Assert(t, rr.Code, qt.Equals, http.StatusOK)
+		var results []model.SubscribeResult
+		err = json.Unmarshal(rr.Body.Bytes(), &results)
+		qt.Assert(t, err, qt.IsNil)
+		return results
+	}
+
+	results := doBatch("/unsubscribe/batch", []string{peerA.String(), "bad-id", peerB.String()})
+	qt.Assert(t, results, qt.HasLen, 3)
+	qt.Check(t, results[0].PeerID, qt.Equals, peerA.String())
+	qt.Check(t, results[0].Changed, qt.IsTrue)
+	qt.Check(t, results[0].Err, qt.Equals, "")
+	qt.Check(t, results[1].PeerID, qt.Equals, "bad-id")
+	qt.Check(t, results[1].Err, qt.Not(qt.Equals), "")
+	qt.Check(t, results[2].Changed, qt.IsTrue)
+	qt.Check(t, reg.Allowed(peerA), qt.IsFalse)
+	qt.Check(t, reg.Allowed(peerB), qt.IsFalse)
+
+	results = doBatch("/subscribe/batch", []string{peerA.String()})
+	qt.Assert(t, results, qt.HasLen, 1)
+	qt.Check(t, results[0].Changed, qt.IsTrue)
+	qt.Check(t, reg.Allowed(peerA), qt.IsTrue)
+	qt.Check(t, reg.Allowed(peerB), qt.IsFalse)
+
+	// Subscribing again does not change anything.
+	results = doBatch("/subscribe/batch", []string{peerA.String()})
+	qt.Assert(t, results, qt.HasLen, 1)
+	qt.Check(t, results[0].Changed, qt.IsFalse)
+
+	req, err := http.NewRequest(http.MethodPost, "/subscribe/batch", bytes.NewReader([]byte("not json")))
+	qt.Assert(t, err, qt.IsNil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	qt.Assert(t, rr.Code, qt.Equals, http.StatusBadRequest)
+}
+
+// flushTestIndexer counts calls to Flush, and fails them if err is set.
+type flushTestIndexer struct {
+	indexer.Interface
+	flushes int
+	err     error
+}
+
+func (x *flushTestIndexer) Flush() error {
+	x.flushes++
+	return x.err
+}
+
+func Test_Flush(t *testing.T) {
+	idx := &flushTestIndexer{Interface: engine.New(nil, memory.New())}
+	h := newHandler(context.Background(), idx, nil, nil, nil)
+	router := mux.NewRouter()
+	router.HandleFunc("/flush", h.flush).Methods(http.MethodPost)
+
+	req, err := http.NewRequest(http.MethodPost, "/flush", nil)
+	qt.Assert(t, err, qt.IsNil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	qt.Assert(t, rr.Code, qt.Equals, http.StatusOK)
+	qt.Assert(t, idx.flushes, qt.Equals, 1)
+
+	idx.err = errors.New("disk full")
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	qt.Assert(t, rr.Code, qt.Equals, http.StatusInternalServerError)
+	qt.Assert(t, rr.Body.String(), qt.Contains, "disk full")
+	qt.Assert(t, idx.flushes, qt.Equals, 2)
+}
@@ -0,0 +1,471 @@
+package adminserver
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	indexer "github.com/filecoin-project/go-indexer-core"
+	"github.com/filecoin-project/storetheindex/api/v0/admin/model"
+	"github.com/filecoin-project/storetheindex/internal/importer"
+	"github.com/filecoin-project/storetheindex/internal/safemode"
+	"github.com/gorilla/mux"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	"github.com/multiformats/go-multihash"
+)
+
+// importJobKeyPath is where import jobs are stored in the indexer repo.
+const importJobKeyPath = "/admin/importjob"
+
+// importPutRetries is the number of times that putting a batch of imported
+// multihashes is retried before the import job fails.
+const importPutRetries = 3
+
+// importRetryWait is the time to wait before the first retry. The wait
+// doubles with each retry.
+var importRetryWait = time.Second
+
+var (
+	errImportJobNotFound   = errors.New("import job not found")
+	errImportJobRunning    = errors.New("import job is running")
+	errImportJobNotRunning = errors.New("import job is not running")
+	errImportJobDone       = errors.New("import job is done")
+)
+
+// importJobs runs import jobs, which import a file in the background and
+// record their progress. The progress is stored in the datastore, if there is
+// one, after each batch of multihashes is imported. This lets a job be
+// resumed after it fails or is canceled, and lets running jobs be resumed
+// when the indexer restarts, without importing the whole file again.
+type importJobs struct {
+	ctx     context.Context
+	dstore  datastore.Datastore
+	indexer indexer.Interface
+
+	mutex   sync.Mutex
+	jobs    map[string]*importJob
+	running sync.WaitGroup
+}
+
+type importJob struct {
+	model.ImportJob
+	// cancel stops the job, and is nil when the job is not running.
+	cancel context.CancelFunc
+}
+
+func importJobDsKey(id string) datastore.Key {
+	return datastore.NewKey(path.Join(importJobKeyPath, id))
+}
+
+// newImportJobs loads the jobs stored in the datastore, and resumes the ones
+// that were running. The jobs stop when ctx is canceled.
+func newImportJobs(ctx context.Context, dstore datastore.Datastore, idxr indexer.Interface, safeMode *safemode.Skipper) (*importJobs, error) {
+	j := &importJobs{
+		ctx:     ctx,
+		dstore:  dstore,
+		indexer: idxr,
+		jobs:    map[string]*importJob{},
+	}
+	if dstore == nil {
+		return j, nil
+	}
+
+	results, err := dstore.Query(ctx, query.Query{Prefix: importJobKeyPath})
+	if err != nil {
+		return nil, err
+	}
+	defer results.Close()
+
+	var resumed int
+	for result := range results.Next() {
+		if result.Error != nil {
+			if safeMode.Skip("import job", result.Key, result.Error) {
+				continue
+			}
+			return nil, fmt.Errorf("cannot read import job: %w", result.Error)
+		}
+		job := new(importJob)
+		if err = json.Unmarshal(result.Entry.Value, &job.ImportJob); err != nil {
+			if safeMode.Skip("import job", result.Key, err) {
+				continue
+			}
+			return nil, fmt.Errorf("cannot decode import job: %w", err)
+		}
+		j.jobs[job.ID] = job
+		if job.Status == model.ImportJobRunning {
+			j.run(job)
+			resumed++
+		}
+	}
+	if resumed != 0 {
+		log.Infow("Resumed import jobs", "count", resumed)
+	}
+	return j, nil
+}
+
+// start creates a job that imports the requested file, and starts it.
+func (j *importJobs) start(req model.ImportJobRequest) (model.ImportJob, error) {
+	switch req.Kind {
+	case model.ImportJobManifest, model.ImportJobCidList:
+	default:
+		return model.ImportJob{}, fmt.Errorf("unknown import kind %q", req.Kind)
+	}
+	if req.Provider == "" {
+		return model.ImportJob{}, errors.New("missing provider in request")
+	}
+	if req.File == "" {
+		return model.ImportJob{}, errors.New("missing file in request")
+	}
+	if _, err := os.Stat(req.File); err != nil {
+		return model.ImportJob{}, err
+	}
+
+	idBytes := make([]byte, 8)
+	if _, err := rand.Read(idBytes); err != nil {
+		return model.ImportJob{}, err
+	}
+	now := time.Now()
+	job := &importJob{
+		ImportJob: model.ImportJob{
+			ImportJobRequest: req,
+			ID:               hex.EncodeToString(idBytes),
+			Status:           model.ImportJobRunning,
+			Started:          now,
+			Updated:          now,
+		},
+	}
+
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	if err := j.persist(job.ImportJob); err != nil {
+		return model.ImportJob{}, err
+	}
+	j.jobs[job.ID] = job
+	j.run(job)
+	return job.ImportJob, nil
+}
+
+// resume restarts a failed or canceled job from where it stopped.
+func (j *importJobs) resume(id string) (model.ImportJob, error) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	job, ok := j.jobs[id]
+	if !ok {
+		return model.ImportJob{}, errImportJobNotFound
+	}
+	switch job.Status {
+	case model.ImportJobRunning:
+		return model.ImportJob{}, errImportJobRunning
+	case model.ImportJobDone:
+		return model.ImportJob{}, errImportJobDone
+	}
+	// Wait for the job to stop if it was canceled and has not stopped yet.
+	if job.cancel != nil {
+		return model.ImportJob{}, errImportJobRunning
+	}
+	job.Status = model.ImportJobRunning
+	job.Error = ""
+	job.Updated = time.Now()
+	if err := j.persist(job.ImportJob); err != nil {
+		return model.ImportJob{}, err
+	}
+	j.run(job)
+	return job.ImportJob, nil
+}
+
+// cancel stops a running job. The job keeps its progress, and can be resumed.
+func (j *importJobs) cancel(id string) (model.ImportJob, error) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	job, ok := j.jobs[id]
+	if !ok {
+		return model.ImportJob{}, errImportJobNotFound
+	}
+	if job.Status != model.ImportJobRunning {
+		return model.ImportJob{}, errImportJobNotRunning
+	}
+	job.Status = model.ImportJobCanceled
+	job.Updated = time.Now()
+	if job.cancel != nil {
+		job.cancel()
+	}
+	if err := j.persist(job.ImportJob); err != nil {
+		return model.ImportJob{}, err
+	}
+	return job.ImportJob, nil
+}
+
+// get returns the job with the given ID.
+func (j *importJobs) get(id string) (model.ImportJob, bool) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	job, ok := j.jobs[id]
+	if !ok {
+		return model.ImportJob{}, false
+	}
+	return job.ImportJob, true
+}
+
+// list returns all jobs, in the order they were started.
+func (j *importJobs) list() []model.ImportJob {
+	j.mutex.Lock()
+	jobs := make([]model.ImportJob, 0, len(j.jobs))
+	for _, job := range j.jobs {
+		jobs = append(jobs, job.ImportJob)
+	}
+	j.mutex.Unlock()
+
+	sort.Slice(jobs, func(i, k int) bool {
+		return jobs[i].Started.Before(jobs[k].Started)
+	})
+	return jobs
+}
+
+// wait waits for all running jobs to stop, after the context given to
+// newImportJobs is canceled.
+func (j *importJobs) wait() {
+	j.running.Wait()
+}
+
+// run starts importing the job's file in the background, skipping the
+// multihashes that the job already imported. The caller must hold the mutex.
+func (j *importJobs) run(job *importJob) {
+	ctx, cancel := context.WithCancel(j.ctx)
+	job.cancel = cancel
+	skip := job.Imported
+	req := job.ImportJobRequest
+
+	j.running.Add(1)
+	go func() {
+		defer j.running.Done()
+		defer cancel()
+
+		log := log.With("job", job.ID, "file", req.File, "provider", req.Provider)
+		log.Infow("Import job started", "skip", skip)
+		err := j.importFile(ctx, req, skip, func(count int) error {
+			j.mutex.Lock()
+			defer j.mutex.Unlock()
+			job.Imported += count
+			job.Updated = time.Now()
+			return j.persist(job.ImportJob)
+		})
+
+		j.mutex.Lock()
+		defer j.mutex.Unlock()
+		job.cancel = nil
+		if ctx.Err() != nil {
+			// The job was canceled, or the indexer is shutting down. If
+			// shutting down, the job is still running, and is resumed when
+			// the indexer restarts.
+			log.Infow("Import job stopped", "imported", job.Imported, "status", job.Status)
+			return
+		}
+		job.Updated = time.Now()
+		if err != nil {
+			log.Errorw("Import job failed", "imported", job.Imported, "err", err)
+			job.Status = model.ImportJobFailed
+			job.Error = err.Error()
+		} else {
+			log.Infow("Import job done", "imported", job.Imported)
+			job.Status = model.ImportJobDone
+		}
+		if err = j.persist(job.ImportJob); err != nil {
+			log.Errorw("Cannot store import job", "err", err)
+		}
+	}()
+}
+
+// importFile imports the multihashes in the file, after skipping the given
+// number of multihashes, in batches. The progress function is called with the
+// number of multihashes imported after each batch is put.
+func (j *importJobs) importFile(ctx context.Context, req model.ImportJobRequest, skip int, progress func(int) error) error {
+	file, err := os.Open(req.File)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	out := make(chan multihash.Multihash, importBatchSize)
+	errOut := make(chan error, 1)
+	switch req.Kind {
+	case model.ImportJobManifest:
+		go importer.ReadManifest(ctx, file, out, errOut)
+	case model.ImportJobCidList:
+		go importer.ReadCids(ctx, file, out, errOut)
+	default:
+		return fmt.Errorf("unknown import kind %q", req.Kind)
+	}
+
+	value := indexer.Value{
+		ProviderID:    req.Provider,
+		ContextID:     req.ContextID,
+		MetadataBytes: req.Metadata,
+	}
+	puts := make([]multihash.Multihash, 0, importBatchSize)
+	putBatch := func() error {
+		if err := j.putRetry(ctx, value, puts); err != nil {
+			return err
+		}
+		if err := progress(len(puts)); err != nil {
+			return fmt.Errorf("cannot store import progress: %w", err)
+		}
+		puts = puts[:0]
+		return nil
+	}
+
+	for mh := range out {
+		if skip != 0 {
+			skip--
+			continue
+		}
+		puts = append(puts, mh)
+		if len(puts) == importBatchSize {
+			if err = putBatch(); err != nil {
+				break
+			}
+		}
+	}
+	if err == nil && len(puts) != 0 {
+		err = putBatch()
+	}
+	if err != nil {
+		// Stop reading the file and wait for the reader to exit.
+		cancel()
+		for range out {
+		}
+		<-errOut
+		return err
+	}
+	if err = <-errOut; err != nil {
+		return fmt.Errorf("error reading %s: %w", req.Kind, err)
+	}
+	return nil
+}
+
+// putRetry puts the multihashes into the indexer, retrying with increasing
+// waits if the put fails.
+func (j *importJobs) putRetry(ctx context.Context, value indexer.Value, mhs []multihash.Multihash) error {
+	wait := importRetryWait
+	for i := 0; ; i++ {
+		err := j.indexer.Put(value, mhs...)
+		if err == nil {
+			return nil
+		}
+		if i == importPutRetries {
+			return fmt.Errorf("error putting entries in indexer: %w", err)
+		}
+		log.Warnw("Cannot put imported entries, retrying", "err", err, "wait", wait)
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+		wait *= 2
+	}
+}
+
+// persist stores the job in the datastore. The caller must hold the mutex.
+func (j *importJobs) persist(job model.ImportJob) error {
+	if j.dstore == nil {
+		return nil
+	}
+	data, err := json.Marshal(&job)
+	if err != nil {
+		return err
+	}
+	return j.dstore.Put(context.Background(), importJobDsKey(job.ID), data)
+}
+
+// ----- import job handlers -----
+
+func (h *adminHandler) startImportJob(w http.ResponseWriter, r *http.Request) {
+	var req model.ImportJobRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Errorw("Cannot unmarshal import job request", "err", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	job, err := h.importJobs.start(req)
+	if err != nil {
+		log.Errorw("Cannot start import job", "err", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeImportJobs(w, job, http.StatusAccepted)
+}
+
+func (h *adminHandler) listImportJobs(w http.ResponseWriter, r *http.Request) {
+	writeImportJobs(w, h.importJobs.list(), http.StatusOK)
+}
+
+func (h *adminHandler) getImportJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := h.importJobs.get(mux.Vars(r)["id"])
+	if !ok {
+		http.Error(w, errImportJobNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	writeImportJobs(w, job, http.StatusOK)
+}
+
+func (h *adminHandler) resumeImportJob(w http.ResponseWriter, r *http.Request) {
+	job, err := h.importJobs.resume(mux.Vars(r)["id"])
+	if err != nil {
+		writeImportJobError(w, err)
+		return
+	}
+	writeImportJobs(w, job, http.StatusAccepted)
+}
+
+func (h *adminHandler) cancelImportJob(w http.ResponseWriter, r *http.Request) {
+	job, err := h.importJobs.cancel(mux.Vars(r)["id"])
+	if err != nil {
+		writeImportJobError(w, err)
+		return
+	}
+	writeImportJobs(w, job, http.StatusOK)
+}
+
+func writeImportJobError(w http.ResponseWriter, err error) {
+	switch err {
+	case errImportJobNotFound:
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errImportJobRunning, errImportJobNotRunning, errImportJobDone:
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		log.Errorw("Cannot update import job", "err", err)
+		http.Error(w, "", http.StatusInternalServerError)
+	}
+}
+
+// writeImportJobs writes a job, or a list of jobs, as the response.
+func writeImportJobs(w http.ResponseWriter, v interface{}, status int) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Errorw("Cannot marshal import job", "err", err)
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if _, err = w.Write(data); err != nil {
+		log.Errorw("Cannot write import job response", "err", err)
+	}
+}
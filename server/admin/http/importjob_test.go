@@ -0,0 +1,151 @@
+package adminserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/go-indexer-core"
+	"github.com/filecoin-project/go-indexer-core/engine"
+	"github.com/filecoin-project/go-indexer-core/store/memory"
+	"github.com/filecoin-project/storetheindex/api/v0/admin/model"
+	"github.com/filecoin-project/storetheindex/test/util"
+	qt "github.com/frankban/quicktest"
+	"github.com/gorilla/mux"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/multiformats/go-multihash"
+)
+
+// putTestIndexer counts the multihashes put, and fails puts once failAfter
+// multihashes are put, if failAfter is not zero.
+type putTestIndexer struct {
+	indexer.Interface
+	mutex     sync.Mutex
+	puts      int
+	failAfter int
+}
+
+func (x *putTestIndexer) Put(value indexer.Value, mhs ...multihash.Multihash) error {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	if x.failAfter != 0 && x.puts >= x.failAfter {
+		return errors.New("cannot put")
+	}
+	x.puts += len(mhs)
+	return x.Interface.Put(value, mhs...)
+}
+
+func (x *putTestIndexer) setFailAfter(n int) {
+	x.mutex.Lock()
+	x.failAfter = n
+	x.mutex.Unlock()
+}
+
+func Test_ImportJob(t *testing.T) {
+	defer func(wait time.Duration) { importRetryWait = wait }(importRetryWait)
+	importRetryWait = time.Millisecond
+	idx := &putTestIndexer{
+		Interface: engine.New(nil, memory.New()),
+		failAfter: importBatchSize,
+	}
+	dstore := dssync.MutexWrap(datastore.NewMapDatastore())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	h := newHandler(ctx, idx, nil, nil, nil)
+	var err error
+	h.importJobs, err = newImportJobs(ctx, dstore, idx, nil)
+	qt.Assert(t, err, qt.IsNil)
+	router := mux.NewRouter()
+	router.HandleFunc("/import/jobs", h.startImportJob).Methods(http.MethodPost)
+	router.HandleFunc("/import/jobs/{id}", h.getImportJob).Methods(http.MethodGet)
+	router.HandleFunc("/import/jobs/{id}/resume", h.resumeImportJob).Methods(http.MethodPost)
+
+	rng := rand.New(rand.NewSource(1413))
+	mhs := util.RandomMultihashes(2*importBatchSize+10, rng)
+	fileName := filepath.Join(t.TempDir(), "test.manifest")
+	writeTestManifest(t, fileName, mhs)
+	provID, err := peer.Decode("12D3KooWKRyzVWW6ChFjQjK4miCty85Niy48tpPV95XdKu1BcvMA")
+	qt.Assert(t, err, qt.IsNil)
+
+	doRequest := func(method, target string, body []byte) model.ImportJob {
+		req, err := http.NewRequest(method, target, bytes.NewReader(body))
+		qt.Assert(t, err, qt.IsNil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		qt.Assert(t, rr.Code == http.StatusOK || rr.Code == http.StatusAccepted, qt.IsTrue, qt.Commentf("status %d: %s", rr.Code, rr.Body.String()))
+		var job model.ImportJob
+		err = json.Unmarshal(rr.Body.Bytes(), &job)
+		qt.Assert(t, err, qt.IsNil)
+		return job
+	}
+	waitStopped := func(id string) model.ImportJob {
+		for i := 0; i < 100; i++ {
+			job := doRequest(http.MethodGet, "/import/jobs/"+id, nil)
+			if job.Status != model.ImportJobRunning {
+				return job
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		t.Fatal("timed out waiting for import job to stop")
+		return model.ImportJob{}
+	}
+
+	body, err := json.Marshal(model.ImportJobRequest{
+		Kind:      model.ImportJobManifest,
+		Provider:  provID,
+		File:      fileName,
+		ContextID: []byte("test-context"),
+		Metadata:  []byte("test-metadata"),
+	})
+	qt.Assert(t, err, qt.IsNil)
+	job := doRequest(http.MethodPost, "/import/jobs", body)
+	qt.Assert(t, job.ID, qt.Not(qt.Equals), "")
+
+	// The job fails after the first batch, since puts fail after that, even
+	// when retried.
+	job = waitStopped(job.ID)
+	qt.Assert(t, job.Status, qt.Equals, model.ImportJobFailed)
+	qt.Assert(t, job.Imported, qt.Equals, importBatchSize)
+	qt.Assert(t, job.Error, qt.Contains, "cannot put")
+
+	// Resuming the job imports the rest of the file, without importing the
+	// first batch again.
+	idx.setFailAfter(0)
+	job = doRequest(http.MethodPost, "/import/jobs/"+job.ID+"/resume", nil)
+	qt.Assert(t, job.Status, qt.Equals, model.ImportJobRunning)
+	job = waitStopped(job.ID)
+	qt.Assert(t, job.Status, qt.Equals, model.ImportJobDone)
+	qt.Assert(t, job.Imported, qt.Equals, len(mhs))
+	qt.Assert(t, idx.puts, qt.Equals, len(mhs))
+	for _, mh := range mhs {
+		_, found, err := idx.Get(mh)
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, found, qt.IsTrue)
+	}
+
+	// A job that was running when the indexer stopped resumes from its
+	// stored progress when the jobs are loaded.
+	job.Status = model.ImportJobRunning
+	job.Imported = len(mhs) - 10
+	data, err := json.Marshal(&job)
+	qt.Assert(t, err, qt.IsNil)
+	err = dstore.Put(ctx, importJobDsKey(job.ID), data)
+	qt.Assert(t, err, qt.IsNil)
+	idx.puts = 0
+	h.importJobs, err = newImportJobs(ctx, dstore, idx, nil)
+	qt.Assert(t, err, qt.IsNil)
+	job = waitStopped(job.ID)
+	qt.Assert(t, job.Status, qt.Equals, model.ImportJobDone)
+	qt.Assert(t, job.Imported, qt.Equals, len(mhs))
+	qt.Assert(t, idx.puts, qt.Equals, 10)
+}
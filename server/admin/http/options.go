@@ -3,6 +3,9 @@ package adminserver
 import (
 	"fmt"
 	"time"
+
+	"github.com/filecoin-project/storetheindex/internal/safemode"
+	"github.com/ipfs/go-datastore"
 )
 
 const (
@@ -14,6 +17,13 @@ const (
 type serverConfig struct {
 	apiWriteTimeout time.Duration
 	apiReadTimeout  time.Duration
+	tlsCertFile     string
+	tlsKeyFile      string
+	clientCAFile    string
+	bearerToken     string
+	auditDstore     datastore.Datastore
+	importDstore    datastore.Datastore
+	safeMode        *safemode.Skipper
 }
 
 // ServerOption for httpserver
@@ -52,3 +62,62 @@ func ReadTimeout(t time.Duration) ServerOption {
 		return nil
 	}
 }
+
+// WithTLS serves the admin API over HTTPS using the given PEM-encoded
+// certificate and key files.
+func WithTLS(certFile, keyFile string) ServerOption {
+	return func(c *serverConfig) error {
+		c.tlsCertFile = certFile
+		c.tlsKeyFile = keyFile
+		return nil
+	}
+}
+
+// WithClientCA requires every request to be made with a client certificate
+// signed by one of the CAs in the given PEM-encoded file. This requires
+// WithTLS.
+func WithClientCA(caFile string) ServerOption {
+	return func(c *serverConfig) error {
+		c.clientCAFile = caFile
+		return nil
+	}
+}
+
+// WithBearerToken requires every request to have an Authorization header
+// with the given bearer token.
+func WithBearerToken(token string) ServerOption {
+	return func(c *serverConfig) error {
+		c.bearerToken = token
+		return nil
+	}
+}
+
+// WithAuditDatastore stores the audit log of admin requests that change the
+// indexer's state in the given datastore, so that it can be read with the
+// audit route. Without this, audit entries are only logged.
+func WithAuditDatastore(dstore datastore.Datastore) ServerOption {
+	return func(c *serverConfig) error {
+		c.auditDstore = dstore
+		return nil
+	}
+}
+
+// WithImportJobDatastore stores import jobs and their progress in the given
+// datastore, so that import jobs that were running when the indexer stopped
+// are resumed when it starts again. Without this, import jobs are lost when
+// the indexer stops.
+func WithImportJobDatastore(dstore datastore.Datastore) ServerOption {
+	return func(c *serverConfig) error {
+		c.importDstore = dstore
+		return nil
+	}
+}
+
+// WithSafeMode makes loading import jobs at startup skip jobs that cannot be
+// read or decoded, instead of failing, and record them in skipper.
+func WithSafeMode(skipper *safemode.Skipper) ServerOption {
+	return func(c *serverConfig) error {
+		c.safeMode = skipper
+		return nil
+	}
+}
@@ -0,0 +1,119 @@
+package adminserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	indexer "github.com/filecoin-project/go-indexer-core"
+	"github.com/filecoin-project/storetheindex/api/v0/admin/model"
+	"github.com/filecoin-project/storetheindex/internal/registry"
+)
+
+const (
+	// defaultPrefixScanLimit is the number of multihashes returned by a
+	// prefix scan when the request does not give a limit.
+	defaultPrefixScanLimit = 100
+	// maxPrefixScanLimit is the largest limit a prefix scan request may give.
+	maxPrefixScanLimit = 10000
+)
+
+// scanPrefix finds the multihashes in the value store that begin with
+// prefix, up to limit multihashes, excluding blocked multihashes.
+//
+// The value store interface has no ordered access by key, so this iterates
+// over every multihash in the value store. The time taken is proportional to
+// the number of multihashes indexed, unless the limit is reached first, which
+// is likely only for a short prefix. Iteration stops if ctx is cancelled.
+func scanPrefix(ctx context.Context, idx indexer.Interface, reg *registry.Registry, prefix []byte, limit int) (model.PrefixScan, error) {
+	var result model.PrefixScan
+	iter, err := idx.Iter()
+	if err != nil {
+		return result, fmt.Errorf("cannot iterate value store: %w", err)
+	}
+	for {
+		if err = ctx.Err(); err != nil {
+			return result, err
+		}
+		mh, values, err := iter.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return result, nil
+			}
+			return result, fmt.Errorf("cannot iterate value store: %w", err)
+		}
+		if !bytes.HasPrefix(mh, prefix) || reg.MultihashBlocked(mh) {
+			continue
+		}
+		if len(result.Multihashes) == limit {
+			result.Truncated = true
+			return result, nil
+		}
+		// The iterator may reuse the multihash and values, so copy them.
+		found := model.PrefixMultihash{
+			Multihash: append([]byte(nil), mh...),
+			Values:    make([]model.PrefixValue, len(values)),
+		}
+		for i, value := range values {
+			found.Values[i] = model.PrefixValue{
+				ProviderID: value.ProviderID,
+				ContextID:  value.ContextID,
+			}
+		}
+		result.Multihashes = append(result.Multihashes, found)
+	}
+}
+
+// multihashesWithPrefix responds with the indexed multihashes that begin with
+// the hex-encoded prefix given by the "prefix" query parameter. The number of
+// multihashes returned is limited by the "limit" query parameter.
+func (h *adminHandler) multihashesWithPrefix(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	prefixStr := query.Get("prefix")
+	if prefixStr == "" {
+		http.Error(w, "missing prefix", http.StatusBadRequest)
+		return
+	}
+	prefix, err := hex.DecodeString(prefixStr)
+	if err != nil {
+		msg := "Cannot decode prefix as hex"
+		log.Errorw(msg, "prefix", prefixStr, "err", err)
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	limit := defaultPrefixScanLimit
+	if limitStr := query.Get("limit"); limitStr != "" {
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit < 1 || limit > maxPrefixScanLimit {
+			http.Error(w, fmt.Sprintf("limit must be an integer from 1 to %d", maxPrefixScanLimit), http.StatusBadRequest)
+			return
+		}
+	}
+
+	result, err := scanPrefix(r.Context(), h.indexer, h.reg, prefix, limit)
+	if err != nil {
+		msg := "Cannot scan value store for prefix"
+		log.Errorw(msg, "prefix", prefixStr, "err", err)
+		http.Error(w, msg, http.StatusInternalServerError)
+		return
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		log.Errorw("Cannot marshal prefix scan", "err", err)
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(data)
+	if err != nil {
+		log.Errorw("Cannot write prefix scan response", "err", err)
+	}
+}
@@ -0,0 +1,103 @@
+package adminserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/filecoin-project/go-indexer-core"
+	"github.com/filecoin-project/go-indexer-core/engine"
+	"github.com/filecoin-project/go-indexer-core/store/memory"
+	"github.com/filecoin-project/storetheindex/api/v0/admin/model"
+	"github.com/filecoin-project/storetheindex/config"
+	"github.com/filecoin-project/storetheindex/internal/registry"
+	"github.com/filecoin-project/storetheindex/test/util"
+	qt "github.com/frankban/quicktest"
+	"github.com/gorilla/mux"
+	"github.com/ipfs/go-datastore"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/multiformats/go-multihash"
+)
+
+func Test_MultihashesWithPrefix(t *testing.T) {
+	ctx := context.Background()
+	reg, err := registry.NewRegistry(ctx, config.NewDiscovery(), datastore.NewMapDatastore(), nil)
+	qt.Assert(t, err, qt.IsNil)
+	t.Cleanup(func() { reg.Close() })
+
+	idx := engine.New(nil, memory.New())
+	h := newHandler(ctx, idx, nil, reg, nil)
+	router := mux.NewRouter()
+	router.HandleFunc("/multihashes", h.multihashesWithPrefix).Methods(http.MethodGet)
+
+	provID, err := peer.Decode("12D3KooWKRyzVWW6ChFjQjK4miCty85Niy48tpPV95XdKu1BcvMA")
+	qt.Assert(t, err, qt.IsNil)
+	value := indexer.Value{
+		ProviderID:    provID,
+		ContextID:     []byte("test-context"),
+		MetadataBytes: []byte("test-metadata"),
+	}
+	mhs := util.RandomMultihashes(64, rand.New(rand.NewSource(1413)))
+	qt.Assert(t, idx.Put(value, mhs...), qt.IsNil)
+
+	scan := func(query string) (int, model.PrefixScan) {
+		req, err := http.NewRequest(http.MethodGet, "/multihashes?"+query, nil)
+		qt.Assert(t, err, qt.IsNil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		var result model.PrefixScan
+		if rr.Code == http.StatusOK {
+			qt.Assert(t, json.Unmarshal(rr.Body.Bytes(), &result), qt.IsNil)
+		}
+		return rr.Code, result
+	}
+
+	// Use the first digest byte of a multihash as the prefix, and find the
+	// multihashes expected to share it.
+	prefix := mhs[0][:3]
+	expect := map[string]struct{}{}
+	for _, mh := range mhs {
+		if bytes.HasPrefix(mh, prefix) {
+			expect[string(mh)] = struct{}{}
+		}
+	}
+
+	code, result := scan("prefix=" + hex.EncodeToString(prefix))
+	qt.Assert(t, code, qt.Equals, http.StatusOK)
+	qt.Assert(t, result.Truncated, qt.IsFalse)
+	qt.Assert(t, result.Multihashes, qt.HasLen, len(expect))
+	for _, found := range result.Multihashes {
+		_, ok := expect[string(found.Multihash)]
+		qt.Check(t, ok, qt.IsTrue)
+		qt.Check(t, found.Values, qt.DeepEquals, []model.PrefixValue{{ProviderID: provID, ContextID: value.ContextID}})
+	}
+
+	// Blocked multihashes are not returned.
+	_, err = reg.BlockMultihash(ctx, mhs[0])
+	qt.Assert(t, err, qt.IsNil)
+	_, result = scan("prefix=" + hex.EncodeToString(prefix))
+	qt.Assert(t, result.Multihashes, qt.HasLen, len(expect)-1)
+
+	// All the multihashes are sha2-256, so share the code and length prefix.
+	code, result = scan("prefix=1220&limit=10")
+	qt.Assert(t, code, qt.Equals, http.StatusOK)
+	qt.Assert(t, result.Truncated, qt.IsTrue)
+	qt.Assert(t, result.Multihashes, qt.HasLen, 10)
+	for _, found := range result.Multihashes {
+		dmh, err := multihash.Decode(found.Multihash)
+		qt.Assert(t, err, qt.IsNil)
+		qt.Check(t, dmh.Code, qt.Equals, uint64(multihash.SHA2_256))
+	}
+
+	code, _ = scan("")
+	qt.Assert(t, code, qt.Equals, http.StatusBadRequest)
+	code, _ = scan("prefix=xyz")
+	qt.Assert(t, code, qt.Equals, http.StatusBadRequest)
+	code, _ = scan("prefix=12&limit=0")
+	qt.Assert(t, code, qt.Equals, http.StatusBadRequest)
+}
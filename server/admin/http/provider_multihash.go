@@ -0,0 +1,109 @@
+package adminserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	indexer "github.com/filecoin-project/go-indexer-core"
+	"github.com/filecoin-project/storetheindex/api/v0/admin/model"
+	"github.com/filecoin-project/storetheindex/internal/registry"
+	"github.com/gorilla/mux"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// providerMultihashFlushCount is the number of provider multihashes written
+// between flushes of the response.
+const providerMultihashFlushCount = 1024
+
+// eachProviderMultihash calls each for every multihash indexed for the
+// provider, once for each context ID the multihash is indexed under, except
+// for blocked multihashes. Returns the number of calls to each.
+//
+// The value store interface has no access by provider, so this iterates over
+// every multihash in the value store. The value store is read as it is during
+// iteration, so multihashes indexed or removed while iterating may or may not
+// be included. Iteration stops if ctx is cancelled or each returns an error.
+func eachProviderMultihash(ctx context.Context, idx indexer.Interface, reg *registry.Registry, providerID peer.ID, each func(model.ProviderMultihash) error) (int, error) {
+	iter, err := idx.Iter()
+	if err != nil {
+		return 0, fmt.Errorf("cannot iterate value store: %w", err)
+	}
+	var count int
+	for {
+		if err = ctx.Err(); err != nil {
+			return count, err
+		}
+		mh, values, err := iter.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return count, nil
+			}
+			return count, fmt.Errorf("cannot iterate value store: %w", err)
+		}
+		if reg.MultihashBlocked(mh) {
+			continue
+		}
+		for _, value := range values {
+			if value.ProviderID != providerID {
+				continue
+			}
+			err = each(model.ProviderMultihash{
+				Multihash: mh,
+				ContextID: value.ContextID,
+			})
+			if err != nil {
+				return count, err
+			}
+			count++
+		}
+	}
+}
+
+// providerMultihashes streams the multihashes indexed for the provider as
+// newline-delimited JSON, without holding them all in memory. This scans the
+// whole value store, so it is only served by the admin server.
+func (h *adminHandler) providerMultihashes(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	provID, ok := decodePeerID(vars["providerid"], w)
+	if !ok {
+		return
+	}
+
+	if h.reg.ProviderInfo(provID) == nil {
+		http.Error(w, "provider not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+
+	flusher, _ := w.(http.Flusher)
+	encoder := json.NewEncoder(w)
+
+	var unflushed int
+	count, err := eachProviderMultihash(r.Context(), h.indexer, h.reg, provID, func(pmh model.ProviderMultihash) error {
+		// Encode writes the result followed by a newline.
+		if err := encoder.Encode(&pmh); err != nil {
+			return err
+		}
+		unflushed++
+		if flusher != nil && unflushed == providerMultihashFlushCount {
+			flusher.Flush()
+			unflushed = 0
+		}
+		return nil
+	})
+	if err != nil {
+		// The response status was already sent, so the only thing that can be
+		// done is to stop writing results.
+		log.Errorw("Failed streaming provider multihashes", "err", err, "written", count, "provider", provID)
+		return
+	}
+	if flusher != nil {
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,98 @@
+package adminserver
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/filecoin-project/go-indexer-core"
+	"github.com/filecoin-project/go-indexer-core/engine"
+	"github.com/filecoin-project/go-indexer-core/store/memory"
+	"github.com/filecoin-project/storetheindex/api/v0/admin/model"
+	"github.com/filecoin-project/storetheindex/config"
+	"github.com/filecoin-project/storetheindex/internal/registry"
+	"github.com/filecoin-project/storetheindex/test/util"
+	qt "github.com/frankban/quicktest"
+	"github.com/gorilla/mux"
+	"github.com/ipfs/go-datastore"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/multiformats/go-multiaddr"
+)
+
+func Test_ProviderMultihashes(t *testing.T) {
+	ctx := context.Background()
+	reg, err := registry.NewRegistry(ctx, config.NewDiscovery(), datastore.NewMapDatastore(), nil)
+	qt.Assert(t, err, qt.IsNil)
+	t.Cleanup(func() { reg.Close() })
+
+	idx := engine.New(nil, memory.New())
+	h := newHandler(ctx, idx, nil, reg, nil)
+	router := mux.NewRouter()
+	router.HandleFunc("/providers/{providerid}/cids", h.providerMultihashes).Methods(http.MethodGet)
+
+	provID, err := peer.Decode("12D3KooWKRyzVWW6ChFjQjK4miCty85Niy48tpPV95XdKu1BcvMA")
+	qt.Assert(t, err, qt.IsNil)
+	otherID, err := peer.Decode("12D3KooWLjeDyvuv7rbfG2wWNvWn7ybmmU88PirmSckuqCgXBAph")
+	qt.Assert(t, err, qt.IsNil)
+	addr, err := multiaddr.NewMultiaddr("/ip4/127.0.0.1/tcp/9999")
+	qt.Assert(t, err, qt.IsNil)
+	err = reg.Register(ctx, &registry.ProviderInfo{
+		AddrInfo: peer.AddrInfo{ID: provID, Addrs: []multiaddr.Multiaddr{addr}},
+	})
+	qt.Assert(t, err, qt.IsNil)
+
+	mhs := util.RandomMultihashes(20, rand.New(rand.NewSource(1413)))
+	value := indexer.Value{
+		ProviderID:    provID,
+		ContextID:     []byte("test-context"),
+		MetadataBytes: []byte("test-metadata"),
+	}
+	qt.Assert(t, idx.Put(value, mhs[:10]...), qt.IsNil)
+	// Multihashes indexed for another provider must not be returned.
+	otherValue := indexer.Value{
+		ProviderID:    otherID,
+		ContextID:     []byte("other-context"),
+		MetadataBytes: []byte("test-metadata"),
+	}
+	qt.Assert(t, idx.Put(otherValue, mhs[9:]...), qt.IsNil)
+
+	list := func(provID string) (int, map[string][]byte) {
+		req, err := http.NewRequest(http.MethodGet, "/providers/"+provID+"/cids", nil)
+		qt.Assert(t, err, qt.IsNil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		found := make(map[string][]byte)
+		if rr.Code != http.StatusOK {
+			return rr.Code, found
+		}
+		scanner := bufio.NewScanner(rr.Body)
+		for scanner.Scan() {
+			var pmh model.ProviderMultihash
+			qt.Assert(t, json.Unmarshal(scanner.Bytes(), &pmh), qt.IsNil)
+			found[string(pmh.Multihash)] = pmh.ContextID
+		}
+		return rr.Code, found
+	}
+
+	code, found := list(provID.String())
+	qt.Assert(t, code, qt.Equals, http.StatusOK)
+	qt.Assert(t, found, qt.HasLen, 10)
+	for _, mh := range mhs[:10] {
+		qt.Check(t, found[string(mh)], qt.DeepEquals, value.ContextID)
+	}
+
+	// Blocked multihashes are not returned.
+	_, err = reg.BlockMultihash(ctx, mhs[0])
+	qt.Assert(t, err, qt.IsNil)
+	_, found = list(provID.String())
+	qt.Assert(t, found, qt.HasLen, 9)
+
+	code, _ = list(otherID.String())
+	qt.Assert(t, code, qt.Equals, http.StatusNotFound)
+	code, _ = list("bad-id")
+	qt.Assert(t, code, qt.Equals, http.StatusBadRequest)
+}
@@ -2,8 +2,13 @@ package adminserver
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
 	"net"
 	"net/http"
+	"os"
 
 	indexer "github.com/filecoin-project/go-indexer-core"
 	coremetrics "github.com/filecoin-project/go-indexer-core/metrics"
@@ -18,9 +23,12 @@ import (
 var log = logging.Logger("indexer/admin")
 
 type Server struct {
-	cancel context.CancelFunc
-	l      net.Listener
-	server *http.Server
+	cancel     context.CancelFunc
+	importJobs *importJobs
+	l          net.Listener
+	server     *http.Server
+	certFile   string
+	keyFile    string
 }
 
 func New(listen string, indexer indexer.Interface, ingester *ingest.Ingester, reg *registry.Registry, reloadErrChan chan<- chan error, options ...ServerOption) (*Server, error) {
@@ -33,6 +41,30 @@ func New(listen string, indexer indexer.Interface, ingester *ingest.Ingester, re
 	}
 	var err error
 
+	if (cfg.tlsCertFile == "") != (cfg.tlsKeyFile == "") {
+		return nil, errors.New("both TLS certificate and key files must be given")
+	}
+	var tlsConfig *tls.Config
+	if cfg.clientCAFile != "" {
+		if cfg.tlsCertFile == "" {
+			return nil, errors.New("client certificate authentication requires TLS")
+		}
+		caPEM, err := os.ReadFile(cfg.clientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read client CA file: %w", err)
+		}
+		clientCAs := x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificates found in client CA file %s", cfg.clientCAFile)
+		}
+		// Verify any client certificate given during the handshake, and
+		// reject requests without one with 401 in the auth handler.
+		tlsConfig = &tls.Config{
+			ClientAuth: tls.VerifyClientCertIfGiven,
+			ClientCAs:  clientCAs,
+		}
+	}
+
 	l, err := net.Listen("tcp", listen)
 	if err != nil {
 		return nil, err
@@ -40,35 +72,79 @@ func New(listen string, indexer indexer.Interface, ingester *ingest.Ingester, re
 
 	r := mux.NewRouter().StrictSlash(true)
 	server := &http.Server{
-		Handler:      r,
+		Handler:      newAuthHandler(r, cfg.clientCAFile != "", cfg.bearerToken),
 		WriteTimeout: cfg.apiWriteTimeout,
 		ReadTimeout:  cfg.apiReadTimeout,
+		TLSConfig:    tlsConfig,
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 
 	s := &Server{
-		cancel: cancel,
-		l:      l,
-		server: server,
+		cancel:   cancel,
+		l:        l,
+		server:   server,
+		certFile: cfg.tlsCertFile,
+		keyFile:  cfg.tlsKeyFile,
 	}
 
 	h := newHandler(ctx, indexer, ingester, reg, reloadErrChan)
+	h.importJobs, err = newImportJobs(ctx, cfg.importDstore, indexer, cfg.safeMode)
+	if err != nil {
+		cancel()
+		l.Close()
+		return nil, fmt.Errorf("cannot load import jobs: %w", err)
+	}
+	s.importJobs = h.importJobs
+
+	// Record all requests that may change state in the audit log.
+	audit := newAuditLog(cfg.auditDstore, cfg.bearerToken != "")
+	r.Use(audit.middleware)
 
 	// Set protocol handlers
 	// Import routes
 	r.HandleFunc("/import/manifest/{provider}", h.importManifest).Methods(http.MethodPost)
+	r.HandleFunc("/import/manifestdir/{provider}", h.importManifestDir).Methods(http.MethodPost)
 	r.HandleFunc("/import/cidlist/{provider}", h.importCidList).Methods(http.MethodPost)
+	r.HandleFunc("/import/snapshot/{provider}", h.importSnapshot).Methods(http.MethodPost)
+	r.HandleFunc("/import/jobs", h.startImportJob).Methods(http.MethodPost)
+	r.HandleFunc("/import/jobs", h.listImportJobs).Methods(http.MethodGet)
+	r.HandleFunc("/import/jobs/{id}", h.getImportJob).Methods(http.MethodGet)
+	r.HandleFunc("/import/jobs/{id}/resume", h.resumeImportJob).Methods(http.MethodPost)
+	r.HandleFunc("/import/jobs/{id}/cancel", h.cancelImportJob).Methods(http.MethodPost)
 
 	// Admin routes
 	r.HandleFunc("/healthcheck", h.healthCheckHandler).Methods(http.MethodGet)
 	r.HandleFunc("/importproviders", h.importProviders).Methods(http.MethodPost)
 	r.HandleFunc("/reloadconfig", h.reloadConfig).Methods(http.MethodPost)
+	r.HandleFunc("/flush", h.flush).Methods(http.MethodPost)
+	r.HandleFunc("/audit", audit.listAudit).Methods(http.MethodGet)
 
 	// Ingester routes
 	r.HandleFunc("/ingest/allow/{peer}", h.allowPeer).Methods(http.MethodPut)
 	r.HandleFunc("/ingest/block/{peer}", h.blockPeer).Methods(http.MethodPut)
+	r.HandleFunc("/ingest/failures/retry", h.retryFailures).Methods(http.MethodPost)
 	r.HandleFunc("/ingest/sync/{peer}", h.sync).Methods(http.MethodPost)
+	r.HandleFunc("/ingest/syncstate", h.syncState).Methods(http.MethodGet)
+	r.HandleFunc("/ingest/syncs", h.listSyncs).Methods(http.MethodGet)
+	r.HandleFunc("/ingest/syncs/{id}", h.cancelSync).Methods(http.MethodDelete)
+	r.HandleFunc("/subscribe/batch", h.subscribeBatch).Methods(http.MethodPost)
+	r.HandleFunc("/unsubscribe/batch", h.unsubscribeBatch).Methods(http.MethodPost)
+	r.HandleFunc("/providers/{providerid}/sync", h.clearSync).Methods(http.MethodDelete)
+	r.HandleFunc("/providers/{providerid}/count", h.providerEntryCount).Methods(http.MethodGet)
+	r.HandleFunc("/providers/{providerid}/cids", h.providerMultihashes).Methods(http.MethodGet)
+	r.HandleFunc("/providers/{providerid}/history", h.providerSyncHistory).Methods(http.MethodGet)
+	r.HandleFunc("/providers/{providerid}/contexts/{contextid}/metadata", h.setMetadataOverride).Methods(http.MethodPut)
+	r.HandleFunc("/providers/{providerid}/contexts/{contextid}/metadata", h.clearMetadataOverride).Methods(http.MethodDelete)
+	r.HandleFunc("/providers/{providerid}/aliases/{alias}", h.setAlias).Methods(http.MethodPut)
+	r.HandleFunc("/providers/{providerid}/tags", h.setProviderTags).Methods(http.MethodPut)
+	r.HandleFunc("/providers/{providerid}/tags", h.getProviderTags).Methods(http.MethodGet)
+	r.HandleFunc("/aliases", h.listAliases).Methods(http.MethodGet)
+	r.HandleFunc("/aliases/{alias}", h.removeAlias).Methods(http.MethodDelete)
+	r.HandleFunc("/multihashes", h.multihashesWithPrefix).Methods(http.MethodGet)
+	r.HandleFunc("/blocklist", h.listBlocked).Methods(http.MethodGet)
+	r.HandleFunc("/blocklist/{multihash}", h.blockMultihash).Methods(http.MethodPut)
+	r.HandleFunc("/blocklist/{multihash}", h.unblockMultihash).Methods(http.MethodDelete)
 
 	// Metrics routes
 	r.Handle("/metrics", metrics.Start(coremetrics.DefaultViews))
@@ -82,6 +158,10 @@ func New(listen string, indexer indexer.Interface, ingester *ingest.Ingester, re
 }
 
 func (s *Server) Start() error {
+	if s.certFile != "" {
+		log.Infow("admin https server listening", "listen_addr", s.l.Addr())
+		return s.server.ServeTLS(s.l, s.certFile, s.keyFile)
+	}
 	log.Infow("admin http server listening", "listen_addr", s.l.Addr())
 	return s.server.Serve(s.l)
 }
@@ -89,5 +169,8 @@ func (s *Server) Start() error {
 func (s *Server) Shutdown(ctx context.Context) error {
 	log.Info("admin http server shutdown")
 	s.cancel() // stop any sync in progress
+	// Wait for import jobs to record their progress, so that they resume
+	// from where they stopped.
+	s.importJobs.wait()
 	return s.server.Shutdown(ctx)
 }
@@ -1,9 +1,15 @@
 package handler
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
 
 	"github.com/filecoin-project/go-indexer-core"
 	v0 "github.com/filecoin-project/storetheindex/api/v0"
@@ -12,6 +18,7 @@ import (
 	logging "github.com/ipfs/go-log/v2"
 	"github.com/libp2p/go-libp2p-core/peer"
 	"github.com/multiformats/go-multiaddr"
+	"github.com/multiformats/go-multicodec"
 	"github.com/multiformats/go-multihash"
 )
 
@@ -21,74 +28,127 @@ var log = logging.Logger("indexer/finder")
 // way of estimating the number of entries in the primary value store.
 const avg_mh_size = 40
 
+// findWorkers is the maximum number of value store lookups done concurrently
+// for a single find request.
+const findWorkers = 8
+
 // FinderHandler provides request handling functionality for the finder server
 // that is common to all protocols.
 type FinderHandler struct {
-	indexer  indexer.Interface
-	registry *registry.Registry
+	indexer     indexer.Interface
+	registry    *registry.Registry
+	findWorkers int
 }
 
 func NewFinderHandler(indexer indexer.Interface, registry *registry.Registry) *FinderHandler {
 	return &FinderHandler{
-		indexer:  indexer,
-		registry: registry,
+		indexer:     indexer,
+		registry:    registry,
+		findWorkers: findWorkers,
 	}
 }
 
+// FindOptions modify the provider results returned by a find.
+type FindOptions struct {
+	// WithTimestamps sets the timestamp of each provider result to the time
+	// that the multihash was indexed for the provider's context. If the
+	// indexer does not record index times, with Ingest.IndexTimestamps, or
+	// did not when the multihash was indexed, then it is the time that the
+	// provider's context was last updated.
+	WithTimestamps bool
+	// WithContextAdvertisement sets the ContextLastAdvertisementCid of each
+	// provider result to the most recent advertisement that updated the
+	// provider's context. This is not the advertisement that indexed each
+	// multihash when the context was updated by later advertisements.
+	WithContextAdvertisement bool
+	// Transport, if not zero, only returns provider results whose metadata is
+	// for this transport protocol.
+	Transport multicodec.Code
+	// Codec, if not zero, only returns provider results from providers that
+	// advertised the multihash as a CID with this codec. This requires the
+	// indexer to record codecs, with Ingest.IndexCodecs, when the content is
+	// ingested.
+	Codec multicodec.Code
+	// ExcludeUnreachable omits provider results for providers that were
+	// unreachable when last checked.
+	ExcludeUnreachable bool
+	// UnreachableLast puts provider results for providers that were
+	// unreachable when last checked after all other provider results.
+	UnreachableLast bool
+	// Rank orders the provider results for each multihash by the given
+	// criteria, in order of importance. If UnreachableLast is also set, then
+	// unreachable providers still come after all others.
+	Rank []RankBy
+	// Limit, if greater than zero, is the maximum number of provider results
+	// returned for each multihash. These are the highest ranked results when
+	// Rank is set. Zero returns all provider results.
+	Limit int
+	// FlagDeregistered returns provider results for providers that are no
+	// longer registered, with their status set to model.StatusDeregistered,
+	// after all other provider results. Otherwise, these results are omitted.
+	FlagDeregistered bool
+}
+
 // Find reads from indexer core to populate a response from a list of
-// multihashes.
+// multihashes. Multihashes that are blocked by the indexer operator have no
+// results.
 func (h *FinderHandler) Find(mhashes []multihash.Multihash) (*model.FindResponse, error) {
+	return h.FindWithOptions(context.Background(), mhashes, FindOptions{})
+}
+
+// FindWithTimestamps is the same as Find, but also sets the timestamp of each
+// provider result to the time that the provider's context was last updated.
+func (h *FinderHandler) FindWithTimestamps(mhashes []multihash.Multihash) (*model.FindResponse, error) {
+	return h.FindWithOptions(context.Background(), mhashes, FindOptions{WithTimestamps: true})
+}
+
+// AllBlocked returns true if every one of the multihashes is blocked.
+func (h *FinderHandler) AllBlocked(mhashes []multihash.Multihash) bool {
+	for _, mh := range mhashes {
+		if !h.registry.MultihashBlocked(mh) {
+			return false
+		}
+	}
+	return len(mhashes) != 0
+}
+
+// FindWithOptions is the same as Find, with the provider results modified by
+// the given options. No more value store lookups are started once ctx is
+// done, and the error returned then has status 504 (Gateway Timeout) if the
+// deadline of ctx was exceeded. A lookup that has already started is not
+// interrupted.
+//
+// A multihash whose lookup fails has a result with its error set, instead of
+// failing the whole find, so that the results for the other multihashes are
+// still returned. An error is only returned if every lookup failed.
+func (h *FinderHandler) FindWithOptions(ctx context.Context, mhashes []multihash.Multihash, opts FindOptions) (*model.FindResponse, error) {
 	results := make([]model.MultihashResult, 0, len(mhashes))
 	provAddrs := map[peer.ID][]multiaddr.Multiaddr{}
 
+	allValues, lookupErrs, err := h.getValues(ctx, mhashes)
+	if err != nil {
+		return nil, err
+	}
+
+	var firstErr error
+	var failed int
 	for i := range mhashes {
-		values, found, err := h.indexer.Get(mhashes[i])
-		if err != nil {
-			err = fmt.Errorf("failed to query %q: %s", mhashes[i], err)
-			return nil, v0.NewError(err, http.StatusInternalServerError)
-		}
-		if !found {
-			continue
+		err = lookupErrs[i]
+		var provResults []model.ProviderResult
+		if err == nil {
+			provResults, err = h.providerResults(ctx, mhashes[i], allValues[i], provAddrs, opts)
 		}
-
-		provResults := make([]model.ProviderResult, 0, len(values))
-		for j := range values {
-			provID := values[j].ProviderID
-			// Lookup provider info for each unique provider, look in local map
-			// before going to registry.
-			addrs, ok := provAddrs[provID]
-			if !ok {
-				pinfo := h.registry.ProviderInfo(provID)
-				if pinfo == nil {
-					// If provider not in registry, then provider was deleted.
-					// Tell the indexed core to delete the contextID for the
-					// deleted provider. Delete the contextID from the core,
-					// because there is no way to delete all records for the
-					// provider without a scan of the entire core valuestore.
-					go func(value indexer.Value) {
-						err := h.indexer.RemoveProviderContext(value.ProviderID, value.ContextID)
-						if err != nil {
-							log.Errorw("Error removing provider context", "err", err)
-						}
-					}(values[j])
-					// If provider not in registry, do not return in result.
-					continue
-				}
-				// Omit provider info if it is marked as inactive.
-				if pinfo.Inactive() {
-					continue
-				}
-				addrs = pinfo.AddrInfo.Addrs
-				provAddrs[provID] = addrs
+		if err != nil {
+			if ctxErr := ctxError(ctx); ctxErr != nil {
+				return nil, ctxErr
 			}
-
-			provResult, err := providerResultFromValue(values[j], addrs)
-			if err != nil {
-				return nil, err
+			if firstErr == nil {
+				firstErr = err
 			}
-			provResults = append(provResults, provResult)
+			failed++
+			results = append(results, lookupErrorResult(mhashes[i], err))
+			continue
 		}
-
 		// If there are no providers for this multihash, then do not return a
 		// result for it.
 		if len(provResults) == 0 {
@@ -101,19 +161,311 @@ func (h *FinderHandler) Find(mhashes []multihash.Multihash) (*model.FindResponse
 			ProviderResults: provResults,
 		})
 	}
+	if failed != 0 && failed == len(mhashes) {
+		return nil, firstErr
+	}
 
 	return &model.FindResponse{
 		MultihashResults: results,
 	}, nil
 }
 
-func (h *FinderHandler) ListProviders() ([]byte, error) {
+// lookupErrorResult makes the result for a multihash whose lookup failed. As
+// with errors returned for a whole request, the details of an internal error
+// are logged, and only its status is returned to the client.
+func lookupErrorResult(mh multihash.Multihash, err error) model.MultihashResult {
+	log.Errorw("Failed to look up multihash", "multihash", mh, "err", err)
+	status := http.StatusInternalServerError
+	var apiErr *v0.Error
+	if errors.As(err, &apiErr) && apiErr.Status() != 0 {
+		status = apiErr.Status()
+	}
+	return model.MultihashResult{
+		Multihash: mh,
+		Error:     v0.NewError(nil, status).Error(),
+	}
+}
+
+// FindEach looks up each multihash in turn, and calls found with the result
+// for each multihash that has providers, as soon as that result is available.
+// This allows a response to be sent incrementally instead of all at once. The
+// results are modified by opts, and the lookups stop when ctx is done, as
+// with FindWithOptions. A multihash whose lookup fails is passed to found as a
+// result with its error set. Returns the number of results passed to found.
+func (h *FinderHandler) FindEach(ctx context.Context, mhashes []multihash.Multihash, opts FindOptions, found func(model.MultihashResult) error) (int, error) {
+	provAddrs := map[peer.ID][]multiaddr.Multiaddr{}
+	var count int
+
+	for i := range mhashes {
+		if err := ctxError(ctx); err != nil {
+			return count, err
+		}
+		if h.registry.MultihashBlocked(mhashes[i]) {
+			continue
+		}
+		var result model.MultihashResult
+		provResults, err := h.findOne(ctx, mhashes[i], provAddrs, opts)
+		if err != nil {
+			if ctxErr := ctxError(ctx); ctxErr != nil {
+				return count, ctxErr
+			}
+			result = lookupErrorResult(mhashes[i], err)
+		} else if len(provResults) != 0 {
+			result = model.MultihashResult{
+				Multihash:       mhashes[i],
+				ProviderResults: provResults,
+			}
+		} else {
+			continue
+		}
+
+		if err = found(result); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// findOne looks up the multihash in the value store and makes its provider
+// results.
+func (h *FinderHandler) findOne(ctx context.Context, mh multihash.Multihash, provAddrs map[peer.ID][]multiaddr.Multiaddr, opts FindOptions) ([]model.ProviderResult, error) {
+	values, _, err := h.indexer.Get(mh)
+	if err != nil {
+		err = fmt.Errorf("failed to query %q: %s", mh, err)
+		return nil, v0.NewError(err, http.StatusInternalServerError)
+	}
+	return h.providerResults(ctx, mh, values, provAddrs, opts)
+}
+
+// ctxError returns an error if ctx is done. The error has status 504 (Gateway
+// Timeout) if the deadline of ctx was exceeded.
+func ctxError(ctx context.Context) error {
+	err := ctx.Err()
+	if err == nil {
+		return nil
+	}
+	if err == context.DeadlineExceeded {
+		return v0.NewError(errors.New("find request timed out"), http.StatusGatewayTimeout)
+	}
+	return v0.NewError(err, http.StatusServiceUnavailable)
+}
+
+// providerResults makes a provider result for each value whose provider is
+// registered and active, and for each value whose provider is deregistered if
+// opts.FlagDeregistered is set. The provAddrs map caches provider addresses
+// already looked up in the registry. The results are modified, ranked, and
+// limited by opts.
+func (h *FinderHandler) providerResults(ctx context.Context, mh multihash.Multihash, values []indexer.Value, provAddrs map[peer.ID][]multiaddr.Multiaddr, opts FindOptions) ([]model.ProviderResult, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+
+	hasOverrides := h.registry.HasMetadataOverrides()
+	checkReach := opts.ExcludeUnreachable || opts.UnreachableLast
+	provResults := make([]model.ProviderResult, 0, len(values))
+	var unreachable, deregistered []model.ProviderResult
+	for j := range values {
+		provID := values[j].ProviderID
+		metadata := values[j].MetadataBytes
+		var ctxInfo *registry.ContextInfo
+		var override bool
+		if hasOverrides || opts.WithTimestamps || opts.WithContextAdvertisement {
+			ctxInfo = h.registry.ProviderContext(provID, values[j].ContextID)
+			if ctxInfo != nil && ctxInfo.MetadataOverride != nil {
+				metadata, override = ctxInfo.MetadataOverride, true
+			}
+		}
+		if opts.Transport != 0 {
+			// Use the registry's index of context transports, which accounts
+			// for metadata overrides, so that the metadata does not need to
+			// be decoded. Decode the metadata of contexts not in the index.
+			transport, ok := h.registry.ContextTransport(provID, values[j].ContextID)
+			if !ok {
+				transport, _ = v0.MetadataTransport(metadata)
+			}
+			if transport != opts.Transport {
+				continue
+			}
+		}
+		if opts.Codec != 0 {
+			codec, ok, err := h.registry.Codec(ctx, provID, mh)
+			if err != nil {
+				err = fmt.Errorf("failed to get codec of %q: %s", mh, err)
+				return nil, v0.NewError(err, http.StatusInternalServerError)
+			}
+			if !ok || multicodec.Code(codec) != opts.Codec {
+				continue
+			}
+		}
+		// Lookup provider info for each unique provider, look in local map
+		// before going to registry.
+		addrs, ok := provAddrs[provID]
+		if !ok {
+			pinfo := h.registry.ProviderInfo(provID)
+			if pinfo == nil {
+				// If provider not in registry, then provider was deleted.
+				// Tell the indexed core to delete the contextID for the
+				// deleted provider. Delete the contextID from the core,
+				// because there is no way to delete all records for the
+				// provider without a scan of the entire core valuestore.
+				go func(value indexer.Value) {
+					err := h.indexer.RemoveProviderContext(value.ProviderID, value.ContextID)
+					if err != nil {
+						log.Errorw("Error removing provider context", "err", err)
+					}
+				}(values[j])
+				// If provider not in registry, only return it in the result
+				// if asked to, flagged as deregistered.
+				if opts.FlagDeregistered {
+					provResult, err := providerResultFromValue(values[j], nil)
+					if err != nil {
+						return nil, err
+					}
+					provResult.Status = model.StatusDeregistered
+					deregistered = append(deregistered, provResult)
+				}
+				continue
+			}
+			// Omit provider info if it is marked as inactive.
+			if pinfo.Inactive() {
+				continue
+			}
+			addrs = pinfo.AddrInfo.Addrs
+			provAddrs[provID] = addrs
+		}
+
+		provResult, err := providerResultFromValue(values[j], addrs)
+		if err != nil {
+			return nil, err
+		}
+		if override {
+			provResult.Metadata = metadata
+			provResult.MetadataOverride = true
+		}
+		if opts.WithTimestamps {
+			indexTime, ok, err := h.registry.IndexTime(ctx, provID, values[j].ContextID, mh)
+			if err != nil {
+				err = fmt.Errorf("failed to get index time of %q: %s", mh, err)
+				return nil, v0.NewError(err, http.StatusInternalServerError)
+			}
+			if ok {
+				provResult.SetTimestamp(indexTime)
+			} else if ctxInfo != nil {
+				provResult.SetTimestamp(ctxInfo.LastAdvertisementTime)
+			}
+		}
+		if opts.WithContextAdvertisement && ctxInfo != nil && ctxInfo.LastAdvertisement.Defined() {
+			provResult.ContextLastAdvertisementCid = ctxInfo.LastAdvertisement.String()
+		}
+		if checkReach && h.registry.Reachability(provID) == registry.Unreachable {
+			if !opts.ExcludeUnreachable {
+				unreachable = append(unreachable, provResult)
+			}
+			continue
+		}
+		provResults = append(provResults, provResult)
+	}
+	h.rankResults(provResults, opts.Rank)
+	h.rankResults(unreachable, opts.Rank)
+	provResults = append(provResults, unreachable...)
+	provResults = append(provResults, deregistered...)
+	if opts.Limit > 0 && len(provResults) > opts.Limit {
+		provResults = provResults[:opts.Limit]
+	}
+	return provResults, nil
+}
+
+// getValues looks up the values for each multihash in the value store. The
+// lookups are done concurrently by up to findWorkers goroutines, and the
+// values for each multihash are returned at the same index as the multihash.
+// A failed lookup does not stop the others, and its error is returned at the
+// same index as the multihash. An error is returned only if ctx is done.
+func (h *FinderHandler) getValues(ctx context.Context, mhashes []multihash.Multihash) ([][]indexer.Value, []error, error) {
+	allValues := make([][]indexer.Value, len(mhashes))
+	lookupErrs := make([]error, len(mhashes))
+
+	getValue := func(i int) error {
+		// The value store API does not take a context, so the context is
+		// checked before each lookup.
+		if err := ctxError(ctx); err != nil {
+			return err
+		}
+		// Blocked multihashes are not looked up, so that there are no
+		// results for them.
+		if h.registry.MultihashBlocked(mhashes[i]) {
+			return nil
+		}
+		values, found, err := h.indexer.Get(mhashes[i])
+		if err != nil {
+			err = fmt.Errorf("failed to query %q: %s", mhashes[i], err)
+			lookupErrs[i] = v0.NewError(err, http.StatusInternalServerError)
+			return nil
+		}
+		if found {
+			allValues[i] = values
+		}
+		return nil
+	}
+
+	workers := h.findWorkers
+	if workers > len(mhashes) {
+		workers = len(mhashes)
+	}
+	if workers < 2 {
+		for i := range mhashes {
+			if err := getValue(i); err != nil {
+				return nil, nil, err
+			}
+		}
+		return allValues, lookupErrs, nil
+	}
+
+	var (
+		next     int64 = -1
+		errOnce  sync.Once
+		firstErr error
+		failed   int32
+		wg       sync.WaitGroup
+	)
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for atomic.LoadInt32(&failed) == 0 {
+				i := int(atomic.AddInt64(&next, 1))
+				if i >= len(mhashes) {
+					return
+				}
+				if err := getValue(i); err != nil {
+					errOnce.Do(func() {
+						firstErr = err
+						atomic.StoreInt32(&failed, 1)
+					})
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, nil, firstErr
+	}
+	return allValues, lookupErrs, nil
+}
+
+// ListProviders returns all registered providers that have all of the given
+// tags.
+func (h *FinderHandler) ListProviders(tags ...string) ([]byte, error) {
 	infos := h.registry.AllProviderInfo()
 
-	responses := make([]model.ProviderInfo, len(infos))
-	for i := range infos {
-		responses[i] = model.MakeProviderInfo(infos[i].AddrInfo, infos[i].LastAdvertisement,
-			infos[i].LastAdvertisementTime, infos[i].Publisher, infos[i].PublisherAddr)
+	responses := make([]model.ProviderInfo, 0, len(infos))
+	for _, info := range infos {
+		if !info.HasTags(tags...) {
+			continue
+		}
+		responses = append(responses, h.makeProviderInfo(info))
 	}
 
 	return json.Marshal(responses)
@@ -125,11 +477,47 @@ func (h *FinderHandler) GetProvider(providerID peer.ID) ([]byte, error) {
 		return nil, nil
 	}
 
-	rsp := model.MakeProviderInfo(info.AddrInfo, info.LastAdvertisement, info.LastAdvertisementTime, info.Publisher, info.PublisherAddr)
+	rsp := h.makeProviderInfo(info)
 
 	return json.Marshal(&rsp)
 }
 
+// makeProviderInfo makes the provider information for a response, including
+// when the provider's publisher was last seen.
+func (h *FinderHandler) makeProviderInfo(info *registry.ProviderInfo) model.ProviderInfo {
+	rsp := model.MakeProviderInfo(info.AddrInfo, info.LastAdvertisement, info.LastAdvertisementTime, info.Publisher, info.PublisherAddr)
+	publisher := info.Publisher
+	if publisher.Validate() != nil {
+		publisher = info.AddrInfo.ID
+	}
+	rsp.SetLastSeen(h.registry.LastSeen(publisher))
+	rsp.AutoRegistered = info.AutoRegistered
+	rsp.Tags = info.Tags
+	return rsp
+}
+
+// GetProviderContexts returns the contexts that the provider has advertised.
+// Returns nil if the provider is not registered.
+func (h *FinderHandler) GetProviderContexts(providerID peer.ID) ([]byte, error) {
+	info := h.registry.ProviderInfo(providerID)
+	if info == nil {
+		return nil, nil
+	}
+
+	infos := h.registry.ProviderContexts(providerID)
+	sort.Slice(infos, func(i, j int) bool {
+		return bytes.Compare(infos[i].ContextID, infos[j].ContextID) < 0
+	})
+
+	responses := make([]model.ProviderContext, len(infos))
+	for i := range infos {
+		responses[i] = model.MakeProviderContext(infos[i].ContextID, infos[i].Metadata,
+			infos[i].EntryCount, infos[i].LastAdvertisement, infos[i].LastAdvertisementTime, infos[i].Transport)
+	}
+
+	return json.Marshal(responses)
+}
+
 func (h *FinderHandler) GetStats() ([]byte, error) {
 	size, err := h.indexer.Size()
 	if err != nil {
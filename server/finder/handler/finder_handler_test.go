@@ -0,0 +1,537 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/go-indexer-core"
+	"github.com/filecoin-project/go-indexer-core/engine"
+	"github.com/filecoin-project/go-indexer-core/store/storethehash"
+	v0 "github.com/filecoin-project/storetheindex/api/v0"
+	"github.com/filecoin-project/storetheindex/api/v0/finder/model"
+	"github.com/filecoin-project/storetheindex/config"
+	"github.com/filecoin-project/storetheindex/internal/registry"
+	"github.com/filecoin-project/storetheindex/test/util"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
+)
+
+const providerID = "12D3KooWKRyzVWW6ChFjQjK4miCty85Niy48tpPV95XdKu1BcvMA"
+
+func initHandler(tb testing.TB, mhCount int) (*FinderHandler, []multihash.Multihash) {
+	valueStore, err := storethehash.New(context.Background(), tb.TempDir())
+	if err != nil {
+		tb.Fatal(err)
+	}
+	ind := engine.New(nil, valueStore)
+	tb.Cleanup(func() { ind.Close() })
+
+	reg, err := registry.NewRegistry(context.Background(), config.Discovery{
+		Policy: config.Policy{
+			Allow: true,
+		},
+		PollInterval:   config.Duration(time.Minute),
+		RediscoverWait: config.Duration(time.Minute),
+	}, datastore.NewMapDatastore(), nil)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { reg.Close() })
+
+	peerID, err := peer.Decode(providerID)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	maddr, err := multiaddr.NewMultiaddr("/ip4/127.0.0.1/tcp/9999")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	err = reg.Register(context.Background(), &registry.ProviderInfo{
+		AddrInfo: peer.AddrInfo{
+			ID:    peerID,
+			Addrs: []multiaddr.Multiaddr{maddr},
+		},
+	})
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	rng := rand.New(rand.NewSource(1413))
+	mhs := util.RandomMultihashes(mhCount, rng)
+	// Index every other multihash, so that some lookups find nothing.
+	for i := 0; i < len(mhs); i += 2 {
+		value := indexer.Value{
+			ProviderID:    peerID,
+			ContextID:     []byte(mhs[i]),
+			MetadataBytes: []byte("test-metadata"),
+		}
+		if err = ind.Put(value, mhs[i]); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	if err = ind.Flush(); err != nil {
+		tb.Fatal(err)
+	}
+
+	return NewFinderHandler(ind, reg), mhs
+}
+
+func TestFindPreservesOrder(t *testing.T) {
+	h, mhs := initHandler(t, 100)
+
+	rsp, err := h.Find(mhs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rsp.MultihashResults) != len(mhs)/2 {
+		t.Fatalf("expected %d results, got %d", len(mhs)/2, len(rsp.MultihashResults))
+	}
+	for i, result := range rsp.MultihashResults {
+		if string(result.Multihash) != string(mhs[2*i]) {
+			t.Fatal("results out of order")
+		}
+		if len(result.ProviderResults) != 1 || string(result.ProviderResults[0].ContextID) != string(mhs[2*i]) {
+			t.Fatal("wrong provider result for multihash")
+		}
+	}
+}
+
+func TestFindMetadataOverride(t *testing.T) {
+	h, mhs := initHandler(t, 2)
+	peerID, err := peer.Decode(providerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctxID := []byte(mhs[0])
+	ctx := context.Background()
+	err = h.registry.UpdateProviderContext(ctx, peerID, ctxID, []byte("test-metadata"), 1, cid.Undef)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rsp, err := h.Find(mhs[:1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := rsp.MultihashResults[0].ProviderResults[0]
+	if string(result.Metadata) != "test-metadata" || result.MetadataOverride {
+		t.Fatal("expected advertised metadata")
+	}
+
+	err = h.registry.SetMetadataOverride(ctx, peerID, ctxID, []byte("fixed-metadata"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsp, err = h.Find(mhs[:1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	result = rsp.MultihashResults[0].ProviderResults[0]
+	if string(result.Metadata) != "fixed-metadata" || !result.MetadataOverride {
+		t.Fatal("expected override metadata")
+	}
+}
+
+func TestFindRankAndLimit(t *testing.T) {
+	h, mhs := initHandler(t, 2)
+	ctx := context.Background()
+
+	// Index mhs[0] for two more providers, so there are three provider
+	// results for it.
+	provIDs := make([]peer.ID, 3)
+	var err error
+	provIDs[0], err = peer.Decode(providerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	maddr, err := multiaddr.NewMultiaddr("/ip4/127.0.0.1/tcp/9999")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i < len(provIDs); i++ {
+		priv, _, err := crypto.GenerateEd25519Key(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		provIDs[i], err = peer.IDFromPrivateKey(priv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = h.registry.Register(ctx, &registry.ProviderInfo{
+			AddrInfo: peer.AddrInfo{ID: provIDs[i], Addrs: []multiaddr.Multiaddr{maddr}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		value := indexer.Value{ProviderID: provIDs[i], ContextID: []byte("ctx"), MetadataBytes: []byte("test-metadata")}
+		if err = h.indexer.Put(value, mhs[0]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// provIDs[1] advertised most recently, provIDs[0] before that, and
+	// provIDs[2] has never advertised. Only provIDs[2] is trusted.
+	err = h.registry.UpdateProviderContext(ctx, provIDs[0], []byte(mhs[0]), []byte("test-metadata"), 1, cid.Undef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	err = h.registry.UpdateProviderContext(ctx, provIDs[1], []byte("ctx"), []byte("test-metadata"), 1, cid.Undef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = h.registry.SetPolicy(config.Policy{Allow: true, Trusted: []string{provIDs[2].String()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	findProviders := func(opts FindOptions) []peer.ID {
+		rsp, err := h.FindWithOptions(context.Background(), mhs[:1], opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []peer.ID
+		for _, pr := range rsp.MultihashResults[0].ProviderResults {
+			ids = append(ids, pr.Provider.ID)
+		}
+		return ids
+	}
+	requireProviders := func(opts FindOptions, expect ...peer.ID) {
+		ids := findProviders(opts)
+		if len(ids) != len(expect) {
+			t.Fatalf("expected %d provider results, got %d", len(expect), len(ids))
+		}
+		for i := range expect {
+			if ids[i] != expect[i] {
+				t.Fatalf("expected provider %s at position %d, got %s", expect[i], i, ids[i])
+			}
+		}
+	}
+
+	if len(findProviders(FindOptions{})) != 3 {
+		t.Fatal("expected all provider results without a limit")
+	}
+	requireProviders(FindOptions{Rank: []RankBy{RankRecency}}, provIDs[1], provIDs[0], provIDs[2])
+	requireProviders(FindOptions{Rank: []RankBy{RankTrust, RankRecency}}, provIDs[2], provIDs[1], provIDs[0])
+	requireProviders(FindOptions{Rank: []RankBy{RankRecency}, Limit: 2}, provIDs[1], provIDs[0])
+	if len(findProviders(FindOptions{Limit: 1})) != 1 {
+		t.Fatal("expected limit to apply without ranking")
+	}
+
+	rank, err := ParseRank("trust, recency")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rank) != 2 || rank[0] != RankTrust || rank[1] != RankRecency {
+		t.Fatal("wrong parsed rank")
+	}
+	if _, err = ParseRank("trust,bogus"); err == nil {
+		t.Fatal("expected error for unknown rank")
+	}
+}
+
+func TestFindFlagDeregistered(t *testing.T) {
+	h, mhs := initHandler(t, 1)
+
+	provID, err := peer.Decode(providerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = h.registry.RemoveProvider(context.Background(), provID); err != nil {
+		t.Fatal(err)
+	}
+
+	// Removing the deregistered provider's values from the value store is
+	// done asynchronously by the find, so only the first find is checked.
+	rsp, err := h.FindWithOptions(context.Background(), mhs, FindOptions{FlagDeregistered: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rsp.MultihashResults) != 1 {
+		t.Fatalf("expected 1 multihash result, got %d", len(rsp.MultihashResults))
+	}
+	provResults := rsp.MultihashResults[0].ProviderResults
+	if len(provResults) != 1 {
+		t.Fatalf("expected 1 provider result, got %d", len(provResults))
+	}
+	if provResults[0].Provider.ID != provID {
+		t.Fatal("wrong provider in result")
+	}
+	if provResults[0].Status != model.StatusDeregistered {
+		t.Fatalf("expected status %q, got %q", model.StatusDeregistered, provResults[0].Status)
+	}
+	if len(provResults[0].Provider.Addrs) != 0 {
+		t.Fatal("expected no addresses for deregistered provider")
+	}
+}
+
+func TestFindOmitDeregistered(t *testing.T) {
+	h, mhs := initHandler(t, 1)
+
+	provID, err := peer.Decode(providerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = h.registry.RemoveProvider(context.Background(), provID); err != nil {
+		t.Fatal(err)
+	}
+
+	rsp, err := h.Find(mhs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rsp.MultihashResults) != 0 {
+		t.Fatal("expected no results for deregistered provider")
+	}
+}
+
+func TestFindTimeout(t *testing.T) {
+	h, mhs := initHandler(t, 10)
+
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+
+	for _, workers := range []int{1, findWorkers} {
+		h.findWorkers = workers
+		_, err := h.FindWithOptions(ctx, mhs, FindOptions{})
+		var apiErr *v0.Error
+		if !errors.As(err, &apiErr) {
+			t.Fatalf("expected api error, got %v", err)
+		}
+		if apiErr.Status() != http.StatusGatewayTimeout {
+			t.Fatalf("expected status %d, got %d", http.StatusGatewayTimeout, apiErr.Status())
+		}
+	}
+
+	count, err := h.FindEach(ctx, mhs, FindOptions{}, func(model.MultihashResult) error { return nil })
+	if err == nil || count != 0 {
+		t.Fatalf("expected timeout with no results, got %d results and error %v", count, err)
+	}
+}
+
+// failingIndexer is an indexer whose lookups of some multihashes fail.
+type failingIndexer struct {
+	indexer.Interface
+	fail map[string]struct{}
+}
+
+func (f *failingIndexer) Get(mh multihash.Multihash) ([]indexer.Value, bool, error) {
+	if _, ok := f.fail[string(mh)]; ok {
+		return nil, false, errors.New("store failure")
+	}
+	return f.Interface.Get(mh)
+}
+
+func TestFindLookupErrors(t *testing.T) {
+	h, mhs := initHandler(t, 10)
+	failing := &failingIndexer{
+		Interface: h.indexer,
+		fail:      map[string]struct{}{string(mhs[2]): {}},
+	}
+	h.indexer = failing
+
+	for _, workers := range []int{1, findWorkers} {
+		h.findWorkers = workers
+		rsp, err := h.FindWithOptions(context.Background(), mhs, FindOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rsp.MultihashResults) != len(mhs)/2 {
+			t.Fatalf("expected %d results, got %d", len(mhs)/2, len(rsp.MultihashResults))
+		}
+		for i, result := range rsp.MultihashResults {
+			if string(result.Multihash) != string(mhs[2*i]) {
+				t.Fatal("results out of order")
+			}
+			if i == 1 {
+				if result.Error == "" || len(result.ProviderResults) != 0 {
+					t.Fatal("expected error result for failed lookup")
+				}
+				continue
+			}
+			if result.Error != "" || len(result.ProviderResults) != 1 {
+				t.Fatal("expected provider result for successful lookup")
+			}
+		}
+	}
+
+	var results []model.MultihashResult
+	_, err := h.FindEach(context.Background(), mhs, FindOptions{}, func(result model.MultihashResult) error {
+		results = append(results, result)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != len(mhs)/2 || results[1].Error == "" {
+		t.Fatal("expected error result for failed lookup")
+	}
+
+	// If every lookup fails, then the find fails.
+	_, err = h.Find(mhs[2:3])
+	var apiErr *v0.Error
+	if !errors.As(err, &apiErr) || apiErr.Status() != http.StatusInternalServerError {
+		t.Fatalf("expected internal server error, got %v", err)
+	}
+}
+
+func TestFindCodec(t *testing.T) {
+	h, mhs := initHandler(t, 6)
+	ctx := context.Background()
+
+	peerID, err := peer.Decode(providerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The multihashes at even indexes are indexed. Record codecs for two of
+	// them, and leave the third without a codec.
+	err = h.registry.PutCodecs(ctx, peerID, []multihash.Multihash{mhs[0], mhs[2]}, []uint64{cid.DagProtobuf, cid.Raw})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rsp, err := h.FindWithOptions(ctx, mhs, FindOptions{Codec: multicodec.DagPb})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rsp.MultihashResults) != 1 || string(rsp.MultihashResults[0].Multihash) != string(mhs[0]) {
+		t.Fatalf("expected only dag-pb result, got %d results", len(rsp.MultihashResults))
+	}
+
+	rsp, err = h.FindWithOptions(ctx, mhs, FindOptions{Codec: multicodec.Raw})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rsp.MultihashResults) != 1 || string(rsp.MultihashResults[0].Multihash) != string(mhs[2]) {
+		t.Fatalf("expected only raw result, got %d results", len(rsp.MultihashResults))
+	}
+
+	// Without a codec, all indexed multihashes are found.
+	rsp, err = h.FindWithOptions(ctx, mhs, FindOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rsp.MultihashResults) != 3 {
+		t.Fatalf("expected 3 results, got %d", len(rsp.MultihashResults))
+	}
+}
+
+func TestFindTimestamps(t *testing.T) {
+	h, mhs := initHandler(t, 4)
+	ctx := context.Background()
+
+	peerID, err := peer.Decode(providerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The multihashes at even indexes are indexed, each in its own context.
+	for _, i := range []int{0, 2} {
+		err = h.registry.UpdateProviderContext(ctx, peerID, []byte(mhs[i]), []byte("test-metadata"), 1, cid.Undef)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Record an index time for only the first multihash.
+	indexTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err = h.registry.PutIndexTimes(ctx, peerID, []byte(mhs[0]), mhs[:1], indexTime); err != nil {
+		t.Fatal(err)
+	}
+	var expect model.ProviderResult
+	expect.SetTimestamp(time.Unix(0, indexTime.UnixNano()))
+
+	rsp, err := h.FindWithOptions(ctx, mhs, FindOptions{WithTimestamps: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rsp.MultihashResults) != 2 {
+		t.Fatalf("expected 2 results, got %d", len(rsp.MultihashResults))
+	}
+	if ts := rsp.MultihashResults[0].ProviderResults[0].Timestamp; ts != expect.Timestamp {
+		t.Fatalf("expected index time %s, got %s", expect.Timestamp, ts)
+	}
+	// Without a recorded index time, the context update time is returned.
+	if ts := rsp.MultihashResults[1].ProviderResults[0].Timestamp; ts == "" || ts == expect.Timestamp {
+		t.Fatalf("expected context update time, got %q", ts)
+	}
+
+	rsp, err = h.FindWithOptions(ctx, mhs, FindOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rsp.MultihashResults[0].ProviderResults[0].Timestamp != "" {
+		t.Fatal("timestamp returned when not requested")
+	}
+}
+
+func BenchmarkFindBatch(b *testing.B) {
+	h, mhs := initHandler(b, 1000)
+
+	b.Run("sequential", func(b *testing.B) {
+		h.findWorkers = 1
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if _, err := h.Find(mhs); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("parallel", func(b *testing.B) {
+		h.findWorkers = findWorkers
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if _, err := h.Find(mhs); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestListProvidersWithTags(t *testing.T) {
+	h, _ := initHandler(t, 0)
+
+	provID, err := peer.Decode(providerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = h.registry.SetProviderTags(context.Background(), provID, []string{"region-eu", "operator-a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	list := func(tags ...string) []model.ProviderInfo {
+		data, err := h.ListProviders(tags...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var infos []model.ProviderInfo
+		if err = json.Unmarshal(data, &infos); err != nil {
+			t.Fatal(err)
+		}
+		return infos
+	}
+
+	infos := list()
+	if len(infos) != 1 {
+		t.Fatalf("expected 1 provider, got %d", len(infos))
+	}
+	if !reflect.DeepEqual(infos[0].Tags, []string{"operator-a", "region-eu"}) {
+		t.Fatalf("wrong tags in provider info: %v", infos[0].Tags)
+	}
+	if infos = list("region-eu", "operator-a"); len(infos) != 1 {
+		t.Fatalf("expected 1 provider with tags, got %d", len(infos))
+	}
+	if infos = list("region-eu", "region-us"); len(infos) != 0 {
+		t.Fatalf("expected no providers with tags, got %d", len(infos))
+	}
+}
@@ -0,0 +1,135 @@
+package handler
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/filecoin-project/storetheindex/api/v0/finder/model"
+	"github.com/filecoin-project/storetheindex/internal/registry"
+)
+
+// RankBy is a criterion used to order the provider results for a multihash.
+type RankBy int
+
+const (
+	// RankRecency puts providers whose advertisement for the result's context
+	// was received more recently first.
+	RankRecency RankBy = iota + 1
+	// RankReachability puts providers that were reachable when last checked
+	// first, and providers that were unreachable last.
+	RankReachability
+	// RankTrust puts providers that are trusted by policy first.
+	RankTrust
+)
+
+// ParseRank parses a comma-separated list of ranking criteria, such as
+// "trust,recency". Results are ordered by the first criterion, and then by
+// each following criterion where the earlier ones are equal.
+func ParseRank(s string) ([]RankBy, error) {
+	var rank []RankBy
+	for _, name := range strings.Split(s, ",") {
+		var r RankBy
+		switch strings.TrimSpace(name) {
+		case "recency":
+			r = RankRecency
+		case "reachability":
+			r = RankReachability
+		case "trust":
+			r = RankTrust
+		default:
+			return nil, fmt.Errorf("unknown rank %q, must be one of \"recency\", \"reachability\", \"trust\"", name)
+		}
+		rank = append(rank, r)
+	}
+	return rank, nil
+}
+
+// rankKey holds the values that provider results are ranked by. Lower values
+// rank first.
+type rankKey struct {
+	reach   int
+	trust   int
+	lastAge time.Duration
+}
+
+// rankResults sorts the provider results according to the ranking criteria.
+// Results that rank the same keep their original order.
+func (h *FinderHandler) rankResults(results []model.ProviderResult, rank []RankBy) {
+	if len(results) < 2 || len(rank) == 0 {
+		return
+	}
+
+	now := time.Now()
+	keys := make([]rankKey, len(results))
+	for i := range results {
+		provID := results[i].Provider.ID
+		var key rankKey
+		for _, r := range rank {
+			switch r {
+			case RankRecency:
+				var lastAd time.Time
+				if ctxInfo := h.registry.ProviderContext(provID, results[i].ContextID); ctxInfo != nil {
+					lastAd = ctxInfo.LastAdvertisementTime
+				} else if pinfo := h.registry.ProviderInfo(provID); pinfo != nil {
+					lastAd = pinfo.LastAdvertisementTime
+				}
+				// Providers with no known advertisement time rank last.
+				if lastAd.IsZero() {
+					key.lastAge = math.MaxInt64
+				} else {
+					key.lastAge = now.Sub(lastAd)
+				}
+			case RankReachability:
+				switch h.registry.Reachability(provID) {
+				case registry.Reachable:
+					key.reach = 0
+				case registry.Unreachable:
+					key.reach = 2
+				default:
+					key.reach = 1
+				}
+			case RankTrust:
+				if !h.registry.Trusted(provID) {
+					key.trust = 1
+				}
+			}
+		}
+		keys[i] = key
+	}
+
+	// Sort indexes, since results cannot be swapped while their keys are
+	// looked up by index.
+	order := make([]int, len(results))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		ka, kb := keys[order[a]], keys[order[b]]
+		for _, r := range rank {
+			switch r {
+			case RankRecency:
+				if ka.lastAge != kb.lastAge {
+					return ka.lastAge < kb.lastAge
+				}
+			case RankReachability:
+				if ka.reach != kb.reach {
+					return ka.reach < kb.reach
+				}
+			case RankTrust:
+				if ka.trust != kb.trust {
+					return ka.trust < kb.trust
+				}
+			}
+		}
+		return false
+	})
+
+	sorted := make([]model.ProviderResult, len(results))
+	for i, j := range order {
+		sorted[i] = results[j]
+	}
+	copy(results, sorted)
+}
@@ -0,0 +1,171 @@
+package httpfinderserver
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var gzipWriterPool = sync.Pool{
+	New: func() interface{} {
+		return gzip.NewWriter(nil)
+	},
+}
+
+// gzipHandler compresses responses with gzip when the client accepts gzip
+// encoding. Responses smaller than minSize are sent uncompressed, since
+// compressing them costs more than it saves.
+func gzipHandler(minSize int, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if r.Method == http.MethodHead || !acceptsGzip(r.Header.Get("Accept-Encoding")) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		gw := &gzipResponseWriter{
+			ResponseWriter: w,
+			minSize:        minSize,
+			status:         http.StatusOK,
+		}
+		defer gw.close()
+		next.ServeHTTP(gw, r)
+	})
+}
+
+// acceptsGzip returns true if the Accept-Encoding header value allows gzip.
+func acceptsGzip(acceptEncoding string) bool {
+	for _, enc := range strings.Split(acceptEncoding, ",") {
+		parts := strings.Split(enc, ";")
+		if strings.TrimSpace(parts[0]) != "gzip" {
+			continue
+		}
+		// Check for an explicit "q=0", which means gzip is not acceptable.
+		for _, param := range parts[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				q, err := strconv.ParseFloat(param[2:], 64)
+				if err == nil && q == 0 {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// gzipResponseWriter holds back the start of a response until it is known
+// whether the response is large enough to compress. Once minSize bytes are
+// written, or the response is flushed, the response is compressed.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	minSize int
+	status  int
+
+	buf         []byte
+	gz          *gzip.Writer
+	gotStatus   bool
+	passthrough bool
+}
+
+func (w *gzipResponseWriter) WriteHeader(status int) {
+	if w.gotStatus || w.passthrough {
+		return
+	}
+	w.gotStatus = true
+	w.status = status
+	// Responses without a body, and responses already encoded by the handler,
+	// are sent as is.
+	if status < http.StatusOK || status == http.StatusNoContent || status == http.StatusNotModified ||
+		w.Header().Get("Content-Encoding") != "" {
+		w.passthrough = true
+		w.ResponseWriter.WriteHeader(status)
+	}
+}
+
+func (w *gzipResponseWriter) Write(p []byte) (int, error) {
+	if w.passthrough {
+		return w.ResponseWriter.Write(p)
+	}
+	if w.gz != nil {
+		return w.gz.Write(p)
+	}
+	if w.Header().Get("Content-Encoding") != "" {
+		w.passthrough = true
+		w.ResponseWriter.WriteHeader(w.status)
+		return w.ResponseWriter.Write(p)
+	}
+	w.buf = append(w.buf, p...)
+	if len(w.buf) >= w.minSize {
+		if err := w.startGzip(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Flush sends any buffered data to the client. Flushing a response that is
+// still being held back starts compression, since the response is streamed.
+func (w *gzipResponseWriter) Flush() {
+	if !w.passthrough {
+		if w.gz == nil {
+			if err := w.startGzip(); err != nil {
+				return
+			}
+		}
+		if err := w.gz.Flush(); err != nil {
+			return
+		}
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// startGzip sends the response header for a compressed response and writes
+// any held back data to the gzip writer.
+func (w *gzipResponseWriter) startGzip() error {
+	h := w.Header()
+	if h.Get("Content-Type") == "" && len(w.buf) != 0 {
+		// Detect the content type from the uncompressed data, since
+		// http.ResponseWriter would otherwise detect it from compressed data.
+		h.Set("Content-Type", http.DetectContentType(w.buf))
+	}
+	h.Del("Content-Length")
+	h.Set("Content-Encoding", "gzip")
+	w.ResponseWriter.WriteHeader(w.status)
+
+	w.gz = gzipWriterPool.Get().(*gzip.Writer)
+	w.gz.Reset(w.ResponseWriter)
+	_, err := w.gz.Write(w.buf)
+	w.buf = nil
+	return err
+}
+
+// close finishes the response. A response smaller than minSize is sent
+// uncompressed.
+func (w *gzipResponseWriter) close() {
+	if w.passthrough {
+		return
+	}
+	if w.gz != nil {
+		if err := w.gz.Close(); err != nil {
+			log.Errorw("Cannot finish gzip response", "err", err)
+		}
+		w.gz.Reset(nil)
+		gzipWriterPool.Put(w.gz)
+		w.gz = nil
+		return
+	}
+	if len(w.buf) != 0 {
+		w.Header().Set("Content-Length", strconv.Itoa(len(w.buf)))
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	if len(w.buf) != 0 {
+		if _, err := w.ResponseWriter.Write(w.buf); err != nil {
+			log.Errorw("Cannot write response", "err", err)
+		}
+	}
+}
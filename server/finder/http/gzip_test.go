@@ -0,0 +1,92 @@
+package httpfinderserver
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestGzipHandler(t *testing.T) {
+	large := bytes.Repeat([]byte("{\"Multihash\":\"abc\"}\n"), 200)
+	small := []byte("{}\n")
+
+	handler := gzipHandler(1024, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/large":
+			_, _ = w.Write(large)
+		case "/small":
+			_, _ = w.Write(small)
+		case "/stream":
+			_, _ = w.Write(small)
+			w.(http.Flusher).Flush()
+			_, _ = w.Write(small)
+		}
+	}))
+
+	serve := func(path, acceptEncoding string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	gunzip := func(t *testing.T, rec *httptest.ResponseRecorder) []byte {
+		if rec.Header().Get("Content-Encoding") != "gzip" {
+			t.Fatal("expected gzip content encoding")
+		}
+		if rec.Header().Get("Content-Length") != "" {
+			t.Fatal("compressed response must not have content length")
+		}
+		gr, err := gzip.NewReader(rec.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(gr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+
+	rec := serve("/large", "deflate, gzip")
+	if !bytes.Equal(gunzip(t, rec), large) {
+		t.Fatal("wrong decompressed response")
+	}
+	if rec.Header().Get("Content-Type") != "application/json" {
+		t.Fatal("wrong content type")
+	}
+
+	rec = serve("/stream", "gzip")
+	if !bytes.Equal(gunzip(t, rec), append(append([]byte{}, small...), small...)) {
+		t.Fatal("wrong decompressed streamed response")
+	}
+
+	rec = serve("/small", "gzip")
+	if rec.Header().Get("Content-Encoding") != "" {
+		t.Fatal("small response should not be compressed")
+	}
+	if rec.Header().Get("Content-Length") != strconv.Itoa(len(small)) {
+		t.Fatal("wrong content length for small response")
+	}
+	if !bytes.Equal(rec.Body.Bytes(), small) {
+		t.Fatal("wrong small response")
+	}
+
+	for _, acceptEncoding := range []string{"", "deflate", "gzip;q=0"} {
+		rec = serve("/large", acceptEncoding)
+		if rec.Header().Get("Content-Encoding") != "" {
+			t.Fatalf("response compressed with Accept-Encoding %q", acceptEncoding)
+		}
+		if !bytes.Equal(rec.Body.Bytes(), large) {
+			t.Fatal("wrong uncompressed response")
+		}
+	}
+}
@@ -6,10 +6,13 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	indexer "github.com/filecoin-project/go-indexer-core"
 	coremetrics "github.com/filecoin-project/go-indexer-core/metrics"
+	v0 "github.com/filecoin-project/storetheindex/api/v0"
 	"github.com/filecoin-project/storetheindex/api/v0/finder/model"
 	"github.com/filecoin-project/storetheindex/internal/httpserver"
 	"github.com/filecoin-project/storetheindex/internal/metrics"
@@ -24,9 +27,21 @@ import (
 	"go.opencensus.io/tag"
 )
 
+// ndjsonMediaType is the media type of newline-delimited JSON, used to stream
+// find results.
+const ndjsonMediaType = "application/x-ndjson"
+
 // handler handles requests for the finder resource
 type httpHandler struct {
 	finderHandler *handler.FinderHandler
+	// blockedStatus, if true, responds with 451 (Unavailable For Legal
+	// Reasons) when all the multihashes in a find request are blocked.
+	blockedStatus bool
+	// signResponses is true if responses are signed. A signed response is
+	// only sent once complete, so find results cannot be streamed.
+	signResponses bool
+	// info describes the indexer in info responses.
+	info model.Info
 }
 
 func newHandler(indexer indexer.Interface, registry *registry.Registry) *httpHandler {
@@ -44,7 +59,12 @@ func (h *httpHandler) find(w http.ResponseWriter, r *http.Request) {
 		httpserver.HandleError(w, err, "find")
 		return
 	}
-	h.getIndexes(w, []multihash.Multihash{m})
+	opts, err := findOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	h.getIndexes(r.Context(), w, []multihash.Multihash{m}, opts, acceptsCBOR(r))
 }
 
 func (h *httpHandler) findCid(w http.ResponseWriter, r *http.Request) {
@@ -56,7 +76,12 @@ func (h *httpHandler) findCid(w http.ResponseWriter, r *http.Request) {
 		httpserver.HandleError(w, err, "find")
 		return
 	}
-	h.getIndexes(w, []multihash.Multihash{c.Hash()})
+	opts, err := findOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	h.getIndexes(r.Context(), w, []multihash.Multihash{c.Hash()}, opts, acceptsCBOR(r))
 }
 
 func (h *httpHandler) findBatch(w http.ResponseWriter, r *http.Request) {
@@ -72,10 +97,95 @@ func (h *httpHandler) findBatch(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "", http.StatusInternalServerError)
 		return
 	}
-	h.getIndexes(w, req.Multihashes)
+	opts, err := findOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if strings.Contains(r.Header.Get("Accept"), ndjsonMediaType) {
+		if h.signResponses {
+			http.Error(w, "streamed responses cannot be signed", http.StatusNotAcceptable)
+			return
+		}
+		h.streamIndexes(r.Context(), w, req.Multihashes, opts)
+		return
+	}
+	h.getIndexes(r.Context(), w, req.Multihashes, opts, acceptsCBOR(r))
+}
+
+// findOptions gets the find options from the request's query parameters. The
+// withTimestamps=true parameter asks for provider results to include the time
+// that the multihash was indexed, or the time that the provider's context was
+// last updated if the indexer does not record index times, and
+// withContextAdvertisement=true asks for them to include the CID of the
+// advertisement that last updated the provider's context, which is not
+// necessarily the advertisement that indexed the multihash. The transport
+// parameter, such as transport=http, asks for only provider results with
+// metadata for that transport. The codec parameter, such as codec=dag-pb, asks
+// for only provider results from providers that advertised the multihash as a
+// CID with that codec. The unreachable=exclude parameter omits providers that
+// were unreachable when last checked, and unreachable=last puts them after all
+// other providers. The rank parameter, such as rank=trust,recency, orders
+// providers by the listed criteria, and the limit parameter, such as limit=5,
+// returns only that many of the top providers for each multihash. All
+// providers are returned when there is no limit parameter or when limit=0.
+// Providers that are no longer registered are omitted, unless the
+// deregistered=flag parameter asks for them to be returned with a deregistered
+// status.
+func findOptions(r *http.Request) (handler.FindOptions, error) {
+	var opts handler.FindOptions
+	query := r.URL.Query()
+	opts.WithTimestamps, _ = strconv.ParseBool(query.Get("withTimestamps"))
+	opts.WithContextAdvertisement, _ = strconv.ParseBool(query.Get("withContextAdvertisement"))
+	switch unreachable := query.Get("unreachable"); unreachable {
+	case "":
+	case "exclude":
+		opts.ExcludeUnreachable = true
+	case "last":
+		opts.UnreachableLast = true
+	default:
+		return opts, fmt.Errorf("unreachable must be \"exclude\" or \"last\", not %q", unreachable)
+	}
+	switch deregistered := query.Get("deregistered"); deregistered {
+	case "", "omit":
+	case "flag":
+		opts.FlagDeregistered = true
+	default:
+		return opts, fmt.Errorf("deregistered must be \"omit\" or \"flag\", not %q", deregistered)
+	}
+	if rank := query.Get("rank"); rank != "" {
+		var err error
+		opts.Rank, err = handler.ParseRank(rank)
+		if err != nil {
+			return opts, err
+		}
+	}
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 0 {
+			return opts, fmt.Errorf("limit must be a non-negative integer, not %q", limit)
+		}
+		opts.Limit = n
+	}
+	if transport := query.Get("transport"); transport != "" {
+		var err error
+		opts.Transport, err = v0.ParseTransport(transport)
+		if err != nil {
+			return opts, err
+		}
+	}
+	if codec := query.Get("codec"); codec != "" {
+		if err := opts.Codec.Set(codec); err != nil {
+			return opts, err
+		}
+	}
+	return opts, nil
 }
 
-func (h *httpHandler) getIndexes(w http.ResponseWriter, mhs []multihash.Multihash) {
+// streamIndexes writes each multihash result as a line of newline-delimited
+// JSON, flushing each result as soon as it is available. This avoids holding
+// the entire response in memory for large batches.
+func (h *httpHandler) streamIndexes(ctx context.Context, w http.ResponseWriter, mhs []multihash.Multihash, opts handler.FindOptions) {
 	startTime := time.Now()
 	var found bool
 	defer func() {
@@ -85,7 +195,68 @@ func (h *httpHandler) getIndexes(w http.ResponseWriter, mhs []multihash.Multihas
 			stats.WithMeasurements(metrics.FindLatency.M(msecPerMh)))
 	}()
 
-	response, err := h.finderHandler.Find(mhs)
+	// The status cannot be changed once results are streamed, so respond to
+	// a request for only blocked multihashes as the buffered response does,
+	// before writing any results.
+	if h.blockedStatus && h.finderHandler.AllBlocked(mhs) {
+		http.Error(w, "content blocked", http.StatusUnavailableForLegalReasons)
+		return
+	}
+
+	flusher, _ := w.(http.Flusher)
+	encoder := json.NewEncoder(w)
+
+	count, err := h.finderHandler.FindEach(ctx, mhs, opts, func(result model.MultihashResult) error {
+		if !found {
+			w.Header().Set("Content-Type", ndjsonMediaType)
+			w.WriteHeader(http.StatusOK)
+			found = true
+		}
+		// Encode writes the result followed by a newline.
+		if err := encoder.Encode(&result); err != nil {
+			return err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	})
+	if err != nil {
+		if count == 0 && !found {
+			httpserver.HandleError(w, err, "get")
+			return
+		}
+		// The response status was already sent, so the only thing that can be
+		// done is to stop writing results.
+		log.Errorw("failed streaming query response", "err", err, "written", count)
+		return
+	}
+
+	// If no info for any multihashes, then 404
+	if !found {
+		http.Error(w, "no results for query", http.StatusNotFound)
+	}
+}
+
+// acceptsCBOR returns true if the request asks for a DAG-CBOR encoded find
+// response, instead of the default JSON.
+func acceptsCBOR(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), model.CBORMediaType)
+}
+
+// getIndexes writes the find response for the multihashes, encoded as
+// DAG-CBOR if binary is true, and as JSON otherwise.
+func (h *httpHandler) getIndexes(ctx context.Context, w http.ResponseWriter, mhs []multihash.Multihash, opts handler.FindOptions, binary bool) {
+	startTime := time.Now()
+	var found bool
+	defer func() {
+		msecPerMh := coremetrics.MsecSince(startTime) / float64(len(mhs))
+		_ = stats.RecordWithOptions(context.Background(),
+			stats.WithTags(tag.Insert(metrics.Method, "http"), tag.Insert(metrics.Found, fmt.Sprintf("%v", found))),
+			stats.WithMeasurements(metrics.FindLatency.M(msecPerMh)))
+	}()
+
+	response, err := h.finderHandler.FindWithOptions(ctx, mhs, opts)
 	if err != nil {
 		httpserver.HandleError(w, err, "get")
 		return
@@ -93,26 +264,47 @@ func (h *httpHandler) getIndexes(w http.ResponseWriter, mhs []multihash.Multihas
 
 	// If no info for any multihashes, then 404
 	if len(response.MultihashResults) == 0 {
+		if h.blockedStatus && h.finderHandler.AllBlocked(mhs) {
+			http.Error(w, "content blocked", http.StatusUnavailableForLegalReasons)
+			return
+		}
 		http.Error(w, "no results for query", http.StatusNotFound)
 		return
 	}
 
+	if binary {
+		rb, err := model.MarshalFindResponseCBOR(response)
+		if err != nil {
+			log.Errorw("failed marshalling query response as cbor", "err", err)
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		found = true
+		w.Header().Set("Content-Type", model.CBORMediaType)
+		w.WriteHeader(http.StatusOK)
+		if _, err = w.Write(rb); err != nil {
+			log.Errorw("cannot write response", "err", err)
+		}
+		return
+	}
+
 	rb, err := model.MarshalFindResponse(response)
 	if err != nil {
 		log.Errorw("failed marshalling query response", "err", err)
 		http.Error(w, "", http.StatusInternalServerError)
 		return
 	}
-
 	found = true
 	httpserver.WriteJsonResponse(w, http.StatusOK, rb)
 }
 
 // ----- provider handlers -----
 
-// GET /providers",
+// GET /providers?tag=<tag>
+//
+// Each tag query parameter limits the providers to those having that tag.
 func (h *httpHandler) listProviders(w http.ResponseWriter, r *http.Request) {
-	data, err := h.finderHandler.ListProviders()
+	data, err := h.finderHandler.ListProviders(r.URL.Query()["tag"]...)
 	if err != nil {
 		log.Errorw("cannot list providers", "err", err)
 		http.Error(w, "", http.StatusInternalServerError)
@@ -145,6 +337,29 @@ func (h *httpHandler) getProvider(w http.ResponseWriter, r *http.Request) {
 	httpserver.WriteJsonResponse(w, http.StatusOK, data)
 }
 
+// GET /providers/{providerid}/contexts
+func (h *httpHandler) getProviderContexts(w http.ResponseWriter, r *http.Request) {
+	providerID, err := getProviderID(r)
+	if err != nil {
+		http.Error(w, "", http.StatusBadRequest)
+		return
+	}
+
+	data, err := h.finderHandler.GetProviderContexts(providerID)
+	if err != nil {
+		log.Errorw("cannot get provider contexts", "err", err)
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	if len(data) == 0 {
+		http.Error(w, "provider not found", http.StatusNotFound)
+		return
+	}
+
+	httpserver.WriteJsonResponse(w, http.StatusOK, data)
+}
+
 // GET /stats",
 func (h *httpHandler) getStats(w http.ResponseWriter, r *http.Request) {
 	data, err := h.finderHandler.GetStats()
@@ -157,6 +372,19 @@ func (h *httpHandler) getStats(w http.ResponseWriter, r *http.Request) {
 	httpserver.WriteJsonResponse(w, http.StatusOK, data)
 }
 
+// getInfo writes the indexer's identity, version, and the protocols and
+// features that it supports.
+func (h *httpHandler) getInfo(w http.ResponseWriter, r *http.Request) {
+	data, err := model.MarshalInfo(&h.info)
+	if err != nil {
+		log.Errorw("cannot marshal info", "err", err)
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	httpserver.WriteJsonResponse(w, http.StatusOK, data)
+}
+
 func getProviderID(r *http.Request) (peer.ID, error) {
 	vars := mux.Vars(r)
 	pid := vars["providerid"]
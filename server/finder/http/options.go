@@ -3,19 +3,30 @@ package httpfinderserver
 import (
 	"fmt"
 	"time"
+
+	"github.com/filecoin-project/storetheindex/api/v0/finder/model"
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
 )
 
 const (
 	apiWriteTimeout = 30 * time.Second
 	apiReadTimeout  = 30 * time.Second
+	findTimeout     = 20 * time.Second
 	maxConns        = 8_000
+	gzipMinSize     = 1024
 )
 
 // serverConfig is a structure containing all the options that can be used when constructing an http server
 type serverConfig struct {
 	apiWriteTimeout time.Duration
 	apiReadTimeout  time.Duration
+	findTimeout     time.Duration
 	maxConns        int
+	gzipMinSize     int
+	blockedStatus   bool
+	signKey         crypto.PrivKey
+	info            model.Info
 }
 
 // ServerOption for httpserver
@@ -26,7 +37,9 @@ type ServerOption func(*serverConfig) error
 var serverDefaults = func(o *serverConfig) error {
 	o.apiWriteTimeout = apiWriteTimeout
 	o.apiReadTimeout = apiReadTimeout
+	o.findTimeout = findTimeout
 	o.maxConns = maxConns
+	o.gzipMinSize = gzipMinSize
 	return nil
 }
 
@@ -60,3 +73,57 @@ func ReadTimeout(t time.Duration) ServerOption {
 		return nil
 	}
 }
+
+// FindTimeout sets the maximum time to spend handling a find request. Value
+// store lookups are not started after this time, and the request fails with
+// 504 (Gateway Timeout). Zero means no limit.
+func FindTimeout(t time.Duration) ServerOption {
+	return func(c *serverConfig) error {
+		c.findTimeout = t
+		return nil
+	}
+}
+
+// BlockedStatus, if true, responds to find requests for only blocked
+// multihashes with 451 (Unavailable For Legal Reasons) instead of 404 (Not
+// Found).
+func BlockedStatus(enable bool) ServerOption {
+	return func(c *serverConfig) error {
+		c.blockedStatus = enable
+		return nil
+	}
+}
+
+// GzipMinSize sets the minimum size of a response that is compressed when the
+// client accepts gzip encoding. Smaller responses are sent uncompressed.
+func GzipMinSize(size int) ServerOption {
+	return func(c *serverConfig) error {
+		c.gzipMinSize = size
+		return nil
+	}
+}
+
+// SignResponses signs every response with the key, and sets the signature and
+// the peer ID of the key in the response headers. Requests for streamed find
+// results are refused with 406 (Not Acceptable), since a signed response is
+// only sent once complete. A nil key disables signing, which is the default.
+func SignResponses(privKey crypto.PrivKey) ServerOption {
+	return func(c *serverConfig) error {
+		c.signKey = privKey
+		return nil
+	}
+}
+
+// IndexerInfo sets the peer ID described by info responses, and the
+// protocols that the indexer serves other than with this server. The finder
+// HTTP and reframe protocols, the version, and the features enabled by the
+// other options, are filled in by the server.
+func IndexerInfo(peerID peer.ID, protocols []string) ServerOption {
+	return func(c *serverConfig) error {
+		c.info = model.Info{
+			PeerID:    peerID,
+			Protocols: protocols,
+		}
+		return nil
+	}
+}
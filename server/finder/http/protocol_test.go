@@ -1,18 +1,34 @@
 package httpfinderserver_test
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"encoding/json"
+	"io"
+	"math/rand"
 	"net/http"
+	"reflect"
 	"testing"
 	"time"
 
 	indexer "github.com/filecoin-project/go-indexer-core"
+	v0 "github.com/filecoin-project/storetheindex/api/v0"
 	httpclient "github.com/filecoin-project/storetheindex/api/v0/finder/client/http"
+	"github.com/filecoin-project/storetheindex/api/v0/finder/model"
 	"github.com/filecoin-project/storetheindex/internal/registry"
+	"github.com/filecoin-project/storetheindex/internal/version"
 	httpserver "github.com/filecoin-project/storetheindex/server/finder/http"
 	"github.com/filecoin-project/storetheindex/server/finder/test"
+	"github.com/filecoin-project/storetheindex/test/util"
+	"github.com/ipfs/go-cid"
 	"github.com/ipfs/go-delegated-routing/client"
 	"github.com/ipfs/go-delegated-routing/gen/proto"
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
+	"github.com/multiformats/go-varint"
 )
 
 func setupServer(ind indexer.Interface, reg *registry.Registry, t *testing.T) *httpserver.Server {
@@ -144,7 +160,22 @@ func TestProviderInfo(t *testing.T) {
 
 	test.ListProvidersTest(t, httpClient, peerID)
 
-	err := s.Shutdown(ctx)
+	err := reg.UpdateProviderContext(ctx, peerID, []byte("ctx-1"), []byte("meta"), 7, cid.Undef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	contexts, err := httpClient.GetProviderContexts(ctx, peerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(contexts) != 1 {
+		t.Fatalf("expected 1 context, got %d", len(contexts))
+	}
+	if string(contexts[0].ContextID) != "ctx-1" || string(contexts[0].Metadata) != "meta" || contexts[0].EntryCount != 7 {
+		t.Fatal("wrong provider context")
+	}
+
+	err = s.Shutdown(ctx)
 	if err != nil {
 		t.Error("shutdown error:", err)
 	}
@@ -234,3 +265,668 @@ func TestRemoveProvider(t *testing.T) {
 		t.Errorf("Error closing indexer core: %s", err)
 	}
 }
+
+func TestFindBatchStream(t *testing.T) {
+	ind := test.InitIndex(t, true)
+	defer ind.Close()
+	reg := test.InitRegistry(t)
+	defer reg.Close()
+
+	s := setupServer(ind, reg, t)
+	errChan := make(chan error, 1)
+	go func() {
+		err := s.Start()
+		if err != http.ErrServerClosed {
+			errChan <- err
+		}
+		close(errChan)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	peerID := test.Register(ctx, t, reg)
+	mhs := util.RandomMultihashes(10, rand.New(rand.NewSource(1413)))
+	value := indexer.Value{
+		ProviderID:    peerID,
+		ContextID:     []byte("test-context-id"),
+		MetadataBytes: []byte("test-metadata"),
+	}
+	// Index only the even multihashes.
+	for i := 0; i < len(mhs); i += 2 {
+		if err := ind.Put(value, mhs[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reqData, err := model.MarshalFindRequest(&model.FindRequest{Multihashes: mhs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL()+"/multihash", bytes.NewReader(reqData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept", "application/x-ndjson")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatal("expected response to be", http.StatusOK)
+	}
+	if resp.Header.Get("Content-Type") != "application/x-ndjson" {
+		t.Fatal("wrong content type:", resp.Header.Get("Content-Type"))
+	}
+
+	var count int
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		var result model.MultihashResult
+		if err = json.Unmarshal(scanner.Bytes(), &result); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(result.Multihash, mhs[2*count]) {
+			t.Fatal("results out of order")
+		}
+		if len(result.ProviderResults) != 1 || result.ProviderResults[0].Provider.ID != peerID {
+			t.Fatal("wrong provider result")
+		}
+		count++
+	}
+	if err = scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if count != len(mhs)/2 {
+		t.Fatalf("expected %d results, got %d", len(mhs)/2, count)
+	}
+
+	if err = s.Shutdown(ctx); err != nil {
+		t.Error("shutdown error:", err)
+	}
+	if err = <-errChan; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFindBatchStreamSigned(t *testing.T) {
+	ind := test.InitIndex(t, true)
+	defer ind.Close()
+	reg := test.InitRegistry(t)
+	defer reg.Close()
+
+	privKey, _, err := crypto.GenerateEd25519Key(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	indexerID, err := peer.IDFromPrivateKey(privKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := httpserver.New("127.0.0.1:0", ind, reg, httpserver.SignResponses(privKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	errChan := make(chan error, 1)
+	go func() {
+		err := s.Start()
+		if err != http.ErrServerClosed {
+			errChan <- err
+		}
+		close(errChan)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	peerID := test.Register(ctx, t, reg)
+	mhs := util.RandomMultihashes(2, rand.New(rand.NewSource(1413)))
+	value := indexer.Value{
+		ProviderID:    peerID,
+		ContextID:     []byte("test-context-id"),
+		MetadataBytes: []byte("test-metadata"),
+	}
+	if err = ind.Put(value, mhs...); err != nil {
+		t.Fatal(err)
+	}
+
+	// A streamed response cannot be signed, so it is refused.
+	reqData, err := model.MarshalFindRequest(&model.FindRequest{Multihashes: mhs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL()+"/multihash", bytes.NewReader(reqData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept", "application/x-ndjson")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotAcceptable {
+		t.Fatal("expected", http.StatusNotAcceptable, "for streamed find, got", resp.StatusCode)
+	}
+
+	// The same request without streaming is answered and signed.
+	req, err = http.NewRequestWithContext(ctx, http.MethodPost, s.URL()+"/multihash", bytes.NewReader(reqData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatal("expected", http.StatusOK, "for buffered find, got", resp.StatusCode)
+	}
+	if err = model.VerifyResponse(indexerID, "/multihash", body, resp.Header.Get(model.SignatureHeader)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = s.Shutdown(ctx); err != nil {
+		t.Error("shutdown error:", err)
+	}
+	if err = <-errChan; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFindWithTimestamps(t *testing.T) {
+	ind := test.InitIndex(t, true)
+	defer ind.Close()
+	reg := test.InitRegistry(t)
+	defer reg.Close()
+
+	s := setupServer(ind, reg, t)
+	errChan := make(chan error, 1)
+	go func() {
+		err := s.Start()
+		if err != http.ErrServerClosed {
+			errChan <- err
+		}
+		close(errChan)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	peerID := test.Register(ctx, t, reg)
+	mhs := util.RandomMultihashes(1, rand.New(rand.NewSource(1413)))
+	value := indexer.Value{
+		ProviderID:    peerID,
+		ContextID:     []byte("test-context-id"),
+		MetadataBytes: []byte("test-metadata"),
+	}
+	if err := ind.Put(value, mhs[0]); err != nil {
+		t.Fatal(err)
+	}
+	err := reg.UpdateProviderContext(ctx, peerID, value.ContextID, value.MetadataBytes, 1, cid.Undef)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	getResult := func(query string) model.ProviderResult {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.URL()+"/multihash/"+mhs[0].B58String()+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatal("expected response to be", http.StatusOK)
+		}
+		var findResp model.FindResponse
+		if err = json.NewDecoder(resp.Body).Decode(&findResp); err != nil {
+			t.Fatal(err)
+		}
+		if len(findResp.MultihashResults) != 1 || len(findResp.MultihashResults[0].ProviderResults) != 1 {
+			t.Fatal("expected one provider result")
+		}
+		return findResp.MultihashResults[0].ProviderResults[0]
+	}
+
+	if getResult("").Timestamp != "" {
+		t.Fatal("timestamp returned when not requested")
+	}
+	if getResult("?withTimestamps=true").Timestamp == "" {
+		t.Fatal("timestamp not returned when requested")
+	}
+
+	if err = s.Shutdown(ctx); err != nil {
+		t.Error("shutdown error:", err)
+	}
+	if err = <-errChan; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFindWithContextAdvertisement(t *testing.T) {
+	ind := test.InitIndex(t, true)
+	defer ind.Close()
+	reg := test.InitRegistry(t)
+	defer reg.Close()
+
+	s := setupServer(ind, reg, t)
+	errChan := make(chan error, 1)
+	go func() {
+		err := s.Start()
+		if err != http.ErrServerClosed {
+			errChan <- err
+		}
+		close(errChan)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	peerID := test.Register(ctx, t, reg)
+	mhs := util.RandomMultihashes(2, rand.New(rand.NewSource(1413)))
+	value := indexer.Value{
+		ProviderID:    peerID,
+		ContextID:     []byte("test-context-id"),
+		MetadataBytes: []byte("test-metadata"),
+	}
+	if err := ind.Put(value, mhs[0]); err != nil {
+		t.Fatal(err)
+	}
+	adCid := cid.NewCidV1(cid.DagJSON, mhs[1])
+	err := reg.UpdateProviderContext(ctx, peerID, value.ContextID, value.MetadataBytes, 1, adCid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for query, expect := range map[string]string{
+		"":                               "",
+		"?withContextAdvertisement=true": adCid.String(),
+	} {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.URL()+"/multihash/"+mhs[0].B58String()+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var findResp model.FindResponse
+		err = json.NewDecoder(resp.Body).Decode(&findResp)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(findResp.MultihashResults) != 1 || len(findResp.MultihashResults[0].ProviderResults) != 1 {
+			t.Fatal("expected one provider result")
+		}
+		if got := findResp.MultihashResults[0].ProviderResults[0].ContextLastAdvertisementCid; got != expect {
+			t.Fatalf("expected advertisement %q for query %q, got %q", expect, query, got)
+		}
+	}
+
+	if err = s.Shutdown(ctx); err != nil {
+		t.Error("shutdown error:", err)
+	}
+	if err = <-errChan; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFindByTransport(t *testing.T) {
+	ind := test.InitIndex(t, true)
+	defer ind.Close()
+	reg := test.InitRegistry(t)
+	defer reg.Close()
+
+	s := setupServer(ind, reg, t)
+	errChan := make(chan error, 1)
+	go func() {
+		err := s.Start()
+		if err != http.ErrServerClosed {
+			errChan <- err
+		}
+		close(errChan)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	peerID := test.Register(ctx, t, reg)
+	mhs := util.RandomMultihashes(1, rand.New(rand.NewSource(1413)))
+	bitswapValue := indexer.Value{
+		ProviderID:    peerID,
+		ContextID:     []byte("bitswap-context"),
+		MetadataBytes: varint.ToUvarint(uint64(multicodec.TransportBitswap)),
+	}
+	httpValue := indexer.Value{
+		ProviderID:    peerID,
+		ContextID:     []byte("http-context"),
+		MetadataBytes: append(varint.ToUvarint(uint64(v0.TransportHTTP)), []byte("https://example.com")...),
+	}
+	for _, value := range []indexer.Value{bitswapValue, httpValue} {
+		if err := ind.Put(value, mhs[0]); err != nil {
+			t.Fatal(err)
+		}
+		err := reg.UpdateProviderContext(ctx, peerID, value.ContextID, value.MetadataBytes, 1, cid.Undef)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if reg.ProviderContext(peerID, httpValue.ContextID).Transport != v0.TransportHTTP {
+		t.Fatal("transport not recorded for provider context")
+	}
+
+	find := func(query string) (int, []model.ProviderResult) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.URL()+"/multihash/"+mhs[0].B58String()+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return resp.StatusCode, nil
+		}
+		var findResp model.FindResponse
+		if err = json.NewDecoder(resp.Body).Decode(&findResp); err != nil {
+			t.Fatal(err)
+		}
+		if len(findResp.MultihashResults) != 1 {
+			t.Fatal("expected one multihash result")
+		}
+		return resp.StatusCode, findResp.MultihashResults[0].ProviderResults
+	}
+
+	_, results := find("")
+	if len(results) != 2 {
+		t.Fatal("expected 2 provider results without transport, got", len(results))
+	}
+	_, results = find("?transport=http")
+	if len(results) != 1 || !bytes.Equal(results[0].ContextID, httpValue.ContextID) {
+		t.Fatal("expected only http provider result")
+	}
+	_, results = find("?transport=bitswap")
+	if len(results) != 1 || !bytes.Equal(results[0].ContextID, bitswapValue.ContextID) {
+		t.Fatal("expected only bitswap provider result")
+	}
+	status, _ := find("?transport=graphsync")
+	if status != http.StatusNotFound {
+		t.Fatal("expected", http.StatusNotFound, "when no results for transport, got", status)
+	}
+	status, _ = find("?transport=carrier-pigeon")
+	if status != http.StatusBadRequest {
+		t.Fatal("expected", http.StatusBadRequest, "for unknown transport, got", status)
+	}
+
+	if err := s.Shutdown(ctx); err != nil {
+		t.Error("shutdown error:", err)
+	}
+	if err := <-errChan; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFindCBOR(t *testing.T) {
+	ind := test.InitIndex(t, true)
+	defer ind.Close()
+	reg := test.InitRegistry(t)
+	defer reg.Close()
+
+	s := setupServer(ind, reg, t)
+	errChan := make(chan error, 1)
+	go func() {
+		err := s.Start()
+		if err != http.ErrServerClosed {
+			errChan <- err
+		}
+		close(errChan)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	peerID := test.Register(ctx, t, reg)
+	mhs := util.RandomMultihashes(1, rand.New(rand.NewSource(1413)))
+	value := indexer.Value{
+		ProviderID:    peerID,
+		ContextID:     []byte("test-context-id"),
+		MetadataBytes: []byte("test-metadata"),
+	}
+	if err := ind.Put(value, mhs[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	find := func(accept string) *http.Response {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.URL()+"/multihash/"+mhs[0].B58String(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Fatal("expected response to be", http.StatusOK)
+		}
+		return resp
+	}
+
+	// JSON is the default.
+	resp := find("")
+	jsonData, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Header.Get("Content-Type") == model.CBORMediaType {
+		t.Fatal("expected JSON response by default")
+	}
+	if _, err = model.UnmarshalFindResponse(jsonData); err != nil {
+		t.Fatal(err)
+	}
+
+	resp = find(model.CBORMediaType)
+	cborData, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Header.Get("Content-Type") != model.CBORMediaType {
+		t.Fatal("wrong content type:", resp.Header.Get("Content-Type"))
+	}
+	findResp, err := model.UnmarshalFindResponseCBOR(cborData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(findResp.MultihashResults) != 1 || !bytes.Equal(findResp.MultihashResults[0].Multihash, mhs[0]) {
+		t.Fatal("wrong multihash result")
+	}
+	provResults := findResp.MultihashResults[0].ProviderResults
+	if len(provResults) != 1 || provResults[0].Provider.ID != peerID {
+		t.Fatal("wrong provider result")
+	}
+	if len(cborData) >= len(jsonData) {
+		t.Fatalf("expected CBOR response (%d bytes) to be smaller than JSON (%d bytes)", len(cborData), len(jsonData))
+	}
+
+	if err = s.Shutdown(ctx); err != nil {
+		t.Error("shutdown error:", err)
+	}
+	if err = <-errChan; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFindBlocked(t *testing.T) {
+	ind := test.InitIndex(t, true)
+	defer ind.Close()
+	reg := test.InitRegistry(t)
+	defer reg.Close()
+
+	s, err := httpserver.New("127.0.0.1:0", ind, reg, httpserver.BlockedStatus(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	errChan := make(chan error, 1)
+	go func() {
+		err := s.Start()
+		if err != http.ErrServerClosed {
+			errChan <- err
+		}
+		close(errChan)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	peerID := test.Register(ctx, t, reg)
+	mhs := util.RandomMultihashes(2, rand.New(rand.NewSource(1413)))
+	value := indexer.Value{
+		ProviderID:    peerID,
+		ContextID:     []byte("test-context"),
+		MetadataBytes: varint.ToUvarint(uint64(multicodec.TransportBitswap)),
+	}
+	if err = ind.Put(value, mhs...); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = reg.BlockMultihash(ctx, mhs[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	findStatus := func(mh multihash.Multihash) int {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.URL()+"/multihash/"+mh.B58String(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if status := findStatus(mhs[0]); status != http.StatusUnavailableForLegalReasons {
+		t.Fatal("expected", http.StatusUnavailableForLegalReasons, "for blocked multihash, got", status)
+	}
+	if status := findStatus(mhs[1]); status != http.StatusOK {
+		t.Fatal("expected", http.StatusOK, "for multihash that is not blocked, got", status)
+	}
+
+	// A batch find omits the blocked multihash.
+	c := setupClient(s.URL(), t)
+	resp, err := c.FindBatch(ctx, mhs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.MultihashResults) != 1 || !bytes.Equal(resp.MultihashResults[0].Multihash, mhs[1]) {
+		t.Fatal("expected only result for multihash that is not blocked")
+	}
+
+	// A streamed batch find responds like the buffered one.
+	streamStatus := func(mhs ...multihash.Multihash) int {
+		body, err := model.MarshalFindRequest(&model.FindRequest{Multihashes: mhs})
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL()+"/multihash", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Accept", "application/x-ndjson")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if status := streamStatus(mhs[0]); status != http.StatusUnavailableForLegalReasons {
+		t.Fatal("expected", http.StatusUnavailableForLegalReasons, "for streamed find of blocked multihash, got", status)
+	}
+	if status := streamStatus(mhs...); status != http.StatusOK {
+		t.Fatal("expected", http.StatusOK, "for streamed find with multihash that is not blocked, got", status)
+	}
+
+	if _, err = reg.UnblockMultihash(ctx, mhs[0]); err != nil {
+		t.Fatal(err)
+	}
+	if status := findStatus(mhs[0]); status != http.StatusOK {
+		t.Fatal("expected", http.StatusOK, "for unblocked multihash, got", status)
+	}
+
+	if err = s.Shutdown(ctx); err != nil {
+		t.Error("shutdown error:", err)
+	}
+	if err = <-errChan; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetInfo(t *testing.T) {
+	ind := test.InitIndex(t, true)
+	defer ind.Close()
+	reg := test.InitRegistry(t)
+	defer reg.Close()
+
+	peerID, err := peer.Decode("12D3KooWKRyzVWW6ChFjQjK4miCty85Niy48tpPV95XdKu1BcvMA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := httpserver.New("127.0.0.1:0", ind, reg, httpserver.BlockedStatus(true),
+		httpserver.IndexerInfo(peerID, []string{model.ProtocolHttpIngest}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	errChan := make(chan error, 1)
+	go func() {
+		err := s.Start()
+		if err != http.ErrServerClosed {
+			errChan <- err
+		}
+		close(errChan)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	c := setupClient(s.URL(), t)
+	info, err := c.GetInfo(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.PeerID != peerID {
+		t.Error("wrong peer id:", info.PeerID)
+	}
+	if info.Version != version.String() {
+		t.Error("wrong version:", info.Version)
+	}
+	expectProtocols := []string{model.ProtocolHttpFinder, model.ProtocolReframe, model.ProtocolHttpIngest}
+	if !reflect.DeepEqual(info.Protocols, expectProtocols) {
+		t.Errorf("expected protocols %v, got %v", expectProtocols, info.Protocols)
+	}
+	if !reflect.DeepEqual(info.Features, []string{model.FeatureBlockedStatus}) {
+		t.Errorf("expected only %s feature, got %v", model.FeatureBlockedStatus, info.Features)
+	}
+
+	if err = s.Shutdown(ctx); err != nil {
+		t.Error("shutdown error:", err)
+	}
+	if err = <-errChan; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -6,9 +6,12 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"time"
 
 	indexer "github.com/filecoin-project/go-indexer-core"
+	"github.com/filecoin-project/storetheindex/api/v0/finder/model"
 	"github.com/filecoin-project/storetheindex/internal/registry"
+	"github.com/filecoin-project/storetheindex/internal/version"
 	"github.com/filecoin-project/storetheindex/server/reframe"
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
@@ -47,6 +50,17 @@ func New(listen string, indexer indexer.Interface, registry *registry.Registry,
 
 	// Resource handler
 	h := newHandler(indexer, registry)
+	h.blockedStatus = cfg.blockedStatus
+	h.signResponses = cfg.signKey != nil
+	h.info = cfg.info
+	h.info.Version = version.String()
+	h.info.Protocols = append([]string{model.ProtocolHttpFinder, model.ProtocolReframe}, cfg.info.Protocols...)
+	if cfg.blockedStatus {
+		h.info.Features = append(h.info.Features, model.FeatureBlockedStatus)
+	}
+	if cfg.signKey != nil {
+		h.info.Features = append(h.info.Features, model.FeatureSignedResponses)
+	}
 
 	// Client routes
 	cidR := mux.NewRouter().StrictSlash(true)
@@ -56,6 +70,10 @@ func New(listen string, indexer indexer.Interface, registry *registry.Registry,
 	mhR := mux.NewRouter().StrictSlash(true)
 	mhR.HandleFunc("/multihash/{multihash}", h.find).Methods(http.MethodGet)
 	mhR.HandleFunc("/multihash", h.findBatch).Methods(http.MethodPost)
+	if cfg.findTimeout != 0 {
+		cidR.Use(timeoutMiddleware(cfg.findTimeout))
+		mhR.Use(timeoutMiddleware(cfg.findTimeout))
+	}
 	corMhR := handlers.CORS(handlers.AllowedOrigins([]string{"*"}))(mhR)
 
 	r := mux.NewRouter().StrictSlash(true)
@@ -67,14 +85,27 @@ func New(listen string, indexer indexer.Interface, registry *registry.Registry,
 
 	r.HandleFunc("/providers", h.listProviders).Methods(http.MethodGet)
 	r.HandleFunc("/providers/{providerid}", h.getProvider).Methods(http.MethodGet)
+	r.HandleFunc("/providers/{providerid}/contexts", h.getProviderContexts).Methods(http.MethodGet)
 
 	r.HandleFunc("/stats", h.getStats).Methods(http.MethodGet)
+	r.HandleFunc("/info", h.getInfo).Methods(http.MethodGet)
 
 	reframeHandler := reframe.NewReframeHTTPHandler(indexer, registry)
 	r.HandleFunc("/reframe", reframeHandler)
 
+	// Sign responses before they are compressed, so that the signature is of
+	// the uncompressed body.
+	var handler http.Handler = r
+	if cfg.signKey != nil {
+		handler, err = signHandler(cfg.signKey, handler)
+		if err != nil {
+			l.Close()
+			return nil, fmt.Errorf("cannot sign responses: %w", err)
+		}
+	}
+
 	server := &http.Server{
-		Handler:      r,
+		Handler:      gzipHandler(cfg.gzipMinSize, handler),
 		WriteTimeout: cfg.apiWriteTimeout,
 		ReadTimeout:  cfg.apiReadTimeout,
 	}
@@ -83,6 +114,18 @@ func New(listen string, indexer indexer.Interface, registry *registry.Registry,
 	return s, nil
 }
 
+// timeoutMiddleware bounds the context of each request by the timeout, so
+// that the request stops doing work once the timeout has passed.
+func timeoutMiddleware(timeout time.Duration) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
 func (s *Server) Start() error {
 	log.Infow("finder http server listening", "listen_addr", s.l.Addr())
 	return s.server.Serve(s.l)
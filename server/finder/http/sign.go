@@ -0,0 +1,77 @@
+package httpfinderserver
+
+import (
+	"bytes"
+	"encoding/base64"
+	"net/http"
+	"strconv"
+
+	"github.com/filecoin-project/storetheindex/api/v0/finder/model"
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// signHandler signs responses with the indexer's key, so that clients can
+// verify that a response came from the indexer. The signature and the
+// indexer's peer ID are set in the response headers; see
+// model.VerifyResponse. The response is held back until it is complete, since
+// the signature covers the whole body, so no response is sent unsigned. A
+// handler that flushes its response while writing it does not stream it.
+func signHandler(privKey crypto.PrivKey, next http.Handler) (http.Handler, error) {
+	signerID, err := peer.IDFromPrivateKey(privKey)
+	if err != nil {
+		return nil, err
+	}
+	signer := signerID.String()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw := &signingResponseWriter{
+			ResponseWriter: w,
+			status:         http.StatusOK,
+		}
+		next.ServeHTTP(sw, r)
+
+		body := sw.buf.Bytes()
+		sig, err := privKey.Sign(model.ResponseSigningData(r.URL.RequestURI(), body))
+		if err != nil {
+			log.Errorw("Cannot sign response", "err", err)
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		h := w.Header()
+		h.Set(model.SignatureHeader, base64.StdEncoding.EncodeToString(sig))
+		h.Set(model.SignerHeader, signer)
+		if len(body) != 0 {
+			h.Set("Content-Length", strconv.Itoa(len(body)))
+		}
+		w.WriteHeader(sw.status)
+		if len(body) != 0 {
+			if _, err = w.Write(body); err != nil {
+				log.Errorw("Cannot write response", "err", err)
+			}
+		}
+	}), nil
+}
+
+// signingResponseWriter holds back the response until it is complete.
+type signingResponseWriter struct {
+	http.ResponseWriter
+	status    int
+	buf       bytes.Buffer
+	gotStatus bool
+}
+
+func (w *signingResponseWriter) WriteHeader(status int) {
+	if w.gotStatus {
+		return
+	}
+	w.gotStatus = true
+	w.status = status
+}
+
+func (w *signingResponseWriter) Write(p []byte) (int, error) {
+	return w.buf.Write(p)
+}
+
+// Flush does nothing, since the response cannot be sent until it is complete
+// and signed.
+func (w *signingResponseWriter) Flush() {}
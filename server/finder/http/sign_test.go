@@ -0,0 +1,103 @@
+package httpfinderserver
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/filecoin-project/storetheindex/api/v0/finder/model"
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+func TestSignHandler(t *testing.T) {
+	privKey, _, err := crypto.GenerateEd25519Key(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	indexerID, err := peer.IDFromPrivateKey(privKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := bytes.Repeat([]byte("{\"Multihash\":\"abc\"}\n"), 200)
+
+	handler, err := signHandler(privKey, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/found":
+			_, _ = w.Write(body)
+		case "/notfound":
+			http.Error(w, "", http.StatusNotFound)
+		case "/stream":
+			_, _ = w.Write(body)
+			w.(http.Flusher).Flush()
+			_, _ = w.Write(body)
+		}
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Responses are signed before they are compressed.
+	handler = gzipHandler(1024, handler)
+
+	serve := func(target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := serve("/found?cascade=ipfs-dht")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+	if !bytes.Equal(rec.Body.Bytes(), body) {
+		t.Fatal("wrong response body")
+	}
+	if rec.Header().Get(model.SignerHeader) != indexerID.String() {
+		t.Fatal("wrong signer header")
+	}
+	sig := rec.Header().Get(model.SignatureHeader)
+	if err = model.VerifyResponse(indexerID, "/found?cascade=ipfs-dht", rec.Body.Bytes(), sig); err != nil {
+		t.Fatal(err)
+	}
+	// The signature does not verify for a different request, a different
+	// body, or a different indexer.
+	if err = model.VerifyResponse(indexerID, "/found", rec.Body.Bytes(), sig); err == nil {
+		t.Fatal("expected signature to not verify for different request")
+	}
+	if err = model.VerifyResponse(indexerID, "/found?cascade=ipfs-dht", body[1:], sig); err == nil {
+		t.Fatal("expected signature to not verify for different body")
+	}
+	_, otherPub, err := crypto.GenerateEd25519Key(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherID, err := peer.IDFromPublicKey(otherPub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = model.VerifyResponse(otherID, "/found?cascade=ipfs-dht", rec.Body.Bytes(), sig); err == nil {
+		t.Fatal("expected signature to not verify for different indexer")
+	}
+
+	// Error responses are signed too.
+	rec = serve("/notfound")
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected status %d, got %d", http.StatusNotFound, rec.Code)
+	}
+	err = model.VerifyResponse(indexerID, "/notfound", rec.Body.Bytes(), rec.Header().Get(model.SignatureHeader))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Flushed responses are held back and signed whole.
+	rec = serve("/stream")
+	if !bytes.Equal(rec.Body.Bytes(), append(body, body...)) {
+		t.Fatal("wrong flushed response body")
+	}
+	err = model.VerifyResponse(indexerID, "/stream", rec.Body.Bytes(), rec.Header().Get(model.SignatureHeader))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -108,11 +108,53 @@ func (h *IngestHandler) IndexContent(ctx context.Context, data []byte) error {
 		return v0.NewError(err, http.StatusInternalServerError)
 	}
 
+	// Count the multihash in the provider's context.
+	err = h.registry.UpdateProviderContext(ctx, ingReq.ProviderID, ingReq.ContextID, ingReq.Metadata, 1, cid.Undef)
+	if err != nil {
+		err = fmt.Errorf("cannot update provider context: %s", err)
+		return v0.NewError(err, http.StatusInternalServerError)
+	}
+
 	// TODO: update last update time for provider
 
 	return nil
 }
 
+// RemoveContent handles a RemoveContentRequest. The request must be signed by
+// the provider whose content is removed.
+//
+// Returning error is the same as return v0.NewError(err, http.StatusBadRequest)
+func (h *IngestHandler) RemoveContent(ctx context.Context, data []byte) error {
+	rmReq, err := model.ReadRemoveContentRequest(data)
+	if err != nil {
+		return fmt.Errorf("cannot read remove content request: %s", err)
+	}
+
+	if len(rmReq.ContextID) > schema.MaxContextIDLen {
+		return errors.New("context id too long")
+	}
+
+	if err = h.registry.CheckSequence(rmReq.ProviderID, rmReq.Seq); err != nil {
+		return err
+	}
+
+	if !h.registry.IsRegistered(rmReq.ProviderID) {
+		return v0.NewError(errors.New("provider not registered"), http.StatusNotFound)
+	}
+
+	value := indexer.Value{
+		ProviderID: rmReq.ProviderID,
+		ContextID:  rmReq.ContextID,
+	}
+	err = h.ingester.RemoveContent(ctx, value, rmReq.Multihash)
+	if err != nil {
+		err = fmt.Errorf("cannot remove content: %s", err)
+		return v0.NewError(err, http.StatusInternalServerError)
+	}
+
+	return nil
+}
+
 func (h *IngestHandler) Announce(r io.Reader) error {
 	// Decode CID and originator addresses from message.
 	an := dtsync.Message{}
@@ -155,3 +197,15 @@ func (h *IngestHandler) Announce(r io.Reader) error {
 	// want to attach the context to the request context that started this.
 	return h.ingester.Announce(context.Background(), an.Cid, addrInfo)
 }
+
+// AdStatus returns the ingestion status of the advertisement, so that a
+// publisher can check whether an advertisement it published has been
+// ingested.
+func (h *IngestHandler) AdStatus(ctx context.Context, adCid cid.Cid) (model.AdStatus, error) {
+	status, err := h.ingester.AdStatus(ctx, adCid)
+	if err != nil {
+		err = fmt.Errorf("cannot get advertisement status: %s", err)
+		return status, v0.NewError(err, http.StatusInternalServerError)
+	}
+	return status, nil
+}
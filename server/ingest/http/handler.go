@@ -1,18 +1,37 @@
 package httpingestserver
 
 import (
+	"encoding/json"
 	"io"
 	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
 
 	indexer "github.com/filecoin-project/go-indexer-core"
 	"github.com/filecoin-project/storetheindex/internal/httpserver"
 	"github.com/filecoin-project/storetheindex/internal/ingest"
 	"github.com/filecoin-project/storetheindex/internal/registry"
 	"github.com/filecoin-project/storetheindex/server/ingest/handler"
+	"github.com/gorilla/mux"
+	"github.com/ipfs/go-cid"
 )
 
+// queueDepthHeader is the response header that reports the number of ingest
+// requests in flight, including the current request.
+const queueDepthHeader = "X-Ingest-Queue-Depth"
+
 type httpHandler struct {
 	ingestHandler *handler.IngestHandler
+
+	// inFlight is the number of ingest requests currently being handled.
+	inFlight int64
+	// maxInFlight is the number of in-flight ingest requests above which new
+	// requests are rejected. A value of 0 means no limit.
+	maxInFlight int64
+	// retryAfter is the time that a rejected client is told to wait before
+	// retrying.
+	retryAfter time.Duration
 }
 
 func newHandler(indexer indexer.Interface, ingester *ingest.Ingester, registry *registry.Registry) *httpHandler {
@@ -21,6 +40,26 @@ func newHandler(indexer indexer.Interface, ingester *ingest.Ingester, registry *
 	}
 }
 
+// admit wraps an ingest handler function so that a request is rejected with
+// 429 (Too Many Requests) when there are already too many ingest requests in
+// flight. Rejected requests are not queued; the client is told when to retry.
+func (h *httpHandler) admit(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		depth := atomic.AddInt64(&h.inFlight, 1)
+		defer atomic.AddInt64(&h.inFlight, -1)
+
+		w.Header().Set(queueDepthHeader, strconv.FormatInt(depth, 10))
+		if h.maxInFlight > 0 && depth > h.maxInFlight {
+			retrySecs := int64((h.retryAfter + time.Second - 1) / time.Second)
+			w.Header().Set("Retry-After", strconv.FormatInt(retrySecs, 10))
+			log.Warnw("Too many ingest requests in flight, rejecting request", "inFlight", depth-1, "path", r.URL.Path)
+			http.Error(w, "", http.StatusTooManyRequests)
+			return
+		}
+		next(w, r)
+	}
+}
+
 // POST /discover
 func (h *httpHandler) discoverProvider(w http.ResponseWriter, r *http.Request) {
 	body, err := io.ReadAll(r.Body)
@@ -96,6 +135,24 @@ func (h *httpHandler) removeProvider(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusNotImplemented)
 }
 
+// DELETE /ingest/content
+func (h *httpHandler) removeContent(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Errorw("failed reading body", "err", err)
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	err = h.ingestHandler.RemoveContent(r.Context(), body)
+	if err != nil {
+		httpserver.HandleError(w, err, "remove content")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
 // ----- ingest handlers -----
 // PUT /ingest/announce
 func (h *httpHandler) announce(w http.ResponseWriter, r *http.Request) {
@@ -109,3 +166,32 @@ func (h *httpHandler) announce(w http.ResponseWriter, r *http.Request) {
 	}
 	w.WriteHeader(http.StatusNoContent)
 }
+
+// GET /ingest/ad/{cid}/status
+func (h *httpHandler) adStatus(w http.ResponseWriter, r *http.Request) {
+	cidStr := mux.Vars(r)["cid"]
+	adCid, err := cid.Decode(cidStr)
+	if err != nil {
+		log.Errorw("Cannot decode advertisement cid", "cid", cidStr, "err", err)
+		http.Error(w, "cannot decode advertisement cid", http.StatusBadRequest)
+		return
+	}
+
+	status, err := h.ingestHandler.AdStatus(r.Context(), adCid)
+	if err != nil {
+		httpserver.HandleError(w, err, "ad status")
+		return
+	}
+
+	data, err := json.Marshal(status)
+	if err != nil {
+		log.Errorw("Cannot marshal advertisement status", "err", err)
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(data)
+	if err != nil {
+		log.Errorw("Cannot write advertisement status response", "err", err)
+	}
+}
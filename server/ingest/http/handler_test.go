@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/filecoin-project/go-indexer-core"
 	"github.com/filecoin-project/storetheindex/api/v0/ingest/model"
@@ -125,3 +126,45 @@ func TestRegisterProvider(t *testing.T) {
 		t.Fatal("provider was not registered")
 	}
 }
+
+func TestTooManyRequests(t *testing.T) {
+	h := &httpHandler{
+		maxInFlight: 1,
+		retryAfter:  1500 * time.Millisecond,
+	}
+
+	var nested *http.Response
+	admitted := h.admit(func(w http.ResponseWriter, r *http.Request) {
+		// While this request is in flight, another request must be rejected.
+		rec := httptest.NewRecorder()
+		h.admit(func(w http.ResponseWriter, r *http.Request) {
+			t.Error("request should not have been admitted")
+		})(rec, httptest.NewRequest(http.MethodPut, "http://example.com/ingest/announce", nil))
+		nested = rec.Result()
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	w := httptest.NewRecorder()
+	admitted(w, httptest.NewRequest(http.MethodPut, "http://example.com/ingest/announce", nil))
+
+	resp := w.Result()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatal("expected response to be", http.StatusNoContent)
+	}
+	if resp.Header.Get(queueDepthHeader) != "1" {
+		t.Error("wrong queue depth header:", resp.Header.Get(queueDepthHeader))
+	}
+
+	if nested.StatusCode != http.StatusTooManyRequests {
+		t.Fatal("expected response to be", http.StatusTooManyRequests)
+	}
+	if nested.Header.Get("Retry-After") != "2" {
+		t.Error("wrong Retry-After header:", nested.Header.Get("Retry-After"))
+	}
+	if nested.Header.Get(queueDepthHeader) != "2" {
+		t.Error("wrong queue depth header:", nested.Header.Get(queueDepthHeader))
+	}
+	if h.inFlight != 0 {
+		t.Error("in-flight count not restored")
+	}
+}
@@ -8,12 +8,15 @@ import (
 const (
 	apiWriteTimeout = 30 * time.Second
 	apiReadTimeout  = 30 * time.Second
+	retryAfter      = 5 * time.Second
 )
 
 // Options is a structure containing all the options that can be used when constructing an http server
 type serverConfig struct {
 	apiWriteTimeout time.Duration
 	apiReadTimeout  time.Duration
+	maxInFlight     int
+	retryAfter      time.Duration
 }
 
 // ServerOption for httpserver
@@ -24,6 +27,7 @@ type ServerOption func(*serverConfig) error
 var serverDefaults = func(o *serverConfig) error {
 	o.apiWriteTimeout = apiWriteTimeout
 	o.apiReadTimeout = apiReadTimeout
+	o.retryAfter = retryAfter
 	return nil
 }
 
@@ -52,3 +56,25 @@ func ReadTimeout(t time.Duration) ServerOption {
 		return nil
 	}
 }
+
+// MaxInFlight sets the number of ingest requests that may be handled
+// concurrently. Requests beyond this are rejected with 429 (Too Many Requests).
+// A value less than 1 means no limit.
+func MaxInFlight(n int) ServerOption {
+	return func(c *serverConfig) error {
+		if n < 0 {
+			n = 0
+		}
+		c.maxInFlight = n
+		return nil
+	}
+}
+
+// RetryAfter sets the time that a client rejected for too many in-flight
+// requests is told to wait before retrying.
+func RetryAfter(t time.Duration) ServerOption {
+	return func(c *serverConfig) error {
+		c.retryAfter = t
+		return nil
+	}
+}
@@ -59,6 +59,8 @@ func TestRegisterProvider(t *testing.T) {
 
 	test.RegisterProviderTest(t, httpClient, peerID, privKey, "/ip4/127.0.0.1/tcp/9999", reg)
 
+	test.RemoveContentTest(t, httpClient, peerID, privKey, ind)
+
 	//test.IndexContent(t, httpClient, peerID, privKey, ind)
 
 	//test.IndexContentNewAddr(t, httpClient, peerID, privKey, ind, "/ip4/127.0.0.1/tcp/7777", reg)
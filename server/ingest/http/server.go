@@ -45,15 +45,19 @@ func New(listen string, indexer indexer.Interface, ingester *ingest.Ingester, re
 	s := &Server{server, l}
 
 	h := newHandler(indexer, ingester, registry)
+	h.maxInFlight = int64(cfg.maxInFlight)
+	h.retryAfter = cfg.retryAfter
 
 	// Advertisement routes
-	r.HandleFunc("/ingest/announce", h.announce).Methods(http.MethodPut)
+	r.HandleFunc("/ingest/announce", h.admit(h.announce)).Methods(http.MethodPut)
+	r.HandleFunc("/ingest/content", h.admit(h.removeContent)).Methods(http.MethodDelete)
+	r.HandleFunc("/ingest/ad/{cid}/status", h.admit(h.adStatus)).Methods(http.MethodGet)
 
 	// Discovery
-	r.HandleFunc("/discover", h.discoverProvider).Methods(http.MethodPost)
+	r.HandleFunc("/discover", h.admit(h.discoverProvider)).Methods(http.MethodPost)
 
 	// Registration routes
-	r.HandleFunc("/register", h.registerProvider).Methods(http.MethodPost)
+	r.HandleFunc("/register", h.admit(h.registerProvider)).Methods(http.MethodPost)
 	r.HandleFunc("/register/{providerid}", h.removeProvider).Methods(http.MethodDelete)
 	return s, nil
 }
@@ -60,6 +60,9 @@ func (h *libp2pHandler) HandleMessage(ctx context.Context, msgPeer peer.ID, msgb
 	case pb.IngestMessage_INDEX_CONTENT:
 		handle = h.indexContent
 		rspType = pb.IngestMessage_INDEX_CONTENT_RESPONSE
+	case pb.IngestMessage_REMOVE_CONTENT:
+		handle = h.removeContent
+		rspType = pb.IngestMessage_REMOVE_CONTENT_RESPONSE
 	default:
 		msg := "ussupported message type"
 		log.Errorw(msg, "type", req.GetType())
@@ -97,3 +100,8 @@ func (h *libp2pHandler) indexContent(ctx context.Context, p peer.ID, msg *pb.Ing
 	err := h.ingestHandler.IndexContent(ctx, msg.GetData())
 	return nil, err
 }
+
+func (h *libp2pHandler) removeContent(ctx context.Context, p peer.ID, msg *pb.IngestMessage) ([]byte, error) {
+	err := h.ingestHandler.RemoveContent(ctx, msg.GetData())
+	return nil, err
+}
@@ -61,6 +61,8 @@ func TestRegisterProvider(t *testing.T) {
 
 	test.RegisterProviderTest(t, p2pClient, peerID, privKey, "/ip4/127.0.0.1/tcp/9999", reg)
 
+	test.RemoveContentTest(t, p2pClient, peerID, privKey, ind)
+
 	test.IndexContent(t, p2pClient, peerID, privKey, ind)
 
 	test.IndexContentNewAddr(t, p2pClient, peerID, privKey, ind, "/ip4/127.0.0.1/tcp/7777", reg)
@@ -217,6 +217,52 @@ func IndexContentFail(t *testing.T, cl client.Ingest, providerID peer.ID, privat
 	}
 }
 
+func RemoveContentTest(t *testing.T, cl client.Ingest, providerID peer.ID, privateKey crypto.PrivKey, ind indexer.Interface) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mhs := util.RandomMultihashes(1, rng)
+
+	value := indexer.Value{
+		ProviderID:    providerID,
+		ContextID:     []byte("test-context-id"),
+		MetadataBytes: []byte("test-metadata"),
+	}
+	err := ind.Put(value, mhs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Request signed by a key other than the provider's must fail.
+	otherKey, _, err := crypto.GenerateEd25519Key(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cl.RemoveContent(ctx, providerID, otherKey, mhs[0], value.ContextID)
+	if err == nil {
+		t.Fatal("expected bad signature error")
+	}
+	_, ok, err := ind.Get(mhs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Fatal("content removed by request not signed by provider")
+	}
+
+	err = cl.RemoveContent(ctx, providerID, privateKey, mhs[0], value.ContextID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, ok, err = ind.Get(mhs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Fatal("content was not removed")
+	}
+}
+
 func AnnounceTest(t *testing.T, peerID peer.ID, cl client.Ingest) {
 	ai, err := peer.AddrInfoFromString(fmt.Sprintf("/ip4/127.0.0.1/tcp/9999/p2p/%s", peerID))
 	if err != nil {